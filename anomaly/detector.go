@@ -0,0 +1,201 @@
+package anomaly
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"ripple/db"
+)
+
+const (
+	// Bucket is the duration of the time buckets the latest value and the baseline values are computed over
+	Bucket = 15 * time.Minute
+	// MinBaseline is the shortest baseline, enough buckets for their standard deviation to be meaningful
+	MinBaseline = 2 * time.Hour
+
+	defaultInterval = time.Minute
+)
+
+// Anomaly metrics
+const (
+	MetricLatency   = "latency"
+	MetricCost      = "cost"
+	MetricErrorRate = "error_rate"
+)
+
+// statMetrics maps the keys of the dashboard statistics to the metric whose anomaly they are flagged with
+var statMetrics = map[string]string{
+	"avg_response_time": MetricLatency,
+	"cost_today":        MetricCost,
+	"runs_today":        MetricErrorRate,
+}
+
+// Source returns the UI stores to detect anomalies in, by the key requests are matched with, see Key
+type Source func(ctx context.Context) (map[string]db.UIStore, error)
+
+// StoreSource detects anomalies in a single store
+func StoreSource(store db.UIStore) Source {
+	return func(context.Context) (map[string]db.UIStore, error) {
+		return map[string]db.UIStore{"": store}, nil
+	}
+}
+
+// TenantSource detects anomalies in the base database, or in the database of every tenant with a tenant router
+func TenantSource(base *db.MongoDB, tenants *db.TenantRouter) Source {
+	return func(ctx context.Context) (map[string]db.UIStore, error) {
+		databases, err := db.TenantDatabases(ctx, base, tenants)
+		if err != nil {
+			return nil, err
+		}
+
+		stores := make(map[string]db.UIStore, len(databases))
+		for tenant, database := range databases {
+			if tenant != "" {
+				tenant = database.Key()
+			}
+			stores[tenant] = db.NewUIRepository(database)
+		}
+		return stores, nil
+	}
+}
+
+// Key returns the key of the store a request reads from: the name of the tenant database and organization, or ""
+// for the default store
+func Key(ctx context.Context) string {
+	if database := db.DatabaseFromContext(ctx); database != nil {
+		return database.Key()
+	}
+	return ""
+}
+
+// Detector periodically compares the latency, cost and error rate of the runs of the latest time bucket against
+// a rolling baseline of the preceding buckets, and keeps the anomalies found for the dashboard
+type Detector struct {
+	source    Source
+	baseline  time.Duration
+	threshold float64
+	interval  time.Duration
+
+	mu        sync.RWMutex
+	anomalies map[string]map[string]*db.Anomaly
+}
+
+// NewDetector creates a new detector flagging values more than threshold standard deviations above the mean of
+// the buckets of the baseline duration
+func NewDetector(source Source, baseline time.Duration, threshold float64) *Detector {
+	return &Detector{
+		source:    source,
+		baseline:  baseline,
+		threshold: threshold,
+		interval:  defaultInterval,
+		anomalies: map[string]map[string]*db.Anomaly{},
+	}
+}
+
+// Run detects anomalies until the context is cancelled
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		stores, err := d.source(ctx)
+		if err != nil {
+			log.Printf("Unable to list the stores to detect anomalies in: %v", err)
+		}
+
+		now := time.Now()
+		found := make(map[string]map[string]*db.Anomaly, len(stores))
+		for key, store := range stores {
+			anomalies, err := d.Detect(ctx, store, now)
+			if err != nil {
+				log.Printf("Unable to detect anomalies of %q: %v", key, err)
+				continue
+			}
+			found[key] = anomalies
+		}
+
+		d.mu.Lock()
+		d.anomalies = found
+		d.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Detect compares the metrics of the latest complete bucket before now against the buckets of the baseline
+// preceding it and returns the anomalies found, by metric
+func (d *Detector) Detect(ctx context.Context, store db.UIStore, now time.Time) (map[string]*db.Anomaly, error) {
+	to := now.UTC().Truncate(Bucket)
+	from := to.Add(-Bucket)
+	baselineFrom := from.Add(-d.baseline)
+
+	buckets, err := store.GetTimeBuckets(ctx, Bucket, baselineFrom, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byStart := make(map[int64]db.TimeBucket, len(buckets))
+	for _, b := range buckets {
+		byStart[b.Start.UnixNano()] = b
+	}
+
+	// Buckets without runs cost nothing, but have no latency or error rate
+	var costs, latencies, errorRates []float64
+	for start := baselineFrom; start.Before(from); start = start.Add(Bucket) {
+		b := byStart[start.UnixNano()]
+		costs = append(costs, b.Cost)
+		if b.Finished > 0 {
+			latencies = append(latencies, latency(b))
+			errorRates = append(errorRates, errorRate(b))
+		}
+	}
+
+	latest := byStart[from.UnixNano()]
+	candidates := []*db.Anomaly{db.DetectAnomaly(MetricCost, latest.Cost, costs, d.threshold)}
+	if latest.Finished > 0 {
+		candidates = append(candidates,
+			db.DetectAnomaly(MetricLatency, latency(latest), latencies, d.threshold),
+			db.DetectAnomaly(MetricErrorRate, errorRate(latest), errorRates, d.threshold),
+		)
+	}
+
+	anomalies := map[string]*db.Anomaly{}
+	for _, anomaly := range candidates {
+		if anomaly == nil {
+			continue
+		}
+		anomaly.From, anomaly.To = from, to
+		anomalies[anomaly.Metric] = anomaly
+	}
+	return anomalies, nil
+}
+
+// Annotate sets the anomalies last detected in the store with the given key on the dashboard statistics they
+// concern
+func (d *Detector) Annotate(key string, stats []db.StatsData) {
+	d.mu.RLock()
+	anomalies := d.anomalies[key]
+	d.mu.RUnlock()
+
+	for i := range stats {
+		if metric, ok := statMetrics[stats[i].Key]; ok {
+			stats[i].Anomaly = anomalies[metric]
+		}
+	}
+}
+
+// latency returns the average time taken by the finished runs of a bucket
+func latency(b db.TimeBucket) float64 {
+	return b.TimeTaken / float64(b.Finished)
+}
+
+// errorRate returns the percentage of the finished runs of a bucket that did not complete
+func errorRate(b db.TimeBucket) float64 {
+	return float64(b.Finished-b.Completed) / float64(b.Finished) * 100
+}
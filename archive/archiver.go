@@ -0,0 +1,297 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	// Interval is how often the runs past the archival age are moved to the object store
+	Interval = time.Hour
+	// MinAge is the shortest age after which runs may be archived
+	MinAge = 24 * time.Hour
+	// defaultMaxRunsPerFile bounds the runs held in memory and written to a single archive file
+	defaultMaxRunsPerFile = 50000
+	// restoreBatchSize is the number of runs inserted per batch by a restore job
+	restoreBatchSize = 1000
+	// jobTimeout bounds the duration of an archival pass or of a restore job, after which a restore job is
+	// considered abandoned and claimed again
+	jobTimeout = time.Hour
+)
+
+// Store stores the archive files
+type Store interface {
+	// Put stores a file under the given name, relative to the store's prefix, and returns its object key
+	Put(ctx context.Context, name string, body []byte, contentType string) (string, error)
+	// Get downloads the file stored under an object key, as returned by Put
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Archiver moves the runs created longer ago than an age to gzip compressed files of newline delimited MongoDB
+// Extended JSON in an object store, deleting them from the database, and runs the pending restore jobs loading the
+// archived runs of a time range back. Archive files are named by the creation day of their first run:
+// [tenant=<tenant>/]agent_runs/date=<yyyy-mm-dd>/<first run id>.ndjson.gz
+type Archiver struct {
+	base           *db.MongoDB
+	tenants        *db.TenantRouter
+	store          Store
+	age            time.Duration
+	interval       time.Duration
+	pollInterval   time.Duration
+	maxRunsPerFile int64
+}
+
+// NewArchiver creates a new archiver of the runs older than age. With a tenant router, the runs of every tenant
+// database are archived.
+func NewArchiver(base *db.MongoDB, tenants *db.TenantRouter, store Store, age time.Duration) *Archiver {
+	return &Archiver{
+		base:           base,
+		tenants:        tenants,
+		store:          store,
+		age:            age,
+		interval:       Interval,
+		pollInterval:   defaultPollInterval,
+		maxRunsPerFile: defaultMaxRunsPerFile,
+	}
+}
+
+// Run archives the runs past the archival age every interval, and polls for pending restore jobs, until the context
+// is cancelled
+func (a *Archiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.pollInterval)
+	defer ticker.Stop()
+
+	var archivedAt time.Time
+	for {
+		databases, err := db.TenantDatabases(ctx, a.base, a.tenants)
+		if err != nil {
+			log.Printf("Unable to list tenant databases for archival: %v", err)
+		}
+
+		archive := time.Since(archivedAt) >= a.interval
+		for tenant, database := range databases {
+			repo := db.NewArchiveRepository(database)
+			a.runPending(ctx, tenant, repo)
+			if archive {
+				a.archive(ctx, tenant, repo)
+			}
+		}
+		if archive {
+			archivedAt = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archive moves the runs of a database past the archival age to the object store, a file at a time, and deletes
+// the restored runs past the time they are kept until
+func (a *Archiver) archive(ctx context.Context, tenant string, repo *db.ArchiveRepository) {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	// Archival works without the indexes, only slower
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		log.Printf("Unable to create the archive indexes of tenant %q: %v", tenant, err)
+	}
+
+	now := time.Now()
+	if deleted, err := repo.DeleteExpiredRestoredRuns(ctx, now); err != nil {
+		log.Printf("Unable to delete the expired restored runs of tenant %q: %v", tenant, err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d restored runs of tenant %q", deleted, tenant)
+	}
+
+	before := now.Add(-a.age)
+	for ctx.Err() == nil {
+		runs, err := repo.ArchivableRuns(ctx, before, a.maxRunsPerFile)
+		if err != nil {
+			log.Printf("Unable to read the runs to archive of tenant %q: %v", tenant, err)
+			return
+		}
+		if len(runs) == 0 {
+			return
+		}
+
+		archived, err := a.archiveRuns(ctx, tenant, repo, runs)
+		if err != nil {
+			log.Printf("Unable to archive the runs of tenant %q: %v", tenant, err)
+			return
+		}
+		log.Printf("Archived %d runs of tenant %q to %s", archived.Runs, tenant, archived.Key)
+		if int64(len(runs)) < a.maxRunsPerFile {
+			return
+		}
+	}
+}
+
+// archiveRuns writes runs to an archive file, records it, then deletes the runs. Runs archived by a pass stopped
+// before deleting them are archived again by the next one, and restored once.
+func (a *Archiver) archiveRuns(ctx context.Context, tenant string, repo *db.ArchiveRepository, runs []bson.Raw) (*models.RunArchive, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	ids := make([]interface{}, 0, len(runs))
+	archived := &models.RunArchive{Runs: int64(len(runs))}
+	for i, run := range runs {
+		var header struct {
+			ID      interface{} `bson:"_id"`
+			Created time.Time   `bson:"created"`
+		}
+		if err := bson.Unmarshal(run, &header); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			archived.From = header.Created
+		}
+		archived.To = header.Created
+		ids = append(ids, header.ID)
+
+		line, err := bson.MarshalExtJSON(run, true, false)
+		if err != nil {
+			return nil, err
+		}
+		zw.Write(line)
+		zw.Write([]byte("\n"))
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("agent_runs/date=%s/%v.ndjson.gz", archived.From.UTC().Format("2006-01-02"), idString(ids[0]))
+	if tenant != "" {
+		name = "tenant=" + tenant + "/" + name
+	}
+	key, err := a.store.Put(ctx, name, buf.Bytes(), "application/gzip")
+	if err != nil {
+		return nil, err
+	}
+	archived.Key = key
+	archived.Bytes = int64(buf.Len())
+	if err := repo.AddArchive(ctx, archived); err != nil {
+		return nil, err
+	}
+
+	if _, err := repo.DeleteArchivedRuns(ctx, ids); err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// runPending runs the pending restore jobs of a database one after the other
+func (a *Archiver) runPending(ctx context.Context, tenant string, repo *db.ArchiveRepository) {
+	for ctx.Err() == nil {
+		job, err := repo.ClaimRestoreJob(ctx, jobTimeout)
+		if err != nil {
+			log.Printf("Unable to claim restore job for tenant %q: %v", tenant, err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		log.Printf("Running restore job %s of runs from %s to %s", job.ID.Hex(), job.From.Format(time.RFC3339), job.To.Format(time.RFC3339))
+		jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+		jobErr := a.restore(jobCtx, repo, job)
+		cancel()
+		if jobErr != nil {
+			log.Printf("Restore job %s failed: %v", job.ID.Hex(), jobErr)
+		}
+		if err := repo.FinishRestoreJob(ctx, job.ID, jobErr); err != nil {
+			log.Printf("Unable to record the end of restore job %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
+
+// restore inserts the archived runs created in the range of a job, reading every archive file overlapping it
+func (a *Archiver) restore(ctx context.Context, repo *db.ArchiveRepository, job *models.RestoreJob) error {
+	archives, err := repo.ListArchives(ctx, job.From, job.To)
+	if err != nil {
+		return err
+	}
+
+	for _, archived := range archives {
+		data, err := a.store.Get(ctx, archived.Key)
+		if err != nil {
+			return err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("invalid archive %s: %w", archived.Key, err)
+		}
+
+		var restored int64
+		batch := []bson.D{}
+		flush := func() error {
+			n, err := repo.RestoreRuns(ctx, batch, job.KeepUntil)
+			restored += n
+			batch = batch[:0]
+			return err
+		}
+
+		scanner := bufio.NewScanner(zr)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var run bson.D
+			if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &run); err != nil {
+				return fmt.Errorf("invalid run in archive %s: %w", archived.Key, err)
+			}
+			if !inRange(run, job.From, job.To) {
+				continue
+			}
+
+			batch = append(batch, run)
+			if len(batch) >= restoreBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("unable to read archive %s: %w", archived.Key, err)
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+
+		if err := repo.AddRestoredArchive(ctx, job.ID, restored); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inRange reports whether an archived run was created in [from, to)
+func inRange(run bson.D, from, to time.Time) bool {
+	for _, e := range run {
+		if created, ok := e.Value.(primitive.DateTime); ok && e.Key == "created" {
+			t := created.Time()
+			return !t.Before(from) && t.Before(to)
+		}
+	}
+	return false
+}
+
+// idString formats the ID of a run for an archive file name
+func idString(id interface{}) string {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(id)
+}
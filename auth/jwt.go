@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles granted by the roles claim of a JWT. Admins have the permissions of editors, and editors those of viewers.
+// The ingest role only writes runs, which no other role can. The versions and deployments roles only register agent
+// versions and post deployment events, which editors can too.
+const (
+	RoleAdmin       = "admin"
+	RoleEditor      = "editor"
+	RoleViewer      = "viewer"
+	RoleIngest      = "ingest"
+	RoleVersions    = "versions"
+	RoleDeployments = "deployments"
+)
+
+// impliedRoles are the roles granted along with a role
+var impliedRoles = map[string][]string{
+	RoleAdmin:  {RoleEditor, RoleViewer, RoleVersions, RoleDeployments},
+	RoleEditor: {RoleViewer, RoleVersions, RoleDeployments},
+}
+
+var (
+	hmacMethods      = []string{"HS256", "HS384", "HS512"}
+	publicKeyMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// Claims are the claims of a verified JWT
+type Claims struct {
+	Roles []string `json:"roles"`
+	Email string   `json:"email,omitempty"`
+	// OrgID is the hex ID of the organization of the caller, required in org tenant mode
+	OrgID string `json:"org_id,omitempty"`
+	// Tenant is the tenant of the caller, required in database tenant mode
+	Tenant string `json:"tenant,omitempty"`
+	// Project restricts the caller to the agents of a project and their data, when set
+	Project string `json:"project,omitempty"`
+	// Bootstrap is only set for the bootstrap API key, never from the claims of a token or session
+	Bootstrap bool `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// Grants reports whether the claims grant a role, directly or through a role implying it
+func (c *Claims) Grants(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+		for _, implied := range impliedRoles[r] {
+			if implied == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verifier verifies the signature, expiry, issuer and audience of JWTs
+type Verifier struct {
+	key    any
+	parser *jwt.Parser
+}
+
+// NewVerifier creates a verifier of JWTs signed with either a shared secret (HS256, HS384, HS512) or the key pair
+// of a PEM encoded RSA, ECDSA or Ed25519 public key. The issuer and audience are checked when set. Tokens must
+// expire.
+func NewVerifier(secret string, publicKeyPEM []byte, issuer, audience string) (*Verifier, error) {
+	if (secret == "") == (len(publicKeyPEM) == 0) {
+		return nil, errors.New("either a secret or a public key is required")
+	}
+
+	var key any
+	methods := hmacMethods
+	if secret != "" {
+		key = []byte(secret)
+	} else {
+		var err error
+		if key, err = parsePublicKey(publicKeyPEM); err != nil {
+			return nil, err
+		}
+		methods = publicKeyMethods
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	return &Verifier{key: key, parser: jwt.NewParser(opts...)}, nil
+}
+
+// Verify returns the claims of a valid token
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return v.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// parsePublicKey parses a PEM encoded RSA, ECDSA or Ed25519 public key
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("invalid public key, expected a PEM encoded RSA, ECDSA or Ed25519 public key")
+}
+
+type claimsContextKey struct{}
+
+// WithClaims returns a context carrying the claims of the request's JWT
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of the request's JWT, nil when the request was not authenticated with one
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// SessionRoles are the roles IdP groups can be mapped to. Browser sessions cannot write runs.
+var SessionRoles = []string{RoleAdmin, RoleEditor, RoleViewer}
+
+// OIDCConfig configures the OpenID Connect provider browser sessions sign in with
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL of the callback route, as registered with the provider
+	RedirectURL string
+	// GroupsClaim is the ID token claim listing the groups of the user
+	GroupsClaim string
+	// GroupRoles maps IdP groups to roles
+	GroupRoles map[string]string
+}
+
+// OIDC signs users in with the authorization code flow of an OpenID Connect provider
+type OIDC struct {
+	oauth       oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	groupsClaim string
+	groupRoles  map[string]string
+}
+
+// NewOIDC discovers the endpoints and keys of the provider of the configured issuer
+func NewOIDC(ctx context.Context, config OIDCConfig) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDC{
+		oauth: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		groupsClaim: config.GroupsClaim,
+		groupRoles:  config.GroupRoles,
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the provider's sign in page, which redirects back to the callback route with the
+// state
+func (o *OIDC) AuthCodeURL(state, nonce string) string {
+	return o.oauth.AuthCodeURL(state, oidc.Nonce(nonce))
+}
+
+// Exchange redeems the authorization code of a callback and returns the claims of the signed in user, with the roles
+// mapped from their groups
+func (o *OIDC) Exchange(ctx context.Context, code, nonce string) (*Claims, error) {
+	token, err := o.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("the provider returned no ID token")
+	}
+
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid ID token nonce")
+	}
+
+	var raw map[string]any
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, err
+	}
+	email, _ := raw["email"].(string)
+	orgID, _ := raw["org_id"].(string)
+	tenant, _ := raw["tenant"].(string)
+
+	return &Claims{
+		Roles:            o.roles(claimStrings(raw[o.groupsClaim])),
+		Email:            email,
+		OrgID:            orgID,
+		Tenant:           tenant,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: idToken.Subject},
+	}, nil
+}
+
+// roles returns the roles mapped from groups
+func (o *OIDC) roles(groups []string) []string {
+	var roles []string
+	seen := map[string]bool{}
+	for _, group := range groups {
+		if role, ok := o.groupRoles[group]; ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// claimStrings returns the strings of a claim holding a string or a list of strings
+func claimStrings(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// ParseGroupRoles parses a group to role mapping in the form "group=role,other=role"
+func ParseGroupRoles(s string) (map[string]string, error) {
+	groupRoles := map[string]string{}
+	if s == "" {
+		return groupRoles, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		group, role, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid group role %q, expected group=role", entry)
+		}
+		if !isSessionRole(role) {
+			return nil, fmt.Errorf("invalid role %q for group %s, expected one of %s", role, group, strings.Join(SessionRoles, ", "))
+		}
+		groupRoles[group] = role
+	}
+
+	return groupRoles, nil
+}
+
+// isSessionRole reports whether IdP groups can be mapped to a role
+func isSessionRole(role string) bool {
+	for _, r := range SessionRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
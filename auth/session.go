@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// SessionCookie is the cookie carrying the session of a browser signed in with OpenID Connect or a password
+	SessionCookie = "ripple_session"
+	// sessionIssuer is the issuer of session tokens, telling them apart from other JWTs signed with the same secret
+	sessionIssuer = "ripple-session"
+)
+
+// ErrSessionRevoked is returned for the sessions signed out before they expire
+var ErrSessionRevoked = errors.New("session signed out, sign in again")
+
+// Revocations records the sessions signed out before they expire, by ID
+type Revocations interface {
+	RevokeSession(ctx context.Context, id string, expiresAt time.Time) error
+	SessionRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// Sessions issues and verifies the tokens of browser sessions, JWTs signed with a secret of the server. Sessions
+// carry an ID, and those signed out are recorded in the revocations of the server until they expire, when it has
+// revocations. Without, sessions stay valid until they expire.
+type Sessions struct {
+	secret      []byte
+	ttl         time.Duration
+	parser      *jwt.Parser
+	revocations Revocations
+}
+
+// NewSessions creates sessions signed with secret and expiring after ttl
+func NewSessions(secret []byte, ttl time.Duration) *Sessions {
+	return &Sessions{
+		secret: secret,
+		ttl:    ttl,
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired(), jwt.WithIssuer(sessionIssuer)),
+	}
+}
+
+// SetRevocations sets where the sessions signed out are recorded
+func (s *Sessions) SetRevocations(revocations Revocations) {
+	s.revocations = revocations
+}
+
+// TTL returns how long sessions last
+func (s *Sessions) TTL() time.Duration {
+	return s.ttl
+}
+
+// Issue returns the token of a new session of a user
+func (s *Sessions) Issue(claims *Claims) (string, error) {
+	now := time.Now()
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	session := *claims
+	session.ID = hex.EncodeToString(id)
+	session.Issuer = sessionIssuer
+	session.IssuedAt = jwt.NewNumericDate(now)
+	session.ExpiresAt = jwt.NewNumericDate(now.Add(s.ttl))
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &session).SignedString(s.secret)
+}
+
+// Verify returns the claims of a valid session token, which was not signed out
+func (s *Sessions) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims, err := s.parse(token)
+	if err != nil || s.revocations == nil {
+		return claims, err
+	}
+	revoked, err := s.revocations.SessionRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+// Revoke signs the session of a token out, so that it is no longer valid even though it has not expired. Tokens
+// that are not valid sessions are ignored.
+func (s *Sessions) Revoke(ctx context.Context, token string) error {
+	claims, err := s.parse(token)
+	if err != nil || s.revocations == nil {
+		return nil
+	}
+	return s.revocations.RevokeSession(ctx, claims.ID, claims.ExpiresAt.Time)
+}
+
+// parse returns the claims of a session token whose signature is valid and which has not expired. Sessions issued
+// without an ID, which could not be signed out, are rejected.
+func (s *Sessions) parse(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := s.parser.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return s.secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, errors.New("session without an ID, sign in again")
+	}
+	return claims, nil
+}
@@ -0,0 +1,296 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"ripple/db"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// FormatVersion is the version of the archive format, checked on restore
+	FormatVersion = 1
+	manifestName  = "manifest.json"
+	// insertBatchSize is the number of documents inserted per batch on restore
+	insertBatchSize = 1000
+)
+
+// Collections are the collections backed up, in the order they are restored
+var Collections = []string{"agents", "agent_versions", "agent_runs", "agent_version_metrics"}
+
+// Manifest describes a backup archive. It is the first file of the archive, followed by a file of newline delimited
+// canonical MongoDB Extended JSON per collection, <collection>.ndjson.
+type Manifest struct {
+	Version  int       `json:"version"`
+	Database string    `json:"database"`
+	Created  time.Time `json:"created"`
+	// Snapshot reports whether the collections were read from a single snapshot of the database
+	Snapshot    bool                 `json:"snapshot"`
+	Collections []CollectionManifest `json:"collections"`
+}
+
+// CollectionManifest describes the file of a collection in a backup archive
+type CollectionManifest struct {
+	Name      string `json:"name"`
+	Documents int64  `json:"documents"`
+	SHA256    string `json:"sha256"`
+}
+
+// Write backs the collections of a database up to w as a gzip compressed tar archive. With snapshot, the
+// collections are read in a snapshot session, so that the archive holds the documents of a single point in time,
+// which needs a MongoDB 5.0 or later replica set or sharded cluster, and a backup shorter than the snapshot history
+// window of the cluster (5 minutes by default). Collections are dumped to temporary files first, as the manifest
+// leads the archive.
+func Write(ctx context.Context, database *db.MongoDB, w io.Writer, snapshot bool) (*Manifest, error) {
+	manifest := &Manifest{Version: FormatVersion, Database: database.Name(), Created: time.Now().UTC(), Snapshot: snapshot}
+	files := make([]*os.File, 0, len(Collections))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	dump := func(ctx context.Context) error {
+		for _, name := range Collections {
+			f, err := os.CreateTemp("", "ripple-backup-"+name+"-*.ndjson")
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+
+			collection, err := dumpCollection(ctx, database.Database().Collection(name), f)
+			if err != nil {
+				return fmt.Errorf("unable to back %s up: %w", name, err)
+			}
+			manifest.Collections = append(manifest.Collections, collection)
+		}
+		return nil
+	}
+
+	if snapshot {
+		session, err := database.Client().StartSession(options.Session().SetSnapshot(true))
+		if err != nil {
+			return nil, err
+		}
+		defer session.EndSession(ctx)
+		if err := mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error { return dump(sc) }); err != nil {
+			return nil, err
+		}
+	} else if err := dump(ctx); err != nil {
+		return nil, err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(tw, manifestName, manifest.Created, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := writeFile(tw, Collections[i]+".ndjson", manifest.Created, info.Size(), f); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// dumpCollection writes the documents of a collection to w, one canonical Extended JSON document per line, in the
+// order of their IDs
+func dumpCollection(ctx context.Context, collection *mongo.Collection, w io.Writer) (CollectionManifest, error) {
+	manifest := CollectionManifest{Name: collection.Name()}
+	digest := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(w, digest))
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(1000)
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return manifest, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return manifest, err
+		}
+		out.Write(line)
+		if err := out.WriteByte('\n'); err != nil {
+			return manifest, err
+		}
+		manifest.Documents++
+	}
+	if err := cursor.Err(); err != nil {
+		return manifest, err
+	}
+	if err := out.Flush(); err != nil {
+		return manifest, err
+	}
+
+	manifest.SHA256 = checksum(digest)
+	return manifest, nil
+}
+
+// writeFile writes a file of the given size to a tar archive
+func writeFile(tw *tar.Writer, name string, modTime time.Time, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// Restore restores a backup archive read from r into a database. The collections must be empty, unless drop is
+// set, which deletes their documents first, keeping the collections, so that their indexes and time-series options
+// are kept. Each file is checked against the count and checksum of the manifest once restored.
+func Restore(ctx context.Context, database *db.MongoDB, r io.Reader, drop bool) (*Manifest, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup archive: %w", err)
+	}
+	tr := tar.NewReader(zr)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, errors.New("invalid backup archive: missing manifest")
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	if manifest.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version %d, expected %d", manifest.Version, FormatVersion)
+	}
+	expected := make(map[string]CollectionManifest, len(manifest.Collections))
+	for _, c := range manifest.Collections {
+		expected[c.Name+".ndjson"] = c
+	}
+
+	// Check every collection before writing any, so that a restore into a database in use fails untouched
+	for _, c := range manifest.Collections {
+		if drop {
+			break
+		}
+		n, err := database.Database().Collection(c.Name).CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, fmt.Errorf("collection %s is not empty, restore with --drop to replace its documents", c.Name)
+		}
+	}
+
+	restored := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup archive: %w", err)
+		}
+		c, ok := expected[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("invalid backup archive: unexpected file %s", header.Name)
+		}
+
+		collection := database.Database().Collection(c.Name)
+		if drop {
+			if _, err := collection.DeleteMany(ctx, bson.D{}); err != nil {
+				return nil, fmt.Errorf("unable to clear %s: %w", c.Name, err)
+			}
+		}
+		if err := restoreCollection(ctx, collection, tr, c); err != nil {
+			return nil, fmt.Errorf("unable to restore %s: %w", c.Name, err)
+		}
+		restored++
+	}
+	if restored != len(manifest.Collections) {
+		return nil, fmt.Errorf("invalid backup archive: %d of %d collections found", restored, len(manifest.Collections))
+	}
+
+	return &manifest, nil
+}
+
+// restoreCollection inserts the documents of a collection file in batches, then checks their count and checksum
+func restoreCollection(ctx context.Context, collection *mongo.Collection, r io.Reader, expected CollectionManifest) error {
+	digest := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(r, digest))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var documents int64
+	batch := make([]interface{}, 0, insertBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := collection.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false))
+		batch = batch[:0]
+		return err
+	}
+	for scanner.Scan() {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return fmt.Errorf("invalid document %d: %w", documents+1, err)
+		}
+		batch = append(batch, doc)
+		documents++
+		if len(batch) == insertBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if documents != expected.Documents {
+		return fmt.Errorf("restored %d documents, the manifest lists %d", documents, expected.Documents)
+	}
+	if sum := checksum(digest); sum != expected.SHA256 {
+		return fmt.Errorf("checksum %s does not match the manifest checksum %s", sum, expected.SHA256)
+	}
+	return nil
+}
+
+// checksum returns the hex encoded digest of a hash
+func checksum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries is the default number of values an in-process cache holds
+const DefaultMaxEntries = 10000
+
+// Cache stores values for a limited time. Clear invalidates every value stored so far.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Clear(ctx context.Context) error
+}
+
+var (
+	_ Cache = (*Memory)(nil)
+	_ Cache = (*Redis)(nil)
+)
+
+// memoryEntry is a value stored in memory with its expiry
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// Memory is an in-process cache holding at most a fixed number of values
+type Memory struct {
+	mu         sync.Mutex
+	entries    map[string]memoryEntry
+	maxEntries int
+}
+
+// NewMemory creates an in-process cache holding at most maxEntries values
+func NewMemory(maxEntries int) *Memory {
+	return &Memory{
+		entries:    map[string]memoryEntry{},
+		maxEntries: maxEntries,
+	}
+}
+
+// Get returns the value stored for key, unless it expired
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+// Set stores a value for ttl. When the cache is full, expired values are dropped first, then arbitrary values.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		for k, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		for k := range m.entries {
+			if len(m.entries) < m.maxEntries {
+				break
+			}
+			delete(m.entries, k)
+		}
+	}
+
+	m.entries[key] = memoryEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+// Clear drops every value
+func (m *Memory) Clear(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = map[string]memoryEntry{}
+	return nil
+}
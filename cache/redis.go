@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix prefixes the keys of the values stored in Redis
+const redisKeyPrefix = "ripple:cache:"
+
+// Redis is a cache shared by the servers connected to the same Redis. Values are stored under the current
+// generation, so that Clear invalidates them by moving to the next generation and leaves them to expire.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis connects to the Redis server of a redis:// or rediss:// URL, such as redis://localhost:6379/0
+func NewRedis(url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &Redis{client: client}, nil
+}
+
+// Get returns the value stored for key in the current generation
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	generation, err := r.generation(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, err := r.client.Get(ctx, redisKeyPrefix+generation+":"+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores a value for ttl in the current generation
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	generation, err := r.generation(ctx)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, redisKeyPrefix+generation+":"+key, value, ttl).Err()
+}
+
+// Clear moves to the next generation
+func (r *Redis) Clear(ctx context.Context) error {
+	return r.client.Incr(ctx, redisKeyPrefix+"generation").Err()
+}
+
+// Close closes the connections to Redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
+
+// generation returns the current generation, "0" until the cache is first cleared
+func (r *Redis) generation(ctx context.Context) (string, error) {
+	generation, err := r.client.Get(ctx, redisKeyPrefix+"generation").Result()
+	if errors.Is(err, redis.Nil) {
+		return "0", nil
+	}
+	return generation, err
+}
@@ -0,0 +1,233 @@
+// Package clickhouse stores the runs of agents in ClickHouse, for deployments whose run volume outgrows MongoDB.
+// Agents, versions, steps and metrics stay in MongoDB; the runs API, the run analytics of the dashboard and the
+// metrics computed by the worker read the runs from ClickHouse. ClickHouse is reached through its HTTP interface.
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Params are the values of the {name:Type} parameters of a query, by name
+type Params map[string]interface{}
+
+// Client runs queries over the HTTP interface of a ClickHouse server
+type Client struct {
+	http     *http.Client
+	endpoint *url.URL
+	database string
+	user     string
+	password string
+}
+
+// NewClient creates a client of the ClickHouse server of a URL, http(s)://[user:password@]host:port[/database].
+// The database is default when the URL has none.
+func NewClient(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid ClickHouse URL %q", rawURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported ClickHouse URL scheme %q, use http:// or https://", u.Scheme)
+	}
+
+	c := &Client{
+		http:     &http.Client{},
+		endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"},
+		database: strings.Trim(u.Path, "/"),
+		user:     u.User.Username(),
+	}
+	c.password, _ = u.User.Password()
+	if c.database == "" {
+		c.database = "default"
+	}
+	return c, nil
+}
+
+// Ping checks that the server answers
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Exec(ctx, "SELECT 1", nil)
+}
+
+// Exec runs a statement
+func (c *Client) Exec(ctx context.Context, query string, params Params) error {
+	body, err := c.do(ctx, query, params, nil)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// Select runs a query and appends its rows to the slice dest points to, decoding them as JSON objects keyed by
+// column name
+func (c *Client) Select(ctx context.Context, dest interface{}, query string, params Params) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return errors.New("select destination must be a pointer to a slice")
+	}
+	slice = slice.Elem()
+
+	return c.Stream(ctx, query, params, func(decode func(row interface{}) error) error {
+		row := reflect.New(slice.Type().Elem())
+		if err := decode(row.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, row.Elem()))
+		return nil
+	})
+}
+
+// Stream runs a query and calls fn with each of its rows as they are read, fn decoding the row into a value
+func (c *Client) Stream(ctx context.Context, query string, params Params, fn func(decode func(row interface{}) error) error) error {
+	body, err := c.do(ctx, query+" FORMAT JSONEachRow", params, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read the ClickHouse response: %w", err)
+		}
+		if err := fn(func(row interface{}) error { return json.Unmarshal(raw, row) }); err != nil {
+			return err
+		}
+	}
+}
+
+// Insert writes rows to a table, encoding them as JSON objects keyed by column name. The rows are inserted in a
+// single block, so that either all or none of them are stored.
+func (c *Client) Insert(ctx context.Context, table string, rows interface{}) error {
+	values := reflect.ValueOf(rows)
+	if values.Kind() != reflect.Slice {
+		return errors.New("insert rows must be a slice")
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for i := 0; i < values.Len(); i++ {
+		if err := encoder.Encode(values.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	body, err := c.do(ctx, "INSERT INTO "+table+" FORMAT JSONEachRow", nil, &data)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// do sends a query, with its data when it inserts rows, and returns the body of the response
+func (c *Client) do(ctx context.Context, query string, params Params, data io.Reader) (io.ReadCloser, error) {
+	values := url.Values{}
+	values.Set("database", c.database)
+	// Numbers and times are read as JSON numbers and RFC 3339 strings, which time.Time decodes
+	values.Set("output_format_json_quote_64bit_integers", "0")
+	values.Set("date_time_output_format", "iso")
+	values.Set("date_time_input_format", "best_effort")
+	for name, value := range params {
+		values.Set("param_"+name, formatParam(value))
+	}
+
+	var body io.Reader = strings.NewReader(query)
+	if data != nil {
+		// The data of an insert is the body of the request, so the query goes in the URL
+		values.Set("query", query)
+		body = data
+	}
+
+	u := *c.endpoint
+	u.RawQuery = values.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("ClickHouse query failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp.Body, nil
+}
+
+// paramType returns the ClickHouse type of the parameter of a value
+func paramType(value interface{}) string {
+	switch value.(type) {
+	case time.Time:
+		return "DateTime64(6, 'UTC')"
+	case int, int64:
+		return "Int64"
+	case float64:
+		return "Float64"
+	case []string:
+		return "Array(String)"
+	case []int64:
+		return "Array(Int64)"
+	case []float64:
+		return "Array(Float64)"
+	default:
+		return "String"
+	}
+}
+
+// formatParam formats the value of a parameter in the escaped text format ClickHouse parses parameters with
+func formatParam(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format("2006-01-02 15:04:05.000000")
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+		}
+		return escapeText("[" + strings.Join(quoted, ",") + "]")
+	case []int64:
+		numbers := make([]string, len(v))
+		for i, n := range v {
+			numbers[i] = strconv.FormatInt(n, 10)
+		}
+		return "[" + strings.Join(numbers, ",") + "]"
+	case []float64:
+		numbers := make([]string, len(v))
+		for i, n := range v {
+			numbers[i] = strconv.FormatFloat(n, 'g', -1, 64)
+		}
+		return "[" + strings.Join(numbers, ",") + "]"
+	default:
+		return escapeText(fmt.Sprint(v))
+	}
+}
+
+// escapeText escapes the backslashes, tabs and line breaks of a value of the escaped text format
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,136 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+)
+
+// ComputeAgentVersionMetrics aggregates the runs of an agent version. Spend windows end at now, and the daily spend
+// starts at midnight in the timezone of now.
+func (m *MetricsStore) ComputeAgentVersionMetrics(ctx context.Context, agent *models.Agent, agentVersion *models.AgentVersion, now time.Time) (*models.AgentVersionMetrics, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	recentStart := now.Add(-models.HealthRecentWindow)
+	baselineStart := recentStart.Add(-models.HealthBaselineWindow)
+
+	var where conditions
+	within := func(from, to time.Time) string {
+		return "created >= " + where.param(from) + " AND created < " + where.param(to)
+	}
+	recent, baseline := within(recentStart, now), within(baselineStart, recentStart)
+	query := "SELECT count() AS runs, countIf(status = 'error') AS errors, max(recorded_at) AS last_seen," +
+		" ifNotFinite(avg(time_taken), 0) AS avg_time, sum(cost) AS total_cost," +
+		" sumIf(cost, " + within(today, now) + ") AS spend_today," +
+		" sumIf(cost, " + within(now.AddDate(0, 0, -7), now) + ") AS spend_7d," +
+		" sumIf(cost, " + within(now.AddDate(0, 0, -30), now) + ") AS spend_30d," +
+		" countIf(" + recent + ") AS recent_runs, countIf(" + recent + " AND " + failed + ") AS recent_errors," +
+		" sumIf(time_taken, " + recent + ") AS recent_time," +
+		" countIf(" + baseline + ") AS baseline_runs, sumIf(time_taken, " + baseline + ") AS baseline_time" +
+		" FROM agent_runs"
+	where.add("version_id =", agentVersion.ID.Hex())
+
+	var rows []struct {
+		Runs         int64     `json:"runs"`
+		Errors       int64     `json:"errors"`
+		LastSeen     time.Time `json:"last_seen"`
+		AvgTime      float64   `json:"avg_time"`
+		TotalCost    float64   `json:"total_cost"`
+		SpendToday   float64   `json:"spend_today"`
+		Spend7d      float64   `json:"spend_7d"`
+		Spend30d     float64   `json:"spend_30d"`
+		RecentRuns   int64     `json:"recent_runs"`
+		RecentErrors int64     `json:"recent_errors"`
+		RecentTime   float64   `json:"recent_time"`
+		BaselineRuns int64     `json:"baseline_runs"`
+		BaselineTime float64   `json:"baseline_time"`
+	}
+	if err := m.store.client.Select(ctx, &rows, query+where.where(), where.params); err != nil {
+		return nil, fmt.Errorf("unable to fetch metrics: %w", err)
+	}
+	if len(rows) == 0 || rows[0].Runs == 0 {
+		return nil, errors.New("unable to fetch last seen time: no runs")
+	}
+	row := rows[0]
+
+	errorsByCategory, err := m.countErrorsByCategory(ctx, agentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	signals := models.HealthSignals{
+		LastSeen:     row.LastSeen,
+		RecentRuns:   row.RecentRuns,
+		RecentErrors: row.RecentErrors,
+		BaselineRuns: row.BaselineRuns,
+	}
+	if signals.RecentRuns > 0 {
+		signals.RecentAvgTime = row.RecentTime / float64(signals.RecentRuns)
+	}
+	if signals.BaselineRuns > 0 {
+		signals.BaselineAvgTime = row.BaselineTime / float64(signals.BaselineRuns)
+	}
+	health, healthReasons := models.ComputeHealth(signals, now)
+
+	return &models.AgentVersionMetrics{
+		Id:             agentVersion.ID,
+		Window:         models.MetricsWindowAll,
+		Environment:    models.MetricsEnvironmentDefault,
+		Name:           agent.Name,
+		Project:        agent.Project,
+		Team:           agent.Team,
+		Status:         agentVersion.Status,
+		LastSeen:       row.LastSeen,
+		Version:        agentVersion.Version,
+		AverageRunTime: row.AvgTime,
+		SuccessRate:    (float64(row.Runs-row.Errors) / float64(row.Runs)) * 100,
+		TotalRuns:      row.Runs,
+		Spend:          row.TotalCost,
+		SpendToday:     row.SpendToday,
+		Spend7d:        row.Spend7d,
+		Spend30d:       row.Spend30d,
+		SpendTotal:     row.TotalCost,
+		Tools:          agentVersion.Tools,
+		Models:         agentVersion.Models,
+		Cluster:        agentVersion.Cluster,
+		Errors:         errorsByCategory,
+		Health:         health,
+		HealthReasons:  healthReasons,
+	}, nil
+}
+
+// countErrorsByCategory counts the failed runs of an agent version by error category
+func (m *MetricsStore) countErrorsByCategory(ctx context.Context, agentVersion *models.AgentVersion) (map[string]int64, error) {
+	var where conditions
+	where.add("version_id =", agentVersion.ID.Hex())
+	where.raw(failed)
+	var rows []struct {
+		Category string `json:"category"`
+		Count    int64  `json:"count"`
+	}
+	err := m.store.client.Select(ctx, &rows, "SELECT "+errorCategory+" AS category, count() AS count FROM agent_runs"+where.where()+
+		" GROUP BY category", where.params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to count errors by category: %w", err)
+	}
+
+	errorsByCategory := map[string]int64{}
+	for _, row := range rows {
+		errorsByCategory[row.Category] = row.Count
+	}
+	return errorsByCategory, nil
+}
+
+// DryRun computes the metrics of all agent versions from the runs in ClickHouse without writing them and returns
+// the differences with the metrics stored in MongoDB. Daily spend follows the reporting timezones.
+func (m *MetricsStore) DryRun(ctx context.Context, timezones *db.Timezones) (*db.MetricsDryRun, error) {
+	stored, err := m.ListAgentVersionMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.ComputeMetricsDryRun(ctx, m, stored, timezones)
+}
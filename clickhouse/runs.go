@@ -0,0 +1,663 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	runColumns = "id, agent_id, version_id, version, created, status, time_taken, initiator, tools, cost, models, run_id, task_id, " +
+		"recorded_at, trace_id, span_id, error_type, error_message, prompt_tokens, completion_tokens, schema_version"
+
+	// finished and failed select the runs that finished, and the finished runs that did not complete
+	finished = "status != '" + models.RunStatusRunning + "'"
+	failed   = "status NOT IN ('" + models.RunStatusCompleted + "', '" + models.RunStatusRunning + "')"
+
+	// errorCategory is the expression of the error category of a run, see models.AgentRun.ErrorCategory
+	errorCategory = "if(error_type != '', error_type, status)"
+)
+
+// runSortColumns are the sortable JSON fields of runs and their columns
+var runSortColumns = map[string]string{
+	"id":                "id",
+	"agent_id":          "agent_id",
+	"version_id":        "version_id",
+	"version":           "version",
+	"created":           "created",
+	"status":            "status",
+	"time_taken":        "time_taken",
+	"initiator":         "initiator",
+	"cost":              "cost",
+	"run_id":            "run_id",
+	"task_id":           "task_id",
+	"recorded_at":       "recorded_at",
+	"trace_id":          "trace_id",
+	"error_type":        "error_type",
+	"prompt_tokens":     "prompt_tokens",
+	"completion_tokens": "completion_tokens",
+}
+
+// runRow is a row of the runs table
+type runRow struct {
+	ID               string    `json:"id"`
+	AgentID          string    `json:"agent_id"`
+	VersionID        string    `json:"version_id"`
+	Version          string    `json:"version"`
+	Created          time.Time `json:"created"`
+	Status           string    `json:"status"`
+	TimeTaken        float64   `json:"time_taken"`
+	Initiator        string    `json:"initiator"`
+	Tools            []string  `json:"tools"`
+	Cost             float64   `json:"cost"`
+	Models           []string  `json:"models"`
+	RunID            int64     `json:"run_id"`
+	TaskID           int64     `json:"task_id"`
+	RecordedAt       time.Time `json:"recorded_at"`
+	TraceID          string    `json:"trace_id"`
+	SpanID           string    `json:"span_id"`
+	ErrorType        string    `json:"error_type"`
+	ErrorMessage     string    `json:"error_message"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	SchemaVersion    int       `json:"schema_version"`
+}
+
+// newRunRow returns the row of a run
+func newRunRow(run *models.AgentRun) runRow {
+	row := runRow{
+		ID:               run.ID.Hex(),
+		AgentID:          run.AgentID.Hex(),
+		VersionID:        run.VersionID.Hex(),
+		Version:          run.Version,
+		Created:          run.Created.UTC(),
+		Status:           run.Status,
+		TimeTaken:        run.TimeTaken,
+		Initiator:        run.Initiator,
+		Tools:            run.Tools,
+		Cost:             run.Cost,
+		Models:           run.Models,
+		RunID:            run.RunID,
+		TaskID:           run.TaskID,
+		RecordedAt:       run.RecordedAt.UTC(),
+		TraceID:          run.TraceID,
+		SpanID:           run.SpanID,
+		ErrorType:        run.ErrorType,
+		ErrorMessage:     run.ErrorMessage,
+		PromptTokens:     run.PromptTokens,
+		CompletionTokens: run.CompletionTokens,
+		SchemaVersion:    run.SchemaVersion,
+	}
+	if row.Tools == nil {
+		row.Tools = []string{}
+	}
+	if row.Models == nil {
+		row.Models = []string{}
+	}
+	return row
+}
+
+// run returns the run of a row
+func (row *runRow) run() models.AgentRun {
+	run := models.AgentRun{
+		ID:               objectID(row.ID),
+		AgentID:          objectID(row.AgentID),
+		VersionID:        objectID(row.VersionID),
+		Version:          row.Version,
+		Created:          row.Created,
+		Status:           row.Status,
+		TimeTaken:        row.TimeTaken,
+		Initiator:        row.Initiator,
+		Tools:            row.Tools,
+		Cost:             row.Cost,
+		Models:           row.Models,
+		RunID:            row.RunID,
+		TaskID:           row.TaskID,
+		RecordedAt:       row.RecordedAt,
+		TraceID:          row.TraceID,
+		SpanID:           row.SpanID,
+		ErrorType:        row.ErrorType,
+		ErrorMessage:     row.ErrorMessage,
+		PromptTokens:     row.PromptTokens,
+		CompletionTokens: row.CompletionTokens,
+		SchemaVersion:    row.SchemaVersion,
+	}
+	return run
+}
+
+// queryRuns loads the runs selected by a query over agent_runs returning runColumns
+func (s *Store) queryRuns(ctx context.Context, query string, params Params) ([]models.AgentRun, error) {
+	var rows []runRow
+	if err := s.client.Select(ctx, &rows, query, params); err != nil {
+		return nil, err
+	}
+
+	runs := make([]models.AgentRun, len(rows))
+	for i := range rows {
+		runs[i] = rows[i].run()
+	}
+	return runs, nil
+}
+
+// CreateAgentRun creates a new agent run
+func (s *Store) CreateAgentRun(ctx context.Context, run *models.AgentRun) error {
+	return s.CreateAgentRunBatch(ctx, []*models.AgentRun{run}, db.BatchOptions{})
+}
+
+// CreateAgentRunBatch creates multiple agent runs in a single insert, so that either all or none of them are stored,
+// whether the batch is ordered or not
+func (s *Store) CreateAgentRunBatch(ctx context.Context, runs []*models.AgentRun, opts db.BatchOptions) error {
+	if len(runs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec*2)*time.Second)
+	defer cancel()
+
+	if err := s.PrepareRuns(ctx, runs); err != nil {
+		return err
+	}
+	ids := make([]primitive.ObjectID, len(runs))
+	rows := make([]runRow, len(runs))
+	for i, run := range runs {
+		// Runs accepted by the run buffer were given their ID already
+		ids[i] = run.ID
+		if ids[i].IsZero() {
+			ids[i] = primitive.NewObjectID()
+		}
+		rows[i] = newRunRow(run)
+		rows[i].ID = ids[i].Hex()
+	}
+	if err := s.client.Insert(ctx, "agent_runs", rows); err != nil {
+		return err
+	}
+
+	for i, run := range runs {
+		run.ID = ids[i]
+		s.PublishRunCreated(run)
+	}
+	return nil
+}
+
+// GetAgentRuns retrieves all runs for an agent
+func (s *Store) GetAgentRuns(ctx context.Context, agentID primitive.ObjectID, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.GetAgentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	return s.listRuns(ctx, where, listOpts)
+}
+
+// GetAgentVersionRuns retrieves all runs for a specific agent version
+func (s *Store) GetAgentVersionRuns(ctx context.Context, agentID primitive.ObjectID, version string, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.GetAgentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+	agentVersion, err := s.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	where.add("version_id =", agentVersion.ID.Hex())
+	return s.listRuns(ctx, where, listOpts)
+}
+
+// GetRunsOfAgents retrieves the latest runs of agents by agent, at most limit per agent. It overrides that of the
+// MongoDB repository, which would read the runs from MongoDB.
+func (s *Store) GetRunsOfAgents(ctx context.Context, agentIDs []primitive.ObjectID, limit int64) (map[primitive.ObjectID][]models.AgentRun, error) {
+	runs, err := s.latestRunsOf(ctx, "agent_id", agentIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	byAgent := map[primitive.ObjectID][]models.AgentRun{}
+	for _, run := range runs {
+		byAgent[run.AgentID] = append(byAgent[run.AgentID], run)
+	}
+	return byAgent, nil
+}
+
+// GetRunsOfVersions retrieves the latest runs of versions by version ID, at most limit per version
+func (s *Store) GetRunsOfVersions(ctx context.Context, versionIDs []primitive.ObjectID, limit int64) (map[primitive.ObjectID][]models.AgentRun, error) {
+	runs, err := s.latestRunsOf(ctx, "version_id", versionIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[primitive.ObjectID][]models.AgentRun{}
+	for _, run := range runs {
+		byVersion[run.VersionID] = append(byVersion[run.VersionID], run)
+	}
+	return byVersion, nil
+}
+
+// latestRunsOf retrieves the latest runs of each of the IDs of an ID column, at most limit each, in one query
+func (s *Store) latestRunsOf(ctx context.Context, column string, ids []primitive.ObjectID, limit int64) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	where.in(column, hexIDs(ids))
+	query := "SELECT " + runColumns + " FROM agent_runs" + where.where() + " ORDER BY " + column + ", created DESC, id" +
+		" LIMIT " + strconv.FormatInt(limit, 10) + " BY " + column
+	return s.queryRuns(ctx, query, where.params)
+}
+
+// QueryRuns retrieves the runs matching a run query created in [from, to), newest first unless sorted otherwise.
+// Zero times leave the range open.
+func (s *Store) QueryRuns(ctx context.Context, query models.RunQuery, from, to time.Time, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	if query.AgentID != nil {
+		where.add("agent_id =", query.AgentID.Hex())
+	}
+	if query.Version != "" {
+		where.add("version =", query.Version)
+	}
+	if len(query.Statuses) > 0 {
+		where.in("status", query.Statuses)
+	}
+	if query.ErrorType != "" {
+		where.add("error_type =", query.ErrorType)
+	}
+	if query.Initiator != "" {
+		where.add("initiator =", query.Initiator)
+	}
+	if query.Tool != "" {
+		where.raw("has(tools, " + where.param(query.Tool) + ")")
+	}
+	if query.Model != "" {
+		where.raw("has(models, " + where.param(query.Model) + ")")
+	}
+	if !from.IsZero() {
+		where.add("created >=", from)
+	}
+	if !to.IsZero() {
+		where.add("created <", to)
+	}
+	return s.listRuns(ctx, where, listOpts)
+}
+
+// listRuns lists the runs matching the conditions, newest first unless sorted otherwise
+func (s *Store) listRuns(ctx context.Context, where conditions, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	query := "SELECT " + runColumns + " FROM agent_runs" + where.where() + orderBy(runSortColumns, listOpts.Sort, "created DESC", "id")
+	if listOpts.Limit > 0 {
+		query += " LIMIT " + where.param(listOpts.Limit)
+	}
+	return s.queryRuns(ctx, query, where.params)
+}
+
+// orderBy builds the ORDER BY clause of the requested JSON fields of a model, from the columns of its fields, falling
+// back to the given order when none of the fields is sortable. The ID column breaks ties so that pages do not overlap.
+func orderBy(columns map[string]string, sortFields []db.SortField, fallback, id string) string {
+	var order []string
+	for _, sf := range sortFields {
+		column, ok := columns[sf.Field]
+		if !ok {
+			continue
+		}
+		if sf.Descending {
+			column += " DESC"
+		}
+		order = append(order, column)
+	}
+	if len(order) == 0 {
+		order = append(order, fallback)
+	}
+	return " ORDER BY " + strings.Join(append(order, id), ", ")
+}
+
+// runFilter adds the conditions of a run filter
+func runFilter(where *conditions, filter db.RunFilter) {
+	if filter.Version != "" {
+		where.add("version =", filter.Version)
+	}
+	if filter.Status != "" {
+		where.add("status =", filter.Status)
+	}
+}
+
+// GetAgentRunsAfter retrieves the runs of an agent recorded after the given position, oldest first.
+// The position is the recorded timestamp and ID of the last run already seen.
+func (s *Store) GetAgentRunsAfter(ctx context.Context, agentID primitive.ObjectID, filter db.RunFilter, after time.Time, afterID primitive.ObjectID, limit int64) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	position := where.param(after)
+	where.raw("(recorded_at > " + position + " OR (recorded_at = " + position + " AND id > " + where.param(afterID.Hex()) + "))")
+	runFilter(&where, filter)
+
+	query := "SELECT " + runColumns + " FROM agent_runs" + where.where() + " ORDER BY recorded_at, id"
+	if limit > 0 {
+		query += " LIMIT " + where.param(limit)
+	}
+	return s.queryRuns(ctx, query, where.params)
+}
+
+// ExportAgentRuns calls fn with each run of an agent matching the filter, oldest first, streaming them from the
+// response so exports of any size use constant memory. The export runs until it completes or ctx is cancelled.
+func (s *Store) ExportAgentRuns(ctx context.Context, agentID primitive.ObjectID, filter db.RunFilter, fn func(run *models.AgentRun) error) error {
+	if _, err := s.GetAgentByID(ctx, agentID); err != nil {
+		return err
+	}
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	runFilter(&where, filter)
+
+	return s.client.Stream(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+" ORDER BY created, id", where.params,
+		func(decode func(row interface{}) error) error {
+			var row runRow
+			if err := decode(&row); err != nil {
+				return err
+			}
+			run := row.run()
+			return fn(&run)
+		})
+}
+
+// GetAgentRun retrieves a run of an agent by its run ID. When the run ID was recorded more than once, the latest
+// run is returned.
+func (s *Store) GetAgentRun(ctx context.Context, agentID primitive.ObjectID, runID int64) (*models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	where.add("run_id =", runID)
+	runs, err := s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+" ORDER BY created DESC, id DESC LIMIT 1", where.params)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("run not found")
+	}
+
+	return &runs[0], nil
+}
+
+// GetExistingRunIDs reports which of the given run IDs are already stored for an agent
+func (s *Store) GetExistingRunIDs(ctx context.Context, agentID primitive.ObjectID, runIDs []int64) (map[int64]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	existing := map[int64]bool{}
+	if len(runIDs) == 0 {
+		return existing, nil
+	}
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	where.raw("has(" + where.param(runIDs) + ", run_id)")
+	var rows []struct {
+		RunID int64 `json:"run_id"`
+	}
+	if err := s.client.Select(ctx, &rows, "SELECT DISTINCT run_id FROM agent_runs"+where.where(), where.params); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		existing[row.RunID] = true
+	}
+	return existing, nil
+}
+
+// versionRange selects the runs of an agent version created in [from, to)
+func versionRange(versionID primitive.ObjectID, from, to time.Time) conditions {
+	var where conditions
+	where.add("version_id =", versionID.Hex())
+	where.add("created >=", from)
+	where.add("created <", to)
+	return where
+}
+
+// GetVersionStats summarizes the runs of an agent version created in [from, to)
+func (s *Store) GetVersionStats(ctx context.Context, agentID primitive.ObjectID, version string, from, to time.Time) (*models.VersionStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	agentVersion, err := s.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	where := versionRange(agentVersion.ID, from, to)
+	var groups []struct {
+		Status    string  `json:"status"`
+		Count     int64   `json:"count"`
+		TimeTaken float64 `json:"total_time"`
+		Cost      float64 `json:"total_cost"`
+	}
+	err = s.client.Select(ctx, &groups, "SELECT status, count() AS count, sum(time_taken) AS total_time, sum(cost) AS total_cost FROM agent_runs"+
+		where.where()+" GROUP BY status", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.VersionStats{Version: version, Errors: map[string]int64{}}
+	var completed int64
+	var timeTaken float64
+	for _, g := range groups {
+		switch g.Status {
+		case models.RunStatusRunning:
+			stats.Running = g.Count
+			continue
+		case models.RunStatusCompleted:
+			completed = g.Count
+		default:
+			stats.Errors[g.Status] = g.Count
+		}
+		stats.Runs += g.Count
+		stats.TotalCost += g.Cost
+		timeTaken += g.TimeTaken
+	}
+	db.FinishVersionStats(stats, completed, timeTaken)
+	if stats.Runs == 0 {
+		return stats, nil
+	}
+
+	// The p95 run is found by skipping to its rank among the finished runs sorted by time taken, as quantile
+	// functions interpolate or sample
+	where.raw(finished)
+	var p95 []struct {
+		TimeTaken float64 `json:"time_taken"`
+	}
+	err = s.client.Select(ctx, &p95, "SELECT time_taken FROM agent_runs"+where.where()+" ORDER BY time_taken LIMIT 1 OFFSET "+
+		where.param(db.PercentileRank(stats.Runs, 95)-1), where.params)
+	if err != nil {
+		return nil, err
+	}
+	if len(p95) > 0 {
+		stats.P95TimeTaken = p95[0].TimeTaken
+	}
+
+	return stats, nil
+}
+
+// timeBucketRow is a row of a time bucket aggregation
+type timeBucketRow struct {
+	Start     time.Time `json:"start"`
+	Runs      int64     `json:"runs"`
+	Finished  int64     `json:"finished"`
+	Completed int64     `json:"completed"`
+	TimeTaken float64   `json:"finished_time"`
+	Cost      float64   `json:"total_cost"`
+}
+
+// timeBucketStart returns the expression truncating the creation time of a run to the start of its time bucket, in
+// UTC
+func timeBucketStart(bucket time.Duration) string {
+	seconds := int64(bucket / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return "toStartOfInterval(created, INTERVAL " + strconv.FormatInt(seconds, 10) + " SECOND)"
+}
+
+// timeBuckets aggregates the runs matching the conditions into buckets of the given duration
+func (s *Store) timeBuckets(ctx context.Context, where conditions, bucket time.Duration) ([]db.TimeBucket, error) {
+	var rows []timeBucketRow
+	err := s.client.Select(ctx, &rows, "SELECT "+timeBucketStart(bucket)+" AS start, count() AS runs, countIf("+finished+") AS finished,"+
+		" countIf(status = '"+models.RunStatusCompleted+"') AS completed, sumIf(time_taken, "+finished+") AS finished_time, sum(cost) AS total_cost"+
+		" FROM agent_runs"+where.where()+" GROUP BY start ORDER BY start", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]db.TimeBucket, len(rows))
+	for i, row := range rows {
+		buckets[i] = db.TimeBucket(row)
+	}
+	return buckets, nil
+}
+
+// GetVersionTimeBuckets aggregates the runs of an agent version created in [from, to) into buckets of the given
+// duration
+func (s *Store) GetVersionTimeBuckets(ctx context.Context, agentID primitive.ObjectID, version string, bucket time.Duration, from, to time.Time) ([]db.TimeBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	agentVersion, err := s.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.timeBuckets(ctx, versionRange(agentVersion.ID, from, to), bucket)
+}
+
+// GetLatencyHistogram counts the finished runs of an agent version created in [from, to) per latency bucket. Bounds
+// are the increasing upper bounds of the buckets, in seconds; runs taking at least the last bound are counted in an
+// overflow bucket.
+func (s *Store) GetLatencyHistogram(ctx context.Context, agentID primitive.ObjectID, version string, bounds []float64, from, to time.Time) ([]db.HistogramCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	agentVersion, err := s.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	where := versionRange(agentVersion.ID, from, to)
+	where.raw(finished)
+	where.raw("time_taken >= 0")
+	// The bucket of a run is the last one starting at or below its time taken
+	boundaries := where.param(db.HistogramBoundaries(bounds))
+	var rows []struct {
+		Min   float64 `json:"bucket_min"`
+		Count int64   `json:"count"`
+	}
+	err = s.client.Select(ctx, &rows, "SELECT "+boundaries+"[arrayCount(b -> b <= time_taken, "+boundaries+")] AS bucket_min, count() AS count"+
+		" FROM agent_runs"+where.where()+" GROUP BY bucket_min", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]db.HistogramCount, len(rows))
+	for i, row := range rows {
+		counts[i] = db.HistogramCount(row)
+	}
+	return counts, nil
+}
+
+// GetAdoptionBuckets counts the runs of every version of an agent created in [from, to) per time bucket of the
+// given duration
+func (s *Store) GetAdoptionBuckets(ctx context.Context, agentID primitive.ObjectID, bucket time.Duration, from, to time.Time) ([]db.VersionBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.GetAgentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	where.add("created >=", from)
+	where.add("created <", to)
+	var rows []struct {
+		Start   time.Time `json:"start"`
+		Version string    `json:"version"`
+		Runs    int64     `json:"runs"`
+	}
+	// Versions first running in the same bucket are listed in a stable order
+	err := s.client.Select(ctx, &rows, "SELECT "+timeBucketStart(bucket)+" AS start, version, count() AS runs FROM agent_runs"+
+		where.where()+" GROUP BY start, version ORDER BY start, version", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]db.VersionBucket, len(rows))
+	for i, row := range rows {
+		buckets[i] = db.VersionBucket(row)
+	}
+	return buckets, nil
+}
+
+// GetUsageStats computes the usage statistics of the values of a run list field, tools or models, over the finished
+// runs of an agent created in [from, to), optionally of a single version. The most used values come first.
+func (s *Store) GetUsageStats(ctx context.Context, agentID primitive.ObjectID, field string, version string, from, to time.Time) ([]models.UsageStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.GetAgentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id =", agentID.Hex())
+	where.add("created >=", from)
+	where.add("created <", to)
+	if version != "" {
+		where.add("version =", version)
+	}
+	return s.usageStats(ctx, where, field)
+}
+
+// usageStats computes the usage statistics of the values of a run list field over the finished runs matching the
+// conditions, most used first
+func (s *Store) usageStats(ctx context.Context, where conditions, field string) ([]models.UsageStats, error) {
+	if field != db.UsageFieldTools && field != db.UsageFieldModels {
+		return nil, fmt.Errorf("unsupported usage field %q", field)
+	}
+	where.raw(finished)
+
+	var rows []struct {
+		Name      string  `json:"name"`
+		Runs      int64   `json:"runs"`
+		Failed    int64   `json:"failed"`
+		TimeTaken float64 `json:"total_time"`
+		Cost      float64 `json:"total_cost"`
+	}
+	err := s.client.Select(ctx, &rows, "SELECT arrayJoin("+field+") AS name, count() AS runs, countIf(status != '"+models.RunStatusCompleted+"') AS failed,"+
+		" sum(time_taken) AS total_time, sum(cost) AS total_cost FROM agent_runs"+where.where()+" GROUP BY name", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	accumulators := make([]db.UsageAccumulator, len(rows))
+	for i, row := range rows {
+		accumulators[i] = db.UsageAccumulator(row)
+	}
+	return db.UsageStatsFrom(accumulators), nil
+}
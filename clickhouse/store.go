@@ -0,0 +1,202 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// schema creates the runs table. Runs are partitioned by month and sorted by agent, version and creation time, which
+// the runs API and the per version analytics read ranges of.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS agent_runs (
+		id String,
+		agent_id String,
+		version_id String,
+		version String,
+		created DateTime64(6, 'UTC'),
+		status LowCardinality(String),
+		time_taken Float64,
+		initiator String,
+		tools Array(String),
+		cost Float64,
+		models Array(String),
+		run_id Int64,
+		task_id Int64,
+		recorded_at DateTime64(6, 'UTC'),
+		trace_id String,
+		span_id String,
+		error_type LowCardinality(String),
+		error_message String,
+		prompt_tokens Int64,
+		completion_tokens Int64,
+		schema_version Int32,
+		INDEX run_id_idx run_id TYPE bloom_filter GRANULARITY 4
+	) ENGINE = MergeTree
+	PARTITION BY toYYYYMM(created)
+	ORDER BY (agent_id, version_id, created, id)`,
+}
+
+// Store implements the agent store with the runs in ClickHouse, and agents, versions, steps, ingest tokens and
+// teams in MongoDB
+type Store struct {
+	*db.AgentRepository
+	client     *Client
+	catalog    *db.MetricsRepository
+	timeoutSec int
+}
+
+// UIStore serves the dashboard views computed from the runs of a Store. Version metrics are read from MongoDB, where
+// the worker stores them.
+type UIStore struct {
+	*db.UIRepository
+	store *Store
+}
+
+// MetricsStore computes the metrics of agent versions from the runs of a Store and stores them in MongoDB
+type MetricsStore struct {
+	*db.MetricsRepository
+	store *Store
+}
+
+var (
+	_ db.AgentStore   = (*Store)(nil)
+	_ db.UIStore      = (*UIStore)(nil)
+	_ db.MetricsStore = (*MetricsStore)(nil)
+	_ db.BatchStore   = (*Store)(nil)
+)
+
+// Open connects to the ClickHouse server of a URL and creates the runs table when it is missing. The agents and
+// versions of the runs are read from the MongoDB database.
+func Open(rawURL string, mongodb *db.MongoDB) (*Store, error) {
+	client, err := NewClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		AgentRepository: db.NewAgentRepository(mongodb),
+		client:          client,
+		catalog:         db.NewMetricsRepository(mongodb),
+		timeoutSec:      10,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("unable to connect to ClickHouse: %w", err)
+	}
+	for _, statement := range schema {
+		if err := client.Exec(ctx, statement, nil); err != nil {
+			return nil, fmt.Errorf("unable to create the runs table: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// UI returns the dashboard views of the store
+func (s *Store) UI(ui *db.UIRepository) *UIStore {
+	return &UIStore{UIRepository: ui, store: s}
+}
+
+// Metrics returns the metrics computation of the store
+func (s *Store) Metrics() *MetricsStore {
+	return &MetricsStore{MetricsRepository: s.catalog, store: s}
+}
+
+// agents loads every agent by ID, to name and scope the agents of aggregated runs
+func (s *Store) agents(ctx context.Context) (map[primitive.ObjectID]*models.Agent, error) {
+	all, err := s.catalog.ListAllAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make(map[primitive.ObjectID]*models.Agent, len(all))
+	for _, agent := range all {
+		agents[agent.ID] = agent
+	}
+	return agents, nil
+}
+
+// versions loads every agent version by ID, to find the cluster of aggregated runs
+func (s *Store) versions(ctx context.Context) (map[primitive.ObjectID]*models.AgentVersion, error) {
+	all, err := s.catalog.ListAllAgentVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[primitive.ObjectID]*models.AgentVersion, len(all))
+	for _, version := range all {
+		versions[version.ID] = version
+	}
+	return versions, nil
+}
+
+// agentName returns the name of an agent, empty when the agent does not exist
+func agentName(agents map[primitive.ObjectID]*models.Agent, id primitive.ObjectID) string {
+	if agent, ok := agents[id]; ok {
+		return agent.Name
+	}
+	return ""
+}
+
+// conditions builds the WHERE clause of a query and the values of its parameters
+type conditions struct {
+	clauses []string
+	params  Params
+}
+
+// param adds the value of a parameter and returns its placeholder
+func (c *conditions) param(value interface{}) string {
+	if c.params == nil {
+		c.params = Params{}
+	}
+	name := "p" + strconv.Itoa(len(c.params))
+	c.params[name] = value
+	return "{" + name + ":" + paramType(value) + "}"
+}
+
+// add adds a condition comparing a column to a value, e.g. add("created >=", from)
+func (c *conditions) add(column string, value interface{}) {
+	c.clauses = append(c.clauses, column+" "+c.param(value))
+}
+
+// in adds the condition that a column has one of the values
+func (c *conditions) in(column string, values []string) {
+	c.raw("has(" + c.param(values) + ", " + column + ")")
+}
+
+// raw adds a condition that has no parameter, or whose parameters were added with param
+func (c *conditions) raw(clause string) {
+	c.clauses = append(c.clauses, clause)
+}
+
+// where returns the WHERE clause of the conditions, empty without conditions
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// hexIDs returns the hex strings of IDs, the form IDs are stored in
+func hexIDs(ids []primitive.ObjectID) []string {
+	hex := make([]string, len(ids))
+	for i, id := range ids {
+		hex[i] = id.Hex()
+	}
+	return hex
+}
+
+// objectID parses an ID stored as the hex string of an ObjectID
+func objectID(hex string) primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(hex)
+	return id
+}
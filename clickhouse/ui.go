@@ -0,0 +1,545 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createdIn selects the runs created in [from, to)
+func createdIn(from, to time.Time) conditions {
+	var where conditions
+	where.add("created >=", from)
+	where.add("created <", to)
+	return where
+}
+
+// dashboardScope adds the conditions selecting the runs of the agents, team, project and cluster of a dashboard
+// filter. Agents and versions are stored in MongoDB, so they are selected by ID.
+func (s *UIStore) dashboardScope(ctx context.Context, where *conditions, filter db.DashboardFilter) error {
+	if !filter.IncludeArchived || filter.Project != "" || filter.Team != "" {
+		agents, err := s.store.agents(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the agents of the filter: %w", err)
+		}
+
+		var archived, selected []primitive.ObjectID
+		for id, agent := range agents {
+			if agent.Archived && !filter.IncludeArchived {
+				archived = append(archived, id)
+				continue
+			}
+			if (filter.Project == "" || agent.Project == filter.Project) && (filter.Team == "" || agent.Team == filter.Team) {
+				selected = append(selected, id)
+			}
+		}
+		if filter.Project != "" || filter.Team != "" {
+			where.in("agent_id", hexIDs(selected))
+		} else if len(archived) > 0 {
+			where.raw("NOT has(" + where.param(hexIDs(archived)) + ", agent_id)")
+		}
+	}
+
+	if filter.Cluster != "" {
+		versions, err := s.store.versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the versions of cluster %q: %w", filter.Cluster, err)
+		}
+		var ids []primitive.ObjectID
+		for id, version := range versions {
+			if version.Cluster == filter.Cluster {
+				ids = append(ids, id)
+			}
+		}
+		where.in("version_id", hexIDs(ids))
+	}
+	return nil
+}
+
+// GetDashboardStats computes the dashboard statistics of the runs selected by a filter, formatted for the given
+// locale. Budgets are computed from the runs in MongoDB, so the statistics have no budget.
+func (s *UIStore) GetDashboardStats(ctx context.Context, locale *db.Locale, filter db.DashboardFilter) ([]db.StatsData, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	windows := filter.Windows(time.Now())
+
+	var where conditions
+	if err := s.dashboardScope(ctx, &where, filter); err != nil {
+		return nil, err
+	}
+
+	// Each statistic is computed over its window and the window it is compared to, in a single scan of the runs
+	from, to := windows.Runs.From, windows.Runs.To
+	window := func(from, to time.Time) string {
+		return "created >= " + where.param(from) + " AND created < " + where.param(to)
+	}
+	var selects []string
+	for _, w := range []struct {
+		window    db.StatsWindow
+		aggregate string
+		name      string
+	}{
+		{windows.ActiveAgents, "uniqExactIf(agent_id, %s)", "active_agents"},
+		{windows.Runs, "countIf(%s)", "runs"},
+		{windows.Cost, "sumIf(cost, %s)", "total_cost"},
+		// Averages over no runs are nan
+		{windows.ResponseTime, "ifNotFinite(avgIf(time_taken, %s), 0)", "response_time"},
+	} {
+		for i, r := range [][2]time.Time{{w.window.From, w.window.To}, {w.window.CompareFrom, w.window.CompareTo}} {
+			name := w.name
+			if i > 0 {
+				name += "_compared"
+			}
+			selects = append(selects, fmt.Sprintf(w.aggregate, window(r[0], r[1]))+" AS "+name)
+			if r[0].Before(from) {
+				from = r[0]
+			}
+			if r[1].After(to) {
+				to = r[1]
+			}
+		}
+	}
+	where.add("created >=", from)
+	where.add("created <", to)
+
+	var rows []struct {
+		ActiveAgents         int     `json:"active_agents"`
+		ActiveAgentsCompared int     `json:"active_agents_compared"`
+		Runs                 int     `json:"runs"`
+		RunsCompared         int     `json:"runs_compared"`
+		Cost                 float64 `json:"total_cost"`
+		CostCompared         float64 `json:"total_cost_compared"`
+		ResponseTime         float64 `json:"response_time"`
+		ResponseTimeCompared float64 `json:"response_time_compared"`
+	}
+	query := "SELECT " + strings.Join(selects, ", ") + " FROM agent_runs" + where.where()
+	if err := s.store.client.Select(ctx, &rows, query, where.params); err != nil {
+		return nil, fmt.Errorf("failed to get dashboard statistics: %w", err)
+	}
+
+	var counts db.DashboardCounts
+	if len(rows) > 0 {
+		row := rows[0]
+		counts.ActiveAgentsNow, counts.ActiveAgentsLastWeek = row.ActiveAgents, row.ActiveAgentsCompared
+		counts.RunsToday, counts.RunsYesterday = row.Runs, row.RunsCompared
+		counts.CostToday, counts.CostYesterday = row.Cost, row.CostCompared
+		counts.AvgResponseTimeNow, counts.AvgResponseTimePrev = row.ResponseTime, row.ResponseTimeCompared
+	}
+
+	return db.BuildDashboardStats(locale, counts, windows), nil
+}
+
+// GetRecentActivity retrieves the most recent agent runs selected by a filter, newest first. The returned cursor
+// points after the last item when more runs match, and is nil otherwise.
+func (s *UIStore) GetRecentActivity(ctx context.Context, filter db.ActivityFilter) ([]db.ActivityData, *db.ActivityCursor, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	if filter.AgentID != nil {
+		where.add("agent_id =", filter.AgentID.Hex())
+	}
+	if len(filter.Statuses) > 0 {
+		where.in("status", filter.Statuses)
+	}
+	if after := filter.After; after != nil {
+		created := where.param(after.Created)
+		where.raw("(created < " + created + " OR (created = " + created + " AND id < " + where.param(after.RunID.Hex()) + "))")
+	}
+
+	// One more run than the limit is fetched to know whether there is a next page
+	runs, err := s.store.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+" ORDER BY created DESC, id DESC LIMIT "+
+		where.param(filter.Limit+1), where.params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query recent activity: %w", err)
+	}
+	agents, err := s.store.agents(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hasMore := int64(len(runs)) > filter.Limit
+	if hasMore {
+		runs = runs[:filter.Limit]
+	}
+
+	activities := make([]db.ActivityData, 0, len(runs))
+	for i := range runs {
+		activities = append(activities, db.NewActivityData(&runs[i], agentName(agents, runs[i].AgentID)))
+	}
+
+	var next *db.ActivityCursor
+	if hasMore {
+		last := runs[len(runs)-1]
+		next = &db.ActivityCursor{Created: last.Created, RunID: last.ID}
+	}
+	return activities, next, nil
+}
+
+// GetAutoscalingSignals computes ingest rate, run backlog and per-agent load over the given window.
+// If agentName is not empty, only that agent is included in the per-agent load, and the totals are those of the agent.
+func (s *UIStore) GetAutoscalingSignals(ctx context.Context, window time.Duration, agentName string) (*db.AutoscalingSignals, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	since := now.Add(-window)
+	agents, err := s.store.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	recent := "recorded_at >= " + where.param(since)
+	running := "status = '" + models.RunStatusRunning + "'"
+	where.raw("(" + recent + " OR " + running + ")")
+	var rows []struct {
+		AgentID      string `json:"agent_id"`
+		RunsInWindow int64  `json:"runs_in_window"`
+		Running      int64  `json:"running"`
+	}
+	err = s.store.client.Select(ctx, &rows, "SELECT agent_id, countIf("+recent+") AS runs_in_window, countIf("+running+") AS running"+
+		" FROM agent_runs"+where.where()+" GROUP BY agent_id ORDER BY running DESC, runs_in_window DESC", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	signals := &db.AutoscalingSignals{
+		GeneratedAt:   now,
+		WindowSeconds: window.Seconds(),
+		Agents:        []db.AgentLoadSignal{},
+	}
+	for _, row := range rows {
+		agent, ok := agents[objectID(row.AgentID)]
+		if agentName != "" && (!ok || agent.Name != agentName) {
+			continue
+		}
+		signals.RunsInWindow += row.RunsInWindow
+		signals.Backlog += row.Running
+		if !ok {
+			continue
+		}
+		signals.Agents = append(signals.Agents, db.AgentLoadSignal{
+			AgentID:      agent.ID,
+			Name:         agent.Name,
+			Project:      agent.Project,
+			RunsInWindow: row.RunsInWindow,
+			IngestRate:   float64(row.RunsInWindow) / window.Seconds(),
+			Running:      row.Running,
+		})
+	}
+	signals.IngestRate = float64(signals.RunsInWindow) / window.Seconds()
+
+	return signals, nil
+}
+
+// GetModelStats computes the usage statistics of the models of the finished runs of all agents created in [from, to),
+// most used first
+func (s *UIStore) GetModelStats(ctx context.Context, from, to time.Time) ([]models.UsageStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.store.usageStats(ctx, createdIn(from, to), db.UsageFieldModels)
+}
+
+// GetClusterStats summarizes the runs created in [from, to) by the cluster of their agent version, busiest first
+func (s *UIStore) GetClusterStats(ctx context.Context, from, to time.Time) ([]models.ClusterStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	// Runs are grouped by version, whose cluster is stored in MongoDB
+	where := createdIn(from, to)
+	var rows []struct {
+		VersionID string  `json:"version_id"`
+		AgentID   string  `json:"any_agent_id"`
+		Runs      int64   `json:"runs"`
+		Finished  int64   `json:"finished"`
+		Failed    int64   `json:"failed"`
+		Cost      float64 `json:"total_cost"`
+	}
+	err := s.store.client.Select(ctx, &rows, "SELECT version_id, any(agent_id) AS any_agent_id, count() AS runs, countIf("+finished+") AS finished,"+
+		" countIf("+failed+") AS failed, sum(cost) AS total_cost FROM agent_runs"+where.where()+" GROUP BY version_id", where.params)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.store.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byCluster := map[string]*db.ClusterAccumulator{}
+	clusterAgents := map[string]map[string]bool{}
+	for _, row := range rows {
+		var cluster string
+		if version, ok := versions[objectID(row.VersionID)]; ok {
+			cluster = version.Cluster
+		}
+		acc, ok := byCluster[cluster]
+		if !ok {
+			acc = &db.ClusterAccumulator{Cluster: cluster}
+			byCluster[cluster] = acc
+			clusterAgents[cluster] = map[string]bool{}
+		}
+		clusterAgents[cluster][row.AgentID] = true
+		acc.Versions++
+		acc.Runs += row.Runs
+		acc.Finished += row.Finished
+		acc.Failed += row.Failed
+		acc.Cost += row.Cost
+	}
+
+	accumulators := make([]db.ClusterAccumulator, 0, len(byCluster))
+	for cluster, acc := range byCluster {
+		acc.Agents = int64(len(clusterAgents[cluster]))
+		accumulators = append(accumulators, *acc)
+	}
+	return db.ClusterStatsOf(accumulators), nil
+}
+
+// GetHeatmapCells aggregates the runs created in [from, to) by day of the week and hour of the day, in UTC
+func (s *UIStore) GetHeatmapCells(ctx context.Context, from, to time.Time) ([]db.HeatmapCell, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	// toDayOfWeek starts at 1 on Monday and ends at 7 on Sunday
+	where := createdIn(from, to)
+	var rows []struct {
+		Weekday int     `json:"weekday"`
+		Hour    int     `json:"hour"`
+		Runs    int64   `json:"runs"`
+		Cost    float64 `json:"total_cost"`
+	}
+	err := s.store.client.Select(ctx, &rows, "SELECT toDayOfWeek(created) % 7 AS weekday, toHour(created) AS hour, count() AS runs,"+
+		" sum(cost) AS total_cost FROM agent_runs"+where.where()+" GROUP BY weekday, hour", where.params)
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make([]db.HeatmapCell, len(rows))
+	for i, row := range rows {
+		cells[i] = db.HeatmapCell{Weekday: row.Weekday, Hour: row.Hour, Runs: row.Runs, Cost: row.Cost}
+	}
+	return cells, nil
+}
+
+// GetTimeBuckets aggregates the runs of all agents created in [from, to) into buckets of the given duration
+func (s *UIStore) GetTimeBuckets(ctx context.Context, bucket time.Duration, from, to time.Time) ([]db.TimeBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.store.timeBuckets(ctx, createdIn(from, to), bucket)
+}
+
+// GetLeaderboard ranks the agents, or the agent versions when group is version, by a metric of their runs created
+// in [from, to) and returns the first limit entries
+func (s *UIStore) GetLeaderboard(ctx context.Context, by, group string, from, to time.Time, limit int) ([]models.LeaderboardEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	version, groupBy := "''", "agent_id"
+	if group == models.LeaderboardByVersion {
+		version, groupBy = "version", "agent_id, version"
+	}
+	where := createdIn(from, to)
+	var rows []struct {
+		AgentID   string  `json:"agent_id"`
+		Version   string  `json:"version"`
+		Runs      int64   `json:"runs"`
+		Errors    int64   `json:"errors"`
+		TotalCost float64 `json:"total_cost"`
+	}
+	err := s.store.client.Select(ctx, &rows, "SELECT agent_id, "+version+" AS version, count() AS runs, countIf("+failed+") AS errors,"+
+		" sum(cost) AS total_cost FROM agent_runs"+where.where()+" GROUP BY "+groupBy, where.params)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := s.store.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.LeaderboardEntry, len(rows))
+	for i, row := range rows {
+		id := objectID(row.AgentID)
+		entries[i] = models.LeaderboardEntry{
+			AgentID:   id,
+			AgentName: agentName(agents, id),
+			Version:   row.Version,
+			Runs:      row.Runs,
+			Errors:    row.Errors,
+			TotalCost: row.TotalCost,
+		}
+	}
+	return db.RankLeaderboard(entries, by, limit), nil
+}
+
+// GetErrorCounts counts the failed runs created in [from, to) by agent and error category
+func (s *UIStore) GetErrorCounts(ctx context.Context, from, to time.Time) ([]db.ErrorCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	where := createdIn(from, to)
+	where.raw(failed)
+	var rows []struct {
+		AgentID  string `json:"agent_id"`
+		Category string `json:"category"`
+		Count    int64  `json:"count"`
+	}
+	err := s.store.client.Select(ctx, &rows, "SELECT agent_id, "+errorCategory+" AS category, count() AS count FROM agent_runs"+
+		where.where()+" GROUP BY agent_id, category", where.params)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := s.store.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]db.ErrorCount, len(rows))
+	for i, row := range rows {
+		id := objectID(row.AgentID)
+		counts[i] = db.ErrorCount{AgentID: id, AgentName: agentName(agents, id), Category: row.Category, Count: row.Count}
+	}
+	return counts, nil
+}
+
+// modelShare selects every distinct model of a run as model, runs without models under an empty model name, and the
+// share of a run column attributed to it, see db.CostShares
+func modelShare(column string) string {
+	return "if(empty(models), " + column + ", " + column + " * countEqual(models, model) / length(models))"
+}
+
+// modelJoin is the select expression of the distinct models of a run used by modelShare
+const modelJoin = "arrayJoin(if(empty(models), [''], arrayDistinct(models))) AS model"
+
+// GetCostBreakdown sums the cost of the runs created in [from, to) by agent, project, team or model. The cost of a
+// run using several models is split evenly between them.
+func (s *UIStore) GetCostBreakdown(ctx context.Context, groupBy string, from, to time.Time) ([]models.CostBreakdownItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	where := createdIn(from, to)
+	var rows []struct {
+		Key  string  `json:"key"`
+		Runs int64   `json:"runs"`
+		Cost float64 `json:"total_cost"`
+	}
+	switch groupBy {
+	case models.CostGroupModel:
+		err := s.store.client.Select(ctx, &rows, "SELECT "+modelJoin+", model AS key, count() AS runs, sum("+modelShare("cost")+") AS total_cost"+
+			" FROM agent_runs"+where.where()+" GROUP BY model", where.params)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]models.CostBreakdownItem, len(rows))
+		for i, row := range rows {
+			items[i] = models.CostBreakdownItem{Name: row.Key, Runs: row.Runs, Cost: row.Cost}
+		}
+		return items, nil
+	case models.CostGroupAgent, models.CostGroupProject, models.CostGroupTeam:
+	default:
+		return nil, fmt.Errorf("unsupported cost grouping %q", groupBy)
+	}
+
+	// Runs are summed by agent, then by the project or team of the agent
+	err := s.store.client.Select(ctx, &rows, "SELECT agent_id AS key, count() AS runs, sum(cost) AS total_cost FROM agent_runs"+
+		where.where()+" GROUP BY agent_id", where.params)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := s.store.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := map[string]*models.CostBreakdownItem{}
+	for _, row := range rows {
+		agentID := objectID(row.Key)
+		agent, ok := agents[agentID]
+		if !ok {
+			agent = &models.Agent{}
+		}
+		key, item := row.Key, models.CostBreakdownItem{AgentID: &agentID, Name: agent.Name}
+		switch groupBy {
+		case models.CostGroupProject:
+			key, item = agent.Project, models.CostBreakdownItem{Name: agent.Project}
+		case models.CostGroupTeam:
+			key, item = agent.Team, models.CostBreakdownItem{Name: agent.Team}
+		}
+		acc, ok := byKey[key]
+		if !ok {
+			acc = &item
+			byKey[key] = acc
+		}
+		acc.Runs += row.Runs
+		acc.Cost += row.Cost
+	}
+
+	items := make([]models.CostBreakdownItem, 0, len(byKey))
+	for _, item := range byKey {
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+// GetTokenUsage sums the tokens and cost of the runs created in [from, to) by day, in the given timezone, agent and
+// model. The tokens and cost of a run using several models are split evenly between them.
+func (s *UIStore) GetTokenUsage(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.TokenUsageItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.store.timeoutSec)*time.Second)
+	defer cancel()
+
+	// Runs are summed by quarter hour, which every timezone offset is a multiple of, and the quarters by day in the
+	// timezone, which ClickHouse would need the name of
+	where := createdIn(from, to)
+	var rows []struct {
+		Start            time.Time `json:"start"`
+		AgentID          string    `json:"agent_id"`
+		Model            string    `json:"model"`
+		Runs             int64     `json:"runs"`
+		PromptTokens     float64   `json:"prompt"`
+		CompletionTokens float64   `json:"completion"`
+		Cost             float64   `json:"total_cost"`
+	}
+	err := s.store.client.Select(ctx, &rows, "SELECT toStartOfFifteenMinutes(created) AS start, agent_id, "+modelJoin+", count() AS runs,"+
+		" sum("+modelShare("prompt_tokens")+") AS prompt, sum("+modelShare("completion_tokens")+") AS completion,"+
+		" sum("+modelShare("cost")+") AS total_cost FROM agent_runs"+where.where()+" GROUP BY start, agent_id, model", where.params)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := s.store.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		day     string
+		agentID primitive.ObjectID
+		model   string
+	}
+	byKey := map[key]*models.TokenUsageItem{}
+	for _, row := range rows {
+		k := key{row.Start.In(loc).Format("2006-01-02"), objectID(row.AgentID), row.Model}
+		item, ok := byKey[k]
+		if !ok {
+			item = &models.TokenUsageItem{Day: k.day, AgentID: k.agentID, Agent: agentName(agents, k.agentID), Model: k.model}
+			byKey[k] = item
+		}
+		item.Runs += row.Runs
+		item.PromptTokens += row.PromptTokens
+		item.CompletionTokens += row.CompletionTokens
+		item.Cost += row.Cost
+	}
+
+	items := make([]models.TokenUsageItem, 0, len(byKey))
+	for _, item := range byKey {
+		items = append(items, *item)
+	}
+	return items, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"ripple/cache"
+	"ripple/db"
+	"ripple/ingest"
+	"ripple/models"
+	"ripple/secrets"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	initialRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+func main() {
+	// Parse command line flags
+	mongoURI := flag.String("mongo-uri", "mongodb://localhost:27017", "MongoDB connection URI")
+	dbName := flag.String("db-name", "agent_metrics", "MongoDB database name")
+	mongoSecret := flag.String("mongo-secret", os.Getenv("MONGO_SECRET"), "Secret holding the MongoDB URI or credentials, overriding --mongo-uri: vault://<path>#<field>, aws-sm://<secret>#<field> or gcp-sm://projects/<project>/secrets/<secret>#<field> (default: $MONGO_SECRET)")
+	mongoSecretRefresh := flag.Duration("mongo-secret-refresh", 5*time.Minute, "Interval at which --mongo-secret is read again, reconnecting to MongoDB when it changed, 0 disables refreshing")
+	mongoMaxPoolSize := flag.Uint64("mongo-max-pool-size", 0, "Maximum number of connections to each MongoDB server, the maxPoolSize of --mongo-uri or 100 when 0")
+	mongoMinPoolSize := flag.Uint64("mongo-min-pool-size", 0, "Number of connections to each MongoDB server kept open while idle, the minPoolSize of --mongo-uri or 0 when 0")
+	mongoServerSelectionTimeout := flag.Duration("mongo-server-selection-timeout", 0, "How long a MongoDB operation waits for a server to run on, the serverSelectionTimeoutMS of --mongo-uri or 30s when 0")
+	mongoCompressors := flag.String("mongo-compressors", "", "Comma separated compressors of the messages exchanged with MongoDB, in order of preference: snappy, zlib or zstd, the compressors of --mongo-uri or none when empty")
+	mongoRetryWrites := flag.Bool("mongo-retry-writes", true, "Retry the MongoDB writes failing on a network error or a failover once, overriding the retryWrites of --mongo-uri")
+	mongoReadTimeout := flag.Duration("mongo-read-timeout", db.DefaultTimeouts.Read, "Timeout of the MongoDB reads of a batch")
+	mongoWriteTimeout := flag.Duration("mongo-write-timeout", db.DefaultTimeouts.Write, "Timeout of the MongoDB writes of a batch")
+	brokers := flag.String("kafka-brokers", "localhost:9092", "Comma separated list of Kafka brokers")
+	topic := flag.String("kafka-topic", "agent-runs", "Kafka topic carrying run events")
+	group := flag.String("kafka-group", "ripple-ingestor", "Kafka consumer group")
+	batchSize := flag.Int("batch-size", 500, "Maximum number of runs written per batch")
+	batchTimeout := flag.Duration("batch-timeout", time.Second, "Maximum time to wait for a batch to fill")
+	readCacheRedis := flag.String("read-cache-redis", "", "Redis URL the versions runs are stored with are cached in, shared with the servers, e.g. redis://localhost:6379/1, not cached when empty")
+	readCacheTTL := flag.Duration("read-cache-ttl", db.DefaultReadCacheTTL, "How long the reads cached with --read-cache-redis are served from the cache at most")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Connect to MongoDB, with the URI or credentials of a secret manager when configured
+	uri := *mongoURI
+	var source secrets.Source
+	if *mongoSecret != "" {
+		var err error
+		if source, err = secrets.NewSource(*mongoSecret); err != nil {
+			log.Fatalf("Invalid MongoDB secret: %v", err)
+		}
+		if uri, err = secrets.ResolveMongoURI(ctx, source, *mongoURI); err != nil {
+			log.Fatalf("Failed to read the MongoDB secret: %v", err)
+		}
+	}
+	compressors, err := db.ParseCompressors(*mongoCompressors)
+	if err != nil {
+		log.Fatalf("Invalid --mongo-compressors: %v", err)
+	}
+	clientOptions := db.ClientOptions{
+		MaxPoolSize:            *mongoMaxPoolSize,
+		MinPoolSize:            *mongoMinPoolSize,
+		ServerSelectionTimeout: *mongoServerSelectionTimeout,
+		Compressors:            compressors,
+		RetryWrites:            mongoRetryWrites,
+	}
+	mongodb, err := db.NewMongoDB(uri, *dbName, clientOptions)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer mongodb.Close()
+	if *mongoReadTimeout <= 0 || *mongoWriteTimeout <= 0 {
+		log.Fatalf("Invalid MongoDB timeouts, --mongo-read-timeout and --mongo-write-timeout must be positive")
+	}
+	mongodb.SetTimeouts(db.Timeouts{Read: *mongoReadTimeout, Write: *mongoWriteTimeout, Aggregate: db.DefaultTimeouts.Aggregate})
+	if *readCacheRedis != "" {
+		if *readCacheTTL <= 0 {
+			log.Fatalf("Invalid --read-cache-ttl %s, it must be positive", *readCacheTTL)
+		}
+		readCache, err := cache.NewRedis(*readCacheRedis)
+		if err != nil {
+			log.Fatalf("Failed to connect to the read cache Redis: %v", err)
+		}
+		defer readCache.Close()
+		mongodb.SetReadCache(readCache, *readCacheTTL)
+	}
+	if source != nil && *mongoSecretRefresh > 0 {
+		go secrets.WatchMongo(ctx, mongodb, source, *mongoURI, uri, *mongoSecretRefresh)
+	}
+
+	processor := ingest.NewProcessor(db.NewAgentRepository(mongodb), ingest.NewRunSchemaRegistry())
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  strings.Split(*brokers, ","),
+		Topic:    *topic,
+		GroupID:  *group,
+		MinBytes: 1,
+		MaxBytes: 10e6,
+	})
+	defer reader.Close()
+
+	log.Printf("Consuming run events from topic %s as group %s", *topic, *group)
+	for {
+		msgs, err := fetchBatch(ctx, reader, *batchSize, *batchTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Println("Ingestor exited properly")
+				return
+			}
+			log.Printf("Failed to fetch messages: %v", err)
+			os.Exit(1)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		if err := processBatch(ctx, processor, msgs); err != nil {
+			// Offsets are not committed, so the batch is consumed again after a restart
+			log.Println("Ingestor exited before the batch was written")
+			return
+		}
+
+		if err := reader.CommitMessages(ctx, msgs...); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to commit offsets: %v", err)
+		}
+	}
+}
+
+// fetchBatch reads up to size messages, returning early when the batch timeout expires after the first message
+func fetchBatch(ctx context.Context, reader *kafka.Reader, size int, timeout time.Duration) ([]kafka.Message, error) {
+	first, err := reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []kafka.Message{first}
+
+	batchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for len(msgs) < size {
+		msg, err := reader.FetchMessage(batchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// processBatch decodes and writes the runs of a batch of messages. Invalid messages are logged and skipped,
+// transient failures are retried with exponential backoff until the context is cancelled.
+func processBatch(ctx context.Context, processor *ingest.Processor, msgs []kafka.Message) error {
+	runs := make([]*models.AgentRun, 0, len(msgs))
+	offsets := make([]kafka.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		var run *models.AgentRun
+		err := retry(ctx, func() error {
+			var err error
+			run, err = processor.Decode(ctx, msg.Value)
+			return err
+		})
+		if err != nil {
+			if !errors.Is(err, ingest.ErrInvalidMessage) {
+				return err
+			}
+			log.Printf("Skipping message at partition %d offset %d: %v", msg.Partition, msg.Offset, err)
+			continue
+		}
+		runs = append(runs, run)
+		offsets = append(offsets, msg)
+	}
+
+	if len(runs) == 0 {
+		return nil
+	}
+
+	return retry(ctx, func() error {
+		rejected, err := processor.Write(ctx, runs)
+		for i, rejectErr := range rejected {
+			log.Printf("Skipping message at partition %d offset %d: %v", offsets[i].Partition, offsets[i].Offset, rejectErr)
+		}
+
+		// Only the runs that failed are written again, the others were stored or skipped
+		var writeErr *ingest.WriteError
+		if errors.As(err, &writeErr) {
+			failedRuns := make([]*models.AgentRun, len(writeErr.Failed))
+			failedOffsets := make([]kafka.Message, len(writeErr.Failed))
+			for j, i := range writeErr.Failed {
+				failedRuns[j], failedOffsets[j] = runs[i], offsets[i]
+			}
+			runs, offsets = failedRuns, failedOffsets
+		}
+		return err
+	})
+}
+
+// retry calls fn until it succeeds, fails with an invalid message error, or the context is cancelled
+func retry(ctx context.Context, fn func() error) error {
+	delay := initialRetryDelay
+	for {
+		err := fn()
+		if err == nil || errors.Is(err, ingest.ErrInvalidMessage) {
+			return err
+		}
+
+		log.Printf("Retrying in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
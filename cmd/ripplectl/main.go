@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/models"
+)
+
+const usage = `Usage: ripplectl [global flags] <command> [flags]
+
+Commands:
+  runs tail <agentId>    Print the most recent runs of an agent, -f to follow new runs
+
+Global flags:
+`
+
+func main() {
+	server := flag.String("server", envOr("RIPPLE_SERVER", "http://localhost:9999"), "Ripple server URL")
+	tenant := flag.String("tenant", os.Getenv("RIPPLE_TENANT"), "Tenant to use when the server runs in database-per-tenant mode")
+	token := flag.String("token", os.Getenv("RIPPLE_TOKEN"), "JWT to authenticate with when the server requires authentication")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	client := &Client{
+		server: strings.TrimRight(*server, "/"),
+		tenant: *tenant,
+		token:  *token,
+		http:   &http.Client{},
+	}
+
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] + " " + args[1] {
+	case "runs tail":
+		err = runsTail(client, args[2:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// runsTail implements `ripplectl runs tail <agentId> [-f] [-n count] [--status status] [--version version]`
+func runsTail(client *Client, args []string) error {
+	fs := flag.NewFlagSet("runs tail", flag.ExitOnError)
+	follow := fs.Bool("f", false, "Follow new runs as they are recorded")
+	count := fs.Int("n", 10, "Number of recent runs to print")
+	status := fs.String("status", "", "Only show runs with this status")
+	version := fs.String("version", "", "Only show runs of this agent version")
+
+	// Allow flags before or after the agent ID
+	var agentID string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		agentID, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if agentID == "" && fs.NArg() > 0 {
+		agentID = fs.Arg(0)
+	}
+	if agentID == "" {
+		return fmt.Errorf("missing agent ID")
+	}
+
+	filter := url.Values{}
+	if *status != "" {
+		filter.Set("status", *status)
+	}
+	if *version != "" {
+		filter.Set("version", *version)
+	}
+
+	if *count > 0 {
+		query := url.Values{"sort": {"recorded_at:desc"}, "limit": {strconv.Itoa(*count)}}
+		for key, values := range filter {
+			query[key] = values
+		}
+
+		var recent []models.AgentRun
+		if err := client.getJSON("/api/v1/agents/"+agentID+"/runs", query, &recent); err != nil {
+			return err
+		}
+		for i := len(recent) - 1; i >= 0; i-- {
+			printRun(recent[i])
+		}
+	}
+
+	if !*follow {
+		return nil
+	}
+
+	filter.Set("since", time.Now().UTC().Format(time.RFC3339))
+	return client.stream("/api/v1/agents/"+agentID+"/runs:tail", filter, func(run models.AgentRun) {
+		printRun(run)
+	})
+}
+
+// printRun prints a single run on one line
+func printRun(run models.AgentRun) {
+	fmt.Printf("%s  %-10s  %-10s  %8.2fs  $%.4f  run=%d task=%d\n",
+		run.Created.Format(time.RFC3339), run.Version, run.Status, run.TimeTaken, run.Cost, run.RunID, run.TaskID)
+}
+
+// Client is a minimal client for the ripple server API
+type Client struct {
+	server string
+	tenant string
+	token  string
+	http   *http.Client
+}
+
+// get performs a GET request against the server
+func (c *Client) get(path string, query url.Values, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if c.tenant != "" {
+		req.Header.Set("X-Ripple-Tenant", c.tenant)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(msg))
+	}
+
+	return resp, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response
+func (c *Client) getJSON(path string, query url.Values, out interface{}) error {
+	resp, err := c.get(path, query, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// stream reads a newline delimited JSON stream of runs until the connection is closed
+func (c *Client) stream(path string, query url.Values, fn func(models.AgentRun)) error {
+	resp, err := c.get(path, query, "application/x-ndjson")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var run models.AgentRun
+		if err := json.Unmarshal([]byte(line), &run); err != nil {
+			return fmt.Errorf("invalid run in stream: %w", err)
+		}
+		fn(run)
+	}
+
+	return scanner.Err()
+}
+
+// envOr returns the value of an environment variable, or the fallback when it is not set
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
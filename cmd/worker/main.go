@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"ripple/cache"
+	"ripple/clickhouse"
 	"ripple/db"
 	"ripple/models"
+	"ripple/reports"
+	"ripple/secrets"
+	"ripple/sqlstore"
 	"sync"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
 const (
@@ -18,43 +22,128 @@ const (
 
 func main() {
 	ctx := context.Background()
-	client, err := db.NewMongoDB(os.Getenv("MONGO_URL"), "agent_metrics")
+
+	timezones, err := db.ParseTimezones(os.Getenv("REPORTING_TIMEZONE"), os.Getenv("PROJECT_TIMEZONES"))
 	if err != nil {
-		log.Printf("Unable to connect to the Mongo store to read from %s", err)
+		log.Printf("Unable to parse the reporting timezones %s", err)
 		os.Exit(-1)
 	}
 
-	// Get a list of agent names and versions
-	agentCollectionCursor, err := client.Database.Collection("agents").Find(ctx, bson.M{})
+	// Agents, runs and metrics stored in a SQL database are aggregated there, SLOs, budgets and reports need MongoDB
+	if storage := os.Getenv("STORAGE"); storage != "" && storage != db.StorageMongo {
+		if err := runSQL(ctx, storage, os.Getenv("STORAGE_DSN"), timezones); err != nil {
+			log.Printf("Unable to aggregate metrics in %s %s", storage, err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	// The worker runs to completion, so credentials read from a secret manager are not refreshed
+	uri := os.Getenv("MONGO_URL")
+	if ref := os.Getenv("MONGO_SECRET"); ref != "" {
+		source, err := secrets.NewSource(ref)
+		if err != nil {
+			log.Printf("Invalid MongoDB secret %s", err)
+			os.Exit(-1)
+		}
+		if uri, err = secrets.ResolveMongoURI(ctx, source, uri); err != nil {
+			log.Printf("Unable to read the MongoDB secret %s", err)
+			os.Exit(-1)
+		}
+	}
+	client, err := db.NewMongoDB(uri, "agent_metrics", db.ClientOptions{})
 	if err != nil {
-		log.Printf("Unable to fetch agents %s", err)
+		log.Printf("Unable to connect to the Mongo store to read from %s", err)
 		os.Exit(-1)
 	}
-
-	agents := []*models.Agent{}
-	err = agentCollectionCursor.All(ctx, &agents)
+	timeouts, err := mongoTimeouts()
 	if err != nil {
-		log.Printf("Unable to fetch agents %s", err)
+		log.Printf("Invalid MongoDB timeouts %s", err)
+		os.Exit(-1)
+	}
+	client.SetTimeouts(timeouts)
+	// The metrics written invalidate those cached by the servers sharing the read cache
+	if url := os.Getenv("READ_CACHE_REDIS"); url != "" {
+		readCache, err := cache.NewRedis(url)
+		if err != nil {
+			log.Printf("Unable to connect to the read cache Redis %s", err)
+			os.Exit(-1)
+		}
+		defer readCache.Close()
+		client.SetReadCache(readCache, db.DefaultReadCacheTTL)
+	}
+	// Version metrics are computed on a second client reading from secondaries, off the primary ingestion writes to
+	if analytics := os.Getenv("MONGO_ANALYTICS"); analytics == "true" || analytics == "1" {
+		timeout := db.DefaultAnalyticsTimeout
+		if value := os.Getenv("MONGO_ANALYTICS_TIMEOUT"); value != "" {
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+				log.Printf("Invalid MONGO_ANALYTICS_TIMEOUT %q, expected a positive duration", value)
+				os.Exit(-1)
+			}
+		}
+		if err := client.ConnectAnalytics(uri, timeout); err != nil {
+			log.Printf("Unable to connect the Mongo analytics client %s", err)
+			os.Exit(-1)
+		}
+	}
+	homeRegion := os.Getenv("HOME_REGION")
+	if homeRegion == "" {
+		homeRegion = "home"
+	}
+	if _, err := db.ConnectRegions(client, homeRegion, os.Getenv("MONGO_REGIONS"), ""); err != nil {
+		log.Printf("Unable to connect to the regions %s", err)
 		os.Exit(-1)
 	}
 
-	agentToAgentIDLookup := make(map[string]*models.Agent, len(agents))
-	for _, a := range agents {
-		agentToAgentIDLookup[string(a.ID.Hex())] = a
+	// Runs stored in ClickHouse are aggregated there, SLOs, budgets and reports read the runs in MongoDB
+	if url := os.Getenv("CLICKHOUSE_URL"); url != "" {
+		if err := runClickHouse(ctx, url, client, timezones); err != nil {
+			log.Printf("Unable to aggregate metrics in ClickHouse %s", err)
+			os.Exit(-1)
+		}
+		return
 	}
 
-	// Get all agent versions in the collection
-	agentVersionsCursor, err := client.Database.Collection("agent_versions").Find(ctx, bson.M{})
-	if err != nil {
-		log.Printf("Unable to fetch agent versions %s", err)
-		os.Exit(-1)
+	// Collect the databases to aggregate, one per tenant when running in database-per-tenant, org or org-database mode
+	databases := map[string]*db.MongoDB{"": client}
+	if mode := os.Getenv("TENANT_MODE"); mode == db.TenantModeDatabase || mode == db.TenantModeOrg || mode == db.TenantModeOrgDatabase {
+		prefix := os.Getenv("TENANT_DB_PREFIX")
+		if prefix == "" {
+			prefix = "ripple_"
+		}
+
+		router := db.NewTenantRouter(client, prefix)
+		if mode == db.TenantModeOrg {
+			router = db.NewOrgRouter(client)
+		} else if mode == db.TenantModeOrgDatabase {
+			router = db.NewOrgDatabaseRouter(client, prefix)
+		}
+		tenants, err := router.Tenants(ctx)
+		if err != nil {
+			log.Printf("Unable to list tenant databases %s", err)
+			os.Exit(-1)
+		}
+
+		databases = make(map[string]*db.MongoDB, len(tenants))
+		for _, tenant := range tenants {
+			databases[tenant], err = router.Database(ctx, tenant)
+			if err != nil {
+				log.Printf("Unable to select the database of tenant %s %s", tenant, err)
+				os.Exit(-1)
+			}
+		}
 	}
+	// The data pinned to other regions is aggregated in their clusters
+	databases = db.InRegions(databases)
 
-	agentVersions := []*models.AgentVersion{}
-	err = agentVersionsCursor.All(ctx, &agentVersions)
-	if err != nil {
-		log.Printf("Unable to fetch agent versions %s", err)
-		os.Exit(-1)
+	// Scheduled reports are only delivered when an SMTP server is configured
+	var mailer *reports.Mailer
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mailer, err = reports.NewMailer(addr, os.Getenv("SMTP_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		if err != nil {
+			log.Printf("Unable to configure the SMTP server %s", err)
+			os.Exit(-1)
+		}
 	}
 
 	// Get filtered runs per agent version. Use go routines, one per agent versions
@@ -64,20 +153,56 @@ func main() {
 
 	wg := sync.WaitGroup{}
 	for i := 0; i < workerPoolSize; i++ {
-		go worker(ctx, client, workChan, &wg)
+		go worker(ctx, workChan, &wg)
 	}
 
-	for _, av := range agentVersions {
-		w := Work{
-			agent:        agentToAgentIDLookup[string(av.AgentID.Hex())],
-			agentVersion: av,
+	for tenant, database := range databases {
+		metricsRepo := db.NewMetricsRepository(database.Analytics())
+		if migrated, err := metricsRepo.EnsureMetricsSchema(ctx); err != nil {
+			log.Printf("Unable to prepare the metrics collection for tenant %q %s", tenant, err)
+			if tenant == "" {
+				os.Exit(-1)
+			}
+			continue
+		} else if migrated > 0 {
+			log.Printf("Migrated %d metrics documents to the composite key for tenant %q", migrated, tenant)
 		}
 
-		wg.Add(1)
-		workChan <- &w
+		if err := enqueue(ctx, metricsRepo, timezones, workChan, &wg); err != nil {
+			log.Printf("Unable to aggregate metrics for tenant %q %s", tenant, err)
+			if tenant == "" {
+				os.Exit(-1)
+			}
+		}
+
+		if err := computeSLOs(ctx, db.NewSLORepository(database)); err != nil {
+			log.Printf("Unable to compute SLO statuses for tenant %q %s", tenant, err)
+		}
+
+		if err := computeBudgets(ctx, db.NewBudgetRepository(database), timezones); err != nil {
+			log.Printf("Unable to compute budget statuses for tenant %q %s", tenant, err)
+		}
+
+		if mailer != nil {
+			if err := reports.NewScheduler(db.NewReportRepository(database), mailer).RunDue(ctx, time.Now()); err != nil {
+				log.Printf("Unable to deliver reports for tenant %q %s", tenant, err)
+			}
+		}
+
+		if err := rollUp(ctx, db.NewRollupRepository(database)); err != nil {
+			log.Printf("Unable to roll up the runs of tenant %q %s", tenant, err)
+		}
 	}
 
 	wg.Wait()
+
+	// Raw runs and steps past the retention of their project are deleted once the metrics and rollups aggregating
+	// them are stored
+	for tenant, database := range databases {
+		if err := applyRetentionPolicies(ctx, db.NewRetentionPolicyRepository(database)); err != nil {
+			log.Printf("Unable to apply the retention policies of tenant %q %s", tenant, err)
+		}
+	}
 	/* Run aggregate queries to compute all elements that need to be aggregated
 	# of runs
 	Last seen time
@@ -90,12 +215,189 @@ func main() {
 
 }
 
+// runSQL aggregates the metrics of the agent versions of a SQL storage backend
+func runSQL(ctx context.Context, storage, dsn string, timezones *db.Timezones) error {
+	store, err := sqlstore.Open(storage, dsn)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return aggregate(ctx, store, timezones)
+}
+
+// runClickHouse aggregates the metrics of the agent versions of a MongoDB database whose runs are stored in
+// ClickHouse
+func runClickHouse(ctx context.Context, url string, mongodb *db.MongoDB, timezones *db.Timezones) error {
+	store, err := clickhouse.Open(url, mongodb)
+	if err != nil {
+		return err
+	}
+
+	return aggregate(ctx, store.Metrics(), timezones)
+}
+
+// aggregate prepares the metrics schema of a store and aggregates the metrics of all its agent versions
+func aggregate(ctx context.Context, metricsRepo db.MetricsStore, timezones *db.Timezones) error {
+	if _, err := metricsRepo.EnsureMetricsSchema(ctx); err != nil {
+		return err
+	}
+
+	workChan := make(chan *Work)
+	defer close(workChan)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workerPoolSize; i++ {
+		go worker(ctx, workChan, &wg)
+	}
+	err := enqueue(ctx, metricsRepo, timezones, workChan, &wg)
+	wg.Wait()
+	return err
+}
+
 type Work struct {
+	metricsRepo  db.MetricsStore
 	agent        *models.Agent
 	agentVersion *models.AgentVersion
+	timezones    *db.Timezones
+}
+
+// enqueue sends a unit of work for every agent version of a database
+func enqueue(ctx context.Context, metricsRepo db.MetricsStore, timezones *db.Timezones, workChan chan *Work, wg *sync.WaitGroup) error {
+	// Get a list of agent names and versions
+	agents, err := metricsRepo.ListAllAgents(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch agents: %w", err)
+	}
+
+	agentToAgentIDLookup := make(map[string]*models.Agent, len(agents))
+	for _, a := range agents {
+		agentToAgentIDLookup[string(a.ID.Hex())] = a
+	}
+
+	// Get all agent versions in the collection
+	agentVersions, err := metricsRepo.ListAllAgentVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch agent versions: %w", err)
+	}
+
+	for _, av := range agentVersions {
+		agent := agentToAgentIDLookup[string(av.AgentID.Hex())]
+		// Archived agents are not aggregated
+		if agent != nil && agent.Archived {
+			continue
+		}
+
+		w := Work{
+			metricsRepo:  metricsRepo,
+			agent:        agent,
+			agentVersion: av,
+			timezones:    timezones,
+		}
+
+		wg.Add(1)
+		workChan <- &w
+	}
+
+	return nil
+}
+
+// computeSLOs computes and stores the compliance and burn rate of every SLO of a database
+func computeSLOs(ctx context.Context, sloRepo *db.SLORepository) error {
+	slos, err := sloRepo.ListAllSLOs(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch SLOs: %w", err)
+	}
+
+	now := time.Now()
+	for i := range slos {
+		slo := &slos[i]
+		status, err := sloRepo.ComputeSLOStatus(ctx, slo, now)
+		if err != nil {
+			log.Printf("Unable to compute the status of SLO %s of agent %s. Error is %s", slo.ID.Hex(), slo.AgentID.Hex(), err)
+			continue
+		}
+
+		if err := sloRepo.UpsertSLOStatus(ctx, status); err != nil {
+			log.Printf("Unable to insert the status of SLO %s. Error is %s", slo.ID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// computeBudgets computes and stores the spend of every budget of a database over its current period
+func computeBudgets(ctx context.Context, budgetRepo *db.BudgetRepository, timezones *db.Timezones) error {
+	budgets, err := budgetRepo.ListAllBudgets(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch budgets: %w", err)
+	}
+
+	now := time.Now()
+	for i := range budgets {
+		budget := &budgets[i]
+
+		// Budget periods start at midnight in the reporting timezone of the budget's project
+		project, err := budgetRepo.BudgetProject(ctx, budget)
+		if err != nil {
+			log.Printf("Unable to fetch the project of budget %s. Error is %s", budget.ID.Hex(), err)
+			continue
+		}
+
+		status, err := budgetRepo.ComputeBudgetStatus(ctx, budget, now.In(timezones.For(project)))
+		if err != nil {
+			log.Printf("Unable to compute the status of budget %s. Error is %s", budget.ID.Hex(), err)
+			continue
+		}
+
+		if err := budgetRepo.UpsertBudgetStatus(ctx, status); err != nil {
+			log.Printf("Unable to insert the status of budget %s. Error is %s", budget.ID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// rollUp writes the hourly and daily rollups of the runs of a database created since its previous roll up
+func rollUp(ctx context.Context, rollupRepo *db.RollupRepository) error {
+	if err := rollupRepo.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+
+	written, err := rollupRepo.RollUp(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("unable to write rollups: %w", err)
+	}
+	if written > 0 {
+		log.Printf("Wrote %d rollups", written)
+	}
+	return nil
+}
+
+// applyRetentionPolicies deletes the runs and steps of the projects of a database past their retention policies
+func applyRetentionPolicies(ctx context.Context, retentionRepo *db.RetentionPolicyRepository) error {
+	policies, err := retentionRepo.ListRetentionPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to fetch retention policies: %w", err)
+	}
+
+	now := time.Now()
+	for i := range policies {
+		retention := &policies[i]
+		runs, steps, err := retentionRepo.ApplyRetention(ctx, retention, now)
+		if err != nil {
+			log.Printf("Unable to apply the retention policy of project %s. Error is %s", retention.Project, err)
+			continue
+		}
+		if runs > 0 || steps > 0 {
+			log.Printf("Deleted %d runs and %d steps of project %s past its retention policy", runs, steps, retention.Project)
+		}
+	}
+
+	return nil
 }
 
-func worker(ctx context.Context, client *db.MongoDB, workChan chan *Work, wg *sync.WaitGroup) {
+func worker(ctx context.Context, workChan chan *Work, wg *sync.WaitGroup) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -104,109 +406,16 @@ func worker(ctx context.Context, client *db.MongoDB, workChan chan *Work, wg *sy
 			return
 		case work := <-workChan:
 			agentVersion := work.agentVersion
-			count, err := client.Database.Collection("agent_runs").CountDocuments(ctx, bson.M{"version_id": agentVersion.ID})
+			metricsRepo := work.metricsRepo
+			now := time.Now().In(work.timezones.For(work.agent.Project))
+			avm, err := metricsRepo.ComputeAgentVersionMetrics(ctx, work.agent, agentVersion, now)
 			if err != nil {
-				log.Printf("Unable to fetch number of runs for the agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, err)
+				log.Printf("Unable to compute metrics for the agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, err)
 				wg.Done()
 				continue
 			}
 
-			// Last seen time
-			res := client.Database.Collection("agent_runs").FindOne(ctx, bson.M{"version_id": agentVersion.ID}, &options.FindOneOptions{
-				Sort: bson.M{
-					"recorded_at": -1,
-				},
-			})
-			if res.Err() != nil {
-				log.Printf("Unable to fetch last seen time for the agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, res.Err())
-				wg.Done()
-				continue
-			}
-
-			lastRecord := models.AgentRun{}
-			err = res.Decode(&lastRecord)
-			if err != nil {
-				log.Printf("Unable to fetch last seem time for  agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, err)
-				wg.Done()
-				continue
-			}
-
-			// Count total errors
-			countErrors, err := client.Database.Collection("agent_runs").CountDocuments(ctx, bson.M{"version_id": agentVersion.ID, "status": "error"})
-			if err != nil {
-				log.Printf("Unable to fetch number of runs for the agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, err)
-				wg.Done()
-				continue
-			}
-
-			//Average Time Taken and Total Cost
-			pipeline := []bson.M{
-				{
-					"$match": bson.M{
-						"version_id": agentVersion.ID,
-					},
-				},
-				{
-					"$group": bson.M{
-						"_id": nil,
-						"avgTimeTaken": bson.M{
-							"$avg": "$time_taken",
-						},
-						"totalCost": bson.M{
-							"$sum": "$cost",
-						},
-					},
-				},
-			}
-
-			cursor, err := client.Database.Collection("agent_runs").Aggregate(ctx, pipeline)
-			if err != nil {
-				log.Printf("Unable to fetch metrics for the agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, err)
-				wg.Done()
-				continue
-			}
-
-			var results []bson.M
-			if err = cursor.All(ctx, &results); err != nil {
-				log.Printf("Unable to decode metrics for the agent with ID %s and version %s. Error is %s", agentVersion.AgentID, agentVersion.Version, err)
-				wg.Done()
-				continue
-			}
-
-			var avgTimeTaken float64
-			var totalCost float64
-
-			if len(results) > 0 {
-				if val, ok := results[0]["avgTimeTaken"].(float64); ok {
-					avgTimeTaken = val
-				}
-				if val, ok := results[0]["totalCost"].(float64); ok {
-					totalCost = val
-				}
-			}
-
-			avm := models.AgentVersionMetrics{
-				Id:             agentVersion.ID,
-				Name:           work.agent.Name,
-				Project:        work.agent.Project,
-				Status:         agentVersion.Status,
-				LastSeen:       lastRecord.RecordedAt,
-				Version:        agentVersion.Version,
-				AverageRunTime: avgTimeTaken,
-				SuccessRate:    (float64(count-countErrors) / float64(count)) * 100,
-				TotalRuns:      count,
-				Spend:          totalCost,
-				Tools:          agentVersion.Tools,
-				Models:         agentVersion.Models,
-				Cluster:        agentVersion.Cluster,
-			}
-			upsert := true
-			updateDoc := bson.M{
-				"$set": avm,
-			}
-			_, err = client.Database.Collection("agent_version_metrics").UpdateOne(ctx, bson.M{"_id": agentVersion.ID}, updateDoc, &options.UpdateOptions{
-				Upsert: &upsert,
-			})
+			err = metricsRepo.UpsertAgentVersionMetrics(ctx, avm)
 			if err != nil {
 				log.Printf("Unable to insert metric record for agent %s. Error is %s", work.agent.Name, err)
 				wg.Done()
@@ -217,3 +426,25 @@ func worker(ctx context.Context, client *db.MongoDB, workChan chan *Work, wg *sy
 		}
 	}
 }
+
+// mongoTimeouts returns the timeouts of the MongoDB operations, read from the MONGO_READ_TIMEOUT,
+// MONGO_WRITE_TIMEOUT and MONGO_AGGREGATE_TIMEOUT environment variables, the defaults when unset
+func mongoTimeouts() (db.Timeouts, error) {
+	timeouts := db.DefaultTimeouts
+	for name, timeout := range map[string]*time.Duration{
+		"MONGO_READ_TIMEOUT":      &timeouts.Read,
+		"MONGO_WRITE_TIMEOUT":     &timeouts.Write,
+		"MONGO_AGGREGATE_TIMEOUT": &timeouts.Aggregate,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return timeouts, fmt.Errorf("%s must be a positive duration, got %q", name, value)
+		}
+		*timeout = d
+	}
+	return timeouts, nil
+}
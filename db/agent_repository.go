@@ -3,8 +3,11 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"time"
 
+	"ripple/events"
 	"ripple/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,27 +18,174 @@ import (
 
 // AgentRepository handles database operations for agents
 type AgentRepository struct {
-	db         *MongoDB
-	agents     *mongo.Collection
-	versions   *mongo.Collection
-	runs       *mongo.Collection
-	timeoutSec int
+	db          *MongoDB
+	agents      collection
+	versions    collection
+	runs        collection
+	steps       collection
+	tokens      collection
+	teams       collection
+	redactions  collection
+	rollups     collection
+	rollupState collection
+	timeouts    Timeouts
 }
 
 // NewAgentRepository creates a new agent repository
 func NewAgentRepository(db *MongoDB) *AgentRepository {
 	return &AgentRepository{
-		db:         db,
-		agents:     db.Database.Collection("agents"),
-		versions:   db.Database.Collection("agent_versions"),
-		runs:       db.Database.Collection("agent_runs"),
-		timeoutSec: 10,
+		db:          db,
+		agents:      db.Collection("agents"),
+		versions:    db.Collection("agent_versions"),
+		runs:        db.Collection("agent_runs"),
+		steps:       db.Collection("run_steps"),
+		tokens:      db.Collection("ingest_tokens"),
+		teams:       db.Collection("teams"),
+		redactions:  db.ForScope(Scope{OrgID: db.OrgID}).Collection("redaction_rules"),
+		rollups:     db.Collection("rollups"),
+		rollupState: db.Collection("rollup_state"),
+		timeouts:    db.Timeouts(),
 	}
 }
 
+// EnsureIndexes creates the indexes the dashboard and the runs API query agents, versions and runs with, and the
+// unique indexes of the names of agents, within an organization, of the versions of an agent, and of the run IDs of
+// the runs of a version
+func (r *AgentRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.agents.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the agent name index: %w", err)
+	}
+	_, err = r.versions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "agent_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the agent version index: %w", err)
+	}
+	_, err = r.runs.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "version_id", Value: 1}, {Key: "created", Value: 1}}},
+		{Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "created", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the run indexes: %w", err)
+	}
+
+	// Time-series collections have no unique indexes, their duplicate runs are only skipped by batch inserts, and
+	// neither do the runs sharded with a key the unique run ID index can not be prefixed with
+	if r.db.timeSeriesRuns {
+		return nil
+	}
+	keys, unique := uniqueRunIndex(r.db.runsShardKey)
+	if !unique {
+		return nil
+	}
+	_, err = r.runs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: keys,
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"run_id": bson.M{"$gt": 0}}),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// The runs stored before the index may hold duplicates, which must not keep the server from starting
+		log.Printf("Unable to create the unique run ID index, runs are deduplicated once the duplicate runs are deleted: %v", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create the unique run ID index: %w", err)
+	}
+	return nil
+}
+
+// MigrateTimeTaken rewrites the time_taken of the runs stored as a string, such as "2.35s" or "1m30s", to a number
+// of seconds, in batches, returning how many runs were rewritten. Values are parsed with models.ParseSeconds, as
+// ingest parses them, and values that do not parse are left unchanged and logged. It is idempotent, and a migration
+// stopped midway is resumed by the next one.
+func (r *AgentRepository) MigrateTimeTaken(ctx context.Context) (int64, error) {
+	if r.db.timeSeriesRuns {
+		// Time-series runs were always stored through the model, with a numeric time_taken
+		return 0, nil
+	}
+
+	var migrated, invalid int64
+	var after interface{}
+	for {
+		runs, err := r.stringTimeTakenRuns(ctx, after)
+		if err != nil || len(runs) == 0 {
+			if invalid > 0 {
+				log.Printf("Left the time taken of %d runs unchanged, it is not a number of seconds or a duration", invalid)
+			}
+			return migrated, err
+		}
+		after = runs[len(runs)-1].ID
+
+		updates := []mongo.WriteModel{}
+		for _, run := range runs {
+			seconds, err := models.ParseSeconds(run.TimeTaken)
+			if err != nil {
+				invalid++
+				continue
+			}
+			updates = append(updates, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": run.ID, "time_taken": run.TimeTaken}).
+				SetUpdate(bson.M{"$set": bson.M{"time_taken": seconds}}))
+		}
+		if len(updates) == 0 {
+			continue
+		}
+
+		updateCtx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+		result, err := r.runs.BulkWrite(updateCtx, updates, options.BulkWrite().SetOrdered(false))
+		cancel()
+		if err != nil {
+			return migrated, err
+		}
+		migrated += result.ModifiedCount
+	}
+}
+
+// stringTimeTaken is the time_taken of a run stored as a string
+type stringTimeTaken struct {
+	ID        interface{} `bson:"_id"`
+	TimeTaken string      `bson:"time_taken"`
+}
+
+// stringTimeTakenRuns returns a batch of runs whose time_taken is a string, in the order of their IDs, after the
+// run of ID after when not nil
+func (r *AgentRepository) stringTimeTakenRuns(ctx context.Context, after interface{}) ([]stringTimeTaken, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	filter := bson.M{"time_taken": bson.M{"$type": "string"}}
+	if after != nil {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "time_taken": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(purgeBatchSize)
+	cursor, err := r.runs.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []stringTimeTaken{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
 // CreateAgent creates a new agent in the database
-func (r *AgentRepository) CreateAgent(agent *models.Agent) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) CreateAgent(ctx context.Context, agent *models.Agent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
 	defer cancel()
 
 	// Check if agent with the same name already exists
@@ -52,20 +202,24 @@ func (r *AgentRepository) CreateAgent(agent *models.Agent) error {
 	agent.CreatedAt = now
 	agent.UpdatedAt = now
 
-	// Insert the agent
+	// Insert the agent, the unique index catching an agent of the same name created concurrently
 	result, err := r.agents.InsertOne(ctx, agent)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("agent with this name already exists")
+		}
 		return err
 	}
 
 	// Set the ID from the insert result
 	agent.ID = result.InsertedID.(primitive.ObjectID)
+	r.db.invalidateReads(ctx, agentsGeneration)
 	return nil
 }
 
 // GetAgentByID retrieves an agent by ID
-func (r *AgentRepository) GetAgentByID(id primitive.ObjectID) (*models.Agent, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) GetAgentByID(ctx context.Context, id primitive.ObjectID) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
 	var agent models.Agent
@@ -81,8 +235,8 @@ func (r *AgentRepository) GetAgentByID(id primitive.ObjectID) (*models.Agent, er
 }
 
 // GetAgentByName retrieves an agent by name
-func (r *AgentRepository) GetAgentByName(name string) (*models.Agent, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) GetAgentByName(ctx context.Context, name string) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
 	var agent models.Agent
@@ -98,32 +252,82 @@ func (r *AgentRepository) GetAgentByName(name string) (*models.Agent, error) {
 }
 
 // ListAgents retrieves all agents
-func (r *AgentRepository) ListAgents() ([]models.Agent, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) ListAgents(ctx context.Context, listOpts ListOptions) ([]models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
-	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
-	cursor, err := r.agents.Find(ctx, bson.M{}, opts)
+	// Signing secrets are left out of lists, which may be cached outside of the database
+	opts := options.Find().SetSort(sortDocument(models.Agent{}, listOpts.Sort, bson.D{{Key: "name", Value: 1}}))
+	opts.SetProjection(bson.M{"signing_secret": 0})
+	if proj := projection(models.Agent{}, listOpts.Fields); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if listOpts.Limit > 0 {
+		opts.SetLimit(listOpts.Limit)
+	}
+	query := bson.M{}
+	if !listOpts.IncludeArchived {
+		query["archived"] = bson.M{"$ne": true}
+	}
+	if listOpts.Project != "" {
+		query["project"] = listOpts.Project
+	}
+	if listOpts.Team != "" {
+		query["team"] = listOpts.Team
+	}
+	var list struct {
+		Agents []models.Agent `bson:"agents"`
+	}
+	err := r.db.cachedRead(ctx, agentsGeneration, fmt.Sprintf("%+v", listOpts), &list, func() error {
+		cursor, err := r.agents.Find(ctx, query, opts)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, &list.Agents)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	var agents []models.Agent
-	if err := cursor.All(ctx, &agents); err != nil {
+	return list.Agents, nil
+}
+
+// SetAgentArchived archives or unarchives an agent and returns the updated agent
+func (r *AgentRepository) SetAgentArchived(ctx context.Context, id primitive.ObjectID, archived bool) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"archived": archived, "archived_at": now, "updated_at": now}}
+	if !archived {
+		update = bson.M{
+			"$set":   bson.M{"archived": false, "updated_at": now},
+			"$unset": bson.M{"archived_at": ""},
+		}
+	}
+
+	var agent models.Agent
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.agents.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&agent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("agent not found")
+		}
 		return nil, err
 	}
+	r.db.invalidateReads(ctx, agentsGeneration)
 
-	return agents, nil
+	return &agent, nil
 }
 
 // CreateAgentVersion creates a new agent version
-func (r *AgentRepository) CreateAgentVersion(version *models.AgentVersion) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) CreateAgentVersion(ctx context.Context, version *models.AgentVersion) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
 	defer cancel()
 
 	// Check if agent exists
-	_, err := r.GetAgentByID(version.AgentID)
+	_, err := r.GetAgentByID(ctx, version.AgentID)
 	if err != nil {
 		return err
 	}
@@ -145,29 +349,46 @@ func (r *AgentRepository) CreateAgentVersion(version *models.AgentVersion) error
 	version.CreatedAt = now
 	version.UpdatedAt = now
 
-	// Insert the version
+	// Insert the version, the unique index catching the same version created concurrently
 	result, err := r.versions.InsertOne(ctx, version)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("version already exists for this agent")
+		}
 		return err
 	}
 
 	// Set the ID from the insert result
 	version.ID = result.InsertedID.(primitive.ObjectID)
+	r.db.invalidateReads(ctx, versionsGeneration(version.AgentID))
+
+	r.db.Events.Publish(events.Event{
+		Type:     events.VersionRegistered,
+		Database: r.db.Name(),
+		Org:      r.db.OrgID,
+		Version:  version,
+	})
 	return nil
 }
 
 // GetAgentVersions retrieves all versions for an agent
-func (r *AgentRepository) GetAgentVersions(agentID primitive.ObjectID) ([]models.AgentVersion, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) GetAgentVersions(ctx context.Context, agentID primitive.ObjectID, listOpts ListOptions) ([]models.AgentVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
 	// Check if agent exists
-	_, err := r.GetAgentByID(agentID)
+	_, err := r.GetAgentByID(ctx, agentID)
 	if err != nil {
 		return nil, err
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	opts := options.Find().SetSort(sortDocument(models.AgentVersion{}, listOpts.Sort, bson.D{{Key: "version", Value: -1}}))
+	if proj := projection(models.AgentVersion{}, listOpts.Fields); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if listOpts.Limit > 0 {
+		opts.SetLimit(listOpts.Limit)
+	}
 	cursor, err := r.versions.Find(ctx, bson.M{"agent_id": agentID}, opts)
 	if err != nil {
 		return nil, err
@@ -183,15 +404,17 @@ func (r *AgentRepository) GetAgentVersions(agentID primitive.ObjectID) ([]models
 }
 
 // GetAgentVersion retrieves a specific version for an agent
-func (r *AgentRepository) GetAgentVersion(agentID primitive.ObjectID, version string) (*models.AgentVersion, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) GetAgentVersion(ctx context.Context, agentID primitive.ObjectID, version string) (*models.AgentVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
 	var agentVersion models.AgentVersion
-	err := r.versions.FindOne(ctx, bson.M{
-		"agent_id": agentID,
-		"version":  version,
-	}).Decode(&agentVersion)
+	err := r.db.cachedRead(ctx, versionsGeneration(agentID), version, &agentVersion, func() error {
+		return r.versions.FindOne(ctx, bson.M{
+			"agent_id": agentID,
+			"version":  version,
+		}).Decode(&agentVersion)
+	})
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, errors.New("version not found for this agent")
@@ -203,93 +426,331 @@ func (r *AgentRepository) GetAgentVersion(agentID primitive.ObjectID, version st
 }
 
 // CreateAgentRun creates a new agent run
-func (r *AgentRepository) CreateAgentRun(run *models.AgentRun) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) CreateAgentRun(ctx context.Context, run *models.AgentRun) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
 	defer cancel()
 
 	// Check if agent exists
-	_, err := r.GetAgentByID(run.AgentID)
+	agent, err := r.GetAgentByID(ctx, run.AgentID)
 	if err != nil {
 		return err
 	}
 
 	// Check if version exists
-	version, err := r.GetAgentVersion(run.AgentID, run.Version)
+	version, err := r.GetAgentVersion(ctx, run.AgentID, run.Version)
 	if err != nil {
 		return err
 	}
 	run.VersionID = version.ID
 
+	// Redact personal data with the rules of the agent's project
+	redaction, err := r.runRedaction(ctx, agent.Project)
+	if err != nil {
+		return err
+	}
+	redaction.Run(run)
+
 	// Set recorded timestamp
 	run.RecordedAt = time.Now()
 
 	// Insert the run
 	result, err := r.runs.InsertOne(ctx, run)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errDuplicateRun
+		}
+		if isValidationFailure(err) {
+			return errInvalidRun
+		}
 		return err
 	}
 
 	// Set the ID from the insert result
 	run.ID = result.InsertedID.(primitive.ObjectID)
+
+	r.PublishRunCreated(run)
 	return nil
 }
 
-// CreateAgentRunBatch creates multiple agent runs in a batch
-func (r *AgentRepository) CreateAgentRunBatch(runs []*models.AgentRun) error {
+// CreateAgentRunBatch creates multiple agent runs in a batch. An ordered batch is stored in a transaction when the
+// database supports them, so that either all or none of its runs are; otherwise it stops at its first run that can
+// not be inserted. An unordered batch stores every run that can be. Runs whose run ID is already stored for their
+// version, or repeats the run ID of an earlier run of the batch, are skipped as conflicts without failing the
+// batch. Runs that were not stored while others were are reported by a *BatchError.
+func (r *AgentRepository) CreateAgentRunBatch(ctx context.Context, runs []*models.AgentRun, opts BatchOptions) error {
 	if len(runs) == 0 {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec*2)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 2*r.timeouts.Write)
 	defer cancel()
 
-	// Check if agent exists (using the first run's agent ID)
-	_, err := r.GetAgentByID(runs[0].AgentID)
+	var stored []*models.AgentRun
+	var err error
+	if !opts.Unordered && r.db.SupportsTransactions() {
+		stored, err = r.insertRunsInTransaction(ctx, runs, !opts.Redacted)
+	} else {
+		stored, err = r.insertRuns(ctx, runs, !opts.Unordered, !opts.Redacted)
+	}
+
+	for _, run := range stored {
+		r.PublishRunCreated(run)
+	}
+	return err
+}
+
+// insertRunsInTransaction looks the versions and the stored run IDs of a batch of runs up and inserts the runs in a
+// transaction, returning the stored runs. The runs are redacted unless redact is false.
+func (r *AgentRepository) insertRunsInTransaction(ctx context.Context, runs []*models.AgentRun, redact bool) ([]*models.AgentRun, error) {
+	session, err := r.db.Client().StartSession()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer session.EndSession(ctx)
 
-	// Process each run to set version ID and recorded timestamp
-	documents := make([]interface{}, len(runs))
-	now := time.Now()
+	var failed map[int]error
+	var stored []*models.AgentRun
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var err error
+		if failed, err = r.prepareRuns(sc, runs, false, redact); err != nil {
+			return nil, err
+		}
+		if err := r.skipDuplicateRuns(sc, runs, failed); err != nil {
+			return nil, err
+		}
+		indexes, documents := pendingRuns(runs, failed)
+		if len(documents) == 0 {
+			stored = nil
+			return nil, nil
+		}
 
-	for i, run := range runs {
-		// Check if version exists
-		version, err := r.GetAgentVersion(run.AgentID, run.Version)
+		result, err := r.runs.InsertMany(sc, documents)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		run.VersionID = version.ID
-		run.RecordedAt = now
-		documents[i] = run
+		stored = insertedRuns(runs, indexes, result, failed)
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Insert all runs in a single batch operation
-	result, err := r.runs.InsertMany(ctx, documents)
+	return stored, insertBatchError(nil, nil, failed, true)
+}
+
+// insertRuns looks the versions and the stored run IDs of a batch of runs up, then inserts the runs, returning the
+// stored runs. An ordered batch fails when a version is not found, and stops at its first run that can not be
+// inserted. An unordered batch skips the runs whose version is not found, and inserts the others. The runs are
+// redacted unless redact is false.
+func (r *AgentRepository) insertRuns(ctx context.Context, runs []*models.AgentRun, ordered, redact bool) ([]*models.AgentRun, error) {
+	failed, err := r.prepareRuns(ctx, runs, !ordered, redact)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := r.skipDuplicateRuns(ctx, runs, failed); err != nil {
+		return nil, err
 	}
 
-	// Set the IDs from the insert result
-	for i, id := range result.InsertedIDs {
-		runs[i].ID = id.(primitive.ObjectID)
+	indexes, documents := pendingRuns(runs, failed)
+	if len(documents) == 0 {
+		return nil, insertBatchError(nil, indexes, failed, ordered)
+	}
+
+	// Insert all runs in a single batch operation
+	result, err := r.runs.InsertMany(ctx, documents, options.InsertMany().SetOrdered(ordered))
+	if err = insertBatchError(err, indexes, failed, ordered); err != nil {
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) || result == nil {
+			return nil, err
+		}
+	}
+
+	return insertedRuns(runs, indexes, result, failed), err
+}
+
+// skipDuplicateRuns adds the runs of a batch whose run ID is already stored for their version, or repeats the run
+// ID of an earlier run of the batch, to the failed runs as conflicts. Runs without a run ID are never duplicates.
+// The unique run ID index rejects the duplicates stored concurrently.
+func (r *AgentRepository) skipDuplicateRuns(ctx context.Context, runs []*models.AgentRun, failed map[int]error) error {
+	byVersion := map[primitive.ObjectID][]int{}
+	for i, run := range runs {
+		if _, ok := failed[i]; !ok && run.RunID != 0 {
+			byVersion[run.VersionID] = append(byVersion[run.VersionID], i)
+		}
+	}
+
+	for versionID, indexes := range byVersion {
+		runIDs := make([]int64, len(indexes))
+		for j, i := range indexes {
+			runIDs[j] = runs[i].RunID
+		}
+		stored, err := r.runs.Distinct(ctx, "run_id", bson.M{
+			"agent_id":   runs[indexes[0]].AgentID,
+			"version_id": versionID,
+			"run_id":     bson.M{"$in": runIDs},
+		})
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[int64]bool, len(indexes)+len(stored))
+		for _, id := range stored {
+			switch v := id.(type) {
+			case int64:
+				seen[v] = true
+			case int32:
+				seen[int64(v)] = true
+			}
+		}
+		for _, i := range indexes {
+			if seen[runs[i].RunID] {
+				failed[i] = errDuplicateRun
+			}
+			seen[runs[i].RunID] = true
+		}
 	}
 
 	return nil
 }
 
+// pendingRuns returns the runs of a batch that did not fail, as documents to insert, and their indexes in the batch
+func pendingRuns(runs []*models.AgentRun, failed map[int]error) ([]int, []interface{}) {
+	indexes := make([]int, 0, len(runs))
+	documents := make([]interface{}, 0, len(runs))
+	for i, run := range runs {
+		if _, ok := failed[i]; !ok {
+			indexes = append(indexes, i)
+			documents = append(documents, run)
+		}
+	}
+	return indexes, documents
+}
+
+// insertedRuns sets the IDs of the runs of a batch inserted as documents from the insert result, and returns them
+func insertedRuns(runs []*models.AgentRun, indexes []int, result *mongo.InsertManyResult, failed map[int]error) []*models.AgentRun {
+	stored := make([]*models.AgentRun, 0, len(indexes))
+	for j, id := range result.InsertedIDs {
+		if _, ok := failed[indexes[j]]; !ok {
+			runs[indexes[j]].ID = id.(primitive.ObjectID)
+			stored = append(stored, runs[indexes[j]])
+		}
+	}
+	return stored
+}
+
+// PrepareRuns readies a batch of runs to be stored: it checks that the agent and the version of every run exist, sets
+// their version IDs and recorded time, and redacts each with the rules of the project of its agent
+func (r *AgentRepository) PrepareRuns(ctx context.Context, runs []*models.AgentRun) error {
+	_, err := r.prepareRuns(ctx, runs, false, true)
+	return err
+}
+
+// prepareRuns readies a batch of runs to be stored like PrepareRuns, redacting them unless redact is false. With
+// skipMissing, the runs whose agent or version is not found are returned by index rather than failing the batch.
+func (r *AgentRepository) prepareRuns(ctx context.Context, runs []*models.AgentRun, skipMissing, redact bool) (map[int]error, error) {
+	// Agents, their redaction and versions are looked up once per batch, runs of a batch usually share theirs. A
+	// batch may mix the runs of agents of different projects, which are redacted with the rules of their own.
+	type agentRedaction struct {
+		redaction *models.Redaction
+		err       error
+	}
+	redactions := map[primitive.ObjectID]agentRedaction{}
+	projects := map[string]*models.Redaction{}
+	type versionKey struct {
+		agentID primitive.ObjectID
+		version string
+	}
+	versions := map[versionKey]*models.AgentVersion{}
+	missing := map[int]error{}
+
+	// Process each run to set version ID and recorded timestamp
+	now := time.Now()
+	for i, run := range runs {
+		agent, ok := redactions[run.AgentID]
+		if !ok {
+			found, err := r.GetAgentByID(ctx, run.AgentID)
+			if err != nil && !(skipMissing && err.Error() == "agent not found") {
+				return nil, err
+			}
+			agent.err = err
+			if found != nil && redact {
+				redaction, ok := projects[found.Project]
+				if !ok {
+					if redaction, err = r.runRedaction(ctx, found.Project); err != nil {
+						return nil, err
+					}
+					projects[found.Project] = redaction
+				}
+				agent.redaction = redaction
+			}
+			redactions[run.AgentID] = agent
+		}
+		if agent.err != nil {
+			missing[i] = agent.err
+			continue
+		}
+
+		key := versionKey{run.AgentID, run.Version}
+		version, ok := versions[key]
+		if !ok {
+			// Check if version exists
+			var err error
+			version, err = r.GetAgentVersion(ctx, run.AgentID, run.Version)
+			if err != nil && !(skipMissing && err.Error() == "version not found for this agent") {
+				return nil, err
+			}
+			versions[key] = version
+		}
+		if version == nil {
+			missing[i] = errors.New("version not found for this agent")
+			continue
+		}
+
+		run.VersionID = version.ID
+		run.RecordedAt = now
+		agent.redaction.Run(run)
+	}
+
+	return missing, nil
+}
+
+// PublishRunCreated notifies subscribers that a run was recorded, and that it failed when it has the error status
+func (r *AgentRepository) PublishRunCreated(run *models.AgentRun) {
+	r.db.Events.Publish(events.Event{
+		Type:     events.RunCreated,
+		Database: r.db.Name(),
+		Org:      r.db.OrgID,
+		Run:      run,
+	})
+
+	if run.Status == "error" {
+		r.db.Events.Publish(events.Event{
+			Type:     events.RunFailed,
+			Database: r.db.Name(),
+			Org:      r.db.OrgID,
+			Run:      run,
+		})
+	}
+}
+
 // GetAgentRuns retrieves all runs for an agent
-func (r *AgentRepository) GetAgentRuns(agentID primitive.ObjectID) ([]models.AgentRun, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) GetAgentRuns(ctx context.Context, agentID primitive.ObjectID, listOpts ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
 	// Check if agent exists
-	_, err := r.GetAgentByID(agentID)
+	_, err := r.GetAgentByID(ctx, agentID)
 	if err != nil {
 		return nil, err
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "created", Value: -1}})
+	opts := options.Find().SetSort(sortDocument(models.AgentRun{}, listOpts.Sort, bson.D{{Key: "created", Value: -1}}))
+	if proj := projection(models.AgentRun{}, listOpts.Fields); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if listOpts.Limit > 0 {
+		opts.SetLimit(listOpts.Limit)
+	}
 	cursor, err := r.runs.Find(ctx, bson.M{"agent_id": agentID}, opts)
 	if err != nil {
 		return nil, err
@@ -304,24 +765,182 @@ func (r *AgentRepository) GetAgentRuns(agentID primitive.ObjectID) ([]models.Age
 	return runs, nil
 }
 
+// GetVersionsOfAgents retrieves the versions of agents by agent, latest first
+func (r *AgentRepository) GetVersionsOfAgents(ctx context.Context, agentIDs []primitive.ObjectID) (map[primitive.ObjectID][]models.AgentVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "agent_id", Value: 1}, {Key: "version", Value: -1}})
+	cursor, err := r.versions.Find(ctx, bson.M{"agent_id": bson.M{"$in": agentIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var versions []models.AgentVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	byAgent := map[primitive.ObjectID][]models.AgentVersion{}
+	for _, version := range versions {
+		byAgent[version.AgentID] = append(byAgent[version.AgentID], version)
+	}
+	return byAgent, nil
+}
+
+// GetRunsOfAgents retrieves the latest runs of agents by agent, at most limit per agent
+func (r *AgentRepository) GetRunsOfAgents(ctx context.Context, agentIDs []primitive.ObjectID, limit int64) (map[primitive.ObjectID][]models.AgentRun, error) {
+	runs, err := r.latestRunsOf(ctx, r.agents, agentIDs, "agent_id", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	byAgent := map[primitive.ObjectID][]models.AgentRun{}
+	for _, run := range runs {
+		byAgent[run.AgentID] = append(byAgent[run.AgentID], run)
+	}
+	return byAgent, nil
+}
+
+// GetRunsOfVersions retrieves the latest runs of versions by version ID, at most limit per version
+func (r *AgentRepository) GetRunsOfVersions(ctx context.Context, versionIDs []primitive.ObjectID, limit int64) (map[primitive.ObjectID][]models.AgentRun, error) {
+	runs, err := r.latestRunsOf(ctx, r.versions, versionIDs, "version_id", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[primitive.ObjectID][]models.AgentRun{}
+	for _, run := range runs {
+		byVersion[run.VersionID] = append(byVersion[run.VersionID], run)
+	}
+	return byVersion, nil
+}
+
+// latestRunsOf retrieves the latest runs of the agents or versions of some IDs, at most limit each, in one
+// aggregation. The runs of each of them are looked up from its document, by the run field referencing it, so that
+// every lookup reads the latest runs from the index of that field rather than all the runs.
+func (r *AgentRepository) latestRunsOf(ctx context.Context, parents collection, ids []primitive.ObjectID, field string, limit int64) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := parents.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"_id": bson.M{"$in": ids}}},
+		{"$lookup": bson.M{
+			"from": r.runs.Name(),
+			"let":  bson.M{"parent": "$_id"},
+			"pipeline": []bson.M{
+				{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$" + field, "$$parent"}}}},
+				{"$sort": bson.D{{Key: "created", Value: -1}}},
+				{"$limit": limit},
+			},
+			"as": "run",
+		}},
+		// Unwound right after the lookup, the runs are not gathered in a document bounded to 16MB
+		{"$unwind": "$run"},
+		{"$replaceRoot": bson.M{"newRoot": "$run"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var runs []models.AgentRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// QueryRuns retrieves the runs matching a run query created in [from, to), newest first unless sorted otherwise.
+// Zero times leave the range open.
+func (r *AgentRepository) QueryRuns(ctx context.Context, query models.RunQuery, from, to time.Time, listOpts ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(sortDocument(models.AgentRun{}, listOpts.Sort, bson.D{{Key: "created", Value: -1}}))
+	if proj := projection(models.AgentRun{}, listOpts.Fields); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if listOpts.Limit > 0 {
+		opts.SetLimit(listOpts.Limit)
+	}
+	cursor, err := r.runs.Find(ctx, runQueryFilter(query, from, to), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []models.AgentRun{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// runQueryFilter builds the Mongo filter of a run query over runs created in [from, to)
+func runQueryFilter(query models.RunQuery, from, to time.Time) bson.M {
+	filter := bson.M{}
+	if query.AgentID != nil {
+		filter["agent_id"] = *query.AgentID
+	}
+	if query.Version != "" {
+		filter["version"] = query.Version
+	}
+	if len(query.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": query.Statuses}
+	}
+	if query.ErrorType != "" {
+		filter["error_type"] = query.ErrorType
+	}
+	if query.Initiator != "" {
+		filter["initiator"] = query.Initiator
+	}
+	if query.Tool != "" {
+		filter["tools"] = query.Tool
+	}
+	if query.Model != "" {
+		filter["models"] = query.Model
+	}
+
+	created := bson.M{}
+	if !from.IsZero() {
+		created["$gte"] = from
+	}
+	if !to.IsZero() {
+		created["$lt"] = to
+	}
+	if len(created) > 0 {
+		filter["created"] = created
+	}
+	return filter
+}
+
 // GetAgentVersionRuns retrieves all runs for a specific agent version
-func (r *AgentRepository) GetAgentVersionRuns(agentID primitive.ObjectID, version string) ([]models.AgentRun, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+func (r *AgentRepository) GetAgentVersionRuns(ctx context.Context, agentID primitive.ObjectID, version string, listOpts ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
 	defer cancel()
 
 	// Check if agent exists
-	_, err := r.GetAgentByID(agentID)
+	_, err := r.GetAgentByID(ctx, agentID)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check if version exists
-	agentVersion, err := r.GetAgentVersion(agentID, version)
+	agentVersion, err := r.GetAgentVersion(ctx, agentID, version)
 	if err != nil {
 		return nil, err
 	}
 
-	opts := options.Find().SetSort(bson.D{{Key: "created", Value: -1}})
+	opts := options.Find().SetSort(sortDocument(models.AgentRun{}, listOpts.Sort, bson.D{{Key: "created", Value: -1}}))
+	if proj := projection(models.AgentRun{}, listOpts.Fields); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if listOpts.Limit > 0 {
+		opts.SetLimit(listOpts.Limit)
+	}
 	cursor, err := r.runs.Find(ctx, bson.M{
 		"agent_id":   agentID,
 		"version_id": agentVersion.ID,
@@ -338,3 +957,584 @@ func (r *AgentRepository) GetAgentVersionRuns(agentID primitive.ObjectID, versio
 
 	return runs, nil
 }
+
+// RunFilter holds optional filters for run queries
+type RunFilter struct {
+	Version string
+	Status  string
+}
+
+// GetAgentRunsAfter retrieves the runs of an agent recorded after the given position, oldest first.
+// The position is the recorded timestamp and ID of the last run already seen.
+func (r *AgentRepository) GetAgentRunsAfter(ctx context.Context, agentID primitive.ObjectID, filter RunFilter, after time.Time, afterID primitive.ObjectID, limit int64) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	query := bson.M{
+		"agent_id": agentID,
+		"$or": []bson.M{
+			{"recorded_at": bson.M{"$gt": after}},
+			{"recorded_at": after, "_id": bson.M{"$gt": afterID}},
+		},
+	}
+	if filter.Version != "" {
+		query["version"] = filter.Version
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "recorded_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+	cursor, err := r.runs.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var runs []models.AgentRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// ExportAgentRuns calls fn with each run of an agent matching the filter, oldest first, reading them from a cursor
+// so exports of any size use constant memory. The export runs until it completes or ctx is cancelled.
+func (r *AgentRepository) ExportAgentRuns(ctx context.Context, agentID primitive.ObjectID, filter RunFilter, fn func(run *models.AgentRun) error) error {
+	// Check if agent exists
+	if _, err := r.GetAgentByID(ctx, agentID); err != nil {
+		return err
+	}
+
+	query := bson.M{"agent_id": agentID}
+	if filter.Version != "" {
+		query["version"] = filter.Version
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(1000)
+	cursor, err := r.runs.Find(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var run models.AgentRun
+		if err := cursor.Decode(&run); err != nil {
+			return err
+		}
+		if err := fn(&run); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// GetAgentRun retrieves a run of an agent by its run ID. When the run ID was recorded more than once, the latest
+// run is returned.
+func (r *AgentRepository) GetAgentRun(ctx context.Context, agentID primitive.ObjectID, runID int64) (*models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var run models.AgentRun
+	opts := options.FindOne().SetSort(bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}})
+	err := r.runs.FindOne(ctx, bson.M{
+		"agent_id": agentID,
+		"run_id":   runID,
+	}, opts).Decode(&run)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("run not found")
+		}
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// GetExistingRunIDs reports which of the given run IDs are already stored for an agent
+func (r *AgentRepository) GetExistingRunIDs(ctx context.Context, agentID primitive.ObjectID, runIDs []int64) (map[int64]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	existing := map[int64]bool{}
+	if len(runIDs) == 0 {
+		return existing, nil
+	}
+
+	query := bson.M{
+		"agent_id": agentID,
+		"run_id":   bson.M{"$in": runIDs},
+	}
+	ids, err := r.runs.Distinct(ctx, "run_id", query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		switch v := id.(type) {
+		case int64:
+			existing[v] = true
+		case int32:
+			existing[int64(v)] = true
+		}
+	}
+
+	return existing, nil
+}
+
+// GetVersionStats summarizes the runs of an agent version created in [from, to)
+func (r *AgentRepository) GetVersionStats(ctx context.Context, agentID primitive.ObjectID, version string, from, to time.Time) (*models.VersionStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	agentVersion, err := r.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	match := bson.M{
+		"version_id": agentVersion.ID,
+		"created":    bson.M{"$gte": from, "$lt": to},
+	}
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":        "$status",
+			"count":      bson.M{"$sum": 1},
+			"time_taken": bson.M{"$sum": "$time_taken"},
+			"cost":       bson.M{"$sum": "$cost"},
+		}},
+	}, r.db.versionRunsAggregate())
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Status    string  `bson:"_id"`
+		Count     int64   `bson:"count"`
+		TimeTaken float64 `bson:"time_taken"`
+		Cost      float64 `bson:"cost"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	stats := &models.VersionStats{Version: version, Errors: map[string]int64{}}
+	var completed int64
+	var timeTaken float64
+	for _, g := range groups {
+		switch g.Status {
+		case models.RunStatusRunning:
+			stats.Running = g.Count
+			continue
+		case models.RunStatusCompleted:
+			completed = g.Count
+		default:
+			stats.Errors[g.Status] = g.Count
+		}
+		stats.Runs += g.Count
+		stats.TotalCost += g.Cost
+		timeTaken += g.TimeTaken
+	}
+	FinishVersionStats(stats, completed, timeTaken)
+	if stats.Runs == 0 {
+		return stats, nil
+	}
+
+	// The p95 run is found by skipping to its rank among the finished runs sorted by time taken
+	match["status"] = bson.M{"$ne": models.RunStatusRunning}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "time_taken", Value: 1}}).
+		SetSkip(PercentileRank(stats.Runs, 95) - 1).
+		SetProjection(bson.M{"time_taken": 1})
+	if r.db.runsShardKey != nil {
+		opts.SetHint(runsVersionIndex)
+	}
+	var p95 models.AgentRun
+	if err := r.runs.FindOne(ctx, match, opts).Decode(&p95); err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	stats.P95TimeTaken = p95.TimeTaken
+
+	return stats, nil
+}
+
+// GetVersionTimeBuckets aggregates the runs of an agent version created in [from, to) into buckets of the given
+// duration, which must be whole minutes, hours or days. Buckets are computed with $dateTrunc, which needs
+// MongoDB 5.0 or later. The part of the range rolled up is read from the rollups.
+func (r *AgentRepository) GetVersionTimeBuckets(ctx context.Context, agentID primitive.ObjectID, version string, bucket time.Duration, from, to time.Time) ([]TimeBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	agentVersion, err := r.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return rolledUpTimeBuckets(ctx, r.runs, r.rollups, r.rollupState, bson.M{"version_id": agentVersion.ID}, bucket, from, to)
+}
+
+// GetLatencyHistogram counts the finished runs of an agent version created in [from, to) per latency bucket, with
+// $bucket. Bounds are the increasing upper bounds of the buckets, in seconds; runs taking at least the last bound are
+// counted in an overflow bucket.
+func (r *AgentRepository) GetLatencyHistogram(ctx context.Context, agentID primitive.ObjectID, version string, bounds []float64, from, to time.Time) ([]HistogramCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	agentVersion, err := r.GetAgentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"version_id": agentVersion.ID,
+			"created":    bson.M{"$gte": from, "$lt": to},
+			"status":     bson.M{"$ne": models.RunStatusRunning},
+			"time_taken": bson.M{"$gte": 0},
+		}},
+		{"$bucket": bson.M{
+			"groupBy":    "$time_taken",
+			"boundaries": HistogramBoundaries(bounds),
+			"default":    bounds[len(bounds)-1],
+			"output":     bson.M{"count": bson.M{"$sum": 1}},
+		}},
+	}, r.db.versionRunsAggregate())
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []HistogramCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// GetAdoptionBuckets counts the runs of every version of an agent created in [from, to) per time bucket of the
+// given duration, which must be whole minutes, hours or days. The part of the range rolled up is read from the
+// rollups.
+func (r *AgentRepository) GetAdoptionBuckets(ctx context.Context, agentID primitive.ObjectID, bucket time.Duration, from, to time.Time) ([]VersionBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	if _, err := r.GetAgentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	return rolledUpVersionBuckets(ctx, r.runs, r.rollups, r.rollupState, agentID, bucket, from, to)
+}
+
+// GetUsageStats computes the usage statistics of the values of a run list field, tools or models, over the finished
+// runs of an agent created in [from, to), optionally of a single version. The most used values come first.
+func (r *AgentRepository) GetUsageStats(ctx context.Context, agentID primitive.ObjectID, field string, version string, from, to time.Time) ([]models.UsageStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	if _, err := r.GetAgentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	match := bson.M{
+		"agent_id": agentID,
+		"created":  bson.M{"$gte": from, "$lt": to},
+		"status":   bson.M{"$ne": models.RunStatusRunning},
+	}
+	if version != "" {
+		match["version"] = version
+	}
+
+	return aggregateUsageStats(ctx, r.runs, match, field)
+}
+
+// CreateRunSteps stores the steps of runs
+func (r *AgentRepository) CreateRunSteps(ctx context.Context, steps []*models.RunStep) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*r.timeouts.Write)
+	defer cancel()
+
+	if err := r.redactSteps(ctx, steps); err != nil {
+		return err
+	}
+
+	documents := make([]interface{}, len(steps))
+	now := time.Now()
+	for i, step := range steps {
+		step.RecordedAt = now
+		documents[i] = step
+	}
+
+	result, err := r.steps.InsertMany(ctx, documents)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range result.InsertedIDs {
+		steps[i].ID = id.(primitive.ObjectID)
+	}
+
+	return nil
+}
+
+// runRedaction returns the redaction of the runs of the agents of a project, nil when no rules apply to them. The
+// rules are read outside of the project scope of the repository, so that the rules of every project also apply to
+// the runs written by project scoped callers.
+func (r *AgentRepository) runRedaction(ctx context.Context, project string) (*models.Redaction, error) {
+	rules, err := findRedactionRules(ctx, r.redactions, bson.M{"project": bson.M{"$in": bson.A{"", project}}})
+	if err != nil {
+		return nil, err
+	}
+	return redactionFor(rules, project)
+}
+
+// redactSteps redacts personal data from steps with the rules of the project of the agent of their run, found by
+// trace ID. Steps whose run is not stored yet are redacted with the rules of every project.
+func (r *AgentRepository) redactSteps(ctx context.Context, steps []*models.RunStep) error {
+	rules, err := findRedactionRules(ctx, r.redactions, bson.M{})
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	traceIDs := bson.A{}
+	for _, step := range steps {
+		traceIDs = append(traceIDs, step.TraceID)
+	}
+	cursor, err := r.runs.Find(ctx, bson.M{"trace_id": bson.M{"$in": traceIDs}}, options.Find().SetProjection(bson.M{"trace_id": 1, "agent_id": 1}))
+	if err != nil {
+		return err
+	}
+	var runs []models.AgentRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return err
+	}
+
+	agentIDs := bson.A{}
+	for _, run := range runs {
+		agentIDs = append(agentIDs, run.AgentID)
+	}
+	cursor, err = r.agents.Find(ctx, bson.M{"_id": bson.M{"$in": agentIDs}}, options.Find().SetProjection(bson.M{"project": 1}))
+	if err != nil {
+		return err
+	}
+	var agents []models.Agent
+	if err := cursor.All(ctx, &agents); err != nil {
+		return err
+	}
+
+	projects := map[primitive.ObjectID]string{}
+	for _, agent := range agents {
+		projects[agent.ID] = agent.Project
+	}
+	traceProjects := map[string]string{}
+	for _, run := range runs {
+		traceProjects[run.TraceID] = projects[run.AgentID]
+	}
+
+	redactions := map[string]*models.Redaction{}
+	for _, step := range steps {
+		project := traceProjects[step.TraceID]
+		redaction, ok := redactions[project]
+		if !ok {
+			if redaction, err = redactionFor(rules, project); err != nil {
+				return err
+			}
+			redactions[project] = redaction
+		}
+		redaction.Step(step)
+	}
+	return nil
+}
+
+// GetRunSteps retrieves the steps recorded for a trace, in the order they started
+func (r *AgentRepository) GetRunSteps(ctx context.Context, traceID string) ([]models.RunStep, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "started", Value: 1}})
+	cursor, err := r.steps.Find(ctx, bson.M{"trace_id": traceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	steps := []models.RunStep{}
+	if err := cursor.All(ctx, &steps); err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
+
+// CreateIngestToken stores an ingest token
+func (r *AgentRepository) CreateIngestToken(ctx context.Context, token *models.IngestToken) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+	_, err := r.tokens.InsertOne(ctx, token)
+	return err
+}
+
+// GetIngestToken retrieves an ingest token by the hash of the token
+func (r *AgentRepository) GetIngestToken(ctx context.Context, hash string) (*models.IngestToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var token models.IngestToken
+	err := r.tokens.FindOne(ctx, bson.M{"_id": hash}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("ingest token not found")
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// CreateTeam creates a new team, unless a team with the same name exists
+func (r *AgentRepository) CreateTeam(ctx context.Context, team *models.Team) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	count, err := r.teams.CountDocuments(ctx, bson.M{"name": team.Name})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("team already exists")
+	}
+
+	now := time.Now()
+	team.CreatedAt = now
+	team.UpdatedAt = now
+
+	result, err := r.teams.InsertOne(ctx, team)
+	if err != nil {
+		return err
+	}
+
+	team.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetTeam retrieves a team by name
+func (r *AgentRepository) GetTeam(ctx context.Context, name string) (*models.Team, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var team models.Team
+	err := r.teams.FindOne(ctx, bson.M{"name": name}).Decode(&team)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("team not found")
+		}
+		return nil, err
+	}
+
+	return &team, nil
+}
+
+// ListTeams retrieves all teams, sorted by name
+func (r *AgentRepository) ListTeams(ctx context.Context) ([]models.Team, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.teams.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	teams := []models.Team{}
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+// DeleteTeam deletes a team by name, unless it owns agents
+func (r *AgentRepository) DeleteTeam(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	count, err := r.agents.CountDocuments(ctx, bson.M{"team": name})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("team owns agents")
+	}
+
+	result, err := r.teams.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("team not found")
+	}
+	return nil
+}
+
+// SetAgentSigningSecret replaces the secret signing the run requests of an agent and returns the updated agent
+func (r *AgentRepository) SetAgentSigningSecret(ctx context.Context, id primitive.ObjectID, secret string) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	var agent models.Agent
+	update := bson.M{"$set": bson.M{"signing_secret": secret, "updated_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.agents.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&agent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("agent not found")
+		}
+		return nil, err
+	}
+	r.db.invalidateReads(ctx, agentsGeneration)
+
+	return &agent, nil
+}
+
+// SetAgentTeam assigns an agent to its owning team, or to no team when team is empty, and returns the updated agent
+func (r *AgentRepository) SetAgentTeam(ctx context.Context, id primitive.ObjectID, team string) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"team": team, "updated_at": now}}
+	if team == "" {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": now},
+			"$unset": bson.M{"team": ""},
+		}
+	}
+
+	var agent models.Agent
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.agents.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&agent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("agent not found")
+		}
+		return nil, err
+	}
+	r.db.invalidateReads(ctx, agentsGeneration)
+
+	return &agent, nil
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// DefaultAnalyticsTimeout is the timeout of the reads and aggregations of the analytics client unless configured
+// otherwise, longer than the default timeouts since dashboard aggregations may scan many runs
+const DefaultAnalyticsTimeout = 60 * time.Second
+
+// ConnectAnalytics connects a second client to uri, with its own connection pool, reading from a secondary of the
+// replica set when one is available. The databases returned by Analytics run their operations on it, so that heavy
+// dashboard aggregations do not compete with the writes of ingestion on the primary. Its reads and aggregations,
+// which MongoDB stops running with maxTimeMS, time out after timeout. Analytics reads may lag behind the writes by
+// the replication lag of the secondaries.
+func (m *MongoDB) ConnectAnalytics(uri string, timeout time.Duration) error {
+	opts := m.conn.options
+	opts.ReadPreference = readpref.SecondaryPreferred()
+	client, err := connect(uri, opts)
+	if err != nil {
+		return err
+	}
+
+	timeouts := m.Timeouts()
+	timeouts.Read = timeout
+	timeouts.Aggregate = timeout
+	m.conn.mu.Lock()
+	m.conn.analytics = &connection{client: client, timeouts: timeouts, options: opts, readCache: m.conn.readCache}
+	m.conn.mu.Unlock()
+	log.Println("Connected the MongoDB analytics client, reading from secondaries")
+	return nil
+}
+
+// Analytics returns the database on the analytics client of its connection, see ConnectAnalytics, or the database
+// itself when none is connected. Its repositories take the timeouts of the analytics client.
+func (m *MongoDB) Analytics() *MongoDB {
+	analytics := m.conn.analyticsConnection()
+	if analytics == nil {
+		return m
+	}
+	database := *m
+	database.conn = analytics
+	if m.shared != nil {
+		database.shared = m.shared.Analytics()
+	}
+	return &database
+}
+
+// analyticsConnection returns the connection of the analytics client, nil when none is connected
+func (c *connection) analyticsConnection() *connection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.analytics
+}
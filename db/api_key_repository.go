@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeyRepository handles database operations for API keys
+type APIKeyRepository struct {
+	db       *MongoDB
+	keys     collection
+	timeouts Timeouts
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *MongoDB) *APIKeyRepository {
+	return &APIKeyRepository{
+		db:       db,
+		keys:     db.Collection("api_keys"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the indexes API keys are looked up by, the hash of their key and of the key they replaced
+func (r *APIKeyRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.keys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "previous_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+// CreateAPIKey stores an API key
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	key.CreatedAt = time.Now()
+	result, err := r.keys.InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID
+func (r *APIKeyRepository) GetAPIKey(ctx context.Context, id primitive.ObjectID) (*models.APIKey, error) {
+	return r.findAPIKey(ctx, bson.M{"_id": id})
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of the key, or of the key it replaced while that one is valid
+func (r *APIKeyRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	return r.findAPIKey(ctx, bson.M{"$or": bson.A{
+		bson.M{"hash": hash},
+		bson.M{"previous_hash": hash, "previous_expires_at": bson.M{"$gt": time.Now()}},
+	}})
+}
+
+// ListAPIKeys retrieves all API keys, sorted by name
+func (r *APIKeyRepository) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.keys.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey deletes an API key, revoking it and the key it replaced
+func (r *APIKeyRepository) DeleteAPIKey(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.keys.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("API key not found")
+	}
+	return nil
+}
+
+// RotateAPIKey replaces the key of an API key by the key of hash, keeping the replaced key valid until
+// previousExpiresAt, and returns the updated API key. A key replaced by an earlier rotation is revoked.
+func (r *APIKeyRepository) RotateAPIKey(ctx context.Context, id primitive.ObjectID, hash string, previousExpiresAt time.Time) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	// The pipeline update reads the current hash before replacing it
+	update := bson.A{bson.M{"$set": bson.M{
+		"previous_hash":       "$hash",
+		"previous_expires_at": previousExpiresAt,
+		"hash":                hash,
+		"rotated_at":          time.Now(),
+	}}}
+
+	var key models.APIKey
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.keys.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("API key not found")
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// TouchAPIKey records that an API key authenticated a request at, unless its last use was recorded less than
+// models.APIKeyLastUsedResolution before
+func (r *APIKeyRepository) TouchAPIKey(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.keys.UpdateOne(ctx, bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$exists": false}},
+			bson.M{"last_used_at": bson.M{"$lt": at.Add(-models.APIKeyLastUsedResolution)}},
+		},
+	}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+// findAPIKey retrieves the API key matching a filter
+func (r *APIKeyRepository) findAPIKey(ctx context.Context, filter bson.M) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var key models.APIKey
+	err := r.keys.FindOne(ctx, filter).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("API key not found")
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
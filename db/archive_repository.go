@@ -0,0 +1,305 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// restoredUntilField marks the runs restored from an archive with the time until which they are kept. Restored runs
+// are not archived again, and are deleted once that time has passed.
+const restoredUntilField = "restored_until"
+
+// duplicateKeyCode is the MongoDB error code of a write of a document whose _id, or unique key, is already stored
+const duplicateKeyCode = 11000
+
+// ArchiveRepository handles database operations for the archives of runs moved to cold storage, and for the jobs
+// restoring them
+type ArchiveRepository struct {
+	db       *MongoDB
+	archives collection
+	restores collection
+	runs     collection
+	timeouts Timeouts
+}
+
+// NewArchiveRepository creates a new archive repository
+func NewArchiveRepository(db *MongoDB) *ArchiveRepository {
+	return &ArchiveRepository{
+		db:       db,
+		archives: db.Collection("run_archives"),
+		restores: db.Collection("restore_jobs"),
+		runs:     db.Collection("agent_runs"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the index archives are looked up by time range with, and the sparse index restored runs
+// are found with
+func (r *ArchiveRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.archives.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "from", Value: 1}, {Key: "to", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the archive index: %w", err)
+	}
+	_, err = r.runs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: restoredUntilField, Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the restored runs index: %w", err)
+	}
+	return nil
+}
+
+// ArchivableRuns returns up to limit of the runs created before a time that were not restored from an archive,
+// oldest first, as stored
+func (r *ArchiveRepository) ArchivableRuns(ctx context.Context, before time.Time, limit int64) ([]bson.Raw, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit).
+		SetBatchSize(1000)
+	cursor, err := r.runs.Find(ctx, bson.M{
+		"created":          bson.M{"$lt": before},
+		restoredUntilField: bson.M{"$exists": false},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []bson.Raw{}
+	for cursor.Next(ctx) {
+		runs = append(runs, append(bson.Raw{}, cursor.Current...))
+	}
+	return runs, cursor.Err()
+}
+
+// DeleteArchivedRuns deletes the runs of an archive by ID, in batches, returning how many were deleted
+func (r *ArchiveRepository) DeleteArchivedRuns(ctx context.Context, ids []interface{}) (int64, error) {
+	var deleted int64
+	for start := 0; start < len(ids); start += purgeBatchSize {
+		end := start + purgeBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		result, err := r.runs.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids[start:end]}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	return deleted, nil
+}
+
+// AddArchive records a file of archived runs
+func (r *ArchiveRepository) AddArchive(ctx context.Context, archive *models.RunArchive) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	archive.CreatedAt = time.Now()
+	result, err := r.archives.InsertOne(ctx, archive)
+	if err != nil {
+		return err
+	}
+
+	archive.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListArchives retrieves the archives holding runs created in [from, to), oldest first, or all archives when from
+// and to are zero
+func (r *ArchiveRepository) ListArchives(ctx context.Context, from, to time.Time) ([]models.RunArchive, error) {
+	filter := bson.M{}
+	if !from.IsZero() {
+		filter["to"] = bson.M{"$gte": from}
+	}
+	if !to.IsZero() {
+		filter["from"] = bson.M{"$lt": to}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "from", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.archives.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	archives := []models.RunArchive{}
+	if err := cursor.All(ctx, &archives); err != nil {
+		return nil, err
+	}
+
+	return archives, nil
+}
+
+// CreateRestoreJob creates a new pending restore job
+func (r *ArchiveRepository) CreateRestoreJob(ctx context.Context, job *models.RestoreJob) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	job.Status = models.RestoreStatusPending
+	job.CreatedAt = time.Now()
+
+	result, err := r.restores.InsertOne(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	job.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetRestoreJob retrieves a restore job by ID
+func (r *ArchiveRepository) GetRestoreJob(ctx context.Context, id primitive.ObjectID) (*models.RestoreJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var job models.RestoreJob
+	err := r.restores.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("restore job not found")
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ListRestoreJobs retrieves the most recent restore jobs
+func (r *ArchiveRepository) ListRestoreJobs(ctx context.Context, limit int64) ([]models.RestoreJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.restores.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.RestoreJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// ClaimRestoreJob marks the oldest pending restore job as running and returns it, or returns nil when there is
+// none. Jobs running for longer than staleAfter were abandoned by a stopped server and are claimed again.
+func (r *ArchiveRepository) ClaimRestoreJob(ctx context.Context, staleAfter time.Duration) (*models.RestoreJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": models.RestoreStatusPending},
+		{"status": models.RestoreStatusRunning, "started_at": bson.M{"$lt": now.Add(-staleAfter)}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     models.RestoreStatusRunning,
+			"started_at": now,
+			"archives":   0,
+			"runs":       0,
+		},
+		"$unset": bson.M{"error": ""},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.RestoreJob
+	if err := r.restores.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// AddRestoredArchive records an archive read by a running restore job, and the runs it restored
+func (r *ArchiveRepository) AddRestoredArchive(ctx context.Context, id primitive.ObjectID, runs int64) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.restores.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$inc": bson.M{"archives": 1, "runs": runs},
+	})
+	return err
+}
+
+// FinishRestoreJob marks a restore job as completed, or as failed when jobErr is set
+func (r *ArchiveRepository) FinishRestoreJob(ctx context.Context, id primitive.ObjectID, jobErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	set := bson.M{
+		"status":       models.RestoreStatusCompleted,
+		"completed_at": time.Now(),
+	}
+	if jobErr != nil {
+		set["status"] = models.RestoreStatusFailed
+		set["error"] = jobErr.Error()
+	}
+
+	_, err := r.restores.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// RestoreRuns inserts archived runs, marked as kept until a time, returning how many were inserted. Runs that are
+// still stored, or were already restored, are skipped.
+func (r *ArchiveRepository) RestoreRuns(ctx context.Context, runs []bson.D, until time.Time) (int64, error) {
+	if len(runs) == 0 {
+		return 0, nil
+	}
+
+	documents := make([]interface{}, len(runs))
+	for i, run := range runs {
+		documents[i] = setField(run, restoredUntilField, until, true)
+	}
+
+	_, err := r.runs.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var writeErr mongo.BulkWriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code != duplicateKeyCode {
+				return 0, err
+			}
+		}
+		return int64(len(documents) - len(writeErr.WriteErrors)), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(documents)), nil
+}
+
+// DeleteExpiredRestoredRuns deletes the restored runs kept until before a time, returning how many were deleted
+func (r *ArchiveRepository) DeleteExpiredRestoredRuns(ctx context.Context, now time.Time) (int64, error) {
+	result, err := r.runs.DeleteMany(ctx, bson.M{restoredUntilField: bson.M{"$lt": now}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// artifactBucket is the GridFS bucket the content of artifacts is stored in, in the artifact_files.files and
+	// artifact_files.chunks collections
+	artifactBucket = "artifact_files"
+	// artifactContentTimeout bounds the upload and download of the content of an artifact, which may be large
+	artifactContentTimeout = 5 * time.Minute
+)
+
+// ArtifactRepository handles database operations for the artifacts attached to runs. It also stores their content
+// in GridFS, as the default storage of artifacts.
+type ArtifactRepository struct {
+	db        *MongoDB
+	artifacts collection
+	timeouts  Timeouts
+}
+
+// NewArtifactRepository creates a new artifact repository
+func NewArtifactRepository(db *MongoDB) *ArtifactRepository {
+	return &ArtifactRepository{
+		db:        db,
+		artifacts: db.Collection("artifacts"),
+		timeouts:  db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the index the artifacts of a run are listed by
+func (r *ArtifactRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.artifacts.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "run_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
+
+// CreateArtifact records an artifact whose content is stored
+func (r *ArtifactRepository) CreateArtifact(ctx context.Context, artifact *models.Artifact) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	if artifact.ID.IsZero() {
+		artifact.ID = primitive.NewObjectID()
+	}
+	artifact.CreatedAt = time.Now()
+	_, err := r.artifacts.InsertOne(ctx, artifact)
+	return err
+}
+
+// ListArtifacts retrieves the artifacts of a run, oldest first
+func (r *ArtifactRepository) ListArtifacts(ctx context.Context, agentID primitive.ObjectID, runID int64) ([]models.Artifact, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.artifacts.Find(ctx, bson.M{"agent_id": agentID, "run_id": runID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	artifacts := []models.Artifact{}
+	if err := cursor.All(ctx, &artifacts); err != nil {
+		return nil, err
+	}
+
+	return artifacts, nil
+}
+
+// GetArtifact retrieves an artifact of a run by ID
+func (r *ArtifactRepository) GetArtifact(ctx context.Context, agentID primitive.ObjectID, runID int64, id primitive.ObjectID) (*models.Artifact, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var artifact models.Artifact
+	err := r.artifacts.FindOne(ctx, bson.M{"_id": id, "agent_id": agentID, "run_id": runID}).Decode(&artifact)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("artifact not found")
+		}
+		return nil, err
+	}
+
+	return &artifact, nil
+}
+
+// Upload stores the content of an artifact read from body in GridFS under the given name, a chunk at a time, and
+// returns the ID of its file as its key. The chunks written are deleted when reading body fails.
+func (r *ArtifactRepository) Upload(ctx context.Context, name string, body io.Reader, contentType string) (string, error) {
+	bucket, err := r.bucket()
+	if err != nil {
+		return "", err
+	}
+	if err := bucket.SetWriteDeadline(contentDeadline(ctx)); err != nil {
+		return "", err
+	}
+
+	opts := options.GridFSUpload().SetMetadata(bson.M{"content_type": contentType})
+	id, err := bucket.UploadFromStream(name, body, opts)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload %s to GridFS: %w", name, err)
+	}
+	return id.Hex(), nil
+}
+
+// Open opens the content of an artifact stored in GridFS under a key, as returned by Upload, for reading
+func (r *ArtifactRepository) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GridFS file ID %q", key)
+	}
+	bucket, err := r.bucket()
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.SetReadDeadline(contentDeadline(ctx)); err != nil {
+		return nil, err
+	}
+
+	content, err := bucket.OpenDownloadStream(id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s from GridFS: %w", key, err)
+	}
+	return content, nil
+}
+
+// bucket returns the GridFS bucket of the content of artifacts, in the database of the repository
+func (r *ArtifactRepository) bucket() (*gridfs.Bucket, error) {
+	if err := r.db.ScopeErr(); err != nil {
+		return nil, err
+	}
+	return gridfs.NewBucket(r.db.Database(), options.GridFSBucket().SetName(artifactBucket))
+}
+
+// contentDeadline returns the deadline of the transfer of the content of an artifact, that of the context when it
+// is sooner
+func contentDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(artifactContentTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
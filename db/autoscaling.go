@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AutoscalingSignals summarizes current demand for use by external autoscalers (e.g. KEDA)
+type AutoscalingSignals struct {
+	GeneratedAt   time.Time         `json:"generated_at"`
+	WindowSeconds float64           `json:"window_seconds"`
+	RunsInWindow  int64             `json:"runs_in_window"`
+	IngestRate    float64           `json:"ingest_rate"`
+	Backlog       int64             `json:"backlog"`
+	Agents        []AgentLoadSignal `json:"agents"`
+}
+
+// AgentLoadSignal represents the current load for a single agent
+type AgentLoadSignal struct {
+	AgentID      primitive.ObjectID `json:"agent_id" bson:"_id"`
+	Name         string             `json:"name" bson:"name"`
+	Project      string             `json:"project" bson:"project"`
+	RunsInWindow int64              `json:"runs_in_window" bson:"runs_in_window"`
+	IngestRate   float64            `json:"ingest_rate" bson:"-"`
+	Running      int64              `json:"running" bson:"running"`
+}
+
+// GetAutoscalingSignals computes ingest rate, run backlog and per-agent load over the given window.
+// If agentName is not empty, only that agent is included in the per-agent load, and the totals are those of the agent.
+func (r *UIRepository) GetAutoscalingSignals(ctx context.Context, window time.Duration, agentName string) (*AutoscalingSignals, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	now := time.Now()
+	since := now.Add(-window)
+
+	agents, err := r.getAgentLoad(ctx, since, agentName)
+	if err != nil {
+		return nil, err
+	}
+
+	var runsInWindow, backlog int64
+	for i := range agents {
+		agents[i].IngestRate = float64(agents[i].RunsInWindow) / window.Seconds()
+		runsInWindow += agents[i].RunsInWindow
+		backlog += agents[i].Running
+	}
+
+	// The totals of all agents include the runs of deleted agents, which are not in the per-agent load
+	if agentName == "" {
+		if runsInWindow, err = r.runs.CountDocuments(ctx, bson.M{"recorded_at": bson.M{"$gte": since}}); err != nil {
+			return nil, err
+		}
+		if backlog, err = r.runs.CountDocuments(ctx, bson.M{"status": "running"}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &AutoscalingSignals{
+		GeneratedAt:   now,
+		WindowSeconds: window.Seconds(),
+		RunsInWindow:  runsInWindow,
+		IngestRate:    float64(runsInWindow) / window.Seconds(),
+		Backlog:       backlog,
+		Agents:        agents,
+	}, nil
+}
+
+// getAgentLoad returns the runs recorded since the given time and the running runs, grouped by agent
+func (r *UIRepository) getAgentLoad(ctx context.Context, since time.Time, agentName string) ([]AgentLoadSignal, error) {
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"$or": []bson.M{
+					{"recorded_at": bson.M{"$gte": since}},
+					{"status": "running"},
+				},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id": "$agent_id",
+				"runs_in_window": bson.M{
+					"$sum": bson.M{"$cond": []interface{}{bson.M{"$gte": []interface{}{"$recorded_at", since}}, 1, 0}},
+				},
+				"running": bson.M{
+					"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []interface{}{"$status", "running"}}, 1, 0}},
+				},
+			},
+		},
+		{
+			"$lookup": bson.M{
+				"from":         "agents",
+				"localField":   "_id",
+				"foreignField": "_id",
+				"as":           "agent_info",
+			},
+		},
+		{
+			"$unwind": "$agent_info",
+		},
+		{
+			"$project": bson.M{
+				"name":           "$agent_info.name",
+				"project":        "$agent_info.project",
+				"runs_in_window": 1,
+				"running":        1,
+			},
+		},
+	}
+
+	if agentName != "" {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"name": agentName}})
+	}
+	pipeline = append(pipeline, bson.M{"$sort": bson.D{{Key: "running", Value: -1}, {Key: "runs_in_window", Value: -1}}})
+
+	cursor, err := r.runs.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	agents := []AgentLoadSignal{}
+	if err := cursor.All(ctx, &agents); err != nil {
+		return nil, err
+	}
+
+	return agents, nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errDuplicateRun is the failure of a run whose run ID is already stored for its version
+var errDuplicateRun = errors.New("run with this ID already exists")
+
+// errNotInserted is the failure of the runs of an ordered batch after its first failing run, which are not inserted
+var errNotInserted = errors.New("not inserted, an earlier run of the ordered batch failed")
+
+// BatchOptions are the options of the insert of a batch of runs
+type BatchOptions struct {
+	// Unordered stores every run of the batch that can be, reporting the others, rather than stopping at the first
+	// run that can not be stored. An ordered batch is stored in a transaction when the store supports them, so that
+	// either all or none of its runs are.
+	Unordered bool
+	// Redacted stores runs already redacted by PrepareRuns without redacting them again, which would hash their
+	// hashed values
+	Redacted bool
+}
+
+// BatchItemError is the failure of a run of a batch, by its index in the batch
+type BatchItemError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+	// Conflict is set when the run ID of the run is already stored for its version, the run was sent before
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// BatchError reports the runs of a batch that were not stored, by index. The other runs of the batch were stored,
+// and have their IDs set.
+type BatchError struct {
+	Items []BatchItemError
+}
+
+// Conflicts reports whether all the runs that were not stored are conflicts, the batch was sent before
+func (e *BatchError) Conflicts() bool {
+	for _, item := range e.Items {
+		if !item.Conflict {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Items) == 1 {
+		return fmt.Sprintf("run %d of the batch was not stored: %s", e.Items[0].Index, e.Items[0].Error)
+	}
+	return fmt.Sprintf("%d runs of the batch were not stored, the first one, run %d: %s", len(e.Items), e.Items[0].Index, e.Items[0].Error)
+}
+
+// insertBatchError returns the error of an InsertMany of the documents of a batch, as a *BatchError when the write
+// errors tell which documents were not inserted. indexes maps the documents to their index in the batch, and
+// failed holds the runs of the batch that already failed before the insert. An ordered insert stops at its first
+// write error, the documents after it are not inserted.
+func insertBatchError(err error, indexes []int, failed map[int]error, ordered bool) error {
+	var writeErr mongo.BulkWriteException
+	if err != nil && (!errors.As(err, &writeErr) || writeErr.WriteConcernError != nil) {
+		// Whether the documents were written is not known
+		return err
+	}
+
+	for _, e := range writeErr.WriteErrors {
+		failed[indexes[e.Index]] = errors.New(e.Message)
+		switch e.Code {
+		case duplicateKeyCode:
+			failed[indexes[e.Index]] = errDuplicateRun
+		case documentValidationFailureCode:
+			failed[indexes[e.Index]] = errInvalidRun
+		}
+		if ordered {
+			for _, index := range indexes[e.Index+1:] {
+				failed[index] = errNotInserted
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	batchErr := &BatchError{}
+	for index := 0; len(batchErr.Items) < len(failed); index++ {
+		if err, ok := failed[index]; ok {
+			batchErr.Items = append(batchErr.Items, BatchItemError{Index: index, Error: err.Error(), Conflict: err == errDuplicateRun})
+		}
+	}
+	return batchErr
+}
@@ -0,0 +1,270 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BudgetRepository handles database operations for budgets and their computed statuses
+type BudgetRepository struct {
+	db       *MongoDB
+	budgets  collection
+	statuses collection
+	agents   collection
+	runs     collection
+	timeouts Timeouts
+}
+
+// NewBudgetRepository creates a new budget repository
+func NewBudgetRepository(db *MongoDB) *BudgetRepository {
+	return &BudgetRepository{
+		db:       db,
+		budgets:  db.Collection("budgets"),
+		statuses: db.Collection("budget_status"),
+		agents:   db.Collection("agents"),
+		runs:     db.Collection("agent_runs"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateBudget creates a new budget
+func (r *BudgetRepository) CreateBudget(ctx context.Context, budget *models.Budget) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	budget.CreatedAt = now
+	budget.UpdatedAt = now
+
+	result, err := r.budgets.InsertOne(ctx, budget)
+	if err != nil {
+		return err
+	}
+
+	budget.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetBudget retrieves a budget by ID
+func (r *BudgetRepository) GetBudget(ctx context.Context, id primitive.ObjectID) (*models.Budget, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var budget models.Budget
+	err := r.budgets.FindOne(ctx, bson.M{"_id": id}).Decode(&budget)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("budget not found")
+		}
+		return nil, err
+	}
+
+	return &budget, nil
+}
+
+// ListBudgets retrieves the budgets of an agent or of a project, or all budgets when neither is given
+func (r *BudgetRepository) ListBudgets(ctx context.Context, agentID *primitive.ObjectID, project string) ([]models.Budget, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	return r.findBudgets(ctx, budgetFilter(agentID, project))
+}
+
+// ListAllBudgets retrieves every budget
+func (r *BudgetRepository) ListAllBudgets(ctx context.Context) ([]models.Budget, error) {
+	return r.findBudgets(ctx, bson.M{})
+}
+
+// findBudgets retrieves the budgets matching a filter, oldest first
+func (r *BudgetRepository) findBudgets(ctx context.Context, filter bson.M) ([]models.Budget, error) {
+	cursor, err := r.budgets.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	budgets := []models.Budget{}
+	if err := cursor.All(ctx, &budgets); err != nil {
+		return nil, err
+	}
+
+	return budgets, nil
+}
+
+// UpdateBudget replaces the scope, period and amount of a budget
+func (r *BudgetRepository) UpdateBudget(ctx context.Context, budget *models.Budget) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	budget.UpdatedAt = time.Now()
+	set := bson.M{
+		"period":     budget.Period,
+		"amount":     budget.Amount,
+		"updated_at": budget.UpdatedAt,
+	}
+	unset := bson.M{}
+	if budget.AgentID != nil {
+		set["agent_id"] = budget.AgentID
+		unset["project"] = ""
+	} else {
+		set["project"] = budget.Project
+		unset["agent_id"] = ""
+	}
+
+	result, err := r.budgets.UpdateOne(ctx, bson.M{"_id": budget.ID}, bson.M{"$set": set, "$unset": unset})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("budget not found")
+	}
+
+	return nil
+}
+
+// DeleteBudget deletes a budget and its status
+func (r *BudgetRepository) DeleteBudget(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.budgets.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("budget not found")
+	}
+
+	_, err = r.statuses.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// ListBudgetStatuses retrieves the last computed statuses of the budgets of an agent or of a project, or of all
+// budgets when neither is given
+func (r *BudgetRepository) ListBudgetStatuses(ctx context.Context, agentID *primitive.ObjectID, project string) ([]models.BudgetStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.statuses.Find(ctx, budgetFilter(agentID, project), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	statuses := []models.BudgetStatus{}
+	if err := cursor.All(ctx, &statuses); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// ComputeBudgetStatus sums the cost of the runs of the budget's agent, or of the agents of its project, over the
+// current budget period up to now, in the timezone of now
+func (r *BudgetRepository) ComputeBudgetStatus(ctx context.Context, budget *models.Budget, now time.Time) (*models.BudgetStatus, error) {
+	start := budget.PeriodStart(now)
+	match := bson.M{"created": bson.M{"$gte": start, "$lt": now}}
+	if budget.AgentID != nil {
+		match["agent_id"] = *budget.AgentID
+	} else {
+		agentIDs, err := r.agents.Distinct(ctx, "_id", bson.M{"project": budget.Project})
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch the agents of project %q: %w", budget.Project, err)
+		}
+		match["agent_id"] = bson.M{"$in": agentIDs}
+	}
+
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":   nil,
+			"spend": bson.M{"$sum": "$cost"},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to sum spend: %w", err)
+	}
+
+	var results []struct {
+		Spend float64 `bson:"spend"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("unable to decode spend: %w", err)
+	}
+
+	var spend float64
+	if len(results) > 0 {
+		spend = results[0].Spend
+	}
+
+	return NewBudgetStatus(budget, start, spend), nil
+}
+
+// BudgetProject returns the project of a budget, the project of its agent for an agent budget
+func (r *BudgetRepository) BudgetProject(ctx context.Context, budget *models.Budget) (string, error) {
+	if budget.AgentID == nil {
+		return budget.Project, nil
+	}
+
+	var agent models.Agent
+	err := r.agents.FindOne(ctx, bson.M{"_id": *budget.AgentID}, options.FindOne().SetProjection(bson.M{"project": 1})).Decode(&agent)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return agent.Project, nil
+}
+
+// UpsertBudgetStatus writes the status of a budget, keyed by the budget ID
+func (r *BudgetRepository) UpsertBudgetStatus(ctx context.Context, status *models.BudgetStatus) error {
+	upsert := true
+	_, err := r.statuses.UpdateOne(ctx, bson.M{"_id": status.BudgetID}, bson.M{"$set": status}, &options.UpdateOptions{
+		Upsert: &upsert,
+	})
+	return err
+}
+
+// NewBudgetStatus computes the remaining amount and the consumed percentage of a budget from its spend over the
+// period starting at start
+func NewBudgetStatus(budget *models.Budget, start time.Time, spend float64) *models.BudgetStatus {
+	status := &models.BudgetStatus{
+		BudgetID:    budget.ID,
+		AgentID:     budget.AgentID,
+		Project:     budget.Project,
+		Period:      budget.Period,
+		Amount:      budget.Amount,
+		PeriodStart: start,
+		PeriodEnd:   budget.PeriodEnd(start),
+		Spend:       spend,
+		Remaining:   budget.Amount - spend,
+		Exceeded:    spend > budget.Amount,
+		ComputedAt:  time.Now(),
+	}
+	if budget.Amount > 0 {
+		status.PercentConsumed = spend / budget.Amount * 100
+	}
+	return status
+}
+
+// budgetFilter matches the budgets, or budget statuses, of an agent or of a project
+func budgetFilter(agentID *primitive.ObjectID, project string) bson.M {
+	filter := bson.M{}
+	if agentID != nil {
+		filter["agent_id"] = *agentID
+	}
+	if project != "" {
+		filter["project"] = project
+	}
+	return filter
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// changeStreamTokensCollection stores the resume token of every change stream consumer, by name
+	changeStreamTokensCollection = "change_stream_tokens"
+	// changeStreamSaveInterval bounds how often the resume token of a consumer is saved while changes keep coming.
+	// It is saved whenever the consumer caught up with the changes otherwise.
+	changeStreamSaveInterval = time.Second
+	// changeStreamMinBackoff and changeStreamMaxBackoff bound the delay before a failed change stream is opened
+	// again
+	changeStreamMinBackoff = time.Second
+	changeStreamMaxBackoff = time.Minute
+	// changeStreamHistoryLostCode is the MongoDB error code of a change stream resumed from a token the oplog no
+	// longer holds
+	changeStreamHistoryLostCode = 286
+)
+
+// ErrChangeStreamsUnsupported is returned when watching a database whose deployment does not run change streams:
+// a standalone server, or the runs of a time-series collection
+var ErrChangeStreamsUnsupported = errors.New("change streams need a replica set or a sharded cluster, and are not supported on time-series runs")
+
+// Change is a change to a document of a watched collection
+type Change struct {
+	// OperationType is the kind of change: insert, update, replace, delete, or invalidate when the collection was
+	// dropped or renamed
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	// DocumentKey holds the _id of the changed document
+	DocumentKey bson.Raw `bson:"documentKey"`
+	// FullDocument is the inserted or replaced document, or the document after an update as looked up when the
+	// change is read, empty for deletes
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// ChangeHandler handles a change of a change stream. A handler error stops the stream.
+type ChangeHandler func(ctx context.Context, change Change) error
+
+// ChangeStream delivers the changes of a collection to a named consumer, saving its resume token in the database
+// so that a restarted consumer resumes after the last change it handled. Changes are delivered at least once: the
+// changes handled after the last saved token are delivered again after a restart, so handlers must be idempotent.
+// Consumers are told apart by name, and two processes running a consumer of the same name receive the same changes.
+type ChangeStream struct {
+	db         *MongoDB
+	name       string
+	collection string
+	pipeline   mongo.Pipeline
+	tokens     collection
+	timeouts   Timeouts
+}
+
+// NewChangeStream creates the change stream of a consumer of the changes of a collection of a database, filtered
+// by the stages of pipeline, such as a $match on operationType. The stream is of the whole collection, regardless
+// of the scope of the database.
+func NewChangeStream(db *MongoDB, name, collectionName string, pipeline mongo.Pipeline) *ChangeStream {
+	return &ChangeStream{
+		db:         db,
+		name:       name,
+		collection: collectionName,
+		pipeline:   pipeline,
+		tokens:     db.Collection(changeStreamTokensCollection),
+		timeouts:   db.Timeouts(),
+	}
+}
+
+// Run delivers the changes of the collection to handle until the context is cancelled or handle fails, returning
+// the handler's error. The stream is opened again after the last change handled when it fails, with a backoff, and
+// from the current time when its resume token expired from the oplog, skipping the changes made meanwhile.
+func (s *ChangeStream) Run(ctx context.Context, handle ChangeHandler) error {
+	s.db.conn.mu.RLock()
+	supported := s.db.conn.transactions
+	s.db.conn.mu.RUnlock()
+	if !supported || (s.db.timeSeriesRuns && s.collection == "agent_runs") {
+		return ErrChangeStreamsUnsupported
+	}
+
+	token, err := s.loadToken(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to load the resume token of change stream %s: %w", s.name, err)
+	}
+
+	backoff := changeStreamMinBackoff
+	for {
+		handled, err := s.watch(ctx, &token, handle)
+		var handlerErr *changeHandlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if hasErrorCode(err, []int{changeStreamHistoryLostCode}) {
+			log.Printf("The resume token of change stream %s expired, resuming from now: %v", s.name, err)
+			token = nil
+			continue
+		}
+
+		if handled {
+			backoff = changeStreamMinBackoff
+		}
+		log.Printf("Change stream %s failed, reopening it in %s: %v", s.name, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > changeStreamMaxBackoff {
+			backoff = changeStreamMaxBackoff
+		}
+	}
+}
+
+// changeHandlerError is the error of a change handler, telling it apart from the errors of the stream
+type changeHandlerError struct {
+	err error
+}
+
+func (e *changeHandlerError) Error() string {
+	return e.err.Error()
+}
+
+// watch opens the stream after token, and delivers its changes until it fails, updating token and saving it as
+// they are handled. It reports whether any change was handled.
+func (s *ChangeStream) watch(ctx context.Context, token *bson.Raw, handle ChangeHandler) (bool, error) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if *token != nil {
+		// StartAfter rather than ResumeAfter, so that the stream resumes after an invalidate event as well
+		opts.SetStartAfter(*token)
+	}
+	pipeline := s.pipeline
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+	stream, err := s.db.Database().Collection(s.collection).Watch(ctx, pipeline, opts)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close(context.Background())
+	if *token == nil {
+		// Reopening the stream after a failure resumes from when it was first opened
+		*token = stream.ResumeToken()
+	}
+
+	handled := false
+	saved := true
+	savedAt := time.Now()
+	save := func(ctx context.Context) {
+		if err := s.saveToken(ctx, *token); err != nil {
+			log.Printf("Unable to save the resume token of change stream %s: %v", s.name, err)
+			return
+		}
+		saved = true
+		savedAt = time.Now()
+	}
+	for {
+		if !stream.TryNext(ctx) {
+			if stream.Err() != nil {
+				break
+			}
+			// Caught up with the changes, the token is saved before waiting for the next ones
+			if !saved {
+				save(ctx)
+			}
+			if !stream.Next(ctx) {
+				break
+			}
+		}
+
+		var change Change
+		if err := stream.Decode(&change); err != nil {
+			return handled, err
+		}
+		if err := handle(ctx, change); err != nil {
+			if !saved {
+				save(context.WithoutCancel(ctx))
+			}
+			return handled, &changeHandlerError{err: err}
+		}
+		handled = true
+		*token = stream.ResumeToken()
+		saved = false
+		if time.Since(savedAt) >= changeStreamSaveInterval {
+			save(ctx)
+		}
+	}
+	if !saved {
+		save(context.WithoutCancel(ctx))
+	}
+	return handled, stream.Err()
+}
+
+// loadToken returns the saved resume token of the consumer, nil when none was saved
+func (s *ChangeStream) loadToken(ctx context.Context) (bson.Raw, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var saved struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := s.tokens.FindOne(ctx, bson.M{"_id": s.name}).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return saved.Token, nil
+}
+
+// saveToken saves the resume token of the consumer
+func (s *ChangeStream) saveToken(ctx context.Context, token bson.Raw) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	_, err := s.tokens.UpdateOne(ctx, bson.M{"_id": s.name}, bson.M{
+		"$set": bson.M{"token": token, "collection": s.collection, "updated_at": time.Now()},
+	}, options.Update().SetUpsert(true))
+	return err
+}
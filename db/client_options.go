@@ -0,0 +1,73 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// compressors are the wire protocol compressors the driver supports
+var compressors = map[string]bool{"snappy": true, "zlib": true, "zstd": true}
+
+// ClientOptions tune the client of a connection. Zero values keep the setting of the URI, or the driver default.
+type ClientOptions struct {
+	// MaxPoolSize and MinPoolSize bound the connections kept open to each server
+	MaxPoolSize uint64
+	MinPoolSize uint64
+	// ServerSelectionTimeout is how long an operation waits for a server to run on, such as while the replica set
+	// elects a new primary
+	ServerSelectionTimeout time.Duration
+	// Compressors are the compressors of the messages exchanged with the servers, in order of preference
+	Compressors []string
+	// RetryWrites, when set, enables or disables retrying the writes failing on a network error or a failover once
+	RetryWrites *bool
+	// ReadPreference, when set, selects the members of the replica set reads run on
+	ReadPreference *readpref.ReadPref
+}
+
+// ParseCompressors parses a comma separated list of compressors: snappy, zlib or zstd
+func ParseCompressors(spec string) ([]string, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	names := []string{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if !compressors[name] {
+			return nil, fmt.Errorf("unknown compressor %q, expected snappy, zlib or zstd", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// apply returns the options of a client connecting to uri with the options
+func (o ClientOptions) apply(uri string) (*options.ClientOptions, error) {
+	if o.MaxPoolSize > 0 && o.MinPoolSize > o.MaxPoolSize {
+		return nil, fmt.Errorf("the minimum pool size %d is larger than the maximum pool size %d", o.MinPoolSize, o.MaxPoolSize)
+	}
+
+	clientOptions := options.Client().ApplyURI(uri)
+	if o.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	}
+	if len(o.Compressors) > 0 {
+		clientOptions.SetCompressors(o.Compressors)
+	}
+	if o.RetryWrites != nil {
+		clientOptions.SetRetryWrites(*o.RetryWrites)
+	}
+	if o.ReadPreference != nil {
+		clientOptions.SetReadPreference(o.ReadPreference)
+	}
+	return clientOptions, nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeploymentRepository handles database operations for the deployment events of agent versions
+type DeploymentRepository struct {
+	db       *MongoDB
+	events   collection
+	timeouts Timeouts
+}
+
+// NewDeploymentRepository creates a new deployment repository
+func NewDeploymentRepository(db *MongoDB) *DeploymentRepository {
+	return &DeploymentRepository{
+		db:       db,
+		events:   db.Collection("deployment_events"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the index deployment events are listed by
+func (r *DeploymentRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "version", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+// CreateDeploymentEvent stores a deployment event
+func (r *DeploymentRepository) CreateDeploymentEvent(ctx context.Context, event *models.DeploymentEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	event.CreatedAt = time.Now()
+	result, err := r.events.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	event.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListDeploymentEvents retrieves the latest deployment events of an agent version, newest first
+func (r *DeploymentRepository) ListDeploymentEvents(ctx context.Context, agentID primitive.ObjectID, version string, limit int64) ([]models.DeploymentEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cursor, err := r.events.Find(ctx, bson.M{"agent_id": agentID, "version": version}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.DeploymentEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
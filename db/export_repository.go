@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportRepository handles database operations for export jobs and reads the runs they export
+type ExportRepository struct {
+	db       *MongoDB
+	jobs     collection
+	runs     collection
+	timeouts Timeouts
+}
+
+// NewExportRepository creates a new export repository
+func NewExportRepository(db *MongoDB) *ExportRepository {
+	return &ExportRepository{
+		db:       db,
+		jobs:     db.Collection("export_jobs"),
+		runs:     db.Collection("agent_runs"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateExportJob creates a new pending export job
+func (r *ExportRepository) CreateExportJob(ctx context.Context, job *models.ExportJob) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	job.Status = models.ExportStatusPending
+	job.Files = []models.ExportFile{}
+	job.CreatedAt = time.Now()
+
+	result, err := r.jobs.InsertOne(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	job.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetExportJob retrieves an export job by ID
+func (r *ExportRepository) GetExportJob(ctx context.Context, id primitive.ObjectID) (*models.ExportJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var job models.ExportJob
+	err := r.jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("export job not found")
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ListExportJobs retrieves the most recent export jobs
+func (r *ExportRepository) ListExportJobs(ctx context.Context, limit int64) ([]models.ExportJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.jobs.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.ExportJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// ClaimExportJob marks the oldest pending export job as running and returns it, or returns nil when there is none.
+// Jobs running for longer than staleAfter were abandoned by a stopped server and are claimed again.
+func (r *ExportRepository) ClaimExportJob(ctx context.Context, staleAfter time.Duration) (*models.ExportJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": models.ExportStatusPending},
+		{"status": models.ExportStatusRunning, "started_at": bson.M{"$lt": now.Add(-staleAfter)}},
+	}}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     models.ExportStatusRunning,
+			"started_at": now,
+			"files":      []models.ExportFile{},
+			"rows":       0,
+		},
+		"$unset": bson.M{"error": ""},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.ExportJob
+	if err := r.jobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// AddExportFile records a file written by a running export job
+func (r *ExportRepository) AddExportFile(ctx context.Context, id primitive.ObjectID, file models.ExportFile) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.jobs.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$push": bson.M{"files": file},
+		"$inc":  bson.M{"rows": file.Rows},
+	})
+	return err
+}
+
+// FinishExportJob marks an export job as completed, or as failed when jobErr is set
+func (r *ExportRepository) FinishExportJob(ctx context.Context, id primitive.ObjectID, jobErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	set := bson.M{
+		"status":       models.ExportStatusCompleted,
+		"completed_at": time.Now(),
+	}
+	if jobErr != nil {
+		set["status"] = models.ExportStatusFailed
+		set["error"] = jobErr.Error()
+	}
+
+	_, err := r.jobs.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// ExportRuns calls fn with the runs of all agents created in [from, to), oldest first, reading them from a cursor
+func (r *ExportRepository) ExportRuns(ctx context.Context, from, to time.Time, fn func(run *models.AgentRun) error) error {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(1000)
+	cursor, err := r.runs.Find(ctx, bson.M{"created": bson.M{"$gte": from, "$lt": to}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var run models.AgentRun
+		if err := cursor.Decode(&run); err != nil {
+			return err
+		}
+		if err := fn(&run); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexOptionsConflict is the MongoDB error code returned when an index exists with different options
+const indexOptionsConflict = 85
+
+// IdempotencyRepository stores the responses to write requests sent with an Idempotency-Key header.
+// Records expire through a TTL index on created_at.
+type IdempotencyRepository struct {
+	db       *MongoDB
+	keys     collection
+	timeouts Timeouts
+}
+
+// NewIdempotencyRepository creates a new idempotency repository
+func NewIdempotencyRepository(db *MongoDB) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		db:       db,
+		keys:     db.Collection("idempotency_keys"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the TTL index removing records older than ttl, updating its expiry when it changed
+func (r *IdempotencyRepository) EnsureIndexes(ctx context.Context, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	seconds := int32(ttl.Seconds())
+	_, err := r.keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexOptionsConflict {
+		return r.db.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: r.keys.Name()},
+			{Key: "index", Value: bson.M{
+				"keyPattern":         bson.M{"created_at": 1},
+				"expireAfterSeconds": seconds,
+			}},
+		}).Err()
+	}
+	return err
+}
+
+// ReserveKey stores a pending record for a request. When a record with the same ID already exists it is
+// returned instead, and nothing is stored.
+func (r *IdempotencyRepository) ReserveKey(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	record.Completed = false
+	record.CreatedAt = time.Now()
+	_, err := r.keys.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var existing models.IdempotencyRecord
+	if err := r.keys.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&existing); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("idempotency key expired while reserving it, retry the request")
+		}
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// CompleteKey stores the response of a reserved request
+func (r *IdempotencyRepository) CompleteKey(ctx context.Context, id string, status int, contentType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.keys.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"completed":    true,
+		"status":       status,
+		"content_type": contentType,
+		"body":         body,
+	}})
+	return err
+}
+
+// ReleaseKey removes a record, so the request can be sent again with the same key
+func (r *IdempotencyRepository) ReleaseKey(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.keys.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Locale holds the number formatting rules and phrases used to render UI payloads
+type Locale struct {
+	Tag        string
+	decimalSep string
+	groupSep   string
+	// symbolAfter places the currency symbol after the amount
+	symbolAfter bool
+	currency    *Currency
+	phrases     map[string]string
+}
+
+// Currency is the currency costs are recorded in. Amounts are only labelled with it, never converted.
+type Currency struct {
+	Code     string
+	Symbol   string
+	Decimals int
+	// Icon is the name of the icon of the currency on the dashboard
+	Icon string
+}
+
+var currencies = map[string]*Currency{
+	"USD": {Code: "USD", Symbol: "$", Decimals: 2, Icon: "DollarSign"},
+	"EUR": {Code: "EUR", Symbol: "€", Decimals: 2, Icon: "Euro"},
+	"GBP": {Code: "GBP", Symbol: "£", Decimals: 2, Icon: "PoundSterling"},
+	"JPY": {Code: "JPY", Symbol: "¥", Decimals: 0, Icon: "JapaneseYen"},
+	"CNY": {Code: "CNY", Symbol: "CN¥", Decimals: 2, Icon: "Banknote"},
+	"INR": {Code: "INR", Symbol: "₹", Decimals: 2, Icon: "IndianRupee"},
+	"CAD": {Code: "CAD", Symbol: "CA$", Decimals: 2, Icon: "DollarSign"},
+	"AUD": {Code: "AUD", Symbol: "A$", Decimals: 2, Icon: "DollarSign"},
+	"CHF": {Code: "CHF", Symbol: "CHF", Decimals: 2, Icon: "Banknote"},
+	"BRL": {Code: "BRL", Symbol: "R$", Decimals: 2, Icon: "Banknote"},
+}
+
+// DefaultCurrency is the currency used when none is configured
+var DefaultCurrency = currencies["USD"]
+
+// LookupCurrency returns the currency for an ISO 4217 code such as "EUR". Codes without a known symbol are used as
+// their own symbol, with two decimals.
+func LookupCurrency(code string) (*Currency, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if currency, ok := currencies[code]; ok {
+		return currency, true
+	}
+	if len(code) != 3 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return nil, false
+	}
+	return &Currency{Code: code, Symbol: code, Decimals: 2, Icon: "Banknote"}, true
+}
+
+var locales = map[string]*Locale{
+	"en": {
+		Tag:        "en",
+		decimalSep: ".",
+		groupSep:   ",",
+		phrases: map[string]string{
+			"active_agents":        "Active Agents",
+			"runs_today":           "Total Runs Today",
+			"avg_response_time":    "Avg Response Time",
+			"cost_today":           "Total Cost Today",
+			"from_last_week":       "%s from last week",
+			"from_yesterday":       "%s from yesterday",
+			"from_last_hour":       "%s from last hour",
+			"runs":                 "Total Runs",
+			"total_cost":           "Total Cost",
+			"from_previous_period": "%s from previous period",
+			"budget_remaining":     "Budget Remaining",
+			"budget_consumed":      "%s of budget used",
+		},
+	},
+	"de": {
+		Tag:         "de",
+		decimalSep:  ",",
+		groupSep:    ".",
+		symbolAfter: true,
+		phrases: map[string]string{
+			"active_agents":        "Aktive Agenten",
+			"runs_today":           "Ausführungen heute",
+			"avg_response_time":    "Durchschn. Antwortzeit",
+			"cost_today":           "Kosten heute",
+			"from_last_week":       "%s gegenüber letzter Woche",
+			"from_yesterday":       "%s gegenüber gestern",
+			"from_last_hour":       "%s gegenüber letzter Stunde",
+			"runs":                 "Ausführungen",
+			"total_cost":           "Gesamtkosten",
+			"from_previous_period": "%s gegenüber dem vorherigen Zeitraum",
+			"budget_remaining":     "Verbleibendes Budget",
+			"budget_consumed":      "%s des Budgets verbraucht",
+		},
+	},
+	"fr": {
+		Tag:         "fr",
+		decimalSep:  ",",
+		groupSep:    " ",
+		symbolAfter: true,
+		phrases: map[string]string{
+			"active_agents":        "Agents actifs",
+			"runs_today":           "Exécutions aujourd'hui",
+			"avg_response_time":    "Temps de réponse moyen",
+			"cost_today":           "Coût aujourd'hui",
+			"from_last_week":       "%s par rapport à la semaine dernière",
+			"from_yesterday":       "%s par rapport à hier",
+			"from_last_hour":       "%s par rapport à l'heure précédente",
+			"runs":                 "Exécutions",
+			"total_cost":           "Coût total",
+			"from_previous_period": "%s par rapport à la période précédente",
+			"budget_remaining":     "Budget restant",
+			"budget_consumed":      "%s du budget consommé",
+		},
+	},
+	"es": {
+		Tag:         "es",
+		decimalSep:  ",",
+		groupSep:    ".",
+		symbolAfter: true,
+		phrases: map[string]string{
+			"active_agents":        "Agentes activos",
+			"runs_today":           "Ejecuciones hoy",
+			"avg_response_time":    "Tiempo de respuesta medio",
+			"cost_today":           "Coste hoy",
+			"from_last_week":       "%s respecto a la semana pasada",
+			"from_yesterday":       "%s respecto a ayer",
+			"from_last_hour":       "%s respecto a la última hora",
+			"runs":                 "Ejecuciones",
+			"total_cost":           "Coste total",
+			"from_previous_period": "%s respecto al periodo anterior",
+			"budget_remaining":     "Presupuesto restante",
+			"budget_consumed":      "%s del presupuesto consumido",
+		},
+	},
+}
+
+// DefaultLocale is the locale used when none is requested
+var DefaultLocale = locales["en"]
+
+// LookupLocale returns the locale for a language tag such as "de" or "de-DE"
+func LookupLocale(tag string) (*Locale, bool) {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	locale, ok := locales[lang]
+	return locale, ok
+}
+
+// FormatInt formats an integer with the locale's thousands separator
+func (l *Locale) FormatInt(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+
+	digits := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(l.groupSep)
+		}
+		b.WriteRune(d)
+	}
+
+	return sign + b.String()
+}
+
+// FormatDecimal formats a number with the given precision using the locale's separators
+func (l *Locale) FormatDecimal(f float64, precision int) string {
+	s := strconv.FormatFloat(f, 'f', precision, 64)
+	intPart, fracPart, _ := strings.Cut(s, ".")
+
+	n, _ := strconv.ParseInt(intPart, 10, 64)
+	formatted := l.FormatInt(n)
+	if n == 0 && strings.HasPrefix(intPart, "-") {
+		formatted = "-" + formatted
+	}
+	if fracPart == "" {
+		return formatted
+	}
+	return formatted + l.decimalSep + fracPart
+}
+
+// WithCurrency returns a copy of the locale formatting monetary amounts in a currency
+func (l *Locale) WithCurrency(currency *Currency) *Locale {
+	c := *l
+	c.currency = currency
+	return &c
+}
+
+// Currency returns the currency monetary amounts are formatted in
+func (l *Locale) Currency() *Currency {
+	if l.currency == nil {
+		return DefaultCurrency
+	}
+	return l.currency
+}
+
+// FormatCurrency formats a monetary amount with the decimals and symbol of the locale's currency
+func (l *Locale) FormatCurrency(f float64) string {
+	currency := l.Currency()
+	amount := l.FormatDecimal(f, currency.Decimals)
+	if l.symbolAfter {
+		return amount + " " + currency.Symbol
+	}
+
+	// Symbols ending with a letter, such as CHF, are separated from the amount
+	last := currency.Symbol[len(currency.Symbol)-1]
+	if last >= 'A' && last <= 'Z' {
+		return currency.Symbol + " " + amount
+	}
+	return currency.Symbol + amount
+}
+
+// Phrase renders a localized phrase
+func (l *Locale) Phrase(key string, args ...interface{}) string {
+	phrase, ok := l.phrases[key]
+	if !ok {
+		phrase = DefaultLocale.phrases[key]
+	}
+	if len(args) == 0 {
+		return phrase
+	}
+	return fmt.Sprintf(phrase, args...)
+}
@@ -0,0 +1,601 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MetricsRepository handles the computation and storage of aggregated agent version metrics
+type MetricsRepository struct {
+	db       *MongoDB
+	agents   collection
+	versions collection
+	runs     collection
+	metrics  collection
+}
+
+// NewMetricsRepository creates a new metrics repository
+func NewMetricsRepository(db *MongoDB) *MetricsRepository {
+	return &MetricsRepository{
+		db:       db,
+		agents:   db.Collection("agents"),
+		versions: db.Collection("agent_versions"),
+		runs:     db.Collection("agent_runs"),
+		metrics:  db.Collection("agent_version_metrics"),
+	}
+}
+
+// MetricsDryRun represents the result of running the aggregation without writing
+type MetricsDryRun struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Summary     map[string]int `json:"summary"`
+	Diffs       []MetricsDiff  `json:"diffs"`
+}
+
+// MetricsDiff represents the difference between the stored and the computed metrics of an agent version
+type MetricsDiff struct {
+	ID          primitive.ObjectID     `json:"id"`
+	Name        string                 `json:"name"`
+	Version     string                 `json:"version"`
+	Window      string                 `json:"window,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Cluster     string                 `json:"cluster,omitempty"`
+	Status      string                 `json:"status"`
+	Changes     map[string]FieldChange `json:"changes,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// FieldChange represents the stored and computed values of a single metrics field
+type FieldChange struct {
+	Current  interface{} `json:"current"`
+	Computed interface{} `json:"computed"`
+}
+
+// Diff statuses
+const (
+	DiffAdded     = "added"
+	DiffChanged   = "changed"
+	DiffUnchanged = "unchanged"
+	DiffStale     = "stale"
+	DiffError     = "error"
+)
+
+// ListAllAgents retrieves all agents
+func (r *MetricsRepository) ListAllAgents(ctx context.Context) ([]*models.Agent, error) {
+	cursor, err := r.agents.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	agents := []*models.Agent{}
+	if err := cursor.All(ctx, &agents); err != nil {
+		return nil, err
+	}
+
+	return agents, nil
+}
+
+// ListAllAgentVersions retrieves all agent versions
+func (r *MetricsRepository) ListAllAgentVersions(ctx context.Context) ([]*models.AgentVersion, error) {
+	cursor, err := r.versions.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*models.AgentVersion{}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// ComputeAgentVersionMetrics runs the aggregation queries for an agent version. Spend windows end at now, and the
+// daily spend starts at midnight in the timezone of now.
+func (r *MetricsRepository) ComputeAgentVersionMetrics(ctx context.Context, agent *models.Agent, agentVersion *models.AgentVersion, now time.Time) (*models.AgentVersionMetrics, error) {
+	count, err := r.runs.CountDocuments(ctx, bson.M{"version_id": agentVersion.ID})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch number of runs: %w", err)
+	}
+
+	// Last seen time
+	res := r.runs.FindOne(ctx, bson.M{"version_id": agentVersion.ID}, &options.FindOneOptions{
+		Sort: bson.M{
+			"recorded_at": -1,
+		},
+	})
+	if res.Err() != nil {
+		return nil, fmt.Errorf("unable to fetch last seen time: %w", res.Err())
+	}
+
+	lastRecord := models.AgentRun{}
+	if err := res.Decode(&lastRecord); err != nil {
+		return nil, fmt.Errorf("unable to fetch last seen time: %w", err)
+	}
+
+	// Count total errors
+	countErrors, err := r.runs.CountDocuments(ctx, bson.M{"version_id": agentVersion.ID, "status": "error"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch number of errors: %w", err)
+	}
+
+	// Average Time Taken, Total Cost and the cost over the spend windows
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	spendSince := func(start time.Time) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{
+			bson.M{"$and": bson.A{bson.M{"$gte": bson.A{"$created", start}}, bson.M{"$lt": bson.A{"$created", now}}}},
+			"$cost",
+			0.0,
+		}}}
+	}
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"version_id": agentVersion.ID,
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id": nil,
+				"avgTimeTaken": bson.M{
+					"$avg": "$time_taken",
+				},
+				"totalCost": bson.M{
+					"$sum": "$cost",
+				},
+				"spendToday": spendSince(today),
+				"spend7d":    spendSince(now.AddDate(0, 0, -7)),
+				"spend30d":   spendSince(now.AddDate(0, 0, -30)),
+			},
+		},
+	}
+
+	cursor, err := r.runs.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch metrics: %w", err)
+	}
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("unable to decode metrics: %w", err)
+	}
+
+	errorsByCategory, err := r.countErrorsByCategory(ctx, agentVersion.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	signals, err := r.healthSignals(ctx, agentVersion.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	signals.LastSeen = lastRecord.RecordedAt
+	health, healthReasons := models.ComputeHealth(signals, now)
+
+	var avgTimeTaken float64
+	var totalCost, spendToday, spend7d, spend30d float64
+
+	if len(results) > 0 {
+		if val, ok := results[0]["avgTimeTaken"].(float64); ok {
+			avgTimeTaken = val
+		}
+		if val, ok := results[0]["totalCost"].(float64); ok {
+			totalCost = val
+		}
+		if val, ok := results[0]["spendToday"].(float64); ok {
+			spendToday = val
+		}
+		if val, ok := results[0]["spend7d"].(float64); ok {
+			spend7d = val
+		}
+		if val, ok := results[0]["spend30d"].(float64); ok {
+			spend30d = val
+		}
+	}
+
+	return &models.AgentVersionMetrics{
+		Id:             agentVersion.ID,
+		Window:         models.MetricsWindowAll,
+		Environment:    models.MetricsEnvironmentDefault,
+		Name:           agent.Name,
+		Project:        agent.Project,
+		Team:           agent.Team,
+		Status:         agentVersion.Status,
+		LastSeen:       lastRecord.RecordedAt,
+		Version:        agentVersion.Version,
+		AverageRunTime: avgTimeTaken,
+		SuccessRate:    (float64(count-countErrors) / float64(count)) * 100,
+		TotalRuns:      count,
+		Spend:          totalCost,
+		SpendToday:     spendToday,
+		Spend7d:        spend7d,
+		Spend30d:       spend30d,
+		SpendTotal:     totalCost,
+		Tools:          agentVersion.Tools,
+		Models:         agentVersion.Models,
+		Cluster:        agentVersion.Cluster,
+		Errors:         errorsByCategory,
+		Health:         health,
+		HealthReasons:  healthReasons,
+	}, nil
+}
+
+// healthSignals sums the runs, failed runs and time taken of an agent version over the recent health window and the
+// baseline window preceding it
+func (r *MetricsRepository) healthSignals(ctx context.Context, versionID primitive.ObjectID, now time.Time) (models.HealthSignals, error) {
+	recentStart := now.Add(-models.HealthRecentWindow)
+	baselineStart := recentStart.Add(-models.HealthBaselineWindow)
+	recent := bson.M{"$gte": bson.A{"$created", recentStart}}
+	failed := bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$status", bson.A{models.RunStatusCompleted, models.RunStatusRunning}}}}}
+	sumIf := func(cond bson.M, value interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, value, 0}}}
+	}
+	timeTaken := bson.M{"$ifNull": bson.A{"$time_taken", 0.0}}
+
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"version_id": versionID,
+			"created":    bson.M{"$gte": baselineStart, "$lt": now},
+		}},
+		{"$group": bson.M{
+			"_id":           nil,
+			"recent_runs":   sumIf(recent, 1),
+			"recent_errors": sumIf(bson.M{"$and": bson.A{recent, failed}}, 1),
+			"recent_time":   sumIf(recent, timeTaken),
+			"runs":          bson.M{"$sum": 1},
+			"time":          bson.M{"$sum": timeTaken},
+		}},
+	})
+	if err != nil {
+		return models.HealthSignals{}, fmt.Errorf("unable to fetch health signals: %w", err)
+	}
+
+	var results []struct {
+		RecentRuns   int64   `bson:"recent_runs"`
+		RecentErrors int64   `bson:"recent_errors"`
+		RecentTime   float64 `bson:"recent_time"`
+		Runs         int64   `bson:"runs"`
+		Time         float64 `bson:"time"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return models.HealthSignals{}, fmt.Errorf("unable to decode health signals: %w", err)
+	}
+
+	var signals models.HealthSignals
+	if len(results) == 0 {
+		return signals, nil
+	}
+
+	result := results[0]
+	signals.RecentRuns = result.RecentRuns
+	signals.RecentErrors = result.RecentErrors
+	if result.RecentRuns > 0 {
+		signals.RecentAvgTime = result.RecentTime / float64(result.RecentRuns)
+	}
+	signals.BaselineRuns = result.Runs - result.RecentRuns
+	if signals.BaselineRuns > 0 {
+		signals.BaselineAvgTime = (result.Time - result.RecentTime) / float64(signals.BaselineRuns)
+	}
+	return signals, nil
+}
+
+// countErrorsByCategory counts the failed runs of an agent version by error category
+func (r *MetricsRepository) countErrorsByCategory(ctx context.Context, versionID primitive.ObjectID) (map[string]int64, error) {
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"version_id": versionID,
+			"status":     bson.M{"$nin": bson.A{models.RunStatusCompleted, models.RunStatusRunning}},
+		}},
+		{"$group": bson.M{
+			"_id":   errorCategoryExpr,
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to count errors by category: %w", err)
+	}
+
+	var results []struct {
+		Category string `bson:"_id"`
+		Count    int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("unable to decode errors by category: %w", err)
+	}
+
+	errorsByCategory := make(map[string]int64, len(results))
+	for _, result := range results {
+		errorsByCategory[result.Category] = result.Count
+	}
+	return errorsByCategory, nil
+}
+
+// UpsertAgentVersionMetrics writes the metrics of an agent version, keyed by version, window, environment and cluster
+func (r *MetricsRepository) UpsertAgentVersionMetrics(ctx context.Context, avm *models.AgentVersionMetrics) error {
+	upsert := true
+	updateDoc := bson.M{
+		"$set": avm,
+	}
+	_, err := r.metrics.UpdateOne(ctx, avm.Key(), updateDoc, &options.UpdateOptions{
+		Upsert: &upsert,
+	})
+	if err != nil {
+		return err
+	}
+	r.db.invalidateReads(ctx, metricsGeneration(avm.Id))
+	return nil
+}
+
+// EnsureMetricsSchema migrates metrics documents keyed by the version ID to the composite key
+// and creates the unique index on the composite key. It is safe to run repeatedly.
+func (r *MetricsRepository) EnsureMetricsSchema(ctx context.Context) (int, error) {
+	migrated, err := r.migrateMetricsKeys(ctx)
+	if err != nil {
+		return migrated, fmt.Errorf("unable to migrate agent version metrics: %w", err)
+	}
+
+	_, err = r.metrics.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "version_id", Value: 1},
+				{Key: "window", Value: 1},
+				{Key: "environment", Value: 1},
+				{Key: "cluster", Value: 1},
+			},
+			Options: options.Index().SetName("metrics_key").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "lastSeen", Value: -1}},
+			Options: options.Index().SetName("metrics_last_seen"),
+		},
+	})
+	if err != nil {
+		return migrated, fmt.Errorf("unable to create agent version metrics indexes: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// migrateMetricsKeys rewrites the documents that still use the version ID as _id. As _id cannot be
+// updated, each document is copied with the composite key fields and a new _id, then the original is removed.
+func (r *MetricsRepository) migrateMetricsKeys(ctx context.Context) (int, error) {
+	cursor, err := r.metrics.Find(ctx, bson.M{"version_id": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, err
+		}
+
+		versionID, ok := doc["_id"].(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+
+		delete(doc, "_id")
+		doc["version_id"] = versionID
+		if _, ok := doc["window"]; !ok {
+			doc["window"] = models.MetricsWindowAll
+		}
+		if _, ok := doc["environment"]; !ok {
+			doc["environment"] = models.MetricsEnvironmentDefault
+		}
+		if _, ok := doc["cluster"]; !ok {
+			doc["cluster"] = ""
+		}
+
+		key := bson.M{
+			"version_id":  doc["version_id"],
+			"window":      doc["window"],
+			"environment": doc["environment"],
+			"cluster":     doc["cluster"],
+		}
+
+		// Upsert rather than insert so that an interrupted migration can be resumed
+		upsert := true
+		if _, err := r.metrics.UpdateOne(ctx, key, bson.M{"$setOnInsert": doc}, &options.UpdateOptions{
+			Upsert: &upsert,
+		}); err != nil {
+			return migrated, err
+		}
+		if _, err := r.metrics.DeleteOne(ctx, bson.M{"_id": versionID}); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+// DryRun computes the metrics of all agent versions without writing them and
+// returns the differences with the stored agent_version_metrics. Daily spend follows the reporting timezones.
+func (r *MetricsRepository) DryRun(ctx context.Context, timezones *Timezones) (*MetricsDryRun, error) {
+	stored, err := r.ListAgentVersionMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ComputeMetricsDryRun(ctx, r, stored, timezones)
+}
+
+// ListAgentVersionMetrics lists the stored metrics of every agent version
+func (r *MetricsRepository) ListAgentVersionMetrics(ctx context.Context) ([]models.AgentVersionMetrics, error) {
+	cursor, err := r.metrics.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch agent version metrics: %w", err)
+	}
+
+	stored := []models.AgentVersionMetrics{}
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("unable to fetch agent version metrics: %w", err)
+	}
+	return stored, nil
+}
+
+// ComputeMetricsDryRun computes the metrics of all agent versions of a store without writing them and returns the
+// differences with its stored metrics. Daily spend follows the reporting timezones.
+func ComputeMetricsDryRun(ctx context.Context, store MetricsStore, stored []models.AgentVersionMetrics, timezones *Timezones) (*MetricsDryRun, error) {
+	agents, err := store.ListAllAgents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch agents: %w", err)
+	}
+
+	agentLookup := make(map[primitive.ObjectID]*models.Agent, len(agents))
+	for _, a := range agents {
+		agentLookup[a.ID] = a
+	}
+
+	versions, err := store.ListAllAgentVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch agent versions: %w", err)
+	}
+
+	storedLookup := make(map[models.MetricsKey]models.AgentVersionMetrics, len(stored))
+	for _, m := range stored {
+		storedLookup[m.Key()] = m
+	}
+
+	result := &MetricsDryRun{
+		GeneratedAt: time.Now(),
+		Summary:     map[string]int{},
+		Diffs:       []MetricsDiff{},
+	}
+
+	seen := make(map[models.MetricsKey]bool, len(versions))
+	for _, av := range versions {
+		diff := MetricsDiff{
+			ID:      av.ID,
+			Version: av.Version,
+		}
+
+		agent, ok := agentLookup[av.AgentID]
+		if !ok {
+			diff.Status = DiffError
+			diff.Error = "agent not found"
+			result.add(diff)
+			continue
+		}
+		diff.Name = agent.Name
+		// Archived agents are not aggregated by the worker
+		if agent.Archived {
+			continue
+		}
+
+		computed, err := store.ComputeAgentVersionMetrics(ctx, agent, av, time.Now().In(timezones.For(agent.Project)))
+		if err != nil {
+			diff.Status = DiffError
+			diff.Error = err.Error()
+			result.add(diff)
+			continue
+		}
+
+		key := computed.Key()
+		seen[key] = true
+		diff.Window, diff.Environment, diff.Cluster = key.Window, key.Environment, key.Cluster
+
+		current, ok := storedLookup[key]
+		if !ok {
+			diff.Status = DiffAdded
+			diff.Changes = diffMetrics(nil, computed)
+			result.add(diff)
+			continue
+		}
+
+		diff.Changes = diffMetrics(&current, computed)
+		diff.Status = DiffUnchanged
+		if len(diff.Changes) > 0 {
+			diff.Status = DiffChanged
+		}
+		result.add(diff)
+	}
+
+	// Metrics documents whose version no longer exists, or whose key is no longer computed, are left untouched by the worker
+	for _, m := range stored {
+		if !seen[m.Key()] {
+			result.add(MetricsDiff{
+				ID:          m.Id,
+				Name:        m.Name,
+				Version:     m.Version,
+				Window:      m.Window,
+				Environment: m.Environment,
+				Cluster:     m.Cluster,
+				Status:      DiffStale,
+			})
+		}
+	}
+
+	sort.SliceStable(result.Diffs, func(i, j int) bool {
+		if result.Diffs[i].Name != result.Diffs[j].Name {
+			return result.Diffs[i].Name < result.Diffs[j].Name
+		}
+		return result.Diffs[i].Version < result.Diffs[j].Version
+	})
+
+	return result, nil
+}
+
+// add appends a diff and updates the summary
+func (d *MetricsDryRun) add(diff MetricsDiff) {
+	d.Diffs = append(d.Diffs, diff)
+	d.Summary[diff.Status]++
+}
+
+// diffMetrics returns the fields that differ between the stored and the computed metrics, keyed by JSON name
+func diffMetrics(current, computed *models.AgentVersionMetrics) map[string]FieldChange {
+	currentFields := metricsFields(current)
+	computedFields := metricsFields(computed)
+
+	changes := map[string]FieldChange{}
+	for name, computedValue := range computedFields {
+		currentValue := currentFields[name]
+		if !valuesEqual(currentValue, computedValue) {
+			changes[name] = FieldChange{Current: currentValue, Computed: computedValue}
+		}
+	}
+
+	return changes
+}
+
+// metricsFields converts metrics into a map keyed by JSON field name
+func metricsFields(m *models.AgentVersionMetrics) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if m == nil {
+		return fields
+	}
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(raw, &fields)
+	delete(fields, "id")
+
+	return fields
+}
+
+// valuesEqual compares two decoded JSON values, with a tolerance for floating point numbers
+func valuesEqual(a, b interface{}) bool {
+	af, aok := a.(float64)
+	bf, bok := b.(float64)
+	if aok && bok {
+		return math.Abs(af-bf) <= 1e-9*math.Max(1, math.Max(math.Abs(af), math.Abs(bf)))
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -2,25 +2,83 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"ripple/events"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MongoDB represents a MongoDB client connection
+// MongoDB represents a database of a MongoDB client connection
 type MongoDB struct {
-	Client   *mongo.Client
-	Database *mongo.Database
+	conn   *connection
+	name   string
+	Events *events.Broker
+	// Scope restricts the collections of the database to the documents of an organization and project, see ForScope
+	Scope
+	// shared holds the shared collections when the database is the own database of an organization, or a database
+	// in another region than the home region
+	shared *MongoDB
+	// region is the region of the database's cluster, empty for the home region, and regions the clusters of the
+	// other regions, nil when none are configured
+	region  string
+	regions *Regions
+	// timeSeriesRuns is set when the runs of the database are stored in a time-series collection, see
+	// EnsureTimeSeriesRuns
+	timeSeriesRuns bool
+	// runsShardKey is the shard key of the runs when they are sharded, see EnsureShardedRuns
+	runsShardKey bson.D
+	// missingScope is set on the database of the requests that were not scoped although tenancy requires them to be,
+	// see MissingScope
+	missingScope bool
+}
+
+// connection holds the client of the databases of a connection, replaced when it reconnects with new credentials
+type connection struct {
+	mu     sync.RWMutex
+	client *mongo.Client
+	// transactions is set when the client is connected to a deployment running multi-document transactions
+	transactions bool
+	timeouts     Timeouts
+	// options are the options the client connected with, which it reconnects with
+	options ClientOptions
+	breaker breaker
+	// analytics is the connection of the analytics client, see ConnectAnalytics, nil when none is connected
+	analytics *connection
+	// readCache caches the hot reads of the databases of the connection, see SetReadCache, nil when none is set
+	readCache *readCache
+}
+
+// NewMongoDB creates a new MongoDB connection, with the client options overriding those of uri
+func NewMongoDB(uri, dbName string, opts ClientOptions) (*MongoDB, error) {
+	client, err := connect(uri, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Connected to MongoDB!")
+	return &MongoDB{
+		conn:   &connection{client: client, transactions: supportsTransactions(client), timeouts: DefaultTimeouts, options: opts},
+		name:   dbName,
+		Events: events.NewBroker(),
+	}, nil
 }
 
-// NewMongoDB creates a new MongoDB connection
-func NewMongoDB(uri, dbName string) (*MongoDB, error) {
+// connect connects a client to uri and checks the connection
+func connect(uri string, opts ClientOptions) (*mongo.Client, error) {
+	clientOptions, err := opts.apply(uri)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOptions := options.Client().ApplyURI(uri)
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
@@ -29,19 +87,268 @@ func NewMongoDB(uri, dbName string) (*MongoDB, error) {
 	// Ping the database to verify connection
 	err = client.Ping(ctx, nil)
 	if err != nil {
+		client.Disconnect(ctx)
 		return nil, err
 	}
 
-	log.Println("Connected to MongoDB!")
+	return client, nil
+}
+
+// supportsTransactions reports whether a client is connected to a replica set or a sharded cluster, the deployments
+// running multi-document transactions. A standalone server, or one the check fails on, is taken not to.
+func supportsTransactions(client *mongo.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// Client returns the client of the connection
+func (m *MongoDB) Client() *mongo.Client {
+	m.conn.mu.RLock()
+	defer m.conn.mu.RUnlock()
+	return m.conn.client
+}
+
+// Database returns the database. Operations should get it when they run rather than keep it, since it belongs to
+// the client of the connection at the time.
+func (m *MongoDB) Database() *mongo.Database {
+	return m.Client().Database(m.name)
+}
+
+// SupportsTransactions reports whether writes to the database can run in a multi-document transaction: the
+// connection must be to a replica set or a sharded cluster, and the runs must not be stored in a time-series
+// collection, which transactions do not write to
+func (m *MongoDB) SupportsTransactions() bool {
+	m.conn.mu.RLock()
+	defer m.conn.mu.RUnlock()
+	return m.conn.transactions && !m.timeSeriesRuns
+}
+
+// Timeouts returns the timeouts of the operations of the databases of the connection
+func (m *MongoDB) Timeouts() Timeouts {
+	m.conn.mu.RLock()
+	defer m.conn.mu.RUnlock()
+	return m.conn.timeouts
+}
+
+// SetTimeouts sets the timeouts of the operations of the databases of the connection. Repositories created before
+// keep the previous timeouts.
+func (m *MongoDB) SetTimeouts(timeouts Timeouts) {
+	m.conn.mu.Lock()
+	defer m.conn.mu.Unlock()
+	m.conn.timeouts = timeouts
+}
+
+// Name returns the name of the database
+func (m *MongoDB) Name() string {
+	return m.name
+}
+
+// WithDatabase returns another database of the connection
+func (m *MongoDB) WithDatabase(name string) *MongoDB {
 	return &MongoDB{
-		Client:   client,
-		Database: client.Database(dbName),
-	}, nil
+		conn:    m.conn,
+		name:    name,
+		Events:  m.Events,
+		region:  m.region,
+		regions: m.regions,
+	}
+}
+
+// Reconnect connects to uri, typically with rotated credentials, and moves the databases of the connection to the
+// new client, along with those of the analytics client when one is connected. The previous client is disconnected
+// after drain, letting the operations it runs finish. The connection is left unchanged when connecting fails.
+func (m *MongoDB) Reconnect(uri string, drain time.Duration) error {
+	if err := m.conn.reconnect(uri, drain); err != nil {
+		return err
+	}
+	log.Println("Reconnected to MongoDB")
+
+	if analytics := m.conn.analyticsConnection(); analytics != nil {
+		if err := analytics.reconnect(uri, drain); err != nil {
+			return fmt.Errorf("unable to reconnect the analytics client: %w", err)
+		}
+	}
+	return nil
 }
 
-// Close closes the MongoDB connection
+// reconnect connects a new client to uri with the options of the connection, and replaces the client of the
+// connection with it, disconnecting the previous one after drain
+func (c *connection) reconnect(uri string, drain time.Duration) error {
+	client, err := connect(uri, c.options)
+	if err != nil {
+		return err
+	}
+	transactions := supportsTransactions(client)
+
+	c.mu.Lock()
+	previous := c.client
+	c.client = client
+	c.transactions = transactions
+	c.mu.Unlock()
+
+	time.AfterFunc(drain, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := previous.Disconnect(ctx); err != nil {
+			log.Printf("Failed to disconnect the previous MongoDB client: %v", err)
+		}
+	})
+	return nil
+}
+
+// Close closes the MongoDB connection, and its analytics client
 func (m *MongoDB) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return m.Client.Disconnect(ctx)
-}
\ No newline at end of file
+	if analytics := m.conn.analyticsConnection(); analytics != nil {
+		analytics.mu.RLock()
+		analytics.client.Disconnect(ctx)
+		analytics.mu.RUnlock()
+	}
+	return m.Client().Disconnect(ctx)
+}
+
+// liveCollection is a collection of the client the connection of its database has when an operation runs, so that
+// repositories move to the new client when the connection reconnects
+type liveCollection struct {
+	db   *MongoDB
+	name string
+}
+
+func (c *liveCollection) current() *mongo.Collection {
+	return c.db.Database().Collection(c.name)
+}
+
+func (c *liveCollection) Name() string {
+	return c.name
+}
+
+func (c *liveCollection) Indexes() mongo.IndexView {
+	return c.current().Indexes()
+}
+
+func (c *liveCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (result *mongo.Cursor, err error) {
+	// MongoDB stops running an aggregation once the operation timed out, rather than finishing it for nobody. Options
+	// setting their own maximum time take precedence.
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) > 0 {
+		opts = append([]*options.AggregateOptions{options.Aggregate().SetMaxTime(time.Until(deadline))}, opts...)
+	}
+	err = c.db.conn.breaker.do(ctx, false, func() error {
+		result, err = c.current().Aggregate(ctx, pipeline, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().BulkWrite(ctx, models, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (result int64, err error) {
+	err = c.db.conn.breaker.do(ctx, false, func() error {
+		result, err = c.current().CountDocuments(ctx, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().DeleteMany(ctx, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().DeleteOne(ctx, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (result []interface{}, err error) {
+	err = c.db.conn.breaker.do(ctx, false, func() error {
+		result, err = c.current().Distinct(ctx, fieldName, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (result *mongo.Cursor, err error) {
+	err = c.db.conn.breaker.do(ctx, false, func() error {
+		result, err = c.current().Find(ctx, filter, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	var result *mongo.SingleResult
+	err := c.db.conn.breaker.do(ctx, false, func() error {
+		result = c.current().FindOne(ctx, filter, opts...)
+		return result.Err()
+	})
+	if result == nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return result
+}
+
+func (c *liveCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	var result *mongo.SingleResult
+	err := c.db.conn.breaker.do(ctx, true, func() error {
+		result = c.current().FindOneAndUpdate(ctx, filter, update, opts...)
+		return result.Err()
+	})
+	if result == nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return result
+}
+
+func (c *liveCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().InsertMany(ctx, documents, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().InsertOne(ctx, document, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().UpdateMany(ctx, filter, update, opts...)
+		return err
+	})
+	return result, err
+}
+
+func (c *liveCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	err = c.db.conn.breaker.do(ctx, true, func() error {
+		result, err = c.current().UpdateOne(ctx, filter, update, opts...)
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OrganizationRepository handles database operations for organizations
+type OrganizationRepository struct {
+	db       *MongoDB
+	orgs     collection
+	timeouts Timeouts
+}
+
+// NewOrganizationRepository creates a new organization repository
+func NewOrganizationRepository(db *MongoDB) *OrganizationRepository {
+	return &OrganizationRepository{
+		db:       db,
+		orgs:     db.Collection("organizations"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the unique index on the names of organizations
+func (r *OrganizationRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.orgs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// CreateOrganization creates a new organization
+func (r *OrganizationRepository) CreateOrganization(ctx context.Context, org *models.Organization) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+
+	result, err := r.orgs.InsertOne(ctx, org)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("organization already exists")
+		}
+		return err
+	}
+
+	org.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetOrganization retrieves an organization by ID
+func (r *OrganizationRepository) GetOrganization(ctx context.Context, id primitive.ObjectID) (*models.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var org models.Organization
+	err := r.orgs.FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("organization not found")
+		}
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// ListOrganizations retrieves all organizations, sorted by name
+func (r *OrganizationRepository) ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	cursor, err := r.orgs.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orgs := []models.Organization{}
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// UpdateOrganization sets the quotas and retention of an organization, and returns the updated organization
+func (r *OrganizationRepository) UpdateOrganization(ctx context.Context, id primitive.ObjectID, quotas models.OrganizationQuotas, retentionDays int) (*models.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"quotas":         quotas,
+		"retention_days": retentionDays,
+		"updated_at":     time.Now(),
+	}}
+
+	var org models.Organization
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.orgs.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&org)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("organization not found")
+		}
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// GetOrganizationUsage counts the agents of an organization and the runs it wrote since a time
+func (r *OrganizationRepository) GetOrganizationUsage(ctx context.Context, id primitive.ObjectID, since time.Time) (*models.OrganizationUsage, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	org := r.db.ForScope(Scope{OrgID: id})
+	agents, err := org.Collection("agents").CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	runs, err := org.Collection("agent_runs").CountDocuments(ctx, bson.M{"recorded_at": bson.M{"$gte": since}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OrganizationUsage{Agents: agents, RunsToday: runs}, nil
+}
+
+// DeleteExpiredRuns deletes the runs an organization recorded before a time, returning how many were deleted
+func (r *OrganizationRepository) DeleteExpiredRuns(ctx context.Context, id primitive.ObjectID, before time.Time) (int64, error) {
+	result, err := r.db.ForScope(Scope{OrgID: id}).Collection("agent_runs").DeleteMany(ctx, bson.M{"recorded_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
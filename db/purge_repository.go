@@ -0,0 +1,293 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// purgeBatchSize is the number of runs deleted per batch by a purge job
+const purgeBatchSize = 1000
+
+// PurgeRepository handles database operations for purge jobs, the runs and steps they delete and their audit records
+type PurgeRepository struct {
+	db       *MongoDB
+	jobs     collection
+	runs     collection
+	steps    collection
+	audit    collection
+	timeouts Timeouts
+}
+
+// NewPurgeRepository creates a new purge repository
+func NewPurgeRepository(db *MongoDB) *PurgeRepository {
+	return &PurgeRepository{
+		db:       db,
+		jobs:     db.Collection("purge_jobs"),
+		runs:     db.Collection("agent_runs"),
+		steps:    db.Collection("run_steps"),
+		audit:    db.Collection("audit_log"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreatePurgeJob creates a new pending purge job and records the request in the audit log
+func (r *PurgeRepository) CreatePurgeJob(ctx context.Context, job *models.PurgeJob, record *models.AuditRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	job.Status = models.PurgeStatusPending
+	job.CreatedAt = time.Now()
+
+	result, err := r.jobs.InsertOne(ctx, job)
+	if err != nil {
+		return err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+
+	record.Action = models.AuditPurgeRequested
+	record.TargetID = job.ID
+	record.Time = job.CreatedAt
+	record.Details = map[string]interface{}{
+		"agent_id": job.AgentID,
+		"filter":   job.Filter,
+		"reason":   job.Reason,
+	}
+	return r.createAuditRecord(ctx, record)
+}
+
+// GetPurgeJob retrieves a purge job by ID
+func (r *PurgeRepository) GetPurgeJob(ctx context.Context, id primitive.ObjectID) (*models.PurgeJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var job models.PurgeJob
+	err := r.jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("purge job not found")
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ListPurgeJobs retrieves the most recent purge jobs
+func (r *PurgeRepository) ListPurgeJobs(ctx context.Context, limit int64) ([]models.PurgeJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.jobs.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.PurgeJob{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// ClaimPurgeJob marks the oldest pending purge job as running and returns it, or returns nil when there is none.
+// Jobs running for longer than staleAfter were abandoned by a stopped server and are claimed again; they resume
+// with the runs not deleted yet.
+func (r *PurgeRepository) ClaimPurgeJob(ctx context.Context, staleAfter time.Duration) (*models.PurgeJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"$or": []bson.M{
+		{"status": models.PurgeStatusPending},
+		{"status": models.PurgeStatusRunning, "started_at": bson.M{"$lt": now.Add(-staleAfter)}},
+	}}
+	update := bson.M{
+		"$set":   bson.M{"status": models.PurgeStatusRunning, "started_at": now},
+		"$unset": bson.M{"error": ""},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.PurgeJob
+	if err := r.jobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// PurgeRuns deletes the runs matching a purge job's filter in batches, with the steps of their traces, and
+// records the progress of the job after each batch
+func (r *PurgeRepository) PurgeRuns(ctx context.Context, job *models.PurgeJob) error {
+	query := purgeQuery(job.AgentID, job.Filter)
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "trace_id": 1}).
+		SetLimit(purgeBatchSize)
+
+	for {
+		cursor, err := r.runs.Find(ctx, query, opts)
+		if err != nil {
+			return err
+		}
+		var runs []struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			TraceID string             `bson:"trace_id"`
+		}
+		if err := cursor.All(ctx, &runs); err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			return nil
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(runs))
+		traceIDs := []string{}
+		for _, run := range runs {
+			ids = append(ids, run.ID)
+			if run.TraceID != "" {
+				traceIDs = append(traceIDs, run.TraceID)
+			}
+		}
+
+		deletedRuns, err := r.runs.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		var deletedSteps int64
+		if len(traceIDs) > 0 {
+			result, err := r.steps.DeleteMany(ctx, bson.M{"trace_id": bson.M{"$in": traceIDs}})
+			if err != nil {
+				return err
+			}
+			deletedSteps = result.DeletedCount
+		}
+
+		if _, err := r.jobs.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{
+			"$inc": bson.M{"runs_deleted": deletedRuns.DeletedCount, "steps_deleted": deletedSteps},
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// FinishPurgeJob marks a purge job as completed, or as failed when jobErr is set, and records the outcome in the
+// audit log
+func (r *PurgeRepository) FinishPurgeJob(ctx context.Context, id primitive.ObjectID, jobErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	set := bson.M{
+		"status":       models.PurgeStatusCompleted,
+		"completed_at": now,
+	}
+	if jobErr != nil {
+		set["status"] = models.PurgeStatusFailed
+		set["error"] = jobErr.Error()
+	}
+
+	var job models.PurgeJob
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := r.jobs.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&job); err != nil {
+		return err
+	}
+
+	record := &models.AuditRecord{
+		Action:   models.AuditPurgeCompleted,
+		TargetID: id,
+		Details: map[string]interface{}{
+			"agent_id":      job.AgentID,
+			"runs_deleted":  job.RunsDeleted,
+			"steps_deleted": job.StepsDeleted,
+		},
+		Time: now,
+	}
+	if jobErr != nil {
+		record.Action = models.AuditPurgeFailed
+		record.Details["error"] = jobErr.Error()
+	}
+	return r.createAuditRecord(ctx, record)
+}
+
+// ListAuditRecords retrieves the audit records of a purge job, oldest first
+func (r *PurgeRepository) ListAuditRecords(ctx context.Context, jobID primitive.ObjectID) ([]models.AuditRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.audit.Find(ctx, bson.M{"target_id": jobID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []models.AuditRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (r *PurgeRepository) createAuditRecord(ctx context.Context, record *models.AuditRecord) error {
+	result, err := r.audit.InsertOne(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	record.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// purgeQuery builds the query matching the runs of an agent selected by a purge filter
+func purgeQuery(agentID primitive.ObjectID, filter models.PurgeFilter) bson.M {
+	query := bson.M{"agent_id": agentID}
+
+	created := bson.M{}
+	if filter.From != nil {
+		created["$gte"] = *filter.From
+	}
+	if filter.To != nil {
+		created["$lt"] = *filter.To
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+
+	if filter.Version != "" {
+		query["version"] = filter.Version
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.Initiator != "" {
+		query["initiator"] = filter.Initiator
+	}
+	if filter.TaskID != nil {
+		query["task_id"] = *filter.TaskID
+	}
+	if filter.TraceID != "" {
+		query["trace_id"] = filter.TraceID
+	}
+
+	return query
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ListOptions holds optional parameters for list queries
+type ListOptions struct {
+	// Fields restricts the returned fields, using the JSON field names of the model
+	Fields []string
+	// Sort orders the results, using the JSON field names of the model
+	Sort []SortField
+	// Limit caps the number of results, zero means no limit
+	Limit int64
+	// Offset skips the first results, for pagination
+	Offset int64
+	// IncludeArchived includes archived agents, which are left out by default
+	IncludeArchived bool
+	// Project restricts agents and agent versions to a project
+	Project string
+	// Team restricts agents to those owned by a team
+	Team string
+}
+
+// SortField represents a single sort key
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// sortDocument builds a Mongo sort document for the requested JSON fields of a model,
+// falling back to the given default when no sort is requested
+func sortDocument(model interface{}, sort []SortField, fallback bson.D) bson.D {
+	if len(sort) == 0 {
+		return fallback
+	}
+
+	names := FieldNames(model)
+	doc := bson.D{}
+	for _, s := range sort {
+		name, ok := names[s.Field]
+		if !ok {
+			continue
+		}
+		order := 1
+		if s.Descending {
+			order = -1
+		}
+		doc = append(doc, bson.E{Key: name, Value: order})
+	}
+
+	if len(doc) == 0 {
+		return fallback
+	}
+	return doc
+}
+
+// FieldNames returns the mapping of JSON field names to document field names for a model
+func FieldNames(model interface{}) map[string]string {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	names := make(map[string]string, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName := tagName(field.Tag.Get("json"))
+		bsonName := tagName(field.Tag.Get("bson"))
+		if jsonName == "" || jsonName == "-" || bsonName == "" || bsonName == "-" {
+			continue
+		}
+		names[jsonName] = bsonName
+	}
+	if a, ok := model.(fieldAliaser); ok {
+		for alias, field := range a.FieldAliases() {
+			names[alias] = names[field]
+		}
+	}
+
+	return names
+}
+
+// fieldAliaser is implemented by the models rendering some JSON fields from others, see models.AgentRun.FieldAliases
+type fieldAliaser interface {
+	FieldAliases() map[string]string
+}
+
+// projection builds a Mongo projection for the requested JSON fields of a model.
+// It returns nil when no fields are requested so that full documents are returned.
+func projection(model interface{}, fields []string) bson.M {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	names := FieldNames(model)
+	proj := bson.M{}
+	for _, f := range fields {
+		if name, ok := names[f]; ok {
+			proj[name] = 1
+		}
+	}
+
+	return proj
+}
+
+// tagName returns the name part of a struct tag value
+func tagName(tag string) string {
+	name, _, _ := strings.Cut(tag, ",")
+	return name
+}
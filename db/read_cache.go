@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"ripple/cache"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultReadCacheTTL is how long the reads cached by SetReadCache are served from the cache unless configured
+	// otherwise
+	DefaultReadCacheTTL = time.Minute
+	// readCacheGenerationTTL is how long the generation of cached reads set by a write is kept, much longer than
+	// the reads cached under it
+	readCacheGenerationTTL = 24 * time.Hour
+)
+
+// readCache caches the hot reads of the repositories: the agents, the versions of an agent, and the metrics of a
+// version. Reads are cached under the generation of what they read, which the repositories replace when they write
+// it, so that a write invalidates the reads cached by every server sharing the cache, whatever their scope.
+type readCache struct {
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// SetReadCache caches the hot reads of the databases of the connection in c for ttl, those of the agents, of the
+// versions of an agent looked up on every run ingest, and of the metrics of a version. The repositories invalidate
+// the reads of what they write, so the processes writing to the databases, including the worker, must share the
+// cache. Writes made by other means, such as a restore, are read from the cache for up to ttl.
+func (m *MongoDB) SetReadCache(c cache.Cache, ttl time.Duration) {
+	rc := &readCache{cache: c, ttl: ttl}
+	m.conn.mu.Lock()
+	defer m.conn.mu.Unlock()
+	m.conn.readCache = rc
+	if m.conn.analytics != nil {
+		m.conn.analytics.setReadCache(rc)
+	}
+}
+
+// setReadCache sets the read cache of the connection
+func (c *connection) setReadCache(rc *readCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readCache = rc
+}
+
+// reads returns the read cache of the connection, nil when its reads are not cached
+func (c *connection) reads() *readCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readCache
+}
+
+// cachedRead reads value, a pointer to a document, from the read cache, or with read on a miss, caching what it
+// read. Only successful reads are cached. Cache errors are logged and the value is read without the cache.
+func (m *MongoDB) cachedRead(ctx context.Context, generation, key string, value interface{}, read func() error) error {
+	// The reads of a request that was not scoped are not served from the cache of the data of every tenant
+	if err := m.ScopeErr(); err != nil {
+		return err
+	}
+	rc := m.conn.reads()
+	if rc == nil {
+		return read()
+	}
+
+	current, _, err := rc.cache.Get(ctx, m.generationKey(generation))
+	if err != nil {
+		log.Printf("Failed to read the read cache: %v", err)
+		return read()
+	}
+	key = "read:" + m.Key() + ":" + generation + ":" + string(current) + ":" + key
+	if data, ok, err := rc.cache.Get(ctx, key); err != nil {
+		log.Printf("Failed to read the read cache: %v", err)
+	} else if ok {
+		if err := bson.Unmarshal(data, value); err == nil {
+			return nil
+		}
+		reflect.ValueOf(value).Elem().SetZero()
+	}
+
+	if err := read(); err != nil {
+		return err
+	}
+	if data, err := bson.Marshal(value); err == nil {
+		if err := rc.cache.Set(ctx, key, data, rc.ttl); err != nil {
+			log.Printf("Failed to write the read cache: %v", err)
+		}
+	}
+	return nil
+}
+
+// invalidateReads replaces a generation of cached reads after a write, so that the reads cached under the previous
+// generation are no longer served. It runs even when the context of the write was cancelled after it.
+func (m *MongoDB) invalidateReads(ctx context.Context, generation string) {
+	rc := m.conn.reads()
+	if rc == nil {
+		return
+	}
+
+	next := []byte(primitive.NewObjectID().Hex())
+	if err := rc.cache.Set(context.WithoutCancel(ctx), m.generationKey(generation), next, readCacheGenerationTTL); err != nil {
+		log.Printf("Failed to invalidate the read cache, reads may be stale for %s: %v", rc.ttl, err)
+	}
+}
+
+// generationKey is the cache key of a generation of the cached reads of the database, regardless of its scope
+func (m *MongoDB) generationKey(generation string) string {
+	key := "generation:" + m.name
+	if m.region != "" {
+		key += "@" + m.region
+	}
+	return key + ":" + generation
+}
+
+// agentsGeneration is the generation of the cached lists of agents
+const agentsGeneration = "agents"
+
+// versionsGeneration is the generation of the cached versions of an agent
+func versionsGeneration(agentID primitive.ObjectID) string {
+	return "agent_versions:" + agentID.Hex()
+}
+
+// metricsGeneration is the generation of the cached metrics of a version
+func metricsGeneration(versionID primitive.ObjectID) string {
+	return "agent_version_metrics:" + versionID.Hex()
+}
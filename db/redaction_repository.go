@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RedactionRepository handles database operations for the redaction rules of projects
+type RedactionRepository struct {
+	db       *MongoDB
+	rules    collection
+	timeouts Timeouts
+}
+
+// NewRedactionRepository creates a new redaction rule repository
+func NewRedactionRepository(db *MongoDB) *RedactionRepository {
+	return &RedactionRepository{
+		db:       db,
+		rules:    db.Collection("redaction_rules"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateRedactionRule creates a new redaction rule
+func (r *RedactionRepository) CreateRedactionRule(ctx context.Context, rule *models.RedactionRule) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+
+	result, err := r.rules.InsertOne(ctx, rule)
+	if err != nil {
+		return err
+	}
+
+	rule.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetRedactionRule retrieves a redaction rule by ID
+func (r *RedactionRepository) GetRedactionRule(ctx context.Context, id primitive.ObjectID) (*models.RedactionRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var rule models.RedactionRule
+	err := r.rules.FindOne(ctx, bson.M{"_id": id}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("redaction rule not found")
+		}
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+// ListRedactionRules retrieves the redaction rules of a project, or of every project when project is empty, in the
+// order they are applied
+func (r *RedactionRepository) ListRedactionRules(ctx context.Context, project string) ([]models.RedactionRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	filter := bson.M{}
+	if project != "" {
+		filter["project"] = project
+	}
+	return findRedactionRules(ctx, r.rules, filter)
+}
+
+// UpdateRedactionRule replaces the project, name, field, pattern and action of a redaction rule
+func (r *RedactionRepository) UpdateRedactionRule(ctx context.Context, rule *models.RedactionRule) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	rule.UpdatedAt = time.Now()
+	result, err := r.rules.UpdateOne(ctx, bson.M{"_id": rule.ID}, bson.M{
+		"$set": bson.M{
+			"project":    rule.Project,
+			"name":       rule.Name,
+			"field":      rule.Field,
+			"pattern":    rule.Pattern,
+			"action":     rule.Action,
+			"updated_at": rule.UpdatedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("redaction rule not found")
+	}
+
+	return nil
+}
+
+// DeleteRedactionRule deletes a redaction rule by ID
+func (r *RedactionRepository) DeleteRedactionRule(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.rules.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("redaction rule not found")
+	}
+
+	return nil
+}
+
+// findRedactionRules retrieves the redaction rules matching a filter, oldest first
+func findRedactionRules(ctx context.Context, rules collection, filter interface{}) ([]models.RedactionRule, error) {
+	cursor, err := rules.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	found := []models.RedactionRule{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// redactionFor compiles the rules applying to the agents of a project: its own and those of every project
+func redactionFor(rules []models.RedactionRule, project string) (*models.Redaction, error) {
+	var applying []models.RedactionRule
+	for _, rule := range rules {
+		if rule.Project == "" || rule.Project == project {
+			applying = append(applying, rule)
+		}
+	}
+	if len(applying) == 0 {
+		return nil, nil
+	}
+	return models.NewRedaction(applying)
+}
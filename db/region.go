@@ -0,0 +1,217 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// regionNamePattern restricts region names, which appear in database keys and error messages
+var regionNamePattern = tenantNamePattern
+
+// Regions are the MongoDB clusters data is stored in for data residency, by region. The home region is the cluster
+// of --mongo-uri, which also holds the data not pinned to another region and the shared collections. Projects are
+// pinned to a region by configuration, and organizations by their region.
+type Regions struct {
+	home      string
+	databases map[string]*MongoDB
+	projects  map[string]string
+}
+
+// NewRegions creates the regions of a home database, and makes the database and the databases derived from it
+// route to the other regions with ForRegion
+func NewRegions(home string, base *MongoDB) (*Regions, error) {
+	if !regionNamePattern.MatchString(home) {
+		return nil, fmt.Errorf("invalid home region %q", home)
+	}
+
+	regions := &Regions{
+		home:      home,
+		databases: map[string]*MongoDB{},
+		projects:  map[string]string{},
+	}
+	base.regions = regions
+	regions.databases[home] = base
+	return regions, nil
+}
+
+// ConnectRegions configures the regions of a home database from semicolon separated region=uri clusters, since URIs
+// list the hosts of a replica set separated by commas, and comma separated project=region pins. Nil is returned when
+// no clusters are configured.
+func ConnectRegions(base *MongoDB, home, clusters, pins string) (*Regions, error) {
+	if clusters == "" {
+		if pins != "" {
+			return nil, errors.New("projects are pinned to regions but no region clusters are configured")
+		}
+		return nil, nil
+	}
+
+	regions, err := NewRegions(home, base)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range strings.Split(clusters, ";") {
+		region, uri, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || region == "" || uri == "" {
+			regions.Close()
+			return nil, fmt.Errorf("invalid region cluster %q, expected region=uri", entry)
+		}
+		if err := regions.Connect(region, uri); err != nil {
+			regions.Close()
+			return nil, err
+		}
+	}
+	if err := regions.ParsePins(pins); err != nil {
+		regions.Close()
+		return nil, err
+	}
+	return regions, nil
+}
+
+// Connect connects to the cluster of a region, whose databases have the names of those of the home region
+func (r *Regions) Connect(region, uri string) error {
+	if !regionNamePattern.MatchString(region) {
+		return fmt.Errorf("invalid region %q", region)
+	}
+	if _, ok := r.databases[region]; ok {
+		return fmt.Errorf("region %s is configured twice", region)
+	}
+
+	home := r.databases[r.home]
+	database, err := NewMongoDB(uri, home.name, home.conn.options)
+	if err != nil {
+		return fmt.Errorf("unable to connect to region %s: %w", region, err)
+	}
+	database.Events = home.Events
+	database.SetTimeouts(home.Timeouts())
+	database.conn.setReadCache(home.conn.reads())
+	database.region = region
+	database.regions = r
+	r.databases[region] = database
+	return nil
+}
+
+// ParsePins parses comma separated project=region pins, pinning each project to its region
+func (r *Regions) ParsePins(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		project, region, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || project == "" || region == "" {
+			return fmt.Errorf("invalid project region %q, expected project=region", entry)
+		}
+		if !r.Has(region) {
+			return fmt.Errorf("unknown region %s for project %s", region, project)
+		}
+		r.projects[project] = region
+	}
+	return nil
+}
+
+// Home returns the home region
+func (r *Regions) Home() string {
+	return r.home
+}
+
+// Has reports whether a region is configured
+func (r *Regions) Has(region string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.databases[region]
+	return ok
+}
+
+// Names returns the configured regions, sorted
+func (r *Regions) Names() []string {
+	names := make([]string, 0, len(r.databases))
+	for name := range r.databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProjectRegion returns the region a project is pinned to, the empty string when it is not pinned
+func (r *Regions) ProjectRegion(project string) string {
+	if r == nil {
+		return ""
+	}
+	return r.projects[project]
+}
+
+// Close closes the connections to the regions other than the home region
+func (r *Regions) Close() {
+	for name, database := range r.databases {
+		if name != r.home {
+			database.Close()
+		}
+	}
+}
+
+// Region returns the region of the database, the empty string when no regions are configured
+func (m *MongoDB) Region() string {
+	if m.regions == nil {
+		return ""
+	}
+	if m.region == "" {
+		return m.regions.home
+	}
+	return m.region
+}
+
+// Regions returns the regions of the database, nil when none are configured
+func (m *MongoDB) Regions() *Regions {
+	return m.regions
+}
+
+// ForRegion returns the database in the cluster of a region, with the name and scope of the database. The shared
+// collections stay in the home region, where credentials are looked up. The database itself is returned for its own
+// region or an empty one.
+func (m *MongoDB) ForRegion(region string) (*MongoDB, error) {
+	if region == "" || region == m.Region() {
+		return m, nil
+	}
+	if m.regions == nil {
+		return nil, errors.New("no regions are configured")
+	}
+	cluster, ok := m.regions.databases[region]
+	if !ok {
+		return nil, fmt.Errorf("unknown region %s", region)
+	}
+
+	regional := *m
+	regional.conn = cluster.conn
+	regional.region = cluster.region
+	if cluster.region == "" {
+		// Back in the home region, only the own database of an organization keeps the shared database apart
+		if m.shared != nil && m.shared.name == m.name {
+			regional.shared = nil
+		}
+	} else if m.shared == nil {
+		regional.shared = m
+	}
+	return &regional, nil
+}
+
+// InRegions returns the databases by key, with their copies in the other regions, so that background jobs run
+// against the data of every region
+func InRegions(databases map[string]*MongoDB) map[string]*MongoDB {
+	all := make(map[string]*MongoDB, len(databases))
+	for key, database := range databases {
+		all[key] = database
+		if database.regions == nil {
+			continue
+		}
+		for _, region := range database.regions.Names() {
+			if region == database.Region() {
+				continue
+			}
+			regional, _ := database.ForRegion(region)
+			all[key+"@"+region] = regional
+		}
+	}
+	return all
+}
@@ -0,0 +1,301 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReportRepository handles database operations for scheduled reports and the summaries they deliver
+type ReportRepository struct {
+	db       *MongoDB
+	reports  collection
+	agents   collection
+	runs     collection
+	timeouts Timeouts
+}
+
+// NewReportRepository creates a new report repository
+func NewReportRepository(db *MongoDB) *ReportRepository {
+	return &ReportRepository{
+		db:       db,
+		reports:  db.Collection("reports"),
+		agents:   db.Collection("agents"),
+		runs:     db.Collection("agent_runs"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateReport creates a new report
+func (r *ReportRepository) CreateReport(ctx context.Context, report *models.Report) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	report.CreatedAt = now
+	report.UpdatedAt = now
+
+	result, err := r.reports.InsertOne(ctx, report)
+	if err != nil {
+		return err
+	}
+
+	report.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetReport retrieves a report by ID
+func (r *ReportRepository) GetReport(ctx context.Context, id primitive.ObjectID) (*models.Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var report models.Report
+	err := r.reports.FindOne(ctx, bson.M{"_id": id}).Decode(&report)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("report not found")
+		}
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ListReports retrieves all reports, oldest first
+func (r *ReportRepository) ListReports(ctx context.Context) ([]models.Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	return r.findReports(ctx, bson.M{})
+}
+
+// ListDueReports retrieves the reports scheduled to run at or before now
+func (r *ReportRepository) ListDueReports(ctx context.Context, now time.Time) ([]models.Report, error) {
+	return r.findReports(ctx, bson.M{"next_run_at": bson.M{"$lte": now}})
+}
+
+// findReports retrieves the reports matching a filter, oldest first
+func (r *ReportRepository) findReports(ctx context.Context, filter bson.M) ([]models.Report, error) {
+	cursor, err := r.reports.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := []models.Report{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// UpdateReport replaces the definition and the next run of a report
+func (r *ReportRepository) UpdateReport(ctx context.Context, report *models.Report) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	report.UpdatedAt = time.Now()
+	result, err := r.reports.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{
+		"$set": bson.M{
+			"name":        report.Name,
+			"project":     report.Project,
+			"metrics":     report.Metrics,
+			"cadence":     report.Cadence,
+			"format":      report.Format,
+			"recipients":  report.Recipients,
+			"next_run_at": report.NextRunAt,
+			"updated_at":  report.UpdatedAt,
+		},
+		// A run rescheduled is no longer retried
+		"$unset": bson.M{"pending_run_at": "", "attempts": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("report not found")
+	}
+
+	return nil
+}
+
+// DeleteReport deletes a report
+func (r *ReportRepository) DeleteReport(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.reports.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("report not found")
+	}
+
+	return nil
+}
+
+// ClaimReportRun claims the due run of a report, scheduled at scheduled, by moving its next run to until, unless
+// another worker moved it first. The run is delivered again from until when its delivery does not finish. It
+// reports whether the run was claimed, and the next run of a claimed report is until.
+func (r *ReportRepository) ClaimReportRun(ctx context.Context, report *models.Report, scheduled, until time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.reports.UpdateOne(ctx,
+		bson.M{"_id": report.ID, "next_run_at": report.NextRunAt},
+		bson.M{"$set": bson.M{"next_run_at": until, "pending_run_at": scheduled}},
+	)
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount != 1 {
+		return false, nil
+	}
+	report.NextRunAt = until
+	return true, nil
+}
+
+// FinishReportRun schedules the next run of a report and records the outcome of its delivery. The last sent time
+// only moves when the delivery succeeded. With retry, next is a retry of the failed run, whose attempts are counted.
+// Reports rescheduled since they were claimed, by an update or another worker, are left as they are.
+func (r *ReportRepository) FinishReportRun(ctx context.Context, report *models.Report, next, sentAt time.Time, sendErr error, retry bool) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	set := bson.M{"next_run_at": next}
+	if sendErr != nil {
+		set["last_error"] = sendErr.Error()
+	} else {
+		set["last_sent_at"] = sentAt
+		set["last_error"] = ""
+	}
+	update := bson.M{"$set": set}
+	if retry {
+		set["attempts"] = report.Attempts + 1
+	} else {
+		update["$unset"] = bson.M{"pending_run_at": "", "attempts": ""}
+	}
+
+	_, err := r.reports.UpdateOne(ctx, bson.M{"_id": report.ID, "next_run_at": report.NextRunAt}, update)
+	return err
+}
+
+// reportAccumulator holds the run aggregates of an agent a report row is computed from
+type reportAccumulator struct {
+	AgentID   primitive.ObjectID `bson:"_id"`
+	Runs      int64              `bson:"runs"`
+	Finished  int64              `bson:"finished"`
+	Completed int64              `bson:"completed"`
+	TimeTaken float64            `bson:"time_taken"`
+	Cost      float64            `bson:"cost"`
+}
+
+// PreviewReport computes the metrics of a report over the runs created in [from, to)
+func (r *ReportRepository) PreviewReport(ctx context.Context, report *models.Report, from, to time.Time) (*models.ReportSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	return r.GetReportSummary(ctx, report, from, to)
+}
+
+// GetReportSummary computes the metrics of the agents in the scope of a report over the runs created in [from, to)
+func (r *ReportRepository) GetReportSummary(ctx context.Context, report *models.Report, from, to time.Time) (*models.ReportSummary, error) {
+	agentFilter := bson.M{}
+	if report.Project != "" {
+		agentFilter["project"] = report.Project
+	}
+	cursor, err := r.agents.Find(ctx, agentFilter, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch agents: %w", err)
+	}
+	var agents []models.Agent
+	if err := cursor.All(ctx, &agents); err != nil {
+		return nil, fmt.Errorf("unable to decode agents: %w", err)
+	}
+
+	names := make(map[primitive.ObjectID]string, len(agents))
+	agentIDs := make([]primitive.ObjectID, 0, len(agents))
+	for _, agent := range agents {
+		names[agent.ID] = agent.Name
+		agentIDs = append(agentIDs, agent.ID)
+	}
+
+	finished := bson.M{"$ne": bson.A{"$status", models.RunStatusRunning}}
+	cursor, err = r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"agent_id": bson.M{"$in": agentIDs},
+			"created":  bson.M{"$gte": from, "$lt": to},
+		}},
+		{"$group": bson.M{
+			"_id":        "$agent_id",
+			"runs":       bson.M{"$sum": 1},
+			"finished":   bson.M{"$sum": bson.M{"$cond": bson.A{finished, 1, 0}}},
+			"completed":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", models.RunStatusCompleted}}, 1, 0}}},
+			"time_taken": bson.M{"$sum": bson.M{"$cond": bson.A{finished, "$time_taken", 0}}},
+			"cost":       bson.M{"$sum": "$cost"},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to aggregate runs: %w", err)
+	}
+	var accumulators []reportAccumulator
+	if err := cursor.All(ctx, &accumulators); err != nil {
+		return nil, fmt.Errorf("unable to decode runs: %w", err)
+	}
+
+	summary := &models.ReportSummary{
+		Name:    report.Name,
+		Project: report.Project,
+		Metrics: report.Metrics,
+		From:    from,
+		To:      to,
+		Rows:    make([]models.ReportRow, 0, len(accumulators)),
+	}
+	var total reportAccumulator
+	for _, acc := range accumulators {
+		summary.Rows = append(summary.Rows, newReportRow(acc, names[acc.AgentID]))
+		total.Runs += acc.Runs
+		total.Finished += acc.Finished
+		total.Completed += acc.Completed
+		total.TimeTaken += acc.TimeTaken
+		total.Cost += acc.Cost
+	}
+	summary.Totals = newReportRow(total, "Total")
+
+	sort.Slice(summary.Rows, func(i, j int) bool {
+		if summary.Rows[i].Cost != summary.Rows[j].Cost {
+			return summary.Rows[i].Cost > summary.Rows[j].Cost
+		}
+		return summary.Rows[i].Agent < summary.Rows[j].Agent
+	})
+
+	return summary, nil
+}
+
+// newReportRow computes the report metrics of an agent from its run aggregates. Errors are the finished runs
+// that did not complete.
+func newReportRow(acc reportAccumulator, agent string) models.ReportRow {
+	row := models.ReportRow{
+		AgentID: acc.AgentID,
+		Agent:   agent,
+		Runs:    acc.Runs,
+		Errors:  acc.Finished - acc.Completed,
+		Cost:    acc.Cost,
+	}
+	if acc.Finished > 0 {
+		row.SuccessRate = float64(acc.Completed) / float64(acc.Finished) * 100
+		row.AvgTimeTaken = acc.TimeTaken / float64(acc.Finished)
+	}
+	return row
+}
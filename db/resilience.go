@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const (
+	// retryAttempts is the number of times an operation failing on a transient error is run, at most
+	retryAttempts = 3
+	// retryBaseDelay is the delay before the first retry of an operation, doubled for every later one, and jittered
+	retryBaseDelay = 50 * time.Millisecond
+	// breakerThreshold is the number of consecutive operations failing on an unavailable cluster after which the
+	// circuit of the connection opens
+	breakerThreshold = 5
+	// breakerCooldown is how long the circuit of a connection stays open, failing operations right away, before an
+	// operation is let through to probe the cluster again
+	breakerCooldown = 30 * time.Second
+)
+
+// ErrUnavailable is the error of the operations of a connection whose circuit is open, run while the cluster was
+// found unavailable
+var ErrUnavailable = errors.New("database unavailable, too many operations failed recently")
+
+// stepDownCodes are the codes of the errors of a server that is not, or no longer, the primary of its replica set,
+// as during a failover
+var stepDownCodes = []int{91, 189, 10107, 11600, 11602, 13435, 13436}
+
+// notWrittenCodes are the step down codes of writes the server refused, which did not write anything
+var notWrittenCodes = []int{10107, 13435}
+
+// breaker retries the operations of a connection failing on transient errors, and opens the circuit of the
+// connection after sustained failures, so that operations fail right away instead of waiting on an unavailable
+// cluster. An open circuit lets an operation through every cooldown, which closes it when it succeeds.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// do runs an operation, retrying it with a jittered exponential backoff while it fails on a transient error that
+// leaves it safe to run again: any for reads, and for writes only those telling that nothing was written, since
+// the driver already retries writes failing on a network error once. Operations of a transaction are not retried,
+// the transaction is.
+func (b *breaker) do(ctx context.Context, write bool, op func() error) error {
+	if !b.allow() {
+		return ErrUnavailable
+	}
+
+	inTransaction := false
+	if session, ok := mongo.SessionFromContext(ctx).(mongo.XSession); ok {
+		inTransaction = session.ClientSession().TransactionRunning()
+	}
+
+	var err error
+	for attempt := 0; attempt < retryAttempts; attempt++ {
+		if attempt > 0 {
+			delay := retryBaseDelay << (attempt - 1)
+			timer := time.NewTimer(delay/2 + time.Duration(rand.Int63n(int64(delay/2))))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				b.record(err)
+				return err
+			case <-timer.C:
+			}
+		}
+
+		err = op()
+		if err == nil || inTransaction || !retryable(err, write) {
+			break
+		}
+	}
+	b.record(err)
+	return err
+}
+
+// allow reports whether an operation may run, letting a single operation probe the cluster once the circuit was
+// open for the cooldown
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record records the outcome of an operation. Any error but those of an unavailable cluster tells that it is
+// available again, and operations cancelled by their caller tell nothing.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil || !unavailable(err) {
+		if b.failures >= breakerThreshold {
+			log.Println("MongoDB is available again, closing the circuit")
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= breakerThreshold {
+		if b.failures == breakerThreshold {
+			log.Printf("%d consecutive MongoDB operations failed, opening the circuit for %s: %v", b.failures, breakerCooldown, err)
+		}
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// openFor returns how long the circuit stays open, 0 when it is closed
+func (b *breaker) openFor() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return 0
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// retryable reports whether an operation failing with err may be run again
+func retryable(err error, write bool) bool {
+	var selectionErr topology.ServerSelectionError
+	if errors.As(err, &selectionErr) && !errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if write {
+		return hasErrorCode(err, notWrittenCodes)
+	}
+	return mongo.IsNetworkError(err) || hasErrorCode(err, stepDownCodes)
+}
+
+// unavailable reports whether an operation failed with err because the cluster is unavailable: unreachable,
+// without a primary, or too slow to answer at all. Aggregations stopped by maxTimeMS only tell that they are slow.
+func unavailable(err error) bool {
+	var selectionErr topology.ServerSelectionError
+	var waitErr topology.WaitQueueTimeoutError
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.IsMaxTimeMSExpiredError() {
+		return false
+	}
+	return errors.As(err, &selectionErr) || errors.As(err, &waitErr) || errors.Is(err, context.DeadlineExceeded) ||
+		mongo.IsNetworkError(err) || hasErrorCode(err, stepDownCodes)
+}
+
+// hasErrorCode reports whether err is a server error with one of the codes
+func hasErrorCode(err error, codes []int) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range codes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// CircuitOpen returns how long the circuit of the connection of the database stays open, failing its operations
+// with ErrUnavailable, and whether it is open
+func (m *MongoDB) CircuitOpen() (time.Duration, bool) {
+	wait := m.conn.breaker.openFor()
+	return wait, wait > 0
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Retention holds how long raw runs are kept, by default and per project. A zero duration keeps runs forever.
+type Retention struct {
+	Default  time.Duration
+	Projects map[string]time.Duration
+}
+
+// ParseRetention parses the default retention of runs and the per-project retentions in the form
+// "project=720h,other=168h". Project retentions are enforced in addition to the default one, so they must be
+// shorter than it when it is set.
+func ParseRetention(retention time.Duration, projectRetention string) (*Retention, error) {
+	if retention != 0 && retention < time.Hour {
+		return nil, fmt.Errorf("invalid retention %s, it must be at least 1h", retention)
+	}
+	r := &Retention{Default: retention, Projects: map[string]time.Duration{}}
+
+	if projectRetention == "" {
+		return r, nil
+	}
+	for _, entry := range strings.Split(projectRetention, ",") {
+		project, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || project == "" || value == "" {
+			return nil, fmt.Errorf("invalid project retention %q, expected project=duration", entry)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Hour {
+			return nil, fmt.Errorf("invalid retention %q for project %s, it must be at least 1h", value, project)
+		}
+		if retention > 0 && d >= retention {
+			return nil, fmt.Errorf("retention %s of project %s must be shorter than the default retention %s", d, project, retention)
+		}
+		r.Projects[project] = d
+	}
+
+	return r, nil
+}
+
+// EnsureRunRetention makes MongoDB delete the runs recorded more than ttl ago with a TTL index on recorded_at,
+// updating its expiry when it changed, or removes the TTL index when ttl is zero. Runs of a time-series collection
+// expire by their creation time instead, as time-series collections only expire documents by their time field.
+func (r *AgentRepository) EnsureRunRetention(ctx context.Context, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	seconds := int32(ttl.Seconds())
+	if r.db.timeSeriesRuns {
+		var expiry interface{} = seconds
+		if ttl == 0 {
+			expiry = "off"
+		}
+		return r.db.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: r.runs.Name()},
+			{Key: "expireAfterSeconds", Value: expiry},
+		}).Err()
+	}
+
+	if ttl == 0 {
+		return r.dropRunTTLIndex(ctx)
+	}
+	_, err := r.runs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "recorded_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == indexOptionsConflict {
+		return r.db.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: r.runs.Name()},
+			{Key: "index", Value: bson.M{
+				"keyPattern":         bson.M{"recorded_at": 1},
+				"expireAfterSeconds": seconds,
+			}},
+		}).Err()
+	}
+	return err
+}
+
+// dropRunTTLIndex drops the TTL index on the recorded_at of runs, when there is one
+func (r *AgentRepository) dropRunTTLIndex(ctx context.Context) error {
+	cursor, err := r.runs.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []struct {
+		Name               string `bson:"name"`
+		Key                bson.D `bson:"key"`
+		ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index.ExpireAfterSeconds != nil && len(index.Key) == 1 && index.Key[0].Key == "recorded_at" {
+			_, err := r.runs.Indexes().DropOne(ctx, index.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredProjectRuns deletes the runs of the agents of a project recorded before a time, returning how many
+// were deleted
+func (r *AgentRepository) DeleteExpiredProjectRuns(ctx context.Context, project string, before time.Time) (int64, error) {
+	result, err := r.db.ForProject(project).Collection("agent_runs").DeleteMany(ctx, bson.M{"recorded_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
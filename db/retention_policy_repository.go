@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RetentionPolicyRepository handles database operations for the retention policies of projects, and the runs and
+// steps they delete
+type RetentionPolicyRepository struct {
+	db       *MongoDB
+	policies collection
+	steps    collection
+	timeouts Timeouts
+}
+
+// NewRetentionPolicyRepository creates a new retention policy repository
+func NewRetentionPolicyRepository(db *MongoDB) *RetentionPolicyRepository {
+	return &RetentionPolicyRepository{
+		db:       db,
+		policies: db.Collection("retention_policies"),
+		steps:    db.Collection("run_steps"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// PutRetentionPolicy creates or replaces the retention policy of a project. The steps of the project are purged
+// again from its oldest runs, as the policy may keep them for less.
+func (r *RetentionPolicyRepository) PutRetentionPolicy(ctx context.Context, policy *models.RetentionPolicy) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := r.policies.FindOneAndUpdate(ctx, bson.M{"project": policy.Project}, bson.M{
+		"$set": bson.M{
+			"runs_days":  policy.RunsDays,
+			"steps_days": policy.StepsDays,
+			"updated_at": now,
+		},
+		"$setOnInsert": bson.M{"created_at": now},
+		"$unset":       bson.M{"steps_purged_before": ""},
+	}, opts).Decode(policy)
+	return err
+}
+
+// GetRetentionPolicy retrieves the retention policy of a project
+func (r *RetentionPolicyRepository) GetRetentionPolicy(ctx context.Context, project string) (*models.RetentionPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var policy models.RetentionPolicy
+	err := r.policies.FindOne(ctx, bson.M{"project": project}).Decode(&policy)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("retention policy not found")
+		}
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// ListRetentionPolicies retrieves the retention policies of every project, by project
+func (r *RetentionPolicyRepository) ListRetentionPolicies(ctx context.Context) ([]models.RetentionPolicy, error) {
+	cursor, err := r.policies.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "project", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	policies := []models.RetentionPolicy{}
+	if err := cursor.All(ctx, &policies); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// DeleteRetentionPolicy deletes the retention policy of a project, whose data is then kept forever
+func (r *RetentionPolicyRepository) DeleteRetentionPolicy(ctx context.Context, project string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.policies.DeleteOne(ctx, bson.M{"project": project})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("retention policy not found")
+	}
+
+	return nil
+}
+
+// PreviewRetention counts the runs and steps of the project of a policy the policy deletes at a time
+func (r *RetentionPolicyRepository) PreviewRetention(ctx context.Context, policy *models.RetentionPolicy, now time.Time) (*models.RetentionPreview, error) {
+	runsBefore, stepsBefore := policy.Cutoffs(now)
+	preview := &models.RetentionPreview{Project: policy.Project, Time: now}
+	runs := r.db.ForProject(policy.Project).Collection("agent_runs")
+
+	if !runsBefore.IsZero() {
+		preview.RunsBefore = &runsBefore
+		count, err := runs.CountDocuments(ctx, bson.M{"recorded_at": bson.M{"$lt": runsBefore}})
+		if err != nil {
+			return nil, err
+		}
+		preview.Runs = count
+	}
+
+	// The steps deleted are those of the runs deleted, and of the runs recorded before the steps cutoff
+	if stepsBefore.Before(runsBefore) || stepsBefore.IsZero() {
+		stepsBefore = runsBefore
+	}
+	if stepsBefore.IsZero() {
+		return preview, nil
+	}
+	preview.StepsBefore = &stepsBefore
+	err := r.eachTraceBatch(ctx, runs, bson.M{"recorded_at": bson.M{"$lt": stepsBefore}}, func(traceIDs []string, _ []primitive.ObjectID) error {
+		if len(traceIDs) == 0 {
+			return nil
+		}
+		count, err := r.steps.CountDocuments(ctx, bson.M{"trace_id": bson.M{"$in": traceIDs}})
+		preview.Steps += count
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// ApplyRetention deletes the runs and steps of the project of a policy past its retention at a time, returning
+// how many were deleted
+func (r *RetentionPolicyRepository) ApplyRetention(ctx context.Context, policy *models.RetentionPolicy, now time.Time) (runsDeleted, stepsDeleted int64, err error) {
+	runsBefore, stepsBefore := policy.Cutoffs(now)
+	runs := r.db.ForProject(policy.Project).Collection("agent_runs")
+
+	// The steps of the runs past the steps retention are deleted, going only through the runs recorded since the
+	// previous purge. The steps of the runs past the runs retention are deleted with them.
+	if !stepsBefore.IsZero() && stepsBefore.After(policy.StepsPurgedBefore) {
+		filter := bson.M{"recorded_at": bson.M{"$gte": policy.StepsPurgedBefore, "$lt": stepsBefore}}
+		err := r.eachTraceBatch(ctx, runs, filter, func(traceIDs []string, _ []primitive.ObjectID) error {
+			if len(traceIDs) == 0 {
+				return nil
+			}
+			result, err := r.steps.DeleteMany(ctx, bson.M{"trace_id": bson.M{"$in": traceIDs}})
+			if err != nil {
+				return err
+			}
+			stepsDeleted += result.DeletedCount
+			return nil
+		})
+		if err != nil {
+			return runsDeleted, stepsDeleted, err
+		}
+		if _, err := r.policies.UpdateOne(ctx, bson.M{"_id": policy.ID}, bson.M{"$set": bson.M{"steps_purged_before": stepsBefore}}); err != nil {
+			return runsDeleted, stepsDeleted, err
+		}
+		policy.StepsPurgedBefore = stepsBefore
+	}
+
+	if runsBefore.IsZero() {
+		return runsDeleted, stepsDeleted, nil
+	}
+	err = r.eachTraceBatch(ctx, runs, bson.M{"recorded_at": bson.M{"$lt": runsBefore}}, func(traceIDs []string, ids []primitive.ObjectID) error {
+		if len(traceIDs) > 0 {
+			result, err := r.steps.DeleteMany(ctx, bson.M{"trace_id": bson.M{"$in": traceIDs}})
+			if err != nil {
+				return err
+			}
+			stepsDeleted += result.DeletedCount
+		}
+		result, err := runs.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		runsDeleted += result.DeletedCount
+		return nil
+	})
+	return runsDeleted, stepsDeleted, err
+}
+
+// eachTraceBatch calls fn with the trace IDs and IDs of the runs matching a filter, in batches in the order of
+// their IDs. fn may delete the runs of the batch.
+func (r *RetentionPolicyRepository) eachTraceBatch(ctx context.Context, runs collection, filter bson.M, fn func(traceIDs []string, ids []primitive.ObjectID) error) error {
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "trace_id": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(purgeBatchSize)
+
+	var after primitive.ObjectID
+	for {
+		query := bson.M{}
+		for key, value := range filter {
+			query[key] = value
+		}
+		if !after.IsZero() {
+			query["_id"] = bson.M{"$gt": after}
+		}
+
+		cursor, err := runs.Find(ctx, query, opts)
+		if err != nil {
+			return err
+		}
+		var batch []struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			TraceID string             `bson:"trace_id"`
+		}
+		if err := cursor.All(ctx, &batch); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(batch))
+		traceIDs := []string{}
+		for _, run := range batch {
+			ids = append(ids, run.ID)
+			if run.TraceID != "" {
+				traceIDs = append(traceIDs, run.TraceID)
+			}
+		}
+		if err := fn(traceIDs, ids); err != nil {
+			return err
+		}
+		after = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,549 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Granularities of the rollups
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// rollupDelay is how long after the end of an hour its runs are rolled up, leaving the runs of the hour time to be
+// ingested and to finish
+const rollupDelay = time.Hour
+
+// recordingMargin is how long before a roll up the runs it counts must have been recorded, the runs recorded since
+// being left to the next roll up, so that the runs being inserted while it runs are not missed
+const recordingMargin = time.Minute
+
+// Rollup holds the aggregates of the runs of an agent version created in an hour or a day, in UTC
+type Rollup struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty"`
+	AgentID          primitive.ObjectID `bson:"agent_id"`
+	VersionID        primitive.ObjectID `bson:"version_id"`
+	Version          string             `bson:"version"`
+	Granularity      string             `bson:"granularity"`
+	Start            time.Time          `bson:"start"`
+	Runs             int64              `bson:"runs"`
+	Finished         int64              `bson:"finished"`
+	Completed        int64              `bson:"completed"`
+	TimeTaken        float64            `bson:"time_taken"`
+	Cost             float64            `bson:"cost"`
+	PromptTokens     int64              `bson:"prompt_tokens"`
+	CompletionTokens int64              `bson:"completion_tokens"`
+}
+
+// RollupState holds the creation times before which the runs of a database are rolled up, by hour and by day, and
+// the recording time before which the runs of the hours rolled up are counted
+type RollupState struct {
+	HoursBefore    time.Time `bson:"hours_before"`
+	DaysBefore     time.Time `bson:"days_before"`
+	RecordedBefore time.Time `bson:"recorded_before"`
+}
+
+// RollupRepository handles database operations for the hourly and daily rollups of the runs of agent versions,
+// which analytics read for the time ranges they cover instead of the raw runs
+type RollupRepository struct {
+	db      *MongoDB
+	rollups collection
+	state   collection
+	runs    collection
+}
+
+// NewRollupRepository creates a new rollup repository
+func NewRollupRepository(db *MongoDB) *RollupRepository {
+	return &RollupRepository{
+		db:      db,
+		rollups: db.Collection("rollups"),
+		state:   db.Collection("rollup_state"),
+		runs:    db.Collection("agent_runs"),
+	}
+}
+
+// EnsureIndexes creates the unique index rollups are upserted with, and the index analytics read the rollups of
+// agents with
+func (r *RollupRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.rollups.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "version_id", Value: 1},
+				{Key: "granularity", Value: 1},
+				{Key: "start", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "granularity", Value: 1}, {Key: "start", Value: 1}}},
+		{Keys: bson.D{{Key: "granularity", Value: 1}, {Key: "start", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the rollup indexes: %w", err)
+	}
+	// The runs recorded late are found by their recording time. The index is not on recorded_at alone, which may
+	// have a TTL index for run retention.
+	_, err = r.runs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "recorded_at", Value: 1}, {Key: "created", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the run recording time index: %w", err)
+	}
+	return nil
+}
+
+// RollUp rolls up the runs created in the hours ended at least an hour before now since the previous roll up, a day
+// at a time, then rolls the complete days of hourly rollups up into daily rollups. It returns the number of rollups
+// written.
+//
+// Only the runs recorded before the roll up are counted. Runs recorded after their hour was rolled up, such as
+// imported runs, runs delivered late by a message queue or runs buffered by ingest, are found by their recording
+// time by the next roll up, which adds them to the rollups of their hour and day.
+func (r *RollupRepository) RollUp(ctx context.Context, now time.Time) (int64, error) {
+	state, err := rollupStateOf(ctx, r.state)
+	if err != nil {
+		return 0, err
+	}
+
+	recordedBefore := now.Add(-recordingMargin)
+	written, err := r.addLateRuns(ctx, state, recordedBefore)
+	if err != nil {
+		return written, err
+	}
+	if err := r.setState(ctx, "recorded_before", recordedBefore); err != nil {
+		return written, err
+	}
+
+	from := state.HoursBefore
+	if from.IsZero() {
+		var first struct {
+			Created time.Time `bson:"created"`
+		}
+		opts := options.FindOne().SetSort(bson.D{{Key: "created", Value: 1}}).SetProjection(bson.M{"created": 1})
+		if err := r.runs.FindOne(ctx, bson.M{}, opts).Decode(&first); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return written, nil
+			}
+			return written, err
+		}
+		from = first.Created.UTC().Truncate(time.Hour)
+	}
+
+	to := now.Add(-rollupDelay).UTC().Truncate(time.Hour)
+	for from.Before(to) {
+		end := from.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		n, err := r.rollUpHours(ctx, from, end, recordedBefore)
+		if err != nil {
+			return written, err
+		}
+		written += n
+		if err := r.setState(ctx, "hours_before", end); err != nil {
+			return written, err
+		}
+		state.HoursBefore = end
+		from = end
+	}
+
+	// Days are rolled up once all their hours are
+	from = state.DaysBefore
+	if from.IsZero() {
+		var first Rollup
+		opts := options.FindOne().SetSort(bson.D{{Key: "start", Value: 1}})
+		if err := r.rollups.FindOne(ctx, bson.M{"granularity": RollupHour}, opts).Decode(&first); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return written, nil
+			}
+			return written, err
+		}
+		from = first.Start.Truncate(24 * time.Hour)
+	}
+	to = state.HoursBefore.Truncate(24 * time.Hour)
+	if from.Before(to) {
+		n, err := r.rollUpDays(ctx, from, to)
+		if err != nil {
+			return written, err
+		}
+		written += n
+		if err := r.setState(ctx, "days_before", to); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// addLateRuns adds the runs recorded since the previous roll up, and before recordedBefore, to the rollups of the
+// hours and days already rolled up they were created in. The previous roll up counted the runs of these hours recorded
+// before its own recordedBefore, so every run is counted once.
+func (r *RollupRepository) addLateRuns(ctx context.Context, state RollupState, recordedBefore time.Time) (int64, error) {
+	if state.RecordedBefore.IsZero() || state.HoursBefore.IsZero() {
+		// Rollups written before the recording times of the runs were tracked counted every run of their hours
+		return 0, nil
+	}
+
+	late := bson.M{
+		"recorded_at": bson.M{"$gte": state.RecordedBefore, "$lt": recordedBefore},
+		"created":     bson.M{"$lt": state.HoursBefore},
+	}
+	written, err := r.add(ctx, RollupHour, r.runsPipeline(time.Hour, late))
+	if err != nil || state.DaysBefore.IsZero() {
+		return written, err
+	}
+
+	late["created"] = bson.M{"$lt": state.DaysBefore}
+	n, err := r.add(ctx, RollupDay, r.runsPipeline(24*time.Hour, late))
+	return written + n, err
+}
+
+// rollUpHours writes the hourly rollups of the runs created in [from, to) and recorded before recordedBefore
+func (r *RollupRepository) rollUpHours(ctx context.Context, from, to, recordedBefore time.Time) (int64, error) {
+	return r.write(ctx, RollupHour, r.runs, r.runsPipeline(time.Hour, bson.M{
+		"created":     bson.M{"$gte": from, "$lt": to},
+		"recorded_at": bson.M{"$lt": recordedBefore},
+	}))
+}
+
+// runsPipeline returns the pipeline grouping the runs matching a filter into rollups of the given duration
+func (r *RollupRepository) runsPipeline(bucket time.Duration, filter bson.M) []bson.M {
+	group := timeBucketGroup(bucket)
+	group["_id"] = bson.M{
+		"start":      group["_id"],
+		"agent_id":   "$agent_id",
+		"version_id": "$version_id",
+		"version":    "$version",
+	}
+	group["prompt_tokens"] = bson.M{"$sum": "$prompt_tokens"}
+	group["completion_tokens"] = bson.M{"$sum": "$completion_tokens"}
+
+	return []bson.M{{"$match": filter}, {"$group": group}}
+}
+
+// rollUpDays writes the daily rollups of the hourly rollups of [from, to)
+func (r *RollupRepository) rollUpDays(ctx context.Context, from, to time.Time) (int64, error) {
+	group := rollupBucketGroup(24 * time.Hour)
+	group["_id"] = bson.M{
+		"start":      group["_id"],
+		"agent_id":   "$agent_id",
+		"version_id": "$version_id",
+		"version":    "$version",
+	}
+	group["prompt_tokens"] = bson.M{"$sum": "$prompt_tokens"}
+	group["completion_tokens"] = bson.M{"$sum": "$completion_tokens"}
+
+	return r.write(ctx, RollupDay, r.rollups, []bson.M{
+		{"$match": bson.M{"granularity": RollupHour, "start": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": group},
+	})
+}
+
+// rollupsOf returns the rollups of a granularity a pipeline grouping by start, agent and version computes
+func rollupsOf(ctx context.Context, granularity string, source collection, pipeline []bson.M) ([]Rollup, error) {
+	cursor, err := source.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Key struct {
+			Start     time.Time          `bson:"start"`
+			AgentID   primitive.ObjectID `bson:"agent_id"`
+			VersionID primitive.ObjectID `bson:"version_id"`
+			Version   string             `bson:"version"`
+		} `bson:"_id"`
+		Runs             int64   `bson:"runs"`
+		Finished         int64   `bson:"finished"`
+		Completed        int64   `bson:"completed"`
+		TimeTaken        float64 `bson:"time_taken"`
+		Cost             float64 `bson:"cost"`
+		PromptTokens     int64   `bson:"prompt_tokens"`
+		CompletionTokens int64   `bson:"completion_tokens"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	rollups := make([]Rollup, 0, len(groups))
+	for _, g := range groups {
+		rollups = append(rollups, Rollup{
+			AgentID:          g.Key.AgentID,
+			VersionID:        g.Key.VersionID,
+			Version:          g.Key.Version,
+			Granularity:      granularity,
+			Start:            g.Key.Start,
+			Runs:             g.Runs,
+			Finished:         g.Finished,
+			Completed:        g.Completed,
+			TimeTaken:        g.TimeTaken,
+			Cost:             g.Cost,
+			PromptTokens:     g.PromptTokens,
+			CompletionTokens: g.CompletionTokens,
+		})
+	}
+	return rollups, nil
+}
+
+// write upserts the rollups of a granularity a pipeline grouping by start, agent and version computes
+func (r *RollupRepository) write(ctx context.Context, granularity string, source collection, pipeline []bson.M) (int64, error) {
+	rollups, err := rollupsOf(ctx, granularity, source, pipeline)
+	if err != nil || len(rollups) == 0 {
+		return 0, err
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(rollups))
+	for _, rollup := range rollups {
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"version_id": rollup.VersionID, "granularity": granularity, "start": rollup.Start}).
+			SetReplacement(rollup).
+			SetUpsert(true))
+	}
+	if _, err := r.rollups.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+	return int64(len(writes)), nil
+}
+
+// add adds the rollups of a granularity a pipeline grouping the runs by start, agent and version computes to the
+// rollups stored, creating those missing
+func (r *RollupRepository) add(ctx context.Context, granularity string, pipeline []bson.M) (int64, error) {
+	rollups, err := rollupsOf(ctx, granularity, r.runs, pipeline)
+	if err != nil || len(rollups) == 0 {
+		return 0, err
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(rollups))
+	for _, rollup := range rollups {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"version_id": rollup.VersionID, "granularity": granularity, "start": rollup.Start}).
+			SetUpdate(bson.M{
+				"$setOnInsert": bson.M{"agent_id": rollup.AgentID, "version": rollup.Version},
+				"$inc": bson.M{
+					"runs":              rollup.Runs,
+					"finished":          rollup.Finished,
+					"completed":         rollup.Completed,
+					"time_taken":        rollup.TimeTaken,
+					"cost":              rollup.Cost,
+					"prompt_tokens":     rollup.PromptTokens,
+					"completion_tokens": rollup.CompletionTokens,
+				},
+			}).
+			SetUpsert(true))
+	}
+	if _, err := r.rollups.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+	return int64(len(writes)), nil
+}
+
+// setState sets a creation time before which runs are rolled up, or the recording time before which they are counted
+func (r *RollupRepository) setState(ctx context.Context, field string, before time.Time) error {
+	opts := options.Update().SetUpsert(true)
+	_, err := r.state.UpdateOne(ctx, bson.M{"name": "rollups"}, bson.M{"$set": bson.M{field: before}}, opts)
+	return err
+}
+
+// rollupStateOf reads the rollup state of a database, zero when nothing was rolled up yet
+func rollupStateOf(ctx context.Context, state collection) (RollupState, error) {
+	var s RollupState
+	err := state.FindOne(ctx, bson.M{"name": "rollups"}).Decode(&s)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return s, err
+	}
+	return s, nil
+}
+
+// rollupBucketGroup returns the $group stage summing rollups into time buckets of the given duration
+func rollupBucketGroup(bucket time.Duration) bson.M {
+	return bson.M{
+		"_id":        truncateTime("$start", bucket),
+		"runs":       bson.M{"$sum": "$runs"},
+		"finished":   bson.M{"$sum": "$finished"},
+		"completed":  bson.M{"$sum": "$completed"},
+		"time_taken": bson.M{"$sum": "$time_taken"},
+		"cost":       bson.M{"$sum": "$cost"},
+	}
+}
+
+// rollupRange is a range of creation times read from the rollups of a granularity, or from the raw runs for an
+// empty granularity
+type rollupRange struct {
+	granularity string
+	from, to    time.Time
+}
+
+// rollupRanges splits [from, to) into the ranges read from the daily rollups, when the buckets are whole days, from
+// the hourly rollups, when they are whole hours, and from the raw runs, which cover the part of the range not rolled
+// up yet and the partial hours at its start and end
+func rollupRanges(state RollupState, bucket time.Duration, from, to time.Time) []rollupRange {
+	hoursFrom := from.UTC().Truncate(time.Hour)
+	if hoursFrom.Before(from) {
+		hoursFrom = hoursFrom.Add(time.Hour)
+	}
+	hoursTo := to.UTC().Truncate(time.Hour)
+	if state.HoursBefore.Before(hoursTo) {
+		hoursTo = state.HoursBefore
+	}
+	if bucket%time.Hour != 0 || !hoursFrom.Before(hoursTo) {
+		return []rollupRange{{from: from, to: to}}
+	}
+
+	ranges := []rollupRange{{from: from, to: hoursFrom}}
+	daysFrom := hoursFrom.Truncate(24 * time.Hour)
+	if daysFrom.Before(hoursFrom) {
+		daysFrom = daysFrom.Add(24 * time.Hour)
+	}
+	daysTo := hoursTo.Truncate(24 * time.Hour)
+	if state.DaysBefore.Before(daysTo) {
+		daysTo = state.DaysBefore
+	}
+	if bucket%(24*time.Hour) == 0 && daysFrom.Before(daysTo) {
+		ranges = append(ranges,
+			rollupRange{RollupHour, hoursFrom, daysFrom},
+			rollupRange{RollupDay, daysFrom, daysTo},
+			rollupRange{RollupHour, daysTo, hoursTo})
+	} else {
+		ranges = append(ranges, rollupRange{RollupHour, hoursFrom, hoursTo})
+	}
+	ranges = append(ranges, rollupRange{from: hoursTo, to: to})
+
+	nonEmpty := ranges[:0]
+	for _, rr := range ranges {
+		if rr.from.Before(rr.to) {
+			nonEmpty = append(nonEmpty, rr)
+		}
+	}
+	return nonEmpty
+}
+
+// rolledUpTimeBuckets aggregates the runs matching match created in [from, to) into buckets of the given duration,
+// reading the rollups for the part of the range they cover and the raw runs for the rest
+func rolledUpTimeBuckets(ctx context.Context, runs, rollups, state collection, match bson.M, bucket time.Duration, from, to time.Time) ([]TimeBucket, error) {
+	s, err := rollupStateOf(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	byStart := map[int64]*TimeBucket{}
+	for _, rr := range rollupRanges(s, bucket, from, to) {
+		source, stage := runs, bson.M{"created": bson.M{"$gte": rr.from, "$lt": rr.to}}
+		group := timeBucketGroup(bucket)
+		if rr.granularity != "" {
+			source, stage = rollups, bson.M{"granularity": rr.granularity, "start": bson.M{"$gte": rr.from, "$lt": rr.to}}
+			group = rollupBucketGroup(bucket)
+		}
+		for key, value := range match {
+			stage[key] = value
+		}
+
+		cursor, err := source.Aggregate(ctx, []bson.M{{"$match": stage}, {"$group": group}})
+		if err != nil {
+			return nil, err
+		}
+		var buckets []TimeBucket
+		err = cursor.All(ctx, &buckets)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range buckets {
+			merged, ok := byStart[b.Start.UnixNano()]
+			if !ok {
+				merged = &TimeBucket{Start: b.Start}
+				byStart[b.Start.UnixNano()] = merged
+			}
+			merged.Runs += b.Runs
+			merged.Finished += b.Finished
+			merged.Completed += b.Completed
+			merged.TimeTaken += b.TimeTaken
+			merged.Cost += b.Cost
+		}
+	}
+
+	buckets := make([]TimeBucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+// rolledUpVersionBuckets counts the runs of every version of an agent created in [from, to) per time bucket of the
+// given duration, reading the rollups for the part of the range they cover and the raw runs for the rest
+func rolledUpVersionBuckets(ctx context.Context, runs, rollups, state collection, agentID primitive.ObjectID, bucket time.Duration, from, to time.Time) ([]VersionBucket, error) {
+	s, err := rollupStateOf(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		start   int64
+		version string
+	}
+	counts := map[key]*VersionBucket{}
+	for _, rr := range rollupRanges(s, bucket, from, to) {
+		source := runs
+		match := bson.M{"agent_id": agentID, "created": bson.M{"$gte": rr.from, "$lt": rr.to}}
+		start, count := timeBucketStart(bucket), interface{}(1)
+		if rr.granularity != "" {
+			source = rollups
+			match = bson.M{"agent_id": agentID, "granularity": rr.granularity, "start": bson.M{"$gte": rr.from, "$lt": rr.to}}
+			start, count = truncateTime("$start", bucket), "$runs"
+		}
+
+		cursor, err := source.Aggregate(ctx, []bson.M{
+			{"$match": match},
+			{"$group": bson.M{
+				"_id":  bson.M{"start": start, "version": "$version"},
+				"runs": bson.M{"$sum": count},
+			}},
+			{"$project": bson.M{
+				"_id":     0,
+				"start":   "$_id.start",
+				"version": "$_id.version",
+				"runs":    1,
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var buckets []VersionBucket
+		err = cursor.All(ctx, &buckets)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range buckets {
+			k := key{b.Start.UnixNano(), b.Version}
+			if merged, ok := counts[k]; ok {
+				merged.Runs += b.Runs
+				continue
+			}
+			b := b
+			counts[k] = &b
+		}
+	}
+
+	buckets := make([]VersionBucket, 0, len(counts))
+	for _, b := range counts {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if !buckets[i].Start.Equal(buckets[j].Start) {
+			return buckets[i].Start.Before(buckets[j].Start)
+		}
+		return buckets[i].Version < buckets[j].Version
+	})
+	return buckets, nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SavedQueryRepository handles database operations for saved run queries
+type SavedQueryRepository struct {
+	db       *MongoDB
+	queries  collection
+	timeouts Timeouts
+}
+
+// NewSavedQueryRepository creates a new saved query repository
+func NewSavedQueryRepository(db *MongoDB) *SavedQueryRepository {
+	return &SavedQueryRepository{
+		db:       db,
+		queries:  db.Collection("saved_queries"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateSavedQuery creates a new saved query, unless a query with the same name exists
+func (r *SavedQueryRepository) CreateSavedQuery(ctx context.Context, query *models.SavedQuery) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	count, err := r.queries.CountDocuments(ctx, bson.M{"name": query.Name})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("saved query already exists")
+	}
+
+	now := time.Now()
+	query.CreatedAt = now
+	query.UpdatedAt = now
+
+	result, err := r.queries.InsertOne(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	query.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetSavedQuery retrieves a saved query by name
+func (r *SavedQueryRepository) GetSavedQuery(ctx context.Context, name string) (*models.SavedQuery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var query models.SavedQuery
+	err := r.queries.FindOne(ctx, bson.M{"name": name}).Decode(&query)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("saved query not found")
+		}
+		return nil, err
+	}
+
+	return &query, nil
+}
+
+// ListSavedQueries retrieves all saved queries, sorted by name
+func (r *SavedQueryRepository) ListSavedQueries(ctx context.Context) ([]models.SavedQuery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.queries.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	queries := []models.SavedQuery{}
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
+
+// UpdateSavedQuery replaces the description and the run query of a saved query
+func (r *SavedQueryRepository) UpdateSavedQuery(ctx context.Context, query *models.SavedQuery) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	query.UpdatedAt = time.Now()
+	result, err := r.queries.UpdateOne(ctx, bson.M{"_id": query.ID}, bson.M{
+		"$set": bson.M{
+			"description": query.Description,
+			"query":       query.Query,
+			"updated_at":  query.UpdatedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("saved query not found")
+	}
+
+	return nil
+}
+
+// DeleteSavedQuery deletes a saved query by name
+func (r *SavedQueryRepository) DeleteSavedQuery(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.queries.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("saved query not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,529 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unscopedCollections are shared by all organizations
+var unscopedCollections = map[string]bool{
+	"organizations":        true,
+	"change_stream_tokens": true,
+	"revoked_sessions":     true,
+}
+
+// collection is the part of a MongoDB collection the repositories use, implemented by *mongo.Collection, by
+// liveCollection and by scopedCollection
+type collection interface {
+	Name() string
+	Indexes() mongo.IndexView
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// Scope restricts the documents of a database to those of an organization and, within it, of a project. The zero
+// Scope matches all documents.
+type Scope struct {
+	// OrgID restricts all collections but the unscoped ones to the documents carrying its org_id
+	OrgID primitive.ObjectID
+	// Project restricts the agents and their data to the agents of a project
+	Project string
+}
+
+// IsZero reports whether the scope matches all documents
+func (s Scope) IsZero() bool {
+	return s.OrgID.IsZero() && s.Project == ""
+}
+
+// sharedCollections stay in the shared database when organizations have their own databases, scoped to the
+// organization, since credentials are looked up before the organization of a request is known
+var sharedCollections = map[string]bool{
+	"organizations":    true,
+	"api_keys":         true,
+	"service_accounts": true,
+	"users":            true,
+	"ingest_tokens":    true,
+	"revoked_sessions": true,
+}
+
+// projectCollections carry the project of their agent
+var projectCollections = map[string]bool{
+	"agents":                true,
+	"agent_version_metrics": true,
+	"redaction_rules":       true,
+	"retention_policies":    true,
+}
+
+// agentCollections carry the ID of their agent, and are restricted to the agents of the project of a scope
+var agentCollections = map[string]bool{
+	"agent_versions":    true,
+	"agent_runs":        true,
+	"ingest_tokens":     true,
+	"slos":              true,
+	"slo_status":        true,
+	"deployment_events": true,
+	"rollups":           true,
+	"artifacts":         true,
+}
+
+// Collection returns a collection of the database, or of the shared database for the shared collections of the own
+// database of an organization, scoped to the documents of the scope of the database when it has one
+func (m *MongoDB) Collection(name string) collection {
+	var c collection = &liveCollection{db: m, name: name}
+	if m.missingScope {
+		return &missingScopeCollection{collection: c}
+	}
+	if m.shared != nil && sharedCollections[name] {
+		c = &liveCollection{db: m.shared, name: name}
+	}
+	if name == "agent_runs" && m.timeSeriesRuns {
+		c = &timeSeriesRuns{collection: c, versions: &liveCollection{db: m, name: "agent_versions"}}
+	}
+	if m.Scope.IsZero() || unscopedCollections[name] {
+		return c
+	}
+	scoped := &scopedCollection{collection: c, orgID: m.OrgID}
+	if m.Project != "" {
+		if projectCollections[name] {
+			scoped.project = m.Project
+		} else if agentCollections[name] {
+			scoped.agents = &liveCollection{db: m, name: "agents"}
+			scoped.agentScope = m.Scope
+		}
+	}
+	return scoped
+}
+
+// ForScope returns the database scoped to the documents of a scope, or the database itself for the zero scope
+func (m *MongoDB) ForScope(scope Scope) *MongoDB {
+	if scope.IsZero() {
+		return m
+	}
+	scoped := *m
+	scoped.Scope = scope
+	return &scoped
+}
+
+// ForOrg returns the database scoped to the documents of an organization, or the database itself for the zero ID
+func (m *MongoDB) ForOrg(orgID primitive.ObjectID) *MongoDB {
+	if orgID.IsZero() {
+		return m
+	}
+	return m.ForScope(Scope{OrgID: orgID, Project: m.Project})
+}
+
+// ForProject returns the database further scoped to the agents of a project, or the database itself for an empty
+// project
+func (m *MongoDB) ForProject(project string) *MongoDB {
+	if project == "" {
+		return m
+	}
+	return m.ForScope(Scope{OrgID: m.OrgID, Project: project})
+}
+
+// ErrMissingScope is returned by the collections of the database of a request that was not scoped to a tenant or
+// organization, although tenancy requires it to be
+var ErrMissingScope = errors.New("the request is not scoped to a tenant or organization")
+
+// MissingScope returns the database standing for the database of the requests that tenancy requires to be scoped to
+// a tenant or organization when they were not, so that they fail rather than read and write the data of every
+// tenant: its collections fail every read and write with ErrMissingScope
+func (m *MongoDB) MissingScope() *MongoDB {
+	database := *m
+	database.missingScope = true
+	return &database
+}
+
+// ScopeErr returns ErrMissingScope for the database of a request that was not scoped although tenancy requires it to
+// be, see MissingScope, and nil otherwise
+func (m *MongoDB) ScopeErr() error {
+	if m.missingScope {
+		return ErrMissingScope
+	}
+	return nil
+}
+
+// Key identifies the data of the database: its name, followed by its region when it is not the home region, and by
+// its organization and project when it has them
+func (m *MongoDB) Key() string {
+	key := m.name
+	if m.region != "" {
+		key += "@" + m.region
+	}
+	if !m.OrgID.IsZero() {
+		key += "/" + m.OrgID.Hex()
+	}
+	if m.Project != "" {
+		key += "/project:" + m.Project
+	}
+	if m.missingScope {
+		key += "/missing-scope"
+	}
+	return key
+}
+
+// scopedCollection scopes the reads and writes of a collection to the documents of a scope: filters and pipelines
+// only match the documents carrying its org_id, and its project or the ID of one of the agents of its project, and
+// inserted documents are given its org_id and project. Inserting a document of an agent outside of the project
+// fails. Upserts take the org_id and project from their filter.
+type scopedCollection struct {
+	collection
+	orgID   primitive.ObjectID
+	project string
+	// agents resolves the agents of the project of agentScope for collections keyed by agent_id
+	agents     collection
+	agentScope Scope
+}
+
+// missingScopeCollection fails the reads and writes of a collection of the database of a request that was not scoped,
+// see MissingScope. Its name and indexes, which hold no documents, are those of the collection.
+type missingScopeCollection struct {
+	collection
+}
+
+func (c *missingScopeCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return 0, ErrMissingScope
+}
+
+func (c *missingScopeCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(bson.D{}, ErrMissingScope, nil)
+}
+
+func (c *missingScopeCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return mongo.NewSingleResultFromDocument(bson.D{}, ErrMissingScope, nil)
+}
+
+func (c *missingScopeCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return nil, ErrMissingScope
+}
+
+func (c *missingScopeCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return nil, ErrMissingScope
+}
+
+// errOutOfScope is returned when a document is written for an agent outside of the project of the scope
+var errOutOfScope = errors.New("agent is outside of the project scope")
+
+// match returns the conditions matching the documents of the scope
+func (c *scopedCollection) match(ctx context.Context) (bson.D, error) {
+	match := bson.D{}
+	if !c.orgID.IsZero() {
+		match = append(match, bson.E{Key: "org_id", Value: c.orgID})
+	}
+	if c.project != "" {
+		match = append(match, bson.E{Key: "project", Value: c.project})
+	}
+	if c.agents != nil {
+		ids, err := c.agentIDs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		match = append(match, bson.E{Key: "agent_id", Value: bson.D{{Key: "$in", Value: ids}}})
+	}
+	return match, nil
+}
+
+// agentIDs returns the IDs of the agents of the project. They are resolved for every operation so that agents
+// registered in, or moved out of, the project are picked up at once.
+func (c *scopedCollection) agentIDs(ctx context.Context) (bson.A, error) {
+	agents := &scopedCollection{collection: c.agents, orgID: c.agentScope.OrgID, project: c.agentScope.Project}
+	ids, err := agents.Distinct(ctx, "_id", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the agents of project %s: %w", c.agentScope.Project, err)
+	}
+	// An empty project matches no documents rather than an invalid $in
+	return append(bson.A{}, ids...), nil
+}
+
+// and restricts a filter to the documents matching the conditions of a scope
+func and(match bson.D, filter interface{}) interface{} {
+	if filter == nil {
+		return match
+	}
+	return bson.D{{Key: "$and", Value: bson.A{match, filter}}}
+}
+
+// filter restricts a filter to the documents of the scope
+func (c *scopedCollection) filter(ctx context.Context, filter interface{}) (interface{}, error) {
+	match, err := c.match(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return and(match, filter), nil
+}
+
+// inScope returns the IDs of the agents of the project for collections keyed by agent_id, to check the documents
+// written with document
+func (c *scopedCollection) inScope(ctx context.Context) (bson.A, error) {
+	if c.agents == nil {
+		return nil, nil
+	}
+	return c.agentIDs(ctx)
+}
+
+// document returns a document with the org_id and project of the scope, failing for documents of an agent outside
+// of the agents of the project
+func (c *scopedCollection) document(document interface{}, agentIDs bson.A) (interface{}, error) {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	if c.agents != nil {
+		var agentID interface{}
+		for _, e := range doc {
+			if e.Key == "agent_id" {
+				agentID = e.Value
+			}
+		}
+		found := false
+		for _, id := range agentIDs {
+			if id == agentID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errOutOfScope
+		}
+	}
+
+	doc = setField(doc, "org_id", c.orgID, !c.orgID.IsZero())
+	doc = setField(doc, "project", c.project, c.project != "")
+	return doc, nil
+}
+
+// setField sets a field of a document when set is true
+func setField(doc bson.D, key string, value interface{}, set bool) bson.D {
+	if !set {
+		return doc
+	}
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+func (c *scopedCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	match, err := c.match(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scoped := bson.A{bson.D{{Key: "$match", Value: match}}}
+	stages := reflect.ValueOf(pipeline)
+	if stages.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("unsupported pipeline %T", pipeline)
+	}
+	for i := 0; i < stages.Len(); i++ {
+		scoped = append(scoped, stages.Index(i).Interface())
+	}
+	return c.collection.Aggregate(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	match, err := c.match(ctx)
+	if err != nil {
+		return nil, err
+	}
+	agentIDs, err := c.inScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := make([]mongo.WriteModel, len(models))
+	for i, model := range models {
+		switch m := model.(type) {
+		case *mongo.InsertOneModel:
+			doc, err := c.document(m.Document, agentIDs)
+			if err != nil {
+				return nil, err
+			}
+			scoped[i] = mongo.NewInsertOneModel().SetDocument(doc)
+		case *mongo.UpdateOneModel:
+			update := *m
+			update.Filter = and(match, m.Filter)
+			scoped[i] = &update
+		case *mongo.UpdateManyModel:
+			update := *m
+			update.Filter = and(match, m.Filter)
+			scoped[i] = &update
+		case *mongo.ReplaceOneModel:
+			replace := *m
+			replace.Filter = and(match, m.Filter)
+			doc, err := c.document(m.Replacement, agentIDs)
+			if err != nil {
+				return nil, err
+			}
+			replace.Replacement = doc
+			scoped[i] = &replace
+		case *mongo.DeleteOneModel:
+			del := *m
+			del.Filter = and(match, m.Filter)
+			scoped[i] = &del
+		case *mongo.DeleteManyModel:
+			del := *m
+			del.Filter = and(match, m.Filter)
+			scoped[i] = &del
+		default:
+			return nil, fmt.Errorf("unsupported write model %T", model)
+		}
+	}
+	return c.collection.BulkWrite(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return c.collection.CountDocuments(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.DeleteMany(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.DeleteOne(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.Distinct(ctx, fieldName, scoped, opts...)
+}
+
+func (c *scopedCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.Find(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return c.collection.FindOne(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return c.collection.FindOneAndUpdate(ctx, scoped, update, opts...)
+}
+
+func (c *scopedCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	agentIDs, err := c.inScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scoped := make([]interface{}, len(documents))
+	for i, document := range documents {
+		doc, err := c.document(document, agentIDs)
+		if err != nil {
+			return nil, err
+		}
+		scoped[i] = doc
+	}
+	return c.collection.InsertMany(ctx, scoped, opts...)
+}
+
+func (c *scopedCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	agentIDs, err := c.inScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := c.document(document, agentIDs)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.InsertOne(ctx, doc, opts...)
+}
+
+func (c *scopedCollection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.UpdateMany(ctx, scoped, update, opts...)
+}
+
+func (c *scopedCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	scoped, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.UpdateOne(ctx, scoped, update, opts...)
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SeriesRepository keeps the last value seen of the counters received over Prometheus remote-write, so the next
+// write can be turned into the runs recorded since
+type SeriesRepository struct {
+	db       *MongoDB
+	series   collection
+	timeouts Timeouts
+}
+
+// seriesValue is the stored position of a counter series
+type seriesValue struct {
+	Key       string    `bson:"_id"`
+	Value     float64   `bson:"value"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// NewSeriesRepository creates a new series repository
+func NewSeriesRepository(db *MongoDB) *SeriesRepository {
+	return &SeriesRepository{
+		db:       db,
+		series:   db.Collection("prometheus_series"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// GetSeriesValues retrieves the last values of the given series, series never seen before are omitted
+func (r *SeriesRepository) GetSeriesValues(ctx context.Context, keys []string) (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	values := map[string]float64{}
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = r.id(key)
+	}
+
+	cursor, err := r.series.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []seriesValue
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		values[strings.TrimPrefix(doc.Key, r.id(""))] = doc.Value
+	}
+
+	return values, nil
+}
+
+// SetSeriesValues stores the last values of series
+func (r *SeriesRepository) SetSeriesValues(ctx context.Context, values map[string]float64) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(values))
+	for key, value := range values {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": r.id(key)}).
+			SetUpdate(bson.M{"$set": bson.M{"value": value, "updated_at": now}}).
+			SetUpsert(true))
+	}
+
+	_, err := r.series.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// id returns the ID a series is stored under, prefixed with the organization of the repository when it has one, as
+// organizations may send the same series
+func (r *SeriesRepository) id(key string) string {
+	if r.db.OrgID.IsZero() {
+		return key
+	}
+	return r.db.OrgID.Hex() + "/" + key
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ServiceAccountRepository handles database operations for service accounts and their API keys. The scopes, project
+// and disabled state of an account are copied to its keys, so that authenticating a key needs a single lookup.
+type ServiceAccountRepository struct {
+	db       *MongoDB
+	accounts collection
+	keys     collection
+	timeouts Timeouts
+}
+
+// NewServiceAccountRepository creates a new service account repository
+func NewServiceAccountRepository(db *MongoDB) *ServiceAccountRepository {
+	return &ServiceAccountRepository{
+		db:       db,
+		accounts: db.Collection("service_accounts"),
+		keys:     db.Collection("api_keys"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateServiceAccount creates a new service account
+func (r *ServiceAccountRepository) CreateServiceAccount(ctx context.Context, account *models.ServiceAccount) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	account.CreatedAt = now
+	account.UpdatedAt = now
+
+	result, err := r.accounts.InsertOne(ctx, account)
+	if err != nil {
+		return err
+	}
+
+	account.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetServiceAccount retrieves a service account by ID
+func (r *ServiceAccountRepository) GetServiceAccount(ctx context.Context, id primitive.ObjectID) (*models.ServiceAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var account models.ServiceAccount
+	err := r.accounts.FindOne(ctx, bson.M{"_id": id}).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("service account not found")
+		}
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// ListServiceAccounts retrieves all service accounts, sorted by name
+func (r *ServiceAccountRepository) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.accounts.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	accounts := []models.ServiceAccount{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// UpdateServiceAccount sets the fields of update on a service account and on its keys, and returns the updated
+// account
+func (r *ServiceAccountRepository) UpdateServiceAccount(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.ServiceAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	set := bson.M{"updated_at": time.Now()}
+	for field, value := range update {
+		set[field] = value
+	}
+
+	var account models.ServiceAccount
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.accounts.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("service account not found")
+		}
+		return nil, err
+	}
+
+	keyUpdate := bson.M{}
+	for _, field := range []string{"scopes", "disabled"} {
+		if value, ok := update[field]; ok {
+			keyUpdate[field] = value
+		}
+	}
+	if len(keyUpdate) > 0 {
+		if _, err := r.keys.UpdateMany(ctx, bson.M{"service_account_id": id}, bson.M{"$set": keyUpdate}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &account, nil
+}
+
+// DeleteServiceAccount deletes a service account and revokes its keys
+func (r *ServiceAccountRepository) DeleteServiceAccount(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	// The keys go first, so that a failure leaves no keys of a deleted account
+	if _, err := r.keys.DeleteMany(ctx, bson.M{"service_account_id": id}); err != nil {
+		return err
+	}
+	result, err := r.accounts.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("service account not found")
+	}
+	return nil
+}
+
+// CreateServiceAccountKey stores an API key of a service account, with the scopes, project and disabled state of the
+// account
+func (r *ServiceAccountRepository) CreateServiceAccountKey(ctx context.Context, account *models.ServiceAccount, key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	key.ServiceAccountID = &account.ID
+	key.Scopes = account.Scopes
+	key.Project = account.Project
+	key.Disabled = account.Disabled
+	key.CreatedAt = time.Now()
+	result, err := r.keys.InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListServiceAccountKeys retrieves the API keys of a service account, oldest first
+func (r *ServiceAccountRepository) ListServiceAccountKeys(ctx context.Context, id primitive.ObjectID) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.keys.Find(ctx, bson.M{"service_account_id": id}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// DeleteServiceAccountKey deletes an API key of a service account, revoking it
+func (r *ServiceAccountRepository) DeleteServiceAccountKey(ctx context.Context, id, keyID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.keys.DeleteOne(ctx, bson.M{"_id": keyID, "service_account_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("API key not found")
+	}
+	return nil
+}
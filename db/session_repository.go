@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepository records the browser sessions signed out before they expire, across organizations, until they
+// expire. It implements auth.Revocations.
+type SessionRepository struct {
+	db       *MongoDB
+	revoked  collection
+	timeouts Timeouts
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *MongoDB) *SessionRepository {
+	return &SessionRepository{
+		db:       db,
+		revoked:  db.Collection("revoked_sessions"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the TTL index deleting the sessions signed out once they expire
+func (r *SessionRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.revoked.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// RevokeSession records a session as signed out until it expires
+func (r *SessionRepository) RevokeSession(ctx context.Context, id string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	opts := options.Update().SetUpsert(true)
+	_, err := r.revoked.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"expires_at": expiresAt}}, opts)
+	return err
+}
+
+// SessionRevoked reports whether a session was signed out
+func (r *SessionRepository) SessionRevoked(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	count, err := r.revoked.CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+	return count > 0, err
+}
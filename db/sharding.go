@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotShardedCluster is returned when sharding the runs of a database that is not served by a mongos router
+var ErrNotShardedCluster = errors.New("sharding the runs needs a sharded cluster, connect to a mongos router")
+
+// runsShardKeyFields are the fields of runs a shard key may use. Every run has them once stored, run_id and org_id
+// being 0 and missing when not set.
+var runsShardKeyFields = map[string]bool{
+	"_id":        true,
+	"org_id":     true,
+	"agent_id":   true,
+	"version_id": true,
+	"created":    true,
+	"run_id":     true,
+}
+
+// uniqueRunFields are the fields of the unique run ID index
+var uniqueRunFields = []string{"agent_id", "version_id", "run_id"}
+
+// uniqueRunPrefixes are the fields that may prefix the unique run ID index. The organization of a run is implied by
+// its agent, so an organization prefix leaves the index as unique.
+var uniqueRunPrefixes = map[string]bool{"org_id": true, "agent_id": true, "version_id": true, "run_id": true}
+
+// runsVersionIndex is the index the runs of a version are read with
+var runsVersionIndex = bson.D{{Key: "version_id", Value: 1}, {Key: "created", Value: 1}}
+
+// ParseShardKey parses a shard key of the runs: comma separated fields, each ranged, or hashed with a :hashed suffix,
+// such as version_id,created or _id:hashed. The fields are those of runs: _id, org_id, agent_id, version_id, created
+// and run_id.
+func ParseShardKey(spec string) (bson.D, error) {
+	key := bson.D{}
+	seen := map[string]bool{}
+	hashed := false
+	for _, part := range strings.Split(spec, ",") {
+		field, kind, _ := strings.Cut(strings.TrimSpace(part), ":")
+		if !runsShardKeyFields[field] {
+			return nil, fmt.Errorf("invalid shard key field %q, expected _id, org_id, agent_id, version_id, created or run_id", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("shard key field %s is repeated", field)
+		}
+		seen[field] = true
+
+		switch kind {
+		case "", "1":
+			key = append(key, bson.E{Key: field, Value: 1})
+		case "hashed":
+			if hashed {
+				return nil, errors.New("a shard key has a single hashed field")
+			}
+			hashed = true
+			key = append(key, bson.E{Key: field, Value: "hashed"})
+		default:
+			return nil, fmt.Errorf("invalid shard key field %q, expected a field, or a field with the :hashed suffix", part)
+		}
+	}
+	return key, nil
+}
+
+// EnsureShardedRuns shards the runs collection of the database with key on a sharded cluster, when it is not
+// sharded yet, creating the index supporting the key first, which may take a while when runs are already stored.
+// The unique run ID index must be prefixed by the shard key: it is created with the fields of the key first when they
+// are among its own, agent_id, version_id, run_id and org_id, and dropped otherwise, leaving the duplicate runs of
+// single inserts unchecked, as with time-series runs. It fails when the runs are sharded with another key, which only
+// reshardCollection changes. The aggregations of the runs of a version then hint their index, which every shard has.
+func (m *MongoDB) EnsureShardedRuns(ctx context.Context, key bson.D) error {
+	if m.timeSeriesRuns {
+		return errors.New("time-series runs are not sharded by the server")
+	}
+
+	admin := m.Client().Database("admin")
+	var hello struct {
+		Msg string `bson:"msg"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("unable to read the deployment of the database: %w", err)
+	}
+	if hello.Msg != "isdbgrid" {
+		return ErrNotShardedCluster
+	}
+
+	namespace := m.name + ".agent_runs"
+	var sharded struct {
+		Key     bson.D `bson:"key"`
+		Dropped bool   `bson:"dropped"`
+	}
+	err := m.Client().Database("config").Collection("collections").FindOne(ctx, bson.M{"_id": namespace}).Decode(&sharded)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("unable to read the sharding of the runs: %w", err)
+	}
+	if err == nil && !sharded.Dropped {
+		if !sameShardKey(sharded.Key, key) {
+			return fmt.Errorf("the runs are already sharded with key %v, reshard them with reshardCollection to change it", sharded.Key)
+		}
+		m.runsShardKey = key
+		return nil
+	}
+
+	runs := m.Database().Collection("agent_runs")
+	if err := dropUniqueRunIndex(ctx, runs, key); err != nil {
+		return err
+	}
+	if _, err := runs.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: key}); err != nil {
+		return fmt.Errorf("unable to create the shard key index of the runs: %w", err)
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "enableSharding", Value: m.name}}).Err(); err != nil {
+		return fmt.Errorf("unable to enable the sharding of the database: %w", err)
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "shardCollection", Value: namespace}, {Key: "key", Value: key}}).Err(); err != nil {
+		return fmt.Errorf("unable to shard the runs: %w", err)
+	}
+
+	log.Printf("Sharded the runs of %s with key %v", m.name, key)
+	m.runsShardKey = key
+	return nil
+}
+
+// dropUniqueRunIndex drops the unique run ID index when it is not the one of the shard key, which sharding the runs
+// would fail on. EnsureIndexes creates the index of the key again when it is allowed.
+func dropUniqueRunIndex(ctx context.Context, runs *mongo.Collection, key bson.D) error {
+	specs, err := runs.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the indexes of the runs: %w", err)
+	}
+	wanted, _ := uniqueRunIndex(key)
+	for _, spec := range specs {
+		if spec.Unique == nil || !*spec.Unique {
+			continue
+		}
+		var keys bson.D
+		if err := bson.Unmarshal(spec.KeysDocument, &keys); err != nil {
+			return err
+		}
+		if !hasField(keys, "run_id") || sameShardKey(keys, wanted) {
+			continue
+		}
+		if _, err := runs.Indexes().DropOne(ctx, spec.Name); err != nil {
+			return fmt.Errorf("unable to drop the unique run ID index %s: %w", spec.Name, err)
+		}
+		log.Printf("Dropped the unique run ID index %s, which is not prefixed by the shard key of the runs", spec.Name)
+	}
+	return nil
+}
+
+// uniqueRunIndex returns the keys of the unique run ID index of runs sharded with key: the ranged fields of the key
+// followed by the other fields of the index, and whether the key allows the index
+func uniqueRunIndex(key bson.D) (bson.D, bool) {
+	index := bson.D{}
+	for _, e := range key {
+		if e.Value != 1 || !uniqueRunPrefixes[e.Key] {
+			return nil, false
+		}
+		index = append(index, bson.E{Key: e.Key, Value: 1})
+	}
+	for _, field := range uniqueRunFields {
+		if !hasField(index, field) {
+			index = append(index, bson.E{Key: field, Value: 1})
+		}
+	}
+	return index, true
+}
+
+// sameShardKey reports whether two index keys have the same fields, in the same order and of the same kind
+func sameShardKey(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || !reflect.DeepEqual(keyKind(a[i].Value), keyKind(b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyKind normalizes the value of an index key field, numbers of any type to float64
+func keyKind(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value
+}
+
+// hasField reports whether an index key has a field
+func hasField(key bson.D, field string) bool {
+	for _, e := range key {
+		if e.Key == field {
+			return true
+		}
+	}
+	return false
+}
+
+// versionRunsAggregate returns the options of the aggregations of the runs of a version, which hint the version
+// index when the runs are sharded, so that every shard reads the runs of the version from it, whatever the indexes
+// added to some shards
+func (m *MongoDB) versionRunsAggregate() *options.AggregateOptions {
+	opts := options.Aggregate()
+	if m.runsShardKey != nil {
+		opts.SetHint(runsVersionIndex)
+	}
+	return opts
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SLORepository handles database operations for SLOs and their computed statuses
+type SLORepository struct {
+	db       *MongoDB
+	slos     collection
+	statuses collection
+	runs     collection
+	timeouts Timeouts
+}
+
+// NewSLORepository creates a new SLO repository
+func NewSLORepository(db *MongoDB) *SLORepository {
+	return &SLORepository{
+		db:       db,
+		slos:     db.Collection("slos"),
+		statuses: db.Collection("slo_status"),
+		runs:     db.Collection("agent_runs"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// CreateSLO creates a new SLO
+func (r *SLORepository) CreateSLO(ctx context.Context, slo *models.SLO) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	slo.CreatedAt = now
+	slo.UpdatedAt = now
+
+	result, err := r.slos.InsertOne(ctx, slo)
+	if err != nil {
+		return err
+	}
+
+	slo.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetSLO retrieves an SLO of an agent by ID
+func (r *SLORepository) GetSLO(ctx context.Context, agentID, id primitive.ObjectID) (*models.SLO, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var slo models.SLO
+	err := r.slos.FindOne(ctx, bson.M{"_id": id, "agent_id": agentID}).Decode(&slo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("slo not found")
+		}
+		return nil, err
+	}
+
+	return &slo, nil
+}
+
+// ListSLOs retrieves the SLOs of an agent, oldest first
+func (r *SLORepository) ListSLOs(ctx context.Context, agentID primitive.ObjectID) ([]models.SLO, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	return r.findSLOs(ctx, bson.M{"agent_id": agentID})
+}
+
+// ListAllSLOs retrieves the SLOs of every agent
+func (r *SLORepository) ListAllSLOs(ctx context.Context) ([]models.SLO, error) {
+	return r.findSLOs(ctx, bson.M{})
+}
+
+// findSLOs retrieves the SLOs matching a filter, oldest first
+func (r *SLORepository) findSLOs(ctx context.Context, filter bson.M) ([]models.SLO, error) {
+	cursor, err := r.slos.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	slos := []models.SLO{}
+	if err := cursor.All(ctx, &slos); err != nil {
+		return nil, err
+	}
+
+	return slos, nil
+}
+
+// DeleteSLO deletes an SLO of an agent and its status
+func (r *SLORepository) DeleteSLO(ctx context.Context, agentID, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.slos.DeleteOne(ctx, bson.M{"_id": id, "agent_id": agentID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("slo not found")
+	}
+
+	_, err = r.statuses.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// ListSLOStatuses retrieves the last computed statuses of the SLOs of an agent
+func (r *SLORepository) ListSLOStatuses(ctx context.Context, agentID primitive.ObjectID) ([]models.SLOStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.statuses.Find(ctx, bson.M{"agent_id": agentID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	statuses := []models.SLOStatus{}
+	if err := cursor.All(ctx, &statuses); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// ComputeSLOStatus counts the finished and good runs of the SLO's agent version over its rolling window up to now
+func (r *SLORepository) ComputeSLOStatus(ctx context.Context, slo *models.SLO, now time.Time) (*models.SLOStatus, error) {
+	from := now.Add(-time.Duration(slo.WindowDays) * 24 * time.Hour)
+	filter := bson.M{
+		"agent_id": slo.AgentID,
+		"version":  slo.Version,
+		"created":  bson.M{"$gte": from, "$lt": now},
+		"status":   bson.M{"$ne": models.RunStatusRunning},
+	}
+
+	runs, err := r.runs.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to count runs: %w", err)
+	}
+
+	switch slo.Objective {
+	case models.SLOObjectiveSuccessRate:
+		filter["status"] = models.RunStatusCompleted
+	case models.SLOObjectiveLatency:
+		filter["time_taken"] = bson.M{"$lte": slo.Threshold}
+	default:
+		return nil, fmt.Errorf("unknown objective %q", slo.Objective)
+	}
+
+	good, err := r.runs.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to count good runs: %w", err)
+	}
+
+	return NewSLOStatus(slo, from, now, runs, good), nil
+}
+
+// UpsertSLOStatus writes the status of an SLO, keyed by the SLO ID
+func (r *SLORepository) UpsertSLOStatus(ctx context.Context, status *models.SLOStatus) error {
+	upsert := true
+	_, err := r.statuses.UpdateOne(ctx, bson.M{"_id": status.SLOID}, bson.M{"$set": status}, &options.UpdateOptions{
+		Upsert: &upsert,
+	})
+	return err
+}
+
+// NewSLOStatus computes the compliance, error budget and burn rate of an SLO from its finished and good runs.
+// The burn rate is the ratio of bad runs over the ratio the target allows, so it is 1 when the budget is spent
+// exactly over the window and above 1 when it would be exhausted early.
+func NewSLOStatus(slo *models.SLO, from, to time.Time, runs, good int64) *models.SLOStatus {
+	status := &models.SLOStatus{
+		SLOID:                slo.ID,
+		AgentID:              slo.AgentID,
+		Version:              slo.Version,
+		Name:                 slo.Name,
+		Objective:            slo.Objective,
+		Target:               slo.Target,
+		Threshold:            slo.Threshold,
+		From:                 from,
+		To:                   to,
+		Runs:                 runs,
+		GoodRuns:             good,
+		Compliance:           100,
+		ErrorBudgetRemaining: 100,
+		Met:                  true,
+		ComputedAt:           time.Now(),
+	}
+	if runs == 0 {
+		return status
+	}
+
+	badRatio := float64(runs-good) / float64(runs)
+	allowedRatio := 1 - slo.Target/100
+
+	status.Compliance = float64(good) / float64(runs) * 100
+	status.BurnRate = badRatio / allowedRatio
+	status.ErrorBudgetRemaining = (1 - status.BurnRate) * 100
+	status.Met = status.Compliance >= slo.Target
+	return status
+}
@@ -0,0 +1,663 @@
+package db
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PercentileRank returns the 1-based nearest rank of the p-th percentile (0 < p <= 100) of n sorted values
+func PercentileRank(n int64, p float64) int64 {
+	rank := int64(math.Ceil(p / 100 * float64(n)))
+	if rank < 1 {
+		rank = 1
+	}
+	return rank
+}
+
+// Percentile returns the p-th percentile (0 < p <= 100) of ascending sorted values, using the nearest rank method
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[PercentileRank(int64(len(sorted)), p)-1]
+}
+
+// FinishVersionStats computes the rates and averages of version statistics from their run counts, the number of
+// completed runs and the total time taken by the finished runs
+func FinishVersionStats(stats *models.VersionStats, completed int64, timeTaken float64) {
+	if stats.Runs == 0 {
+		return
+	}
+
+	stats.SuccessRate = float64(completed) / float64(stats.Runs) * 100
+	stats.AvgTimeTaken = timeTaken / float64(stats.Runs)
+	stats.CostPerRun = stats.TotalCost / float64(stats.Runs)
+}
+
+// TimeBucket holds the run aggregates of a time bucket a time series is computed from
+type TimeBucket struct {
+	Start     time.Time `bson:"_id"`
+	Runs      int64     `bson:"runs"`
+	Finished  int64     `bson:"finished"`
+	Completed int64     `bson:"completed"`
+	TimeTaken float64   `bson:"time_taken"`
+	Cost      float64   `bson:"cost"`
+}
+
+// Add adds a run to the bucket
+func (b *TimeBucket) Add(run *models.AgentRun) {
+	b.Runs++
+	b.Cost += run.Cost
+	if run.Status == models.RunStatusRunning {
+		return
+	}
+	b.Finished++
+	b.TimeTaken += run.TimeTaken
+	if run.Status == models.RunStatusCompleted {
+		b.Completed++
+	}
+}
+
+// timeBucketStart returns the expression truncating the creation time of a run to the start of its time bucket
+func timeBucketStart(bucket time.Duration) bson.M {
+	return truncateTime("$created", bucket)
+}
+
+// truncateTime returns the expression truncating a time field to the start of its time bucket
+func truncateTime(field string, bucket time.Duration) bson.M {
+	unit, binSize := "minute", int64(bucket/time.Minute)
+	if bucket%(24*time.Hour) == 0 {
+		unit, binSize = "day", int64(bucket/(24*time.Hour))
+	} else if bucket%time.Hour == 0 {
+		unit, binSize = "hour", int64(bucket/time.Hour)
+	}
+
+	return bson.M{"$dateTrunc": bson.M{
+		"date":    field,
+		"unit":    unit,
+		"binSize": binSize,
+	}}
+}
+
+// timeBucketGroup returns the $group stage aggregating runs into time buckets of the given duration
+func timeBucketGroup(bucket time.Duration) bson.M {
+	finished := bson.M{"$ne": bson.A{"$status", models.RunStatusRunning}}
+	return bson.M{
+		"_id":        timeBucketStart(bucket),
+		"runs":       bson.M{"$sum": 1},
+		"finished":   bson.M{"$sum": bson.M{"$cond": bson.A{finished, 1, 0}}},
+		"completed":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", models.RunStatusCompleted}}, 1, 0}}},
+		"time_taken": bson.M{"$sum": bson.M{"$cond": bson.A{finished, "$time_taken", 0}}},
+		"cost":       bson.M{"$sum": "$cost"},
+	}
+}
+
+// BuildTimeSeries computes the points of a metric from time buckets, with a point for every bucket from the
+// bucket containing from up to to, so that buckets without runs are charted as zero
+func BuildTimeSeries(metric string, bucket time.Duration, from, to time.Time, buckets []TimeBucket) []models.TimeSeriesPoint {
+	byStart := make(map[int64]TimeBucket, len(buckets))
+	for _, b := range buckets {
+		byStart[b.Start.UnixNano()] = b
+	}
+
+	points := []models.TimeSeriesPoint{}
+	for start := from.UTC().Truncate(bucket); start.Before(to); start = start.Add(bucket) {
+		b := byStart[start.UnixNano()]
+		point := models.TimeSeriesPoint{Time: start, Runs: b.Runs}
+
+		switch metric {
+		case models.TimeSeriesRuns:
+			point.Value = float64(b.Runs)
+		case models.TimeSeriesCost:
+			point.Value = b.Cost
+		case models.TimeSeriesLatency:
+			if b.Finished > 0 {
+				point.Value = b.TimeTaken / float64(b.Finished)
+			}
+		case models.TimeSeriesSuccessRate:
+			if b.Finished > 0 {
+				point.Value = float64(b.Completed) / float64(b.Finished) * 100
+			}
+		}
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// VersionBucket counts the runs of an agent version created in a time bucket
+type VersionBucket struct {
+	Start   time.Time `bson:"start"`
+	Version string    `bson:"version"`
+	Runs    int64     `bson:"runs"`
+}
+
+// BuildVersionAdoption computes the share of the runs each version of an agent handled, over the whole range and
+// for every bucket from the bucket containing from up to to. Versions are listed in the order they first ran, and
+// every point lists all versions so that points can be charted as stacked series.
+func BuildVersionAdoption(bucket time.Duration, from, to time.Time, buckets []VersionBucket) ([]models.VersionShare, []models.AdoptionPoint) {
+	sort.SliceStable(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+
+	var versions []string
+	totals := map[string]int64{}
+	byStart := map[int64]map[string]int64{}
+	for _, b := range buckets {
+		if _, ok := totals[b.Version]; !ok {
+			versions = append(versions, b.Version)
+		}
+		totals[b.Version] += b.Runs
+
+		start := b.Start.UnixNano()
+		if byStart[start] == nil {
+			byStart[start] = map[string]int64{}
+		}
+		byStart[start][b.Version] += b.Runs
+	}
+
+	shares := func(runs map[string]int64) ([]models.VersionShare, int64) {
+		var sum int64
+		for _, n := range runs {
+			sum += n
+		}
+		list := make([]models.VersionShare, 0, len(versions))
+		for _, version := range versions {
+			share := models.VersionShare{Version: version, Runs: runs[version]}
+			if sum > 0 {
+				share.Share = float64(share.Runs) / float64(sum) * 100
+			}
+			list = append(list, share)
+		}
+		return list, sum
+	}
+
+	overall, _ := shares(totals)
+	points := []models.AdoptionPoint{}
+	for start := from.UTC().Truncate(bucket); start.Before(to); start = start.Add(bucket) {
+		list, runs := shares(byStart[start.UnixNano()])
+		points = append(points, models.AdoptionPoint{Time: start, Runs: runs, Versions: list})
+	}
+
+	return overall, points
+}
+
+// HistogramCount is the number of finished runs whose time taken falls in the histogram bucket starting at Min
+type HistogramCount struct {
+	Min   float64 `bson:"_id"`
+	Count int64   `bson:"count"`
+}
+
+// HistogramBoundaries returns the $bucket boundaries of a latency histogram with the given upper bounds: the first
+// bucket starts at 0, and runs taking at least the last bound fall in the overflow bucket
+func HistogramBoundaries(bounds []float64) []float64 {
+	return append([]float64{0}, bounds...)
+}
+
+// BuildLatencyHistogram computes the buckets of a latency histogram with the given upper bounds from the counts of
+// its non-empty buckets. The last bucket has no upper bound and counts the runs taking at least the last bound.
+func BuildLatencyHistogram(bounds []float64, counts []HistogramCount) ([]models.HistogramBucket, int64) {
+	byMin := make(map[float64]int64, len(counts))
+	for _, c := range counts {
+		byMin[c.Min] += c.Count
+	}
+
+	buckets := make([]models.HistogramBucket, 0, len(bounds)+1)
+	var runs int64
+	lower := 0.0
+	for i := range bounds {
+		upper := bounds[i]
+		buckets = append(buckets, models.HistogramBucket{Min: lower, Max: &upper, Count: byMin[lower]})
+		runs += byMin[lower]
+		lower = upper
+	}
+	buckets = append(buckets, models.HistogramBucket{Min: lower, Count: byMin[lower]})
+	runs += byMin[lower]
+
+	return buckets, runs
+}
+
+// minAnomalyBaseline is the number of baseline values below which no anomaly is flagged
+const minAnomalyBaseline = 8
+
+// DetectAnomaly flags a value exceeding the mean of its baseline values by more than threshold standard deviations.
+// It returns nil when the value is within the threshold, or when the baseline is too short or has no variance.
+func DetectAnomaly(metric string, value float64, baseline []float64, threshold float64) *Anomaly {
+	if len(baseline) < minAnomalyBaseline {
+		return nil
+	}
+
+	var sum float64
+	for _, v := range baseline {
+		sum += v
+	}
+	mean := sum / float64(len(baseline))
+
+	var squares float64
+	for _, v := range baseline {
+		squares += (v - mean) * (v - mean)
+	}
+	stdDev := math.Sqrt(squares / float64(len(baseline)))
+	if stdDev == 0 {
+		return nil
+	}
+
+	sigma := (value - mean) / stdDev
+	if sigma <= threshold {
+		return nil
+	}
+
+	return &Anomaly{
+		Metric:   metric,
+		Value:    value,
+		Baseline: mean,
+		StdDev:   stdDev,
+		Sigma:    sigma,
+	}
+}
+
+// HeatmapCell holds the run aggregates of an hour of a day of the week a heatmap is computed from. Weekday
+// follows time.Weekday, starting at 0 on Sunday.
+type HeatmapCell struct {
+	Weekday int     `bson:"weekday"`
+	Hour    int     `bson:"hour"`
+	Runs    int64   `bson:"runs"`
+	Cost    float64 `bson:"cost"`
+}
+
+// BuildHeatmap computes the values of a heatmap metric from its cells, leaving hours without runs at zero
+func BuildHeatmap(metric string, cells []HeatmapCell) [7][24]float64 {
+	var values [7][24]float64
+	for _, cell := range cells {
+		if cell.Weekday < 0 || cell.Weekday > 6 || cell.Hour < 0 || cell.Hour > 23 {
+			continue
+		}
+		switch metric {
+		case models.HeatmapRuns:
+			values[cell.Weekday][cell.Hour] += float64(cell.Runs)
+		case models.HeatmapCost:
+			values[cell.Weekday][cell.Hour] += cell.Cost
+		}
+	}
+	return values
+}
+
+// leaderboardFields are the fields of leaderboard entries ranked by each leaderboard metric
+var leaderboardFields = map[string]string{
+	models.LeaderboardCost:   "total_cost",
+	models.LeaderboardErrors: "errors",
+	models.LeaderboardRuns:   "runs",
+}
+
+// RankLeaderboard sorts leaderboard entries by a metric, highest first, and keeps the first limit entries. Ties are
+// ordered by agent name and version.
+func RankLeaderboard(entries []models.LeaderboardEntry, by string, limit int) []models.LeaderboardEntry {
+	value := func(e models.LeaderboardEntry) float64 {
+		switch by {
+		case models.LeaderboardCost:
+			return e.TotalCost
+		case models.LeaderboardErrors:
+			return float64(e.Errors)
+		default:
+			return float64(e.Runs)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if vi, vj := value(entries[i]), value(entries[j]); vi != vj {
+			return vi > vj
+		}
+		if entries[i].AgentName != entries[j].AgentName {
+			return entries[i].AgentName < entries[j].AgentName
+		}
+		return entries[i].Version < entries[j].Version
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// errorCategoryExpr is the aggregation expression of the error category of a run, see models.AgentRun.ErrorCategory
+var errorCategoryExpr = bson.M{"$ifNull": bson.A{"$error_type", "$status"}}
+
+// ErrorCount is the number of failed runs of an agent in an error category
+type ErrorCount struct {
+	AgentID   primitive.ObjectID `bson:"agent_id"`
+	AgentName string             `bson:"agent_name"`
+	Category  string             `bson:"category"`
+	Count     int64              `bson:"count"`
+}
+
+// newErrorCategoryStats compares the errors of a category to the errors of the preceding window
+func newErrorCategoryStats(category string, count, previous int64) models.ErrorCategoryStats {
+	stats := models.ErrorCategoryStats{
+		Type:          category,
+		Count:         count,
+		PreviousCount: previous,
+		Change:        count - previous,
+		Trend:         "neutral",
+	}
+	if previous > 0 {
+		percent := float64(count-previous) / float64(previous) * 100
+		stats.ChangePercent = &percent
+	}
+	switch {
+	case count > previous:
+		stats.Trend = "up"
+	case count < previous:
+		stats.Trend = "down"
+	}
+	return stats
+}
+
+// BuildErrorReport compares the error counts of agents to the counts of the preceding window. Agents with the most
+// errors come first, and at most limit categories are listed per agent, most frequent first.
+func BuildErrorReport(current, previous []ErrorCount, limit int) []models.AgentErrorStats {
+	type agentCounts struct {
+		name                string
+		current, previous   map[string]int64
+		currentN, previousN int64
+	}
+	byAgent := map[primitive.ObjectID]*agentCounts{}
+	add := func(counts []ErrorCount, isCurrent bool) {
+		for _, c := range counts {
+			agent, ok := byAgent[c.AgentID]
+			if !ok {
+				agent = &agentCounts{current: map[string]int64{}, previous: map[string]int64{}}
+				byAgent[c.AgentID] = agent
+			}
+			if c.AgentName != "" {
+				agent.name = c.AgentName
+			}
+			if isCurrent {
+				agent.current[c.Category] += c.Count
+				agent.currentN += c.Count
+			} else {
+				agent.previous[c.Category] += c.Count
+				agent.previousN += c.Count
+			}
+		}
+	}
+	add(current, true)
+	add(previous, false)
+
+	report := make([]models.AgentErrorStats, 0, len(byAgent))
+	for agentID, agent := range byAgent {
+		stats := models.AgentErrorStats{
+			AgentID:    agentID,
+			AgentName:  agent.name,
+			Errors:     newErrorCategoryStats("", agent.currentN, agent.previousN),
+			Categories: []models.ErrorCategoryStats{},
+		}
+		for category, count := range agent.current {
+			stats.Categories = append(stats.Categories, newErrorCategoryStats(category, count, agent.previous[category]))
+		}
+		for category, count := range agent.previous {
+			if _, ok := agent.current[category]; !ok {
+				stats.Categories = append(stats.Categories, newErrorCategoryStats(category, 0, count))
+			}
+		}
+
+		sort.Slice(stats.Categories, func(i, j int) bool {
+			ci, cj := stats.Categories[i], stats.Categories[j]
+			if ci.Count != cj.Count {
+				return ci.Count > cj.Count
+			}
+			if ci.PreviousCount != cj.PreviousCount {
+				return ci.PreviousCount > cj.PreviousCount
+			}
+			return ci.Type < cj.Type
+		})
+		if len(stats.Categories) > limit {
+			stats.Categories = stats.Categories[:limit]
+		}
+		report = append(report, stats)
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Errors.Count != report[j].Errors.Count {
+			return report[i].Errors.Count > report[j].Errors.Count
+		}
+		return report[i].AgentName < report[j].AgentName
+	})
+	return report
+}
+
+// CostShares splits the cost of a run evenly between its models, so that the costs attributed to models add up to
+// the total cost. Runs without models are attributed to an empty model name.
+func CostShares(run *models.AgentRun) map[string]float64 {
+	if len(run.Models) == 0 {
+		return map[string]float64{"": run.Cost}
+	}
+	shares := make(map[string]float64, len(run.Models))
+	for _, model := range run.Models {
+		shares[model] += run.Cost / float64(len(run.Models))
+	}
+	return shares
+}
+
+// FinishCostBreakdown computes the share of the total cost of every item, sorting the most expensive items first,
+// and returns the total cost
+func FinishCostBreakdown(items []models.CostBreakdownItem) float64 {
+	var total float64
+	for _, item := range items {
+		total += item.Cost
+	}
+	for i := range items {
+		if total > 0 {
+			items[i].Percent = items[i].Cost / total * 100
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Cost != items[j].Cost {
+			return items[i].Cost > items[j].Cost
+		}
+		return items[i].Name < items[j].Name
+	})
+	return total
+}
+
+// TokenShares splits the tokens of a run evenly between its models, like CostShares
+func TokenShares(run *models.AgentRun) (prompt, completion float64) {
+	n := len(run.Models)
+	if n == 0 {
+		n = 1
+	}
+	return float64(run.PromptTokens) / float64(n), float64(run.CompletionTokens) / float64(n)
+}
+
+// FinishTokenUsage sorts token usage items by day, agent and model, and sums their tokens and cost into usage
+func FinishTokenUsage(usage *models.TokenUsage, items []models.TokenUsageItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Day != items[j].Day {
+			return items[i].Day < items[j].Day
+		}
+		if items[i].Agent != items[j].Agent {
+			return items[i].Agent < items[j].Agent
+		}
+		return items[i].Model < items[j].Model
+	})
+
+	for _, item := range items {
+		usage.PromptTokens += item.PromptTokens
+		usage.CompletionTokens += item.CompletionTokens
+		usage.Cost += item.Cost
+	}
+	usage.Items = items
+}
+
+// mongoTimezone returns the timezone MongoDB date operators compute dates in for a location: its IANA name, or its
+// UTC offset at t for the host's timezone, which has no name
+func mongoTimezone(loc *time.Location, t time.Time) string {
+	if loc == nil || loc == time.Local {
+		return t.In(time.Local).Format("-07:00")
+	}
+	return loc.String()
+}
+
+// Run list fields usage statistics can be computed for
+const (
+	UsageFieldTools  = "tools"
+	UsageFieldModels = "models"
+)
+
+// UsageAccumulator holds the run aggregates of a tool or model its usage statistics are computed from
+type UsageAccumulator struct {
+	Name      string  `bson:"_id"`
+	Runs      int64   `bson:"runs"`
+	Failed    int64   `bson:"failed"`
+	TimeTaken float64 `bson:"time_taken"`
+	Cost      float64 `bson:"cost"`
+}
+
+// stats computes the rates and averages of the accumulated usage
+func (a *UsageAccumulator) stats() models.UsageStats {
+	stats := models.UsageStats{
+		Name:      a.Name,
+		Runs:      a.Runs,
+		Failed:    a.Failed,
+		TotalCost: a.Cost,
+	}
+	if a.Runs > 0 {
+		stats.ErrorRate = float64(a.Failed) / float64(a.Runs) * 100
+		stats.AvgTimeTaken = a.TimeTaken / float64(a.Runs)
+	}
+	return stats
+}
+
+// sortUsageStats orders usage statistics by number of runs, most used first, then by name
+func sortUsageStats(stats []models.UsageStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Runs != stats[j].Runs {
+			return stats[i].Runs > stats[j].Runs
+		}
+		return stats[i].Name < stats[j].Name
+	})
+}
+
+// runListField returns the values of a run list field
+func runListField(run *models.AgentRun, field string) []string {
+	switch field {
+	case UsageFieldTools:
+		return run.Tools
+	case UsageFieldModels:
+		return run.Models
+	}
+	return nil
+}
+
+// aggregateUsageStats computes the usage statistics of the values of a run list field over the runs matching a
+// query, most used first
+func aggregateUsageStats(ctx context.Context, runs collection, match bson.M, field string) ([]models.UsageStats, error) {
+	cursor, err := runs.Aggregate(ctx, []bson.M{
+		{"$match": match},
+		{"$unwind": "$" + field},
+		{"$group": bson.M{
+			"_id":        "$" + field,
+			"runs":       bson.M{"$sum": 1},
+			"failed":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ne": bson.A{"$status", models.RunStatusCompleted}}, 1, 0}}},
+			"time_taken": bson.M{"$sum": "$time_taken"},
+			"cost":       bson.M{"$sum": "$cost"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var accumulators []UsageAccumulator
+	if err := cursor.All(ctx, &accumulators); err != nil {
+		return nil, err
+	}
+
+	return UsageStatsFrom(accumulators), nil
+}
+
+// UsageStatsFrom computes the usage statistics of tools or models from their aggregates, most used first
+func UsageStatsFrom(accumulators []UsageAccumulator) []models.UsageStats {
+	stats := make([]models.UsageStats, 0, len(accumulators))
+	for i := range accumulators {
+		stats = append(stats, accumulators[i].stats())
+	}
+	sortUsageStats(stats)
+	return stats
+}
+
+// UsageStatsOf computes the usage statistics of the tools or models of finished runs, most used first
+func UsageStatsOf(runs []models.AgentRun, field string) []models.UsageStats {
+	byName := map[string]*UsageAccumulator{}
+	for i := range runs {
+		run := &runs[i]
+		if run.Status == models.RunStatusRunning {
+			continue
+		}
+
+		for _, name := range runListField(run, field) {
+			acc, ok := byName[name]
+			if !ok {
+				acc = &UsageAccumulator{Name: name}
+				byName[name] = acc
+			}
+			acc.Runs++
+			acc.TimeTaken += run.TimeTaken
+			acc.Cost += run.Cost
+			if run.Status != models.RunStatusCompleted {
+				acc.Failed++
+			}
+		}
+	}
+
+	stats := make([]models.UsageStats, 0, len(byName))
+	for _, acc := range byName {
+		stats = append(stats, acc.stats())
+	}
+	sortUsageStats(stats)
+	return stats
+}
+
+// ClusterAccumulator holds the run aggregates of a cluster its statistics are computed from
+type ClusterAccumulator struct {
+	Cluster  string  `bson:"_id"`
+	Agents   int64   `bson:"agents"`
+	Versions int64   `bson:"versions"`
+	Runs     int64   `bson:"runs"`
+	Finished int64   `bson:"finished"`
+	Failed   int64   `bson:"failed"`
+	Cost     float64 `bson:"cost"`
+}
+
+// ClusterStatsOf computes the statistics of clusters from their aggregates, busiest clusters first. The error rate
+// is the percentage of finished runs that failed.
+func ClusterStatsOf(accumulators []ClusterAccumulator) []models.ClusterStats {
+	stats := make([]models.ClusterStats, 0, len(accumulators))
+	for _, acc := range accumulators {
+		cluster := models.ClusterStats{
+			Cluster:   acc.Cluster,
+			Agents:    acc.Agents,
+			Versions:  acc.Versions,
+			Runs:      acc.Runs,
+			Failed:    acc.Failed,
+			TotalCost: acc.Cost,
+		}
+		if acc.Finished > 0 {
+			cluster.ErrorRate = float64(acc.Failed) / float64(acc.Finished) * 100
+		}
+		stats = append(stats, cluster)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Runs != stats[j].Runs {
+			return stats[i].Runs > stats[j].Runs
+		}
+		return stats[i].Cluster < stats[j].Cluster
+	})
+	return stats
+}
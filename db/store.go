@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StorageMongo is the name of the MongoDB storage backend, the default one
+const StorageMongo = "mongo"
+
+// AgentStore stores agents, their versions and their runs
+type AgentStore interface {
+	CreateAgent(ctx context.Context, agent *models.Agent) error
+	GetAgentByID(ctx context.Context, id primitive.ObjectID) (*models.Agent, error)
+	GetAgentByName(ctx context.Context, name string) (*models.Agent, error)
+	ListAgents(ctx context.Context, listOpts ListOptions) ([]models.Agent, error)
+	SetAgentArchived(ctx context.Context, id primitive.ObjectID, archived bool) (*models.Agent, error)
+	SetAgentSigningSecret(ctx context.Context, id primitive.ObjectID, secret string) (*models.Agent, error)
+
+	CreateAgentVersion(ctx context.Context, version *models.AgentVersion) error
+	GetAgentVersions(ctx context.Context, agentID primitive.ObjectID, listOpts ListOptions) ([]models.AgentVersion, error)
+	GetAgentVersion(ctx context.Context, agentID primitive.ObjectID, version string) (*models.AgentVersion, error)
+
+	CreateAgentRun(ctx context.Context, run *models.AgentRun) error
+	CreateAgentRunBatch(ctx context.Context, runs []*models.AgentRun, opts BatchOptions) error
+	GetAgentRun(ctx context.Context, agentID primitive.ObjectID, runID int64) (*models.AgentRun, error)
+	GetAgentRuns(ctx context.Context, agentID primitive.ObjectID, listOpts ListOptions) ([]models.AgentRun, error)
+	GetAgentVersionRuns(ctx context.Context, agentID primitive.ObjectID, version string, listOpts ListOptions) ([]models.AgentRun, error)
+	QueryRuns(ctx context.Context, query models.RunQuery, from, to time.Time, listOpts ListOptions) ([]models.AgentRun, error)
+	GetAgentRunsAfter(ctx context.Context, agentID primitive.ObjectID, filter RunFilter, after time.Time, afterID primitive.ObjectID, limit int64) ([]models.AgentRun, error)
+	GetExistingRunIDs(ctx context.Context, agentID primitive.ObjectID, runIDs []int64) (map[int64]bool, error)
+	GetVersionStats(ctx context.Context, agentID primitive.ObjectID, version string, from, to time.Time) (*models.VersionStats, error)
+	GetUsageStats(ctx context.Context, agentID primitive.ObjectID, field string, version string, from, to time.Time) ([]models.UsageStats, error)
+	GetVersionTimeBuckets(ctx context.Context, agentID primitive.ObjectID, version string, bucket time.Duration, from, to time.Time) ([]TimeBucket, error)
+	GetLatencyHistogram(ctx context.Context, agentID primitive.ObjectID, version string, bounds []float64, from, to time.Time) ([]HistogramCount, error)
+	GetAdoptionBuckets(ctx context.Context, agentID primitive.ObjectID, bucket time.Duration, from, to time.Time) ([]VersionBucket, error)
+	ExportAgentRuns(ctx context.Context, agentID primitive.ObjectID, filter RunFilter, fn func(run *models.AgentRun) error) error
+
+	CreateRunSteps(ctx context.Context, steps []*models.RunStep) error
+	GetRunSteps(ctx context.Context, traceID string) ([]models.RunStep, error)
+
+	CreateIngestToken(ctx context.Context, token *models.IngestToken) error
+	GetIngestToken(ctx context.Context, hash string) (*models.IngestToken, error)
+
+	CreateTeam(ctx context.Context, team *models.Team) error
+	GetTeam(ctx context.Context, name string) (*models.Team, error)
+	ListTeams(ctx context.Context) ([]models.Team, error)
+	DeleteTeam(ctx context.Context, name string) error
+	SetAgentTeam(ctx context.Context, id primitive.ObjectID, team string) (*models.Agent, error)
+}
+
+// UIStore serves the aggregated views of the dashboard
+type UIStore interface {
+	GetDashboardStats(ctx context.Context, locale *Locale, filter DashboardFilter) ([]StatsData, error)
+	GetRecentActivity(ctx context.Context, filter ActivityFilter) ([]ActivityData, *ActivityCursor, error)
+	GetAgentVersions(ctx context.Context, filter VersionMetricsFilter, listOpts ListOptions) ([]models.AgentVersionMetrics, error)
+	CountAgentVersions(ctx context.Context, filter VersionMetricsFilter) (int64, error)
+	GetAgentVersionMetrics(ctx context.Context, versionID primitive.ObjectID) (*models.AgentVersionMetrics, error)
+	GetAutoscalingSignals(ctx context.Context, window time.Duration, agentName string) (*AutoscalingSignals, error)
+	GetModelStats(ctx context.Context, from, to time.Time) ([]models.UsageStats, error)
+	GetClusterStats(ctx context.Context, from, to time.Time) ([]models.ClusterStats, error)
+	GetHeatmapCells(ctx context.Context, from, to time.Time) ([]HeatmapCell, error)
+	GetTimeBuckets(ctx context.Context, bucket time.Duration, from, to time.Time) ([]TimeBucket, error)
+	GetLeaderboard(ctx context.Context, by, group string, from, to time.Time, limit int) ([]models.LeaderboardEntry, error)
+	GetErrorCounts(ctx context.Context, from, to time.Time) ([]ErrorCount, error)
+	GetCostBreakdown(ctx context.Context, groupBy string, from, to time.Time) ([]models.CostBreakdownItem, error)
+	GetTokenUsage(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.TokenUsageItem, error)
+}
+
+// MetricsStore aggregates the runs of agent versions into their stored metrics, for the worker and the admin API
+type MetricsStore interface {
+	ListAllAgents(ctx context.Context) ([]*models.Agent, error)
+	ListAllAgentVersions(ctx context.Context) ([]*models.AgentVersion, error)
+	ComputeAgentVersionMetrics(ctx context.Context, agent *models.Agent, agentVersion *models.AgentVersion, now time.Time) (*models.AgentVersionMetrics, error)
+	UpsertAgentVersionMetrics(ctx context.Context, avm *models.AgentVersionMetrics) error
+	EnsureMetricsSchema(ctx context.Context) (int, error)
+	DryRun(ctx context.Context, timezones *Timezones) (*MetricsDryRun, error)
+}
+
+// BatchStore reads the versions and runs of several agents or versions at once, so that the GraphQL API reads each
+// level of a query in one query rather than one per agent or version. Stores that do not implement it are read an
+// agent or version at a time.
+type BatchStore interface {
+	// GetVersionsOfAgents retrieves the versions of agents by agent, latest first
+	GetVersionsOfAgents(ctx context.Context, agentIDs []primitive.ObjectID) (map[primitive.ObjectID][]models.AgentVersion, error)
+	// GetRunsOfAgents retrieves the latest runs of agents by agent, at most limit per agent
+	GetRunsOfAgents(ctx context.Context, agentIDs []primitive.ObjectID, limit int64) (map[primitive.ObjectID][]models.AgentRun, error)
+	// GetRunsOfVersions retrieves the latest runs of versions by version ID, at most limit per version
+	GetRunsOfVersions(ctx context.Context, versionIDs []primitive.ObjectID, limit int64) (map[primitive.ObjectID][]models.AgentRun, error)
+}
+
+// BatchUIStore reads the metrics of several versions at once, for the GraphQL API
+type BatchUIStore interface {
+	// GetMetricsOfVersions retrieves the metrics of versions by version ID, without the versions with no metrics
+	GetMetricsOfVersions(ctx context.Context, versionIDs []primitive.ObjectID) (map[primitive.ObjectID]*models.AgentVersionMetrics, error)
+}
+
+var (
+	_ AgentStore   = (*AgentRepository)(nil)
+	_ UIStore      = (*UIRepository)(nil)
+	_ MetricsStore = (*MetricsRepository)(nil)
+	_ BatchStore   = (*AgentRepository)(nil)
+	_ BatchUIStore = (*UIRepository)(nil)
+)
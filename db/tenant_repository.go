@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TenantRepository handles database operations for the registry of the tenants provisioned in database tenant mode
+type TenantRepository struct {
+	db       *MongoDB
+	tenants  collection
+	timeouts Timeouts
+}
+
+// NewTenantRepository creates a new tenant repository
+func NewTenantRepository(db *MongoDB) *TenantRepository {
+	return &TenantRepository{
+		db:       db,
+		tenants:  db.Collection("tenants"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// RegisterTenant adds a tenant to the registry, when it is not yet, and returns it along with whether it was added
+func (r *TenantRepository) RegisterTenant(ctx context.Context, name string) (*models.Tenant, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.tenants.UpdateOne(ctx, bson.M{"_id": name},
+		bson.M{"$setOnInsert": bson.M{"created_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, false, err
+	}
+
+	var tenant models.Tenant
+	if err := r.tenants.FindOne(ctx, bson.M{"_id": name}).Decode(&tenant); err != nil {
+		return nil, false, err
+	}
+	return &tenant, result.UpsertedCount > 0, nil
+}
+
+// GetTenant retrieves a tenant by name
+func (r *TenantRepository) GetTenant(ctx context.Context, name string) (*models.Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var tenant models.Tenant
+	err := r.tenants.FindOne(ctx, bson.M{"_id": name}).Decode(&tenant)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("tenant not found")
+		}
+		return nil, err
+	}
+
+	return &tenant, nil
+}
+
+// ListTenants retrieves all tenants, sorted by name
+func (r *TenantRepository) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.tenants.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tenants := []models.Tenant{}
+	if err := cursor.All(ctx, &tenants); err != nil {
+		return nil, err
+	}
+
+	return tenants, nil
+}
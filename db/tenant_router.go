@@ -0,0 +1,311 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tenant modes
+const (
+	// TenantModeSingle stores all data in a single database
+	TenantModeSingle = "single"
+	// TenantModeDatabase stores the data of each tenant in its own database
+	TenantModeDatabase = "database"
+	// TenantModeOrg stores the data of all organizations in a single database, scoped by org_id
+	TenantModeOrg = "org"
+	// TenantModeOrgDatabase stores the data of each organization in its own database, and their organizations and
+	// credentials in a shared database
+	TenantModeOrgDatabase = "org-database"
+)
+
+// tenantSetupTimeout bounds the creation of the indexes and validators of a tenant database and the migration of its
+// runs, when the tenant is provisioned
+const tenantSetupTimeout = 5 * time.Minute
+
+// tenantNamePattern restricts tenant names to values that are safe to use in a database name
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,47}$`)
+
+type databaseContextKey struct{}
+
+// TenantRouter selects the database of a tenant when running in database-per-tenant mode, the database scoped to
+// an organization in org mode, or the own database of an organization in org-database mode. Only provisioned tenants
+// are routed: the tenants of the registry in database mode, and the existing organizations in org modes.
+type TenantRouter struct {
+	base      *MongoDB
+	prefix    string
+	orgs      bool
+	mu        sync.RWMutex
+	databases map[string]*MongoDB
+	// validation is the schema validation mode of tenant databases, see EnsureValidators
+	validation string
+}
+
+// NewTenantRouter creates a new tenant router. Tenant databases are named prefix + tenant.
+func NewTenantRouter(base *MongoDB, prefix string) *TenantRouter {
+	return &TenantRouter{
+		base:      base,
+		prefix:    prefix,
+		databases: map[string]*MongoDB{},
+	}
+}
+
+// NewOrgRouter creates a new tenant router for org mode. Tenants are the hex IDs of organizations.
+func NewOrgRouter(base *MongoDB) *TenantRouter {
+	return &TenantRouter{
+		base:      base,
+		orgs:      true,
+		databases: map[string]*MongoDB{},
+	}
+}
+
+// NewOrgDatabaseRouter creates a new tenant router for org-database mode. Tenants are the hex IDs of organizations,
+// whose data is stored in the database named prefix + ID, while the organizations, API keys, service accounts, users
+// and ingest tokens stay in base.
+func NewOrgDatabaseRouter(base *MongoDB, prefix string) *TenantRouter {
+	return &TenantRouter{
+		base:      base,
+		prefix:    prefix,
+		orgs:      true,
+		databases: map[string]*MongoDB{},
+	}
+}
+
+// SetValidation sets the schema validation mode of the tenant databases, applied when each is provisioned
+func (t *TenantRouter) SetValidation(mode string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.validation = mode
+}
+
+// Database returns the database of a provisioned tenant. The tenants provisioned by other servers are looked up in
+// the registry, or among the organizations in org modes, the first time they are used.
+func (t *TenantRouter) Database(ctx context.Context, tenant string) (*MongoDB, error) {
+	orgID, err := t.parseTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.RLock()
+	database, ok := t.databases[tenant]
+	t.mu.RUnlock()
+	if ok {
+		return database, nil
+	}
+
+	if t.orgs {
+		if _, err := NewOrganizationRepository(t.base).GetOrganization(ctx, orgID); err != nil {
+			if err.Error() == "organization not found" {
+				return nil, errors.New("tenant not found")
+			}
+			return nil, err
+		}
+	} else if _, err := NewTenantRepository(t.base).GetTenant(ctx, tenant); err != nil {
+		return nil, err
+	}
+	return t.addDatabase(tenant, orgID), nil
+}
+
+// parseTenant checks the name of a tenant, and returns the ID of its organization in org modes
+func (t *TenantRouter) parseTenant(tenant string) (primitive.ObjectID, error) {
+	if t.orgs {
+		id, err := primitive.ObjectIDFromHex(tenant)
+		if err != nil || id.IsZero() {
+			return primitive.NilObjectID, errors.New("invalid organization ID")
+		}
+		return id, nil
+	}
+	if !tenantNamePattern.MatchString(tenant) {
+		return primitive.NilObjectID, errors.New("invalid tenant name")
+	}
+	return primitive.NilObjectID, nil
+}
+
+// addDatabase returns the database of a tenant, adding it to the databases of the router when it is not yet
+func (t *TenantRouter) addDatabase(tenant string, orgID primitive.ObjectID) *MongoDB {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if database, ok := t.databases[tenant]; ok {
+		return database
+	}
+
+	var database *MongoDB
+	if t.orgs && t.prefix != "" {
+		// Documents still carry their org_id, so that the shared collections and the live feed are scoped alike
+		database = t.base.WithDatabase(t.prefix + tenant)
+		database.shared = t.base
+		database = database.ForOrg(orgID)
+	} else if t.orgs {
+		database = t.base.ForOrg(orgID)
+	} else {
+		database = t.base.WithDatabase(t.prefix + tenant)
+	}
+	t.databases[tenant] = database
+	return database
+}
+
+// Provision registers a tenant in database mode and sets its database up. Provisioning a registered tenant sets its
+// database up again. It returns whether the tenant was added to the registry.
+func (t *TenantRouter) Provision(ctx context.Context, tenant string) (*models.Tenant, bool, error) {
+	if t.orgs {
+		return nil, false, errors.New("organizations are provisioned when they are created")
+	}
+	if _, err := t.parseTenant(tenant); err != nil {
+		return nil, false, err
+	}
+
+	registered, created, err := NewTenantRepository(t.base).RegisterTenant(ctx, tenant)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := t.setUp(ctx, t.addDatabase(tenant, primitive.NilObjectID), tenant); err != nil {
+		return nil, false, err
+	}
+	return registered, created, nil
+}
+
+// ProvisionOrg sets the own database of an organization up in org-database mode, in the cluster of its region
+func (t *TenantRouter) ProvisionOrg(ctx context.Context, org *models.Organization) error {
+	database, err := t.OrgDatabase(org)
+	if err != nil || t.prefix == "" {
+		return err
+	}
+	return t.setUp(ctx, database, org.ID.Hex())
+}
+
+// RegisterDatabases adds the tenant databases that exist but are missing from the registry to it, in database mode.
+// Their tenants were used before tenants were provisioned, which set their databases up.
+func (t *TenantRouter) RegisterDatabases(ctx context.Context) error {
+	if t.orgs {
+		return nil
+	}
+
+	names, err := t.base.Client().ListDatabaseNames(ctx, bson.M{
+		"name": bson.M{"$regex": "^" + regexp.QuoteMeta(t.prefix)},
+	})
+	if err != nil {
+		return err
+	}
+
+	repo := NewTenantRepository(t.base)
+	for _, name := range names {
+		tenant := strings.TrimPrefix(name, t.prefix)
+		if !tenantNamePattern.MatchString(tenant) {
+			continue
+		}
+		if _, created, err := repo.RegisterTenant(ctx, tenant); err != nil {
+			return err
+		} else if created {
+			log.Printf("Registered the tenant %s of database %s", tenant, name)
+		}
+	}
+	return nil
+}
+
+// setUp creates the indexes and validators of a tenant database and migrates its runs
+func (t *TenantRouter) setUp(ctx context.Context, database *MongoDB, tenant string) error {
+	ctx, cancel := context.WithTimeout(ctx, tenantSetupTimeout)
+	defer cancel()
+
+	t.mu.RLock()
+	validation := t.validation
+	t.mu.RUnlock()
+
+	repo := NewAgentRepository(database)
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		return fmt.Errorf("unable to create the agent indexes of database %s: %w", t.prefix+tenant, err)
+	}
+	if _, err := repo.MigrateTimeTaken(ctx); err != nil {
+		return fmt.Errorf("unable to migrate the time taken of the runs of database %s: %w", t.prefix+tenant, err)
+	}
+	if err := repo.EnsureValidators(ctx, validation); err != nil {
+		return fmt.Errorf("unable to set the validators of database %s: %w", t.prefix+tenant, err)
+	}
+	return nil
+}
+
+// OrgDatabase returns the database of an organization, in the cluster of its region
+func (t *TenantRouter) OrgDatabase(org *models.Organization) (*MongoDB, error) {
+	return t.addDatabase(org.ID.Hex(), org.ID).ForRegion(org.Region)
+}
+
+// Regions returns the regions of the base database, nil when none are configured
+func (t *TenantRouter) Regions() *Regions {
+	return t.base.Regions()
+}
+
+// Tenants lists the provisioned tenants, or the organizations in org mode
+func (t *TenantRouter) Tenants(ctx context.Context) ([]string, error) {
+	if t.orgs {
+		orgs, err := NewOrganizationRepository(t.base).ListOrganizations(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tenants := make([]string, len(orgs))
+		for i, org := range orgs {
+			tenants[i] = org.ID.Hex()
+		}
+		return tenants, nil
+	}
+
+	registered, err := t.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]string, len(registered))
+	for i, tenant := range registered {
+		tenants[i] = tenant.Name
+	}
+	return tenants, nil
+}
+
+// ListTenants lists the tenants of the registry of database mode, sorted by name
+func (t *TenantRouter) ListTenants(ctx context.Context) ([]models.Tenant, error) {
+	return NewTenantRepository(t.base).ListTenants(ctx)
+}
+
+// TenantDatabases returns the databases background jobs run against, by tenant: the base database when tenants is
+// nil, or the database of every tenant, in each configured region
+func TenantDatabases(ctx context.Context, base *MongoDB, tenants *TenantRouter) (map[string]*MongoDB, error) {
+	if tenants == nil {
+		return InRegions(map[string]*MongoDB{"": base}), nil
+	}
+
+	names, err := tenants.Tenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	databases := make(map[string]*MongoDB, len(names))
+	for _, tenant := range names {
+		database, err := tenants.Database(ctx, tenant)
+		if err != nil {
+			return nil, err
+		}
+		databases[tenant] = database
+	}
+	return InRegions(databases), nil
+}
+
+// WithDatabase returns a context carrying the database selected for the request
+func WithDatabase(ctx context.Context, database *MongoDB) context.Context {
+	return context.WithValue(ctx, databaseContextKey{}, database)
+}
+
+// DatabaseFromContext returns the database selected for the request, if any
+func DatabaseFromContext(ctx context.Context) *MongoDB {
+	database, _ := ctx.Value(databaseContextKey{}).(*MongoDB)
+	return database
+}
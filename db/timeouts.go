@@ -0,0 +1,18 @@
+package db
+
+import "time"
+
+// Timeouts bound the operations of the repositories of a database, by kind: reads, writes, and aggregations, which
+// MongoDB is also told to stop running with maxTimeMS once they time out
+type Timeouts struct {
+	Read      time.Duration
+	Write     time.Duration
+	Aggregate time.Duration
+}
+
+// DefaultTimeouts are the timeouts of the operations of a database unless configured otherwise
+var DefaultTimeouts = Timeouts{
+	Read:      10 * time.Second,
+	Write:     10 * time.Second,
+	Aggregate: 10 * time.Second,
+}
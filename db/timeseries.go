@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureTimeSeriesRuns creates the runs collection as a MongoDB time-series collection when it does not exist, with
+// the creation time of runs as its time field and their version as its meta field, so that runs are stored in
+// compressed buckets of a version and time range. It fails when the runs are already stored in a standard
+// collection, which cannot be converted in place. The queries of the database are then adapted to the buckets.
+func (m *MongoDB) EnsureTimeSeriesRuns() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	database := m.Database()
+	specs, err := database.ListCollectionSpecifications(ctx, bson.M{"name": "agent_runs"})
+	if err != nil {
+		return fmt.Errorf("unable to read the runs collection: %w", err)
+	}
+	if len(specs) > 0 && specs[0].Type != "timeseries" {
+		return errors.New("agent_runs already exists as a standard collection, move its runs to a time-series collection first")
+	}
+
+	if len(specs) == 0 {
+		timeSeries := options.TimeSeries().SetTimeField("created").SetMetaField("version_id").SetGranularity("seconds")
+		if err := database.CreateCollection(ctx, "agent_runs", options.CreateCollection().SetTimeSeriesOptions(timeSeries)); err != nil {
+			return fmt.Errorf("unable to create the runs time-series collection: %w", err)
+		}
+	}
+
+	// The buckets are only indexed by version and time, runs are also looked up by run ID, recording time and trace.
+	// Their agent and version indexes are created by AgentRepository.EnsureIndexes.
+	_, err = database.Collection("agent_runs").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "run_id", Value: 1}}},
+		{Keys: bson.D{{Key: "recorded_at", Value: 1}}},
+		{Keys: bson.D{{Key: "trace_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the runs indexes: %w", err)
+	}
+
+	m.timeSeriesRuns = true
+	return nil
+}
+
+// timeSeriesRuns adapts the queries of runs stored in a time-series collection. Buckets are keyed by version, so
+// conditions on the agent of runs are completed with the versions of the agent, letting MongoDB read the buckets of
+// those versions instead of unpacking every bucket of the time range.
+type timeSeriesRuns struct {
+	collection
+	// versions resolves the versions of the agents of a query
+	versions collection
+}
+
+// versionMatch returns the condition matching the versions of the agents a filter restricts runs to, nil when the
+// filter does not restrict the agent of runs
+func (c *timeSeriesRuns) versionMatch(ctx context.Context, filter interface{}) (bson.D, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	data, err := bson.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	var conditions bson.D
+	if err := bson.Unmarshal(data, &conditions); err != nil {
+		return nil, err
+	}
+
+	agentIDs := agentCondition(conditions)
+	if agentIDs == nil {
+		return nil, nil
+	}
+	ids, err := c.versions.Distinct(ctx, "_id", bson.M{"agent_id": bson.M{"$in": agentIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the versions of the agents of runs: %w", err)
+	}
+	return bson.D{{Key: "version_id", Value: bson.D{{Key: "$in", Value: append(bson.A{}, ids...)}}}}, nil
+}
+
+// agentCondition returns the agent IDs the conditions of a filter restrict documents to, in an agent_id equality
+// or $in condition of the filter or of its $and conditions, nil when there are none
+func agentCondition(conditions bson.D) bson.A {
+	for _, e := range conditions {
+		switch e.Key {
+		case "agent_id":
+			if in, ok := e.Value.(bson.D); ok {
+				for _, op := range in {
+					if ids, ok := op.Value.(bson.A); ok && op.Key == "$in" {
+						return ids
+					}
+				}
+				continue
+			}
+			return bson.A{e.Value}
+		case "$and":
+			all, _ := e.Value.(bson.A)
+			for _, condition := range all {
+				if d, ok := condition.(bson.D); ok {
+					if ids := agentCondition(d); ids != nil {
+						return ids
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// filter restricts a filter on the agent of runs to the versions of the agent
+func (c *timeSeriesRuns) filter(ctx context.Context, filter interface{}) (interface{}, error) {
+	match, err := c.versionMatch(ctx, filter)
+	if err != nil || match == nil {
+		return filter, err
+	}
+	return and(match, filter), nil
+}
+
+func (c *timeSeriesRuns) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	stages := reflect.ValueOf(pipeline)
+	if stages.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("unsupported pipeline %T", pipeline)
+	}
+
+	// The version condition is added before the $match stages the pipeline starts with, when one of them restricts
+	// the agent of runs
+	adapted := bson.A{}
+	for i := 0; i < stages.Len(); i++ {
+		data, err := bson.Marshal(stages.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		var stage bson.D
+		if err := bson.Unmarshal(data, &stage); err != nil {
+			return nil, err
+		}
+		if len(stage) != 1 || stage[0].Key != "$match" {
+			break
+		}
+		match, err := c.versionMatch(ctx, stage[0].Value)
+		if err != nil {
+			return nil, err
+		}
+		if match != nil {
+			adapted = append(adapted, bson.D{{Key: "$match", Value: match}})
+			break
+		}
+	}
+	for i := 0; i < stages.Len(); i++ {
+		adapted = append(adapted, stages.Index(i).Interface())
+	}
+	return c.collection.Aggregate(ctx, adapted, opts...)
+}
+
+func (c *timeSeriesRuns) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	adapted, err := c.filter(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return c.collection.CountDocuments(ctx, adapted, opts...)
+}
+
+func (c *timeSeriesRuns) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	adapted, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.DeleteMany(ctx, adapted, opts...)
+}
+
+func (c *timeSeriesRuns) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	adapted, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.DeleteOne(ctx, adapted, opts...)
+}
+
+func (c *timeSeriesRuns) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	adapted, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.Distinct(ctx, fieldName, adapted, opts...)
+}
+
+func (c *timeSeriesRuns) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	adapted, err := c.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.collection.Find(ctx, adapted, opts...)
+}
+
+func (c *timeSeriesRuns) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	adapted, err := c.filter(ctx, filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	return c.collection.FindOne(ctx, adapted, opts...)
+}
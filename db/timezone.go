@@ -0,0 +1,57 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Embeds the timezone database for hosts without one, such as minimal containers
+	_ "time/tzdata"
+)
+
+// Timezones holds the reporting timezones day and month boundaries are computed in, by default and per project
+type Timezones struct {
+	Default  *time.Location
+	Projects map[string]*time.Location
+}
+
+// ParseTimezones parses the default reporting timezone, an IANA name such as "Europe/Berlin", "UTC" or "Local" for
+// the timezone of the host, and the per-project overrides in the form "project=Europe/Berlin,other=Asia/Tokyo"
+func ParseTimezones(timezone, projectTimezones string) (*Timezones, error) {
+	timezones := &Timezones{Default: time.Local, Projects: map[string]*time.Location{}}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+		timezones.Default = loc
+	}
+
+	if projectTimezones == "" {
+		return timezones, nil
+	}
+	for _, entry := range strings.Split(projectTimezones, ",") {
+		project, name, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || project == "" || name == "" {
+			return nil, fmt.Errorf("invalid project timezone %q, expected project=timezone", entry)
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q for project %s: %w", name, project, err)
+		}
+		timezones.Projects[project] = loc
+	}
+
+	return timezones, nil
+}
+
+// For returns the reporting timezone of a project, or the default timezone when the project has none
+func (t *Timezones) For(project string) *time.Location {
+	if t == nil {
+		return time.Local
+	}
+	if loc, ok := t.Projects[project]; ok && project != "" {
+		return loc
+	}
+	return t.Default
+}
@@ -2,54 +2,146 @@ package db
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"ripple/models"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // UIRepository handles database operations for UI-related data
 type UIRepository struct {
-	db         *MongoDB
-	agents     *mongo.Collection
-	versions   *mongo.Collection
-	runs       *mongo.Collection
-	timeoutSec int
+	db          *MongoDB
+	agents      collection
+	versions    collection
+	runs        collection
+	rollups     collection
+	rollupState collection
+	timeouts    Timeouts
 }
 
 // NewUIRepository creates a new UI repository
 func NewUIRepository(db *MongoDB) *UIRepository {
 	return &UIRepository{
-		db:         db,
-		agents:     db.Database.Collection("agents"),
-		versions:   db.Database.Collection("agent_versions"),
-		runs:       db.Database.Collection("agent_runs"),
-		timeoutSec: 10,
+		db:          db,
+		agents:      db.Collection("agents"),
+		versions:    db.Collection("agent_versions"),
+		runs:        db.Collection("agent_runs"),
+		rollups:     db.Collection("rollups"),
+		rollupState: db.Collection("rollup_state"),
+		timeouts:    db.Timeouts(),
 	}
 }
 
 // StatsData represents the data structure for UI stats
 type StatsData struct {
-	Title  string  `json:"title"`
-	Value  string  `json:"value"`
-	Change string  `json:"change"`
-	Icon   string  `json:"icon"`
-	Trend  string  `json:"trend"`
-	Raw    float64 `json:"raw,omitempty"`
+	Key    string      `json:"key"`
+	Title  string      `json:"title"`
+	Value  string      `json:"value"`
+	Change string      `json:"change"`
+	Icon   string      `json:"icon"`
+	Trend  string      `json:"trend"`
+	Raw    float64     `json:"raw,omitempty"`
+	Unit   string      `json:"unit"`
+	Delta  StatsChange `json:"delta"`
+	Window StatsWindow `json:"window"`
+
+	// Currency is the ISO 4217 code of monetary statistics, whose unit is "currency"
+	Currency string `json:"currency,omitempty"`
+
+	// Anomaly is set when the latest value of a metric behind the statistic spikes above its rolling baseline
+	Anomaly *Anomaly `json:"anomaly,omitempty"`
+}
+
+// Anomaly describes a metric whose value over the latest time bucket deviates from the mean of the preceding
+// buckets by more than the detection threshold, in standard deviations
+type Anomaly struct {
+	Metric   string    `json:"metric"`
+	Value    float64   `json:"value"`
+	Baseline float64   `json:"baseline"`
+	StdDev   float64   `json:"stddev"`
+	Sigma    float64   `json:"sigma"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+}
+
+// StatsChange represents the change of a statistic as raw values, so clients can format it themselves
+type StatsChange struct {
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	Direction string  `json:"direction"`
+	Period    string  `json:"period"`
+}
+
+// StatsWindow is the time range a statistic covers and the time range it is compared to
+type StatsWindow struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	CompareFrom time.Time `json:"compare_from"`
+	CompareTo   time.Time `json:"compare_to"`
 }
 
 // ActivityData represents a single activity item for the UI
 type ActivityData struct {
-	ID       int64     `json:"id"`
-	Agent    string    `json:"agent"`
-	Action   string    `json:"action"`
-	Status   string    `json:"status"`
-	Time     time.Time `json:"time"`
-	Duration float64   `json:"duration"`
-	Cost     float64   `json:"cost"`
+	ID       int64              `json:"id"`
+	AgentID  primitive.ObjectID `json:"agent_id"`
+	Agent    string             `json:"agent"`
+	Action   string             `json:"action"`
+	Status   string             `json:"status"`
+	Time     time.Time          `json:"time"`
+	Duration float64            `json:"duration"`
+	Cost     float64            `json:"cost"`
+}
+
+// ActivityFilter selects the runs listed as recent activity
+type ActivityFilter struct {
+	// AgentID restricts the activity to the runs of an agent
+	AgentID *primitive.ObjectID
+	// Statuses restricts the activity to runs with one of the statuses
+	Statuses []string
+	// After continues the listing after the last item of a previous page
+	After *ActivityCursor
+	// Limit caps the number of items
+	Limit int64
+}
+
+// ActivityCursor points after an activity item, by the creation time and ID of its run
+type ActivityCursor struct {
+	Created time.Time
+	RunID   primitive.ObjectID
+}
+
+// String encodes the cursor as an opaque token
+func (c *ActivityCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Created.UnixNano(), 10) + "." + c.RunID.Hex()))
+}
+
+// ParseActivityCursor decodes a cursor token
+func ParseActivityCursor(token string) (*ActivityCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	nanos, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	runID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &ActivityCursor{Created: time.Unix(0, n).UTC(), RunID: runID}, nil
 }
 
 type AgentVersion struct {
@@ -68,30 +160,221 @@ type AgentVersion struct {
 	Cluster        string    `json:"cluster"`
 }
 
-// GetDashboardStats retrieves statistics for the dashboard
-func (r *UIRepository) GetDashboardStats() ([]StatsData, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
-	defer cancel()
+// DashboardFilter selects the runs the dashboard statistics are computed from
+type DashboardFilter struct {
+	// IncludeArchived includes the runs of archived agents, which are left out by default
+	IncludeArchived bool
+	// Cluster restricts the statistics to the runs of agent versions deployed to a cluster
+	Cluster string
+	// Project restricts the statistics to the runs of the agents of a project
+	Project string
+	// Team restricts the statistics to the runs of the agents owned by a team
+	Team string
+	// From and To set the time range of every statistic, compared to the preceding range of the same length. When
+	// unset, runs and cost cover today, the response time the last hour and active agents the last 48 hours.
+	From time.Time
+	To   time.Time
+	// Location is the reporting timezone the default day windows start at midnight in, the host's timezone when nil
+	Location *time.Location
+}
+
+// DashboardWindows holds the time ranges of the dashboard statistics
+type DashboardWindows struct {
+	ActiveAgents StatsWindow
+	Runs         StatsWindow
+	ResponseTime StatsWindow
+	Cost         StatsWindow
+	// Custom is set when the windows follow the range of the filter instead of the default windows
+	Custom bool
+}
+
+// Windows computes the time ranges of the dashboard statistics at the given time
+func (f DashboardFilter) Windows(now time.Time) DashboardWindows {
+	if !f.From.IsZero() && !f.To.IsZero() {
+		window := StatsWindow{
+			From:        f.From,
+			To:          f.To,
+			CompareFrom: f.From.Add(-f.To.Sub(f.From)),
+			CompareTo:   f.From,
+		}
+		return DashboardWindows{
+			ActiveAgents: window,
+			Runs:         window,
+			ResponseTime: window,
+			Cost:         window,
+			Custom:       true,
+		}
+	}
 
-	// Get current time for time-based calculations
-	now := time.Now()
+	if f.Location != nil {
+		now = now.In(f.Location)
+	}
 	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	yesterday := today.AddDate(0, 0, -1)
 	lastWeek := today.AddDate(0, 0, -7)
 	lastHour := now.Add(-1 * time.Hour)
 	last48Hours := now.Add(-48 * time.Hour)
 
-	// 1. Active Agents (agents with runs in the last 48 hours)
-	activeAgentsNow, err := r.getActiveAgentsCount(ctx, last48Hours)
+	day := StatsWindow{From: today, To: now, CompareFrom: yesterday, CompareTo: today}
+	return DashboardWindows{
+		// Agents active in the last 48 hours are compared to the agents active since a week ago
+		ActiveAgents: StatsWindow{From: last48Hours, To: now, CompareFrom: lastWeek, CompareTo: now},
+		Runs:         day,
+		ResponseTime: StatsWindow{From: lastHour, To: now, CompareFrom: lastHour.Add(-1 * time.Hour), CompareTo: lastHour},
+		Cost:         day,
+	}
+}
+
+// GetDashboardStats retrieves statistics for the dashboard, formatted for the given locale
+func (r *UIRepository) GetDashboardStats(ctx context.Context, locale *Locale, filter DashboardFilter) ([]StatsData, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	windows := filter.Windows(time.Now())
+
+	var counts DashboardCounts
+	scope := bson.M{}
+	agentScope := bson.M{}
+	if !filter.IncludeArchived {
+		archived, err := r.archivedAgentIDs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get archived agents: %w", err)
+		}
+		if len(archived) > 0 {
+			agentScope["$nin"] = archived
+		}
+	}
+	if filter.Project != "" || filter.Team != "" {
+		agentQuery := bson.M{}
+		if filter.Project != "" {
+			agentQuery["project"] = filter.Project
+		}
+		if filter.Team != "" {
+			agentQuery["team"] = filter.Team
+		}
+		agentIDs, err := r.agents.Distinct(ctx, "_id", agentQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the agents of the filter: %w", err)
+		}
+		agentScope["$in"] = agentIDs
+	}
+	if len(agentScope) > 0 {
+		scope["agent_id"] = agentScope
+	}
+	if filter.Cluster != "" {
+		versionIDs, err := r.versions.Distinct(ctx, "_id", bson.M{"cluster": filter.Cluster})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the versions of cluster %q: %w", filter.Cluster, err)
+		}
+		scope["version_id"] = bson.M{"$in": versionIDs}
+	}
+
+	var err error
+
+	// 1. Active Agents
+	w := windows.ActiveAgents
+	counts.ActiveAgentsNow, err = r.getActiveAgentsCount(ctx, scope, w.From, w.To)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get active agents count: %w", err)
 	}
 
-	activeAgentsLastWeek, err := r.getActiveAgentsCount(ctx, lastWeek)
+	counts.ActiveAgentsLastWeek, err = r.getActiveAgentsCount(ctx, scope, w.CompareFrom, w.CompareTo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the compared active agents count: %w", err)
+	}
+
+	// 2. Total Runs
+	w = windows.Runs
+	counts.RunsToday, err = r.getRunsCount(ctx, scope, w.From, w.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runs count: %w", err)
+	}
+
+	counts.RunsYesterday, err = r.getRunsCount(ctx, scope, w.CompareFrom, w.CompareTo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last week's active agents count: %w", err)
+		return nil, fmt.Errorf("failed to get the compared runs count: %w", err)
 	}
 
+	// 3. Average Response Time
+	w = windows.ResponseTime
+	counts.AvgResponseTimeNow, err = r.getAvgResponseTime(ctx, scope, w.From, w.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current average response time: %w", err)
+	}
+
+	counts.AvgResponseTimePrev, err = r.getAvgResponseTime(ctx, scope, w.CompareFrom, w.CompareTo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get previous average response time: %w", err)
+	}
+
+	// 4. Total Cost
+	w = windows.Cost
+	counts.CostToday, err = r.getTotalCost(ctx, scope, w.From, w.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get total cost: %w", err)
+	}
+
+	counts.CostYesterday, err = r.getTotalCost(ctx, scope, w.CompareFrom, w.CompareTo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the compared total cost: %w", err)
+	}
+
+	// 5. Budget, project budgets do not map to clusters or teams
+	if filter.Cluster == "" && filter.Team == "" {
+		counts.Budget, err = r.getBudgetTotals(ctx, filter.Project, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get budget totals: %w", err)
+		}
+	}
+
+	return BuildDashboardStats(locale, counts, windows), nil
+}
+
+// DashboardCounts holds the raw values the dashboard statistics are computed from, over their window and over the
+// window they are compared to. The field names refer to the default windows.
+type DashboardCounts struct {
+	ActiveAgentsNow      int
+	ActiveAgentsLastWeek int
+	RunsToday            int
+	RunsYesterday        int
+	AvgResponseTimeNow   float64
+	AvgResponseTimePrev  float64
+	CostToday            float64
+	CostYesterday        float64
+
+	// Budget sums the project budgets in scope over their current periods, nil when there are none
+	Budget *BudgetTotals
+}
+
+// BudgetTotals sums the amounts and the spend of budgets over their current periods
+type BudgetTotals struct {
+	Amount float64
+	Spend  float64
+	From   time.Time
+	To     time.Time
+}
+
+// Add adds the status of a budget to the totals, widening their window to its period
+func (t *BudgetTotals) Add(status *models.BudgetStatus) {
+	t.Amount += status.Amount
+	t.Spend += status.Spend
+	if t.From.IsZero() || status.PeriodStart.Before(t.From) {
+		t.From = status.PeriodStart
+	}
+	if status.PeriodEnd.After(t.To) {
+		t.To = status.PeriodEnd
+	}
+}
+
+// BuildDashboardStats computes the trends of the dashboard statistics over their windows and formats them for the
+// given locale
+func BuildDashboardStats(locale *Locale, counts DashboardCounts, windows DashboardWindows) []StatsData {
+	activeAgentsNow, activeAgentsLastWeek := counts.ActiveAgentsNow, counts.ActiveAgentsLastWeek
+	runsToday, runsYesterday := counts.RunsToday, counts.RunsYesterday
+	avgResponseTimeNow, avgResponseTimePrev := counts.AvgResponseTimeNow, counts.AvgResponseTimePrev
+	costToday, costYesterday := counts.CostToday, counts.CostYesterday
+
+	// 1. Active Agents (agents with runs in the last 48 hours)
 	activeAgentsDiff := activeAgentsNow - activeAgentsLastWeek
 	activeAgentsTrend := "up"
 	activeAgentsChangePrefix := "+"
@@ -104,16 +387,6 @@ func (r *UIRepository) GetDashboardStats() ([]StatsData, error) {
 	}
 
 	// 2. Total Runs Today
-	runsToday, err := r.getRunsCount(ctx, today, now)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get today's runs count: %w", err)
-	}
-
-	runsYesterday, err := r.getRunsCount(ctx, yesterday, today)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get yesterday's runs count: %w", err)
-	}
-
 	runsTrend := "up"
 	runsChangePrefix := "+"
 	runsPercentChange := 0.0
@@ -129,16 +402,6 @@ func (r *UIRepository) GetDashboardStats() ([]StatsData, error) {
 	}
 
 	// 3. Average Response Time
-	avgResponseTimeNow, err := r.getAvgResponseTime(ctx, lastHour, now)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current average response time: %w", err)
-	}
-
-	avgResponseTimePrev, err := r.getAvgResponseTime(ctx, lastHour.Add(-1*time.Hour), lastHour)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get previous average response time: %w", err)
-	}
-
 	responseTrend := "down" // Lower response time is better
 	responseChangePrefix := "-"
 	responseDiff := avgResponseTimePrev - avgResponseTimeNow
@@ -152,16 +415,6 @@ func (r *UIRepository) GetDashboardStats() ([]StatsData, error) {
 	}
 
 	// 4. Total Cost Today
-	costToday, err := r.getTotalCost(ctx, today, now)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get today's total cost: %w", err)
-	}
-
-	costYesterday, err := r.getTotalCost(ctx, yesterday, today)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get yesterday's total cost: %w", err)
-	}
-
 	costTrend := "up"
 	costChangePrefix := "+"
 	costPercentChange := 0.0
@@ -176,47 +429,233 @@ func (r *UIRepository) GetDashboardStats() ([]StatsData, error) {
 		costChangePrefix = ""
 	}
 
+	// Statistics over a requested range are compared to the preceding range
+	runsTitle, costTitle := "runs_today", "cost_today"
+	activeAgentsPeriod, dayPeriod, hourPeriod := "last_week", "yesterday", "last_hour"
+	activeAgentsPhrase, dayPhrase, hourPhrase := "from_last_week", "from_yesterday", "from_last_hour"
+	if windows.Custom {
+		runsTitle, costTitle = "runs", "total_cost"
+		activeAgentsPeriod, dayPeriod, hourPeriod = "previous_period", "previous_period", "previous_period"
+		activeAgentsPhrase, dayPhrase, hourPhrase = "from_previous_period", "from_previous_period", "from_previous_period"
+	}
+
 	// Format the stats data
 	stats := []StatsData{
 		{
-			Title:  "Active Agents",
-			Value:  fmt.Sprintf("%d", activeAgentsNow),
-			Change: fmt.Sprintf("%s%d from last week", activeAgentsChangePrefix, abs(activeAgentsDiff)),
+			Key:    "active_agents",
+			Title:  locale.Phrase("active_agents"),
+			Value:  locale.FormatInt(int64(activeAgentsNow)),
+			Change: locale.Phrase(activeAgentsPhrase, activeAgentsChangePrefix+locale.FormatInt(int64(abs(activeAgentsDiff)))),
 			Icon:   "Bot",
 			Trend:  activeAgentsTrend,
 			Raw:    float64(activeAgentsNow),
+			Unit:   "count",
+			Delta: StatsChange{
+				Value:     float64(activeAgentsDiff),
+				Unit:      "count",
+				Direction: activeAgentsTrend,
+				Period:    activeAgentsPeriod,
+			},
+			Window: windows.ActiveAgents,
 		},
 		{
-			Title:  "Total Runs Today",
-			Value:  formatNumber(runsToday),
-			Change: fmt.Sprintf("%s%d%% from yesterday", runsChangePrefix, abs(int(runsPercentChange))),
+			Key:    "runs_today",
+			Title:  locale.Phrase(runsTitle),
+			Value:  locale.FormatInt(int64(runsToday)),
+			Change: locale.Phrase(dayPhrase, runsChangePrefix+locale.FormatInt(int64(abs(int(runsPercentChange))))+"%"),
 			Icon:   "Activity",
 			Trend:  runsTrend,
 			Raw:    float64(runsToday),
+			Unit:   "count",
+			Delta: StatsChange{
+				Value:     runsPercentChange,
+				Unit:      "percent",
+				Direction: runsTrend,
+				Period:    dayPeriod,
+			},
+			Window: windows.Runs,
 		},
 		{
-			Title:  "Avg Response Time",
-			Value:  fmt.Sprintf("%.1fs", avgResponseTimeNow),
-			Change: fmt.Sprintf("%s%.1fs from last hour", responseChangePrefix, responseDiff),
+			Key:    "avg_response_time",
+			Title:  locale.Phrase("avg_response_time"),
+			Value:  locale.FormatDecimal(avgResponseTimeNow, 1) + "s",
+			Change: locale.Phrase(hourPhrase, responseChangePrefix+locale.FormatDecimal(responseDiff, 1)+"s"),
 			Icon:   "Clock",
 			Trend:  responseTrend,
 			Raw:    avgResponseTimeNow,
+			Unit:   "seconds",
+			Delta: StatsChange{
+				Value:     avgResponseTimeNow - avgResponseTimePrev,
+				Unit:      "seconds",
+				Direction: responseTrend,
+				Period:    hourPeriod,
+			},
+			Window: windows.ResponseTime,
 		},
 		{
-			Title:  "Total Cost Today",
-			Value:  fmt.Sprintf("$%.2f", costToday),
-			Change: fmt.Sprintf("%s%d%% from yesterday", costChangePrefix, abs(int(costPercentChange))),
-			Icon:   "DollarSign",
-			Trend:  costTrend,
-			Raw:    costToday,
+			Key:      "cost_today",
+			Title:    locale.Phrase(costTitle),
+			Value:    locale.FormatCurrency(costToday),
+			Change:   locale.Phrase(dayPhrase, costChangePrefix+locale.FormatInt(int64(abs(int(costPercentChange))))+"%"),
+			Icon:     locale.Currency().Icon,
+			Trend:    costTrend,
+			Raw:      costToday,
+			Unit:     "currency",
+			Currency: locale.Currency().Code,
+			Delta: StatsChange{
+				Value:     costPercentChange,
+				Unit:      "percent",
+				Direction: costTrend,
+				Period:    dayPeriod,
+			},
+			Window: windows.Cost,
 		},
 	}
 
-	return stats, nil
+	if budget := counts.Budget; budget != nil {
+		consumed := 0.0
+		if budget.Amount > 0 {
+			consumed = budget.Spend / budget.Amount * 100
+		}
+		budgetTrend := "neutral"
+		if budget.Spend > budget.Amount {
+			budgetTrend = "up"
+		}
+
+		stats = append(stats, StatsData{
+			Key:      "budget_remaining",
+			Title:    locale.Phrase("budget_remaining"),
+			Value:    locale.FormatCurrency(budget.Amount - budget.Spend),
+			Change:   locale.Phrase("budget_consumed", locale.FormatInt(int64(consumed))+"%"),
+			Icon:     "Wallet",
+			Trend:    budgetTrend,
+			Raw:      budget.Amount - budget.Spend,
+			Unit:     "currency",
+			Currency: locale.Currency().Code,
+			Delta: StatsChange{
+				Value:     consumed,
+				Unit:      "percent",
+				Direction: budgetTrend,
+				Period:    "budget_period",
+			},
+			Window: StatsWindow{From: budget.From, To: budget.To},
+		})
+	}
+
+	return stats
+}
+
+// getBudgetTotals sums the statuses of the project budgets in their current period, of a single project when given.
+// It returns nil when there are none.
+func (r *UIRepository) getBudgetTotals(ctx context.Context, project string, now time.Time) (*BudgetTotals, error) {
+	query := bson.M{
+		"project":    bson.M{"$exists": true},
+		"period_end": bson.M{"$gt": now},
+	}
+	if project != "" {
+		query["project"] = project
+	}
+
+	cursor, err := r.db.Collection("budget_status").Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var statuses []models.BudgetStatus
+	if err := cursor.All(ctx, &statuses); err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	totals := &BudgetTotals{}
+	for i := range statuses {
+		totals.Add(&statuses[i])
+	}
+	return totals, nil
+}
+
+// VersionMetricsFilter selects the agent versions whose aggregated metrics are listed
+type VersionMetricsFilter struct {
+	// Project restricts the versions to the agents of a project
+	Project string
+	// Team restricts the versions to the agents owned by a team
+	Team string
+	// Statuses restricts the versions to those with one of the statuses
+	Statuses []string
+	// Cluster restricts the versions to those deployed to a cluster
+	Cluster string
+	// Health restricts the versions to those with one of the health statuses
+	Health []string
+}
+
+// Matches reports whether the metrics of an agent version are selected by the filter
+func (f VersionMetricsFilter) Matches(m *models.AgentVersionMetrics) bool {
+	if f.Project != "" && m.Project != f.Project {
+		return false
+	}
+	if f.Team != "" && m.Team != f.Team {
+		return false
+	}
+	if f.Cluster != "" && m.Cluster != f.Cluster {
+		return false
+	}
+	return matchesAny(f.Statuses, m.Status) && matchesAny(f.Health, m.Health)
 }
 
-func (r *UIRepository) GetAgentVersions(ctx context.Context) ([]models.AgentVersionMetrics, error) {
-	cursor, err := r.db.Database.Collection("agent_version_metrics").Find(ctx, bson.M{})
+// matchesAny reports whether a value is one of the values of a filter, or the filter is empty
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// query builds the Mongo query of the filter
+func (f VersionMetricsFilter) query() bson.M {
+	query := bson.M{}
+	if f.Project != "" {
+		query["project"] = f.Project
+	}
+	if f.Team != "" {
+		query["team"] = f.Team
+	}
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+	if f.Cluster != "" {
+		query["cluster"] = f.Cluster
+	}
+	if len(f.Health) > 0 {
+		query["health"] = bson.M{"$in": f.Health}
+	}
+	return query
+}
+
+// GetAgentVersions retrieves the aggregated metrics of the agent versions selected by the filter, by agent name and
+// version unless another sort is requested
+func (r *UIRepository) GetAgentVersions(ctx context.Context, filter VersionMetricsFilter, listOpts ListOptions) ([]models.AgentVersionMetrics, error) {
+	// The document ID breaks ties so that pages do not overlap
+	sort := sortDocument(models.AgentVersionMetrics{}, listOpts.Sort, bson.D{{Key: "name", Value: 1}, {Key: "version", Value: 1}})
+	opts := options.Find().SetSort(append(sort, bson.E{Key: "_id", Value: 1}))
+	if proj := projection(models.AgentVersionMetrics{}, listOpts.Fields); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if listOpts.Limit > 0 {
+		opts.SetLimit(listOpts.Limit)
+	}
+	if listOpts.Offset > 0 {
+		opts.SetSkip(listOpts.Offset)
+	}
+
+	cursor, err := r.db.Collection("agent_version_metrics").Find(ctx, filter.query(), opts)
 	if err != nil {
 		return nil, err
 	}
@@ -230,13 +669,63 @@ func (r *UIRepository) GetAgentVersions(ctx context.Context) ([]models.AgentVers
 	return versions, nil
 }
 
-// getActiveAgentsCount returns the count of unique agents with runs since the given time
-func (r *UIRepository) getActiveAgentsCount(ctx context.Context, since time.Time) (int, error) {
+// CountAgentVersions counts the agent versions with aggregated metrics selected by the filter
+func (r *UIRepository) CountAgentVersions(ctx context.Context, filter VersionMetricsFilter) (int64, error) {
+	return r.db.Collection("agent_version_metrics").CountDocuments(ctx, filter.query())
+}
+
+// GetAgentVersionMetrics retrieves the aggregated metrics for a single agent version, over all time and the default environment
+func (r *UIRepository) GetAgentVersionMetrics(ctx context.Context, versionID primitive.ObjectID) (*models.AgentVersionMetrics, error) {
+	var metrics models.AgentVersionMetrics
+	err := r.db.cachedRead(ctx, metricsGeneration(versionID), "all", &metrics, func() error {
+		return r.db.Collection("agent_version_metrics").FindOne(ctx, bson.M{
+			"version_id":  versionID,
+			"window":      models.MetricsWindowAll,
+			"environment": models.MetricsEnvironmentDefault,
+		}).Decode(&metrics)
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("metrics not found for this version")
+		}
+		return nil, err
+	}
+
+	return &metrics, nil
+}
+
+// GetMetricsOfVersions retrieves the metrics of versions by version ID, without the versions with no metrics
+func (r *UIRepository) GetMetricsOfVersions(ctx context.Context, versionIDs []primitive.ObjectID) (map[primitive.ObjectID]*models.AgentVersionMetrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.db.Collection("agent_version_metrics").Find(ctx, bson.M{
+		"version_id":  bson.M{"$in": versionIDs},
+		"window":      models.MetricsWindowAll,
+		"environment": models.MetricsEnvironmentDefault,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var metrics []models.AgentVersionMetrics
+	if err := cursor.All(ctx, &metrics); err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[primitive.ObjectID]*models.AgentVersionMetrics, len(metrics))
+	for i := range metrics {
+		byVersion[metrics[i].Id] = &metrics[i]
+	}
+	return byVersion, nil
+}
+
+// getActiveAgentsCount returns the count of unique agents with runs between the given time range
+func (r *UIRepository) getActiveAgentsCount(ctx context.Context, scope bson.M, start, end time.Time) (int, error) {
 	pipeline := mongo.Pipeline{
 		{
-			{"$match", bson.M{
-				"created": bson.M{"$gte": since},
-			}},
+			{"$match", runMatch(scope, bson.M{"$gte": start, "$lt": end})},
 		},
 		{
 			{"$group", bson.M{
@@ -267,13 +756,11 @@ func (r *UIRepository) getActiveAgentsCount(ctx context.Context, since time.Time
 }
 
 // getRunsCount returns the count of runs between the given time range
-func (r *UIRepository) getRunsCount(ctx context.Context, start, end time.Time) (int, error) {
-	count, err := r.runs.CountDocuments(ctx, bson.M{
-		"created": bson.M{
-			"$gte": start,
-			"$lt":  end,
-		},
-	})
+func (r *UIRepository) getRunsCount(ctx context.Context, scope bson.M, start, end time.Time) (int, error) {
+	count, err := r.runs.CountDocuments(ctx, runMatch(scope, bson.M{
+		"$gte": start,
+		"$lt":  end,
+	}))
 	if err != nil {
 		return 0, err
 	}
@@ -282,35 +769,18 @@ func (r *UIRepository) getRunsCount(ctx context.Context, start, end time.Time) (
 }
 
 // getAvgResponseTime calculates the average response time for runs in the given time range
-func (r *UIRepository) getAvgResponseTime(ctx context.Context, start, end time.Time) (float64, error) {
-	// We need to convert time_taken string to seconds for calculation
-	// For simplicity, we'll assume time_taken is stored in seconds as a string
+func (r *UIRepository) getAvgResponseTime(ctx context.Context, scope bson.M, start, end time.Time) (float64, error) {
 	pipeline := mongo.Pipeline{
 		{
-			{"$match", bson.M{
-				"created": bson.M{
-					"$gte": start,
-					"$lt":  end,
-				},
-			}},
-		},
-		{
-			{"$addFields", bson.M{
-				"time_taken_seconds": bson.M{
-					"$toDouble": bson.M{
-						"$replaceAll": bson.M{
-							"input":       "$time_taken",
-							"find":        "s",
-							"replacement": "",
-						},
-					},
-				},
-			}},
+			{"$match", runMatch(scope, bson.M{
+				"$gte": start,
+				"$lt":  end,
+			})},
 		},
 		{
 			{"$group", bson.M{
 				"_id":   nil,
-				"avg":   bson.M{"$avg": "$time_taken_seconds"},
+				"avg":   bson.M{"$avg": "$time_taken"},
 				"count": bson.M{"$sum": 1},
 			}},
 		},
@@ -331,19 +801,19 @@ func (r *UIRepository) getAvgResponseTime(ctx context.Context, start, end time.T
 		return 0, nil
 	}
 
-	return results[0]["avg"].(float64), nil
+	// The average is null when no run has a numeric time taken
+	avg, _ := results[0]["avg"].(float64)
+	return avg, nil
 }
 
 // getTotalCost calculates the total cost for runs in the given time range
-func (r *UIRepository) getTotalCost(ctx context.Context, start, end time.Time) (float64, error) {
+func (r *UIRepository) getTotalCost(ctx context.Context, scope bson.M, start, end time.Time) (float64, error) {
 	pipeline := mongo.Pipeline{
 		{
-			{"$match", bson.M{
-				"created": bson.M{
-					"$gte": start,
-					"$lt":  end,
-				},
-			}},
+			{"$match", runMatch(scope, bson.M{
+				"$gte": start,
+				"$lt":  end,
+			})},
 		},
 		{
 			{"$group", bson.M{
@@ -371,20 +841,40 @@ func (r *UIRepository) getTotalCost(ctx context.Context, start, end time.Time) (
 	return results[0]["total"].(float64), nil
 }
 
-// GetRecentActivity retrieves the 10 most recent agent runs
-func (r *UIRepository) GetRecentActivity() ([]ActivityData, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeoutSec)*time.Second)
+// GetRecentActivity retrieves the most recent agent runs selected by a filter, newest first. The returned cursor
+// points after the last item when more runs match, and is nil otherwise.
+func (r *UIRepository) GetRecentActivity(ctx context.Context, filter ActivityFilter) ([]ActivityData, *ActivityCursor, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
 	defer cancel()
 
-	// Create a pipeline to get the 10 most recent runs with agent names
+	match := bson.M{}
+	if filter.AgentID != nil {
+		match["agent_id"] = *filter.AgentID
+	}
+	if len(filter.Statuses) > 0 {
+		match["status"] = bson.M{"$in": filter.Statuses}
+	}
+	if filter.After != nil {
+		match["$or"] = bson.A{
+			bson.M{"created": bson.M{"$lt": filter.After.Created}},
+			bson.M{"created": filter.After.Created, "_id": bson.M{"$lt": filter.After.RunID}},
+		}
+	}
+
+	// Create a pipeline to get the most recent runs with agent names, fetching one more run than the limit to know
+	// whether there is a next page
 	pipeline := mongo.Pipeline{
 		{
-			{"$sort", bson.M{
-				"created": -1,
+			{"$match", match},
+		},
+		{
+			{"$sort", bson.D{
+				{"created", -1},
+				{"_id", -1},
 			}},
 		},
 		{
-			{"$limit", 10},
+			{"$limit", filter.Limit + 1},
 		},
 		{
 			{"$lookup", bson.M{
@@ -395,13 +885,14 @@ func (r *UIRepository) GetRecentActivity() ([]ActivityData, error) {
 			}},
 		},
 		{
-			{"$unwind", "$agent_info"},
+			{"$unwind", bson.M{"path": "$agent_info", "preserveNullAndEmptyArrays": true}},
 		},
 		{
 			{"$project", bson.M{
-				"_id":        0,
+				"_id":        1,
 				"id":         "$run_id",
-				"agent_name": "$agent_info.name",
+				"agent_id":   1,
+				"agent_name": bson.M{"$ifNull": bson.A{"$agent_info.name", ""}},
 				"status":     1,
 				"created":    1,
 				"time_taken": 1,
@@ -412,26 +903,24 @@ func (r *UIRepository) GetRecentActivity() ([]ActivityData, error) {
 
 	cursor, err := r.runs.Aggregate(ctx, pipeline)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query recent activity: %w", err)
+		return nil, nil, fmt.Errorf("failed to query recent activity: %w", err)
 	}
 	defer cursor.Close(ctx)
 
 	var results []bson.M
 	if err = cursor.All(ctx, &results); err != nil {
-		return nil, fmt.Errorf("failed to decode recent activity: %w", err)
+		return nil, nil, fmt.Errorf("failed to decode recent activity: %w", err)
+	}
+
+	hasMore := int64(len(results)) > filter.Limit
+	if hasMore {
+		results = results[:filter.Limit]
 	}
 
 	activities := make([]ActivityData, 0, len(results))
 	for _, result := range results {
 		// Determine action based on status
-		action := "completed run"
-		if result["status"].(string) == "error" {
-			action = "failed run"
-		} else if result["status"].(string) == "timeout" {
-			action = "timed out"
-		} else if result["status"].(string) == "running" {
-			action = "started run"
-		}
+		action := activityAction(result["status"].(string))
 
 		// Convert MongoDB primitive.DateTime to time.Time
 		var createdTime time.Time
@@ -446,6 +935,7 @@ func (r *UIRepository) GetRecentActivity() ([]ActivityData, error) {
 
 		activity := ActivityData{
 			ID:       result["id"].(int64),
+			AgentID:  result["agent_id"].(primitive.ObjectID),
 			Agent:    result["agent_name"].(string),
 			Action:   action,
 			Status:   result["status"].(string),
@@ -457,11 +947,421 @@ func (r *UIRepository) GetRecentActivity() ([]ActivityData, error) {
 		activities = append(activities, activity)
 	}
 
-	return activities, nil
+	var next *ActivityCursor
+	if hasMore {
+		last := results[len(results)-1]
+		next = &ActivityCursor{Created: activities[len(activities)-1].Time, RunID: last["_id"].(primitive.ObjectID)}
+	}
+
+	return activities, next, nil
+}
+
+// GetModelStats computes the usage statistics of the models of the finished runs of all agents created in [from, to),
+// most used first
+func (r *UIRepository) GetModelStats(ctx context.Context, from, to time.Time) ([]models.UsageStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	return aggregateUsageStats(ctx, r.runs, bson.M{
+		"created": bson.M{"$gte": from, "$lt": to},
+		"status":  bson.M{"$ne": models.RunStatusRunning},
+	}, UsageFieldModels)
+}
+
+// GetClusterStats summarizes the runs created in [from, to) by the cluster of their agent version, busiest
+// clusters first
+func (r *UIRepository) GetClusterStats(ctx context.Context, from, to time.Time) ([]models.ClusterStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	// Runs are grouped by version before looking up the cluster, so that the lookup runs once per version
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"created": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id":      "$version_id",
+			"agent_id": bson.M{"$first": "$agent_id"},
+			"runs":     bson.M{"$sum": 1},
+			"finished": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ne": bson.A{"$status", models.RunStatusRunning}}, 1, 0}}},
+			"failed": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$status", bson.A{models.RunStatusCompleted, models.RunStatusRunning}}}}}, 1, 0,
+			}}},
+			"cost": bson.M{"$sum": "$cost"},
+		}},
+		{"$lookup": bson.M{
+			"from":         "agent_versions",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "version",
+		}},
+		{"$unwind": bson.M{"path": "$version", "preserveNullAndEmptyArrays": true}},
+		{"$group": bson.M{
+			"_id":      bson.M{"$ifNull": bson.A{"$version.cluster", ""}},
+			"agents":   bson.M{"$addToSet": "$agent_id"},
+			"versions": bson.M{"$sum": 1},
+			"runs":     bson.M{"$sum": "$runs"},
+			"finished": bson.M{"$sum": "$finished"},
+			"failed":   bson.M{"$sum": "$failed"},
+			"cost":     bson.M{"$sum": "$cost"},
+		}},
+		{"$addFields": bson.M{"agents": bson.M{"$size": "$agents"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var accumulators []ClusterAccumulator
+	if err := cursor.All(ctx, &accumulators); err != nil {
+		return nil, err
+	}
+
+	return ClusterStatsOf(accumulators), nil
+}
+
+// GetHeatmapCells aggregates the runs created in [from, to) by day of the week and hour of the day, in UTC
+func (r *UIRepository) GetHeatmapCells(ctx context.Context, from, to time.Time) ([]HeatmapCell, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"created": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			// $dayOfWeek starts at 1 on Sunday
+			"_id": bson.M{
+				"weekday": bson.M{"$subtract": bson.A{bson.M{"$dayOfWeek": "$created"}, 1}},
+				"hour":    bson.M{"$hour": "$created"},
+			},
+			"runs": bson.M{"$sum": 1},
+			"cost": bson.M{"$sum": "$cost"},
+		}},
+		{"$project": bson.M{
+			"_id":     0,
+			"weekday": "$_id.weekday",
+			"hour":    "$_id.hour",
+			"runs":    1,
+			"cost":    1,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	cells := []HeatmapCell{}
+	if err := cursor.All(ctx, &cells); err != nil {
+		return nil, err
+	}
+
+	return cells, nil
+}
+
+// GetTimeBuckets aggregates the runs of all agents created in [from, to) into buckets of the given duration. The
+// part of the range rolled up is read from the rollups.
+func (r *UIRepository) GetTimeBuckets(ctx context.Context, bucket time.Duration, from, to time.Time) ([]TimeBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	return rolledUpTimeBuckets(ctx, r.runs, r.rollups, r.rollupState, bson.M{}, bucket, from, to)
+}
+
+// GetLeaderboard ranks the agents, or the agent versions when group is version, by a metric of their runs created
+// in [from, to) and returns the first limit entries. Errors are the finished runs that did not complete.
+func (r *UIRepository) GetLeaderboard(ctx context.Context, by, group string, from, to time.Time, limit int) ([]models.LeaderboardEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	key := bson.M{"agent_id": "$agent_id"}
+	if group == models.LeaderboardByVersion {
+		key["version"] = "$version"
+	}
+
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"created": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id":  key,
+			"runs": bson.M{"$sum": 1},
+			"errors": bson.M{"$sum": bson.M{"$cond": bson.A{
+				bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$status", bson.A{models.RunStatusCompleted, models.RunStatusRunning}}}}}, 1, 0,
+			}}},
+			"total_cost": bson.M{"$sum": "$cost"},
+		}},
+		{"$sort": bson.D{{Key: leaderboardFields[by], Value: -1}, {Key: "_id", Value: 1}}},
+		{"$limit": limit},
+		{"$lookup": bson.M{
+			"from":         "agents",
+			"localField":   "_id.agent_id",
+			"foreignField": "_id",
+			"as":           "agent",
+		}},
+		{"$unwind": bson.M{"path": "$agent", "preserveNullAndEmptyArrays": true}},
+		{"$project": bson.M{
+			"_id":        0,
+			"agent_id":   "$_id.agent_id",
+			"agent_name": bson.M{"$ifNull": bson.A{"$agent.name", ""}},
+			"version":    "$_id.version",
+			"runs":       1,
+			"errors":     1,
+			"total_cost": 1,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.LeaderboardEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	// Ties are ranked by agent name, which is only known after the lookup
+	return RankLeaderboard(entries, by, limit), nil
+}
+
+// GetErrorCounts counts the failed runs created in [from, to) by agent and error category
+func (r *UIRepository) GetErrorCounts(ctx context.Context, from, to time.Time) ([]ErrorCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"created": bson.M{"$gte": from, "$lt": to},
+			"status":  bson.M{"$nin": bson.A{models.RunStatusCompleted, models.RunStatusRunning}},
+		}},
+		{"$group": bson.M{
+			"_id":   bson.M{"agent_id": "$agent_id", "category": errorCategoryExpr},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$lookup": bson.M{
+			"from":         "agents",
+			"localField":   "_id.agent_id",
+			"foreignField": "_id",
+			"as":           "agent",
+		}},
+		{"$unwind": bson.M{"path": "$agent", "preserveNullAndEmptyArrays": true}},
+		{"$project": bson.M{
+			"_id":        0,
+			"agent_id":   "$_id.agent_id",
+			"agent_name": bson.M{"$ifNull": bson.A{"$agent.name", ""}},
+			"category":   "$_id.category",
+			"count":      1,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []ErrorCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// GetCostBreakdown sums the cost of the runs created in [from, to) by agent, project, team or model. The cost of a
+// run using several models is split evenly between them.
+func (r *UIRepository) GetCostBreakdown(ctx context.Context, groupBy string, from, to time.Time) ([]models.CostBreakdownItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"created": bson.M{"$gte": from, "$lt": to}}},
+	}
+	lookupAgents := []bson.M{
+		{"$lookup": bson.M{
+			"from":         "agents",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "agent",
+		}},
+		{"$unwind": bson.M{"path": "$agent", "preserveNullAndEmptyArrays": true}},
+	}
+	switch groupBy {
+	case models.CostGroupAgent:
+		pipeline = append(pipeline, bson.M{"$group": bson.M{
+			"_id":  "$agent_id",
+			"runs": bson.M{"$sum": 1},
+			"cost": bson.M{"$sum": "$cost"},
+		}})
+		pipeline = append(pipeline, lookupAgents...)
+		pipeline = append(pipeline, bson.M{"$project": bson.M{
+			"_id":      0,
+			"agent_id": "$_id",
+			"name":     bson.M{"$ifNull": bson.A{"$agent.name", ""}},
+			"runs":     1,
+			"cost":     1,
+		}})
+	case models.CostGroupProject, models.CostGroupTeam:
+		// Runs are grouped by agent before looking up the project or team, so that the lookup runs once per agent
+		pipeline = append(pipeline, bson.M{"$group": bson.M{
+			"_id":  "$agent_id",
+			"runs": bson.M{"$sum": 1},
+			"cost": bson.M{"$sum": "$cost"},
+		}})
+		pipeline = append(pipeline, lookupAgents...)
+		pipeline = append(pipeline,
+			bson.M{"$group": bson.M{
+				"_id":  bson.M{"$ifNull": bson.A{"$agent." + groupBy, ""}},
+				"runs": bson.M{"$sum": "$runs"},
+				"cost": bson.M{"$sum": "$cost"},
+			}},
+			bson.M{"$project": bson.M{"_id": 0, "name": "$_id", "runs": 1, "cost": 1}},
+		)
+	case models.CostGroupModel:
+		// Runs without models are attributed to an empty model name, see CostShares
+		runModels := bson.M{"$ifNull": bson.A{"$models", bson.A{}}}
+		pipeline = append(pipeline,
+			bson.M{"$project": bson.M{
+				"models": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$size": runModels}, 0}}, bson.A{""}, runModels}},
+				"share":  bson.M{"$divide": bson.A{"$cost", bson.M{"$max": bson.A{bson.M{"$size": runModels}, 1}}}},
+			}},
+			bson.M{"$unwind": "$models"},
+			bson.M{"$group": bson.M{
+				"_id":  "$models",
+				"runs": bson.M{"$sum": 1},
+				"cost": bson.M{"$sum": "$share"},
+			}},
+			bson.M{"$project": bson.M{"_id": 0, "name": "$_id", "runs": 1, "cost": 1}},
+		)
+	default:
+		return nil, fmt.Errorf("unsupported cost grouping %q", groupBy)
+	}
+
+	cursor, err := r.runs.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := []models.CostBreakdownItem{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetTokenUsage sums the tokens and cost of the runs created in [from, to) by day, in the given timezone, agent and
+// model. The tokens and cost of a run using several models are split evenly between them.
+func (r *UIRepository) GetTokenUsage(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.TokenUsageItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Aggregate)
+	defer cancel()
+
+	// Runs without models are attributed to an empty model name, see CostShares
+	runModels := bson.M{"$ifNull": bson.A{"$models", bson.A{}}}
+	modelCount := bson.M{"$max": bson.A{bson.M{"$size": runModels}, 1}}
+	share := func(field string) bson.M {
+		return bson.M{"$divide": bson.A{bson.M{"$ifNull": bson.A{field, 0}}, modelCount}}
+	}
+	cursor, err := r.runs.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"created": bson.M{"$gte": from, "$lt": to}}},
+		{"$project": bson.M{
+			"day": bson.M{"$dateToString": bson.M{
+				"format":   "%Y-%m-%d",
+				"date":     "$created",
+				"timezone": mongoTimezone(loc, to),
+			}},
+			"agent_id":          1,
+			"models":            bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{bson.M{"$size": runModels}, 0}}, bson.A{""}, runModels}},
+			"prompt_tokens":     share("$prompt_tokens"),
+			"completion_tokens": share("$completion_tokens"),
+			"cost":              share("$cost"),
+		}},
+		{"$unwind": "$models"},
+		{"$group": bson.M{
+			"_id":               bson.M{"day": "$day", "agent_id": "$agent_id", "model": "$models"},
+			"runs":              bson.M{"$sum": 1},
+			"prompt_tokens":     bson.M{"$sum": "$prompt_tokens"},
+			"completion_tokens": bson.M{"$sum": "$completion_tokens"},
+			"cost":              bson.M{"$sum": "$cost"},
+		}},
+		{"$lookup": bson.M{
+			"from":         "agents",
+			"localField":   "_id.agent_id",
+			"foreignField": "_id",
+			"as":           "agent",
+		}},
+		{"$unwind": bson.M{"path": "$agent", "preserveNullAndEmptyArrays": true}},
+		{"$project": bson.M{
+			"_id":               0,
+			"day":               "$_id.day",
+			"agent_id":          "$_id.agent_id",
+			"agent":             bson.M{"$ifNull": bson.A{"$agent.name", ""}},
+			"model":             "$_id.model",
+			"runs":              1,
+			"prompt_tokens":     1,
+			"completion_tokens": 1,
+			"cost":              1,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := []models.TokenUsageItem{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// archivedAgentIDs returns the IDs of the archived agents
+func (r *UIRepository) archivedAgentIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	ids, err := r.agents.Distinct(ctx, "_id", bson.M{"archived": true})
+	if err != nil {
+		return nil, err
+	}
+
+	archived := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			archived = append(archived, oid)
+		}
+	}
+	return archived, nil
+}
+
+// runMatch builds the query matching the runs of a scope created within a time range
+func runMatch(scope bson.M, created bson.M) bson.M {
+	match := bson.M{"created": created}
+	for key, value := range scope {
+		match[key] = value
+	}
+	return match
+}
+
+// NewActivityData builds the activity item of a run
+func NewActivityData(run *models.AgentRun, agentName string) ActivityData {
+	return ActivityData{
+		ID:       run.RunID,
+		AgentID:  run.AgentID,
+		Agent:    agentName,
+		Action:   activityAction(run.Status),
+		Status:   run.Status,
+		Time:     run.Created,
+		Duration: run.TimeTaken,
+		Cost:     run.Cost,
+	}
 }
 
 // Helper functions
 
+// activityAction describes the action of a run based on its status
+func activityAction(status string) string {
+	switch status {
+	case "error":
+		return "failed run"
+	case "timeout":
+		return "timed out"
+	case "running":
+		return "started run"
+	}
+	return "completed run"
+}
+
 // abs returns the absolute value of an integer
 func abs(n int) int {
 	if n < 0 {
@@ -469,11 +1369,3 @@ func abs(n int) int {
 	}
 	return n
 }
-
-// formatNumber formats a number with commas as thousands separators
-func formatNumber(n int) string {
-	if n < 1000 {
-		return fmt.Sprintf("%d", n)
-	}
-	return fmt.Sprintf("%d,%03d", n/1000, n%1000)
-}
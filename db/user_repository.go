@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserRepository handles database operations for the users signing in with a password
+type UserRepository struct {
+	db       *MongoDB
+	users    collection
+	timeouts Timeouts
+}
+
+// NewUserRepository creates a new user repository
+func NewUserRepository(db *MongoDB) *UserRepository {
+	return &UserRepository{
+		db:       db,
+		users:    db.Collection("users"),
+		timeouts: db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the unique index users sign in by, their email, across organizations
+func (r *UserRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// CreateUser creates a new user. Emails are stored lower cased.
+func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	user.Email = strings.ToLower(user.Email)
+	user.CreatedAt = time.Now()
+	result, err := r.users.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("user already exists")
+		}
+		return err
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetUser retrieves a user by ID
+func (r *UserRepository) GetUser(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.findUser(ctx, bson.M{"_id": id})
+}
+
+// GetUserByEmail retrieves a user by email, ignoring case
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findUser(ctx, bson.M{"email": strings.ToLower(email)})
+}
+
+// ListUsers retrieves all users, sorted by email
+func (r *UserRepository) ListUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.users.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "email", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// DeleteUser deletes a user
+func (r *UserRepository) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.users.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// UpdateUser sets the fields of update on a user
+func (r *UserRepository) UpdateUser(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": update})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+// UseTOTPStep records that a user signed in with the TOTP code of a time step, reporting false when a code of that
+// step or a later one was already used, so that a code can not be replayed
+func (r *UserRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.users.UpdateOne(ctx, bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+		},
+	}, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// findUser retrieves the user matching a filter
+func (r *UserRepository) findUser(ctx context.Context, filter bson.M) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var user models.User
+	err := r.users.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
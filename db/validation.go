@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Schema validation modes, see EnsureValidators
+const (
+	// ValidationOff leaves the validators of the collections unchanged
+	ValidationOff = "off"
+	// ValidationWarn lets MongoDB store the documents failing validation, logging a warning
+	ValidationWarn = "warn"
+	// ValidationError makes MongoDB reject the documents failing validation
+	ValidationError = "error"
+)
+
+// documentValidationFailureCode is the MongoDB error code of a write of a document failing the validator of its
+// collection
+const documentValidationFailureCode = 121
+
+// errInvalidRun is the failure of a run rejected by the validator of the runs collection
+var errInvalidRun = errors.New("run failed the schema validation of the database")
+
+// numeric are the BSON types of numbers
+var numeric = []string{"double", "int", "long", "decimal"}
+
+// validators are the $jsonSchema validators of the collections, matching the documents the repositories write.
+// Lists are null when their Go value is nil.
+var validators = map[string]bson.M{
+	"agents": {
+		"bsonType": "object",
+		"required": []string{"name", "created_at"},
+		"properties": bson.M{
+			"org_id":     bson.M{"bsonType": "objectId"},
+			"name":       bson.M{"bsonType": "string", "minLength": 1},
+			"project":    bson.M{"bsonType": "string"},
+			"team":       bson.M{"bsonType": "string"},
+			"archived":   bson.M{"bsonType": "bool"},
+			"created_at": bson.M{"bsonType": "date"},
+			"updated_at": bson.M{"bsonType": "date"},
+		},
+	},
+	"agent_versions": {
+		"bsonType": "object",
+		"required": []string{"agent_id", "version", "created_at"},
+		"properties": bson.M{
+			"org_id":     bson.M{"bsonType": "objectId"},
+			"agent_id":   bson.M{"bsonType": "objectId"},
+			"version":    bson.M{"bsonType": "string", "minLength": 1},
+			"cluster":    bson.M{"bsonType": "string"},
+			"status":     bson.M{"bsonType": "string"},
+			"tools":      bson.M{"bsonType": []string{"array", "null"}, "items": bson.M{"bsonType": "string"}},
+			"models":     bson.M{"bsonType": []string{"array", "null"}, "items": bson.M{"bsonType": "string"}},
+			"deployment": bson.M{"bsonType": "string"},
+			"created_at": bson.M{"bsonType": "date"},
+			"updated_at": bson.M{"bsonType": "date"},
+		},
+	},
+	"agent_runs": {
+		"bsonType": "object",
+		"required": []string{"agent_id", "version_id", "created", "time_taken"},
+		"properties": bson.M{
+			"org_id":            bson.M{"bsonType": "objectId"},
+			"agent_id":          bson.M{"bsonType": "objectId"},
+			"version_id":        bson.M{"bsonType": "objectId"},
+			"version":           bson.M{"bsonType": "string"},
+			"created":           bson.M{"bsonType": "date"},
+			"status":            bson.M{"bsonType": "string"},
+			"time_taken":        bson.M{"bsonType": numeric, "minimum": 0},
+			"initiator":         bson.M{"bsonType": "string"},
+			"tools":             bson.M{"bsonType": []string{"array", "null"}, "items": bson.M{"bsonType": "string"}},
+			"models":            bson.M{"bsonType": []string{"array", "null"}, "items": bson.M{"bsonType": "string"}},
+			"cost":              bson.M{"bsonType": numeric},
+			"run_id":            bson.M{"bsonType": numeric},
+			"task_id":           bson.M{"bsonType": numeric},
+			"recorded_at":       bson.M{"bsonType": "date"},
+			"prompt_tokens":     bson.M{"bsonType": numeric},
+			"completion_tokens": bson.M{"bsonType": numeric},
+		},
+	},
+}
+
+// EnsureValidators sets the $jsonSchema validators of the agents, versions and runs collections, creating the
+// collections when missing, so that MongoDB rejects malformed documents, such as a run with a string time_taken,
+// or warns about them in its log, depending on the mode. Validation is moderate: updates of documents that are
+// already invalid are not validated. The runs of a time-series collection are not validated.
+func (r *AgentRepository) EnsureValidators(ctx context.Context, mode string) error {
+	if mode == ValidationOff {
+		return nil
+	}
+	if mode != ValidationWarn && mode != ValidationError {
+		return fmt.Errorf("invalid validation mode %q", mode)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	database := r.db.Database()
+	existing, err := database.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	for _, name := range []string{"agents", "agent_versions", "agent_runs"} {
+		if name == "agent_runs" && r.db.timeSeriesRuns {
+			continue
+		}
+		command := bson.D{
+			{Key: "collMod", Value: name},
+			{Key: "validator", Value: bson.M{"$jsonSchema": validators[name]}},
+			{Key: "validationLevel", Value: "moderate"},
+			{Key: "validationAction", Value: mode},
+		}
+		if !exists[name] {
+			command[0].Key = "create"
+		}
+		if err := database.RunCommand(ctx, command).Err(); err != nil {
+			return fmt.Errorf("unable to set the validator of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// isValidationFailure reports whether a write failed on the validator of its collection
+func isValidationFailure(err error) bool {
+	var writeErr mongo.WriteException
+	return errors.As(err, &writeErr) && writeErr.HasErrorCode(documentValidationFailureCode)
+}
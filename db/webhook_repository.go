@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookRepository handles database operations for webhooks, their delivery logs and the queue of pending
+// deliveries
+type WebhookRepository struct {
+	db         *MongoDB
+	webhooks   collection
+	deliveries collection
+	queue      collection
+	timeouts   Timeouts
+}
+
+// NewWebhookRepository creates a new webhook repository
+func NewWebhookRepository(db *MongoDB) *WebhookRepository {
+	return &WebhookRepository{
+		db:         db,
+		webhooks:   db.Collection("webhooks"),
+		deliveries: db.Collection("webhook_deliveries"),
+		queue:      db.Collection("webhook_queue"),
+		timeouts:   db.Timeouts(),
+	}
+}
+
+// EnsureIndexes creates the index the pending deliveries due are claimed with
+func (r *WebhookRepository) EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.queue.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "next_attempt_at", Value: 1}},
+	})
+	return err
+}
+
+// CreateWebhook creates a new webhook
+func (r *WebhookRepository) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+
+	result, err := r.webhooks.InsertOne(ctx, webhook)
+	if err != nil {
+		return err
+	}
+
+	webhook.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetWebhook retrieves a webhook by ID
+func (r *WebhookRepository) GetWebhook(ctx context.Context, id primitive.ObjectID) (*models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	var webhook models.Webhook
+	err := r.webhooks.FindOne(ctx, bson.M{"_id": id}).Decode(&webhook)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("webhook not found")
+		}
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// ListWebhooks retrieves all webhooks
+func (r *WebhookRepository) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return r.findWebhooks(ctx, bson.M{})
+}
+
+// ListWebhooksForEvent retrieves the active webhooks subscribed to an event type
+func (r *WebhookRepository) ListWebhooksForEvent(ctx context.Context, eventType string) ([]models.Webhook, error) {
+	return r.findWebhooks(ctx, bson.M{"active": true, "events": eventType})
+}
+
+// findWebhooks retrieves the webhooks matching a filter, oldest first
+func (r *WebhookRepository) findWebhooks(ctx context.Context, filter bson.M) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	cursor, err := r.webhooks.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	webhooks := []models.Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// UpdateWebhook replaces the URL, events, secret, description and active flag of a webhook
+func (r *WebhookRepository) UpdateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	webhook.UpdatedAt = time.Now()
+	result, err := r.webhooks.UpdateOne(ctx, bson.M{"_id": webhook.ID}, bson.M{
+		"$set": bson.M{
+			"url":         webhook.URL,
+			"events":      webhook.Events,
+			"secret":      webhook.Secret,
+			"description": webhook.Description,
+			"active":      webhook.Active,
+			"updated_at":  webhook.UpdatedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("webhook not found")
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes a webhook and its delivery logs
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.webhooks.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("webhook not found")
+	}
+
+	_, err = r.deliveries.DeleteMany(ctx, bson.M{"webhook_id": id})
+	return err
+}
+
+// RecordDelivery stores a delivery attempt
+func (r *WebhookRepository) RecordDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	result, err := r.deliveries.InsertOne(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	delivery.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListDeliveries retrieves the most recent delivery attempts of a webhook
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, webhookID primitive.ObjectID, limit int64) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "attempted_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	cursor, err := r.deliveries.Find(ctx, bson.M{"webhook_id": webhookID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// QueueDeliveries queues deliveries, due at once
+func (r *WebhookRepository) QueueDeliveries(ctx context.Context, pending []models.PendingDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	docs := make([]interface{}, len(pending))
+	now := time.Now()
+	for i := range pending {
+		pending[i].NextAttemptAt = now
+		docs[i] = pending[i]
+	}
+	_, err := r.queue.InsertMany(ctx, docs)
+	return err
+}
+
+// ClaimDelivery claims the pending delivery due the earliest, or returns nil when none is due. It is not claimed again
+// before lease has passed, after which a delivery abandoned by a stopped server is attempted again.
+func (r *WebhookRepository) ClaimDelivery(ctx context.Context, lease time.Duration) (*models.PendingDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}})
+	var pending models.PendingDelivery
+	err := r.queue.FindOneAndUpdate(ctx,
+		bson.M{"next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+		opts,
+	).Decode(&pending)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// RetryDelivery records the attempts of a pending delivery, which is due again at next
+func (r *WebhookRepository) RetryDelivery(ctx context.Context, id primitive.ObjectID, attempts int, next time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.queue.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"attempts": attempts, "next_attempt_at": next}})
+	return err
+}
+
+// RemoveDelivery removes a pending delivery once it succeeded or was given up
+func (r *WebhookRepository) RemoveDelivery(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	_, err := r.queue.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
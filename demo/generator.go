@@ -0,0 +1,231 @@
+package demo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"ripple/models"
+)
+
+// backfillDays is the number of days of history generated when the demo starts
+const backfillDays = 8
+
+// demoAgent describes a synthetic agent
+type demoAgent struct {
+	name     string
+	project  string
+	tools    []string
+	models   []string
+	versions []string
+	// meanSeconds is the typical duration of a run
+	meanSeconds float64
+	// weight is the relative share of the generated runs
+	weight int
+}
+
+var demoAgents = []demoAgent{
+	{"support-triage", "customer-support", []string{"zendesk_search", "kb_lookup", "escalate"}, []string{"gpt-4o-mini", "claude-3-haiku"}, []string{"1.4.0", "1.5.0", "1.5.1"}, 4, 8},
+	{"code-reviewer", "developer-tools", []string{"git_diff", "lint", "post_comment"}, []string{"claude-3-5-sonnet"}, []string{"0.9.2", "1.0.0"}, 25, 4},
+	{"invoice-extractor", "finance-ops", []string{"ocr", "ledger_write"}, []string{"gpt-4o"}, []string{"2.1.0", "2.2.0"}, 9, 5},
+	{"research-assistant", "knowledge", []string{"web_search", "browser", "summarize"}, []string{"gpt-4o", "claude-3-opus"}, []string{"0.3.0", "0.4.0"}, 45, 2},
+	{"sales-outreach", "growth", []string{"crm_lookup", "email_draft"}, []string{"gpt-4o-mini"}, []string{"1.0.0", "1.1.0"}, 6, 3},
+	{"incident-responder", "platform", []string{"pagerduty", "logs_query", "runbook"}, []string{"claude-3-5-sonnet", "gpt-4o"}, []string{"0.7.0"}, 18, 1},
+}
+
+var demoClusters = []string{"us-east-1", "eu-west-1", "ap-southeast-2"}
+
+// demoErrors are the error types of failed demo runs, with their messages
+var demoErrors = []struct{ errorType, message string }{
+	{"rate_limit", "model provider returned 429 Too Many Requests"},
+	{"tool_error", "tool call failed: upstream returned 502 Bad Gateway"},
+	{"invalid_output", "model output did not match the expected schema"},
+	{"context_length", "prompt exceeds the model context window"},
+}
+
+var modelPrices = map[string]float64{
+	"gpt-4o-mini":       0.0006,
+	"gpt-4o":            0.01,
+	"claude-3-haiku":    0.0008,
+	"claude-3-5-sonnet": 0.012,
+	"claude-3-opus":     0.05,
+}
+
+// Generator continuously records plausible runs in the demo store
+type Generator struct {
+	store    *Store
+	interval time.Duration
+	rand     *rand.Rand
+	nextRun  int64
+	versions []*models.AgentVersion
+	agents   []demoAgent
+	weights  []int
+}
+
+// NewGenerator creates a generator recording a burst of runs every interval
+func NewGenerator(store *Store, interval time.Duration) *Generator {
+	return &Generator{
+		store:    store,
+		interval: interval,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		nextRun:  1,
+	}
+}
+
+// Seed registers the demo agents and versions and backfills their run history
+func (g *Generator) Seed(ctx context.Context) error {
+	for i, da := range demoAgents {
+		agent := &models.Agent{Name: da.name, Project: da.project}
+		if err := g.store.CreateAgent(ctx, agent); err != nil {
+			return fmt.Errorf("unable to create demo agent %s: %w", da.name, err)
+		}
+
+		for j, v := range da.versions {
+			status := "inactive"
+			if j == len(da.versions)-1 {
+				status = "active"
+			}
+			version := &models.AgentVersion{
+				AgentID:    agent.ID,
+				Version:    v,
+				Cluster:    demoClusters[(i+j)%len(demoClusters)],
+				Status:     status,
+				Tools:      da.tools,
+				Models:     da.models,
+				Deployment: "kubernetes",
+			}
+			if err := g.store.CreateAgentVersion(ctx, version); err != nil {
+				return fmt.Errorf("unable to create demo version %s %s: %w", da.name, v, err)
+			}
+
+			// Older versions keep a small share of the traffic
+			weight := da.weight
+			if status != "active" {
+				weight = int(math.Max(1, float64(da.weight)/4))
+			}
+			g.versions = append(g.versions, version)
+			g.agents = append(g.agents, da)
+			g.weights = append(g.weights, weight)
+		}
+	}
+
+	// Backfill history at roughly one run every 20 seconds, busier during working hours
+	now := time.Now()
+	runs := []models.AgentRun{}
+	for t := now.AddDate(0, 0, -backfillDays); t.Before(now); t = t.Add(time.Duration(5+g.rand.Intn(30)) * time.Second) {
+		if hour := t.Hour(); (hour < 8 || hour > 19) && g.rand.Intn(3) > 0 {
+			continue
+		}
+		run := g.newRun(t)
+		run.RecordedAt = t.Add(time.Duration(run.TimeTaken * float64(time.Second)))
+		runs = append(runs, *run)
+	}
+	g.store.appendRuns(runs)
+
+	log.Printf("Demo mode: seeded %d agents, %d versions and %d runs", len(demoAgents), len(g.versions), len(runs))
+	return nil
+}
+
+// Run records new runs until the context is cancelled
+func (g *Generator) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for n := 1 + g.rand.Intn(3); n > 0; n-- {
+				run := g.newRun(time.Now())
+				if err := g.store.CreateAgentRun(ctx, run); err != nil {
+					log.Printf("Demo mode: unable to record run: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// newRun generates a run of a randomly picked agent version
+func (g *Generator) newRun(created time.Time) *models.AgentRun {
+	version, da := g.pickVersion()
+
+	status := "completed"
+	switch p := g.rand.Float64(); {
+	case p < 0.06:
+		status = "error"
+	case p < 0.08:
+		status = "timeout"
+	}
+
+	// Log-normal durations around the agent's typical run time
+	seconds := da.meanSeconds * math.Exp(g.rand.NormFloat64()*0.5)
+	if status == "timeout" {
+		seconds = da.meanSeconds * 6
+	}
+
+	usedModels := []string{da.models[g.rand.Intn(len(da.models))]}
+	cost := 0.0
+	for _, m := range usedModels {
+		cost += modelPrices[m] * seconds * (0.5 + g.rand.Float64())
+	}
+
+	// Tokens grow with the run time, models produce a fraction of the tokens they consume
+	promptTokens := int64(seconds * (150 + 250*g.rand.Float64()))
+	completionTokens := promptTokens / int64(3+g.rand.Intn(5))
+
+	usedTools := []string{}
+	for _, tool := range da.tools {
+		if g.rand.Intn(3) > 0 {
+			usedTools = append(usedTools, tool)
+		}
+	}
+
+	runID := g.nextRun
+	g.nextRun++
+
+	run := &models.AgentRun{
+		AgentID:   version.AgentID,
+		VersionID: version.ID,
+		Version:   version.Version,
+		Created:   created,
+		Status:    status,
+		TimeTaken: math.Round(seconds*100) / 100,
+		Initiator: []string{"api", "schedule", "user", "webhook"}[g.rand.Intn(4)],
+		Tools:     usedTools,
+		Cost:      math.Round(cost*10000) / 10000,
+		Models:    usedModels,
+		RunID:     runID,
+		TaskID:    1000 + int64(g.rand.Intn(9000)),
+
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+	}
+	if status == "error" {
+		e := demoErrors[g.rand.Intn(len(demoErrors))]
+		run.ErrorType = e.errorType
+		run.ErrorMessage = e.message
+	}
+	return run
+}
+
+// pickVersion picks an agent version, weighted by its share of the traffic
+func (g *Generator) pickVersion() (*models.AgentVersion, demoAgent) {
+	total := 0
+	for _, w := range g.weights {
+		total += w
+	}
+
+	n := g.rand.Intn(total)
+	for i, w := range g.weights {
+		if n < w {
+			return g.versions[i], g.agents[i]
+		}
+		n -= w
+	}
+	last := len(g.versions) - 1
+	return g.versions[last], g.agents[last]
+}
@@ -0,0 +1,1464 @@
+package demo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ripple/db"
+	"ripple/events"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DatabaseName is the database name reported on events published by the demo store
+const DatabaseName = "demo"
+
+// maxRuns is the number of runs, and of run steps, kept in memory. Older ones are dropped
+const maxRuns = 100000
+
+// Store is an in-memory implementation of the agent and UI stores used by demo mode
+type Store struct {
+	mu       sync.RWMutex
+	agents   []models.Agent
+	versions []models.AgentVersion
+	runs     []models.AgentRun
+	steps    []models.RunStep
+	tokens   map[string]models.IngestToken
+	teams    []models.Team
+	events   *events.Broker
+}
+
+// UIStore serves the dashboard views computed from the runs of a Store
+type UIStore struct {
+	*Store
+}
+
+var (
+	_ db.AgentStore = (*Store)(nil)
+	_ db.UIStore    = (*UIStore)(nil)
+)
+
+// NewStore creates an empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		tokens: map[string]models.IngestToken{},
+		events: events.NewBroker(),
+	}
+}
+
+// UI returns the dashboard views of the store
+func (s *Store) UI() *UIStore {
+	return &UIStore{s}
+}
+
+// Events returns the broker the store publishes events on
+func (s *Store) Events() *events.Broker {
+	return s.events
+}
+
+// CreateAgent creates a new agent
+func (s *Store) CreateAgent(ctx context.Context, agent *models.Agent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.agents {
+		if a.Name == agent.Name {
+			return errors.New("agent with this name already exists")
+		}
+	}
+
+	now := time.Now()
+	agent.ID = primitive.NewObjectID()
+	agent.CreatedAt = now
+	agent.UpdatedAt = now
+	s.agents = append(s.agents, *agent)
+	return nil
+}
+
+// GetAgentByID retrieves an agent by ID
+func (s *Store) GetAgentByID(ctx context.Context, id primitive.ObjectID) (*models.Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.agentByID(id)
+}
+
+// GetAgentByName retrieves an agent by name
+func (s *Store) GetAgentByName(ctx context.Context, name string) (*models.Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, a := range s.agents {
+		if a.Name == name {
+			agent := a
+			return &agent, nil
+		}
+	}
+	return nil, errors.New("agent not found")
+}
+
+// ListAgents retrieves all agents
+func (s *Store) ListAgents(ctx context.Context, listOpts db.ListOptions) ([]models.Agent, error) {
+	s.mu.RLock()
+	agents := make([]models.Agent, 0, len(s.agents))
+	for _, a := range s.agents {
+		if (listOpts.IncludeArchived || !a.Archived) && (listOpts.Project == "" || a.Project == listOpts.Project) && (listOpts.Team == "" || a.Team == listOpts.Team) {
+			agents = append(agents, a)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortItems(agents, listOpts.Sort, []db.SortField{{Field: "name"}})
+	return limit(agents, listOpts.Limit), nil
+}
+
+// SetAgentArchived archives or unarchives an agent and returns the updated agent
+func (s *Store) SetAgentArchived(ctx context.Context, id primitive.ObjectID, archived bool) (*models.Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.agents {
+		if s.agents[i].ID != id {
+			continue
+		}
+
+		agent := &s.agents[i]
+		now := time.Now()
+		agent.Archived = archived
+		agent.ArchivedAt = nil
+		if archived {
+			agent.ArchivedAt = &now
+		}
+		agent.UpdatedAt = now
+
+		updated := *agent
+		return &updated, nil
+	}
+
+	return nil, errors.New("agent not found")
+}
+
+// CreateAgentVersion creates a new agent version
+func (s *Store) CreateAgentVersion(ctx context.Context, version *models.AgentVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.agentByID(version.AgentID); err != nil {
+		return err
+	}
+	if _, err := s.agentVersion(version.AgentID, version.Version); err == nil {
+		return errors.New("version already exists for this agent")
+	}
+
+	now := time.Now()
+	version.ID = primitive.NewObjectID()
+	version.CreatedAt = now
+	version.UpdatedAt = now
+	s.versions = append(s.versions, *version)
+
+	s.events.Publish(events.Event{
+		Type:     events.VersionRegistered,
+		Database: DatabaseName,
+		Version:  version,
+	})
+	return nil
+}
+
+// GetAgentVersions retrieves all versions for an agent
+func (s *Store) GetAgentVersions(ctx context.Context, agentID primitive.ObjectID, listOpts db.ListOptions) ([]models.AgentVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.agentByID(agentID); err != nil {
+		return nil, err
+	}
+
+	versions := []models.AgentVersion{}
+	for _, v := range s.versions {
+		if v.AgentID == agentID {
+			versions = append(versions, v)
+		}
+	}
+
+	sortItems(versions, listOpts.Sort, []db.SortField{{Field: "created_at", Descending: true}})
+	return limit(versions, listOpts.Limit), nil
+}
+
+// GetAgentVersion retrieves a specific version for an agent
+func (s *Store) GetAgentVersion(ctx context.Context, agentID primitive.ObjectID, version string) (*models.AgentVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.agentVersion(agentID, version)
+}
+
+// CreateAgentRun creates a new agent run
+func (s *Store) CreateAgentRun(ctx context.Context, run *models.AgentRun) error {
+	return s.CreateAgentRunBatch(ctx, []*models.AgentRun{run}, db.BatchOptions{})
+}
+
+// CreateAgentRunBatch creates multiple agent runs, either all or none of them, whether the batch is ordered or not
+func (s *Store) CreateAgentRunBatch(ctx context.Context, runs []*models.AgentRun, opts db.BatchOptions) error {
+	if len(runs) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	for _, run := range runs {
+		if _, err := s.agentByID(run.AgentID); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		version, err := s.agentVersion(run.AgentID, run.Version)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		run.VersionID = version.ID
+	}
+	for _, run := range runs {
+		run.ID = primitive.NewObjectID()
+		run.RecordedAt = now
+		s.runs = append(s.runs, *run)
+	}
+	if len(s.runs) > maxRuns {
+		s.runs = append([]models.AgentRun{}, s.runs[len(s.runs)-maxRuns:]...)
+	}
+	s.mu.Unlock()
+
+	for _, run := range runs {
+		s.events.Publish(events.Event{Type: events.RunCreated, Database: DatabaseName, Run: run})
+		if run.Status == "error" {
+			s.events.Publish(events.Event{Type: events.RunFailed, Database: DatabaseName, Run: run})
+		}
+	}
+	return nil
+}
+
+// GetAgentRuns retrieves all runs for an agent
+func (s *Store) GetAgentRuns(ctx context.Context, agentID primitive.ObjectID, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.agentByID(agentID); err != nil {
+		return nil, err
+	}
+
+	return s.filterRuns(func(run *models.AgentRun) bool {
+		return run.AgentID == agentID
+	}, listOpts), nil
+}
+
+// QueryRuns retrieves the runs matching a run query created in [from, to). Zero times leave the range open.
+func (s *Store) QueryRuns(ctx context.Context, query models.RunQuery, from, to time.Time, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filterRuns(func(run *models.AgentRun) bool {
+		if !from.IsZero() && run.Created.Before(from) {
+			return false
+		}
+		if !to.IsZero() && !run.Created.Before(to) {
+			return false
+		}
+		return query.Matches(run)
+	}, listOpts), nil
+}
+
+// GetAgentVersionRuns retrieves all runs for a specific agent version
+func (s *Store) GetAgentVersionRuns(ctx context.Context, agentID primitive.ObjectID, version string, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.agentByID(agentID); err != nil {
+		return nil, err
+	}
+	agentVersion, err := s.agentVersion(agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.filterRuns(func(run *models.AgentRun) bool {
+		return run.AgentID == agentID && run.VersionID == agentVersion.ID
+	}, listOpts), nil
+}
+
+// GetAgentRunsAfter retrieves the runs of an agent recorded after the given position, oldest first
+func (s *Store) GetAgentRunsAfter(ctx context.Context, agentID primitive.ObjectID, filter db.RunFilter, after time.Time, afterID primitive.ObjectID, limit int64) ([]models.AgentRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := []models.AgentRun{}
+	for _, run := range s.runs {
+		if run.AgentID != agentID ||
+			(filter.Version != "" && run.Version != filter.Version) ||
+			(filter.Status != "" && run.Status != filter.Status) {
+			continue
+		}
+		if run.RecordedAt.After(after) || (run.RecordedAt.Equal(after) && run.ID.Hex() > afterID.Hex()) {
+			runs = append(runs, run)
+		}
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		if !runs[i].RecordedAt.Equal(runs[j].RecordedAt) {
+			return runs[i].RecordedAt.Before(runs[j].RecordedAt)
+		}
+		return runs[i].ID.Hex() < runs[j].ID.Hex()
+	})
+	if limit > 0 && int64(len(runs)) > limit {
+		runs = runs[:limit]
+	}
+
+	return runs, nil
+}
+
+// ExportAgentRuns calls fn with each run of an agent matching the filter, oldest first
+func (s *Store) ExportAgentRuns(ctx context.Context, agentID primitive.ObjectID, filter db.RunFilter, fn func(run *models.AgentRun) error) error {
+	s.mu.RLock()
+	if _, err := s.agentByID(agentID); err != nil {
+		s.mu.RUnlock()
+		return err
+	}
+	runs := []models.AgentRun{}
+	for _, run := range s.runs {
+		if run.AgentID == agentID &&
+			(filter.Version == "" || run.Version == filter.Version) &&
+			(filter.Status == "" || run.Status == filter.Status) {
+			runs = append(runs, run)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Created.Before(runs[j].Created) })
+	for i := range runs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&runs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAgentRun retrieves a run of an agent by its run ID, the latest one when the run ID was recorded more than once
+func (s *Store) GetAgentRun(ctx context.Context, agentID primitive.ObjectID, runID int64) (*models.AgentRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *models.AgentRun
+	for i := range s.runs {
+		run := &s.runs[i]
+		if run.AgentID == agentID && run.RunID == runID && (found == nil || !run.Created.Before(found.Created)) {
+			found = run
+		}
+	}
+	if found == nil {
+		return nil, errors.New("run not found")
+	}
+
+	run := *found
+	return &run, nil
+}
+
+// GetExistingRunIDs reports which of the given run IDs are already stored for an agent
+func (s *Store) GetExistingRunIDs(ctx context.Context, agentID primitive.ObjectID, runIDs []int64) (map[int64]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wanted := make(map[int64]bool, len(runIDs))
+	for _, id := range runIDs {
+		wanted[id] = true
+	}
+
+	existing := map[int64]bool{}
+	for _, run := range s.runs {
+		if run.AgentID == agentID && wanted[run.RunID] {
+			existing[run.RunID] = true
+		}
+	}
+
+	return existing, nil
+}
+
+// GetVersionStats summarizes the runs of an agent version created in [from, to)
+func (s *Store) GetVersionStats(ctx context.Context, agentID primitive.ObjectID, version string, from, to time.Time) (*models.VersionStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agentVersion, err := s.agentVersion(agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.VersionStats{Version: version, Errors: map[string]int64{}}
+	var completed int64
+	var timeTaken float64
+	var times []float64
+	for _, run := range s.runs {
+		if run.VersionID != agentVersion.ID || !within(run.Created, from, to) {
+			continue
+		}
+
+		switch run.Status {
+		case models.RunStatusRunning:
+			stats.Running++
+			continue
+		case models.RunStatusCompleted:
+			completed++
+		default:
+			stats.Errors[run.Status]++
+		}
+		stats.Runs++
+		stats.TotalCost += run.Cost
+		timeTaken += run.TimeTaken
+		times = append(times, run.TimeTaken)
+	}
+
+	db.FinishVersionStats(stats, completed, timeTaken)
+	sort.Float64s(times)
+	stats.P95TimeTaken = db.Percentile(times, 95)
+
+	return stats, nil
+}
+
+// GetVersionTimeBuckets aggregates the runs of an agent version created in [from, to) into buckets of the given
+// duration
+func (s *Store) GetVersionTimeBuckets(ctx context.Context, agentID primitive.ObjectID, version string, bucket time.Duration, from, to time.Time) ([]db.TimeBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agentVersion, err := s.agentVersion(agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.timeBuckets(bucket, from, to, func(run *models.AgentRun) bool {
+		return run.VersionID == agentVersion.ID
+	}), nil
+}
+
+// GetLatencyHistogram counts the finished runs of an agent version created in [from, to) per latency bucket
+func (s *Store) GetLatencyHistogram(ctx context.Context, agentID primitive.ObjectID, version string, bounds []float64, from, to time.Time) ([]db.HistogramCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agentVersion, err := s.agentVersion(agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	boundaries := db.HistogramBoundaries(bounds)
+	counts := make([]db.HistogramCount, len(boundaries))
+	for i, lower := range boundaries {
+		counts[i].Min = lower
+	}
+	for i := range s.runs {
+		run := &s.runs[i]
+		if run.VersionID != agentVersion.ID || run.Status == models.RunStatusRunning || run.TimeTaken < 0 || !within(run.Created, from, to) {
+			continue
+		}
+		// The bucket of a run is the last one starting at or below its time taken
+		b := sort.SearchFloat64s(boundaries, run.TimeTaken)
+		if b == len(boundaries) || boundaries[b] != run.TimeTaken {
+			b--
+		}
+		counts[b].Count++
+	}
+
+	return counts, nil
+}
+
+// GetAdoptionBuckets counts the runs of every version of an agent created in [from, to) per time bucket of the
+// given duration
+func (s *Store) GetAdoptionBuckets(ctx context.Context, agentID primitive.ObjectID, bucket time.Duration, from, to time.Time) ([]db.VersionBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.agentByID(agentID); err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		start   time.Time
+		version string
+	}
+	counts := map[key]int64{}
+	for i := range s.runs {
+		run := &s.runs[i]
+		if run.AgentID == agentID && within(run.Created, from, to) {
+			counts[key{run.Created.UTC().Truncate(bucket), run.Version}]++
+		}
+	}
+
+	buckets := make([]db.VersionBucket, 0, len(counts))
+	for k, runs := range counts {
+		buckets = append(buckets, db.VersionBucket{Start: k.start, Version: k.version, Runs: runs})
+	}
+	// Versions first running in the same bucket are listed in a stable order
+	sort.Slice(buckets, func(i, j int) bool {
+		if !buckets[i].Start.Equal(buckets[j].Start) {
+			return buckets[i].Start.Before(buckets[j].Start)
+		}
+		return buckets[i].Version < buckets[j].Version
+	})
+
+	return buckets, nil
+}
+
+// timeBuckets aggregates the runs created in [from, to) that match keep into buckets of the given duration
+func (s *Store) timeBuckets(bucket time.Duration, from, to time.Time, keep func(run *models.AgentRun) bool) []db.TimeBucket {
+	byStart := map[time.Time]*db.TimeBucket{}
+	for i := range s.runs {
+		run := &s.runs[i]
+		if !within(run.Created, from, to) || !keep(run) {
+			continue
+		}
+
+		start := run.Created.UTC().Truncate(bucket)
+		b, ok := byStart[start]
+		if !ok {
+			b = &db.TimeBucket{Start: start}
+			byStart[start] = b
+		}
+		b.Add(run)
+	}
+
+	buckets := make([]db.TimeBucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+
+	return buckets
+}
+
+// GetUsageStats computes the usage statistics of the values of a run list field, tools or models, over the finished
+// runs of an agent created in [from, to), optionally of a single version
+func (s *Store) GetUsageStats(ctx context.Context, agentID primitive.ObjectID, field string, version string, from, to time.Time) ([]models.UsageStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.agentByID(agentID); err != nil {
+		return nil, err
+	}
+
+	var runs []models.AgentRun
+	for _, run := range s.runs {
+		if run.AgentID == agentID && within(run.Created, from, to) && (version == "" || run.Version == version) {
+			runs = append(runs, run)
+		}
+	}
+	return db.UsageStatsOf(runs, field), nil
+}
+
+// CreateRunSteps stores the steps of runs
+func (s *Store) CreateRunSteps(ctx context.Context, steps []*models.RunStep) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, step := range steps {
+		step.ID = primitive.NewObjectID()
+		step.RecordedAt = now
+		s.steps = append(s.steps, *step)
+	}
+	if len(s.steps) > maxRuns {
+		s.steps = append([]models.RunStep{}, s.steps[len(s.steps)-maxRuns:]...)
+	}
+
+	return nil
+}
+
+// GetRunSteps retrieves the steps recorded for a trace, in the order they started
+func (s *Store) GetRunSteps(ctx context.Context, traceID string) ([]models.RunStep, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	steps := []models.RunStep{}
+	for _, step := range s.steps {
+		if step.TraceID == traceID {
+			steps = append(steps, step)
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Started.Before(steps[j].Started)
+	})
+
+	return steps, nil
+}
+
+// CreateIngestToken stores an ingest token
+func (s *Store) CreateIngestToken(ctx context.Context, token *models.IngestToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.CreatedAt = time.Now()
+	s.tokens[token.Hash] = *token
+	return nil
+}
+
+// GetIngestToken retrieves an ingest token by the hash of the token
+func (s *Store) GetIngestToken(ctx context.Context, hash string) (*models.IngestToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, errors.New("ingest token not found")
+	}
+	return &token, nil
+}
+
+// CreateTeam creates a new team, unless a team with the same name exists
+func (s *Store) CreateTeam(ctx context.Context, team *models.Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.teams {
+		if t.Name == team.Name {
+			return errors.New("team already exists")
+		}
+	}
+
+	now := time.Now()
+	team.ID = primitive.NewObjectID()
+	team.CreatedAt = now
+	team.UpdatedAt = now
+	s.teams = append(s.teams, *team)
+	return nil
+}
+
+// GetTeam retrieves a team by name
+func (s *Store) GetTeam(ctx context.Context, name string) (*models.Team, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.teams {
+		if t.Name == name {
+			team := t
+			return &team, nil
+		}
+	}
+	return nil, errors.New("team not found")
+}
+
+// ListTeams retrieves all teams, sorted by name
+func (s *Store) ListTeams(ctx context.Context) ([]models.Team, error) {
+	s.mu.RLock()
+	teams := append([]models.Team{}, s.teams...)
+	s.mu.RUnlock()
+
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, nil
+}
+
+// DeleteTeam deletes a team by name, unless it owns agents
+func (s *Store) DeleteTeam(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.agents {
+		if a.Team == name {
+			return errors.New("team owns agents")
+		}
+	}
+	for i, t := range s.teams {
+		if t.Name == name {
+			s.teams = append(s.teams[:i], s.teams[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("team not found")
+}
+
+// SetAgentSigningSecret replaces the secret signing the run requests of an agent and returns the updated agent
+func (s *Store) SetAgentSigningSecret(ctx context.Context, id primitive.ObjectID, secret string) (*models.Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.agents {
+		if s.agents[i].ID != id {
+			continue
+		}
+
+		agent := &s.agents[i]
+		agent.SigningSecret = secret
+		agent.UpdatedAt = time.Now()
+
+		updated := *agent
+		return &updated, nil
+	}
+
+	return nil, errors.New("agent not found")
+}
+
+// SetAgentTeam assigns an agent to its owning team, or to no team when team is empty, and returns the updated agent
+func (s *Store) SetAgentTeam(ctx context.Context, id primitive.ObjectID, team string) (*models.Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.agents {
+		if s.agents[i].ID != id {
+			continue
+		}
+
+		agent := &s.agents[i]
+		agent.Team = team
+		agent.UpdatedAt = time.Now()
+
+		updated := *agent
+		return &updated, nil
+	}
+
+	return nil, errors.New("agent not found")
+}
+
+// GetModelStats computes the usage statistics of the models of the finished runs of all agents created in [from, to)
+func (s *UIStore) GetModelStats(ctx context.Context, from, to time.Time) ([]models.UsageStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var runs []models.AgentRun
+	for _, run := range s.runs {
+		if within(run.Created, from, to) {
+			runs = append(runs, run)
+		}
+	}
+	return db.UsageStatsOf(runs, db.UsageFieldModels), nil
+}
+
+// GetHeatmapCells aggregates the runs created in [from, to) by day of the week and hour of the day, in UTC
+func (s *UIStore) GetHeatmapCells(ctx context.Context, from, to time.Time) ([]db.HeatmapCell, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var cells [7][24]db.HeatmapCell
+	for _, run := range s.runs {
+		if !within(run.Created, from, to) {
+			continue
+		}
+		created := run.Created.UTC()
+		cell := &cells[created.Weekday()][created.Hour()]
+		cell.Runs++
+		cell.Cost += run.Cost
+	}
+
+	result := []db.HeatmapCell{}
+	for weekday := range cells {
+		for hour, cell := range cells[weekday] {
+			if cell.Runs == 0 {
+				continue
+			}
+			cell.Weekday = weekday
+			cell.Hour = hour
+			result = append(result, cell)
+		}
+	}
+	return result, nil
+}
+
+// GetTimeBuckets aggregates the runs of all agents created in [from, to) into buckets of the given duration
+func (s *UIStore) GetTimeBuckets(ctx context.Context, bucket time.Duration, from, to time.Time) ([]db.TimeBucket, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.timeBuckets(bucket, from, to, func(*models.AgentRun) bool { return true }), nil
+}
+
+// GetLeaderboard ranks the agents, or the agent versions when group is version, by a metric of their runs created
+// in [from, to) and returns the first limit entries
+func (s *UIStore) GetLeaderboard(ctx context.Context, by, group string, from, to time.Time, limit int) ([]models.LeaderboardEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := map[primitive.ObjectID]string{}
+	for _, a := range s.agents {
+		names[a.ID] = a.Name
+	}
+
+	type key struct {
+		agentID primitive.ObjectID
+		version string
+	}
+	byKey := map[key]*models.LeaderboardEntry{}
+	for _, run := range s.runs {
+		if !within(run.Created, from, to) {
+			continue
+		}
+
+		k := key{agentID: run.AgentID}
+		if group == models.LeaderboardByVersion {
+			k.version = run.Version
+		}
+		entry, ok := byKey[k]
+		if !ok {
+			entry = &models.LeaderboardEntry{AgentID: run.AgentID, AgentName: names[run.AgentID], Version: k.version}
+			byKey[k] = entry
+		}
+		entry.Runs++
+		entry.TotalCost += run.Cost
+		if run.Status != models.RunStatusCompleted && run.Status != models.RunStatusRunning {
+			entry.Errors++
+		}
+	}
+
+	entries := make([]models.LeaderboardEntry, 0, len(byKey))
+	for _, entry := range byKey {
+		entries = append(entries, *entry)
+	}
+	return db.RankLeaderboard(entries, by, limit), nil
+}
+
+// GetErrorCounts counts the failed runs created in [from, to) by agent and error category
+func (s *UIStore) GetErrorCounts(ctx context.Context, from, to time.Time) ([]db.ErrorCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := map[primitive.ObjectID]string{}
+	for _, a := range s.agents {
+		names[a.ID] = a.Name
+	}
+
+	type key struct {
+		agentID  primitive.ObjectID
+		category string
+	}
+	byKey := map[key]int64{}
+	for _, run := range s.runs {
+		if !within(run.Created, from, to) || run.Status == models.RunStatusCompleted || run.Status == models.RunStatusRunning {
+			continue
+		}
+		byKey[key{run.AgentID, run.ErrorCategory()}]++
+	}
+
+	counts := make([]db.ErrorCount, 0, len(byKey))
+	for k, count := range byKey {
+		counts = append(counts, db.ErrorCount{AgentID: k.agentID, AgentName: names[k.agentID], Category: k.category, Count: count})
+	}
+	return counts, nil
+}
+
+// GetCostBreakdown sums the cost of the runs created in [from, to) by agent, project, team or model. The cost of a
+// run using several models is split evenly between them.
+func (s *UIStore) GetCostBreakdown(ctx context.Context, groupBy string, from, to time.Time) ([]models.CostBreakdownItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agents := map[primitive.ObjectID]*models.Agent{}
+	for i := range s.agents {
+		agents[s.agents[i].ID] = &s.agents[i]
+	}
+
+	byKey := map[string]*models.CostBreakdownItem{}
+	add := func(key string, item models.CostBreakdownItem, cost float64) {
+		acc, ok := byKey[key]
+		if !ok {
+			acc = &item
+			byKey[key] = acc
+		}
+		acc.Runs++
+		acc.Cost += cost
+	}
+	for _, run := range s.runs {
+		if !within(run.Created, from, to) {
+			continue
+		}
+		agent := agents[run.AgentID]
+		switch groupBy {
+		case models.CostGroupAgent:
+			agentID := run.AgentID
+			item := models.CostBreakdownItem{AgentID: &agentID}
+			if agent != nil {
+				item.Name = agent.Name
+			}
+			add(agentID.Hex(), item, run.Cost)
+		case models.CostGroupProject:
+			project := ""
+			if agent != nil {
+				project = agent.Project
+			}
+			add(project, models.CostBreakdownItem{Name: project}, run.Cost)
+		case models.CostGroupTeam:
+			team := ""
+			if agent != nil {
+				team = agent.Team
+			}
+			add(team, models.CostBreakdownItem{Name: team}, run.Cost)
+		case models.CostGroupModel:
+			for model, share := range db.CostShares(&run) {
+				add(model, models.CostBreakdownItem{Name: model}, share)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported cost grouping %q", groupBy)
+		}
+	}
+
+	items := make([]models.CostBreakdownItem, 0, len(byKey))
+	for _, item := range byKey {
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+// GetTokenUsage sums the tokens and cost of the runs created in [from, to) by day, in the given timezone, agent and
+// model. The tokens and cost of a run using several models are split evenly between them.
+func (s *UIStore) GetTokenUsage(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.TokenUsageItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := map[primitive.ObjectID]string{}
+	for _, agent := range s.agents {
+		names[agent.ID] = agent.Name
+	}
+
+	type key struct {
+		day     string
+		agentID primitive.ObjectID
+		model   string
+	}
+	byKey := map[key]*models.TokenUsageItem{}
+	for i := range s.runs {
+		run := &s.runs[i]
+		if !within(run.Created, from, to) {
+			continue
+		}
+		day := run.Created.In(loc).Format("2006-01-02")
+		prompt, completion := db.TokenShares(run)
+		for model, cost := range db.CostShares(run) {
+			k := key{day, run.AgentID, model}
+			item, ok := byKey[k]
+			if !ok {
+				item = &models.TokenUsageItem{Day: day, AgentID: run.AgentID, Agent: names[run.AgentID], Model: model}
+				byKey[k] = item
+			}
+			item.Runs++
+			item.PromptTokens += prompt
+			item.CompletionTokens += completion
+			item.Cost += cost
+		}
+	}
+
+	items := make([]models.TokenUsageItem, 0, len(byKey))
+	for _, item := range byKey {
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+// GetClusterStats summarizes the runs created in [from, to) by the cluster of their agent version
+func (s *UIStore) GetClusterStats(ctx context.Context, from, to time.Time) ([]models.ClusterStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clusters := map[primitive.ObjectID]string{}
+	for _, v := range s.versions {
+		clusters[v.ID] = v.Cluster
+	}
+
+	byCluster := map[string]*db.ClusterAccumulator{}
+	agents := map[string]map[primitive.ObjectID]bool{}
+	versions := map[string]map[primitive.ObjectID]bool{}
+	for _, run := range s.runs {
+		if !within(run.Created, from, to) {
+			continue
+		}
+
+		cluster := clusters[run.VersionID]
+		acc, ok := byCluster[cluster]
+		if !ok {
+			acc = &db.ClusterAccumulator{Cluster: cluster}
+			byCluster[cluster] = acc
+			agents[cluster] = map[primitive.ObjectID]bool{}
+			versions[cluster] = map[primitive.ObjectID]bool{}
+		}
+		agents[cluster][run.AgentID] = true
+		versions[cluster][run.VersionID] = true
+
+		acc.Runs++
+		acc.Cost += run.Cost
+		if run.Status != models.RunStatusRunning {
+			acc.Finished++
+			if run.Status != models.RunStatusCompleted {
+				acc.Failed++
+			}
+		}
+	}
+
+	accumulators := make([]db.ClusterAccumulator, 0, len(byCluster))
+	for cluster, acc := range byCluster {
+		acc.Agents = int64(len(agents[cluster]))
+		acc.Versions = int64(len(versions[cluster]))
+		accumulators = append(accumulators, *acc)
+	}
+	return db.ClusterStatsOf(accumulators), nil
+}
+
+// GetDashboardStats computes the dashboard statistics of the runs selected by a filter, formatted for the given
+// locale
+func (s *UIStore) GetDashboardStats(ctx context.Context, locale *db.Locale, filter db.DashboardFilter) ([]db.StatsData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	windows := filter.Windows(time.Now())
+
+	activeNow := map[primitive.ObjectID]bool{}
+	activeLastWeek := map[primitive.ObjectID]bool{}
+	var counts db.DashboardCounts
+	var timeNow, timePrev float64
+	var runsNow, runsPrev int
+	// excluded holds the agents whose runs are left out, archived agents or agents of other projects or teams
+	excluded := map[primitive.ObjectID]bool{}
+	for _, a := range s.agents {
+		if (a.Archived && !filter.IncludeArchived) || (filter.Project != "" && a.Project != filter.Project) || (filter.Team != "" && a.Team != filter.Team) {
+			excluded[a.ID] = true
+		}
+	}
+	var clusterVersions map[primitive.ObjectID]bool
+	if filter.Cluster != "" {
+		clusterVersions = map[primitive.ObjectID]bool{}
+		for _, v := range s.versions {
+			if v.Cluster == filter.Cluster {
+				clusterVersions[v.ID] = true
+			}
+		}
+	}
+	for _, run := range s.runs {
+		if excluded[run.AgentID] || (clusterVersions != nil && !clusterVersions[run.VersionID]) {
+			continue
+		}
+		if w := windows.ActiveAgents; within(run.Created, w.From, w.To) {
+			activeNow[run.AgentID] = true
+		}
+		if w := windows.ActiveAgents; within(run.Created, w.CompareFrom, w.CompareTo) {
+			activeLastWeek[run.AgentID] = true
+		}
+		if w := windows.Runs; within(run.Created, w.From, w.To) {
+			counts.RunsToday++
+		}
+		if w := windows.Runs; within(run.Created, w.CompareFrom, w.CompareTo) {
+			counts.RunsYesterday++
+		}
+		if w := windows.Cost; within(run.Created, w.From, w.To) {
+			counts.CostToday += run.Cost
+		}
+		if w := windows.Cost; within(run.Created, w.CompareFrom, w.CompareTo) {
+			counts.CostYesterday += run.Cost
+		}
+		if w := windows.ResponseTime; within(run.Created, w.From, w.To) {
+			timeNow += run.TimeTaken
+			runsNow++
+		}
+		if w := windows.ResponseTime; within(run.Created, w.CompareFrom, w.CompareTo) {
+			timePrev += run.TimeTaken
+			runsPrev++
+		}
+	}
+
+	counts.ActiveAgentsNow = len(activeNow)
+	counts.ActiveAgentsLastWeek = len(activeLastWeek)
+	if runsNow > 0 {
+		counts.AvgResponseTimeNow = timeNow / float64(runsNow)
+	}
+	if runsPrev > 0 {
+		counts.AvgResponseTimePrev = timePrev / float64(runsPrev)
+	}
+
+	return db.BuildDashboardStats(locale, counts, windows), nil
+}
+
+// GetRecentActivity retrieves the most recent agent runs selected by a filter, newest first. The returned cursor
+// points after the last item when more runs match, and is nil otherwise.
+func (s *UIStore) GetRecentActivity(ctx context.Context, filter db.ActivityFilter) ([]db.ActivityData, *db.ActivityCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := map[string]bool{}
+	for _, status := range filter.Statuses {
+		statuses[status] = true
+	}
+
+	runs := []*models.AgentRun{}
+	for i := range s.runs {
+		run := &s.runs[i]
+		if filter.AgentID != nil && run.AgentID != *filter.AgentID {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[run.Status] {
+			continue
+		}
+		if after := filter.After; after != nil &&
+			!(run.Created.Before(after.Created) || (run.Created.Equal(after.Created) && run.ID.Hex() < after.RunID.Hex())) {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].Created.Equal(runs[j].Created) {
+			return runs[i].Created.After(runs[j].Created)
+		}
+		return runs[i].ID.Hex() > runs[j].ID.Hex()
+	})
+
+	hasMore := int64(len(runs)) > filter.Limit
+	if hasMore {
+		runs = runs[:filter.Limit]
+	}
+
+	activities := make([]db.ActivityData, 0, len(runs))
+	for _, run := range runs {
+		name := ""
+		if agent, err := s.agentByID(run.AgentID); err == nil {
+			name = agent.Name
+		}
+		activities = append(activities, db.NewActivityData(run, name))
+	}
+
+	var next *db.ActivityCursor
+	if hasMore {
+		last := runs[len(runs)-1]
+		next = &db.ActivityCursor{Created: last.Created, RunID: last.ID}
+	}
+	return activities, next, nil
+}
+
+// GetAgentVersions computes the metrics of the agent versions selected by the filter
+func (s *UIStore) GetAgentVersions(ctx context.Context, filter db.VersionMetricsFilter, listOpts db.ListOptions) ([]models.AgentVersionMetrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metrics := s.filterVersionMetrics(filter)
+	sortItems(metrics, listOpts.Sort, []db.SortField{{Field: "name"}, {Field: "version"}})
+	if listOpts.Offset >= int64(len(metrics)) {
+		return []models.AgentVersionMetrics{}, nil
+	}
+
+	return limit(metrics[listOpts.Offset:], listOpts.Limit), nil
+}
+
+// CountAgentVersions counts the agent versions with runs selected by the filter
+func (s *UIStore) CountAgentVersions(ctx context.Context, filter db.VersionMetricsFilter) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.filterVersionMetrics(filter))), nil
+}
+
+// filterVersionMetrics computes the metrics of the agent versions selected by the filter. The caller must hold
+// the lock.
+func (s *UIStore) filterVersionMetrics(filter db.VersionMetricsFilter) []models.AgentVersionMetrics {
+	metrics := make([]models.AgentVersionMetrics, 0, len(s.versions))
+	for i := range s.versions {
+		if m := s.versionMetrics(&s.versions[i]); m != nil && filter.Matches(m) {
+			metrics = append(metrics, *m)
+		}
+	}
+	return metrics
+}
+
+// GetAgentVersionMetrics computes the metrics of a single agent version
+func (s *UIStore) GetAgentVersionMetrics(ctx context.Context, versionID primitive.ObjectID) (*models.AgentVersionMetrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.versions {
+		if s.versions[i].ID == versionID {
+			if m := s.versionMetrics(&s.versions[i]); m != nil {
+				return m, nil
+			}
+		}
+	}
+	return nil, errors.New("metrics not found for this version")
+}
+
+// GetAutoscalingSignals computes ingest rate, run backlog and per-agent load over the given window, of the named
+// agent only when agentName is not empty
+func (s *UIStore) GetAutoscalingSignals(ctx context.Context, window time.Duration, agentName string) (*db.AutoscalingSignals, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	since := now.Add(-window)
+	signals := &db.AutoscalingSignals{
+		GeneratedAt:   now,
+		WindowSeconds: window.Seconds(),
+		Agents:        []db.AgentLoadSignal{},
+	}
+
+	load := map[primitive.ObjectID]*db.AgentLoadSignal{}
+	for _, run := range s.runs {
+		inWindow := !run.RecordedAt.Before(since)
+		running := run.Status == "running"
+		if !inWindow && !running {
+			continue
+		}
+
+		agent, err := s.agentByID(run.AgentID)
+		if agentName != "" && (err != nil || agent.Name != agentName) {
+			continue
+		}
+		if inWindow {
+			signals.RunsInWindow++
+		}
+		if running {
+			signals.Backlog++
+		}
+		if err != nil {
+			continue
+		}
+		l, ok := load[agent.ID]
+		if !ok {
+			l = &db.AgentLoadSignal{AgentID: agent.ID, Name: agent.Name, Project: agent.Project}
+			load[agent.ID] = l
+		}
+		if inWindow {
+			l.RunsInWindow++
+		}
+		if running {
+			l.Running++
+		}
+	}
+
+	signals.IngestRate = float64(signals.RunsInWindow) / window.Seconds()
+	for _, l := range load {
+		l.IngestRate = float64(l.RunsInWindow) / window.Seconds()
+		signals.Agents = append(signals.Agents, *l)
+	}
+	sort.Slice(signals.Agents, func(i, j int) bool {
+		if signals.Agents[i].Running != signals.Agents[j].Running {
+			return signals.Agents[i].Running > signals.Agents[j].Running
+		}
+		return signals.Agents[i].RunsInWindow > signals.Agents[j].RunsInWindow
+	})
+
+	return signals, nil
+}
+
+// appendRuns stores runs as is, keeping their recorded time and without publishing events
+func (s *Store) appendRuns(runs []models.AgentRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range runs {
+		runs[i].ID = primitive.NewObjectID()
+		s.runs = append(s.runs, runs[i])
+	}
+	if len(s.runs) > maxRuns {
+		s.runs = append([]models.AgentRun{}, s.runs[len(s.runs)-maxRuns:]...)
+	}
+}
+
+// agentByID looks up an agent. The caller must hold the lock.
+func (s *Store) agentByID(id primitive.ObjectID) (*models.Agent, error) {
+	for _, a := range s.agents {
+		if a.ID == id {
+			agent := a
+			return &agent, nil
+		}
+	}
+	return nil, errors.New("agent not found")
+}
+
+// agentVersion looks up a version of an agent. The caller must hold the lock.
+func (s *Store) agentVersion(agentID primitive.ObjectID, version string) (*models.AgentVersion, error) {
+	for _, v := range s.versions {
+		if v.AgentID == agentID && v.Version == version {
+			agentVersion := v
+			return &agentVersion, nil
+		}
+	}
+	return nil, errors.New("version not found for this agent")
+}
+
+// filterRuns returns the runs matching a predicate, newest first unless sorted otherwise. The caller must hold the lock.
+func (s *Store) filterRuns(match func(*models.AgentRun) bool, listOpts db.ListOptions) []models.AgentRun {
+	runs := []models.AgentRun{}
+	for i := range s.runs {
+		if match(&s.runs[i]) {
+			runs = append(runs, s.runs[i])
+		}
+	}
+
+	sortItems(runs, listOpts.Sort, []db.SortField{{Field: "created", Descending: true}})
+	return limit(runs, listOpts.Limit)
+}
+
+// versionMetrics computes the metrics of an agent version the way the worker does. The caller must hold the lock.
+func (s *Store) versionMetrics(version *models.AgentVersion) *models.AgentVersionMetrics {
+	agent, err := s.agentByID(version.AgentID)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	recentStart := now.Add(-models.HealthRecentWindow)
+	baselineStart := recentStart.Add(-models.HealthBaselineWindow)
+	var count, errorCount int64
+	var timeTaken, cost, spendToday, spend7d, spend30d float64
+	var lastSeen time.Time
+	var signals models.HealthSignals
+	var recentTime, baselineTime float64
+	errorsByCategory := map[string]int64{}
+	for _, run := range s.runs {
+		if run.VersionID != version.ID {
+			continue
+		}
+		count++
+		timeTaken += run.TimeTaken
+		cost += run.Cost
+		failed := run.Status != models.RunStatusCompleted && run.Status != models.RunStatusRunning
+		if within(run.Created, recentStart, now) {
+			signals.RecentRuns++
+			recentTime += run.TimeTaken
+			if failed {
+				signals.RecentErrors++
+			}
+		} else if within(run.Created, baselineStart, recentStart) {
+			signals.BaselineRuns++
+			baselineTime += run.TimeTaken
+		}
+		if within(run.Created, today, now) {
+			spendToday += run.Cost
+		}
+		if within(run.Created, now.AddDate(0, 0, -7), now) {
+			spend7d += run.Cost
+		}
+		if within(run.Created, now.AddDate(0, 0, -30), now) {
+			spend30d += run.Cost
+		}
+		if run.Status == "error" {
+			errorCount++
+		}
+		if run.Status != models.RunStatusCompleted && run.Status != models.RunStatusRunning {
+			errorsByCategory[run.ErrorCategory()]++
+		}
+		if run.RecordedAt.After(lastSeen) {
+			lastSeen = run.RecordedAt
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+
+	signals.LastSeen = lastSeen
+	if signals.RecentRuns > 0 {
+		signals.RecentAvgTime = recentTime / float64(signals.RecentRuns)
+	}
+	if signals.BaselineRuns > 0 {
+		signals.BaselineAvgTime = baselineTime / float64(signals.BaselineRuns)
+	}
+	health, healthReasons := models.ComputeHealth(signals, now)
+
+	return &models.AgentVersionMetrics{
+		Id:             version.ID,
+		Window:         models.MetricsWindowAll,
+		Environment:    models.MetricsEnvironmentDefault,
+		Name:           agent.Name,
+		Project:        agent.Project,
+		Team:           agent.Team,
+		Status:         version.Status,
+		LastSeen:       lastSeen,
+		Version:        version.Version,
+		AverageRunTime: timeTaken / float64(count),
+		SuccessRate:    (float64(count-errorCount) / float64(count)) * 100,
+		TotalRuns:      count,
+		Spend:          cost,
+		SpendToday:     spendToday,
+		Spend7d:        spend7d,
+		Spend30d:       spend30d,
+		SpendTotal:     cost,
+		Tools:          version.Tools,
+		Models:         version.Models,
+		Cluster:        version.Cluster,
+		Errors:         errorsByCategory,
+		Health:         health,
+		HealthReasons:  healthReasons,
+	}
+}
+
+// within reports whether t is in [start, end)
+func within(t, start, end time.Time) bool {
+	return !t.Before(start) && t.Before(end)
+}
+
+// limit caps the number of items, zero means no limit
+func limit[T any](items []T, n int64) []T {
+	if n > 0 && int64(len(items)) > n {
+		return items[:n]
+	}
+	return items
+}
+
+// sortItems sorts a slice of structs by the requested JSON fields, or by the fallback when no sort is requested
+func sortItems[T any](items []T, sortFields []db.SortField, fallback []db.SortField) {
+	if len(sortFields) == 0 {
+		sortFields = fallback
+	}
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	indexes := make([]int, 0, len(sortFields))
+	fields := make([]db.SortField, 0, len(sortFields))
+	for _, sf := range sortFields {
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == sf.Field {
+				indexes = append(indexes, i)
+				fields = append(fields, sf)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := reflect.ValueOf(items[i]), reflect.ValueOf(items[j])
+		for k, index := range indexes {
+			c := compare(a.Field(index), b.Field(index))
+			if c == 0 {
+				continue
+			}
+			if fields[k].Descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// compare compares two values of the same kind, returning -1, 0 or 1
+func compare(a, b reflect.Value) int {
+	if at, ok := a.Interface().(time.Time); ok {
+		return at.Compare(b.Interface().(time.Time))
+	}
+
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		switch {
+		case a.Int() < b.Int():
+			return -1
+		case a.Int() > b.Int():
+			return 1
+		}
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case a.Float() < b.Float():
+			return -1
+		case a.Float() > b.Float():
+			return 1
+		}
+	}
+	return 0
+}
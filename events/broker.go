@@ -0,0 +1,93 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event types
+const (
+	// RunCreated is published when an agent run is recorded
+	RunCreated = "run.created"
+	// RunFailed is published, in addition to RunCreated, when a recorded run has the error status
+	RunFailed = "run.failed"
+	// VersionRegistered is published when a new agent version is registered
+	VersionRegistered = "version.registered"
+)
+
+// Types lists all event types
+var Types = []string{RunCreated, RunFailed, VersionRegistered}
+
+// subscriberBufferSize is the number of events buffered per subscriber before events are dropped
+const subscriberBufferSize = 256
+
+// Event represents something that happened in ripple
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Database string    `json:"-"`
+	// Org is the organization the event was published for in org tenant mode
+	Org     primitive.ObjectID   `json:"-"`
+	Run     *models.AgentRun     `json:"run,omitempty"`
+	Version *models.AgentVersion `json:"version,omitempty"`
+}
+
+// Broker is an in-process publish/subscribe broker for events
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewBroker creates a new broker
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Subscribe returns a channel receiving published events. Events are dropped
+// for subscribers that do not keep up.
+func (b *Broker) Subscribe() chan Event {
+	ch := make(chan Event, subscriberBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Broker) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends an event to all subscribers without blocking
+func (b *Broker) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
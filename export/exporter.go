@@ -0,0 +1,141 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+)
+
+const (
+	// FormatParquet is the format of the files written by export jobs
+	FormatParquet = "parquet"
+
+	defaultPollInterval   = 10 * time.Second
+	defaultMaxRowsPerFile = 100000
+	// jobTimeout bounds the duration of a job, after which it is considered abandoned and claimed again
+	jobTimeout = time.Hour
+)
+
+// Exporter runs the pending export jobs, writing the runs of each job's time range as Parquet files partitioned
+// by day: [tenant=<tenant>/]agent_runs/date=<yyyy-mm-dd>/<job id>-<part>.parquet
+type Exporter struct {
+	base           *db.MongoDB
+	tenants        *db.TenantRouter
+	store          ObjectStore
+	pollInterval   time.Duration
+	maxRowsPerFile int
+}
+
+// NewExporter creates a new exporter. With a tenant router, the jobs of every tenant database are run.
+func NewExporter(base *db.MongoDB, tenants *db.TenantRouter, store ObjectStore) *Exporter {
+	return &Exporter{
+		base:           base,
+		tenants:        tenants,
+		store:          store,
+		pollInterval:   defaultPollInterval,
+		maxRowsPerFile: defaultMaxRowsPerFile,
+	}
+}
+
+// Run polls for pending export jobs until the context is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		databases, err := db.TenantDatabases(ctx, e.base, e.tenants)
+		if err != nil {
+			log.Printf("Unable to list tenant databases for exports: %v", err)
+		}
+		for tenant, database := range databases {
+			e.runPending(ctx, tenant, db.NewExportRepository(database))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runPending runs the pending jobs of a database one after the other
+func (e *Exporter) runPending(ctx context.Context, tenant string, repo *db.ExportRepository) {
+	for ctx.Err() == nil {
+		job, err := repo.ClaimExportJob(ctx, jobTimeout)
+		if err != nil {
+			log.Printf("Unable to claim export job for tenant %q: %v", tenant, err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		log.Printf("Running export job %s of runs from %s to %s", job.ID.Hex(), job.From.Format(time.RFC3339), job.To.Format(time.RFC3339))
+		jobErr := e.export(ctx, tenant, repo, job)
+		if jobErr != nil {
+			log.Printf("Export job %s failed: %v", job.ID.Hex(), jobErr)
+		}
+		if err := repo.FinishExportJob(ctx, job.ID, jobErr); err != nil {
+			log.Printf("Unable to record the end of export job %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
+
+// export writes the runs of a job, starting a new file for every day and every maxRowsPerFile runs
+func (e *Exporter) export(ctx context.Context, tenant string, repo *db.ExportRepository, job *models.ExportJob) error {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	prefix := "agent_runs/"
+	if tenant != "" {
+		prefix = "tenant=" + tenant + "/" + prefix
+	}
+
+	var runs []*models.AgentRun
+	var day string
+	var part int
+	flush := func() error {
+		if len(runs) == 0 {
+			return nil
+		}
+
+		data := EncodeRunsParquet(runs)
+		name := fmt.Sprintf("%sdate=%s/%s-%05d.parquet", prefix, day, job.ID.Hex(), part)
+		key, err := e.store.Put(ctx, name, data, "application/vnd.apache.parquet")
+		if err != nil {
+			return err
+		}
+		if err := repo.AddExportFile(ctx, job.ID, models.ExportFile{Key: key, Rows: int64(len(runs)), Bytes: int64(len(data))}); err != nil {
+			return err
+		}
+
+		runs = runs[:0]
+		part++
+		return nil
+	}
+
+	err := repo.ExportRuns(ctx, job.From, job.To, func(run *models.AgentRun) error {
+		if runDay := run.Created.UTC().Format("2006-01-02"); runDay != day {
+			if err := flush(); err != nil {
+				return err
+			}
+			day, part = runDay, 0
+		}
+
+		runs = append(runs, run)
+		if len(runs) >= e.maxRowsPerFile {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
@@ -0,0 +1,280 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ripple/sigv4"
+)
+
+const (
+	defaultS3Region  = "us-east-1"
+	gcsEndpoint      = "https://storage.googleapis.com"
+	gcsRegion        = "auto"
+	objectPutTimeout = 5 * time.Minute
+	// objectPartSize is the size of the parts of multipart uploads, which must be at least 5 MiB but for the last
+	objectPartSize = 8 << 20
+)
+
+// ObjectStore stores the files written by exports
+type ObjectStore interface {
+	// Location returns the URL of the bucket and prefix files are written to
+	Location() string
+	// Put stores a file under the given name, relative to the store's prefix, and returns its object key
+	Put(ctx context.Context, name string, body []byte, contentType string) (string, error)
+}
+
+// S3Store writes files to an S3 compatible bucket with AWS Signature Version 4 signed requests. Google Cloud
+// Storage buckets are written through its S3 compatible XML API, authenticated with HMAC keys.
+type S3Store struct {
+	client   *http.Client
+	location string
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	creds    sigv4.Credentials
+}
+
+// NewObjectStore creates an object store for a bucket URL such as s3://bucket/prefix or gs://bucket/prefix.
+// The endpoint and region default to the ones of AWS S3 or Google Cloud Storage. Credentials are read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+func NewObjectStore(bucketURL, endpoint, region string) (*S3Store, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bucket URL: %w", err)
+	}
+	if u.Host == "" {
+		return nil, errors.New("invalid bucket URL: missing bucket name")
+	}
+
+	switch u.Scheme {
+	case "s3":
+		if region == "" {
+			region = defaultS3Region
+		}
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+	case "gs":
+		if region == "" {
+			region = gcsRegion
+		}
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bucket URL scheme %q, use s3:// or gs://", u.Scheme)
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid object store endpoint %q", endpoint)
+	}
+
+	creds, err := sigv4.CredentialsFromEnv()
+	if err != nil {
+		return nil, errors.New("missing object store credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	return &S3Store{
+		client:   &http.Client{Timeout: objectPutTimeout},
+		location: strings.TrimSuffix(bucketURL, "/"),
+		endpoint: endpointURL,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		region:   region,
+		creds:    creds,
+	}, nil
+}
+
+// Location returns the URL of the bucket and prefix files are written to
+func (s *S3Store) Location() string {
+	return s.location
+}
+
+// Put uploads a file with a single PUT request and returns its object key
+func (s *S3Store) Put(ctx context.Context, name string, body []byte, contentType string) (string, error) {
+	key := s.key(name)
+	resp, err := s.do(ctx, http.MethodPut, key, "", body, contentType)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return key, nil
+}
+
+// Upload stores a file read from body and returns its object key. Files of up to a part are uploaded with a single
+// PUT request, and larger ones with a multipart upload, so that files are never held in memory whole. A multipart
+// upload failing, as when reading body fails, is aborted.
+func (s *S3Store) Upload(ctx context.Context, name string, body io.Reader, contentType string) (string, error) {
+	part := make([]byte, objectPartSize)
+	n, err := io.ReadFull(body, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.Put(ctx, name, part[:n], contentType)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	key := s.key(name)
+	uploadID, err := s.createMultipartUpload(ctx, key, contentType)
+	if err != nil {
+		return "", fmt.Errorf("unable to upload %s: %w", key, err)
+	}
+	parts := []completedPart{}
+	for n > 0 {
+		query := fmt.Sprintf("partNumber=%d&uploadId=%s", len(parts)+1, queryEscape(uploadID))
+		resp, err := s.do(ctx, http.MethodPut, key, query, part[:n], "")
+		if err != nil {
+			s.abortMultipartUpload(key, uploadID)
+			return "", fmt.Errorf("unable to upload part %d of %s: %w", len(parts)+1, key, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{Number: len(parts) + 1, ETag: resp.Header.Get("ETag")})
+
+		n, err = io.ReadFull(body, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			s.abortMultipartUpload(key, uploadID)
+			return "", err
+		}
+	}
+
+	complete, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, "uploadId="+queryEscape(uploadID), complete, "application/xml")
+	if err != nil {
+		s.abortMultipartUpload(key, uploadID)
+		return "", fmt.Errorf("unable to complete the upload of %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	// Completing an upload may fail after the response status is sent, with an error in its body
+	var result struct {
+		XMLName xml.Name
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+		s.abortMultipartUpload(key, uploadID)
+		return "", fmt.Errorf("unable to complete the upload of %s: %s", key, result.Message)
+	}
+	return key, nil
+}
+
+// Get downloads the file stored under an object key, as returned by Put
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	content, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+// Open opens the file stored under an object key, as returned by Put, for reading
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, "", nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+// completedPart is an uploaded part of a multipart upload
+type completedPart struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+// completeMultipartUpload is the body of the request completing a multipart upload
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// createMultipartUpload starts a multipart upload and returns its ID
+func (s *S3Store) createMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, "uploads=", nil, contentType)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response to the creation of a multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("invalid response to the creation of a multipart upload: missing upload ID")
+	}
+	return result.UploadID, nil
+}
+
+// abortMultipartUpload aborts a multipart upload, deleting its parts, even once the context of the upload is done
+func (s *S3Store) abortMultipartUpload(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodDelete, key, "uploadId="+queryEscape(uploadID), nil, "")
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+// key returns the object key of a file, relative to the store's prefix
+func (s *S3Store) key(name string) string {
+	if s.prefix != "" {
+		return s.prefix + "/" + name
+	}
+	return name
+}
+
+// do sends a signed request for an object, with a query already in canonical form, and returns its response,
+// failing with the error the store responds with unless it succeeded
+func (s *S3Store) do(ctx context.Context, method, key, query string, body []byte, contentType string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	u.RawPath = sigv4.URIEscape(u.Path)
+	u.RawQuery = query
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sigv4.Sign(req, body, s.creds, s.region, "s3", time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// queryEscape escapes a query parameter value as required by Signature Version 4
+func queryEscape(value string) string {
+	return strings.ReplaceAll(sigv4.URIEscape(value), "/", "%2F")
+}
@@ -0,0 +1,435 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"time"
+
+	"ripple/models"
+
+	"github.com/golang/snappy"
+)
+
+// Parquet format constants, see https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	parquetMagic = "PAR1"
+
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedList            = 3
+	parquetConvertedTimestampMillis = 9
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecSnappy = 1
+	parquetDataPage    = 0
+)
+
+// columnKind is the layout of a column in the Parquet schema
+type columnKind int
+
+const (
+	columnString         columnKind = iota // required UTF8 byte array
+	columnOptionalString                   // optional UTF8 byte array, empty strings are stored as null
+	columnDouble                           // required double
+	columnInt64                            // required int64
+	columnTimestamp                        // required int64 timestamp in milliseconds
+	columnStringList                       // optional list of UTF8 byte arrays
+)
+
+// parquetColumn is a column of the exported runs
+type parquetColumn struct {
+	name  string
+	kind  columnKind
+	value func(run *models.AgentRun) any
+}
+
+// runColumns are the columns of the agent_runs export
+var runColumns = []parquetColumn{
+	{"id", columnString, func(r *models.AgentRun) any { return r.ID.Hex() }},
+	{"agent_id", columnString, func(r *models.AgentRun) any { return r.AgentID.Hex() }},
+	{"version_id", columnString, func(r *models.AgentRun) any { return r.VersionID.Hex() }},
+	{"version", columnString, func(r *models.AgentRun) any { return r.Version }},
+	{"created", columnTimestamp, func(r *models.AgentRun) any { return r.Created }},
+	{"status", columnString, func(r *models.AgentRun) any { return r.Status }},
+	{"time_taken", columnDouble, func(r *models.AgentRun) any { return r.TimeTaken }},
+	{"initiator", columnString, func(r *models.AgentRun) any { return r.Initiator }},
+	{"tools", columnStringList, func(r *models.AgentRun) any { return r.Tools }},
+	{"cost", columnDouble, func(r *models.AgentRun) any { return r.Cost }},
+	{"models", columnStringList, func(r *models.AgentRun) any { return r.Models }},
+	{"run_id", columnInt64, func(r *models.AgentRun) any { return r.RunID }},
+	{"task_id", columnInt64, func(r *models.AgentRun) any { return r.TaskID }},
+	{"trace_id", columnOptionalString, func(r *models.AgentRun) any { return r.TraceID }},
+	{"span_id", columnOptionalString, func(r *models.AgentRun) any { return r.SpanID }},
+	{"recorded_at", columnTimestamp, func(r *models.AgentRun) any { return r.RecordedAt }},
+	{"schema_version", columnInt64, func(r *models.AgentRun) any { return int64(r.SchemaVersion) }},
+	{"error_type", columnOptionalString, func(r *models.AgentRun) any { return r.ErrorType }},
+	{"error_message", columnOptionalString, func(r *models.AgentRun) any { return r.ErrorMessage }},
+	{"prompt_tokens", columnInt64, func(r *models.AgentRun) any { return r.PromptTokens }},
+	{"completion_tokens", columnInt64, func(r *models.AgentRun) any { return r.CompletionTokens }},
+}
+
+// EncodeRunsParquet encodes runs as a Snappy compressed Parquet file with a single row group
+func EncodeRunsParquet(runs []*models.AgentRun) []byte {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, 1+schemaSize(runColumns))
+	writeSchema(meta, runColumns)
+	meta.i64(3, int64(len(runs)))
+
+	// A single row group holding a single data page per column
+	meta.list(4, thriftStruct, 1)
+	meta.beginStruct()
+	meta.list(1, thriftStruct, len(runColumns))
+	var totalSize int64
+	for _, column := range runColumns {
+		chunk := &columnChunk{column: column}
+		for _, run := range runs {
+			chunk.add(run)
+		}
+		offset := int64(file.Len())
+		uncompressed, compressed := chunk.writePage(&file)
+		totalSize += uncompressed
+
+		meta.beginStruct()
+		meta.i64(2, offset)
+		meta.structField(3)
+		meta.i32(1, chunk.physicalType())
+		meta.list(2, thriftI32, 2)
+		meta.listI32(parquetEncodingPlain)
+		meta.listI32(parquetEncodingRLE)
+		path := chunk.path()
+		meta.list(3, thriftBinary, len(path))
+		for _, name := range path {
+			meta.listString(name)
+		}
+		meta.i32(4, parquetCodecSnappy)
+		meta.i64(5, int64(chunk.count))
+		meta.i64(6, uncompressed)
+		meta.i64(7, compressed)
+		meta.i64(9, offset)
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(len(runs)))
+	meta.endStruct()
+
+	meta.str(6, "ripple")
+	meta.endStruct()
+
+	file.Write(meta.buf.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.buf.Len()))
+	file.WriteString(parquetMagic)
+	return file.Bytes()
+}
+
+// schemaSize returns the number of schema elements of columns, lists taking three
+func schemaSize(columns []parquetColumn) int {
+	size := 0
+	for _, column := range columns {
+		if column.kind == columnStringList {
+			size += 3
+		} else {
+			size++
+		}
+	}
+	return size
+}
+
+// writeSchema writes the flattened schema: the root, then the columns depth first. Lists use the three-level
+// LIST layout: optional group <name> (LIST) { repeated group list { optional binary element (UTF8) } }.
+func writeSchema(t *thriftWriter, columns []parquetColumn) {
+	t.beginStruct()
+	t.str(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.endStruct()
+
+	for _, column := range columns {
+		switch column.kind {
+		case columnString:
+			schemaElement(t, column.name, parquetTypeByteArray, parquetRequired, parquetConvertedUTF8)
+		case columnOptionalString:
+			schemaElement(t, column.name, parquetTypeByteArray, parquetOptional, parquetConvertedUTF8)
+		case columnDouble:
+			schemaElement(t, column.name, parquetTypeDouble, parquetRequired, -1)
+		case columnInt64:
+			schemaElement(t, column.name, parquetTypeInt64, parquetRequired, -1)
+		case columnTimestamp:
+			schemaElement(t, column.name, parquetTypeInt64, parquetRequired, parquetConvertedTimestampMillis)
+		case columnStringList:
+			t.beginStruct()
+			t.i32(3, parquetOptional)
+			t.str(4, column.name)
+			t.i32(5, 1)
+			t.i32(6, parquetConvertedList)
+			t.endStruct()
+
+			t.beginStruct()
+			t.i32(3, parquetRepeated)
+			t.str(4, "list")
+			t.i32(5, 1)
+			t.endStruct()
+
+			schemaElement(t, "element", parquetTypeByteArray, parquetOptional, parquetConvertedUTF8)
+		}
+	}
+}
+
+// schemaElement writes a leaf schema element, without converted type when converted is negative
+func schemaElement(t *thriftWriter, name string, physicalType, repetition, converted int32) {
+	t.beginStruct()
+	t.i32(1, physicalType)
+	t.i32(3, repetition)
+	t.str(4, name)
+	if converted >= 0 {
+		t.i32(6, converted)
+	}
+	t.endStruct()
+}
+
+// columnChunk accumulates the PLAIN encoded values and the levels of a column
+type columnChunk struct {
+	column parquetColumn
+	values bytes.Buffer
+	defs   []uint8
+	reps   []uint8
+	count  int
+}
+
+// add appends the value of a run
+func (c *columnChunk) add(run *models.AgentRun) {
+	value := c.column.value(run)
+
+	switch c.column.kind {
+	case columnString:
+		c.writeByteArray(value.(string))
+		c.count++
+	case columnOptionalString:
+		if s := value.(string); s != "" {
+			c.defs = append(c.defs, 1)
+			c.writeByteArray(s)
+		} else {
+			c.defs = append(c.defs, 0)
+		}
+		c.count++
+	case columnDouble:
+		binary.Write(&c.values, binary.LittleEndian, math.Float64bits(value.(float64)))
+		c.count++
+	case columnInt64:
+		binary.Write(&c.values, binary.LittleEndian, value.(int64))
+		c.count++
+	case columnTimestamp:
+		binary.Write(&c.values, binary.LittleEndian, value.(time.Time).UnixMilli())
+		c.count++
+	case columnStringList:
+		// Definition levels: 0 null list, 1 empty list, 3 element. Repetition levels: 0 new row, 1 same list.
+		list := value.([]string)
+		if len(list) == 0 {
+			def := uint8(0)
+			if list != nil {
+				def = 1
+			}
+			c.defs = append(c.defs, def)
+			c.reps = append(c.reps, 0)
+			c.count++
+			return
+		}
+		for i, element := range list {
+			rep := uint8(1)
+			if i == 0 {
+				rep = 0
+			}
+			c.defs = append(c.defs, 3)
+			c.reps = append(c.reps, rep)
+			c.writeByteArray(element)
+			c.count++
+		}
+	}
+}
+
+// writeByteArray appends a PLAIN encoded byte array: its length, then its bytes
+func (c *columnChunk) writeByteArray(s string) {
+	binary.Write(&c.values, binary.LittleEndian, uint32(len(s)))
+	c.values.WriteString(s)
+}
+
+// physicalType returns the Parquet type of the column's values
+func (c *columnChunk) physicalType() int32 {
+	switch c.column.kind {
+	case columnDouble:
+		return parquetTypeDouble
+	case columnInt64, columnTimestamp:
+		return parquetTypeInt64
+	}
+	return parquetTypeByteArray
+}
+
+// path returns the path of the column's leaf in the schema
+func (c *columnChunk) path() []string {
+	if c.column.kind == columnStringList {
+		return []string{c.column.name, "list", "element"}
+	}
+	return []string{c.column.name}
+}
+
+// maxLevels returns the maximum definition and repetition levels of the column
+func (c *columnChunk) maxLevels() (maxDef, maxRep int) {
+	switch c.column.kind {
+	case columnOptionalString:
+		return 1, 0
+	case columnStringList:
+		return 3, 1
+	}
+	return 0, 0
+}
+
+// writePage writes the column as a single Snappy compressed data page, returning its uncompressed and compressed
+// sizes including the page header
+func (c *columnChunk) writePage(w *bytes.Buffer) (uncompressed, compressed int64) {
+	var body bytes.Buffer
+	maxDef, maxRep := c.maxLevels()
+	if maxRep > 0 {
+		body.Write(encodeLevels(c.reps, maxRep))
+	}
+	if maxDef > 0 {
+		body.Write(encodeLevels(c.defs, maxDef))
+	}
+	body.Write(c.values.Bytes())
+	data := snappy.Encode(nil, body.Bytes())
+
+	header := &thriftWriter{}
+	header.beginStruct()
+	header.i32(1, parquetDataPage)
+	header.i32(2, int32(body.Len()))
+	header.i32(3, int32(len(data)))
+	header.structField(5)
+	header.i32(1, int32(c.count))
+	header.i32(2, parquetEncodingPlain)
+	header.i32(3, parquetEncodingRLE)
+	header.i32(4, parquetEncodingRLE)
+	header.endStruct()
+	header.endStruct()
+
+	w.Write(header.buf.Bytes())
+	w.Write(data)
+	return int64(header.buf.Len() + body.Len()), int64(header.buf.Len() + len(data))
+}
+
+// encodeLevels encodes levels with the RLE/bit-packing hybrid encoding, using RLE runs only, prefixed with the
+// length of the encoded data as data page v1 requires
+func encodeLevels(levels []uint8, maxLevel int) []byte {
+	byteWidth := (bits.Len(uint(maxLevel)) + 7) / 8
+
+	var runs bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		runs.Write(binary.AppendUvarint(nil, uint64(j-i)<<1))
+		value := make([]byte, byteWidth)
+		value[0] = levels[i]
+		runs.Write(value)
+		i = j
+	}
+
+	out := binary.LittleEndian.AppendUint32(nil, uint32(runs.Len()))
+	return append(out, runs.Bytes()...)
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Parquet metadata structures with the Thrift compact protocol
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // ID of the last field written in each open struct
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// fieldHeader writes a field header, as a delta from the previous field ID when possible
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+// varint writes a zigzag encoded varint
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64((v<<1)^(v>>63))))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.listString(s)
+}
+
+// structField starts a struct field, to be closed with endStruct
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// list starts a list field of size elements, written with listI32, listString or beginStruct/endStruct
+func (t *thriftWriter) list(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.buf.Write(binary.AppendUvarint(nil, uint64(size)))
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) listString(s string) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buf.WriteString(s)
+}
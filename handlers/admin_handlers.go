@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"ripple/db"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminHandler handles HTTP requests for administrative operations
+type AdminHandler struct {
+	metricsRepo db.MetricsStore
+	timezones   *db.Timezones
+}
+
+// NewAdminHandler creates a new admin handler computing daily spend in the given reporting timezones
+func NewAdminHandler(metricsRepo db.MetricsStore, timezones *db.Timezones) *AdminHandler {
+	return &AdminHandler{
+		metricsRepo: metricsRepo,
+		timezones:   timezones,
+	}
+}
+
+// RegisterRoutes registers the admin routes
+func (h *AdminHandler) RegisterRoutes(router *mux.Router) {
+	adminRouter := router.PathPrefix("/api/v1/admin").Subrouter()
+
+	adminRouter.HandleFunc("/metrics/dry_run", h.DryRunMetrics).Methods("POST")
+}
+
+// DryRunMetrics handles POST /api/v1/admin/metrics/dry_run
+func (h *AdminHandler) DryRunMetrics(w http.ResponseWriter, r *http.Request) {
+	result, err := metricsRepoFor(r.Context(), h.metricsRepo).DryRun(r.Context(), h.timezones)
+	if err != nil {
+		http.Error(w, "Failed to run metrics aggregation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("only_changes") == "true" {
+		diffs := []db.MetricsDiff{}
+		for _, d := range result.Diffs {
+			if d.Status != db.DiffUnchanged {
+				diffs = append(diffs, d)
+			}
+		}
+		result.Diffs = diffs
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
@@ -1,55 +1,188 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"ripple/db"
+	"ripple/ingest"
 	"ripple/models"
+	"ripple/policy"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	defaultCompareWindow = 7 * 24 * time.Hour
+	maxCompareWindow     = 90 * 24 * time.Hour
+
+	defaultTimeSeriesWindow = 24 * time.Hour
+	maxTimeSeriesWindow     = 90 * 24 * time.Hour
+	defaultTimeSeriesBucket = "1h"
+	maxTimeSeriesPoints     = 1000
+
+	defaultAdoptionWindow = 30 * 24 * time.Hour
+	defaultAdoptionBucket = "1d"
+
+	defaultHistogramWindow = 7 * 24 * time.Hour
+	maxHistogramWindow     = 90 * 24 * time.Hour
+	maxHistogramBuckets    = 50
+)
+
+var (
+	// timeSeriesMetrics are the metrics a time series can be computed for
+	timeSeriesMetrics = []string{models.TimeSeriesRuns, models.TimeSeriesCost, models.TimeSeriesLatency, models.TimeSeriesSuccessRate}
+	// timeSeriesBuckets are the supported bucket sizes, which divide a day so that buckets align on midnight UTC
+	timeSeriesBuckets = map[string]time.Duration{
+		"1m": time.Minute, "5m": 5 * time.Minute, "15m": 15 * time.Minute, "30m": 30 * time.Minute,
+		"1h": time.Hour, "3h": 3 * time.Hour, "6h": 6 * time.Hour, "12h": 12 * time.Hour, "1d": 24 * time.Hour,
+	}
+	// defaultHistogramBounds are the upper bounds of the latency histogram buckets, in seconds
+	defaultHistogramBounds = []float64{1, 2, 5, 10, 30, 60, 120, 300, 600}
+)
+
 // AgentHandler handles HTTP requests for agent operations
 type AgentHandler struct {
-	repo *db.AgentRepository
+	repo         db.AgentStore
+	traceLinks   TraceLinkTemplates
+	schemas      *ingest.RunSchemaRegistry
+	maxBatchRuns int
+	// requireIngestTokens rejects runs written without an ingest token
+	requireIngestTokens bool
+	policy              policy.Policy
+	// buffer, when set, writes the single runs of the default store in batches, see SetRunBuffer
+	buffer *ingest.RunBuffer
 }
 
-// NewAgentHandler creates a new agent handler
-func NewAgentHandler(repo *db.AgentRepository) *AgentHandler {
+// NewAgentHandler creates a new agent handler. Run batches larger than maxBatchRuns are rejected, and so are runs
+// written without an ingest token when requireIngestTokens is set. The policy authorizes every request before the
+// repository is called.
+func NewAgentHandler(repo db.AgentStore, traceLinks TraceLinkTemplates, maxBatchRuns int, requireIngestTokens bool, p policy.Policy) *AgentHandler {
 	return &AgentHandler{
-		repo: repo,
+		repo:                repo,
+		traceLinks:          traceLinks,
+		schemas:             ingest.NewRunSchemaRegistry(),
+		maxBatchRuns:        maxBatchRuns,
+		requireIngestTokens: requireIngestTokens,
+		policy:              p,
 	}
 }
 
+// SetRunBuffer writes the runs sent one per request through a write-behind buffer, which accepts them with 202
+// Accepted once their version was found and they were logged, and stores them in batches. Runs of the databases of
+// tenants and regions are stored right away.
+func (h *AgentHandler) SetRunBuffer(buffer *ingest.RunBuffer) {
+	h.buffer = buffer
+}
+
 // RegisterRoutes registers the agent routes
 func (h *AgentHandler) RegisterRoutes(router *mux.Router) {
 	// Agent routes
 	router.HandleFunc("/api/v1/agents", h.ListAgents).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}", h.GetAgent).Methods("GET")
 	router.HandleFunc("/api/v1/agents/{name}/register", h.RegisterAgent).Methods("POST")
+	router.HandleFunc("/api/v1/agents/{agentId}/archive", h.ArchiveAgent).Methods("POST")
+	router.HandleFunc("/api/v1/agents/{agentId}/unarchive", h.UnarchiveAgent).Methods("POST")
+	router.HandleFunc("/api/v1/agents/{agentId}/signing_secret", h.RotateSigningSecret).Methods("POST")
 
 	// Agent version routes
 	router.HandleFunc("/api/v1/agents/{agentId}/versions", h.AddAgentVersion).Methods("POST")
 	router.HandleFunc("/api/v1/agents/{agentId}/versions", h.GetAgentVersions).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/versions/compare", h.CompareAgentVersions).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/versions/adoption", h.GetVersionAdoption).Methods("GET")
 	router.HandleFunc("/api/v1/agents/{agentId}/versions/{version}", h.GetAgentVersion).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/versions/{version}/latency_histogram", h.GetLatencyHistogram).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/versions/{version}/timeseries", h.GetVersionTimeSeries).Methods("GET")
 
 	// Agent run routes
 	router.HandleFunc("/api/v1/agents/{agentId}/versions/{version}/runs", h.AddAgentRun).Methods("POST")
 	router.HandleFunc("/api/v1/agents/{agentId}/versions/{version}/runs", h.GetAgentVersionRuns).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/tools/stats", h.GetToolStats).Methods("GET")
 	router.HandleFunc("/api/v1/agents/{agentId}/runs", h.GetAgentRuns).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/runs/export", h.ExportAgentRuns).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/runs/{runId}", h.GetAgentRun).Methods("GET")
+	router.HandleFunc("/api/v1/runs/batch", h.AddRunBatch).Methods("POST")
+	router.HandleFunc("/api/v1/import", h.Import).Methods("POST")
+	router.HandleFunc("/api/v1/agents/{agentId}/runs:tail", h.TailAgentRuns).Methods("GET")
+
+	// Run payload schema routes
+	router.HandleFunc("/api/v1/schemas/runs", h.ListRunSchemas).Methods("GET")
 }
 
 // ListAgents handles GET /api/v1/agents
 func (h *AgentHandler) ListAgents(w http.ResponseWriter, r *http.Request) {
-	agents, err := h.repo.ListAgents()
+	listOpts, err := parseListOptions(r, models.Agent{}, agentSortFields)
+	if err == nil {
+		listOpts.IncludeArchived, err = parseIncludeArchived(r)
+	}
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	listOpts.Project = r.URL.Query().Get("project")
+	listOpts.Team = r.URL.Query().Get("team")
+	if !h.can(w, r, policy.Read, policy.Resource{Project: listOpts.Project}) {
+		return
+	}
+
+	agents, err := agentRepoFor(r.Context(), h.repo).ListAgents(r.Context(), listOpts)
 	if err != nil {
 		http.Error(w, "Failed to retrieve agents: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := setAgentOwners(r.Context(), h.repo, agents); err != nil {
+		http.Error(w, "Failed to retrieve teams: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := selectFields(agents, listOpts.Fields)
+	if err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSONWithETag(w, r, http.StatusOK, data)
+}
+
+// GetAgent handles GET /api/v1/agents/{agentId}
+func (h *AgentHandler) GetAgent(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+	agent, err := repo.GetAgentByID(r.Context(), agentID)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if agent.Team != "" {
+		// The owner is left out when the team no longer exists
+		if agent.Owner, err = repo.GetTeam(r.Context(), agent.Team); err != nil && err.Error() != "team not found" {
+			http.Error(w, "Failed to retrieve team: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	respondJSON(w, http.StatusOK, agents)
+	respondJSONWithETag(w, r, http.StatusOK, agent)
 }
 
 // RegisterAgent handles POST /api/v1/agents/{name}/register
@@ -59,7 +192,7 @@ func (h *AgentHandler) RegisterAgent(w http.ResponseWriter, r *http.Request) {
 
 	var req models.RegisterAgentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		respondBodyError(w, "Invalid request body", err)
 		return
 	}
 
@@ -70,18 +203,85 @@ func (h *AgentHandler) RegisterAgent(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Agent name in URL and request body must match", http.StatusBadRequest)
 		return
 	}
+	if !h.can(w, r, policy.Write, policy.Resource{Project: req.Project}) {
+		return
+	}
+	if !inRegion(w, r, req.Project) {
+		return
+	}
 
+	secret, err := models.NewSigningSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate signing secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	agent := &models.Agent{
-		Name:    req.Name,
-		Project: req.Project,
+		Name:          req.Name,
+		Project:       req.Project,
+		Team:          req.Team,
+		SigningSecret: secret,
 	}
 
-	if err := h.repo.CreateAgent(agent); err != nil {
+	repo := agentRepoFor(r.Context(), h.repo)
+	if req.Team != "" {
+		owner, err := repo.GetTeam(r.Context(), req.Team)
+		if err != nil {
+			respondUnknownTeam(w, req.Team, err)
+			return
+		}
+		agent.Owner = owner
+	}
+	if !withinQuotas(w, r, 1, 0) {
+		return
+	}
+	if err := repo.CreateAgent(r.Context(), agent); err != nil {
 		http.Error(w, "Failed to create agent: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, agent)
+	token, err := issueIngestToken(r.Context(), repo, agent.ID, "")
+	if err != nil {
+		http.Error(w, "Failed to issue ingest token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	agent.IngestToken = token
+	agent.IssuedSigningSecret = secret
+
+	respondCredentials(w, http.StatusCreated, agent)
+}
+
+// ArchiveAgent handles POST /api/v1/agents/{agentId}/archive
+func (h *AgentHandler) ArchiveAgent(w http.ResponseWriter, r *http.Request) {
+	h.setAgentArchived(w, r, true)
+}
+
+// UnarchiveAgent handles POST /api/v1/agents/{agentId}/unarchive
+func (h *AgentHandler) UnarchiveAgent(w http.ResponseWriter, r *http.Request) {
+	h.setAgentArchived(w, r, false)
+}
+
+// setAgentArchived archives or unarchives the agent of the request and responds with the updated agent
+func (h *AgentHandler) setAgentArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Write, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	agent, err := agentRepoFor(r.Context(), h.repo).SetAgentArchived(r.Context(), agentID, archived)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to update agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, agent)
 }
 
 // AddAgentVersion handles POST /api/v1/agents/{agentId}/versions
@@ -94,10 +294,13 @@ func (h *AgentHandler) AddAgentVersion(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
 		return
 	}
+	if !h.can(w, r, policy.WriteVersions, policy.Resource{AgentID: agentID}) {
+		return
+	}
 
 	var req models.RegisterAgentVersionRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		respondBodyError(w, "Invalid request body", err)
 		return
 	}
 
@@ -110,12 +313,20 @@ func (h *AgentHandler) AddAgentVersion(w http.ResponseWriter, r *http.Request) {
 		Deployment: req.Deployment,
 	}
 
-	if err := h.repo.CreateAgentVersion(version); err != nil {
+	repo := agentRepoFor(r.Context(), h.repo)
+	if err := repo.CreateAgentVersion(r.Context(), version); err != nil {
 		http.Error(w, "Failed to create agent version: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, version)
+	token, err := issueIngestToken(r.Context(), repo, agentID, version.Version)
+	if err != nil {
+		http.Error(w, "Failed to issue ingest token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	version.IngestToken = token
+
+	respondCredentials(w, http.StatusCreated, version)
 }
 
 // GetAgentVersions handles GET /api/v1/agents/{agentId}/versions
@@ -128,14 +339,23 @@ func (h *AgentHandler) GetAgentVersions(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
 		return
 	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	listOpts, err := parseListOptions(r, models.AgentVersion{}, versionSortFields)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	versions, err := h.repo.GetAgentVersions(agentID)
+	versions, err := agentRepoFor(r.Context(), h.repo).GetAgentVersions(r.Context(), agentID, listOpts)
 	if err != nil {
 		http.Error(w, "Failed to retrieve agent versions: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, versions)
+	respondJSONFields(w, http.StatusOK, versions, listOpts.Fields)
 }
 
 // AddAgentRun handles POST /api/v1/agents/{agentId}/versions/{version}/runs
@@ -150,97 +370,343 @@ func (h *AgentHandler) AddAgentRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to decode as a batch request first
-	var batchReq models.RegisterAgentRunBatchRequest
-	if err := json.NewDecoder(r.Body).Decode(&batchReq); err != nil || len(batchReq.Runs) == 0 {
-		// If it's not a batch request or empty batch, reset the body and try as a single request
-		r.Body.Close()
-		// Create a new reader from the raw request body
-		if r.GetBody != nil {
-			r.Body, _ = r.GetBody()
-		} else {
-			http.Error(w, "Failed to reset request body for single run processing", http.StatusBadRequest)
-			return
-		}
+	repo := agentRepoFor(r.Context(), h.repo)
+	token, ok := h.authorizeIngest(w, r, repo)
+	if !ok {
+		return
+	}
+	if !authorize(w, h.policy, requestPrincipal(r, token), policy.WriteRuns, policy.Resource{AgentID: agentID, Version: versionStr}) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondBodyError(w, "Failed to read request body", err)
+		return
+	}
 
+	var envelope ingest.PayloadEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schemaVersion := ingest.DefaultRunSchemaVersion
+	if envelope.SchemaVersion != nil {
+		schemaVersion = *envelope.SchemaVersion
+	}
+	deprecated := map[int]bool{}
+
+	if len(envelope.Runs) == 0 {
 		// Process as a single run
-		var req models.RegisterAgentRunRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		req, schema, err := h.schemas.Decode(schemaVersion, body)
+		if err != nil {
 			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		if schema.Deprecated {
+			deprecated[schema.Version] = true
+		}
 
-		// Parse the created time
-		var createdTime time.Time
-		if req.Created != "" {
-			createdTime, err = time.Parse(time.RFC3339, req.Created)
-			if err != nil {
-				createdTime = time.Now()
-			}
+		run, err := ingest.NewAgentRun(agentID, versionStr, req)
+		if err != nil {
+			http.Error(w, "Invalid run: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !withinQuotas(w, r, 0, 1) {
+			return
+		}
+		status := http.StatusCreated
+		if h.buffer != nil && requestDatabase(r.Context()) == nil {
+			status = http.StatusAccepted
+			err = h.bufferRun(r.Context(), repo, run)
 		} else {
-			createdTime = time.Now()
+			err = repo.CreateAgentRun(r.Context(), run)
+		}
+		if err != nil {
+			if err.Error() == "run with this ID already exists" {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err.Error() == "run failed the schema validation of the database" {
+				http.Error(w, "Invalid run: "+err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			if errors.Is(err, ingest.ErrBufferFull) || errors.Is(err, ingest.ErrBufferClosed) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Failed to create agent run: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, "Failed to create agent run: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		run.TraceLinks = h.traceLinks.Links(run.TraceID, run.SpanID)
+		warnDeprecatedSchemas(w, h.schemas, deprecated)
+		respondJSON(w, status, run)
+		return
+	}
+
+	if h.maxBatchRuns > 0 && len(envelope.Runs) > h.maxBatchRuns {
+		http.Error(w, fmt.Sprintf("Batch of %d runs exceeds the limit of %d runs, split it into smaller batches", len(envelope.Runs), h.maxBatchRuns), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Process as a batch request, runs use the batch schema version unless they set their own
+	batchOpts, err := parseBatchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	runs := make([]*models.AgentRun, len(envelope.Runs))
+	for i, raw := range envelope.Runs {
+		runSchemaVersion := schemaVersion
+		var runEnvelope ingest.PayloadEnvelope
+		if err := json.Unmarshal(raw, &runEnvelope); err == nil && runEnvelope.SchemaVersion != nil {
+			runSchemaVersion = *runEnvelope.SchemaVersion
 		}
 
-		run := &models.AgentRun{
-			AgentID:   agentID,
-			Version:   versionStr,
-			Created:   createdTime,
-			Status:    req.Status,
-			TimeTaken: req.TimeTaken,
-			Initiator: req.Initiator,
-			Tools:     req.Tools,
-			Cost:      req.Cost,
-			Models:    req.Models,
-			RunID:     req.RunID,
-			TaskID:    req.TaskID,
+		req, schema, err := h.schemas.Decode(runSchemaVersion, raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid run at index %d: %s", i, err), http.StatusBadRequest)
+			return
+		}
+		if schema.Deprecated {
+			deprecated[schema.Version] = true
 		}
 
-		if err := h.repo.CreateAgentRun(run); err != nil {
-			http.Error(w, "Failed to create agent run: "+err.Error(), http.StatusInternalServerError)
+		runs[i], err = ingest.NewAgentRun(agentID, versionStr, req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid run at index %d: %s", i, err), http.StatusBadRequest)
 			return
 		}
+	}
+
+	if !withinQuotas(w, r, 0, int64(len(runs))) {
+		return
+	}
+	if err := repo.CreateAgentRunBatch(r.Context(), runs, batchOpts); err != nil {
+		if !respondBatchError(w, runs, err) {
+			http.Error(w, "Failed to create agent runs batch: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	for _, run := range runs {
+		run.TraceLinks = h.traceLinks.Links(run.TraceID, run.SpanID)
+	}
+
+	warnDeprecatedSchemas(w, h.schemas, deprecated)
+	respondJSON(w, http.StatusCreated, runs)
+}
+
+// AddRunBatch handles POST /api/v1/runs/batch
+func (h *AgentHandler) AddRunBatch(w http.ResponseWriter, r *http.Request) {
+	repo := agentRepoFor(r.Context(), h.repo)
+	token, ok := h.authorizeIngest(w, r, repo)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondBodyError(w, "Failed to read request body", err)
+		return
+	}
 
-		respondJSON(w, http.StatusCreated, run)
+	var batch struct {
+		SchemaVersion *int             `json:"schema_version"`
+		Runs          []ingest.Message `json:"runs"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(batch.Runs) == 0 {
+		http.Error(w, "Batch has no runs", http.StatusBadRequest)
+		return
+	}
+	batchOpts, err := parseBatchOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if h.maxBatchRuns > 0 && len(batch.Runs) > h.maxBatchRuns {
+		http.Error(w, fmt.Sprintf("Batch of %d runs exceeds the limit of %d runs, split it into smaller batches", len(batch.Runs), h.maxBatchRuns), http.StatusUnprocessableEntity)
+		return
+	}
+
+	schemaVersion := ingest.DefaultRunSchemaVersion
+	if batch.SchemaVersion != nil {
+		schemaVersion = *batch.SchemaVersion
+	}
+	deprecated := map[int]bool{}
+
+	principal := requestPrincipal(r, token)
+	agentIDs := map[string]primitive.ObjectID{}
+	runs := make([]*models.AgentRun, len(batch.Runs))
+	for i, msg := range batch.Runs {
+		if msg.Version == "" || len(msg.Run) == 0 {
+			http.Error(w, fmt.Sprintf("Invalid run at index %d: version and run are required", i), http.StatusBadRequest)
+			return
+		}
+
+		// Runs use the batch schema version unless the entry or the run set their own
+		runSchemaVersion := schemaVersion
+		if msg.SchemaVersion != nil {
+			runSchemaVersion = *msg.SchemaVersion
+		}
+		var runEnvelope ingest.PayloadEnvelope
+		if err := json.Unmarshal(msg.Run, &runEnvelope); err == nil && runEnvelope.SchemaVersion != nil {
+			runSchemaVersion = *runEnvelope.SchemaVersion
+		}
 
-	// Process as a batch request
-	runs := make([]*models.AgentRun, len(batchReq.Runs))
-	for i, reqRun := range batchReq.Runs {
-		// Parse the created time
-		var createdTime time.Time
-		if reqRun.Created != "" {
-			createdTime, err = time.Parse(time.RFC3339, reqRun.Created)
-			if err != nil {
-				createdTime = time.Now()
+		req, schema, err := h.schemas.Decode(runSchemaVersion, msg.Run)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid run at index %d: %s", i, err), http.StatusBadRequest)
+			return
+		}
+		if schema.Deprecated {
+			deprecated[schema.Version] = true
+		}
+
+		agentID, err := resolveBatchAgent(r.Context(), repo, msg, agentIDs)
+		if err != nil {
+			if err.Error() == "agent not found" {
+				http.Error(w, fmt.Sprintf("Unknown agent at index %d: %s", i, err), http.StatusUnprocessableEntity)
+				return
 			}
-		} else {
-			createdTime = time.Now()
+			if err.Error() == "invalid agent ID format" || err.Error() == "agent or agent_id is required" {
+				http.Error(w, fmt.Sprintf("Invalid run at index %d: %s", i, err), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to resolve agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.policy.Can(principal, policy.WriteRuns, policy.Resource{AgentID: agentID, Version: msg.Version}); err != nil {
+			http.Error(w, fmt.Sprintf("Forbidden run at index %d: %s", i, err), http.StatusForbidden)
+			return
 		}
 
-		runs[i] = &models.AgentRun{
-			AgentID:   agentID,
-			Version:   versionStr,
-			Created:   createdTime,
-			Status:    reqRun.Status,
-			TimeTaken: reqRun.TimeTaken,
-			Initiator: reqRun.Initiator,
-			Tools:     reqRun.Tools,
-			Cost:      reqRun.Cost,
-			Models:    reqRun.Models,
-			RunID:     reqRun.RunID,
-			TaskID:    reqRun.TaskID,
+		runs[i], err = ingest.NewAgentRun(agentID, msg.Version, req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid run at index %d: %s", i, err), http.StatusBadRequest)
+			return
 		}
 	}
 
-	if err := h.repo.CreateAgentRunBatch(runs); err != nil {
+	if !withinQuotas(w, r, 0, int64(len(runs))) {
+		return
+	}
+	if err := repo.CreateAgentRunBatch(r.Context(), runs, batchOpts); err != nil {
+		if respondBatchError(w, runs, err) {
+			return
+		}
+		if err.Error() == "agent not found" || err.Error() == "version not found for this agent" {
+			http.Error(w, "Failed to create agent runs batch: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		http.Error(w, "Failed to create agent runs batch: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	for _, run := range runs {
+		run.TraceLinks = h.traceLinks.Links(run.TraceID, run.SpanID)
+	}
+
+	warnDeprecatedSchemas(w, h.schemas, deprecated)
 	respondJSON(w, http.StatusCreated, runs)
 }
 
+// runPreparer is implemented by the stores readying runs before they are stored, redacting them among others
+type runPreparer interface {
+	PrepareRuns(ctx context.Context, runs []*models.AgentRun) error
+}
+
+// bufferRun adds a run to the write-behind buffer once its version is found, so that runs of unknown versions are
+// rejected rather than dropped when the buffer is written. Runs are prepared by the store first when it can, so
+// that the personal data redacted from stored runs is not written to the write-ahead log of the buffer either. The
+// buffer stores them without redacting them again.
+func (h *AgentHandler) bufferRun(ctx context.Context, repo db.AgentStore, run *models.AgentRun) error {
+	if preparer, ok := repo.(runPreparer); ok {
+		if err := preparer.PrepareRuns(ctx, []*models.AgentRun{run}); err != nil {
+			return err
+		}
+		return h.buffer.Add(run)
+	}
+
+	version, err := repo.GetAgentVersion(ctx, run.AgentID, run.Version)
+	if err != nil {
+		return err
+	}
+	run.VersionID = version.ID
+	return h.buffer.Add(run)
+}
+
+// batchFailure is the response to a batch of runs some runs of which were not stored
+type batchFailure struct {
+	Error string `json:"error"`
+	// Runs are the stored runs of the batch
+	Runs   []*models.AgentRun  `json:"runs"`
+	Failed []db.BatchItemError `json:"failed"`
+}
+
+// respondBatchError responds to a batch of runs whose insert reported the runs that were not stored, with the
+// stored runs and the failures by index: 207 when some runs were stored, 409 when none were as all were sent before,
+// 422 otherwise. It reports whether err was such an error.
+func respondBatchError(w http.ResponseWriter, runs []*models.AgentRun, err error) bool {
+	var batchErr *db.BatchError
+	if !errors.As(err, &batchErr) {
+		return false
+	}
+
+	failed := map[int]bool{}
+	for _, item := range batchErr.Items {
+		failed[item.Index] = true
+	}
+	stored := []*models.AgentRun{}
+	for i, run := range runs {
+		if !failed[i] {
+			stored = append(stored, run)
+		}
+	}
+
+	status := http.StatusMultiStatus
+	if len(stored) == 0 && batchErr.Conflicts() {
+		status = http.StatusConflict
+	} else if len(stored) == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	respondJSON(w, status, batchFailure{Error: err.Error(), Runs: stored, Failed: batchErr.Items})
+	return true
+}
+
+// resolveBatchAgent returns the ID of the agent of a batch entry, identified by agent ID or else by agent name.
+// Agents looked up by name are cached in agentIDs for the rest of the batch.
+func resolveBatchAgent(ctx context.Context, repo db.AgentStore, msg ingest.Message, agentIDs map[string]primitive.ObjectID) (primitive.ObjectID, error) {
+	if msg.AgentID != "" {
+		id, err := primitive.ObjectIDFromHex(msg.AgentID)
+		if err != nil {
+			return primitive.NilObjectID, errors.New("invalid agent ID format")
+		}
+		return id, nil
+	}
+	if msg.Agent == "" {
+		return primitive.NilObjectID, errors.New("agent or agent_id is required")
+	}
+
+	if id, ok := agentIDs[msg.Agent]; ok {
+		return id, nil
+	}
+	agent, err := repo.GetAgentByName(ctx, msg.Agent)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	agentIDs[msg.Agent] = agent.ID
+	return agent.ID, nil
+}
+
 // GetAgentVersionRuns handles GET /api/v1/agents/{agentId}/versions/{version}/runs
 func (h *AgentHandler) GetAgentVersionRuns(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -252,17 +718,30 @@ func (h *AgentHandler) GetAgentVersionRuns(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
 		return
 	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
 
-	runs, err := h.repo.GetAgentVersionRuns(agentID, versionStr)
+	listOpts, err := parseListOptions(r, models.AgentRun{}, runSortFields)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	runs, err := agentRepoFor(r.Context(), h.repo).GetAgentVersionRuns(r.Context(), agentID, versionStr, listOpts)
 	if err != nil {
 		http.Error(w, "Failed to retrieve agent runs: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.traceLinks.addRunTraceLinks(runs)
 
-	respondJSON(w, http.StatusOK, runs)
+	respondJSONFields(w, http.StatusOK, runs, listOpts.Fields)
 }
 
 // GetAgentRuns handles GET /api/v1/agents/{agentId}/runs
+//
+// The status and version query parameters only list the runs with that status or of that version, and limit caps
+// the number of runs listed.
 func (h *AgentHandler) GetAgentRuns(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	agentIDStr := vars["agentId"]
@@ -272,14 +751,43 @@ func (h *AgentHandler) GetAgentRuns(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
 		return
 	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	listOpts, err := parseListOptions(r, models.AgentRun{}, runSortFields)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if limitStr := query.Get("limit"); limitStr != "" {
+		listOpts.Limit, err = strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || listOpts.Limit <= 0 {
+			http.Error(w, "Invalid limit, expected a positive number", http.StatusBadRequest)
+			return
+		}
+	}
 
-	runs, err := h.repo.GetAgentRuns(agentID)
+	repo := agentRepoFor(r.Context(), h.repo)
+	var runs []models.AgentRun
+	if version, status := query.Get("version"), query.Get("status"); version != "" || status != "" {
+		runQuery := models.RunQuery{AgentID: &agentID, Version: version}
+		if status != "" {
+			runQuery.Statuses = []string{status}
+		}
+		runs, err = repo.QueryRuns(r.Context(), runQuery, time.Time{}, time.Time{}, listOpts)
+	} else {
+		runs, err = repo.GetAgentRuns(r.Context(), agentID, listOpts)
+	}
 	if err != nil {
 		http.Error(w, "Failed to retrieve agent runs: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.traceLinks.addRunTraceLinks(runs)
 
-	respondJSON(w, http.StatusOK, runs)
+	respondJSONFields(w, http.StatusOK, runs, listOpts.Fields)
 }
 
 // GetAgentVersion handles GET /api/v1/agents/{agentId}/versions/{version}
@@ -293,8 +801,11 @@ func (h *AgentHandler) GetAgentVersion(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
 		return
 	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
 
-	version, err := h.repo.GetAgentVersion(agentID, versionStr)
+	version, err := agentRepoFor(r.Context(), h.repo).GetAgentVersion(r.Context(), agentID, versionStr)
 	if err != nil {
 		if err.Error() == "version not found for this agent" {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -307,9 +818,356 @@ func (h *AgentHandler) GetAgentVersion(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, version)
 }
 
+// GetAgentRun handles GET /api/v1/agents/{agentId}/runs/{runId}
+func (h *AgentHandler) GetAgentRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	agentID, err := primitive.ObjectIDFromHex(vars["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	runID, err := strconv.ParseInt(vars["runId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid run ID format", http.StatusBadRequest)
+		return
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+
+	agent, err := repo.GetAgentByID(r.Context(), agentID)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	run, err := repo.GetAgentRun(r.Context(), agentID, runID)
+	if err != nil {
+		if err.Error() == "run not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent run: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	run.TraceLinks = h.traceLinks.Links(run.TraceID, run.SpanID)
+
+	detail := &models.AgentRunDetail{AgentRun: *run, Agent: agent, Steps: []models.RunStep{}}
+
+	// The version is left out when it is not registered
+	detail.AgentVersion, err = repo.GetAgentVersion(r.Context(), agentID, run.Version)
+	if err != nil && err.Error() != "version not found for this agent" {
+		http.Error(w, "Failed to retrieve agent version: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if run.TraceID != "" {
+		if detail.Steps, err = repo.GetRunSteps(r.Context(), run.TraceID); err != nil {
+			http.Error(w, "Failed to retrieve run steps: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, detail)
+}
+
+// CompareAgentVersions handles GET /api/v1/agents/{agentId}/versions/compare
+func (h *AgentHandler) CompareAgentVersions(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	query := r.URL.Query()
+	base, target := query.Get("from"), query.Get("to")
+	if base == "" || target == "" {
+		http.Error(w, "Invalid query parameters: from and to versions are required", http.StatusBadRequest)
+		return
+	}
+
+	window, err := parseWindow(r, defaultCompareWindow, maxCompareWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+	to := time.Now().UTC()
+	from := to.Add(-window)
+
+	stats := make([]*models.VersionStats, 2)
+	for i, version := range []string{base, target} {
+		stats[i], err = repo.GetVersionStats(r.Context(), agentID, version, from, to)
+		if err != nil {
+			if err.Error() == "version not found for this agent" {
+				http.Error(w, fmt.Sprintf("version %q not found for this agent", version), http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to compute version statistics: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, models.NewVersionComparison(agentID, from, to, stats[0], stats[1]))
+}
+
+// GetVersionTimeSeries handles GET /api/v1/agents/{agentId}/versions/{version}/timeseries
+func (h *AgentHandler) GetVersionTimeSeries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID, err := primitive.ObjectIDFromHex(vars["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = models.TimeSeriesRuns
+	}
+	if !contains(timeSeriesMetrics, metric) {
+		http.Error(w, fmt.Sprintf("Invalid metric %q, expected one of %s", metric, strings.Join(timeSeriesMetrics, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	bucketStr := query.Get("bucket")
+	if bucketStr == "" {
+		bucketStr = defaultTimeSeriesBucket
+	}
+	bucket, ok := timeSeriesBuckets[bucketStr]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid bucket %q, expected one of 1m, 5m, 15m, 30m, 1h, 3h, 6h, 12h, 1d", bucketStr), http.StatusBadRequest)
+		return
+	}
+
+	window, err := parseWindow(r, defaultTimeSeriesWindow, maxTimeSeriesWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if window/bucket > maxTimeSeriesPoints {
+		http.Error(w, fmt.Sprintf("Invalid query parameters: the window covers more than %d buckets, use a larger bucket", maxTimeSeriesPoints), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	buckets, err := agentRepoFor(r.Context(), h.repo).GetVersionTimeBuckets(r.Context(), agentID, vars["version"], bucket, from, to)
+	if err != nil {
+		if err.Error() == "version not found for this agent" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to compute time series: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.TimeSeries{
+		AgentID: agentID,
+		Version: vars["version"],
+		Metric:  metric,
+		Bucket:  bucketStr,
+		From:    from,
+		To:      to,
+		Points:  db.BuildTimeSeries(metric, bucket, from, to, buckets),
+	})
+}
+
+// GetLatencyHistogram handles GET /api/v1/agents/{agentId}/versions/{version}/latency_histogram
+func (h *AgentHandler) GetLatencyHistogram(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID, err := primitive.ObjectIDFromHex(vars["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	bounds := defaultHistogramBounds
+	if v := r.URL.Query().Get("buckets"); v != "" {
+		if bounds, err = parseHistogramBounds(v); err != nil {
+			http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	window, err := parseWindow(r, defaultHistogramWindow, maxHistogramWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	counts, err := agentRepoFor(r.Context(), h.repo).GetLatencyHistogram(r.Context(), agentID, vars["version"], bounds, from, to)
+	if err != nil {
+		if err.Error() == "version not found for this agent" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to compute latency histogram: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	buckets, runs := db.BuildLatencyHistogram(bounds, counts)
+	respondJSON(w, http.StatusOK, &models.LatencyHistogram{
+		AgentID: agentID,
+		Version: vars["version"],
+		From:    from,
+		To:      to,
+		Runs:    runs,
+		Buckets: buckets,
+	})
+}
+
+// parseHistogramBounds parses the comma separated upper bounds of latency histogram buckets, in seconds, which
+// must be positive and increasing
+func parseHistogramBounds(v string) ([]float64, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) > maxHistogramBuckets {
+		return nil, fmt.Errorf("at most %d buckets are supported", maxHistogramBuckets)
+	}
+
+	bounds := make([]float64, 0, len(parts))
+	for _, part := range parts {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsInf(bound, 0) || math.IsNaN(bound) || bound <= 0 {
+			return nil, fmt.Errorf("invalid bucket bound %q, expected a positive number of seconds", part)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("bucket bounds must be increasing, got %q after %g", part, bounds[len(bounds)-1])
+		}
+		bounds = append(bounds, bound)
+	}
+
+	return bounds, nil
+}
+
+// GetVersionAdoption handles GET /api/v1/agents/{agentId}/versions/adoption
+func (h *AgentHandler) GetVersionAdoption(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	bucketStr := r.URL.Query().Get("bucket")
+	if bucketStr == "" {
+		bucketStr = defaultAdoptionBucket
+	}
+	bucket, ok := timeSeriesBuckets[bucketStr]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid bucket %q, expected one of 1m, 5m, 15m, 30m, 1h, 3h, 6h, 12h, 1d", bucketStr), http.StatusBadRequest)
+		return
+	}
+
+	window, err := parseWindow(r, defaultAdoptionWindow, maxTimeSeriesWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if window/bucket > maxTimeSeriesPoints {
+		http.Error(w, fmt.Sprintf("Invalid query parameters: the window covers more than %d buckets, use a larger bucket", maxTimeSeriesPoints), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	buckets, err := agentRepoFor(r.Context(), h.repo).GetAdoptionBuckets(r.Context(), agentID, bucket, from, to)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to compute version adoption: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	versions, points := db.BuildVersionAdoption(bucket, from, to, buckets)
+	respondJSON(w, http.StatusOK, &models.VersionAdoption{
+		AgentID:  agentID,
+		Bucket:   bucketStr,
+		From:     from,
+		To:       to,
+		Versions: versions,
+		Points:   points,
+	})
+}
+
+// GetToolStats handles GET /api/v1/agents/{agentId}/tools/stats
+func (h *AgentHandler) GetToolStats(w http.ResponseWriter, r *http.Request) {
+	h.getUsageStats(w, r, db.UsageFieldTools)
+}
+
+// getUsageStats responds with the usage statistics of the tools or models of the agent of the request
+func (h *AgentHandler) getUsageStats(w http.ResponseWriter, r *http.Request, field string) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	window, err := parseWindow(r, defaultUsageStatsWindow, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	stats, err := agentRepoFor(r.Context(), h.repo).GetUsageStats(r.Context(), agentID, field, version, from, to)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to compute "+field+" statistics: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.UsageStatsReport{
+		AgentID: &agentID,
+		Version: version,
+		From:    from,
+		To:      to,
+		Stats:   stats,
+	})
+}
+
 // Helper function to respond with JSON
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// respondCredentials responds like respondJSON with a document carrying credentials, which are only returned once.
+// The response is marked as not to be stored, so that IdempotencyMiddleware replays it without the credentials.
+func respondCredentials(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, status, data)
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAPIKeyGracePeriod = 24 * time.Hour
+	maxAPIKeyGracePeriod     = 30 * 24 * time.Hour
+)
+
+// APIKeyHandler handles HTTP requests for API keys
+type APIKeyHandler struct {
+	repo *db.APIKeyRepository
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(repo *db.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the API key routes
+func (h *APIKeyHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/api_keys", h.CreateAPIKey).Methods("POST")
+	router.HandleFunc("/api/v1/api_keys", h.ListAPIKeys).Methods("GET")
+	router.HandleFunc("/api/v1/api_keys/{id}", h.GetAPIKey).Methods("GET")
+	router.HandleFunc("/api/v1/api_keys/{id}", h.DeleteAPIKey).Methods("DELETE")
+	router.HandleFunc("/api/v1/api_keys/{id}/rotate", h.RotateAPIKey).Methods("POST")
+	router.HandleFunc("/api/v1/ui/api_keys/{id}/scopes", h.GetAPIKeyScopes).Methods("GET")
+}
+
+// CreateAPIKey handles POST /api/v1/api_keys
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "Invalid API key: name is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "Invalid API key: at least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !contains(models.Scopes, scope) {
+			http.Error(w, "Invalid API key: unknown scope "+scope+", expected one of "+strings.Join(models.Scopes, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Project scoped callers only create keys of their project
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil && claims.Project != "" {
+		if req.Project != "" && req.Project != claims.Project {
+			http.Error(w, "Forbidden: the API key must be scoped to project "+claims.Project, http.StatusForbidden)
+			return
+		}
+		req.Project = claims.Project
+	}
+
+	key, apiKey, err := models.NewAPIKey(req.Name, req.Scopes)
+	if err != nil {
+		http.Error(w, "Failed to generate API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiKey.Project = req.Project
+	if err := apiKeyRepoFor(r.Context(), h.repo).CreateAPIKey(r.Context(), apiKey); err != nil {
+		http.Error(w, "Failed to create API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiKey.Key = key
+
+	respondCredentials(w, http.StatusCreated, apiKey)
+}
+
+// ListAPIKeys handles GET /api/v1/api_keys
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := apiKeyRepoFor(r.Context(), h.repo).ListAPIKeys(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve API keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// GetAPIKey handles GET /api/v1/api_keys/{id}
+func (h *APIKeyHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := h.requestAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, apiKey)
+}
+
+// DeleteAPIKey handles DELETE /api/v1/api_keys/{id}
+//
+// The key is revoked immediately, and so is the key it replaced when it was rotated.
+func (h *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := apiKeyRepoFor(r.Context(), h.repo).DeleteAPIKey(r.Context(), id); err != nil {
+		respondAPIKeyError(w, "Failed to delete API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateAPIKey handles POST /api/v1/api_keys/{id}/rotate
+//
+// A new key is issued, and the replaced key stays valid for the grace period of the request, so that clients can
+// move to the new key without downtime. A grace period of 0 revokes the replaced key immediately.
+func (h *APIKeyHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req models.RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondBodyError(w, "Invalid request body", err)
+			return
+		}
+	}
+	grace := defaultAPIKeyGracePeriod
+	if req.GracePeriod == "0" {
+		grace = 0
+	} else if req.GracePeriod != "" {
+		if grace, err = parseDuration("grace_period", req.GracePeriod, maxAPIKeyGracePeriod); err != nil {
+			http.Error(w, "Invalid rotation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, rotated, err := models.NewAPIKey("", nil)
+	if err != nil {
+		http.Error(w, "Failed to generate API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiKey, err := apiKeyRepoFor(r.Context(), h.repo).RotateAPIKey(r.Context(), id, rotated.Hash, time.Now().Add(grace))
+	if err != nil {
+		respondAPIKeyError(w, "Failed to rotate API key", err)
+		return
+	}
+	apiKey.Key = key
+
+	respondCredentials(w, http.StatusOK, apiKey)
+}
+
+// GetAPIKeyScopes handles GET /api/v1/ui/api_keys/{id}/scopes
+//
+// The effective scopes include the scopes implied by the scopes of the key, such as read for a write key.
+func (h *APIKeyHandler) GetAPIKeyScopes(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := h.requestAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.APIKeyScopes{
+		ID:              apiKey.ID,
+		Name:            apiKey.Name,
+		Scopes:          apiKey.Scopes,
+		EffectiveScopes: apiKey.EffectiveScopes(),
+	})
+}
+
+// requestAPIKey returns the API key of the id path variable, responding with an error when it does not exist
+func (h *APIKeyHandler) requestAPIKey(w http.ResponseWriter, r *http.Request) (*models.APIKey, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	apiKey, err := apiKeyRepoFor(r.Context(), h.repo).GetAPIKey(r.Context(), id)
+	if err != nil {
+		respondAPIKeyError(w, "Failed to retrieve API key", err)
+		return nil, false
+	}
+	return apiKey, true
+}
+
+func respondAPIKeyError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "API key not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
+
+// apiKeyRepoFor returns the API key repository of the request's tenant, or the default repository. In database tenant
+// mode, keys are issued in the database of their tenant, where AuthMiddleware looks them up.
+func apiKeyRepoFor(ctx context.Context, fallback *db.APIKeyRepository) *db.APIKeyRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewAPIKeyRepository(database)
+	}
+	return fallback
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultRestoreJobsLimit = 50
+	maxRestoreJobsLimit     = 500
+	// maxArchiveListRange bounds the time range archives are listed for
+	maxArchiveListRange = 10 * 365 * 24 * time.Hour
+	// maxRestoreRange bounds the time range of a restore, which loads the archived runs back into the database
+	maxRestoreRange = 31 * 24 * time.Hour
+	// maxRestoreKeepDays bounds how long restored runs are kept
+	maxRestoreKeepDays = 90
+)
+
+// ArchiveHandler handles HTTP requests listing the archives of runs moved to cold storage, and restoring them
+type ArchiveHandler struct {
+	repo *db.ArchiveRepository
+}
+
+// NewArchiveHandler creates a new archive handler
+func NewArchiveHandler(repo *db.ArchiveRepository) *ArchiveHandler {
+	return &ArchiveHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the archive routes
+func (h *ArchiveHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/archives", h.ListArchives).Methods("GET")
+	router.HandleFunc("/api/v1/archive_restores", h.CreateRestore).Methods("POST")
+	router.HandleFunc("/api/v1/archive_restores", h.ListRestores).Methods("GET")
+	router.HandleFunc("/api/v1/archive_restores/{restoreId}", h.GetRestore).Methods("GET")
+}
+
+// ListArchives handles GET /api/v1/archives
+//
+// The archives holding runs created in the from and to, or range, query parameters are listed, oldest first, or
+// all archives without them.
+func (h *ArchiveHandler) ListArchives(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r, maxArchiveListRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	archives, err := archiveRepoFor(r.Context(), h.repo).ListArchives(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Failed to retrieve archives: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, archives)
+}
+
+// CreateRestore handles POST /api/v1/archive_restores
+//
+// A restore job is queued, which the server runs in the background, inserting the archived runs created in the
+// range back into the database. They are kept for keep_days, then deleted again.
+func (h *ArchiveHandler) CreateRestore(w http.ResponseWriter, r *http.Request) {
+	var req models.RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if req.From.IsZero() || req.To.IsZero() {
+		http.Error(w, "Invalid restore: from and to are required", http.StatusBadRequest)
+		return
+	}
+	if !req.From.Before(req.To) {
+		http.Error(w, "Invalid restore: from must be before to", http.StatusBadRequest)
+		return
+	}
+	if req.To.Sub(req.From) > maxRestoreRange {
+		http.Error(w, fmt.Sprintf("Invalid restore: the range is longer than %s", formatWindow(maxRestoreRange)), http.StatusBadRequest)
+		return
+	}
+	if req.KeepDays == 0 {
+		req.KeepDays = models.DefaultRestoreKeepDays
+	}
+	if req.KeepDays < 0 || req.KeepDays > maxRestoreKeepDays {
+		http.Error(w, fmt.Sprintf("Invalid restore: keep_days must be between 1 and %d", maxRestoreKeepDays), http.StatusBadRequest)
+		return
+	}
+
+	job := &models.RestoreJob{
+		From:      req.From.UTC(),
+		To:        req.To.UTC(),
+		KeepUntil: time.Now().UTC().AddDate(0, 0, req.KeepDays),
+	}
+	if err := archiveRepoFor(r.Context(), h.repo).CreateRestoreJob(r.Context(), job); err != nil {
+		http.Error(w, "Failed to create restore job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, job)
+}
+
+// ListRestores handles GET /api/v1/archive_restores
+func (h *ArchiveHandler) ListRestores(w http.ResponseWriter, r *http.Request) {
+	limit := int64(defaultRestoreJobsLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxRestoreJobsLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxRestoreJobsLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := archiveRepoFor(r.Context(), h.repo).ListRestoreJobs(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve restore jobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, jobs)
+}
+
+// GetRestore handles GET /api/v1/archive_restores/{restoreId}
+func (h *ArchiveHandler) GetRestore(w http.ResponseWriter, r *http.Request) {
+	jobID, err := primitive.ObjectIDFromHex(mux.Vars(r)["restoreId"])
+	if err != nil {
+		http.Error(w, "Invalid restore ID format", http.StatusBadRequest)
+		return
+	}
+
+	job, err := archiveRepoFor(r.Context(), h.repo).GetRestoreJob(r.Context(), jobID)
+	if err != nil {
+		if err.Error() == "restore job not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve restore job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"ripple/db"
+	"ripple/models"
+	"ripple/policy"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxArtifactNameLength bounds the length of the names of artifacts
+const maxArtifactNameLength = 255
+
+// artifactsRoute uploads the artifacts of a run when posted to, with bodies bounded by the artifact size limit
+// rather than the request body limit
+const artifactsRoute = "/api/v1/agents/{agentId}/runs/{runId}/artifacts"
+
+// ArtifactStore stores the content of artifacts, implemented by the GridFS storage of db.ArtifactRepository and by
+// the buckets of export.S3Store. Content is streamed both ways, artifacts may be too large to hold in memory.
+type ArtifactStore interface {
+	// Upload stores the content read from body under the given name and returns the key it is read with
+	Upload(ctx context.Context, name string, body io.Reader, contentType string) (string, error)
+	// Open opens the content stored under a key, as returned by Upload, for reading
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// ArtifactHandler handles HTTP requests uploading the artifacts of runs, such as traces, transcripts and generated
+// files, and downloading them. Their content is stored in GridFS, or in a bucket when one is configured, and their
+// metadata in the artifacts collection.
+type ArtifactHandler struct {
+	repo   *db.ArtifactRepository
+	agents db.AgentStore
+	// bucket stores the content of new artifacts, or GridFS when nil
+	bucket ArtifactStore
+	policy policy.Policy
+}
+
+// NewArtifactHandler creates a new artifact handler storing the content of artifacts in bucket, or in GridFS when
+// bucket is nil
+func NewArtifactHandler(repo *db.ArtifactRepository, agents db.AgentStore, bucket ArtifactStore, p policy.Policy) *ArtifactHandler {
+	return &ArtifactHandler{
+		repo:   repo,
+		agents: agents,
+		bucket: bucket,
+		policy: p,
+	}
+}
+
+// RegisterRoutes registers the artifact routes
+func (h *ArtifactHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc(artifactsRoute, h.UploadArtifact).Methods("POST")
+	router.HandleFunc(artifactsRoute, h.ListArtifacts).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/runs/{runId}/artifacts/{artifactId}", h.DownloadArtifact).Methods("GET")
+}
+
+// UploadArtifact handles POST /api/v1/agents/{agentId}/runs/{runId}/artifacts
+//
+// The request body is the content of the artifact, named by the name query parameter, streamed to the storage of
+// artifacts. Its type is the Content-Type of the request, or detected from the start of the content without one.
+// Callers that may write the runs of the version of the run may attach artifacts to it.
+func (h *ArtifactHandler) UploadArtifact(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.requestRun(w, r)
+	if !ok {
+		return
+	}
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.WriteRuns, policy.Resource{AgentID: run.AgentID, Version: run.Version}) {
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if err := validateArtifactName(name); err != nil {
+		http.Error(w, "Invalid artifact name: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader := bufio.NewReader(r.Body)
+	head, err := reader.Peek(512)
+	if err != nil && err != io.EOF {
+		respondBodyError(w, "Failed to read artifact", err)
+		return
+	}
+	if len(head) == 0 {
+		http.Error(w, "Invalid artifact: the request body is empty", http.StatusBadRequest)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	artifact := &models.Artifact{
+		ID:          primitive.NewObjectID(),
+		AgentID:     run.AgentID,
+		RunID:       run.RunID,
+		Name:        name,
+		ContentType: contentType,
+	}
+
+	repo := artifactRepoFor(r.Context(), h.repo)
+	var store ArtifactStore = repo
+	artifact.Storage = models.ArtifactStorageGridFS
+	if h.bucket != nil {
+		store = h.bucket
+		artifact.Storage = models.ArtifactStorageS3
+	}
+
+	// The size and checksum of the content are known once it is stored
+	hash := sha256.New()
+	content := &countingReader{reader: io.TeeReader(reader, hash)}
+	objectName := fmt.Sprintf("artifacts/%s/%d/%s/%s", run.AgentID.Hex(), run.RunID, artifact.ID.Hex(), name)
+	if artifact.Key, err = store.Upload(r.Context(), objectName, content, contentType); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondBodyError(w, "Failed to read artifact", tooLarge)
+			return
+		}
+		http.Error(w, "Failed to store artifact: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	artifact.Size = content.n
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err := repo.CreateArtifact(r.Context(), artifact); err != nil {
+		http.Error(w, "Failed to create artifact: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, artifact)
+}
+
+// ListArtifacts handles GET /api/v1/agents/{agentId}/runs/{runId}/artifacts
+//
+// The artifacts of the run are listed oldest first, without their content.
+func (h *ArtifactHandler) ListArtifacts(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.requestRun(w, r)
+	if !ok {
+		return
+	}
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{AgentID: run.AgentID}) {
+		return
+	}
+
+	artifacts, err := artifactRepoFor(r.Context(), h.repo).ListArtifacts(r.Context(), run.AgentID, run.RunID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve artifacts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, artifacts)
+}
+
+// DownloadArtifact handles GET /api/v1/agents/{agentId}/runs/{runId}/artifacts/{artifactId}
+//
+// The content of the artifact is returned as an attachment, with its content type.
+func (h *ArtifactHandler) DownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	run, ok := h.requestRun(w, r)
+	if !ok {
+		return
+	}
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{AgentID: run.AgentID}) {
+		return
+	}
+
+	artifactID, err := primitive.ObjectIDFromHex(mux.Vars(r)["artifactId"])
+	if err != nil {
+		http.Error(w, "Invalid artifact ID format", http.StatusBadRequest)
+		return
+	}
+
+	repo := artifactRepoFor(r.Context(), h.repo)
+	artifact, err := repo.GetArtifact(r.Context(), run.AgentID, run.RunID, artifactID)
+	if err != nil {
+		if err.Error() == "artifact not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve artifact: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Artifacts are read from the storage they were written to, whatever the storage of new artifacts
+	var store ArtifactStore = repo
+	if artifact.Storage == models.ArtifactStorageS3 {
+		if h.bucket == nil {
+			http.Error(w, "Failed to download artifact: it is stored in a bucket, and no artifact bucket is configured", http.StatusInternalServerError)
+			return
+		}
+		store = h.bucket
+	}
+
+	content, err := store.Open(r.Context(), artifact.Key)
+	if err != nil {
+		http.Error(w, "Failed to download artifact: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", artifact.Name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, content)
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// requestRun returns the run of the agentId and runId path variables, responding with an error when they are
+// invalid or the run is not found
+func (h *ArtifactHandler) requestRun(w http.ResponseWriter, r *http.Request) (*models.AgentRun, bool) {
+	vars := mux.Vars(r)
+
+	agentID, err := primitive.ObjectIDFromHex(vars["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return nil, false
+	}
+	runID, err := strconv.ParseInt(vars["runId"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid run ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	run, err := agentRepoFor(r.Context(), h.agents).GetAgentRun(r.Context(), agentID, runID)
+	if err != nil {
+		if err.Error() == "run not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent run: "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return run, true
+}
+
+// validateArtifactName checks that an artifact name is a file name, which downloads are named after
+func validateArtifactName(name string) error {
+	if name == "" {
+		return fmt.Errorf("the name query parameter is required")
+	}
+	if len(name) > maxArtifactNameLength {
+		return fmt.Errorf("the name is longer than %d bytes", maxArtifactNameLength)
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("the name must be a file name, without path separators")
+	}
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("the name contains control characters")
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// ingestRoutes are the routes writing runs, or their artifacts, when posted to, which only the ingest role can call
+var ingestRoutes = []string{
+	"/api/v1/agents/{agentId}/versions/{version}/runs",
+	artifactsRoute,
+	"/api/v1/runs/batch",
+	"/v1/traces",
+	"/api/v1/prometheus/write",
+}
+
+// ingestTokenRoutes are the routes accepting the per-agent ingest tokens issued at registration in place of a JWT,
+// their handlers check the tokens
+var ingestTokenRoutes = []string{
+	"/api/v1/agents/{agentId}/versions/{version}/runs",
+	"/api/v1/runs/batch",
+}
+
+// versionRoute registers agent versions when posted to, which the versions role can call
+const versionRoute = "/api/v1/agents/{agentId}/versions"
+
+// deploymentRoute records deployment events when posted to, which the deployments role can call
+const deploymentRoute = "/api/v1/agents/{agentId}/versions/{version}/deployments"
+
+// publicRoutes need no credentials, they sign browser sessions in and out
+var publicRoutes = []string{"/auth/login", "/auth/callback", "/auth/logout", "/api/v1/auth/login", "/api/v1/auth/logout"}
+
+// adminRoutePrefixes start the routes only the admin role can call: the admin API and the management of API keys,
+// service accounts and users, which could otherwise create credentials with more permissions than their caller, of
+// the redaction rules keeping personal data out of stored runs, of the retention policies deleting stored runs, and
+// of the archives of runs of all projects
+var adminRoutePrefixes = []string{"/api/v1/admin/", "/api/v1/api_keys", "/api/v1/ui/api_keys", "/api/v1/service_accounts", "/api/v1/users", "/api/v1/redaction_rules", "/api/v1/retention_policies", "/api/v1/archives", "/api/v1/archive_restores"}
+
+// bootstrapSubject is the subject of the claims of the bootstrap API key, recorded in audit records. It does not
+// identify the key, whose claims are marked as Bootstrap.
+const bootstrapSubject = "bootstrap"
+
+// scopeRoles are the roles granted by the API key scopes
+var scopeRoles = map[string]string{
+	models.ScopeRead:             auth.RoleViewer,
+	models.ScopeWrite:            auth.RoleEditor,
+	models.ScopeAdmin:            auth.RoleAdmin,
+	models.ScopeRunsWrite:        auth.RoleIngest,
+	models.ScopeVersionsWrite:    auth.RoleVersions,
+	models.ScopeDeploymentsWrite: auth.RoleDeployments,
+}
+
+// AuthMiddleware requires requests to carry a JWT checked by verifier, an API key stored in apiKeys or equal to
+// bootstrapKey, or a session cookie checked by sessions, granting the role of their route: viewer to read, editor to
+// write, admin to delete and to call the admin API, and ingest to write runs. API keys are granted the roles of
+// their scopes. The claims are added to the request context. Per-agent ingest tokens and client certificates are
+// passed on to the routes that check them. Any of verifier, apiKeys and sessions may be nil, to only accept the others.
+// In database tenant mode, tenants is set and API keys are looked up in the tenant named by the tenantHeader header,
+// which TenantMiddleware, running next, checks the claims of the request against.
+func AuthMiddleware(verifier *auth.Verifier, apiKeys *db.APIKeyRepository, bootstrapKey string, sessions *auth.Sessions, tenants *db.TenantRouter, tenantHeader string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var template string
+			if route := mux.CurrentRoute(r); route != nil {
+				template, _ = route.GetPathTemplate()
+			}
+			if contains(publicRoutes, template) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			role := requiredRole(r.Method, template)
+
+			// Client certificates are verified by the ingest listener, which only serves them the runs API
+			if clientCertAgent(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok && strings.HasPrefix(token, models.IngestTokenPrefix) {
+				if role != auth.RoleIngest || !contains(ingestTokenRoutes, template) {
+					http.Error(w, "Ingest tokens can only write runs to the runs API", http.StatusForbidden)
+					return
+				}
+				// The token is checked by the route, requests are told apart by its hash until then
+				next.ServeHTTP(w, r.WithContext(withIngestTokenHash(r.Context(), token)))
+				return
+			}
+
+			claims, err := requestClaims(r, verifier, apiKeys, bootstrapKey, sessions, tenants, tenantHeader)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid credentials: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if claims == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Missing credentials, set the Authorization: Bearer <token> header or sign in at /auth/login", http.StatusUnauthorized)
+				return
+			}
+			if !claims.Grants(role) {
+				http.Error(w, "The "+role+" role is required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// requestClaims returns the claims of the API key or JWT sent as bearer token, or else of the session cookie of a
+// request, nil when the request sends neither
+func requestClaims(r *http.Request, verifier *auth.Verifier, apiKeys *db.APIKeyRepository, bootstrapKey string, sessions *auth.Sessions, tenants *db.TenantRouter, tenantHeader string) (*auth.Claims, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		if strings.HasPrefix(token, models.APIKeyPrefix) {
+			return apiKeyClaims(r.Context(), token, apiKeys, bootstrapKey, tenants, r.Header.Get(tenantHeader))
+		}
+		if verifier == nil {
+			return nil, errors.New("bearer tokens are not accepted")
+		}
+		return verifier.Verify(token)
+	}
+
+	if sessions != nil {
+		if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+			return sessions.Verify(r.Context(), cookie.Value)
+		}
+	}
+	return nil, nil
+}
+
+// apiKeyClaims returns the claims of an API key: the roles of its scopes and its organization. The bootstrap key, when
+// set, is an admin key that is not stored. In database tenant mode, keys are issued in the database of their tenant,
+// where they are looked up when it was provisioned, and their claims carry the tenant.
+func apiKeyClaims(ctx context.Context, key string, apiKeys *db.APIKeyRepository, bootstrapKey string, tenants *db.TenantRouter, tenant string) (*auth.Claims, error) {
+	if bootstrapKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(bootstrapKey)) == 1 {
+		return &auth.Claims{
+			Roles:            []string{auth.RoleAdmin},
+			Bootstrap:        true,
+			RegisteredClaims: jwt.RegisteredClaims{Subject: bootstrapSubject},
+		}, nil
+	}
+	if apiKeys == nil {
+		return nil, errors.New("API keys are not accepted")
+	}
+	if tenants != nil {
+		database, err := tenants.Database(ctx, tenant)
+		if err != nil {
+			if err.Error() == "invalid tenant name" || err.Error() == "tenant not found" {
+				return nil, errors.New("invalid API key")
+			}
+			return nil, err
+		}
+		apiKeys = db.NewAPIKeyRepository(database)
+	}
+
+	apiKey, err := apiKeys.GetAPIKeyByHash(ctx, models.HashAPIKey(key))
+	if err != nil {
+		if err.Error() == "API key not found" {
+			return nil, errors.New("invalid API key")
+		}
+		return nil, err
+	}
+
+	if apiKey.Disabled {
+		return nil, errors.New("the service account of the API key is disabled")
+	}
+
+	// The last use is recorded for hygiene audits, failing to record it does not fail the request
+	if err := apiKeys.TouchAPIKey(ctx, apiKey.ID, time.Now()); err != nil {
+		log.Printf("Failed to record the use of API key %s: %v", apiKey.ID.Hex(), err)
+	}
+
+	// Service accounts are the subject of their keys, so that their requests are told apart from other keys'
+	subject := "api_key:" + apiKey.ID.Hex()
+	if apiKey.ServiceAccountID != nil {
+		subject = "service_account:" + apiKey.ServiceAccountID.Hex()
+	}
+	claims := &auth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}}
+	for _, scope := range apiKey.Scopes {
+		if role, ok := scopeRoles[scope]; ok {
+			claims.Roles = append(claims.Roles, role)
+		}
+	}
+	if !apiKey.OrgID.IsZero() {
+		claims.OrgID = apiKey.OrgID.Hex()
+	}
+	claims.Project = apiKey.Project
+	claims.Tenant = tenant
+	return claims, nil
+}
+
+// requiredRole returns the role a request to a route needs
+func requiredRole(method, template string) string {
+	switch {
+	case method == http.MethodPost && contains(ingestRoutes, template):
+		return auth.RoleIngest
+	case method == http.MethodPost && template == versionRoute:
+		return auth.RoleVersions
+	case method == http.MethodPost && template == deploymentRoute:
+		return auth.RoleDeployments
+	case strings.HasPrefix(template, sessionRoutePrefix):
+		// Every signed in user manages their own session
+		return auth.RoleViewer
+	case method == http.MethodDelete || hasAnyPrefix(template, adminRoutePrefixes):
+		return auth.RoleAdmin
+	case method == http.MethodGet || method == http.MethodHead || template == "/graphql":
+		// GraphQL only has queries
+		return auth.RoleViewer
+	default:
+		return auth.RoleEditor
+	}
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
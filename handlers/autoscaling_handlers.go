@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"ripple/db"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAutoscalingWindow = 5 * time.Minute
+	maxAutoscalingWindow     = 24 * time.Hour
+)
+
+// AutoscalingHandler handles HTTP requests for autoscaling signals
+type AutoscalingHandler struct {
+	repo db.UIStore
+}
+
+// NewAutoscalingHandler creates a new autoscaling handler
+func NewAutoscalingHandler(repo db.UIStore) *AutoscalingHandler {
+	return &AutoscalingHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the autoscaling routes
+func (h *AutoscalingHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/autoscaling/signals", h.GetSignals).Methods("GET")
+}
+
+// GetSignals handles GET /api/v1/autoscaling/signals
+func (h *AutoscalingHandler) GetSignals(w http.ResponseWriter, r *http.Request) {
+	window := defaultAutoscalingWindow
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		parsed, err := time.ParseDuration(windowStr)
+		if err != nil || parsed <= 0 || parsed > maxAutoscalingWindow {
+			http.Error(w, "Invalid window, expected a duration between 0 and 24h", http.StatusBadRequest)
+			return
+		}
+		window = parsed
+	}
+
+	signals, err := uiRepoFor(r.Context(), h.repo).GetAutoscalingSignals(r.Context(), window, r.URL.Query().Get("agent"))
+	if err != nil {
+		http.Error(w, "Failed to retrieve autoscaling signals: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, signals)
+}
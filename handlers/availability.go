@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"ripple/db"
+
+	"github.com/gorilla/mux"
+)
+
+// AvailabilityMiddleware responds with 503 Service Unavailable and a Retry-After header while the circuit of the
+// MongoDB connection is open, after sustained failures of its operations, rather than letting requests wait on an
+// unavailable cluster
+func AvailabilityMiddleware(database *db.MongoDB) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if wait, open := database.CircuitOpen(); open {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Database unavailable, retry later", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
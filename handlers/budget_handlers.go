@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BudgetHandler handles HTTP requests for budgets and their statuses
+type BudgetHandler struct {
+	repo   *db.BudgetRepository
+	agents db.AgentStore
+}
+
+// NewBudgetHandler creates a new budget handler
+func NewBudgetHandler(repo *db.BudgetRepository, agents db.AgentStore) *BudgetHandler {
+	return &BudgetHandler{
+		repo:   repo,
+		agents: agents,
+	}
+}
+
+// RegisterRoutes registers the budget routes
+func (h *BudgetHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/budgets", h.CreateBudget).Methods("POST")
+	router.HandleFunc("/api/v1/budgets", h.ListBudgets).Methods("GET")
+	router.HandleFunc("/api/v1/budgets/status", h.GetBudgetStatus).Methods("GET")
+	router.HandleFunc("/api/v1/budgets/{budgetId}", h.GetBudget).Methods("GET")
+	router.HandleFunc("/api/v1/budgets/{budgetId}", h.UpdateBudget).Methods("PUT")
+	router.HandleFunc("/api/v1/budgets/{budgetId}", h.DeleteBudget).Methods("DELETE")
+}
+
+// CreateBudget handles POST /api/v1/budgets
+func (h *BudgetHandler) CreateBudget(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeBudgetRequest(w, r)
+	if !ok {
+		return
+	}
+
+	budget := &models.Budget{
+		AgentID: req.AgentID,
+		Project: req.Project,
+		Period:  req.Period,
+		Amount:  req.Amount,
+	}
+	if err := budgetRepoFor(r.Context(), h.repo).CreateBudget(r.Context(), budget); err != nil {
+		http.Error(w, "Failed to create budget: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, budget)
+}
+
+// ListBudgets handles GET /api/v1/budgets
+func (h *BudgetHandler) ListBudgets(w http.ResponseWriter, r *http.Request) {
+	agentID, project, err := parseBudgetScope(r)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	budgets, err := budgetRepoFor(r.Context(), h.repo).ListBudgets(r.Context(), agentID, project)
+	if err != nil {
+		http.Error(w, "Failed to retrieve budgets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, budgets)
+}
+
+// GetBudget handles GET /api/v1/budgets/{budgetId}
+func (h *BudgetHandler) GetBudget(w http.ResponseWriter, r *http.Request) {
+	budgetID, err := primitive.ObjectIDFromHex(mux.Vars(r)["budgetId"])
+	if err != nil {
+		http.Error(w, "Invalid budget ID format", http.StatusBadRequest)
+		return
+	}
+
+	budget, err := budgetRepoFor(r.Context(), h.repo).GetBudget(r.Context(), budgetID)
+	if err != nil {
+		respondBudgetError(w, "Failed to retrieve budget", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, budget)
+}
+
+// UpdateBudget handles PUT /api/v1/budgets/{budgetId}
+func (h *BudgetHandler) UpdateBudget(w http.ResponseWriter, r *http.Request) {
+	budgetID, err := primitive.ObjectIDFromHex(mux.Vars(r)["budgetId"])
+	if err != nil {
+		http.Error(w, "Invalid budget ID format", http.StatusBadRequest)
+		return
+	}
+
+	req, ok := h.decodeBudgetRequest(w, r)
+	if !ok {
+		return
+	}
+
+	repo := budgetRepoFor(r.Context(), h.repo)
+	budget, err := repo.GetBudget(r.Context(), budgetID)
+	if err != nil {
+		respondBudgetError(w, "Failed to retrieve budget", err)
+		return
+	}
+
+	budget.AgentID = req.AgentID
+	budget.Project = req.Project
+	budget.Period = req.Period
+	budget.Amount = req.Amount
+
+	if err := repo.UpdateBudget(r.Context(), budget); err != nil {
+		respondBudgetError(w, "Failed to update budget", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, budget)
+}
+
+// DeleteBudget handles DELETE /api/v1/budgets/{budgetId}
+func (h *BudgetHandler) DeleteBudget(w http.ResponseWriter, r *http.Request) {
+	budgetID, err := primitive.ObjectIDFromHex(mux.Vars(r)["budgetId"])
+	if err != nil {
+		http.Error(w, "Invalid budget ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := budgetRepoFor(r.Context(), h.repo).DeleteBudget(r.Context(), budgetID); err != nil {
+		respondBudgetError(w, "Failed to delete budget", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBudgetStatus handles GET /api/v1/budgets/status
+func (h *BudgetHandler) GetBudgetStatus(w http.ResponseWriter, r *http.Request) {
+	agentID, project, err := parseBudgetScope(r)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	statuses, err := budgetRepoFor(r.Context(), h.repo).ListBudgetStatuses(r.Context(), agentID, project)
+	if err != nil {
+		http.Error(w, "Failed to retrieve budget statuses: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, statuses)
+}
+
+// decodeBudgetRequest decodes and validates a budget request, checking that its agent exists. It responds with an
+// error when the request is invalid.
+func (h *BudgetHandler) decodeBudgetRequest(w http.ResponseWriter, r *http.Request) (models.BudgetRequest, bool) {
+	var req models.BudgetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return req, false
+	}
+
+	req.Project = strings.TrimSpace(req.Project)
+	if err := validateBudgetRequest(req); err != nil {
+		http.Error(w, "Invalid budget: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+
+	if req.AgentID != nil {
+		if _, err := agentRepoFor(r.Context(), h.agents).GetAgentByID(r.Context(), *req.AgentID); err != nil {
+			if err.Error() == "agent not found" {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+			}
+			return req, false
+		}
+	}
+
+	return req, true
+}
+
+// parseBudgetScope parses the agent_id and project query parameters budgets are filtered by
+func parseBudgetScope(r *http.Request) (*primitive.ObjectID, string, error) {
+	query := r.URL.Query()
+	var agentID *primitive.ObjectID
+	if agentIDStr := query.Get("agent_id"); agentIDStr != "" {
+		id, err := primitive.ObjectIDFromHex(agentIDStr)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid agent_id %q", agentIDStr)
+		}
+		agentID = &id
+	}
+	return agentID, query.Get("project"), nil
+}
+
+// validateBudgetRequest checks the scope, period and amount of a budget
+func validateBudgetRequest(req models.BudgetRequest) error {
+	if (req.AgentID == nil) == (req.Project == "") {
+		return errors.New("exactly one of agent_id and project is required")
+	}
+	if !contains(models.BudgetPeriods, req.Period) {
+		return fmt.Errorf("unknown period %q, expected %s or %s", req.Period, models.BudgetPeriodDaily, models.BudgetPeriodMonthly)
+	}
+	if req.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	return nil
+}
+
+// respondBudgetError responds with 404 when the budget does not exist, 500 otherwise
+func respondBudgetError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "budget not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"ripple/cache"
+	"ripple/db"
+	"ripple/events"
+
+	"github.com/gorilla/mux"
+)
+
+// cacheStatusHeader tells whether a dashboard response was served from the cache
+const cacheStatusHeader = "X-Cache"
+
+// cachedDashboardPaths are the dashboard endpoints whose responses are cached, the ones running aggregations over
+// the runs
+var cachedDashboardPaths = []string{
+	"/api/v1/ui/stats",
+	"/api/v1/ui/health",
+	"/api/v1/ui/models/stats",
+	"/api/v1/ui/clusters",
+	"/api/v1/ui/heatmap",
+	"/api/v1/ui/top",
+	"/api/v1/ui/errors",
+	"/api/v1/ui/cost/breakdown",
+	"/api/v1/ui/tokens",
+}
+
+// DashboardCache serves the responses of the dashboard endpoints from a cache for up to a staleness budget, so that
+// a dashboard open in many browsers computes its aggregations once per budget
+type DashboardCache struct {
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// NewDashboardCache creates a dashboard cache keeping responses for ttl
+func NewDashboardCache(c cache.Cache, ttl time.Duration) *DashboardCache {
+	return &DashboardCache{
+		cache: c,
+		ttl:   ttl,
+	}
+}
+
+// cachedResponse is a successful dashboard response stored in the cache
+type cachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// RegisterRoutes registers the cache invalidation route
+func (c *DashboardCache) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/ui/cache", c.ClearCache).Methods("DELETE")
+}
+
+// ClearCache handles DELETE /api/v1/ui/cache
+func (c *DashboardCache) ClearCache(w http.ResponseWriter, r *http.Request) {
+	if err := c.cache.Clear(r.Context()); err != nil {
+		http.Error(w, "Failed to clear the dashboard cache: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Middleware serves GET requests to the cached dashboard endpoints from the cache, computing and storing the
+// response on a miss. Only successful responses are stored. Cache errors are logged and the request is served
+// without the cache.
+func (c *DashboardCache) Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !contains(cachedDashboardPaths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := dashboardCacheKey(r)
+			if data, ok, err := c.cache.Get(r.Context(), key); err != nil {
+				log.Printf("Failed to read the dashboard cache: %v", err)
+			} else if ok {
+				var resp cachedResponse
+				if err := json.Unmarshal(data, &resp); err == nil {
+					replayCachedResponse(w, r, &resp, "HIT")
+					return
+				}
+			}
+
+			// The response is computed in full, the client's If-None-Match is applied when replaying it
+			rec := &cacheRecorder{header: http.Header{}}
+			req := r.Clone(r.Context())
+			req.Header.Del("If-None-Match")
+			next.ServeHTTP(rec, req)
+
+			if rec.status != 0 && rec.status != http.StatusOK {
+				copyHeader(w.Header(), rec.header)
+				w.WriteHeader(rec.status)
+				w.Write(rec.body.Bytes())
+				return
+			}
+
+			resp := &cachedResponse{Header: rec.header, Body: rec.body.Bytes()}
+			if data, err := json.Marshal(resp); err == nil {
+				if err := c.cache.Set(r.Context(), key, data, c.ttl); err != nil {
+					log.Printf("Failed to write the dashboard cache: %v", err)
+				}
+			}
+			replayCachedResponse(w, r, resp, "MISS")
+		})
+	}
+}
+
+// InvalidateOn clears the cache when agent versions are registered, until the context is cancelled. Runs are not
+// invalidating: the staleness budget bounds how late they show on the dashboard.
+func (c *DashboardCache) InvalidateOn(ctx context.Context, broker *events.Broker) {
+	sub := broker.Subscribe()
+	defer broker.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if event.Type != events.VersionRegistered {
+				continue
+			}
+			if err := c.cache.Clear(ctx); err != nil {
+				log.Printf("Failed to clear the dashboard cache: %v", err)
+			}
+		}
+	}
+}
+
+// dashboardCacheKey identifies a dashboard response by the tenant, path, query parameters and Accept-Language
+// header of its request
+func dashboardCacheKey(r *http.Request) string {
+	h := sha256.New()
+	if database := db.DatabaseFromContext(r.Context()); database != nil {
+		io.WriteString(h, database.Key())
+	}
+	io.WriteString(h, "\n"+r.URL.Path+"\n"+r.URL.Query().Encode()+"\n"+r.Header.Get("Accept-Language"))
+	return "ui:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// replayCachedResponse writes a cached response, or 304 Not Modified when its ETag matches the request's
+// If-None-Match header
+func replayCachedResponse(w http.ResponseWriter, r *http.Request, resp *cachedResponse, status string) {
+	copyHeader(w.Header(), resp.Header)
+	w.Header().Set(cacheStatusHeader, status)
+	if etag := resp.Header.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp.Body)
+}
+
+// copyHeader adds the values of src to dst
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		for _, v := range values {
+			dst.Add(name, v)
+		}
+	}
+}
+
+// cacheRecorder buffers a response instead of writing it
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+)
+
+// Client certificate fields agents are identified by
+const (
+	ClientIdentityCN  = "cn"
+	ClientIdentitySAN = "san"
+)
+
+// ClientIdentities lists the client certificate fields agents can be identified by
+var ClientIdentities = []string{ClientIdentityCN, ClientIdentitySAN}
+
+type clientCertAgentKey struct{}
+
+// ClientCertIngestHandler serves the runs API of router to agents authenticated by a verified client certificate,
+// for an ingest listener requiring client certificates. The certificate names the agent by its common name, or by its
+// first DNS subject alternative name when identity is ClientIdentitySAN, and writes the runs of that agent only, like
+// an ingest token of the agent. Other routes are not served.
+func ClientCertIngestHandler(router *mux.Router, identity string) (http.Handler, error) {
+	if !contains(ClientIdentities, identity) {
+		return nil, fmt.Errorf("invalid client certificate identity %q, expected cn or san", identity)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "A verified client certificate is required", http.StatusUnauthorized)
+			return
+		}
+		agent := clientCertIdentity(r.TLS.VerifiedChains[0][0], identity)
+		if agent == "" {
+			http.Error(w, "The client certificate does not name an agent", http.StatusForbidden)
+			return
+		}
+
+		var match mux.RouteMatch
+		var template string
+		if router.Match(r, &match) && match.Route != nil {
+			template, _ = match.Route.GetPathTemplate()
+		}
+		if r.Method != http.MethodPost || !contains(ingestTokenRoutes, template) {
+			http.Error(w, "Only the runs API is served to client certificates", http.StatusNotFound)
+			return
+		}
+
+		router.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientCertAgentKey{}, agent)))
+	}), nil
+}
+
+// clientCertIdentity returns the agent name of a client certificate
+func clientCertIdentity(cert *x509.Certificate, identity string) string {
+	if identity == ClientIdentitySAN {
+		if len(cert.DNSNames) == 0 {
+			return ""
+		}
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// clientCertAgent returns the name of the agent whose client certificate authenticated a request, empty when the
+// request was not served by ClientCertIngestHandler
+func clientCertAgent(ctx context.Context) string {
+	agent, _ := ctx.Value(clientCertAgentKey{}).(string)
+	return agent
+}
+
+// clientCertIngestToken returns an ingest token writing the runs of the agent of a client certificate, responding
+// with 403 Forbidden when no agent of that name is registered
+func clientCertIngestToken(ctx context.Context, w http.ResponseWriter, repo db.AgentStore, name string) (*models.IngestToken, bool) {
+	agent, err := repo.GetAgentByName(ctx, name)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, "The client certificate names agent "+name+", which is not registered", http.StatusForbidden)
+		} else {
+			http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return &models.IngestToken{AgentID: agent.ID}, true
+}
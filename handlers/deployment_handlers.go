@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultDeploymentEventsLimit = 50
+	maxDeploymentEventsLimit     = 500
+)
+
+// DeploymentHandler handles HTTP requests for the deployment events of agent versions
+type DeploymentHandler struct {
+	repo   *db.DeploymentRepository
+	agents db.AgentStore
+}
+
+// NewDeploymentHandler creates a new deployment handler
+func NewDeploymentHandler(repo *db.DeploymentRepository, agents db.AgentStore) *DeploymentHandler {
+	return &DeploymentHandler{
+		repo:   repo,
+		agents: agents,
+	}
+}
+
+// RegisterRoutes registers the deployment routes
+func (h *DeploymentHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc(deploymentRoute, h.CreateDeploymentEvent).Methods("POST")
+	router.HandleFunc(deploymentRoute, h.ListDeploymentEvents).Methods("GET")
+}
+
+// CreateDeploymentEvent handles POST /api/v1/agents/{agentId}/versions/{version}/deployments
+func (h *DeploymentHandler) CreateDeploymentEvent(w http.ResponseWriter, r *http.Request) {
+	agentID, version, ok := h.requestVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.DeploymentEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	req.Environment = strings.TrimSpace(req.Environment)
+	if req.Environment == "" {
+		http.Error(w, "Invalid deployment event: environment is required", http.StatusBadRequest)
+		return
+	}
+	if !contains(models.DeploymentStatuses, req.Status) {
+		http.Error(w, "Invalid deployment event: status must be one of "+strings.Join(models.DeploymentStatuses, ", "), http.StatusBadRequest)
+		return
+	}
+
+	event := &models.DeploymentEvent{
+		AgentID:     agentID,
+		Version:     version,
+		Environment: req.Environment,
+		Cluster:     req.Cluster,
+		Status:      req.Status,
+		Commit:      req.Commit,
+		URL:         req.URL,
+	}
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil {
+		event.Actor = claims.Subject
+	}
+
+	if err := deploymentRepoFor(r.Context(), h.repo).CreateDeploymentEvent(r.Context(), event); err != nil {
+		http.Error(w, "Failed to create deployment event: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, event)
+}
+
+// ListDeploymentEvents handles GET /api/v1/agents/{agentId}/versions/{version}/deployments
+func (h *DeploymentHandler) ListDeploymentEvents(w http.ResponseWriter, r *http.Request) {
+	agentID, version, ok := h.requestVersion(w, r)
+	if !ok {
+		return
+	}
+
+	limit := int64(defaultDeploymentEventsLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxDeploymentEventsLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxDeploymentEventsLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := deploymentRepoFor(r.Context(), h.repo).ListDeploymentEvents(r.Context(), agentID, version, limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve deployment events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// requestVersion returns the agent ID and version of the request path, responding with an error when the version
+// does not exist
+func (h *DeploymentHandler) requestVersion(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, string, bool) {
+	vars := mux.Vars(r)
+	agentID, err := primitive.ObjectIDFromHex(vars["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return agentID, "", false
+	}
+
+	version := vars["version"]
+	if _, err := agentRepoFor(r.Context(), h.agents).GetAgentVersion(r.Context(), agentID, version); err != nil {
+		if err.Error() == "version not found for this agent" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent version: "+err.Error(), http.StatusInternalServerError)
+		}
+		return agentID, "", false
+	}
+	return agentID, version, true
+}
+
+// deploymentRepoFor returns the deployment repository of the request's tenant, or the default repository
+func deploymentRepoFor(ctx context.Context, fallback *db.DeploymentRepository) *db.DeploymentRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewDeploymentRepository(database)
+	}
+	return fallback
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ripple/db"
+)
+
+// DisplaySettings holds the locale, currency and timezone dashboard statistics are computed with, by default and per
+// project. The locale of a request, from the locale query parameter or the Accept-Language header, takes precedence
+// over the configured locales.
+type DisplaySettings struct {
+	Locale            *db.Locale
+	Currency          *db.Currency
+	ProjectLocales    map[string]*db.Locale
+	ProjectCurrencies map[string]*db.Currency
+
+	// Timezones are the reporting timezones the daily statistics start at midnight in
+	Timezones *db.Timezones
+}
+
+// ParseDisplaySettings parses the default locale and currency, and the per-project overrides in the form
+// "project=de,other=fr" and "project=EUR,other=GBP"
+func ParseDisplaySettings(locale, currency, projectLocales, projectCurrencies string) (*DisplaySettings, error) {
+	settings := &DisplaySettings{
+		Locale:            db.DefaultLocale,
+		Currency:          db.DefaultCurrency,
+		ProjectLocales:    map[string]*db.Locale{},
+		ProjectCurrencies: map[string]*db.Currency{},
+	}
+
+	if locale != "" {
+		l, ok := db.LookupLocale(locale)
+		if !ok {
+			return nil, fmt.Errorf("unsupported locale %q", locale)
+		}
+		settings.Locale = l
+	}
+	if currency != "" {
+		c, ok := db.LookupCurrency(currency)
+		if !ok {
+			return nil, fmt.Errorf("invalid currency %q, expected an ISO 4217 code", currency)
+		}
+		settings.Currency = c
+	}
+
+	err := parseProjectSettings(projectLocales, "locale", func(project, value string) bool {
+		l, ok := db.LookupLocale(value)
+		settings.ProjectLocales[project] = l
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = parseProjectSettings(projectCurrencies, "currency", func(project, value string) bool {
+		c, ok := db.LookupCurrency(value)
+		settings.ProjectCurrencies[project] = c
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// parseProjectSettings parses settings in the form "project=value,other=value", setting each with set, which
+// reports whether the value is valid
+func parseProjectSettings(s, name string, set func(project, value string) bool) error {
+	if s == "" {
+		return nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		project, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || project == "" || value == "" {
+			return fmt.Errorf("invalid project %s %q, expected project=%s", name, entry, name)
+		}
+		if !set(project, value) {
+			return fmt.Errorf("invalid %s %q for project %s", name, value, project)
+		}
+	}
+
+	return nil
+}
+
+// localeFor returns the locale statistics of a project are formatted with when the request asks for none
+func (s *DisplaySettings) localeFor(project string) *db.Locale {
+	if s == nil {
+		return db.DefaultLocale
+	}
+	if l, ok := s.ProjectLocales[project]; ok && project != "" {
+		return l
+	}
+	return s.Locale
+}
+
+// timezoneFor returns the reporting timezone of a project
+func (s *DisplaySettings) timezoneFor(project string) *time.Location {
+	if s == nil {
+		return time.Local
+	}
+	return s.Timezones.For(project)
+}
+
+// currencyFor returns the currency the costs of a project are recorded in
+func (s *DisplaySettings) currencyFor(project string) *db.Currency {
+	if s == nil {
+		return db.DefaultCurrency
+	}
+	if c, ok := s.ProjectCurrencies[project]; ok && project != "" {
+		return c
+	}
+	return s.Currency
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+	"ripple/policy"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// exportFormatCSV is the default export format
+	exportFormatCSV = "csv"
+	// exportFlushRows is the number of rows written between flushes of a streamed export
+	exportFlushRows = 500
+)
+
+var runCSVHeader = []string{
+	"id", "agent_id", "version_id", "version", "created", "status", "time_taken", "initiator", "tools", "cost",
+	"models", "run_id", "task_id", "trace_id", "span_id", "recorded_at", "schema_version", "error_type",
+	"error_message", "prompt_tokens", "completion_tokens",
+}
+
+var agentVersionMetricsCSVHeader = []string{
+	"id", "name", "project", "version", "status", "window", "environment", "cluster", "lastSeen", "avgRuntime",
+	"successRate", "totalRuns", "spend", "spendToday", "spend7d", "spend30d", "spendTotal", "tools", "models", "errors", "health", "healthReasons",
+}
+
+// ExportAgentRuns handles GET /api/v1/agents/{agentId}/runs/export
+func (h *AgentHandler) ExportAgentRuns(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentIDStr := vars["agentId"]
+
+	agentID, err := primitive.ObjectIDFromHex(agentIDStr)
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	query := r.URL.Query()
+	if !isSupportedExportFormat(w, query.Get("format")) {
+		return
+	}
+	filter := db.RunFilter{
+		Version: query.Get("version"),
+		Status:  query.Get("status"),
+	}
+
+	out := newCSVExport(w, "runs-"+agentIDStr+".csv", runCSVHeader)
+	err = agentRepoFor(r.Context(), h.repo).ExportAgentRuns(r.Context(), agentID, filter, func(run *models.AgentRun) error {
+		return out.write(runCSVRecord(run))
+	})
+	if err != nil {
+		if out.started {
+			// The status is already sent, the truncated export can only be logged
+			log.Printf("Failed to export runs of agent %s: %v", agentIDStr, err)
+			return
+		}
+		if err.Error() == "agent not found" {
+			http.Error(w, "Agent not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to export agent runs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := out.close(); err != nil {
+		log.Printf("Failed to export runs of agent %s: %v", agentIDStr, err)
+	}
+}
+
+// ExportAgentVersions handles GET /api/v1/ui/agent_versions/export
+func (h *UIHandler) ExportAgentVersions(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	if !isSupportedExportFormat(w, r.URL.Query().Get("format")) {
+		return
+	}
+
+	filter, err := parseVersionMetricsFilter(r)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metrics, err := uiRepoFor(r.Context(), h.repo).GetAgentVersions(r.Context(), filter, db.ListOptions{})
+	if err != nil {
+		http.Error(w, "Failed to get agents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := newCSVExport(w, "agent-versions.csv", agentVersionMetricsCSVHeader)
+	for i := range metrics {
+		if err := out.write(agentVersionMetricsCSVRecord(&metrics[i])); err != nil {
+			log.Printf("Failed to export agent versions: %v", err)
+			return
+		}
+	}
+	if err := out.close(); err != nil {
+		log.Printf("Failed to export agent versions: %v", err)
+	}
+}
+
+// isSupportedExportFormat checks the requested export format, responding with 400 when it is not supported
+func isSupportedExportFormat(w http.ResponseWriter, format string) bool {
+	if format == "" || format == exportFormatCSV {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("Unsupported export format %q, use csv", format), http.StatusBadRequest)
+	return false
+}
+
+// csvExport streams CSV rows to a response, flushing them in chunks. The response headers are sent with the
+// first row, so errors occurring before it can still be reported with an error status.
+type csvExport struct {
+	w        http.ResponseWriter
+	csv      *csv.Writer
+	filename string
+	header   []string
+	rows     int
+	started  bool
+}
+
+// newCSVExport creates a CSV export downloaded as filename
+func newCSVExport(w http.ResponseWriter, filename string, header []string) *csvExport {
+	return &csvExport{
+		w:        w,
+		csv:      csv.NewWriter(w),
+		filename: filename,
+		header:   header,
+	}
+}
+
+// write writes a row, flushing the rows written so far every exportFlushRows rows
+func (e *csvExport) write(record []string) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	if err := e.csv.Write(record); err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// close writes the header of an empty export and flushes the remaining rows
+func (e *csvExport) close() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.flush()
+}
+
+// start sends the response headers and the CSV header row
+func (e *csvExport) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+
+	// Large exports outlast the server's write timeout
+	http.NewResponseController(e.w).SetWriteDeadline(time.Time{})
+	e.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+	e.w.WriteHeader(http.StatusOK)
+	return e.csv.Write(e.header)
+}
+
+// flush sends the buffered rows to the client
+func (e *csvExport) flush() error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// runCSVRecord formats a run as a CSV row
+func runCSVRecord(run *models.AgentRun) []string {
+	return []string{
+		run.ID.Hex(),
+		run.AgentID.Hex(),
+		run.VersionID.Hex(),
+		csvText(run.Version),
+		csvTime(run.Created),
+		csvText(run.Status),
+		csvFloat(run.TimeTaken),
+		csvText(run.Initiator),
+		csvList(run.Tools),
+		csvFloat(run.Cost),
+		csvList(run.Models),
+		strconv.FormatInt(run.RunID, 10),
+		strconv.FormatInt(run.TaskID, 10),
+		csvText(run.TraceID),
+		csvText(run.SpanID),
+		csvTime(run.RecordedAt),
+		strconv.Itoa(run.SchemaVersion),
+		csvText(run.ErrorType),
+		csvText(run.ErrorMessage),
+		strconv.FormatInt(run.PromptTokens, 10),
+		strconv.FormatInt(run.CompletionTokens, 10),
+	}
+}
+
+// agentVersionMetricsCSVRecord formats the metrics of an agent version as a CSV row
+func agentVersionMetricsCSVRecord(m *models.AgentVersionMetrics) []string {
+	return []string{
+		m.Id.Hex(),
+		csvText(m.Name),
+		csvText(m.Project),
+		csvText(m.Version),
+		csvText(m.Status),
+		csvText(m.Window),
+		csvText(m.Environment),
+		csvText(m.Cluster),
+		csvTime(m.LastSeen),
+		csvFloat(m.AverageRunTime),
+		csvFloat(m.SuccessRate),
+		strconv.FormatInt(m.TotalRuns, 10),
+		csvFloat(m.Spend),
+		csvFloat(m.SpendToday),
+		csvFloat(m.Spend7d),
+		csvFloat(m.Spend30d),
+		csvFloat(m.SpendTotal),
+		csvList(m.Tools),
+		csvList(m.Models),
+		csvCounts(m.Errors),
+		csvText(m.Health),
+		csvList(m.HealthReasons),
+	}
+}
+
+// csvText neutralizes values that spreadsheets would evaluate as formulas by prefixing them with a quote.
+// Quoting of separators and line breaks is left to the CSV writer.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvList joins a list into a single cell
+func csvList(values []string) string {
+	return csvText(strings.Join(values, ";"))
+}
+
+// csvCounts joins counts into a single cell as name=count pairs, sorted by name
+func csvCounts(counts map[string]int64) string {
+	pairs := make([]string, 0, len(counts))
+	for name, count := range counts {
+		pairs = append(pairs, name+"="+strconv.FormatInt(count, 10))
+	}
+	sort.Strings(pairs)
+	return csvList(pairs)
+}
+
+// csvFloat formats a number without exponent, so spreadsheets parse it as a number
+func csvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// csvTime formats a timestamp as RFC 3339, leaving unset timestamps empty
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ripple/db"
+	"ripple/export"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultExportJobsLimit = 50
+	maxExportJobsLimit     = 500
+)
+
+// ExportHandler handles HTTP requests for export jobs
+type ExportHandler struct {
+	repo        *db.ExportRepository
+	destination string
+}
+
+// NewExportHandler creates a new export handler for jobs writing to the given bucket URL
+func NewExportHandler(repo *db.ExportRepository, destination string) *ExportHandler {
+	return &ExportHandler{
+		repo:        repo,
+		destination: destination,
+	}
+}
+
+// RegisterRoutes registers the export routes
+func (h *ExportHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/exports", h.CreateExport).Methods("POST")
+	router.HandleFunc("/api/v1/exports", h.ListExports).Methods("GET")
+	router.HandleFunc("/api/v1/exports/{exportId}", h.GetExport).Methods("GET")
+}
+
+// CreateExport handles POST /api/v1/exports
+func (h *ExportHandler) CreateExport(w http.ResponseWriter, r *http.Request) {
+	var req models.ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = export.FormatParquet
+	}
+	if req.Format != export.FormatParquet {
+		http.Error(w, fmt.Sprintf("Unsupported export format %q, use parquet", req.Format), http.StatusBadRequest)
+		return
+	}
+	if req.From.IsZero() || req.To.IsZero() {
+		http.Error(w, "Invalid export: from and to are required", http.StatusBadRequest)
+		return
+	}
+	if !req.From.Before(req.To) {
+		http.Error(w, "Invalid export: from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	job := &models.ExportJob{
+		Format:      req.Format,
+		From:        req.From.UTC(),
+		To:          req.To.UTC(),
+		Destination: h.destination,
+	}
+	if err := exportRepoFor(r.Context(), h.repo).CreateExportJob(r.Context(), job); err != nil {
+		http.Error(w, "Failed to create export job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, job)
+}
+
+// ListExports handles GET /api/v1/exports
+func (h *ExportHandler) ListExports(w http.ResponseWriter, r *http.Request) {
+	limit := int64(defaultExportJobsLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxExportJobsLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxExportJobsLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := exportRepoFor(r.Context(), h.repo).ListExportJobs(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve export jobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, jobs)
+}
+
+// GetExport handles GET /api/v1/exports/{exportId}
+func (h *ExportHandler) GetExport(w http.ResponseWriter, r *http.Request) {
+	jobID, err := primitive.ObjectIDFromHex(mux.Vars(r)["exportId"])
+	if err != nil {
+		http.Error(w, "Invalid export ID format", http.StatusBadRequest)
+		return
+	}
+
+	job, err := exportRepoFor(r.Context(), h.repo).GetExportJob(r.Context(), jobID)
+	if err != nil {
+		if err.Error() == "export job not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve export job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, job)
+}
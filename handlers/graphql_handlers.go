@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// defaultGraphQLRunsLimit is the number of runs returned by nested run fields when no limit is given
+const defaultGraphQLRunsLimit = 20
+
+// GraphQLHandler handles GraphQL read requests for the dashboard
+type GraphQLHandler struct {
+	agentRepo  db.AgentStore
+	uiRepo     db.UIStore
+	traceLinks TraceLinkTemplates
+	schema     graphql.Schema
+}
+
+// GraphQLRequest represents a GraphQL request body
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// NewGraphQLHandler creates a new GraphQL handler
+func NewGraphQLHandler(agentRepo db.AgentStore, uiRepo db.UIStore, traceLinks TraceLinkTemplates) (*GraphQLHandler, error) {
+	h := &GraphQLHandler{
+		agentRepo:  agentRepo,
+		uiRepo:     uiRepo,
+		traceLinks: traceLinks,
+	}
+
+	schema, err := h.buildSchema()
+	if err != nil {
+		return nil, err
+	}
+	h.schema = schema
+
+	return h, nil
+}
+
+// RegisterRoutes registers the GraphQL routes
+func (h *GraphQLHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/graphql", h.Query).Methods("GET", "POST")
+}
+
+// Query handles GET and POST /graphql
+func (h *GraphQLHandler) Query(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if req.Query == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+	if err := checkGraphQLLimits(h.schema, req); err != nil {
+		respondJSON(w, http.StatusBadRequest, &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(err.Error())}})
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        withGraphQLLoader(r.Context()),
+	})
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// objectIDType serializes Mongo ObjectIDs as hex strings
+var objectIDType = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "ObjectID",
+	Description: "A MongoDB ObjectID, as a hex string",
+	Serialize: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case primitive.ObjectID:
+			return v.Hex()
+		case *primitive.ObjectID:
+			return v.Hex()
+		}
+		return nil
+	},
+	ParseValue: func(value interface{}) interface{} {
+		if s, ok := value.(string); ok {
+			if id, err := primitive.ObjectIDFromHex(s); err == nil {
+				return id
+			}
+		}
+		return nil
+	},
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		if s, ok := valueAST.(*ast.StringValue); ok {
+			if id, err := primitive.ObjectIDFromHex(s.Value); err == nil {
+				return id
+			}
+		}
+		return nil
+	},
+})
+
+// buildSchema builds the GraphQL schema exposing agents, versions, runs and metrics
+func (h *GraphQLHandler) buildSchema() (graphql.Schema, error) {
+	stringList := graphql.NewList(graphql.String)
+	limitArgs := graphql.FieldConfigArgument{
+		"limit": &graphql.ArgumentConfig{
+			Type:         graphql.Int,
+			DefaultValue: defaultGraphQLRunsLimit,
+		},
+	}
+
+	metricsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AgentVersionMetrics",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: objectIDType},
+			"name":        &graphql.Field{Type: graphql.String},
+			"project":     &graphql.Field{Type: graphql.String},
+			"status":      &graphql.Field{Type: graphql.String},
+			"lastSeen":    &graphql.Field{Type: graphql.DateTime},
+			"version":     &graphql.Field{Type: graphql.String},
+			"avgRuntime":  &graphql.Field{Type: graphql.Float},
+			"successRate": &graphql.Field{Type: graphql.Float},
+			"totalRuns":   &graphql.Field{Type: graphql.Int},
+			"spend":       &graphql.Field{Type: graphql.Float},
+			"spendToday":  &graphql.Field{Type: graphql.Float},
+			"spend7d":     &graphql.Field{Type: graphql.Float},
+			"spend30d":    &graphql.Field{Type: graphql.Float},
+			"spendTotal":  &graphql.Field{Type: graphql.Float},
+			"tools":       &graphql.Field{Type: stringList},
+			"models":      &graphql.Field{Type: stringList},
+			"cluster":     &graphql.Field{Type: graphql.String},
+			"health":      &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	runType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AgentRun",
+		Fields: graphql.Fields{
+			"id":                &graphql.Field{Type: objectIDType},
+			"agent_id":          &graphql.Field{Type: objectIDType},
+			"version_id":        &graphql.Field{Type: objectIDType},
+			"version":           &graphql.Field{Type: graphql.String},
+			"created":           &graphql.Field{Type: graphql.DateTime},
+			"status":            &graphql.Field{Type: graphql.String},
+			"time_taken":        &graphql.Field{Type: graphql.Float},
+			"initiator":         &graphql.Field{Type: graphql.String},
+			"tools":             &graphql.Field{Type: stringList},
+			"cost":              &graphql.Field{Type: graphql.Float},
+			"models":            &graphql.Field{Type: stringList},
+			"run_id":            &graphql.Field{Type: graphql.Int},
+			"task_id":           &graphql.Field{Type: graphql.Int},
+			"recorded_at":       &graphql.Field{Type: graphql.DateTime},
+			"trace_id":          &graphql.Field{Type: graphql.String},
+			"span_id":           &graphql.Field{Type: graphql.String},
+			"error_type":        &graphql.Field{Type: graphql.String},
+			"error_message":     &graphql.Field{Type: graphql.String},
+			"prompt_tokens":     &graphql.Field{Type: graphql.Int},
+			"completion_tokens": &graphql.Field{Type: graphql.Int},
+			"trace_links": &graphql.Field{
+				Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+					Name: "TraceLink",
+					Fields: graphql.Fields{
+						"name": &graphql.Field{Type: graphql.String},
+						"url":  &graphql.Field{Type: graphql.String},
+					},
+				})),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					run := p.Source.(models.AgentRun)
+					links := []map[string]interface{}{}
+					for name, url := range h.traceLinks.Links(run.TraceID, run.SpanID) {
+						links = append(links, map[string]interface{}{"name": name, "url": url})
+					}
+					return links, nil
+				},
+			},
+		},
+	})
+
+	versionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AgentVersion",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: objectIDType},
+			"agent_id":   &graphql.Field{Type: objectIDType},
+			"version":    &graphql.Field{Type: graphql.String},
+			"cluster":    &graphql.Field{Type: graphql.String},
+			"status":     &graphql.Field{Type: graphql.String},
+			"tools":      &graphql.Field{Type: stringList},
+			"models":     &graphql.Field{Type: stringList},
+			"deployment": &graphql.Field{Type: graphql.String},
+			"created_at": &graphql.Field{Type: graphql.DateTime},
+			"updated_at": &graphql.Field{Type: graphql.DateTime},
+			"runs": &graphql.Field{
+				Type: graphql.NewList(runType),
+				Args: limitArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					version := p.Source.(models.AgentVersion)
+					limit, err := graphQLLimit(p)
+					if err != nil {
+						return nil, err
+					}
+					return graphQLLoaderFrom(p.Context).load(fmt.Sprintf("version.runs:%d", limit), version.ID, version,
+						func(versions map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error) {
+							return h.loadVersionRuns(p.Context, versions, limit)
+						}), nil
+				},
+			},
+			"metrics": &graphql.Field{
+				Type: metricsType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					version := p.Source.(models.AgentVersion)
+					return graphQLLoaderFrom(p.Context).load("version.metrics", version.ID, version,
+						func(versions map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error) {
+							return h.loadMetrics(p.Context, versions)
+						}), nil
+				},
+			},
+		},
+	})
+
+	agentType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Agent",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: objectIDType},
+			"name":       &graphql.Field{Type: graphql.String},
+			"project":    &graphql.Field{Type: graphql.String},
+			"created_at": &graphql.Field{Type: graphql.DateTime},
+			"updated_at": &graphql.Field{Type: graphql.DateTime},
+			"versions": &graphql.Field{
+				Type: graphql.NewList(versionType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					agent := p.Source.(models.Agent)
+					return graphQLLoaderFrom(p.Context).load("agent.versions", agent.ID, agent,
+						func(agents map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error) {
+							return h.loadVersions(p.Context, agents)
+						}), nil
+				},
+			},
+			"runs": &graphql.Field{
+				Type: graphql.NewList(runType),
+				Args: limitArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					agent := p.Source.(models.Agent)
+					limit, err := graphQLLimit(p)
+					if err != nil {
+						return nil, err
+					}
+					return graphQLLoaderFrom(p.Context).load(fmt.Sprintf("agent.runs:%d", limit), agent.ID, agent,
+						func(agents map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error) {
+							return h.loadAgentRuns(p.Context, agents, limit)
+						}), nil
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"agents": &graphql.Field{
+				Type: graphql.NewList(agentType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return agentRepoFor(p.Context, h.agentRepo).ListAgents(p.Context, db.ListOptions{})
+				},
+			},
+			"agent": &graphql.Field{
+				Type: agentType,
+				Args: graphql.FieldConfigArgument{
+					"id":   &graphql.ArgumentConfig{Type: objectIDType},
+					"name": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var agent *models.Agent
+					var err error
+					if id, ok := p.Args["id"].(primitive.ObjectID); ok {
+						agent, err = agentRepoFor(p.Context, h.agentRepo).GetAgentByID(p.Context, id)
+					} else if name, ok := p.Args["name"].(string); ok {
+						agent, err = agentRepoFor(p.Context, h.agentRepo).GetAgentByName(p.Context, name)
+					} else {
+						return nil, errors.New("either id or name must be provided")
+					}
+					if err != nil {
+						if err.Error() == "agent not found" {
+							return nil, nil
+						}
+						return nil, err
+					}
+					return *agent, nil
+				},
+			},
+			"agentVersionMetrics": &graphql.Field{
+				Type: graphql.NewList(metricsType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return uiRepoFor(p.Context, h.uiRepo).GetAgentVersions(p.Context, db.VersionMetricsFilter{}, db.ListOptions{})
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: queryType,
+	})
+}
+
+// graphQLLimit returns the limit argument of a runs field, which must be positive
+func graphQLLimit(p graphql.ResolveParams) (int64, error) {
+	limit := p.Args["limit"].(int)
+	if limit < 1 {
+		return 0, errors.New("limit must be at least 1")
+	}
+	return int64(limit), nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const (
+	// maxGraphQLDepth bounds the nesting of the fields of a GraphQL query. It leaves room for the introspection
+	// queries of GraphQL clients, which nest the types of fields several levels deep.
+	maxGraphQLDepth = 15
+	// maxGraphQLComplexity bounds the number of values a GraphQL query may resolve, as estimated by graphQLCost
+	maxGraphQLComplexity = 50000
+	// graphQLListSize is the number of items a list field without a limit argument is estimated to resolve
+	graphQLListSize = 10
+)
+
+// checkGraphQLLimits rejects the queries nested deeper than maxGraphQLDepth or more complex than
+// maxGraphQLComplexity, before they are executed. Queries that do not parse, or whose operation is not found, are
+// left to graphql-go to report.
+func checkGraphQLLimits(schema graphql.Schema, req GraphQLRequest) error {
+	document, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return nil
+	}
+
+	cost := &graphQLCost{
+		fragments: map[string]*ast.FragmentDefinition{},
+		variables: req.Variables,
+		spreading: map[string]bool{},
+	}
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch d := definition.(type) {
+		case *ast.FragmentDefinition:
+			cost.fragments[d.Name.Value] = d
+		case *ast.OperationDefinition:
+			if req.OperationName == "" || (d.Name != nil && d.Name.Value == req.OperationName) {
+				operations = append(operations, d)
+			}
+		}
+	}
+	if len(operations) != 1 {
+		return nil
+	}
+
+	complexity, err := cost.selections(operations[0].SelectionSet, schema.QueryType(), 1)
+	if err != nil {
+		return err
+	}
+	if complexity > maxGraphQLComplexity {
+		return fmt.Errorf("query complexity %d is above the limit of %d", complexity, maxGraphQLComplexity)
+	}
+	return nil
+}
+
+// graphQLCost computes the complexity of a query: every field costs 1, and the fields selected on the items of a
+// list cost as many times as the list may hold items, the limit of the runs fields and graphQLListSize for the other
+// lists
+type graphQLCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// spreading are the fragments being spread
+	spreading map[string]bool
+}
+
+// selections returns the complexity of a selection set of fields of a type, at a depth
+func (c *graphQLCost) selections(set *ast.SelectionSet, parent *graphql.Object, depth int) (int, error) {
+	if set == nil {
+		return 0, nil
+	}
+
+	complexity := 0
+	for _, selection := range set.Selections {
+		cost, err := 0, error(nil)
+		switch s := selection.(type) {
+		case *ast.Field:
+			cost, err = c.field(s, parent, depth)
+		case *ast.InlineFragment:
+			cost, err = c.selections(s.SelectionSet, parent, depth)
+		case *ast.FragmentSpread:
+			fragment := c.fragments[s.Name.Value]
+			if fragment == nil {
+				continue
+			}
+			// graphql-go would recurse through such fragments until the stack overflows
+			if c.spreading[s.Name.Value] {
+				return 0, fmt.Errorf("fragment %s spreads itself", s.Name.Value)
+			}
+			c.spreading[s.Name.Value] = true
+			cost, err = c.selections(fragment.SelectionSet, parent, depth)
+			delete(c.spreading, s.Name.Value)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// Stop as soon as the limit is reached, before large lists multiply the complexity further
+		complexity += cost
+		if complexity > maxGraphQLComplexity {
+			return complexity, nil
+		}
+	}
+	return complexity, nil
+}
+
+// field returns the complexity of a field of a type, at a depth, and of its selections
+func (c *graphQLCost) field(field *ast.Field, parent *graphql.Object, depth int) (int, error) {
+	if depth > maxGraphQLDepth {
+		return 0, fmt.Errorf("query is nested deeper than %d fields", maxGraphQLDepth)
+	}
+	if field.SelectionSet == nil {
+		return 1, nil
+	}
+
+	items := 1
+	var object *graphql.Object
+	if definition := graphQLField(parent, field.Name.Value); definition != nil {
+		fieldType := definition.Type
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+		}
+		if list, ok := fieldType.(*graphql.List); ok {
+			items, fieldType = graphQLListSize, list.OfType
+			if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+				fieldType = nonNull.OfType
+			}
+			for _, arg := range definition.Args {
+				if arg.Name() == "limit" {
+					items = c.limit(field)
+				}
+			}
+		}
+		object, _ = fieldType.(*graphql.Object)
+	}
+
+	children, err := c.selections(field.SelectionSet, object, depth+1)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + items*children, nil
+}
+
+// limit returns the limit argument of a field, given as a literal or a variable, or its default
+func (c *graphQLCost) limit(field *ast.Field) int {
+	limit := defaultGraphQLRunsLimit
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				limit = n
+			}
+		case *ast.Variable:
+			switch n := c.variables[value.Name.Value].(type) {
+			case float64:
+				limit = int(n)
+			case int:
+				limit = n
+			}
+		}
+	}
+	if limit < 1 {
+		// Limits below 1 are rejected by the resolvers
+		return 1
+	}
+	// Larger limits are above the complexity limit whatever their selections, and would overflow it
+	return min(limit, maxGraphQLComplexity+1)
+}
+
+// graphQLField returns the definition of a field of a type, the introspection fields included, or nil when the type
+// has no such field
+func graphQLField(parent *graphql.Object, name string) *graphql.FieldDefinition {
+	switch name {
+	case "__schema":
+		return graphql.SchemaMetaFieldDef
+	case "__type":
+		return graphql.TypeMetaFieldDef
+	case "__typename":
+		return graphql.TypeNameMetaFieldDef
+	}
+	if parent == nil {
+		return nil
+	}
+	return parent.Fields()[name]
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// graphQLLoaderKey is the context key of the loader of a GraphQL request
+type graphQLLoaderKey struct{}
+
+// graphQLLoadFunc reads the results of a batch, by the ID of the agent or version each source is read for
+type graphQLLoadFunc func(sources map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error)
+
+// graphQLLoader batches the reads of the nested fields of a GraphQL request. Their resolvers add the agent or version
+// they are resolved for to the batch of their field and return a thunk, which graphql-go only calls once the fields
+// of every agent or version of the level are resolved. The first thunk called reads the whole batch, in one query
+// when the store supports it, and the others find their result read.
+type graphQLLoader struct {
+	mu      sync.Mutex
+	batches map[string]*graphQLBatch
+}
+
+// graphQLBatch is the agents or versions of a field read together, and their results once read
+type graphQLBatch struct {
+	sources map[primitive.ObjectID]interface{}
+	load    graphQLLoadFunc
+	loaded  bool
+	results map[primitive.ObjectID]interface{}
+	err     error
+}
+
+// withGraphQLLoader returns a context carrying a new loader, for the resolvers of a request
+func withGraphQLLoader(ctx context.Context) context.Context {
+	return context.WithValue(ctx, graphQLLoaderKey{}, &graphQLLoader{batches: map[string]*graphQLBatch{}})
+}
+
+// graphQLLoaderFrom returns the loader of a request
+func graphQLLoaderFrom(ctx context.Context) *graphQLLoader {
+	return ctx.Value(graphQLLoaderKey{}).(*graphQLLoader)
+}
+
+// load adds the source of a field, an agent or a version, to the batch named after the field and its arguments, and
+// returns the thunk resolving the field from the results of the batch
+func (l *graphQLLoader) load(name string, id primitive.ObjectID, source interface{}, load graphQLLoadFunc) func() (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	batch := l.batches[name]
+	if batch == nil || batch.loaded {
+		// The fields resolved once the batch of their level was read start a new batch
+		batch = &graphQLBatch{sources: map[primitive.ObjectID]interface{}{}, load: load}
+		l.batches[name] = batch
+	}
+	batch.sources[id] = source
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if !batch.loaded {
+			batch.results, batch.err = batch.load(batch.sources)
+			batch.loaded = true
+		}
+		if batch.err != nil {
+			return nil, batch.err
+		}
+		return batch.results[id], nil
+	}
+}
+
+// graphQLIDs returns the IDs of the sources of a batch
+func graphQLIDs(sources map[primitive.ObjectID]interface{}) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(sources))
+	for id := range sources {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// loadVersions reads the versions of the agents of a batch
+func (h *GraphQLHandler) loadVersions(ctx context.Context, agents map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error) {
+	repo := agentRepoFor(ctx, h.agentRepo)
+	results := make(map[primitive.ObjectID]interface{}, len(agents))
+	if batch, ok := repo.(db.BatchStore); ok {
+		versions, err := batch.GetVersionsOfAgents(ctx, graphQLIDs(agents))
+		if err != nil {
+			return nil, err
+		}
+		for id := range agents {
+			results[id] = versions[id]
+		}
+		return results, nil
+	}
+
+	for id := range agents {
+		versions, err := repo.GetAgentVersions(ctx, id, db.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		results[id] = versions
+	}
+	return results, nil
+}
+
+// loadAgentRuns reads the latest runs of the agents of a batch, at most limit per agent
+func (h *GraphQLHandler) loadAgentRuns(ctx context.Context, agents map[primitive.ObjectID]interface{}, limit int64) (map[primitive.ObjectID]interface{}, error) {
+	repo := agentRepoFor(ctx, h.agentRepo)
+	results := make(map[primitive.ObjectID]interface{}, len(agents))
+	if batch, ok := repo.(db.BatchStore); ok {
+		runs, err := batch.GetRunsOfAgents(ctx, graphQLIDs(agents), limit)
+		if err != nil {
+			return nil, err
+		}
+		for id := range agents {
+			results[id] = runs[id]
+		}
+		return results, nil
+	}
+
+	for id := range agents {
+		runs, err := repo.GetAgentRuns(ctx, id, db.ListOptions{Limit: limit})
+		if err != nil {
+			return nil, err
+		}
+		results[id] = runs
+	}
+	return results, nil
+}
+
+// loadVersionRuns reads the latest runs of the versions of a batch, at most limit per version
+func (h *GraphQLHandler) loadVersionRuns(ctx context.Context, versions map[primitive.ObjectID]interface{}, limit int64) (map[primitive.ObjectID]interface{}, error) {
+	repo := agentRepoFor(ctx, h.agentRepo)
+	results := make(map[primitive.ObjectID]interface{}, len(versions))
+	if batch, ok := repo.(db.BatchStore); ok {
+		runs, err := batch.GetRunsOfVersions(ctx, graphQLIDs(versions), limit)
+		if err != nil {
+			return nil, err
+		}
+		for id := range versions {
+			results[id] = runs[id]
+		}
+		return results, nil
+	}
+
+	for id, source := range versions {
+		version := source.(models.AgentVersion)
+		runs, err := repo.GetAgentVersionRuns(ctx, version.AgentID, version.Version, db.ListOptions{Limit: limit})
+		if err != nil {
+			return nil, err
+		}
+		results[id] = runs
+	}
+	return results, nil
+}
+
+// loadMetrics reads the metrics of the versions of a batch, leaving out the versions without metrics
+func (h *GraphQLHandler) loadMetrics(ctx context.Context, versions map[primitive.ObjectID]interface{}) (map[primitive.ObjectID]interface{}, error) {
+	repo := uiRepoFor(ctx, h.uiRepo)
+	results := make(map[primitive.ObjectID]interface{}, len(versions))
+	if batch, ok := repo.(db.BatchUIStore); ok {
+		metrics, err := batch.GetMetricsOfVersions(ctx, graphQLIDs(versions))
+		if err != nil {
+			return nil, err
+		}
+		for id, m := range metrics {
+			results[id] = m
+		}
+		return results, nil
+	}
+
+	for id := range versions {
+		metrics, err := repo.GetAgentVersionMetrics(ctx, id)
+		if err != nil && err.Error() == "metrics not found for this version" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[id] = metrics
+	}
+	return results, nil
+}
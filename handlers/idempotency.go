@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the idempotency key of a write request
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on responses replayed from a stored snapshot
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength caps the length of idempotency keys
+	maxIdempotencyKeyLength = 255
+)
+
+// credentialFields are the fields of the responses carrying credentials, API keys, ingest tokens and signing, webhook
+// and TOTP secrets, which are removed from their stored snapshot
+var credentialFields = []string{"key", "ingest_token", "signing_secret", "secret", "uri"}
+
+// IdempotencyMiddleware replays the stored response of POST requests retried with the same Idempotency-Key header,
+// instead of running them again. Keys are scoped to the request path and to the caller, and kept for ttl. A key
+// reused with a different body is rejected with 422, and a retry sent while the first request is still running with
+// 409. Responses with a 5xx status are not stored, so the request can be retried. Responses marked Cache-Control:
+// no-store carry credentials, which are only returned once: they are stored and replayed without them.
+func IdempotencyMiddleware(repo *db.IdempotencyRepository, ttl time.Duration) mux.MiddlewareFunc {
+	var indexed sync.Map
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				respondBodyError(w, "Failed to read request body", err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			repo := idempotencyRepoFor(r.Context(), repo, &indexed, ttl)
+			record := &models.IdempotencyRecord{
+				ID:          idempotencyID(r, key),
+				Key:         key,
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				RequestHash: requestHash(r, body),
+			}
+
+			existing, err := repo.ReserveKey(r.Context(), record)
+			if err == nil && existing != nil && time.Since(existing.CreatedAt) > ttl {
+				// The record expired but has not been removed by the TTL monitor yet
+				if err = repo.ReleaseKey(r.Context(), existing.ID); err == nil {
+					existing, err = repo.ReserveKey(r.Context(), record)
+				}
+			}
+			if err != nil {
+				http.Error(w, "Failed to reserve idempotency key: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if existing != nil {
+				replayIdempotentResponse(w, existing, record.RequestHash)
+				return
+			}
+
+			rec := &idempotencyRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+
+			// The key is released or completed even when the client went away while the request was handled
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+				if err := repo.ReleaseKey(ctx, record.ID); err != nil {
+					log.Printf("Failed to release idempotency key %q: %v", key, err)
+				}
+				return
+			}
+			body = rec.body.Bytes()
+			if rec.Header().Get("Cache-Control") == "no-store" {
+				body = withoutCredentials(body)
+			}
+			if err := repo.CompleteKey(ctx, record.ID, rec.status, rec.Header().Get("Content-Type"), body); err != nil {
+				log.Printf("Failed to store response of idempotency key %q: %v", key, err)
+			}
+		})
+	}
+}
+
+// replayIdempotentResponse responds to a request whose key is already stored
+func replayIdempotentResponse(w http.ResponseWriter, record *models.IdempotencyRecord, requestHash string) {
+	if record.RequestHash != requestHash {
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if !record.Completed {
+		http.Error(w, "A request with this Idempotency-Key is still in progress, retry later", http.StatusConflict)
+		return
+	}
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// withoutCredentials removes the credential fields from a JSON response, and returns no body when it is not JSON
+func withoutCredentials(body []byte) []byte {
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil
+	}
+	stripped, err := json.Marshal(removeCredentials(document))
+	if err != nil {
+		return nil
+	}
+	return stripped
+}
+
+// removeCredentials removes the credential fields from the objects of a decoded JSON document
+func removeCredentials(document interface{}) interface{} {
+	switch value := document.(type) {
+	case map[string]interface{}:
+		for _, field := range credentialFields {
+			delete(value, field)
+		}
+		for name, item := range value {
+			value[name] = removeCredentials(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = removeCredentials(item)
+		}
+	}
+	return document
+}
+
+// idempotencyRepoFor returns the idempotency repository of the request's tenant, or the default repository.
+// The TTL index of each database is created on first use.
+func idempotencyRepoFor(ctx context.Context, fallback *db.IdempotencyRepository, indexed *sync.Map, ttl time.Duration) *db.IdempotencyRepository {
+	repo, name := fallback, ""
+	if database := db.DatabaseFromContext(ctx); database != nil {
+		repo, name = db.NewIdempotencyRepository(database), database.Key()
+	}
+
+	if _, ok := indexed.Load(name); !ok {
+		if err := repo.EnsureIndexes(ctx, ttl); err != nil {
+			log.Printf("Failed to create idempotency key indexes: %v", err)
+		} else {
+			indexed.Store(name, true)
+		}
+	}
+	return repo
+}
+
+// requestHash fingerprints a request, to detect keys reused for a different request
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyRecorder captures the status and body of a response while writing it
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotencyID identifies the record of a request by its method, path and idempotency key, by its caller, and by its
+// organization and project when scoped to them, as callers, organizations and projects may use the same keys
+func idempotencyID(r *http.Request, key string) string {
+	id := r.Method + " " + r.URL.Path + " " + key
+	if principal := idempotencyPrincipal(r); principal != "" {
+		id = principal + " " + id
+	}
+	if database := db.DatabaseFromContext(r.Context()); database != nil {
+		if database.Project != "" {
+			id = "project:" + database.Project + " " + id
+		}
+		if !database.OrgID.IsZero() {
+			id = database.OrgID.Hex() + " " + id
+		}
+	}
+	return id
+}
+
+// idempotencyPrincipal identifies the caller of a request: the subject of its credentials, the hash of its ingest
+// token, or the agent of its client certificate, so that the stored responses are only replayed to their caller
+func idempotencyPrincipal(r *http.Request) string {
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil {
+		return "subject:" + claims.Subject
+	}
+	if hash := ingestTokenHash(r.Context()); hash != "" {
+		return "ingest_token:" + hash
+	}
+	if agent := clientCertAgent(r.Context()); agent != "" {
+		return "agent:" + agent
+	}
+	return ""
+}
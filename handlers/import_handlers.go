@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/ingest"
+	"ripple/models"
+	"ripple/policy"
+)
+
+const (
+	// maxImportErrors caps the item errors listed in an import report
+	maxImportErrors = 100
+	// importBatchSize is the number of runs inserted per batch when applying an import
+	importBatchSize = 1000
+)
+
+// importAgent is an agent of a validated import, with an ID when it already exists
+type importAgent struct {
+	agent    *models.Agent
+	exists   bool
+	versions map[string]*importVersion
+	order    []string
+	runIDs   map[int64]bool
+}
+
+// importVersion is an agent version of a validated import and the runs to import
+type importVersion struct {
+	req    models.RegisterAgentVersionRequest
+	exists bool
+	runs   []*models.AgentRun
+}
+
+// Import handles POST /api/v1/import
+func (h *AgentHandler) Import(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "Invalid dry_run, expected true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondBodyError(w, "Failed to read request body", err)
+		return
+	}
+
+	var data *ingest.ImportData
+	var itemErrors []models.ImportItemError
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		data, itemErrors, err = ingest.DecodeImportCSV(bytes.NewReader(body))
+	} else {
+		data, itemErrors, err = ingest.DecodeImportJSON(body, h.schemas)
+	}
+	if err != nil {
+		http.Error(w, "Invalid import: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, a := range data.Agents {
+		if !h.can(w, r, policy.WriteRuns, policy.Resource{Project: a.Project}) || !inRegion(w, r, a.Project) {
+			return
+		}
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+	report := &models.ImportReport{DryRun: dryRun, Errors: []models.ImportItemError{}}
+	addErrors(report, itemErrors...)
+
+	agents, err := planImport(r.Context(), repo, data, report)
+	if err != nil {
+		http.Error(w, "Failed to validate import: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !h.canImport(w, r, agents) {
+		return
+	}
+
+	if report.ErrorCount > 0 {
+		// Nothing is imported unless the whole import is valid
+		respondJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	if dryRun {
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	if !withinQuotas(w, r, int64(report.Agents.Created), int64(report.Runs.Imported)) {
+		return
+	}
+	if err := applyImport(r.Context(), repo, agents); err != nil {
+		http.Error(w, "Failed to import: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
+
+// canImport asks the policy whether the caller of an import may create its new agents and versions and write its
+// runs, as when registering them one by one, responding with 403 Forbidden when it may not
+func (h *AgentHandler) canImport(w http.ResponseWriter, r *http.Request, agents []*importAgent) bool {
+	for _, agent := range agents {
+		resource := policy.Resource{Project: agent.agent.Project, AgentID: agent.agent.ID}
+		if !agent.exists && !h.can(w, r, policy.Write, resource) {
+			return false
+		}
+		for _, name := range agent.order {
+			resource.Version = name
+			if !agent.versions[name].exists && !h.can(w, r, policy.WriteVersions, resource) {
+				return false
+			}
+			if !h.can(w, r, policy.WriteRuns, resource) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// planImport validates an import against the stored agents and versions, counting what the import creates in
+// the report. Runs whose run ID is already stored for their agent are left out as duplicates.
+func planImport(ctx context.Context, repo db.AgentStore, data *ingest.ImportData, report *models.ImportReport) ([]*importAgent, error) {
+	var agents []*importAgent
+	byName := map[string]*importAgent{}
+
+	for _, a := range data.Agents {
+		if a.Name == "" {
+			addErrors(report, models.ImportItemError{Location: a.Location, Message: "agent name is required"})
+			continue
+		}
+
+		agent, ok := byName[a.Name]
+		if !ok {
+			agent = &importAgent{
+				agent:    &models.Agent{Name: a.Name, Project: a.Project},
+				versions: map[string]*importVersion{},
+				runIDs:   map[int64]bool{},
+			}
+			existing, err := repo.GetAgentByName(ctx, a.Name)
+			if err == nil {
+				agent.agent, agent.exists = existing, true
+				report.Agents.Existing++
+			} else if err.Error() == "agent not found" {
+				report.Agents.Created++
+			} else {
+				return nil, err
+			}
+			byName[a.Name] = agent
+			agents = append(agents, agent)
+		}
+
+		for _, v := range a.Versions {
+			if err := planImportVersion(ctx, repo, agent, v, report); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Skip the runs already imported, so an import can be sent again
+	for _, agent := range agents {
+		existing := map[int64]bool{}
+		if agent.exists && len(agent.runIDs) > 0 {
+			runIDs := make([]int64, 0, len(agent.runIDs))
+			for id := range agent.runIDs {
+				runIDs = append(runIDs, id)
+			}
+			var err error
+			if existing, err = repo.GetExistingRunIDs(ctx, agent.agent.ID, runIDs); err != nil {
+				return nil, err
+			}
+		}
+
+		seen := map[int64]bool{}
+		for _, name := range agent.order {
+			version := agent.versions[name]
+			runs := version.runs[:0]
+			for _, run := range version.runs {
+				if run.RunID != 0 && (existing[run.RunID] || seen[run.RunID]) {
+					report.Runs.Duplicates++
+					continue
+				}
+				seen[run.RunID] = true
+				runs = append(runs, run)
+			}
+			version.runs = runs
+			report.Runs.Imported += len(runs)
+		}
+	}
+
+	return agents, nil
+}
+
+// planImportVersion validates a version of an import and its runs
+func planImportVersion(ctx context.Context, repo db.AgentStore, agent *importAgent, v *ingest.ImportVersion, report *models.ImportReport) error {
+	if v.Version == "" {
+		addErrors(report, models.ImportItemError{Location: v.Location, Message: "version is required"})
+		return nil
+	}
+
+	version, ok := agent.versions[v.Version]
+	if !ok {
+		version = &importVersion{req: v.RegisterAgentVersionRequest}
+		if agent.exists {
+			_, err := repo.GetAgentVersion(ctx, agent.agent.ID, v.Version)
+			if err == nil {
+				version.exists = true
+			} else if err.Error() != "version not found for this agent" {
+				return err
+			}
+		}
+		if version.exists {
+			report.Versions.Existing++
+		} else {
+			report.Versions.Created++
+		}
+		agent.versions[v.Version] = version
+		agent.order = append(agent.order, v.Version)
+	}
+
+	for _, r := range v.Runs {
+		if r.Created == "" {
+			addErrors(report, models.ImportItemError{Location: r.Location, Message: "created is required"})
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, r.Created); err != nil {
+			addErrors(report, models.ImportItemError{Location: r.Location, Message: fmt.Sprintf("invalid created %q, expected an RFC 3339 timestamp", r.Created)})
+			continue
+		}
+		if r.Status == "" {
+			addErrors(report, models.ImportItemError{Location: r.Location, Message: "status is required"})
+			continue
+		}
+
+		run, err := ingest.NewAgentRun(agent.agent.ID, v.Version, r.RegisterAgentRunRequest)
+		if err != nil {
+			addErrors(report, models.ImportItemError{Location: r.Location, Message: err.Error()})
+			continue
+		}
+		if run.RunID != 0 {
+			agent.runIDs[run.RunID] = true
+		}
+		version.runs = append(version.runs, run)
+	}
+
+	return nil
+}
+
+// applyImport creates the new agents and versions of a validated import, then inserts its runs in batches
+func applyImport(ctx context.Context, repo db.AgentStore, agents []*importAgent) error {
+	for _, agent := range agents {
+		if !agent.exists {
+			if err := repo.CreateAgent(ctx, agent.agent); err != nil {
+				return fmt.Errorf("unable to create agent %q: %w", agent.agent.Name, err)
+			}
+		}
+
+		for _, name := range agent.order {
+			version := agent.versions[name]
+			if !version.exists {
+				if err := repo.CreateAgentVersion(ctx, &models.AgentVersion{
+					AgentID:    agent.agent.ID,
+					Version:    version.req.Version,
+					Cluster:    version.req.Cluster,
+					Tools:      version.req.Tools,
+					Models:     version.req.Models,
+					Deployment: version.req.Deployment,
+				}); err != nil {
+					return fmt.Errorf("unable to create version %q of agent %q: %w", name, agent.agent.Name, err)
+				}
+			}
+
+			for _, run := range version.runs {
+				run.AgentID = agent.agent.ID
+			}
+			for start := 0; start < len(version.runs); start += importBatchSize {
+				end := min(start+importBatchSize, len(version.runs))
+				if err := repo.CreateAgentRunBatch(ctx, version.runs[start:end], db.BatchOptions{}); err != nil {
+					return fmt.Errorf("unable to import runs of version %q of agent %q: %w", name, agent.agent.Name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// addErrors records item errors in an import report, listing at most maxImportErrors of them
+func addErrors(report *models.ImportReport, itemErrors ...models.ImportItemError) {
+	for _, itemErr := range itemErrors {
+		report.ErrorCount++
+		if len(report.Errors) < maxImportErrors {
+			report.Errors = append(report.Errors, itemErr)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ingestTokenHashKey is the context key of the hash of the ingest token a request was sent with
+type ingestTokenHashKey struct{}
+
+// withIngestTokenHash returns a context carrying the hash of the ingest token of a request
+func withIngestTokenHash(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ingestTokenHashKey{}, models.HashIngestToken(token))
+}
+
+// ingestTokenHash returns the hash of the ingest token a request was sent with, set by AuthMiddleware, or an empty
+// string
+func ingestTokenHash(ctx context.Context) string {
+	hash, _ := ctx.Value(ingestTokenHashKey{}).(string)
+	return hash
+}
+
+// issueIngestToken issues a token writing the runs of an agent, or of one of its versions when version is set
+func issueIngestToken(ctx context.Context, repo db.AgentStore, agentID primitive.ObjectID, version string) (string, error) {
+	token, record, err := models.NewIngestToken(agentID, version)
+	if err != nil {
+		return "", err
+	}
+	if err := repo.CreateIngestToken(ctx, record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// requestIngestToken returns the ingest token of the Authorization header of a request, nil when the request sends
+// none or another credential
+func requestIngestToken(r *http.Request, repo db.AgentStore) (*models.IngestToken, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, models.IngestTokenPrefix) {
+		return nil, nil
+	}
+	return repo.GetIngestToken(r.Context(), models.HashIngestToken(token))
+}
+
+// authorizeIngest returns the ingest token of a request writing runs, responding with 401 Unauthorized when the
+// token is unknown, or missing while tokens are required. Requests authenticated with a JWT granting the ingest role
+// need no ingest token, and requests authenticated with a client certificate or signed with the signing secret of an
+// agent are given one for their agent.
+func (h *AgentHandler) authorizeIngest(w http.ResponseWriter, r *http.Request, repo db.AgentStore) (*models.IngestToken, bool) {
+	token, ok := h.requestIngestCredentials(w, r, repo)
+	if !ok {
+		return nil, false
+	}
+
+	// Signed requests only write the runs of the agent whose secret signed them
+	signer := signingAgent(r.Context())
+	if signer.IsZero() {
+		return token, true
+	}
+	if token == nil {
+		return &models.IngestToken{AgentID: signer}, true
+	}
+	if token.AgentID != signer {
+		http.Error(w, "The request is signed by another agent than the one of its ingest credentials", http.StatusForbidden)
+		return nil, false
+	}
+	return token, true
+}
+
+// requestIngestCredentials returns the ingest token of the client certificate or Authorization header of a request
+func (h *AgentHandler) requestIngestCredentials(w http.ResponseWriter, r *http.Request, repo db.AgentStore) (*models.IngestToken, bool) {
+	if agent := clientCertAgent(r.Context()); agent != "" {
+		return clientCertIngestToken(r.Context(), w, repo, agent)
+	}
+
+	token, err := requestIngestToken(r, repo)
+	if err != nil {
+		if err.Error() == "ingest token not found" {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid ingest token", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Failed to check ingest token: "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	if token == nil && h.requireIngestTokens && auth.ClaimsFromContext(r.Context()) == nil && signingAgent(r.Context()).IsZero() {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Missing ingest token, send the token issued at agent registration as Authorization: Bearer <token>", http.StatusUnauthorized)
+		return nil, false
+	}
+	return token, true
+}
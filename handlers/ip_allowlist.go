@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"ripple/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteGroupAdmin is the route group of the admin routes and of deletions, restricted further than the write routes
+const RouteGroupAdmin = "admin"
+
+// RouteGroups lists the route groups networks are allowed for
+var RouteGroups = []string{RouteClassIngest, RouteClassRead, RouteClassWrite, RouteGroupAdmin}
+
+// routeGroups are the route groups of the roles routes require
+var routeGroups = map[string]string{
+	auth.RoleIngest:      RouteClassIngest,
+	auth.RoleViewer:      RouteClassRead,
+	auth.RoleEditor:      RouteClassWrite,
+	auth.RoleAdmin:       RouteGroupAdmin,
+	auth.RoleVersions:    RouteClassWrite,
+	auth.RoleDeployments: RouteClassWrite,
+}
+
+// IPAllowlist restricts route groups to the networks they are allowed from, e.g. the admin routes to internal
+// ranges. Route groups without networks are allowed from anywhere.
+type IPAllowlist struct {
+	networks map[string][]netip.Prefix
+	ipSource ClientIPSource
+}
+
+// NewIPAllowlist creates an allowlist from networks in the form "admin=10.0.0.0/8,admin=192.168.0.0/16,ingest=10.1.2.3",
+// where each entry allows a route group from a CIDR range or a single address. The client IP address is taken from
+// ipSource.
+func NewIPAllowlist(allowlist string, ipSource ClientIPSource) (*IPAllowlist, error) {
+	al := &IPAllowlist{
+		networks: map[string][]netip.Prefix{},
+		ipSource: ipSource,
+	}
+	if allowlist == "" {
+		return al, nil
+	}
+
+	for _, entry := range strings.Split(allowlist, ",") {
+		group, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || group == "" || value == "" {
+			return nil, fmt.Errorf("invalid allowed network %q, expected group=cidr", entry)
+		}
+		if !contains(RouteGroups, group) {
+			return nil, fmt.Errorf("invalid route group %q, expected one of %s", group, strings.Join(RouteGroups, ", "))
+		}
+		network, err := parseNetwork(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q for %s, expected a CIDR range such as 10.0.0.0/8 or an IP address", value, group)
+		}
+		al.networks[group] = append(al.networks[group], network)
+	}
+
+	return al, nil
+}
+
+// parseNetwork parses a CIDR range, or a single IP address
+func parseNetwork(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	network, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(network.Addr().Unmap(), network.Bits()).Masked(), nil
+}
+
+// Enabled reports whether any route group is restricted
+func (al *IPAllowlist) Enabled() bool {
+	return len(al.networks) > 0
+}
+
+// Middleware responds with 403 Forbidden to requests from outside the networks allowed for the route group of the
+// request. It runs before the authentication middleware, so that the credentials of disallowed clients are not even
+// checked.
+func (al *IPAllowlist) Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			template, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			group := routeGroups[requiredRole(r.Method, template)]
+			networks := al.networks[group]
+			if len(networks) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !allowedFrom(networks, al.ipSource.clientIP(r)) {
+				http.Error(w, "Forbidden: "+group+" routes are not allowed from this network", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowedFrom reports whether ip is in one of the networks. Invalid addresses are in none.
+func allowedFrom(networks []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPSource tells where the IP address of the client of a request is taken from. Without a header, it is the
+// address of the connection. Behind Hops proxies each appending the address they received the request from to the
+// header, such as X-Forwarded-For, it is the Hops-th address from the right, the one the outermost proxy appended:
+// the addresses left of it are sent by the client, which can send any value.
+type ClientIPSource struct {
+	Header string
+	Hops   int
+}
+
+// clientIP returns the IP address of the client of a request. Requests whose header has fewer addresses than the
+// proxies did not come through them, and their connection address is returned.
+func (s ClientIPSource) clientIP(r *http.Request) string {
+	if s.Header != "" && s.Hops > 0 {
+		var addresses []string
+		for _, v := range r.Header.Values(s.Header) {
+			addresses = append(addresses, strings.Split(v, ",")...)
+		}
+		if len(addresses) >= s.Hops {
+			return strings.TrimSpace(addresses[len(addresses)-s.Hops])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
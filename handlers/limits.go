@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Default request limits
+const (
+	DefaultMaxBodyBytes     = 10 << 20
+	DefaultMaxBatchRuns     = 1000
+	DefaultMaxArtifactBytes = 1 << 30
+)
+
+type bodyLimitKey struct{}
+
+// BodyLimitMiddleware caps the size of request bodies, to maxArtifactBytes for artifact uploads, which are streamed
+// to their storage, and to maxBytes for the other requests. Reading past the limit fails, and handlers respond with
+// 413 Request Entity Too Large.
+func BodyLimitMiddleware(maxBytes, maxArtifactBytes int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				limit := maxBytes
+				if route := mux.CurrentRoute(r); route != nil && r.Method == http.MethodPost {
+					if template, _ := route.GetPathTemplate(); template == artifactsRoute {
+						limit = maxArtifactBytes
+					}
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, maxBytes)))
+		})
+	}
+}
+
+// bodyLimit returns the body size limit of a request, or 0 when unlimited. Handlers decompressing bodies apply it
+// to the decompressed size as well.
+func bodyLimit(r *http.Request) int64 {
+	limit, _ := r.Context().Value(bodyLimitKey{}).(int64)
+	return limit
+}
+
+// respondBodyError reports a failure to read or decode a request body, with 413 when the body is too large
+func respondBodyError(w http.ResponseWriter, message string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body too large, the limit is %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, message+": "+err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ripple/auth"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// oidcStateCookie carries the state, nonce and redirect of a sign in until the provider redirects back
+	oidcStateCookie = "ripple_oidc_state"
+	// oidcStateTTL is how long a sign in may take
+	oidcStateTTL = 10 * time.Minute
+)
+
+// OIDCHandler signs browser sessions in with an OpenID Connect provider
+type OIDCHandler struct {
+	oidc     *auth.OIDC
+	sessions *auth.Sessions
+	// secure marks the cookies as only sent over HTTPS
+	secure bool
+}
+
+// NewOIDCHandler creates a new OIDC handler. Cookies are marked secure when secure is set.
+func NewOIDCHandler(oidc *auth.OIDC, sessions *auth.Sessions, secure bool) *OIDCHandler {
+	return &OIDCHandler{
+		oidc:     oidc,
+		sessions: sessions,
+		secure:   secure,
+	}
+}
+
+// RegisterRoutes registers the sign in routes
+func (h *OIDCHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/auth/login", h.Login).Methods("GET")
+	router.HandleFunc("/auth/callback", h.Callback).Methods("GET")
+	router.HandleFunc("/auth/logout", h.Logout).Methods("POST")
+	router.HandleFunc("/auth/session", h.GetSession).Methods("GET")
+}
+
+// Login handles GET /auth/login
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	redirect := r.URL.Query().Get("redirect")
+	if !isLocalRedirect(redirect) {
+		redirect = "/"
+	}
+
+	state, err := randomHex()
+	if err != nil {
+		http.Error(w, "Failed to start sign in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomHex()
+	if err != nil {
+		http.Error(w, "Failed to start sign in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    url.Values{"state": {state}, "nonce": {nonce}, "redirect": {redirect}}.Encode(),
+		Path:     "/auth/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, h.oidc.AuthCodeURL(state, nonce), http.StatusFound)
+}
+
+// Callback handles GET /auth/callback
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if msg := query.Get("error"); msg != "" {
+		http.Error(w, "Sign in failed: "+msg+" "+query.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Sign in expired, start again at /auth/login", http.StatusBadRequest)
+		return
+	}
+	pending, err := url.ParseQuery(cookie.Value)
+	if err != nil || pending.Get("state") == "" || pending.Get("state") != query.Get("state") {
+		http.Error(w, "Invalid sign in state, start again at /auth/login", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/", MaxAge: -1})
+
+	claims, err := h.oidc.Exchange(r.Context(), query.Get("code"), pending.Get("nonce"))
+	if err != nil {
+		http.Error(w, "Sign in failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if len(claims.Roles) == 0 {
+		http.Error(w, "None of your groups is mapped to a role", http.StatusForbidden)
+		return
+	}
+
+	session, err := h.sessions.Issue(claims)
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, session, h.sessions.TTL(), h.secure)
+
+	redirect := pending.Get("redirect")
+	if !isLocalRedirect(redirect) {
+		redirect = "/"
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// Logout handles POST /auth/logout
+//
+// The session is signed out, and its cookie cleared.
+func (h *OIDCHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	signOut(w, r, h.sessions, h.secure)
+}
+
+// GetSession handles GET /auth/session
+func (h *OIDCHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, sessionInfo(claims))
+}
+
+// isLocalRedirect reports whether a redirect stays on the server, so that sign in cannot redirect to another site
+func isLocalRedirect(redirect string) bool {
+	return strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//") && !strings.HasPrefix(redirect, "/\\")
+}
+
+// randomHex returns 16 random bytes, hex encoded
+func randomHex() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
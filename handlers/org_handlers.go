@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// orgRoutePrefix starts the routes provisioning organizations, which only the bootstrap API key can call
+const orgRoutePrefix = "/api/v1/admin/orgs"
+
+// OrgHandler handles HTTP requests provisioning the organizations of a shared service: creating them, setting their
+// quotas and retention, and issuing their first admin API key
+type OrgHandler struct {
+	repo    *db.OrganizationRepository
+	tenants *db.TenantRouter
+}
+
+// NewOrgHandler creates a new organization handler. The usage and API keys of organizations are read and stored in
+// the database the tenant router selects for them, in their region.
+func NewOrgHandler(repo *db.OrganizationRepository, tenants *db.TenantRouter) *OrgHandler {
+	return &OrgHandler{
+		repo:    repo,
+		tenants: tenants,
+	}
+}
+
+// RegisterRoutes registers the organization routes
+func (h *OrgHandler) RegisterRoutes(router *mux.Router) {
+	orgRouter := router.PathPrefix(orgRoutePrefix).Subrouter()
+	orgRouter.Use(requireBootstrap)
+
+	orgRouter.HandleFunc("", h.CreateOrganization).Methods("POST")
+	orgRouter.HandleFunc("", h.ListOrganizations).Methods("GET")
+	orgRouter.HandleFunc("/{orgId}", h.GetOrganization).Methods("GET")
+	orgRouter.HandleFunc("/{orgId}", h.UpdateOrganization).Methods("PUT")
+	orgRouter.HandleFunc("/{orgId}/api_keys", h.CreateOrganizationAPIKey).Methods("POST")
+}
+
+// requireBootstrap rejects the requests of callers other than the bootstrap API key, since admins of an organization
+// or tenant must not provision others
+func requireBootstrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims := auth.ClaimsFromContext(r.Context()); claims == nil || !claims.Bootstrap {
+			http.Error(w, "Forbidden: organizations and tenants are only provisioned with the bootstrap API key", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateOrganization handles POST /api/v1/admin/orgs
+func (h *OrgHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Invalid organization: name is required", http.StatusBadRequest)
+		return
+	}
+	if !validOrganizationLimits(w, req.Quotas, req.RetentionDays) {
+		return
+	}
+	if req.Region != "" && !h.tenants.Regions().Has(req.Region) {
+		http.Error(w, "Invalid organization: unknown region "+req.Region, http.StatusBadRequest)
+		return
+	}
+
+	org := &models.Organization{
+		Name:          req.Name,
+		Quotas:        req.Quotas,
+		RetentionDays: req.RetentionDays,
+		Region:        req.Region,
+	}
+	if err := h.repo.CreateOrganization(r.Context(), org); err != nil {
+		if err.Error() == "organization already exists" {
+			http.Error(w, "An organization with this name already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create organization: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	// In org-database mode, the database of the organization is set up before it is used
+	if err := h.tenants.ProvisionOrg(r.Context(), org); err != nil {
+		http.Error(w, "Failed to provision organization: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, org)
+}
+
+// ListOrganizations handles GET /api/v1/admin/orgs
+func (h *OrgHandler) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	orgs, err := h.repo.ListOrganizations(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve organizations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, orgs)
+}
+
+// GetOrganization handles GET /api/v1/admin/orgs/{orgId}
+//
+// The organization is returned with its usage counted against its quotas.
+func (h *OrgHandler) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.requestOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	database, err := h.tenants.OrgDatabase(org)
+	if err != nil {
+		http.Error(w, "Failed to select organization database: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	usage, err := db.NewOrganizationRepository(database).GetOrganizationUsage(r.Context(), org.ID, time.Now().UTC().Truncate(24*time.Hour))
+	if err != nil {
+		http.Error(w, "Failed to count organization usage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	org.Usage = usage
+
+	respondJSON(w, http.StatusOK, org)
+}
+
+// UpdateOrganization handles PUT /api/v1/admin/orgs/{orgId}
+func (h *OrgHandler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, err := primitive.ObjectIDFromHex(mux.Vars(r)["orgId"])
+	if err != nil {
+		http.Error(w, "Invalid organization ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req models.UpdateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+	if !validOrganizationLimits(w, req.Quotas, req.RetentionDays) {
+		return
+	}
+
+	org, err := h.repo.UpdateOrganization(r.Context(), orgID, req.Quotas, req.RetentionDays)
+	if err != nil {
+		respondOrganizationError(w, "Failed to update organization", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, org)
+}
+
+// CreateOrganizationAPIKey handles POST /api/v1/admin/orgs/{orgId}/api_keys
+//
+// An admin API key of the organization is issued, with which its admins create its other credentials.
+func (h *OrgHandler) CreateOrganizationAPIKey(w http.ResponseWriter, r *http.Request) {
+	org, ok := h.requestOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+	if req.Name == "" {
+		req.Name = org.Name + " admin"
+	}
+
+	key, apiKey, err := models.NewAPIKey(req.Name, []string{models.ScopeAdmin})
+	if err != nil {
+		http.Error(w, "Failed to generate API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	database, err := h.tenants.OrgDatabase(org)
+	if err != nil {
+		http.Error(w, "Failed to select organization database: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := db.NewAPIKeyRepository(database).CreateAPIKey(r.Context(), apiKey); err != nil {
+		http.Error(w, "Failed to create API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiKey.Key = key
+
+	respondCredentials(w, http.StatusCreated, apiKey)
+}
+
+// requestOrganization returns the organization of the request's path, responding with an error when it is invalid
+// or unknown
+func (h *OrgHandler) requestOrganization(w http.ResponseWriter, r *http.Request) (*models.Organization, bool) {
+	orgID, err := primitive.ObjectIDFromHex(mux.Vars(r)["orgId"])
+	if err != nil {
+		http.Error(w, "Invalid organization ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	org, err := h.repo.GetOrganization(r.Context(), orgID)
+	if err != nil {
+		respondOrganizationError(w, "Failed to retrieve organization", err)
+		return nil, false
+	}
+	return org, true
+}
+
+// validOrganizationLimits checks the quotas and retention of an organization, responding with 400 Bad Request when
+// one is negative
+func validOrganizationLimits(w http.ResponseWriter, quotas models.OrganizationQuotas, retentionDays int) bool {
+	if quotas.MaxAgents < 0 || quotas.MaxRunsPerDay < 0 || retentionDays < 0 {
+		http.Error(w, "Invalid organization: quotas and retention_days must not be negative, 0 is unlimited", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// respondOrganizationError responds with 404 for unknown organizations, and 500 otherwise
+func respondOrganizationError(w http.ResponseWriter, message string, err error) {
+	if err.Error() == "organization not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, message+": "+err.Error(), http.StatusInternalServerError)
+}
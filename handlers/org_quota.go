@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+)
+
+type organizationKey struct{}
+
+// withinQuotas reports whether the organization of a request, in org tenant mode, may register agents more agents
+// and write runs more runs today. Requests exceeding a quota are answered with 429 Too Many Requests, and a
+// Retry-After header until the next UTC day for the runs quota.
+func withinQuotas(w http.ResponseWriter, r *http.Request, agents, runs int64) bool {
+	org, _ := r.Context().Value(organizationKey{}).(*models.Organization)
+	database := db.DatabaseFromContext(r.Context())
+	if org == nil || database == nil || (org.Quotas.MaxAgents == 0 || agents == 0) && (org.Quotas.MaxRunsPerDay == 0 || runs == 0) {
+		return true
+	}
+
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	usage, err := db.NewOrganizationRepository(database).GetOrganizationUsage(r.Context(), org.ID, today)
+	if err != nil {
+		http.Error(w, "Failed to count organization usage: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if max := org.Quotas.MaxAgents; max > 0 && agents > 0 && usage.Agents+agents > max {
+		http.Error(w, fmt.Sprintf("Agent quota exceeded: the organization has %d of its %d agents", usage.Agents, max), http.StatusTooManyRequests)
+		return false
+	}
+	if max := org.Quotas.MaxRunsPerDay; max > 0 && runs > 0 && usage.RunsToday+runs > max {
+		w.Header().Set("Retry-After", strconv.Itoa(int(today.Add(24*time.Hour).Sub(now).Seconds())+1))
+		http.Error(w, fmt.Sprintf("Run quota exceeded: the organization wrote %d of its %d runs today", usage.RunsToday, max), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
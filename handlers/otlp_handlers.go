@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ripple/db"
+	"ripple/ingest"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errMissingAgentAttribute   = errors.New("root span has no ripple.agent.name, gen_ai.agent.name or service.name attribute")
+	errMissingVersionAttribute = errors.New("root span has no ripple.agent.version, gen_ai.agent.version or service.version attribute")
+)
+
+// OTLPHandler handles OpenTelemetry traces exported over OTLP/HTTP
+type OTLPHandler struct {
+	repo db.AgentStore
+}
+
+// NewOTLPHandler creates a new OTLP handler
+func NewOTLPHandler(repo db.AgentStore) *OTLPHandler {
+	return &OTLPHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the OTLP routes
+func (h *OTLPHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/v1/traces", h.ExportTraces).Methods("POST")
+}
+
+// ExportTraces handles POST /v1/traces
+func (h *OTLPHandler) ExportTraces(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	isJSON := strings.HasPrefix(contentType, "application/json")
+	if !isJSON && !strings.HasPrefix(contentType, "application/x-protobuf") {
+		http.Error(w, "Unsupported content type, use application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid gzip body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+		if limit := bodyLimit(r); limit > 0 {
+			body = http.MaxBytesReader(w, gz, limit)
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		respondBodyError(w, "Failed to read request body", err)
+		return
+	}
+
+	traces, err := ingest.DecodeOTLPTraces(data, isJSON)
+	if err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+	agentIDs := map[string]primitive.ObjectID{}
+	var steps []*models.RunStep
+	var rejected int64
+	var messages []string
+
+	for _, trace := range ingest.OTLPTraces(traces) {
+		if trace.Run == nil {
+			// The root span has not been exported yet, keep the steps for when it arrives
+			steps = append(steps, trace.Steps...)
+			continue
+		}
+
+		if err := h.resolveAgent(r.Context(), repo, trace, agentIDs); err != nil {
+			if !isRejectedRun(err) {
+				http.Error(w, "Failed to resolve agent: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rejected += int64(len(trace.Steps) + 1)
+			messages = append(messages, fmt.Sprintf("trace %s: %s", trace.TraceID, err))
+			continue
+		}
+
+		if err := repo.CreateAgentRun(r.Context(), trace.Run); err != nil {
+			if !isRejectedRun(err) {
+				http.Error(w, "Failed to create agent run: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			rejected += int64(len(trace.Steps) + 1)
+			messages = append(messages, fmt.Sprintf("trace %s: %s", trace.TraceID, err))
+			continue
+		}
+		steps = append(steps, trace.Steps...)
+	}
+
+	if err := repo.CreateRunSteps(r.Context(), steps); err != nil {
+		http.Error(w, "Failed to create run steps: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(ingest.OTLPExportResponse(rejected, strings.Join(messages, "; "), isJSON))
+}
+
+// resolveAgent sets the agent and version of the run of a trace, looking the agent up by name
+func (h *OTLPHandler) resolveAgent(ctx context.Context, repo db.AgentStore, trace *ingest.OTLPTrace, agentIDs map[string]primitive.ObjectID) error {
+	if trace.Agent == "" {
+		return errMissingAgentAttribute
+	}
+	if trace.Version == "" {
+		return errMissingVersionAttribute
+	}
+
+	agentID, ok := agentIDs[trace.Agent]
+	if !ok {
+		agent, err := repo.GetAgentByName(ctx, trace.Agent)
+		if err != nil {
+			return err
+		}
+		agentID = agent.ID
+		agentIDs[trace.Agent] = agentID
+	}
+
+	trace.Run.AgentID = agentID
+	trace.Run.Version = trace.Version
+	return nil
+}
+
+// isRejectedRun reports whether a run can never be stored, rather than failing because of the database
+func isRejectedRun(err error) bool {
+	if errors.Is(err, errMissingAgentAttribute) || errors.Is(err, errMissingVersionAttribute) {
+		return true
+	}
+
+	switch err.Error() {
+	case "agent not found", "version not found for this agent":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"ripple/auth"
+	"ripple/models"
+	"ripple/policy"
+)
+
+// requestPrincipal returns the caller of a request, writing runs with token when not nil
+func requestPrincipal(r *http.Request, token *models.IngestToken) policy.Principal {
+	return policy.Principal{Claims: auth.ClaimsFromContext(r.Context()), IngestToken: token}
+}
+
+// authorize asks a policy whether the caller of a request may perform an action on a resource, responding with 403
+// Forbidden when it may not
+func authorize(w http.ResponseWriter, p policy.Policy, principal policy.Principal, action policy.Action, resource policy.Resource) bool {
+	if err := p.Can(principal, action, resource); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// can asks the policy of the agent handler whether the caller of a request may perform an action on a resource,
+// responding with 403 Forbidden when it may not
+func (h *AgentHandler) can(w http.ResponseWriter, r *http.Request, action policy.Action, resource policy.Resource) bool {
+	return authorize(w, h.policy, requestPrincipal(r, nil), action, resource)
+}
+
+// can asks the policy of the UI handler whether the caller of a request may read the statistics of the project of
+// its project query parameter, or of every agent without one, responding with 403 Forbidden when it may not
+func (h *UIHandler) can(w http.ResponseWriter, r *http.Request) bool {
+	return authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{Project: r.URL.Query().Get("project")})
+}
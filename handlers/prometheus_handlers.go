@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"ripple/db"
+	"ripple/ingest"
+
+	"github.com/gorilla/mux"
+)
+
+// PrometheusHandler handles run counters forwarded with the Prometheus remote-write protocol
+type PrometheusHandler struct {
+	agentRepo  db.AgentStore
+	seriesRepo *db.SeriesRepository
+}
+
+// NewPrometheusHandler creates a new Prometheus remote-write handler
+func NewPrometheusHandler(agentRepo db.AgentStore, seriesRepo *db.SeriesRepository) *PrometheusHandler {
+	return &PrometheusHandler{
+		agentRepo:  agentRepo,
+		seriesRepo: seriesRepo,
+	}
+}
+
+// RegisterRoutes registers the Prometheus routes
+func (h *PrometheusHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/prometheus/write", h.RemoteWrite).Methods("POST")
+}
+
+// RemoteWrite handles POST /api/v1/prometheus/write
+func (h *PrometheusHandler) RemoteWrite(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Content-Type"), "io.prometheus.write.v2") {
+		http.Error(w, "Unsupported remote-write version, only prometheus.WriteRequest (1.0) is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	if encoding := r.Header.Get("Content-Encoding"); encoding != "" && encoding != "snappy" {
+		http.Error(w, "Unsupported content encoding, use snappy", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondBodyError(w, "Failed to read request body", err)
+		return
+	}
+
+	series, err := ingest.DecodeRemoteWrite(body, bodyLimit(r))
+	if err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	var keys []string
+	for _, s := range series {
+		if ingest.IsPromRunSeries(s) {
+			keys = append(keys, ingest.PromSeriesKey(s.Labels))
+		}
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	seriesRepo := seriesRepoFor(r.Context(), h.seriesRepo)
+	last, err := seriesRepo.GetSeriesValues(r.Context(), keys)
+	if err != nil {
+		http.Error(w, "Failed to retrieve series: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	agentRepo := agentRepoFor(r.Context(), h.agentRepo)
+	groups, values := ingest.PromRunsFromSeries(series, last)
+	for _, group := range groups {
+		agent, err := agentRepo.GetAgentByName(r.Context(), group.Agent)
+		if err != nil {
+			if err.Error() == "agent not found" {
+				// Rejecting the request would make Prometheus retry it forever, skip the runs instead
+				log.Printf("Skipping %d remote-write runs of unknown agent %q", len(group.Runs), group.Agent)
+				continue
+			}
+			http.Error(w, "Failed to resolve agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, run := range group.Runs {
+			run.AgentID = agent.ID
+		}
+		if err := agentRepo.CreateAgentRunBatch(r.Context(), group.Runs, db.BatchOptions{}); err != nil {
+			if err.Error() == "version not found for this agent" {
+				log.Printf("Skipping %d remote-write runs of unknown version %q of agent %q", len(group.Runs), group.Version, group.Agent)
+				continue
+			}
+			http.Error(w, "Failed to create agent runs batch: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := seriesRepo.SetSeriesValues(r.Context(), values); err != nil {
+		http.Error(w, "Failed to store series: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
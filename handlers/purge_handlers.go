@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPurgeJobsLimit = 50
+	maxPurgeJobsLimit     = 500
+)
+
+// PurgeHandler handles HTTP requests for purge jobs
+type PurgeHandler struct {
+	repo   *db.PurgeRepository
+	agents db.AgentStore
+}
+
+// NewPurgeHandler creates a new purge handler
+func NewPurgeHandler(repo *db.PurgeRepository, agents db.AgentStore) *PurgeHandler {
+	return &PurgeHandler{
+		repo:   repo,
+		agents: agents,
+	}
+}
+
+// PurgeJobResponse is a purge job with its audit records
+type PurgeJobResponse struct {
+	*models.PurgeJob
+	Audit []models.AuditRecord `json:"audit"`
+}
+
+// RegisterRoutes registers the purge routes
+func (h *PurgeHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/agents/{agentId}/runs", h.PurgeRuns).Methods("DELETE")
+	router.HandleFunc("/api/v1/purges", h.ListPurges).Methods("GET")
+	router.HandleFunc("/api/v1/purges/{purgeId}", h.GetPurge).Methods("GET")
+}
+
+// PurgeRuns handles DELETE /api/v1/agents/{agentId}/runs
+func (h *PurgeHandler) PurgeRuns(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parsePurgeFilter(query)
+	if err != nil {
+		http.Error(w, "Invalid purge: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.IsEmpty() && query.Get("all") != "true" {
+		http.Error(w, "Invalid purge: set a filter, or all=true to delete every run of the agent", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := agentRepoFor(r.Context(), h.agents).GetAgentByID(r.Context(), agentID); err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	job := &models.PurgeJob{
+		AgentID: agentID,
+		Filter:  filter,
+		Reason:  query.Get("reason"),
+	}
+	record := &models.AuditRecord{
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	}
+	if err := purgeRepoFor(r.Context(), h.repo).CreatePurgeJob(r.Context(), job, record); err != nil {
+		http.Error(w, "Failed to create purge job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, job)
+}
+
+// ListPurges handles GET /api/v1/purges
+func (h *PurgeHandler) ListPurges(w http.ResponseWriter, r *http.Request) {
+	limit := int64(defaultPurgeJobsLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxPurgeJobsLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxPurgeJobsLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := purgeRepoFor(r.Context(), h.repo).ListPurgeJobs(r.Context(), limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve purge jobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, jobs)
+}
+
+// GetPurge handles GET /api/v1/purges/{purgeId}
+func (h *PurgeHandler) GetPurge(w http.ResponseWriter, r *http.Request) {
+	jobID, err := primitive.ObjectIDFromHex(mux.Vars(r)["purgeId"])
+	if err != nil {
+		http.Error(w, "Invalid purge ID format", http.StatusBadRequest)
+		return
+	}
+
+	repo := purgeRepoFor(r.Context(), h.repo)
+	job, err := repo.GetPurgeJob(r.Context(), jobID)
+	if err != nil {
+		if err.Error() == "purge job not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve purge job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit, err := repo.ListAuditRecords(r.Context(), jobID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve purge audit records: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, PurgeJobResponse{PurgeJob: job, Audit: audit})
+}
+
+// purgeParameters are the query parameters of a purge. Others are rejected rather than ignored, since a filter that
+// is ignored deletes more runs than asked for.
+var purgeParameters = map[string]bool{
+	"from": true, "to": true, "version": true, "status": true, "initiator": true, "task_id": true, "trace_id": true,
+	"all": true, "reason": true, "project": true,
+}
+
+// parsePurgeFilter parses the run filter of a purge from query parameters. Runs have no metadata, so there is no
+// metadata filter.
+func parsePurgeFilter(query url.Values) (models.PurgeFilter, error) {
+	for name := range query {
+		if !purgeParameters[name] {
+			return models.PurgeFilter{}, fmt.Errorf("unknown filter %q, runs can be purged by from, to, version, status, initiator, task_id and trace_id", name)
+		}
+	}
+
+	filter := models.PurgeFilter{
+		Version:   query.Get("version"),
+		Status:    query.Get("status"),
+		Initiator: query.Get("initiator"),
+		TraceID:   query.Get("trace_id"),
+	}
+
+	for _, bound := range []struct {
+		name  string
+		field **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := query.Get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s, expected an RFC3339 timestamp", bound.name)
+			}
+			t = t.UTC()
+			*bound.field = &t
+		}
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+
+	if v := query.Get("task_id"); v != "" {
+		taskID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid task_id %q", v)
+		}
+		filter.TaskID = &taskID
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/db"
+)
+
+var (
+	// agentSortFields are the agent fields allowed in the sort query parameter
+	agentSortFields = []string{"name", "project", "team", "created_at", "updated_at"}
+	// versionSortFields are the agent version fields allowed in the sort query parameter
+	versionSortFields = []string{"version", "cluster", "status", "deployment", "created_at", "updated_at"}
+	// runSortFields are the agent run fields allowed in the sort query parameter
+	runSortFields = []string{"created", "recorded_at", "status", "time_taken", "cost", "initiator", "run_id", "task_id"}
+	// versionMetricsSortFields are the agent version metrics fields allowed in the sort query parameter
+	versionMetricsSortFields = []string{
+		"name", "project", "team", "version", "status", "cluster", "lastSeen", "avgRuntime", "successRate", "totalRuns",
+		"spend", "spendToday", "spend7d", "spend30d", "spendTotal", "health",
+	}
+)
+
+// parseListOptions parses the common list query parameters for the given model
+func parseListOptions(r *http.Request, model interface{}, sortable []string) (db.ListOptions, error) {
+	fields, err := parseFields(r, model)
+	if err != nil {
+		return db.ListOptions{}, err
+	}
+
+	sort, err := parseSort(r, sortable)
+	if err != nil {
+		return db.ListOptions{}, err
+	}
+
+	return db.ListOptions{
+		Fields: fields,
+		Sort:   sort,
+	}, nil
+}
+
+// parseIncludeArchived parses the include_archived query parameter, false by default
+func parseIncludeArchived(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("include_archived")
+	if v == "" {
+		return false, nil
+	}
+
+	includeArchived, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid include_archived %q, expected true or false", v)
+	}
+	return includeArchived, nil
+}
+
+// parseBatchOptions parses the ordered query parameter of a batch of runs, true by default
+func parseBatchOptions(r *http.Request) (db.BatchOptions, error) {
+	v := r.URL.Query().Get("ordered")
+	if v == "" {
+		return db.BatchOptions{}, nil
+	}
+
+	ordered, err := strconv.ParseBool(v)
+	if err != nil {
+		return db.BatchOptions{}, fmt.Errorf("invalid ordered %q, expected true or false", v)
+	}
+	return db.BatchOptions{Unordered: !ordered}, nil
+}
+
+const (
+	defaultUsageStatsWindow  = 7 * 24 * time.Hour
+	maxUsageStatsWindow      = 90 * 24 * time.Hour
+	defaultHeatmapWindow     = 28 * 24 * time.Hour
+	defaultLeaderboardWindow = 24 * time.Hour
+	defaultTokenUsageWindow  = 30 * 24 * time.Hour
+)
+
+const (
+	defaultLeaderboardLimit     = 10
+	maxLeaderboardLimit         = 100
+	defaultErrorCategoriesLimit = 5
+	maxErrorCategoriesLimit     = 50
+	defaultActivityLimit        = 10
+	maxActivityLimit            = 200
+	maxAgentVersionsLimit       = 1000
+)
+
+// parseWindow parses the window query parameter, a duration such as 90m or 24h, or a number of days such as 7d
+func parseWindow(r *http.Request, defaultWindow, maxWindow time.Duration) (time.Duration, error) {
+	return parseDurationParam(r, "window", defaultWindow, maxWindow)
+}
+
+// parseDurationParam parses a duration query parameter in the format of the window parameter
+func parseDurationParam(r *http.Request, name string, defaultWindow, maxWindow time.Duration) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return defaultWindow, nil
+	}
+	return parseDuration(name, v, maxWindow)
+}
+
+// parseDuration parses a duration such as 90m or 24h, or a number of days such as 7d, of at most maxWindow
+func parseDuration(name, v string, maxWindow time.Duration) (time.Duration, error) {
+	var window time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		window, err = time.ParseDuration(v)
+	}
+	if err != nil || window <= 0 || window > maxWindow {
+		return 0, fmt.Errorf("invalid %s %q, expected a duration such as 24h or 7d, at most %s", name, v, formatWindow(maxWindow))
+	}
+	return window, nil
+}
+
+// parseTimeRange parses a time range given either as a range query parameter, the duration up to now, or as from
+// and to RFC 3339 timestamps, to defaulting to now. Zero times are returned when neither is set.
+func parseTimeRange(r *http.Request, maxRange time.Duration) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	now := time.Now().UTC()
+
+	if query.Get("range") != "" {
+		if query.Get("from") != "" || query.Get("to") != "" {
+			return from, to, errors.New("range can not be combined with from and to")
+		}
+		d, err := parseDurationParam(r, "range", 0, maxRange)
+		if err != nil {
+			return from, to, err
+		}
+		return now.Add(-d), now, nil
+	}
+
+	fromStr, toStr := query.Get("from"), query.Get("to")
+	if fromStr == "" {
+		if toStr != "" {
+			return from, to, errors.New("to requires from")
+		}
+		return from, to, nil
+	}
+	if from, err = time.Parse(time.RFC3339, fromStr); err != nil {
+		return from, to, fmt.Errorf("invalid from %q, expected an RFC3339 timestamp", fromStr)
+	}
+	to = now
+	if toStr != "" {
+		if to, err = time.Parse(time.RFC3339, toStr); err != nil {
+			return from, to, fmt.Errorf("invalid to %q, expected an RFC3339 timestamp", toStr)
+		}
+	}
+	from, to = from.UTC(), to.UTC()
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	if to.Sub(from) > maxRange {
+		return from, to, fmt.Errorf("the range from %s to %s is longer than %s", fromStr, to.Format(time.RFC3339), formatWindow(maxRange))
+	}
+	return from, to, nil
+}
+
+// formatWindow formats a window as a number of days when it is a whole number of days
+func formatWindow(window time.Duration) string {
+	if window%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(window/(24*time.Hour))) + "d"
+	}
+	return window.String()
+}
+
+// parseSort parses the sort query parameter (e.g. sort=created:desc,cost:asc) against an allowlist of fields
+func parseSort(r *http.Request, sortable []string) ([]db.SortField, error) {
+	sortStr := r.URL.Query().Get("sort")
+	if sortStr == "" {
+		return nil, nil
+	}
+	if len(sortable) == 0 {
+		return nil, fmt.Errorf("sorting is not supported on this endpoint")
+	}
+
+	var sort []db.SortField
+	for _, s := range strings.Split(sortStr, ",") {
+		field, direction, _ := strings.Cut(strings.TrimSpace(s), ":")
+		if field == "" {
+			continue
+		}
+		if !contains(sortable, field) {
+			return nil, fmt.Errorf("field %q is not sortable, expected one of %s", field, strings.Join(sortable, ", "))
+		}
+
+		switch strings.ToLower(direction) {
+		case "", "asc":
+			sort = append(sort, db.SortField{Field: field})
+		case "desc":
+			sort = append(sort, db.SortField{Field: field, Descending: true})
+		default:
+			return nil, fmt.Errorf("invalid sort direction %q for field %q, expected asc or desc", direction, field)
+		}
+	}
+
+	return sort, nil
+}
+
+// parseFields parses the fields query parameter and validates it against the fields of the model
+func parseFields(r *http.Request, model interface{}) ([]string, error) {
+	fieldsStr := r.URL.Query().Get("fields")
+	if fieldsStr == "" {
+		return nil, nil
+	}
+
+	names := db.FieldNames(model)
+	var fields []string
+	for _, f := range strings.Split(fieldsStr, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, ok := names[f]; !ok {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// respondJSONFields responds with a JSON list keeping only the requested fields of each item
+func respondJSONFields(w http.ResponseWriter, status int, data interface{}, fields []string) {
+	selected, err := selectFields(data, fields)
+	if err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, status, selected)
+}
+
+// selectFields keeps only the requested fields of each item of a list
+func selectFields(data interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		keep[f] = true
+	}
+	for _, item := range items {
+		for k := range item {
+			if !keep[k] {
+				delete(item, k)
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// contains reports whether the list contains the given value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
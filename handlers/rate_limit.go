@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/auth"
+	"ripple/ratelimit"
+
+	"github.com/gorilla/mux"
+)
+
+// Route classes requests are rate limited by
+const (
+	RouteClassIngest = "ingest"
+	RouteClassRead   = "read"
+	RouteClassWrite  = "write"
+)
+
+// RouteClasses lists the route classes
+var RouteClasses = []string{RouteClassIngest, RouteClassRead, RouteClassWrite}
+
+// ClientIPLimit names the limit of the requests of each client IP address, checked before their credentials, so that
+// requests with invalid credentials are limited too
+const ClientIPLimit = "ip"
+
+// routeClasses are the route classes of the roles routes require
+var routeClasses = map[string]string{
+	auth.RoleIngest:      RouteClassIngest,
+	auth.RoleViewer:      RouteClassRead,
+	auth.RoleEditor:      RouteClassWrite,
+	auth.RoleAdmin:       RouteClassWrite,
+	auth.RoleVersions:    RouteClassWrite,
+	auth.RoleDeployments: RouteClassWrite,
+}
+
+// RateLimiter limits the requests of each client per route class, so that a runaway client can not overload
+// MongoDB. Authenticated clients are told apart by their credentials, such as their API key, other clients by their
+// IP address. Limits are kept in memory, each server enforces them on its own.
+type RateLimiter struct {
+	limiters map[string]*ratelimit.Limiter
+	// ipLimiter limits the requests of each client IP address before their credentials are checked, nil when unset
+	ipLimiter *ratelimit.Limiter
+	ipSource  ClientIPSource
+}
+
+// NewRateLimiter creates a rate limiter from limits in the form "ingest=100/s:200,read=20/s,write=5/m,ip=50/s", see
+// ratelimit.ParseLimit. Route classes without a limit are not limited, nor are client IP addresses without the ip
+// limit. The client IP address is taken from ipSource.
+func NewRateLimiter(limits string, ipSource ClientIPSource) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		limiters: map[string]*ratelimit.Limiter{},
+		ipSource: ipSource,
+	}
+	if limits == "" {
+		return rl, nil
+	}
+
+	for _, entry := range strings.Split(limits, ",") {
+		class, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || class == "" || value == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, expected class=limit", entry)
+		}
+		if class != ClientIPLimit && !contains(RouteClasses, class) {
+			return nil, fmt.Errorf("invalid route class %q, expected one of %s or %s", class, strings.Join(RouteClasses, ", "), ClientIPLimit)
+		}
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit for %s: %w", class, err)
+		}
+		if class == ClientIPLimit {
+			rl.ipLimiter = ratelimit.New(limit, ratelimit.DefaultMaxKeys)
+			continue
+		}
+		rl.limiters[class] = ratelimit.New(limit, ratelimit.DefaultMaxKeys)
+	}
+
+	return rl, nil
+}
+
+// Enabled reports whether any route class is limited
+func (rl *RateLimiter) Enabled() bool {
+	return len(rl.limiters) > 0
+}
+
+// IPEnabled reports whether the requests of each client IP address are limited
+func (rl *RateLimiter) IPEnabled() bool {
+	return rl.ipLimiter != nil
+}
+
+// IPMiddleware responds with 429 Too Many Requests and a Retry-After header to client IP addresses over the ip limit.
+// It runs before the authentication middleware, so that clients sending invalid credentials, which are not limited
+// by their credentials, can not make it look up API keys and ingest tokens at will.
+func (rl *RateLimiter) IPMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := rl.ipLimiter.Allow(rl.ipSource.clientIP(r), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many requests, retry later", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware responds with 429 Too Many Requests and a Retry-After header to clients over the limit of the route
+// class of a request. It runs after the authentication middleware, to limit clients by their credentials.
+func (rl *RateLimiter) Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			template, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			class := routeClasses[requiredRole(r.Method, template)]
+			limiter := rl.limiters[class]
+			if limiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if ok, wait := limiter.Allow(rl.clientKey(r), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "Too many "+class+" requests, retry later", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey returns the key a request is limited by: the subject of its credentials, the agent of its client
+// certificate, the hash of its ingest token, or its client IP address
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if agent := clientCertAgent(r.Context()); agent != "" {
+		return "agent:" + agent
+	}
+	if hash := ingestTokenHash(r.Context()); hash != "" {
+		return "ingest_token:" + hash
+	}
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil && claims.Subject != "" {
+		// Subjects are only unique within an organization
+		return "sub:" + claims.OrgID + ":" + claims.Subject
+	}
+	return "ip:" + rl.ipSource.clientIP(r)
+}
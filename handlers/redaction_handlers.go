@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+	"ripple/policy"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RedactionHandler handles HTTP requests managing the rules that redact personal data from the runs and steps of
+// the agents of a project before they are stored
+type RedactionHandler struct {
+	repo   *db.RedactionRepository
+	policy policy.Policy
+}
+
+// NewRedactionHandler creates a new redaction rule handler
+func NewRedactionHandler(repo *db.RedactionRepository, p policy.Policy) *RedactionHandler {
+	return &RedactionHandler{
+		repo:   repo,
+		policy: p,
+	}
+}
+
+// RegisterRoutes registers the redaction rule routes
+func (h *RedactionHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/redaction_rules", h.CreateRedactionRule).Methods("POST")
+	router.HandleFunc("/api/v1/redaction_rules", h.ListRedactionRules).Methods("GET")
+	router.HandleFunc("/api/v1/redaction_rules/{ruleId}", h.GetRedactionRule).Methods("GET")
+	router.HandleFunc("/api/v1/redaction_rules/{ruleId}", h.UpdateRedactionRule).Methods("PUT")
+	router.HandleFunc("/api/v1/redaction_rules/{ruleId}", h.DeleteRedactionRule).Methods("DELETE")
+}
+
+// CreateRedactionRule handles POST /api/v1/redaction_rules
+//
+// The rule applies to the runs and steps stored after it is created, runs already stored are not redacted.
+func (h *RedactionHandler) CreateRedactionRule(w http.ResponseWriter, r *http.Request) {
+	var req models.RedactionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	rule := &models.RedactionRule{}
+	if !h.applyRequest(w, r, rule, req) {
+		return
+	}
+	if err := redactionRepoFor(r.Context(), h.repo).CreateRedactionRule(r.Context(), rule); err != nil {
+		http.Error(w, "Failed to create redaction rule: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+// ListRedactionRules handles GET /api/v1/redaction_rules
+//
+// The rules of the project of the project query parameter are listed, or of every project without one.
+func (h *RedactionHandler) ListRedactionRules(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{Project: project}) {
+		return
+	}
+
+	rules, err := redactionRepoFor(r.Context(), h.repo).ListRedactionRules(r.Context(), project)
+	if err != nil {
+		http.Error(w, "Failed to retrieve redaction rules: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// GetRedactionRule handles GET /api/v1/redaction_rules/{ruleId}
+func (h *RedactionHandler) GetRedactionRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.requestRule(w, r, policy.Read)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// UpdateRedactionRule handles PUT /api/v1/redaction_rules/{ruleId}
+func (h *RedactionHandler) UpdateRedactionRule(w http.ResponseWriter, r *http.Request) {
+	var req models.RedactionRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	rule, ok := h.requestRule(w, r, policy.Write)
+	if !ok {
+		return
+	}
+	if !h.applyRequest(w, r, rule, req) {
+		return
+	}
+	if err := redactionRepoFor(r.Context(), h.repo).UpdateRedactionRule(r.Context(), rule); err != nil {
+		respondRedactionError(w, "Failed to update redaction rule", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// DeleteRedactionRule handles DELETE /api/v1/redaction_rules/{ruleId}
+func (h *RedactionHandler) DeleteRedactionRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.requestRule(w, r, policy.Delete)
+	if !ok {
+		return
+	}
+	if err := redactionRepoFor(r.Context(), h.repo).DeleteRedactionRule(r.Context(), rule.ID); err != nil {
+		respondRedactionError(w, "Failed to delete redaction rule", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestRule returns the redaction rule of the request's path when the caller may perform an action on its project,
+// responding with an error otherwise
+func (h *RedactionHandler) requestRule(w http.ResponseWriter, r *http.Request, action policy.Action) (*models.RedactionRule, bool) {
+	ruleID, err := primitive.ObjectIDFromHex(mux.Vars(r)["ruleId"])
+	if err != nil {
+		http.Error(w, "Invalid redaction rule ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	rule, err := redactionRepoFor(r.Context(), h.repo).GetRedactionRule(r.Context(), ruleID)
+	if err != nil {
+		respondRedactionError(w, "Failed to retrieve redaction rule", err)
+		return nil, false
+	}
+	if !authorize(w, h.policy, requestPrincipal(r, nil), action, policy.Resource{Project: rule.Project}) {
+		return nil, false
+	}
+	return rule, true
+}
+
+// applyRequest sets the fields of a rule from a request, responding with an error when the caller may not write
+// rules of its project or the rule is invalid. Rules of project scoped callers apply to their project.
+func (h *RedactionHandler) applyRequest(w http.ResponseWriter, r *http.Request, rule *models.RedactionRule, req models.RedactionRuleRequest) bool {
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Write, policy.Resource{Project: req.Project}) {
+		return false
+	}
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil && claims.Project != "" {
+		req.Project = claims.Project
+	}
+
+	rule.Project = req.Project
+	rule.Name = req.Name
+	rule.Field = req.Field
+	rule.Pattern = req.Pattern
+	rule.Action = req.Action
+	if err := rule.Validate(); err != nil {
+		http.Error(w, "Invalid redaction rule: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// respondRedactionError responds with 404 for unknown redaction rules, and 500 otherwise
+func respondRedactionError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "redaction rule not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
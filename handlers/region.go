@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+)
+
+// RegionMiddleware routes requests to the cluster of the region their data is pinned to: the region of the
+// organization of the caller, or of the project of its project claim or project query parameter. Requests for
+// organizations and projects pinned to different regions are rejected, since a query does not span clusters.
+// Requests without a pinned region stay in the home region. It runs after the org and project middlewares.
+func RegionMiddleware(base *db.MongoDB) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			database := db.DatabaseFromContext(r.Context())
+			if database == nil {
+				database = base
+			}
+			regions := database.Regions()
+
+			var region, pinnedBy string
+			if org, ok := r.Context().Value(organizationKey{}).(*models.Organization); ok && org.Region != "" {
+				region, pinnedBy = org.Region, "organization "+org.Name
+			}
+			for _, project := range requestProjects(r) {
+				pinned := regions.ProjectRegion(project)
+				if pinned == "" {
+					continue
+				}
+				if region != "" && pinned != region {
+					http.Error(w, fmt.Sprintf("Cross-region request: project %s is stored in region %s, but %s is stored in region %s", project, pinned, pinnedBy, region), http.StatusBadRequest)
+					return
+				}
+				region, pinnedBy = pinned, "project "+project
+			}
+
+			regional, err := database.ForRegion(region)
+			if err != nil {
+				http.Error(w, "Failed to select region: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(db.WithDatabase(r.Context(), regional)))
+		})
+	}
+}
+
+// requestProjects returns the projects a request is restricted to by its project claim and project query parameter
+func requestProjects(r *http.Request) []string {
+	var projects []string
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil && claims.Project != "" {
+		projects = append(projects, claims.Project)
+	}
+	if project := r.URL.Query().Get("project"); project != "" {
+		projects = append(projects, project)
+	}
+	return projects
+}
+
+// inRegion checks that the agents of a project are written in the region of the request's database, responding
+// with 400 Bad Request when the project is pinned to another region
+func inRegion(w http.ResponseWriter, r *http.Request, project string) bool {
+	database := db.DatabaseFromContext(r.Context())
+	if database == nil {
+		return true
+	}
+	pinned := database.Regions().ProjectRegion(project)
+	if pinned == "" || pinned == database.Region() {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("Cross-region write: project %s is stored in region %s, but the request is routed to region %s, set the project query parameter to %s", project, pinned, database.Region(), project), http.StatusBadRequest)
+	return false
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+	"ripple/reports"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxReportRecipients caps the number of recipients of a report
+const maxReportRecipients = 50
+
+// ReportHandler handles HTTP requests for scheduled reports
+type ReportHandler struct {
+	repo *db.ReportRepository
+}
+
+// NewReportHandler creates a new report handler
+func NewReportHandler(repo *db.ReportRepository) *ReportHandler {
+	return &ReportHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the report routes
+func (h *ReportHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/reports", h.CreateReport).Methods("POST")
+	router.HandleFunc("/api/v1/reports", h.ListReports).Methods("GET")
+	router.HandleFunc("/api/v1/reports/{reportId}", h.GetReport).Methods("GET")
+	router.HandleFunc("/api/v1/reports/{reportId}", h.UpdateReport).Methods("PUT")
+	router.HandleFunc("/api/v1/reports/{reportId}", h.DeleteReport).Methods("DELETE")
+	router.HandleFunc("/api/v1/reports/{reportId}/preview", h.PreviewReport).Methods("GET")
+}
+
+// CreateReport handles POST /api/v1/reports
+func (h *ReportHandler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	var req models.ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	req = normalizeReportRequest(req)
+	if err := validateReportRequest(req); err != nil {
+		http.Error(w, "Invalid report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := &models.Report{}
+	applyReportRequest(report, req)
+	if req.StartAt == nil {
+		report.NextRunAt = report.FirstRun(time.Now())
+	}
+
+	if err := reportRepoFor(r.Context(), h.repo).CreateReport(r.Context(), report); err != nil {
+		http.Error(w, "Failed to create report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, report)
+}
+
+// ListReports handles GET /api/v1/reports
+func (h *ReportHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+	list, err := reportRepoFor(r.Context(), h.repo).ListReports(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve reports: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, list)
+}
+
+// GetReport handles GET /api/v1/reports/{reportId}
+func (h *ReportHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["reportId"])
+	if err != nil {
+		http.Error(w, "Invalid report ID format", http.StatusBadRequest)
+		return
+	}
+
+	report, err := reportRepoFor(r.Context(), h.repo).GetReport(r.Context(), reportID)
+	if err != nil {
+		respondReportError(w, "Failed to retrieve report", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
+
+// UpdateReport handles PUT /api/v1/reports/{reportId}
+func (h *ReportHandler) UpdateReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["reportId"])
+	if err != nil {
+		http.Error(w, "Invalid report ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	req = normalizeReportRequest(req)
+	if err := validateReportRequest(req); err != nil {
+		http.Error(w, "Invalid report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo := reportRepoFor(r.Context(), h.repo)
+	report, err := repo.GetReport(r.Context(), reportID)
+	if err != nil {
+		respondReportError(w, "Failed to retrieve report", err)
+		return
+	}
+
+	// The next run is kept unless a new start is given or the cadence changes
+	cadence := report.Cadence
+	applyReportRequest(report, req)
+	if req.StartAt == nil && report.Cadence != cadence {
+		report.NextRunAt = report.FirstRun(time.Now())
+	}
+
+	if err := repo.UpdateReport(r.Context(), report); err != nil {
+		respondReportError(w, "Failed to update report", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
+
+// DeleteReport handles DELETE /api/v1/reports/{reportId}
+func (h *ReportHandler) DeleteReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["reportId"])
+	if err != nil {
+		http.Error(w, "Invalid report ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := reportRepoFor(r.Context(), h.repo).DeleteReport(r.Context(), reportID); err != nil {
+		respondReportError(w, "Failed to delete report", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewReport handles GET /api/v1/reports/{reportId}/preview
+func (h *ReportHandler) PreviewReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["reportId"])
+	if err != nil {
+		http.Error(w, "Invalid report ID format", http.StatusBadRequest)
+		return
+	}
+
+	repo := reportRepoFor(r.Context(), h.repo)
+	report, err := repo.GetReport(r.Context(), reportID)
+	if err != nil {
+		respondReportError(w, "Failed to retrieve report", err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = report.Format
+	} else if !contains(models.ReportFormats, format) {
+		http.Error(w, fmt.Sprintf("Invalid format %q, expected html or csv", format), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	summary, err := repo.PreviewReport(r.Context(), report, report.Period(now), now)
+	if err != nil {
+		http.Error(w, "Failed to compute report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := reports.Render(summary, format)
+	if err != nil {
+		http.Error(w, "Failed to render report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", reports.ContentType(format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// normalizeReportRequest trims a report request and fills in the default metrics and format
+func normalizeReportRequest(req models.ReportRequest) models.ReportRequest {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Project = strings.TrimSpace(req.Project)
+	if len(req.Metrics) == 0 {
+		req.Metrics = models.ReportMetrics
+	}
+	if req.Format == "" {
+		req.Format = models.ReportFormatHTML
+	}
+	return req
+}
+
+// validateReportRequest checks the name, metrics, cadence, format and recipients of a report
+func validateReportRequest(req models.ReportRequest) error {
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	for _, metric := range req.Metrics {
+		if !contains(models.ReportMetrics, metric) {
+			return fmt.Errorf("unknown metric %q, expected %s", metric, strings.Join(models.ReportMetrics, ", "))
+		}
+	}
+	if !contains(models.ReportCadences, req.Cadence) {
+		return fmt.Errorf("unknown cadence %q, expected daily, weekly or monthly", req.Cadence)
+	}
+	if !contains(models.ReportFormats, req.Format) {
+		return fmt.Errorf("unknown format %q, expected html or csv", req.Format)
+	}
+
+	if len(req.Recipients) == 0 || len(req.Recipients) > maxReportRecipients {
+		return fmt.Errorf("between 1 and %d recipients are required", maxReportRecipients)
+	}
+	for _, recipient := range req.Recipients {
+		if addr, err := mail.ParseAddress(recipient); err != nil || addr.Address != recipient {
+			return fmt.Errorf("invalid recipient %q, expected an email address", recipient)
+		}
+	}
+
+	return nil
+}
+
+// applyReportRequest sets the definition of a report from a validated request
+func applyReportRequest(report *models.Report, req models.ReportRequest) {
+	report.Name = req.Name
+	report.Project = req.Project
+	report.Metrics = req.Metrics
+	report.Cadence = req.Cadence
+	report.Format = req.Format
+	report.Recipients = req.Recipients
+	if req.StartAt != nil {
+		report.NextRunAt = req.StartAt.UTC()
+	}
+}
+
+// respondReportError responds with 404 when the report does not exist, 500 otherwise
+func respondReportError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "report not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
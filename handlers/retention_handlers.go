@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+	"ripple/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// RetentionHandler handles HTTP requests managing how long the runs and steps of the agents of a project are kept,
+// which the worker enforces
+type RetentionHandler struct {
+	repo   *db.RetentionPolicyRepository
+	policy policy.Policy
+}
+
+// NewRetentionHandler creates a new retention policy handler
+func NewRetentionHandler(repo *db.RetentionPolicyRepository, p policy.Policy) *RetentionHandler {
+	return &RetentionHandler{
+		repo:   repo,
+		policy: p,
+	}
+}
+
+// RegisterRoutes registers the retention policy routes
+func (h *RetentionHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/retention_policies", h.ListRetentionPolicies).Methods("GET")
+	router.HandleFunc("/api/v1/retention_policies/{project}", h.GetRetentionPolicy).Methods("GET")
+	router.HandleFunc("/api/v1/retention_policies/{project}", h.PutRetentionPolicy).Methods("PUT")
+	router.HandleFunc("/api/v1/retention_policies/{project}", h.DeleteRetentionPolicy).Methods("DELETE")
+	router.HandleFunc("/api/v1/retention_policies/{project}/preview", h.PreviewRetentionPolicy).Methods("GET")
+}
+
+// ListRetentionPolicies handles GET /api/v1/retention_policies
+func (h *RetentionHandler) ListRetentionPolicies(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{}) {
+		return
+	}
+
+	policies, err := retentionRepoFor(r.Context(), h.repo).ListRetentionPolicies(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve retention policies: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, policies)
+}
+
+// GetRetentionPolicy handles GET /api/v1/retention_policies/{project}
+func (h *RetentionHandler) GetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	project := mux.Vars(r)["project"]
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{Project: project}) {
+		return
+	}
+
+	retention, err := retentionRepoFor(r.Context(), h.repo).GetRetentionPolicy(r.Context(), project)
+	if err != nil {
+		respondRetentionError(w, "Failed to retrieve retention policy", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, retention)
+}
+
+// PutRetentionPolicy handles PUT /api/v1/retention_policies/{project}
+//
+// The policy of the project is created, or replaced. The worker deletes the data past its retention the next time
+// it runs.
+func (h *RetentionHandler) PutRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	var req models.RetentionPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	project := mux.Vars(r)["project"]
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Write, policy.Resource{Project: project}) {
+		return
+	}
+	retention := &models.RetentionPolicy{Project: project, RunsDays: req.RunsDays, StepsDays: req.StepsDays}
+	if err := retention.Validate(); err != nil {
+		http.Error(w, "Invalid retention policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := retentionRepoFor(r.Context(), h.repo).PutRetentionPolicy(r.Context(), retention); err != nil {
+		http.Error(w, "Failed to store retention policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, retention)
+}
+
+// DeleteRetentionPolicy handles DELETE /api/v1/retention_policies/{project}
+func (h *RetentionHandler) DeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	project := mux.Vars(r)["project"]
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Delete, policy.Resource{Project: project}) {
+		return
+	}
+	if err := retentionRepoFor(r.Context(), h.repo).DeleteRetentionPolicy(r.Context(), project); err != nil {
+		respondRetentionError(w, "Failed to delete retention policy", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PreviewRetentionPolicy handles GET /api/v1/retention_policies/{project}/preview
+//
+// The runs and steps the policy of the project deletes now are counted. The runs_days and steps_days query
+// parameters preview other retentions before they are set, the project needing no policy then.
+func (h *RetentionHandler) PreviewRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	project := mux.Vars(r)["project"]
+	if !authorize(w, h.policy, requestPrincipal(r, nil), policy.Read, policy.Resource{Project: project}) {
+		return
+	}
+
+	repo := retentionRepoFor(r.Context(), h.repo)
+	query := r.URL.Query()
+	retention := &models.RetentionPolicy{Project: project}
+	if query.Get("runs_days") == "" && query.Get("steps_days") == "" {
+		stored, err := repo.GetRetentionPolicy(r.Context(), project)
+		if err != nil {
+			respondRetentionError(w, "Failed to retrieve retention policy", err)
+			return
+		}
+		retention = stored
+	} else {
+		for _, param := range []struct {
+			name  string
+			value *int
+		}{{"runs_days", &retention.RunsDays}, {"steps_days", &retention.StepsDays}} {
+			if s := query.Get(param.name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					http.Error(w, "Invalid "+param.name+" parameter", http.StatusBadRequest)
+					return
+				}
+				*param.value = n
+			}
+		}
+		if err := retention.Validate(); err != nil {
+			http.Error(w, "Invalid retention policy: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	preview, err := repo.PreviewRetention(r.Context(), retention, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to preview retention policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, preview)
+}
+
+// respondRetentionError responds with 404 for projects without a retention policy, and 500 otherwise
+func respondRetentionError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "retention policy not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
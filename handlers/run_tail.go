@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/policy"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	tailPollInterval      = time.Second
+	tailKeepAliveInterval = 15 * time.Second
+	tailBatchSize         = 500
+)
+
+// TailAgentRuns handles GET /api/v1/agents/{agentId}/runs:tail
+//
+// New runs are streamed as they are recorded, either as server-sent events (when the client
+// accepts text/event-stream or sets format=sse) or as newline delimited JSON.
+func (h *AgentHandler) TailAgentRuns(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentIDStr := vars["agentId"]
+
+	agentID, err := primitive.ObjectIDFromHex(agentIDStr)
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Read, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := db.RunFilter{
+		Version: query.Get("version"),
+		Status:  query.Get("status"),
+	}
+
+	since := time.Now()
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		since, err = time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			http.Error(w, "Invalid since, expected an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+	if _, err := repo.GetAgentByID(r.Context(), agentID); err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Streams outlive the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	sse := query.Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	lastSeen := since
+	lastID := primitive.NilObjectID
+	poll := time.NewTicker(tailPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(tailKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if sse {
+				fmt.Fprint(w, ": keepalive\n\n")
+			} else {
+				fmt.Fprint(w, "\n")
+			}
+			flusher.Flush()
+		case <-poll.C:
+			runs, err := repo.GetAgentRunsAfter(r.Context(), agentID, filter, lastSeen, lastID, tailBatchSize)
+			if err != nil {
+				if sse {
+					fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+					flusher.Flush()
+				}
+				continue
+			}
+
+			for i := range runs {
+				run := runs[i]
+				run.TraceLinks = h.traceLinks.Links(run.TraceID, run.SpanID)
+				data, err := json.Marshal(run)
+				if err != nil {
+					continue
+				}
+
+				if sse {
+					fmt.Fprintf(w, "id: %s\nevent: run\ndata: %s\n\n", run.ID.Hex(), data)
+				} else {
+					fmt.Fprintf(w, "%s\n", data)
+				}
+				lastSeen, lastID = run.RecordedAt, run.ID
+			}
+
+			if len(runs) > 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultSavedQueryLimit = 100
+	maxSavedQueryLimit     = 1000
+	maxSavedQueryRange     = 90 * 24 * time.Hour
+)
+
+// savedQueryNamePattern restricts saved query names to values that are safe to use in a URL path
+var savedQueryNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// SavedQueryHandler handles HTTP requests for saved run queries and their execution
+type SavedQueryHandler struct {
+	repo   *db.SavedQueryRepository
+	agents db.AgentStore
+}
+
+// NewSavedQueryHandler creates a new saved query handler
+func NewSavedQueryHandler(repo *db.SavedQueryRepository, agents db.AgentStore) *SavedQueryHandler {
+	return &SavedQueryHandler{
+		repo:   repo,
+		agents: agents,
+	}
+}
+
+// RegisterRoutes registers the saved query routes
+func (h *SavedQueryHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/saved_queries", h.CreateSavedQuery).Methods("POST")
+	router.HandleFunc("/api/v1/saved_queries", h.ListSavedQueries).Methods("GET")
+	router.HandleFunc("/api/v1/saved_queries/{name}", h.GetSavedQuery).Methods("GET")
+	router.HandleFunc("/api/v1/saved_queries/{name}", h.UpdateSavedQuery).Methods("PUT")
+	router.HandleFunc("/api/v1/saved_queries/{name}", h.DeleteSavedQuery).Methods("DELETE")
+	router.HandleFunc("/api/v1/saved_queries/{name}/runs", h.RunSavedQuery).Methods("GET")
+	router.HandleFunc("/api/v1/ui/saved_queries/{name}/activity", h.GetSavedQueryActivity).Methods("GET")
+}
+
+// CreateSavedQuery handles POST /api/v1/saved_queries
+func (h *SavedQueryHandler) CreateSavedQuery(w http.ResponseWriter, r *http.Request) {
+	var req models.SavedQueryRequest
+	if err := decodeSavedQueryRequest(r, &req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if !savedQueryNamePattern.MatchString(req.Name) {
+		http.Error(w, "Invalid saved query: name must be 1 to 64 lowercase letters, digits, - or _", http.StatusBadRequest)
+		return
+	}
+	if err := validateRunQuery(req.Query); err != nil {
+		http.Error(w, "Invalid saved query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := &models.SavedQuery{
+		Name:        req.Name,
+		Description: req.Description,
+		Query:       req.Query,
+	}
+	if err := savedQueryRepoFor(r.Context(), h.repo).CreateSavedQuery(r.Context(), query); err != nil {
+		if err.Error() == "saved query already exists" {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create saved query: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, query)
+}
+
+// ListSavedQueries handles GET /api/v1/saved_queries
+func (h *SavedQueryHandler) ListSavedQueries(w http.ResponseWriter, r *http.Request) {
+	queries, err := savedQueryRepoFor(r.Context(), h.repo).ListSavedQueries(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve saved queries: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, queries)
+}
+
+// GetSavedQuery handles GET /api/v1/saved_queries/{name}
+func (h *SavedQueryHandler) GetSavedQuery(w http.ResponseWriter, r *http.Request) {
+	query, err := savedQueryRepoFor(r.Context(), h.repo).GetSavedQuery(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		respondSavedQueryError(w, "Failed to retrieve saved query", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, query)
+}
+
+// UpdateSavedQuery handles PUT /api/v1/saved_queries/{name}
+func (h *SavedQueryHandler) UpdateSavedQuery(w http.ResponseWriter, r *http.Request) {
+	var req models.SavedQueryRequest
+	if err := decodeSavedQueryRequest(r, &req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if req.Name != "" && req.Name != name {
+		http.Error(w, "Invalid saved query: the name can not be changed", http.StatusBadRequest)
+		return
+	}
+	if err := validateRunQuery(req.Query); err != nil {
+		http.Error(w, "Invalid saved query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo := savedQueryRepoFor(r.Context(), h.repo)
+	query, err := repo.GetSavedQuery(r.Context(), name)
+	if err != nil {
+		respondSavedQueryError(w, "Failed to retrieve saved query", err)
+		return
+	}
+
+	query.Description = req.Description
+	query.Query = req.Query
+	if err := repo.UpdateSavedQuery(r.Context(), query); err != nil {
+		respondSavedQueryError(w, "Failed to update saved query", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, query)
+}
+
+// DeleteSavedQuery handles DELETE /api/v1/saved_queries/{name}
+func (h *SavedQueryHandler) DeleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	if err := savedQueryRepoFor(r.Context(), h.repo).DeleteSavedQuery(r.Context(), mux.Vars(r)["name"]); err != nil {
+		respondSavedQueryError(w, "Failed to delete saved query", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunSavedQuery handles GET /api/v1/saved_queries/{name}/runs
+func (h *SavedQueryHandler) RunSavedQuery(w http.ResponseWriter, r *http.Request) {
+	listOpts, err := parseListOptions(r, models.AgentRun{}, runSortFields)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if listOpts.Limit, ok = parseSavedQueryLimit(w, r); !ok {
+		return
+	}
+
+	runs, ok := h.runSavedQuery(w, r, listOpts)
+	if !ok {
+		return
+	}
+
+	respondJSONFields(w, http.StatusOK, runs, listOpts.Fields)
+}
+
+// GetSavedQueryActivity handles GET /api/v1/ui/saved_queries/{name}/activity
+func (h *SavedQueryHandler) GetSavedQueryActivity(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseSavedQueryLimit(w, r)
+	if !ok {
+		return
+	}
+
+	runs, ok := h.runSavedQuery(w, r, db.ListOptions{Limit: limit})
+	if !ok {
+		return
+	}
+
+	agents, err := agentRepoFor(r.Context(), h.agents).ListAgents(r.Context(), db.ListOptions{IncludeArchived: true})
+	if err != nil {
+		http.Error(w, "Failed to retrieve agents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	names := make(map[primitive.ObjectID]string, len(agents))
+	for _, agent := range agents {
+		names[agent.ID] = agent.Name
+	}
+
+	activities := make([]db.ActivityData, 0, len(runs))
+	for i := range runs {
+		activities = append(activities, db.NewActivityData(&runs[i], names[runs[i].AgentID]))
+	}
+
+	respondJSON(w, http.StatusOK, activities)
+}
+
+// runSavedQuery executes the saved query named in the request path, responding with an error when it fails
+func (h *SavedQueryHandler) runSavedQuery(w http.ResponseWriter, r *http.Request, listOpts db.ListOptions) ([]models.AgentRun, bool) {
+	query, err := savedQueryRepoFor(r.Context(), h.repo).GetSavedQuery(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		respondSavedQueryError(w, "Failed to retrieve saved query", err)
+		return nil, false
+	}
+
+	from, to, err := runQueryRange(query.Query, time.Now().UTC())
+	if err != nil {
+		http.Error(w, "Invalid saved query: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	runs, err := agentRepoFor(r.Context(), h.agents).QueryRuns(r.Context(), query.Query, from, to, listOpts)
+	if err != nil {
+		http.Error(w, "Failed to run saved query: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return runs, true
+}
+
+// parseSavedQueryLimit parses the limit query parameter of saved query results, responding with 400 when it is
+// invalid
+func parseSavedQueryLimit(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultSavedQueryLimit, true
+	}
+
+	limit, err := strconv.ParseInt(limitStr, 10, 64)
+	if err != nil || limit <= 0 || limit > maxSavedQueryLimit {
+		http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxSavedQueryLimit), http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// decodeSavedQueryRequest decodes the body of a request creating or updating a saved query. Unknown fields are
+// rejected rather than ignored, since a query whose filter is ignored matches more runs than asked for, as with the
+// metadata selectors of runs, which have no metadata.
+func decodeSavedQueryRequest(r *http.Request, req *models.SavedQueryRequest) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(req)
+}
+
+// validateRunQuery checks the statuses and the time range of a run query
+func validateRunQuery(query models.RunQuery) error {
+	for _, status := range query.Statuses {
+		if strings.TrimSpace(status) == "" {
+			return errors.New("statuses can not be empty")
+		}
+	}
+
+	_, _, err := runQueryRange(query, time.Now().UTC())
+	return err
+}
+
+// runQueryRange resolves the time range of a run query executed at now. Zero times leave the range open.
+func runQueryRange(query models.RunQuery, now time.Time) (from, to time.Time, err error) {
+	if query.Range != "" {
+		if query.From != nil || query.To != nil {
+			return from, to, errors.New("range can not be combined with from and to")
+		}
+		d, err := parseDuration("range", query.Range, maxSavedQueryRange)
+		if err != nil {
+			return from, to, err
+		}
+		return now.Add(-d), now, nil
+	}
+
+	if query.From != nil {
+		from = query.From.UTC()
+	}
+	if query.To != nil {
+		to = query.To.UTC()
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+// respondSavedQueryError responds with 404 when the saved query does not exist, 500 otherwise
+func respondSavedQueryError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "saved query not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
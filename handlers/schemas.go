@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"ripple/ingest"
+	"ripple/policy"
+)
+
+// ListRunSchemas handles GET /api/v1/schemas/runs
+func (h *AgentHandler) ListRunSchemas(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r, policy.Read, policy.Resource{}) {
+		return
+	}
+	respondJSON(w, http.StatusOK, h.schemas.List())
+}
+
+// warnDeprecatedSchemas adds deprecation headers to the response when deprecated schema versions were used
+func warnDeprecatedSchemas(w http.ResponseWriter, schemas *ingest.RunSchemaRegistry, versions map[int]bool) {
+	if len(versions) == 0 {
+		return
+	}
+
+	latest := schemas.Latest()
+	w.Header().Set("Deprecation", "true")
+	for version := range versions {
+		w.Header().Add("Warning", fmt.Sprintf(`299 ripple "run payload schema_version %d is deprecated, use schema_version %d"`, version, latest))
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ServiceAccountHandler handles HTTP requests for service accounts and their API keys
+type ServiceAccountHandler struct {
+	repo *db.ServiceAccountRepository
+}
+
+// NewServiceAccountHandler creates a new service account handler
+func NewServiceAccountHandler(repo *db.ServiceAccountRepository) *ServiceAccountHandler {
+	return &ServiceAccountHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the service account routes
+func (h *ServiceAccountHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/service_accounts", h.CreateServiceAccount).Methods("POST")
+	router.HandleFunc("/api/v1/service_accounts", h.ListServiceAccounts).Methods("GET")
+	router.HandleFunc("/api/v1/service_accounts/{id}", h.GetServiceAccount).Methods("GET")
+	router.HandleFunc("/api/v1/service_accounts/{id}", h.UpdateServiceAccount).Methods("PUT")
+	router.HandleFunc("/api/v1/service_accounts/{id}", h.DeleteServiceAccount).Methods("DELETE")
+	router.HandleFunc("/api/v1/service_accounts/{id}/disable", h.DisableServiceAccount).Methods("POST")
+	router.HandleFunc("/api/v1/service_accounts/{id}/enable", h.EnableServiceAccount).Methods("POST")
+	router.HandleFunc("/api/v1/service_accounts/{id}/keys", h.CreateServiceAccountKey).Methods("POST")
+	router.HandleFunc("/api/v1/service_accounts/{id}/keys", h.ListServiceAccountKeys).Methods("GET")
+	router.HandleFunc("/api/v1/service_accounts/{id}/keys/{keyId}", h.DeleteServiceAccountKey).Methods("DELETE")
+}
+
+// CreateServiceAccount handles POST /api/v1/service_accounts
+func (h *ServiceAccountHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "Invalid service account: name is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateServiceAccountScopes(req.Scopes); err != nil {
+		http.Error(w, "Invalid service account: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Project scoped callers only create service accounts of their project
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil && claims.Project != "" {
+		if req.Project != "" && req.Project != claims.Project {
+			http.Error(w, "Forbidden: the service account must be scoped to project "+claims.Project, http.StatusForbidden)
+			return
+		}
+		req.Project = claims.Project
+	}
+
+	account := &models.ServiceAccount{
+		Name:        req.Name,
+		Description: req.Description,
+		Scopes:      req.Scopes,
+		Project:     req.Project,
+	}
+	if err := serviceAccountRepoFor(r.Context(), h.repo).CreateServiceAccount(r.Context(), account); err != nil {
+		http.Error(w, "Failed to create service account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, account)
+}
+
+// ListServiceAccounts handles GET /api/v1/service_accounts
+func (h *ServiceAccountHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := serviceAccountRepoFor(r.Context(), h.repo).ListServiceAccounts(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve service accounts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, accounts)
+}
+
+// GetServiceAccount handles GET /api/v1/service_accounts/{id}
+func (h *ServiceAccountHandler) GetServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := serviceAccountRepoFor(r.Context(), h.repo).GetServiceAccount(r.Context(), id)
+	if err != nil {
+		respondServiceAccountError(w, "Failed to retrieve service account", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, account)
+}
+
+// UpdateServiceAccount handles PUT /api/v1/service_accounts/{id}
+//
+// Changing the scopes changes the scopes of the keys of the account immediately.
+func (h *ServiceAccountHandler) UpdateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if err := validateServiceAccountScopes(req.Scopes); err != nil {
+		http.Error(w, "Invalid service account: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.updateServiceAccount(w, r, id, bson.M{"description": req.Description, "scopes": req.Scopes})
+}
+
+// DeleteServiceAccount handles DELETE /api/v1/service_accounts/{id}
+//
+// The keys of the account are revoked immediately.
+func (h *ServiceAccountHandler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := serviceAccountRepoFor(r.Context(), h.repo).DeleteServiceAccount(r.Context(), id); err != nil {
+		respondServiceAccountError(w, "Failed to delete service account", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DisableServiceAccount handles POST /api/v1/service_accounts/{id}/disable
+//
+// The keys of the account are rejected until it is enabled again.
+func (h *ServiceAccountHandler) DisableServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	h.updateServiceAccount(w, r, id, bson.M{"disabled": true})
+}
+
+// EnableServiceAccount handles POST /api/v1/service_accounts/{id}/enable
+func (h *ServiceAccountHandler) EnableServiceAccount(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	h.updateServiceAccount(w, r, id, bson.M{"disabled": false})
+}
+
+// CreateServiceAccountKey handles POST /api/v1/service_accounts/{id}/keys
+//
+// The key is returned once, and has the scopes and project of the account.
+func (h *ServiceAccountHandler) CreateServiceAccountKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateServiceAccountKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondBodyError(w, "Invalid request body", err)
+			return
+		}
+	}
+
+	repo := serviceAccountRepoFor(r.Context(), h.repo)
+	account, err := repo.GetServiceAccount(r.Context(), id)
+	if err != nil {
+		respondServiceAccountError(w, "Failed to retrieve service account", err)
+		return
+	}
+	if req.Name == "" {
+		req.Name = account.Name
+	}
+
+	key, apiKey, err := models.NewAPIKey(req.Name, nil)
+	if err != nil {
+		http.Error(w, "Failed to generate API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := repo.CreateServiceAccountKey(r.Context(), account, apiKey); err != nil {
+		http.Error(w, "Failed to create API key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiKey.Key = key
+
+	respondCredentials(w, http.StatusCreated, apiKey)
+}
+
+// ListServiceAccountKeys handles GET /api/v1/service_accounts/{id}/keys
+func (h *ServiceAccountHandler) ListServiceAccountKeys(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	repo := serviceAccountRepoFor(r.Context(), h.repo)
+	if _, err := repo.GetServiceAccount(r.Context(), id); err != nil {
+		respondServiceAccountError(w, "Failed to retrieve service account", err)
+		return
+	}
+	keys, err := repo.ListServiceAccountKeys(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Failed to retrieve API keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// DeleteServiceAccountKey handles DELETE /api/v1/service_accounts/{id}/keys/{keyId}
+func (h *ServiceAccountHandler) DeleteServiceAccountKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+	keyID, err := primitive.ObjectIDFromHex(mux.Vars(r)["keyId"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := serviceAccountRepoFor(r.Context(), h.repo).DeleteServiceAccountKey(r.Context(), id, keyID); err != nil {
+		respondAPIKeyError(w, "Failed to delete API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateServiceAccount applies an update to a service account and responds with the updated account
+func (h *ServiceAccountHandler) updateServiceAccount(w http.ResponseWriter, r *http.Request, id primitive.ObjectID, update bson.M) {
+	account, err := serviceAccountRepoFor(r.Context(), h.repo).UpdateServiceAccount(r.Context(), id, update)
+	if err != nil {
+		respondServiceAccountError(w, "Failed to update service account", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, account)
+}
+
+// validateServiceAccountScopes checks that service account scopes are given and limited to models.ServiceAccountScopes
+func validateServiceAccountScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !contains(models.ServiceAccountScopes, scope) {
+			return fmt.Errorf("scope %s can not be granted to service accounts, expected one of %s", scope, strings.Join(models.ServiceAccountScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// serviceAccountID returns the service account ID of the id path variable, responding with an error when it is invalid
+func serviceAccountID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid service account ID format", http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+func respondServiceAccountError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "service account not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
+
+// serviceAccountRepoFor returns the service account repository of the request's tenant, or the default repository
+func serviceAccountRepoFor(ctx context.Context, fallback *db.ServiceAccountRepository) *db.ServiceAccountRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewServiceAccountRepository(database)
+	}
+	return fallback
+}
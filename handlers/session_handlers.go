@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// sessionRoutePrefix starts the routes of password sign in and of the session of the caller
+	sessionRoutePrefix = "/api/v1/auth/"
+	// userSubjectPrefix starts the subject of the sessions of users signed in with a password
+	userSubjectPrefix = "user:"
+	// totpIssuer names the server in authenticator apps
+	totpIssuer = "Ripple"
+)
+
+// SessionHandler signs users in with a password, and a TOTP code once they enabled TOTP, and manages their TOTP
+// enrollment. Users are looked up across organizations: their sessions carry the organization they belong to.
+type SessionHandler struct {
+	users    *db.UserRepository
+	sessions *auth.Sessions
+	// secure marks the session cookie as only sent over HTTPS
+	secure bool
+	// dummyHash is verified when signing in with an unknown email, so that the response time does not tell whether
+	// a user exists
+	dummyHash string
+}
+
+// NewSessionHandler creates a new session handler. Cookies are marked secure when secure is set.
+func NewSessionHandler(users *db.UserRepository, sessions *auth.Sessions, secure bool) (*SessionHandler, error) {
+	secret, err := randomHex()
+	if err != nil {
+		return nil, err
+	}
+	dummyHash, err := auth.HashPassword(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionHandler{
+		users:     users,
+		sessions:  sessions,
+		secure:    secure,
+		dummyHash: dummyHash,
+	}, nil
+}
+
+// RegisterRoutes registers the password sign in and session routes
+func (h *SessionHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/auth/login", h.Login).Methods("POST")
+	router.HandleFunc("/api/v1/auth/logout", h.Logout).Methods("POST")
+	router.HandleFunc("/api/v1/auth/me", h.Me).Methods("GET")
+	router.HandleFunc("/api/v1/auth/totp", h.EnrollTOTP).Methods("POST")
+	router.HandleFunc("/api/v1/auth/totp/confirm", h.ConfirmTOTP).Methods("POST")
+	router.HandleFunc("/api/v1/auth/totp/disable", h.DisableTOTP).Methods("POST")
+}
+
+// Login handles POST /api/v1/auth/login
+//
+// The session cookie is set, and the session is returned as by GET /api/v1/auth/me.
+func (h *SessionHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req models.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	user, err := h.users.GetUserByEmail(r.Context(), strings.TrimSpace(req.Email))
+	if err != nil && err.Error() != "user not found" {
+		http.Error(w, "Failed to retrieve user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash := h.dummyHash
+	if user != nil {
+		hash = user.PasswordHash
+	}
+	valid, err := auth.VerifyPassword(hash, req.Password)
+	if err != nil && user != nil {
+		log.Printf("Failed to verify the password of user %s: %v", user.ID.Hex(), err)
+	}
+	if user == nil || !valid {
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		return
+	}
+
+	if user.TOTPEnabled {
+		if req.TOTP == "" {
+			http.Error(w, "TOTP code required, set totp to the code of your authenticator app", http.StatusUnauthorized)
+			return
+		}
+		step, ok := auth.VerifyTOTP(user.TOTPSecret, req.TOTP, time.Now())
+		if !ok {
+			http.Error(w, "Invalid TOTP code", http.StatusUnauthorized)
+			return
+		}
+		if !h.useTOTPStep(r.Context(), w, user, step) {
+			return
+		}
+	}
+
+	claims := &auth.Claims{
+		Roles:            user.Roles,
+		Email:            user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: userSubjectPrefix + user.ID.Hex()},
+	}
+	if !user.OrgID.IsZero() {
+		claims.OrgID = user.OrgID.Hex()
+	}
+	session, err := h.sessions.Issue(claims)
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if claims, err = h.sessions.Verify(r.Context(), session); err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, session, h.sessions.TTL(), h.secure)
+
+	// The last sign in is recorded for audits, failing to record it does not fail the sign in
+	if err := h.users.UpdateUser(r.Context(), user.ID, bson.M{"last_login_at": time.Now()}); err != nil {
+		log.Printf("Failed to record the sign in of user %s: %v", user.ID.Hex(), err)
+	}
+
+	respondJSON(w, http.StatusOK, sessionInfo(claims))
+}
+
+// Logout handles POST /api/v1/auth/logout
+//
+// The session is signed out, and its cookie cleared.
+func (h *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	signOut(w, r, h.sessions, h.secure)
+}
+
+// Me handles GET /api/v1/auth/me
+//
+// The session of users signed in with a password also has their name and whether they enabled TOTP.
+func (h *SessionHandler) Me(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+
+	session := sessionInfo(claims)
+	if id, ok := userSubject(claims); ok {
+		user, err := h.users.GetUser(r.Context(), id)
+		if err != nil {
+			if err.Error() == "user not found" {
+				http.Error(w, "Your user no longer exists, sign in again", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "Failed to retrieve user: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		session["name"] = user.Name
+		session["totp_enabled"] = user.TOTPEnabled
+	}
+	respondJSON(w, http.StatusOK, session)
+}
+
+// EnrollTOTP handles POST /api/v1/auth/totp
+//
+// A new secret is generated for the signed in user, and is only required at sign in once a code of it is confirmed.
+func (h *SessionHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "TOTP is already enabled, disable it first", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate TOTP secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.users.UpdateUser(r.Context(), user.ID, bson.M{"totp_pending_secret": secret}); err != nil {
+		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondCredentials(w, http.StatusOK, &models.TOTPEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP handles POST /api/v1/auth/totp/confirm
+func (h *SessionHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	code, ok := totpCode(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		http.Error(w, "No TOTP enrollment, start one with POST /api/v1/auth/totp", http.StatusConflict)
+		return
+	}
+
+	step, valid := auth.VerifyTOTP(user.TOTPPendingSecret, code, time.Now())
+	if !valid {
+		http.Error(w, "Invalid TOTP code", http.StatusBadRequest)
+		return
+	}
+	if err := h.users.UpdateUser(r.Context(), user.ID, bson.M{
+		"totp_enabled":        true,
+		"totp_secret":         user.TOTPPendingSecret,
+		"totp_pending_secret": "",
+		"totp_last_step":      step,
+	}); err != nil {
+		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DisableTOTP handles POST /api/v1/auth/totp/disable
+//
+// A current code is required, so that a stolen session can not remove the second factor.
+func (h *SessionHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	code, ok := totpCode(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "TOTP is not enabled", http.StatusConflict)
+		return
+	}
+
+	step, valid := auth.VerifyTOTP(user.TOTPSecret, code, time.Now())
+	if !valid {
+		http.Error(w, "Invalid TOTP code", http.StatusBadRequest)
+		return
+	}
+	if !h.useTOTPStep(r.Context(), w, user, step) {
+		return
+	}
+	if err := h.users.UpdateUser(r.Context(), user.ID, bson.M{"totp_enabled": false, "totp_secret": ""}); err != nil {
+		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// useTOTPStep records the use of the code of a time step, responding with an error when it was already used
+func (h *SessionHandler) useTOTPStep(ctx context.Context, w http.ResponseWriter, user *models.User, step int64) bool {
+	used, err := h.users.UseTOTPStep(ctx, user.ID, step)
+	if err != nil {
+		http.Error(w, "Failed to update user: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !used {
+		http.Error(w, "TOTP code already used, wait for the next one", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// sessionUser returns the user signed in with a password of the request, responding with an error for other callers
+func (h *SessionHandler) sessionUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	claims := auth.ClaimsFromContext(r.Context())
+	id, ok := userSubject(claims)
+	if !ok {
+		http.Error(w, "Forbidden: only users signed in with a password have TOTP", http.StatusForbidden)
+		return nil, false
+	}
+
+	user, err := h.users.GetUser(r.Context(), id)
+	if err != nil {
+		if err.Error() == "user not found" {
+			http.Error(w, "Your user no longer exists, sign in again", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Failed to retrieve user: "+err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// userSubject returns the ID of the user of claims issued at password sign in
+func userSubject(claims *auth.Claims) (primitive.ObjectID, bool) {
+	if claims == nil {
+		return primitive.NilObjectID, false
+	}
+	hex, ok := strings.CutPrefix(claims.Subject, userSubjectPrefix)
+	if !ok {
+		return primitive.NilObjectID, false
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	return id, err == nil
+}
+
+// totpCode returns the code of a TOTP code request, responding with an error when it has none
+func totpCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return "", false
+	}
+	if req.Code == "" {
+		http.Error(w, "Missing code, set code to the code of your authenticator app", http.StatusBadRequest)
+		return "", false
+	}
+	return req.Code, true
+}
+
+// sessionInfo returns the subject, email, roles and expiry of a session
+func sessionInfo(claims *auth.Claims) map[string]interface{} {
+	session := map[string]interface{}{
+		"subject": claims.Subject,
+		"email":   claims.Email,
+		"roles":   claims.Roles,
+	}
+	if claims.ExpiresAt != nil {
+		session["expires_at"] = claims.ExpiresAt.Time
+	}
+	return session
+}
+
+// setSessionCookie sets the session cookie of a browser, HttpOnly so that scripts can not read it and SameSite=Lax so
+// that other sites can not send requests with it
+func setSessionCookie(w http.ResponseWriter, session string, ttl time.Duration, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// signOut signs the session of the cookie of a request out and clears the cookie. The response is an error when the
+// session could not be recorded as signed out, since it is still valid then.
+func signOut(w http.ResponseWriter, r *http.Request, sessions *auth.Sessions, secure bool) {
+	clearSessionCookie(w, secure)
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		if err := sessions.Revoke(r.Context(), cookie.Value); err != nil {
+			http.Error(w, "Failed to sign out: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearSessionCookie removes the session cookie of a browser
+func clearSessionCookie(w http.ResponseWriter, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+	"ripple/policy"
+	"ripple/webhooks"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// signingAgentHeader names the agent whose secret signs a batch, which has no agent in its path
+	signingAgentHeader = "X-Ripple-Agent-Id"
+	// signatureTolerance is how far the timestamp of a signed request may be from the time it is received
+	signatureTolerance = 5 * time.Minute
+)
+
+type signingAgentKey struct{}
+
+// SignedIngestMiddleware verifies the signatures of requests writing runs to the runs API. A signed request carries
+// its Unix timestamp in X-Ripple-Timestamp and "sha256=" followed by the hex encoded HMAC-SHA256 of
+// "<timestamp>.<body>" with the signing secret of its agent in X-Ripple-Signature, the scheme of webhook deliveries.
+// Batches name their agent in X-Ripple-Agent-Id. Requests with a stale timestamp or a signature already received are
+// rejected, so that captured requests can not be replayed, and signed requests only write runs of their agent.
+// Unsigned requests are rejected when required is set, and passed on otherwise.
+func SignedIngestMiddleware(repo db.AgentStore, required bool) mux.MiddlewareFunc {
+	seen := newSignatureCache()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var template string
+			if route := mux.CurrentRoute(r); route != nil {
+				template, _ = route.GetPathTemplate()
+			}
+			if r.Method != http.MethodPost || !contains(ingestTokenRoutes, template) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			signature, signed := strings.CutPrefix(r.Header.Get(webhooks.HeaderSignature), "sha256=")
+			if !signed {
+				if required {
+					http.Error(w, "Missing request signature, sign runs with the signing secret of the agent in the "+webhooks.HeaderTimestamp+" and "+webhooks.HeaderSignature+" headers", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timestamp := r.Header.Get(webhooks.HeaderTimestamp)
+			unix, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid "+webhooks.HeaderTimestamp+" header, expected a Unix timestamp in seconds", http.StatusUnauthorized)
+				return
+			}
+			now := time.Now()
+			if skew := now.Sub(time.Unix(unix, 0)); skew > signatureTolerance || skew < -signatureTolerance {
+				http.Error(w, "Request timestamp is more than "+signatureTolerance.String()+" away from the server time", http.StatusUnauthorized)
+				return
+			}
+
+			agentIDStr := mux.Vars(r)["agentId"]
+			if agentIDStr == "" {
+				agentIDStr = r.Header.Get(signingAgentHeader)
+			}
+			agentID, err := primitive.ObjectIDFromHex(agentIDStr)
+			if err != nil {
+				http.Error(w, "Invalid signing agent, signed batches name their agent in the "+signingAgentHeader+" header", http.StatusUnauthorized)
+				return
+			}
+			agent, err := agentRepoFor(r.Context(), repo).GetAgentByID(r.Context(), agentID)
+			if err != nil {
+				if err.Error() == "agent not found" {
+					http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+				} else {
+					http.Error(w, "Failed to retrieve agent: "+err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			if agent.SigningSecret == "" {
+				http.Error(w, "The agent has no signing secret, issue one with POST /api/v1/agents/{agentId}/signing_secret", http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				respondBodyError(w, "Failed to read request body", err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			expected := webhooks.Sign(agent.SigningSecret, timestamp, body)
+			if subtle.ConstantTimeCompare([]byte(signature), []byte(expected)) != 1 {
+				http.Error(w, "Invalid request signature", http.StatusUnauthorized)
+				return
+			}
+			if !seen.add(expected, now) {
+				http.Error(w, "Replayed request, the signature was already received", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signingAgentKey{}, agentID)))
+		})
+	}
+}
+
+// signingAgent returns the agent whose secret signed a request, the zero ID for unsigned requests
+func signingAgent(ctx context.Context) primitive.ObjectID {
+	agentID, _ := ctx.Value(signingAgentKey{}).(primitive.ObjectID)
+	return agentID
+}
+
+// signatureCache remembers the signatures received within the timestamp tolerance, to reject replayed requests
+type signatureCache struct {
+	mu         sync.Mutex
+	signatures map[string]time.Time
+	pruned     time.Time
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{signatures: map[string]time.Time{}}
+}
+
+// add records a signature received at now, reporting false when it was already received. Signatures older than
+// twice the tolerance, whose timestamps are rejected anyway, are forgotten.
+func (c *signatureCache) add(signature string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.pruned) > signatureTolerance {
+		for s, at := range c.signatures {
+			if now.Sub(at) > 2*signatureTolerance {
+				delete(c.signatures, s)
+			}
+		}
+		c.pruned = now
+	}
+
+	if _, ok := c.signatures[signature]; ok {
+		return false
+	}
+	c.signatures[signature] = now
+	return true
+}
+
+// RotateSigningSecret handles POST /api/v1/agents/{agentId}/signing_secret
+//
+// A new secret is issued and returned once, and the previous secret stops being accepted.
+func (h *AgentHandler) RotateSigningSecret(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.can(w, r, policy.Write, policy.Resource{AgentID: agentID}) {
+		return
+	}
+
+	secret, err := models.NewSigningSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate signing secret: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	agent, err := agentRepoFor(r.Context(), h.repo).SetAgentSigningSecret(r.Context(), agentID, secret)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to update agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	agent.IssuedSigningSecret = secret
+
+	respondCredentials(w, http.StatusOK, agent)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxSLOWindowDays is the longest rolling window of an SLO
+const maxSLOWindowDays = 90
+
+// SLOHandler handles HTTP requests for SLOs and their statuses
+type SLOHandler struct {
+	repo   *db.SLORepository
+	agents db.AgentStore
+}
+
+// NewSLOHandler creates a new SLO handler
+func NewSLOHandler(repo *db.SLORepository, agents db.AgentStore) *SLOHandler {
+	return &SLOHandler{
+		repo:   repo,
+		agents: agents,
+	}
+}
+
+// RegisterRoutes registers the SLO routes
+func (h *SLOHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/agents/{agentId}/slo", h.CreateSLO).Methods("POST")
+	router.HandleFunc("/api/v1/agents/{agentId}/slo", h.ListSLOs).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/slo/status", h.GetSLOStatus).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/slo/{sloId}", h.GetSLO).Methods("GET")
+	router.HandleFunc("/api/v1/agents/{agentId}/slo/{sloId}", h.DeleteSLO).Methods("DELETE")
+}
+
+// CreateSLO handles POST /api/v1/agents/{agentId}/slo
+func (h *SLOHandler) CreateSLO(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req models.SLORequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	req.Version = strings.TrimSpace(req.Version)
+	if req.WindowDays == 0 {
+		req.WindowDays = models.DefaultSLOWindowDays
+	}
+	if err := validateSLORequest(req); err != nil {
+		http.Error(w, "Invalid SLO: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := agentRepoFor(r.Context(), h.agents).GetAgentVersion(r.Context(), agentID, req.Version); err != nil {
+		if err.Error() == "version not found for this agent" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve agent version: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	slo := &models.SLO{
+		AgentID:    agentID,
+		Version:    req.Version,
+		Name:       req.Name,
+		Objective:  req.Objective,
+		Target:     req.Target,
+		Threshold:  req.Threshold,
+		WindowDays: req.WindowDays,
+	}
+	if slo.Name == "" {
+		slo.Name = defaultSLOName(slo)
+	}
+
+	if err := sloRepoFor(r.Context(), h.repo).CreateSLO(r.Context(), slo); err != nil {
+		http.Error(w, "Failed to create SLO: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, slo)
+}
+
+// ListSLOs handles GET /api/v1/agents/{agentId}/slo
+func (h *SLOHandler) ListSLOs(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+
+	slos, err := sloRepoFor(r.Context(), h.repo).ListSLOs(r.Context(), agentID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve SLOs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, slos)
+}
+
+// GetSLO handles GET /api/v1/agents/{agentId}/slo/{sloId}
+func (h *SLOHandler) GetSLO(w http.ResponseWriter, r *http.Request) {
+	agentID, sloID, ok := parseSLOPath(w, r)
+	if !ok {
+		return
+	}
+
+	slo, err := sloRepoFor(r.Context(), h.repo).GetSLO(r.Context(), agentID, sloID)
+	if err != nil {
+		respondSLOError(w, "Failed to retrieve SLO", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, slo)
+}
+
+// DeleteSLO handles DELETE /api/v1/agents/{agentId}/slo/{sloId}
+func (h *SLOHandler) DeleteSLO(w http.ResponseWriter, r *http.Request) {
+	agentID, sloID, ok := parseSLOPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := sloRepoFor(r.Context(), h.repo).DeleteSLO(r.Context(), agentID, sloID); err != nil {
+		respondSLOError(w, "Failed to delete SLO", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSLOStatus handles GET /api/v1/agents/{agentId}/slo/status
+func (h *SLOHandler) GetSLOStatus(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+
+	statuses, err := sloRepoFor(r.Context(), h.repo).ListSLOStatuses(r.Context(), agentID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve SLO statuses: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, statuses)
+}
+
+// parseSLOPath parses the agent and SLO IDs of the request path, responding with 400 when either is invalid
+func parseSLOPath(w http.ResponseWriter, r *http.Request) (agentID, sloID primitive.ObjectID, ok bool) {
+	vars := mux.Vars(r)
+	agentID, err := primitive.ObjectIDFromHex(vars["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return agentID, sloID, false
+	}
+
+	sloID, err = primitive.ObjectIDFromHex(vars["sloId"])
+	if err != nil {
+		http.Error(w, "Invalid SLO ID format", http.StatusBadRequest)
+		return agentID, sloID, false
+	}
+
+	return agentID, sloID, true
+}
+
+// validateSLORequest checks the version, objective, target, threshold and window of an SLO
+func validateSLORequest(req models.SLORequest) error {
+	if req.Version == "" {
+		return errors.New("version is required")
+	}
+	if !contains(models.SLOObjectives, req.Objective) {
+		return fmt.Errorf("unknown objective %q, expected %s or %s", req.Objective, models.SLOObjectiveSuccessRate, models.SLOObjectiveLatency)
+	}
+
+	// A target of 100 leaves no error budget to burn
+	if req.Target <= 0 || req.Target >= 100 {
+		return errors.New("target must be a percentage between 0 and 100, exclusive")
+	}
+	if req.Objective == models.SLOObjectiveLatency && req.Threshold <= 0 {
+		return errors.New("threshold must be a positive number of seconds for a latency objective")
+	}
+	if req.Objective != models.SLOObjectiveLatency && req.Threshold != 0 {
+		return errors.New("threshold is only supported for a latency objective")
+	}
+	if req.WindowDays < 1 || req.WindowDays > maxSLOWindowDays {
+		return fmt.Errorf("window_days must be between 1 and %d", maxSLOWindowDays)
+	}
+
+	return nil
+}
+
+// defaultSLOName names an SLO after its objective, such as "99% success" or "p95 < 3s"
+func defaultSLOName(slo *models.SLO) string {
+	if slo.Objective == models.SLOObjectiveLatency {
+		return fmt.Sprintf("p%g < %gs", slo.Target, slo.Threshold)
+	}
+	return fmt.Sprintf("%g%% success", slo.Target)
+}
+
+// respondSLOError responds with 404 when the SLO does not exist, 500 otherwise
+func respondSLOError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "slo not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
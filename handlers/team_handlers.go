@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// teamNamePattern restricts team names to values that are safe to use in a URL path
+var teamNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// TeamHandler handles HTTP requests for teams and the ownership of agents
+type TeamHandler struct {
+	repo db.AgentStore
+}
+
+// NewTeamHandler creates a new team handler
+func NewTeamHandler(repo db.AgentStore) *TeamHandler {
+	return &TeamHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the team routes
+func (h *TeamHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/teams", h.CreateTeam).Methods("POST")
+	router.HandleFunc("/api/v1/teams", h.ListTeams).Methods("GET")
+	router.HandleFunc("/api/v1/teams/{name}", h.GetTeam).Methods("GET")
+	router.HandleFunc("/api/v1/teams/{name}", h.DeleteTeam).Methods("DELETE")
+	router.HandleFunc("/api/v1/agents/{agentId}/team", h.SetAgentTeam).Methods("PUT")
+}
+
+// CreateTeam handles POST /api/v1/teams
+func (h *TeamHandler) CreateTeam(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if !teamNamePattern.MatchString(req.Name) {
+		http.Error(w, "Invalid team: name must be 1 to 64 lowercase letters, digits, - or _", http.StatusBadRequest)
+		return
+	}
+
+	team := &models.Team{
+		Name:         req.Name,
+		Email:        req.Email,
+		OnCall:       req.OnCall,
+		SlackChannel: req.SlackChannel,
+	}
+	if err := agentRepoFor(r.Context(), h.repo).CreateTeam(r.Context(), team); err != nil {
+		if err.Error() == "team already exists" {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create team: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, team)
+}
+
+// ListTeams handles GET /api/v1/teams
+func (h *TeamHandler) ListTeams(w http.ResponseWriter, r *http.Request) {
+	teams, err := agentRepoFor(r.Context(), h.repo).ListTeams(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve teams: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSONWithETag(w, r, http.StatusOK, teams)
+}
+
+// GetTeam handles GET /api/v1/teams/{name}
+func (h *TeamHandler) GetTeam(w http.ResponseWriter, r *http.Request) {
+	team, err := agentRepoFor(r.Context(), h.repo).GetTeam(r.Context(), mux.Vars(r)["name"])
+	if err != nil {
+		respondTeamError(w, "Failed to retrieve team", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, team)
+}
+
+// DeleteTeam handles DELETE /api/v1/teams/{name}
+//
+// Teams owning agents can not be deleted, their agents must be assigned to another team first.
+func (h *TeamHandler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	if err := agentRepoFor(r.Context(), h.repo).DeleteTeam(r.Context(), mux.Vars(r)["name"]); err != nil {
+		if err.Error() == "team owns agents" {
+			http.Error(w, "The team owns agents, assign them to another team first", http.StatusConflict)
+			return
+		}
+		respondTeamError(w, "Failed to delete team", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetAgentTeam handles PUT /api/v1/agents/{agentId}/team
+func (h *TeamHandler) SetAgentTeam(w http.ResponseWriter, r *http.Request) {
+	agentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["agentId"])
+	if err != nil {
+		http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req models.SetAgentTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	repo := agentRepoFor(r.Context(), h.repo)
+	var owner *models.Team
+	if req.Team != "" {
+		if owner, err = repo.GetTeam(r.Context(), req.Team); err != nil {
+			respondUnknownTeam(w, req.Team, err)
+			return
+		}
+	}
+
+	agent, err := repo.SetAgentTeam(r.Context(), agentID, req.Team)
+	if err != nil {
+		if err.Error() == "agent not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to update agent: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	agent.Owner = owner
+
+	respondJSON(w, http.StatusOK, agent)
+}
+
+// setAgentOwners sets the owning team of agents owned by a team
+func setAgentOwners(ctx context.Context, repo db.AgentStore, agents []models.Agent) error {
+	teams, err := agentRepoFor(ctx, repo).ListTeams(ctx)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*models.Team, len(teams))
+	for i := range teams {
+		byName[teams[i].Name] = &teams[i]
+	}
+	for i := range agents {
+		agents[i].Owner = byName[agents[i].Team]
+	}
+	return nil
+}
+
+// respondUnknownTeam responds with 400 Bad Request when a team assigned to an agent does not exist
+func respondUnknownTeam(w http.ResponseWriter, team string, err error) {
+	if err.Error() == "team not found" {
+		http.Error(w, "Unknown team "+team+", create it with POST /api/v1/teams", http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Failed to retrieve team: "+err.Error(), http.StatusInternalServerError)
+}
+
+func respondTeamError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "team not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"ripple/auth"
+	"ripple/db"
+
+	"github.com/gorilla/mux"
+)
+
+// requiredScopeKey is the context key of the database of the requests that tenancy requires to be scoped to a tenant
+// or organization, see RequireScopeMiddleware
+type requiredScopeKey struct{}
+
+// RequireScopeMiddleware makes the requests of the tenant modes other than single fail when they were not scoped to a
+// tenant or organization by the middlewares that follow it, rather than fall back to the default repositories, which
+// read and write the data of every tenant. It runs before the tenant and org middlewares.
+func RequireScopeMiddleware(base *db.MongoDB) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), requiredScopeKey{}, base.MissingScope())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestDatabase returns the database selected for a request, or nil when the default repositories are used. With
+// RequireScopeMiddleware, requests that were not scoped get a database failing every read and write instead.
+func requestDatabase(ctx context.Context) *db.MongoDB {
+	if database := db.DatabaseFromContext(ctx); database != nil {
+		return database
+	}
+	database, _ := ctx.Value(requiredScopeKey{}).(*db.MongoDB)
+	return database
+}
+
+// TenantMiddleware selects the tenant database from the given request header. It runs after AuthMiddleware, and
+// rejects the credentials of other tenants than the one of the header, so that the tenant is only resolved for its
+// own callers. Requests without a tenant are rejected, as are requests for tenants that were not provisioned. Ingest
+// tokens carry no tenant, they are checked against the tokens of the tenant by the routes accepting them.
+func TenantMiddleware(router *db.TenantRouter, header string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var template string
+			if route := mux.CurrentRoute(r); route != nil {
+				template, _ = route.GetPathTemplate()
+			}
+			// The bootstrap API key provisioning tenants belongs to none
+			if strings.HasPrefix(template, tenantRoutePrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tenant := r.Header.Get(header)
+			if tenant == "" {
+				http.Error(w, "Missing tenant, set the "+header+" header", http.StatusBadRequest)
+				return
+			}
+			// The bootstrap API key belongs to no tenant
+			if claims := auth.ClaimsFromContext(r.Context()); claims != nil && !claims.Bootstrap && claims.Tenant != tenant {
+				http.Error(w, "Forbidden: the credentials of the request are not valid for tenant "+tenant, http.StatusForbidden)
+				return
+			}
+
+			database, err := router.Database(r.Context(), tenant)
+			if err != nil {
+				switch err.Error() {
+				case "invalid tenant name":
+					http.Error(w, "Invalid tenant: "+err.Error(), http.StatusBadRequest)
+				case "tenant not found":
+					http.Error(w, "Unknown tenant "+tenant, http.StatusNotFound)
+				default:
+					http.Error(w, "Failed to select tenant: "+err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(db.WithDatabase(r.Context(), database)))
+		})
+	}
+}
+
+// OrgMiddleware scopes requests to the organization of the caller: the org_id claim of its JWT or session, or the
+// organization of the agent its ingest token was issued for, in the region of the organization. It runs after
+// AuthMiddleware. Requests without a known organization are rejected, and the organization of the others is added to
+// their context for its quotas.
+func OrgMiddleware(router *db.TenantRouter, base *db.MongoDB) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var template string
+			if route := mux.CurrentRoute(r); route != nil {
+				template, _ = route.GetPathTemplate()
+			}
+			// Sign in happens before the organization of the caller is known, and the bootstrap API key provisioning
+			// organizations belongs to none
+			if strings.HasPrefix(template, "/auth/") || strings.HasPrefix(template, orgRoutePrefix) || contains(publicRoutes, template) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var orgID string
+			if claims := auth.ClaimsFromContext(r.Context()); claims != nil {
+				orgID = claims.OrgID
+			} else {
+				// Ingest tokens are looked up across organizations, since the organization of the request is not
+				// known yet, and the request is scoped to the organization the token was issued in
+				token, err := requestIngestToken(r, db.NewAgentRepository(base))
+				if err != nil {
+					if err.Error() == "ingest token not found" {
+						w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+						http.Error(w, "Invalid ingest token", http.StatusUnauthorized)
+					} else {
+						http.Error(w, "Failed to check ingest token: "+err.Error(), http.StatusInternalServerError)
+					}
+					return
+				}
+				if token != nil {
+					orgID = token.OrgID.Hex()
+				}
+			}
+			if orgID == "" {
+				http.Error(w, "No organization, the credentials of the request must carry an org_id claim", http.StatusForbidden)
+				return
+			}
+
+			database, err := router.Database(r.Context(), orgID)
+			if err != nil {
+				if err.Error() == "invalid organization ID" || err.Error() == "tenant not found" {
+					http.Error(w, "Unknown organization "+orgID, http.StatusForbidden)
+				} else {
+					http.Error(w, "Failed to get organization: "+err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			org, err := db.NewOrganizationRepository(base).GetOrganization(r.Context(), database.OrgID)
+			if err != nil {
+				if err.Error() == "organization not found" {
+					http.Error(w, "Unknown organization "+orgID, http.StatusForbidden)
+				} else {
+					http.Error(w, "Failed to get organization: "+err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+			database, err = database.ForRegion(org.Region)
+			if err != nil {
+				http.Error(w, "Failed to select the region of organization "+orgID+": "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(db.WithDatabase(r.Context(), database), organizationKey{}, org)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ProjectMiddleware scopes the requests of callers whose credentials carry a project claim to the agents of the
+// project and their data, within the database of their tenant or organization or else the base database. It runs
+// after the tenant and org middlewares.
+func ProjectMiddleware(base *db.MongoDB) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := auth.ClaimsFromContext(r.Context())
+			if claims == nil || claims.Project == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			database := requestDatabase(r.Context())
+			if database == nil {
+				database = base
+			}
+			if database == nil {
+				http.Error(w, "Forbidden: project scoped credentials are not supported in demo mode", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(db.WithDatabase(r.Context(), database.ForProject(claims.Project))))
+		})
+	}
+}
+
+// agentRepoFor returns the agent repository of the request's tenant, or the default repository
+func agentRepoFor(ctx context.Context, fallback db.AgentStore) db.AgentStore {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewAgentRepository(database)
+	}
+	return fallback
+}
+
+// uiRepoFor returns the UI repository of the request's tenant, on its analytics client when one is connected, or the
+// default repository
+func uiRepoFor(ctx context.Context, fallback db.UIStore) db.UIStore {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewUIRepository(database.Analytics())
+	}
+	return fallback
+}
+
+// metricsRepoFor returns the metrics repository of the request's tenant, or the default repository
+func metricsRepoFor(ctx context.Context, fallback db.MetricsStore) db.MetricsStore {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewMetricsRepository(database)
+	}
+	return fallback
+}
+
+// webhookRepoFor returns the webhook repository of the request's tenant, or the default repository
+func webhookRepoFor(ctx context.Context, fallback *db.WebhookRepository) *db.WebhookRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewWebhookRepository(database)
+	}
+	return fallback
+}
+
+// seriesRepoFor returns the series repository of the request's tenant, or the default repository
+func seriesRepoFor(ctx context.Context, fallback *db.SeriesRepository) *db.SeriesRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewSeriesRepository(database)
+	}
+	return fallback
+}
+
+// exportRepoFor returns the export repository of the request's tenant, or the default repository
+func exportRepoFor(ctx context.Context, fallback *db.ExportRepository) *db.ExportRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewExportRepository(database)
+	}
+	return fallback
+}
+
+// archiveRepoFor returns the archive repository of the request's tenant, or the default repository
+func archiveRepoFor(ctx context.Context, fallback *db.ArchiveRepository) *db.ArchiveRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewArchiveRepository(database)
+	}
+	return fallback
+}
+
+// artifactRepoFor returns the artifact repository of the request's tenant, or the default repository
+func artifactRepoFor(ctx context.Context, fallback *db.ArtifactRepository) *db.ArtifactRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewArtifactRepository(database)
+	}
+	return fallback
+}
+
+// purgeRepoFor returns the purge repository of the request's tenant, or the default repository
+func purgeRepoFor(ctx context.Context, fallback *db.PurgeRepository) *db.PurgeRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewPurgeRepository(database)
+	}
+	return fallback
+}
+
+// sloRepoFor returns the SLO repository of the request's tenant, or the default repository
+func sloRepoFor(ctx context.Context, fallback *db.SLORepository) *db.SLORepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewSLORepository(database)
+	}
+	return fallback
+}
+
+// budgetRepoFor returns the budget repository of the request's tenant, or the default repository
+func budgetRepoFor(ctx context.Context, fallback *db.BudgetRepository) *db.BudgetRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewBudgetRepository(database)
+	}
+	return fallback
+}
+
+// savedQueryRepoFor returns the saved query repository of the request's tenant, or the default repository
+func savedQueryRepoFor(ctx context.Context, fallback *db.SavedQueryRepository) *db.SavedQueryRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewSavedQueryRepository(database)
+	}
+	return fallback
+}
+
+// redactionRepoFor returns the redaction rule repository of the request's tenant, or the default repository
+func redactionRepoFor(ctx context.Context, fallback *db.RedactionRepository) *db.RedactionRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewRedactionRepository(database)
+	}
+	return fallback
+}
+
+// retentionRepoFor returns the retention policy repository of the request's tenant, or the default repository
+func retentionRepoFor(ctx context.Context, fallback *db.RetentionPolicyRepository) *db.RetentionPolicyRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewRetentionPolicyRepository(database)
+	}
+	return fallback
+}
+
+// reportRepoFor returns the report repository of the request's tenant, or the default repository
+func reportRepoFor(ctx context.Context, fallback *db.ReportRepository) *db.ReportRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewReportRepository(database)
+	}
+	return fallback
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+)
+
+// tenantRoutePrefix starts the routes provisioning tenants in database tenant mode, which only the bootstrap API key
+// can call
+const tenantRoutePrefix = "/api/v1/admin/tenants"
+
+// TenantHandler handles HTTP requests provisioning the tenants of database tenant mode, which requests are only
+// routed to once provisioned
+type TenantHandler struct {
+	tenants *db.TenantRouter
+}
+
+// NewTenantHandler creates a new tenant handler
+func NewTenantHandler(tenants *db.TenantRouter) *TenantHandler {
+	return &TenantHandler{
+		tenants: tenants,
+	}
+}
+
+// RegisterRoutes registers the tenant routes
+func (h *TenantHandler) RegisterRoutes(router *mux.Router) {
+	tenantRouter := router.PathPrefix(tenantRoutePrefix).Subrouter()
+	tenantRouter.Use(requireBootstrap)
+
+	tenantRouter.HandleFunc("", h.ProvisionTenant).Methods("POST")
+	tenantRouter.HandleFunc("", h.ListTenants).Methods("GET")
+}
+
+// ProvisionTenant handles POST /api/v1/admin/tenants
+//
+// The tenant is registered and the indexes and validators of its database are created. Provisioning an existing
+// tenant sets its database up again.
+func (h *TenantHandler) ProvisionTenant(w http.ResponseWriter, r *http.Request) {
+	var req models.ProvisionTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	tenant, created, err := h.tenants.Provision(r.Context(), req.Name)
+	if err != nil {
+		if err.Error() == "invalid tenant name" {
+			http.Error(w, "Invalid tenant: the name must be lowercase alphanumeric, plus - and _, up to 48 characters", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to provision tenant: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondJSON(w, status, tenant)
+}
+
+// ListTenants handles GET /api/v1/admin/tenants
+func (h *TenantHandler) ListTenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := h.tenants.ListTenants(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve tenants: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, tenants)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"ripple/models"
+)
+
+// TraceLinkTemplates maps a tracing backend name (e.g. jaeger, tempo, datadog) to a deep-link URL template.
+// Templates may contain the {trace_id} and {span_id} placeholders.
+type TraceLinkTemplates map[string]string
+
+// ParseTraceLinkTemplates parses templates in the form "jaeger=https://jaeger/trace/{trace_id},tempo=..."
+func ParseTraceLinkTemplates(s string) (TraceLinkTemplates, error) {
+	templates := TraceLinkTemplates{}
+	if s == "" {
+		return templates, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		name, tmpl, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || tmpl == "" {
+			return nil, fmt.Errorf("invalid trace link template %q, expected name=url", entry)
+		}
+		templates[name] = tmpl
+	}
+
+	return templates, nil
+}
+
+// Links renders the deep links for a trace and span. The IDs are escaped, so that the IDs of runs recorded before
+// they were validated can not change the URL beyond their placeholder.
+func (t TraceLinkTemplates) Links(traceID, spanID string) map[string]string {
+	if traceID == "" || len(t) == 0 {
+		return nil
+	}
+
+	replacer := strings.NewReplacer("{trace_id}", url.QueryEscape(traceID), "{span_id}", url.QueryEscape(spanID))
+	links := make(map[string]string, len(t))
+	for name, tmpl := range t {
+		links[name] = replacer.Replace(tmpl)
+	}
+
+	return links
+}
+
+// addRunTraceLinks populates the trace links of the given runs
+func (t TraceLinkTemplates) addRunTraceLinks(runs []models.AgentRun) {
+	for i := range runs {
+		runs[i].TraceLinks = t.Links(runs[i].TraceID, runs[i].SpanID)
+	}
+}
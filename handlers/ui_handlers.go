@@ -1,22 +1,45 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"ripple/anomaly"
 	"ripple/db"
+	"ripple/events"
+	"ripple/models"
+	"ripple/policy"
 
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // UIHandler handles HTTP requests for UI-related operations
 type UIHandler struct {
-	repo *db.UIRepository
+	repo      db.UIStore
+	agentRepo db.AgentStore
+	events    *events.Broker
+	anomalies *anomaly.Detector
+	display   *DisplaySettings
+	policy    policy.Policy
+	// origins are the origins the live activity feed may be opened from besides that of the server
+	origins []string
 }
 
-// NewUIHandler creates a new UI handler
-func NewUIHandler(repo *db.UIRepository) *UIHandler {
+// NewUIHandler creates a new UI handler. The broker provides the runs pushed on the live activity feed, the
+// detector, when not nil, the anomalies flagged on the dashboard statistics, and the display settings the locale
+// and currency of the dashboard statistics. The policy authorizes every request before the repository is called.
+func NewUIHandler(repo db.UIStore, agentRepo db.AgentStore, broker *events.Broker, detector *anomaly.Detector, display *DisplaySettings, p policy.Policy) *UIHandler {
 	return &UIHandler{
-		repo: repo,
+		repo:      repo,
+		agentRepo: agentRepo,
+		events:    broker,
+		anomalies: detector,
+		display:   display,
+		policy:    p,
 	}
 }
 
@@ -29,37 +52,520 @@ func (h *UIHandler) RegisterRoutes(router *mux.Router) {
 	uiRouter.HandleFunc("/stats", h.GetDashboardStats).Methods("GET")
 	uiRouter.HandleFunc("/recent_activity", h.GetRecentActivity).Methods("GET")
 	uiRouter.HandleFunc("/agent_versions", h.GetAgentVersions).Methods("GET")
+	uiRouter.HandleFunc("/agent_versions/export", h.ExportAgentVersions).Methods("GET")
+	uiRouter.HandleFunc("/health", h.GetHealthSummary).Methods("GET")
+	uiRouter.HandleFunc("/models/stats", h.GetModelStats).Methods("GET")
+	uiRouter.HandleFunc("/clusters", h.GetClusterStats).Methods("GET")
+	uiRouter.HandleFunc("/heatmap", h.GetHeatmap).Methods("GET")
+	uiRouter.HandleFunc("/top", h.GetLeaderboard).Methods("GET")
+	uiRouter.HandleFunc("/errors", h.GetErrorReport).Methods("GET")
+	uiRouter.HandleFunc("/cost/breakdown", h.GetCostBreakdown).Methods("GET")
+	uiRouter.HandleFunc("/tokens", h.GetTokenUsage).Methods("GET")
+	uiRouter.HandleFunc("/ws", h.LiveActivity).Methods("GET")
 
 }
 
 // GetDashboardStats handles GET /api/v1/ui/stats
 func (h *UIHandler) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
-	stats, err := h.repo.GetDashboardStats()
+	if !h.can(w, r) {
+		return
+	}
+
+	project := r.URL.Query().Get("project")
+	locale := h.display.localeFor(project)
+	if tag := r.URL.Query().Get("locale"); tag != "" {
+		l, ok := db.LookupLocale(tag)
+		if !ok {
+			http.Error(w, "Unsupported locale: "+tag, http.StatusBadRequest)
+			return
+		}
+		locale = l
+	} else if l, ok := acceptLanguageLocale(r.Header.Get("Accept-Language")); ok {
+		locale = l
+	}
+	locale = locale.WithCurrency(h.display.currencyFor(project))
+
+	filter := db.DashboardFilter{
+		Cluster:  r.URL.Query().Get("cluster"),
+		Project:  project,
+		Team:     r.URL.Query().Get("team"),
+		Location: h.display.timezoneFor(project),
+	}
+	var err error
+	if filter.IncludeArchived, err = parseIncludeArchived(r); err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.From, filter.To, err = parseTimeRange(r, maxUsageStatsWindow); err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept-Language")
+	stats, err := uiRepoFor(r.Context(), h.repo).GetDashboardStats(r.Context(), locale, filter)
 	if err != nil {
 		http.Error(w, "Failed to retrieve dashboard stats: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, stats)
+	// Anomalies are detected over all runs, so they only apply to the default windows without filters
+	if h.anomalies != nil && filter == (db.DashboardFilter{Location: filter.Location}) {
+		h.anomalies.Annotate(anomaly.Key(r.Context()), stats)
+	}
+
+	respondJSONWithETag(w, r, http.StatusOK, stats)
 }
 
 // GetRecentActivity handles GET /api/v1/ui/recent_activity
 func (h *UIHandler) GetRecentActivity(w http.ResponseWriter, r *http.Request) {
-	activities, err := h.repo.GetRecentActivity()
+	if !h.can(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := db.ActivityFilter{Limit: defaultActivityLimit}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxActivityLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxActivityLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if agentIDStr := query.Get("agent_id"); agentIDStr != "" {
+		agentID, err := primitive.ObjectIDFromHex(agentIDStr)
+		if err != nil {
+			http.Error(w, "Invalid agent ID format", http.StatusBadRequest)
+			return
+		}
+		filter.AgentID = &agentID
+	}
+	if status := query.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				filter.Statuses = append(filter.Statuses, s)
+			}
+		}
+	}
+	if token := query.Get("cursor"); token != "" {
+		cursor, err := db.ParseActivityCursor(token)
+		if err != nil {
+			http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.After = cursor
+	}
+
+	activities, next, err := uiRepoFor(r.Context(), h.repo).GetRecentActivity(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "Failed to retrieve recent activity: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// The body stays a plain list, the cursor of the next page is returned in a header
+	if next != nil {
+		w.Header().Set("X-Next-Cursor", next.String())
+	}
+
 	respondJSON(w, http.StatusOK, activities)
 }
 
+// GetModelStats handles GET /api/v1/ui/models/stats
+func (h *UIHandler) GetModelStats(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	window, err := parseWindow(r, defaultUsageStatsWindow, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	stats, err := uiRepoFor(r.Context(), h.repo).GetModelStats(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Failed to compute model statistics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.UsageStatsReport{
+		From:  from,
+		To:    to,
+		Stats: stats,
+	})
+}
+
+// GetClusterStats handles GET /api/v1/ui/clusters
+func (h *UIHandler) GetClusterStats(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	window, err := parseWindow(r, defaultUsageStatsWindow, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	clusters, err := uiRepoFor(r.Context(), h.repo).GetClusterStats(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Failed to compute cluster statistics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.ClusterStatsReport{
+		From:     from,
+		To:       to,
+		Clusters: clusters,
+	})
+}
+
+// GetHeatmap handles GET /api/v1/ui/heatmap
+func (h *UIHandler) GetHeatmap(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = models.HeatmapRuns
+	}
+	if metric != models.HeatmapRuns && metric != models.HeatmapCost {
+		http.Error(w, fmt.Sprintf("Invalid metric %q, expected runs or cost", metric), http.StatusBadRequest)
+		return
+	}
+
+	window, err := parseWindow(r, defaultHeatmapWindow, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	cells, err := uiRepoFor(r.Context(), h.repo).GetHeatmapCells(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Failed to compute heatmap: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.Heatmap{
+		Metric: metric,
+		From:   from,
+		To:     to,
+		Values: db.BuildHeatmap(metric, cells),
+	})
+}
+
+// GetLeaderboard handles GET /api/v1/ui/top
+func (h *UIHandler) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	by := query.Get("by")
+	if by == "" {
+		by = models.LeaderboardCost
+	}
+	if by != models.LeaderboardCost && by != models.LeaderboardErrors && by != models.LeaderboardRuns {
+		http.Error(w, fmt.Sprintf("Invalid by %q, expected cost, errors or runs", by), http.StatusBadRequest)
+		return
+	}
+
+	group := query.Get("group")
+	if group == "" {
+		group = models.LeaderboardByAgent
+	}
+	if group != models.LeaderboardByAgent && group != models.LeaderboardByVersion {
+		http.Error(w, fmt.Sprintf("Invalid group %q, expected agent or version", group), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultLeaderboardLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxLeaderboardLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxLeaderboardLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	window, err := parseWindow(r, defaultLeaderboardWindow, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	entries, err := uiRepoFor(r.Context(), h.repo).GetLeaderboard(r.Context(), by, group, from, to, limit)
+	if err != nil {
+		http.Error(w, "Failed to compute leaderboard: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.Leaderboard{
+		By:      by,
+		Group:   group,
+		From:    from,
+		To:      to,
+		Entries: entries,
+	})
+}
+
+// GetErrorReport handles GET /api/v1/ui/errors
+func (h *UIHandler) GetErrorReport(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	limit := defaultErrorCategoriesLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxErrorCategoriesLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxErrorCategoriesLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	window, err := parseWindow(r, defaultUsageStatsWindow, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo := uiRepoFor(r.Context(), h.repo)
+	to := time.Now().UTC()
+	from := to.Add(-window)
+	previousFrom := from.Add(-window)
+	current, err := repo.GetErrorCounts(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Failed to count errors: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	previous, err := repo.GetErrorCounts(r.Context(), previousFrom, from)
+	if err != nil {
+		http.Error(w, "Failed to count errors: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, &models.ErrorReport{
+		From:         from,
+		To:           to,
+		PreviousFrom: previousFrom,
+		Agents:       db.BuildErrorReport(current, previous, limit),
+	})
+}
+
+// GetCostBreakdown handles GET /api/v1/ui/cost/breakdown
+func (h *UIHandler) GetCostBreakdown(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = models.CostGroupAgent
+	}
+	if groupBy != models.CostGroupAgent && groupBy != models.CostGroupProject && groupBy != models.CostGroupTeam && groupBy != models.CostGroupModel {
+		http.Error(w, fmt.Sprintf("Invalid group_by %q, expected agent, project, team or model", groupBy), http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(r, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.IsZero() {
+		to = time.Now().UTC()
+		from = to.Add(-defaultUsageStatsWindow)
+	}
+
+	items, err := uiRepoFor(r.Context(), h.repo).GetCostBreakdown(r.Context(), groupBy, from, to)
+	if err != nil {
+		http.Error(w, "Failed to compute cost breakdown: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	total := db.FinishCostBreakdown(items)
+	respondJSON(w, http.StatusOK, &models.CostBreakdown{
+		GroupBy:   groupBy,
+		From:      from,
+		To:        to,
+		TotalCost: total,
+		Items:     items,
+	})
+}
+
+// GetTokenUsage handles GET /api/v1/ui/tokens
+func (h *UIHandler) GetTokenUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	from, to, err := parseTimeRange(r, maxUsageStatsWindow)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.IsZero() {
+		to = time.Now().UTC()
+		from = to.Add(-defaultTokenUsageWindow)
+	}
+
+	loc := h.display.timezoneFor("")
+	items, err := uiRepoFor(r.Context(), h.repo).GetTokenUsage(r.Context(), from, to, loc)
+	if err != nil {
+		http.Error(w, "Failed to compute token usage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	usage := &models.TokenUsage{From: from, To: to, Timezone: loc.String()}
+	db.FinishTokenUsage(usage, items)
+	respondJSON(w, http.StatusOK, usage)
+}
+
+// GetAgentVersions handles GET /api/v1/ui/agent_versions
 func (h *UIHandler) GetAgentVersions(w http.ResponseWriter, r *http.Request) {
-	agents, err := h.repo.GetAgentVersions(r.Context())
+	if !h.can(w, r) {
+		return
+	}
+
+	listOpts, err := parseListOptions(r, models.AgentVersionMetrics{}, versionMetricsSortFields)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseVersionMetricsFilter(r)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxAgentVersionsLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxAgentVersionsLimit), http.StatusBadRequest)
+			return
+		}
+		listOpts.Limit = limit
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err := strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset, expected a non-negative number", http.StatusBadRequest)
+			return
+		}
+		listOpts.Offset = offset
+	}
+
+	repo := uiRepoFor(r.Context(), h.repo)
+	agents, err := repo.GetAgentVersions(r.Context(), filter, listOpts)
+	if err != nil {
+		http.Error(w, "Failed to get agents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The body stays a plain list, the number of versions matching the filter is returned in a header
+	total, err := repo.CountAgentVersions(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to count agents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	data, err := selectFields(agents, listOpts.Fields)
+	if err != nil {
+		http.Error(w, "Failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSONWithETag(w, r, http.StatusOK, data)
+}
+
+// GetHealthSummary handles GET /api/v1/ui/health
+func (h *UIHandler) GetHealthSummary(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	filter, err := parseVersionMetricsFilter(r)
+	if err != nil {
+		http.Error(w, "Invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metrics, err := uiRepoFor(r.Context(), h.repo).GetAgentVersions(r.Context(), filter, db.ListOptions{})
 	if err != nil {
 		http.Error(w, "Failed to get agents: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, agents)
+	respondJSONWithETag(w, r, http.StatusOK, models.NewHealthSummary(metrics))
+}
+
+// parseVersionMetricsFilter parses the project, team, status, cluster and health query parameters of the agent
+// version listings. Several statuses and health statuses can be given separated by commas.
+func parseVersionMetricsFilter(r *http.Request) (db.VersionMetricsFilter, error) {
+	query := r.URL.Query()
+	filter := db.VersionMetricsFilter{
+		Project: query.Get("project"),
+		Team:    query.Get("team"),
+		Cluster: query.Get("cluster"),
+	}
+	if statuses := query.Get("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.TrimSpace(status)
+			if status == "" {
+				return db.VersionMetricsFilter{}, fmt.Errorf("invalid status %q, statuses can not be empty", statuses)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	if healths := query.Get("health"); healths != "" {
+		for _, health := range strings.Split(healths, ",") {
+			health = strings.TrimSpace(health)
+			if !contains(models.HealthStatuses, health) {
+				return db.VersionMetricsFilter{}, fmt.Errorf("invalid health %q, expected one of %s", health, strings.Join(models.HealthStatuses, ", "))
+			}
+			filter.Health = append(filter.Health, health)
+		}
+	}
+	return filter, nil
+}
+
+// acceptLanguageLocale returns the supported locale of an Accept-Language header with the highest quality value,
+// the first listed among equal ones. Languages with a quality value of 0 are not acceptable.
+func acceptLanguageLocale(header string) (*db.Locale, bool) {
+	var best *db.Locale
+	bestQ := 0.0
+	for _, lang := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(lang, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) != "q" {
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && parsed >= 0 && parsed <= 1 {
+				q = parsed
+			}
+		}
+
+		if l, ok := db.LookupLocale(tag); ok && q > bestQ {
+			best, bestQ = l, q
+		}
+	}
+	return best, best != nil
 }
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"ripple/auth"
+	"ripple/db"
+	"ripple/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserHandler handles HTTP requests for the users signing in with a password
+type UserHandler struct {
+	repo *db.UserRepository
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(repo *db.UserRepository) *UserHandler {
+	return &UserHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the user routes
+func (h *UserHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/users", h.CreateUser).Methods("POST")
+	router.HandleFunc("/api/v1/users", h.ListUsers).Methods("GET")
+	router.HandleFunc("/api/v1/users/{id}", h.GetUser).Methods("GET")
+	router.HandleFunc("/api/v1/users/{id}", h.DeleteUser).Methods("DELETE")
+}
+
+// CreateUser handles POST /api/v1/users
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	// Users are not scoped to a project, so project scoped callers could otherwise create callers with more access
+	if claims := auth.ClaimsFromContext(r.Context()); claims != nil && claims.Project != "" {
+		http.Error(w, "Forbidden: project scoped callers can not create users", http.StatusForbidden)
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if !strings.Contains(req.Email, "@") {
+		http.Error(w, "Invalid user: a valid email is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Roles) == 0 {
+		http.Error(w, "Invalid user: at least one role is required", http.StatusBadRequest)
+		return
+	}
+	for _, role := range req.Roles {
+		if !contains(auth.SessionRoles, role) {
+			http.Error(w, "Invalid user: unknown role "+role+", expected one of "+strings.Join(auth.SessionRoles, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Invalid user: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := &models.User{
+		Email:        req.Email,
+		Name:         req.Name,
+		PasswordHash: hash,
+		Roles:        req.Roles,
+	}
+	if err := userRepoFor(r.Context(), h.repo).CreateUser(r.Context(), user); err != nil {
+		if err.Error() == "user already exists" {
+			http.Error(w, "A user with this email already exists", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create user: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, user)
+}
+
+// ListUsers handles GET /api/v1/users
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := userRepoFor(r.Context(), h.repo).ListUsers(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve users: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, users)
+}
+
+// GetUser handles GET /api/v1/users/{id}
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userRepoFor(r.Context(), h.repo).GetUser(r.Context(), id)
+	if err != nil {
+		respondUserError(w, "Failed to retrieve user", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// DeleteUser handles DELETE /api/v1/users/{id}
+//
+// The user can no longer sign in, sessions already issued stay valid until they expire.
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := userRepoFor(r.Context(), h.repo).DeleteUser(r.Context(), id); err != nil {
+		respondUserError(w, "Failed to delete user", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respondUserError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "user not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
+
+// userRepoFor returns the user repository of the request's tenant, or the default repository
+func userRepoFor(ctx context.Context, fallback *db.UserRepository) *db.UserRepository {
+	if database := requestDatabase(ctx); database != nil {
+		return db.NewUserRepository(database)
+	}
+	return fallback
+}
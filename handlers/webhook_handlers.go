@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"ripple/db"
+	"ripple/events"
+	"ripple/models"
+	"ripple/webhooks"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+// WebhookHandler handles HTTP requests for webhook management
+type WebhookHandler struct {
+	repo *db.WebhookRepository
+}
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(repo *db.WebhookRepository) *WebhookHandler {
+	return &WebhookHandler{
+		repo: repo,
+	}
+}
+
+// RegisterRoutes registers the webhook routes
+func (h *WebhookHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/api/v1/webhooks", h.CreateWebhook).Methods("POST")
+	router.HandleFunc("/api/v1/webhooks", h.ListWebhooks).Methods("GET")
+	router.HandleFunc("/api/v1/webhooks/{webhookId}", h.GetWebhook).Methods("GET")
+	router.HandleFunc("/api/v1/webhooks/{webhookId}", h.UpdateWebhook).Methods("PUT")
+	router.HandleFunc("/api/v1/webhooks/{webhookId}", h.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/api/v1/webhooks/{webhookId}/deliveries", h.ListDeliveries).Methods("GET")
+}
+
+// CreateWebhook handles POST /api/v1/webhooks
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if err := validateWebhookRequest(req); err != nil {
+		http.Error(w, "Invalid webhook: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook := &models.Webhook{
+		URL:         req.URL,
+		Events:      req.Events,
+		Secret:      req.Secret,
+		Description: req.Description,
+		Active:      req.Active == nil || *req.Active,
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = webhooks.NewSecret()
+	}
+
+	if err := webhookRepoFor(r.Context(), h.repo).CreateWebhook(r.Context(), webhook); err != nil {
+		http.Error(w, "Failed to create webhook: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The secret is only returned when the webhook is created
+	respondCredentials(w, http.StatusCreated, webhook)
+}
+
+// ListWebhooks handles GET /api/v1/webhooks
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := webhookRepoFor(r.Context(), h.repo).ListWebhooks(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to retrieve webhooks: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	respondJSON(w, http.StatusOK, hooks)
+}
+
+// GetWebhook handles GET /api/v1/webhooks/{webhookId}
+func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := primitive.ObjectIDFromHex(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID format", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := webhookRepoFor(r.Context(), h.repo).GetWebhook(r.Context(), webhookID)
+	if err != nil {
+		respondWebhookError(w, "Failed to retrieve webhook", err)
+		return
+	}
+
+	webhook.Secret = ""
+	respondJSON(w, http.StatusOK, webhook)
+}
+
+// UpdateWebhook handles PUT /api/v1/webhooks/{webhookId}
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := primitive.ObjectIDFromHex(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID format", http.StatusBadRequest)
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondBodyError(w, "Invalid request body", err)
+		return
+	}
+
+	if err := validateWebhookRequest(req); err != nil {
+		http.Error(w, "Invalid webhook: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo := webhookRepoFor(r.Context(), h.repo)
+	webhook, err := repo.GetWebhook(r.Context(), webhookID)
+	if err != nil {
+		respondWebhookError(w, "Failed to retrieve webhook", err)
+		return
+	}
+
+	webhook.URL = req.URL
+	webhook.Events = req.Events
+	webhook.Description = req.Description
+	if req.Secret != "" {
+		webhook.Secret = req.Secret
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+
+	if err := repo.UpdateWebhook(r.Context(), webhook); err != nil {
+		respondWebhookError(w, "Failed to update webhook", err)
+		return
+	}
+
+	webhook.Secret = ""
+	respondJSON(w, http.StatusOK, webhook)
+}
+
+// DeleteWebhook handles DELETE /api/v1/webhooks/{webhookId}
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := primitive.ObjectIDFromHex(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := webhookRepoFor(r.Context(), h.repo).DeleteWebhook(r.Context(), webhookID); err != nil {
+		respondWebhookError(w, "Failed to delete webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /api/v1/webhooks/{webhookId}/deliveries
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookID, err := primitive.ObjectIDFromHex(mux.Vars(r)["webhookId"])
+	if err != nil {
+		http.Error(w, "Invalid webhook ID format", http.StatusBadRequest)
+		return
+	}
+
+	limit := int64(defaultDeliveriesLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || limit <= 0 || limit > maxDeliveriesLimit {
+			http.Error(w, fmt.Sprintf("Invalid limit, expected a number between 1 and %d", maxDeliveriesLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	repo := webhookRepoFor(r.Context(), h.repo)
+	if _, err := repo.GetWebhook(r.Context(), webhookID); err != nil {
+		respondWebhookError(w, "Failed to retrieve webhook", err)
+		return
+	}
+
+	deliveries, err := repo.ListDeliveries(r.Context(), webhookID, limit)
+	if err != nil {
+		http.Error(w, "Failed to retrieve webhook deliveries: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, deliveries)
+}
+
+// validateWebhookRequest checks the URL and the subscribed event types of a webhook
+func validateWebhookRequest(req models.WebhookRequest) error {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if err := webhooks.CheckURL(req.URL); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+
+	if len(req.Events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	for _, event := range req.Events {
+		if !contains(events.Types, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	return nil
+}
+
+// respondWebhookError responds with 404 when the webhook does not exist, 500 otherwise
+func respondWebhookError(w http.ResponseWriter, msg string, err error) {
+	if err.Error() == "webhook not found" {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
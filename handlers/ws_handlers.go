@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/events"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+// ParseAllowedOrigins parses comma separated origins, such as https://dashboard.example.com, the live activity feed
+// may be opened from besides that of the server
+func ParseAllowedOrigins(value string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid origin %q, expected scheme://host[:port]", origin)
+		}
+		origins = append(origins, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return origins, nil
+}
+
+// SetAllowedOrigins allows the live activity feed to be opened from pages of other origins than that of the server,
+// such as a dashboard served apart from the API
+func (h *UIHandler) SetAllowedOrigins(origins []string) {
+	h.origins = origins
+}
+
+// checkOrigin reports whether a WebSocket may be opened for a request. Browsers send the Origin of the page opening
+// it, which must be that of the server or an allowed origin, so that other sites cannot read the feed with the
+// cookies of their visitors. Clients other than browsers send none.
+func (h *UIHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	origin = strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range h.origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ActivityMessage represents a message pushed on the live activity feed
+type ActivityMessage struct {
+	Type     string          `json:"type"`
+	Activity db.ActivityData `json:"activity"`
+}
+
+// LiveActivity handles GET /api/v1/ui/ws
+//
+// The connection is upgraded to a WebSocket that receives an activity message for every recorded run.
+func (h *UIHandler) LiveActivity(w http.ResponseWriter, r *http.Request) {
+	if !h.can(w, r) {
+		return
+	}
+
+	// In database-per-tenant and org modes, only the runs of the request's tenant are pushed, and only the runs of
+	// the agents of its project for project scoped callers
+	broker, tenantDatabase, org, project := h.events, "", primitive.NilObjectID, ""
+	if database := requestDatabase(r.Context()); database != nil {
+		if err := database.ScopeErr(); err != nil {
+			http.Error(w, "Failed to select the runs of the request: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		broker, tenantDatabase, org, project = database.Events, database.Name(), database.OrgID, database.Project
+	}
+	agentRepo := agentRepoFor(r.Context(), h.agentRepo)
+
+	upgrader := websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024, CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		return
+	}
+	defer conn.Close()
+
+	sub := broker.Subscribe()
+	defer broker.Unsubscribe(sub)
+
+	// Read until the client goes away, handling pongs to keep the connection alive
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	agentNames := map[primitive.ObjectID]string{}
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if event.Type != events.RunCreated || event.Run == nil || (tenantDatabase != "" && event.Database != tenantDatabase) || event.Org != org {
+				continue
+			}
+
+			name, ok := agentNames[event.Run.AgentID]
+			if !ok {
+				agent, err := agentRepo.GetAgentByID(r.Context(), event.Run.AgentID)
+				if err == nil {
+					name = agent.Name
+					agentNames[event.Run.AgentID] = name
+				} else if project != "" {
+					// The agent is not in the project, or can not be checked to be
+					continue
+				}
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(ActivityMessage{
+				Type:     event.Type,
+				Activity: db.NewActivityData(event.Run, name),
+			}); err != nil {
+				return
+			}
+		}
+	}
+}
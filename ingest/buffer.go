@@ -0,0 +1,441 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultBufferBatchSize is the number of buffered runs that triggers a write unless configured otherwise
+	DefaultBufferBatchSize = 500
+	// DefaultBufferMaxRuns is the number of runs the buffer holds at most unless configured otherwise
+	DefaultBufferMaxRuns = 50000
+
+	// walSuffix ends the names of the segments of the write-ahead log
+	walSuffix = ".wal"
+	// maxWALRunSize bounds the size of the runs read from the write-ahead log, larger sizes are corrupt, as the
+	// request bodies runs are sent in are smaller
+	maxWALRunSize = 16 << 20
+)
+
+var (
+	// ErrBufferFull is returned when the buffer holds as many runs as it may, as when the store is unavailable
+	ErrBufferFull = errors.New("run buffer full, retry later")
+	// ErrBufferClosed is returned for runs added once the buffer is closed
+	ErrBufferClosed = errors.New("run buffer closed")
+)
+
+// RunBufferConfig configures a run buffer
+type RunBufferConfig struct {
+	// Dir holds the segments of the write-ahead log, created when missing
+	Dir string
+	// BatchSize is the number of buffered runs that triggers a write
+	BatchSize int
+	// FlushInterval is how long runs are buffered at most before they are written, while the store is available
+	FlushInterval time.Duration
+	// MaxRuns bounds the runs held in memory, and in the write-ahead log
+	MaxRuns int
+}
+
+// walSegment is a file of the write-ahead log, holding the runs added while it was the current segment
+type walSegment struct {
+	path string
+	file *os.File
+	// size is the size of the runs appended to the segment
+	size int64
+	// closed is set once the segment was synced and closed, guarded by the sync lock of the buffer
+	closed bool
+}
+
+// RunBuffer accumulates the runs written one at a time and writes them in batches, once BatchSize runs are
+// buffered or after FlushInterval, so that bursts of single-run requests are not written one insert each. Runs are
+// appended to a write-ahead log and synced to disk before they are accepted, and the runs of a log left by a crash
+// are written when the buffer is opened again, so that accepted runs are stored at least once. Runs are written
+// with the Processor: those already stored for their run ID are skipped, and those that can never be stored are
+// logged and dropped.
+type RunBuffer struct {
+	processor *Processor
+	cfg       RunBufferConfig
+	// flushNow is signalled when a batch is full
+	flushNow chan struct{}
+	// flushMu serializes flushes
+	flushMu sync.Mutex
+	// syncMu serializes the syncs of the log with the rotation of its current segment
+	syncMu sync.Mutex
+
+	mu      sync.Mutex
+	pending []*models.AgentRun
+	current *walSegment
+	// sealed are the previous segments holding pending runs, removed once their runs are written
+	sealed   []*walSegment
+	sequence int64
+	closed   bool
+}
+
+// NewRunBuffer opens a run buffer writing runs with processor, loading the runs of the write-ahead log left in the
+// directory of the configuration, which are written by the first flush
+func NewRunBuffer(processor *Processor, cfg RunBufferConfig) (*RunBuffer, error) {
+	if cfg.BatchSize <= 0 || cfg.FlushInterval <= 0 || cfg.MaxRuns < cfg.BatchSize {
+		return nil, fmt.Errorf("invalid run buffer, the batch size and flush interval must be positive and the maximum number of runs at least the batch size")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create the run buffer directory: %w", err)
+	}
+
+	b := &RunBuffer{
+		processor: processor,
+		cfg:       cfg,
+		flushNow:  make(chan struct{}, 1),
+	}
+	if err := b.replay(); err != nil {
+		return nil, err
+	}
+	if len(b.pending) > 0 {
+		log.Printf("Loaded %d buffered runs from the write-ahead log in %s", len(b.pending), cfg.Dir)
+	}
+
+	current, err := b.openSegment()
+	if err != nil {
+		return nil, err
+	}
+	b.current = current
+	return b, nil
+}
+
+// Add buffers a run, returning once it is synced to the write-ahead log. The run should be prepared, with its
+// version checked, since runs that can not be stored are only logged when they are written. The run is given its ID
+// when it has none, and keeps it when it is stored.
+func (b *RunBuffer) Add(run *models.AgentRun) error {
+	if run.ID.IsZero() {
+		run.ID = primitive.NewObjectID()
+	}
+	data, err := bson.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBufferClosed
+	}
+	if len(b.pending) >= b.cfg.MaxRuns {
+		b.mu.Unlock()
+		return ErrBufferFull
+	}
+	segment := b.current
+	if _, err := segment.file.Write(data); err != nil {
+		// A run appended in part would hide the runs appended after it when the log is read
+		segment.file.Truncate(segment.size)
+		b.mu.Unlock()
+		return fmt.Errorf("unable to append the run to the write-ahead log: %w", err)
+	}
+	segment.size += int64(len(data))
+	b.pending = append(b.pending, run)
+	full := len(b.pending) >= b.cfg.BatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.flushNow <- struct{}{}:
+		default:
+		}
+	}
+	return b.sync(segment)
+}
+
+// sync syncs a segment of the log to disk. Concurrent runs wait for the sync in progress, which usually covers
+// theirs as well. A closed segment was synced when it was closed.
+func (b *RunBuffer) sync(segment *walSegment) error {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+	if segment.closed {
+		return nil
+	}
+	if err := segment.file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync the write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Run writes the buffered runs every flush interval, and whenever a batch is full, until the context is cancelled.
+// The runs still buffered then are written by Close.
+func (b *RunBuffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.flushNow:
+		}
+		b.flush(ctx)
+	}
+}
+
+// Close stops accepting runs and writes those still buffered. The runs that could not be written stay in the
+// write-ahead log, and are written when the buffer is opened again.
+func (b *RunBuffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	b.flush(ctx)
+
+	// The current segment is left open when no runs were buffered, or the flush could not rotate it
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current.closed {
+		return nil
+	}
+	b.current.closed = true
+	if err := b.current.file.Close(); err != nil {
+		return err
+	}
+	if b.current.size == 0 {
+		return os.Remove(b.current.path)
+	}
+	return nil
+}
+
+// flush writes the buffered runs in batches. On a transient failure, the runs not written yet are kept for the next
+// flush, in a segment of the log replacing those holding the runs, so that the batches already written are not
+// written again when the log is replayed.
+func (b *RunBuffer) flush(ctx context.Context) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	runs, segments, err := b.rotate()
+	if err != nil {
+		log.Printf("Failed to rotate the write-ahead log of the run buffer: %v", err)
+		return
+	}
+	if len(runs) == 0 {
+		b.remove(segments)
+		return
+	}
+
+	for start := 0; start < len(runs); start += b.cfg.BatchSize {
+		end := min(start+b.cfg.BatchSize, len(runs))
+		batch := runs[start:end]
+		// The runs were prepared, and redacted, before they were buffered
+		rejected, err := b.processor.write(ctx, batch, db.BatchOptions{Unordered: true, Redacted: true})
+		for i, rejectErr := range rejected {
+			log.Printf("Skipping buffered run of agent %s version %s: %v", batch[i].AgentID.Hex(), batch[i].Version, rejectErr)
+		}
+		if err != nil {
+			// The runs of the batch that were stored or rejected are not kept, only those that failed
+			left := runs[start:end:end]
+			var writeErr *WriteError
+			if errors.As(err, &writeErr) {
+				left = make([]*models.AgentRun, 0, len(writeErr.Failed)+len(runs)-end)
+				for _, i := range writeErr.Failed {
+					left = append(left, batch[i])
+				}
+			}
+			left = append(left, runs[end:]...)
+			log.Printf("Failed to write %d buffered runs, retrying on the next flush: %v", len(left), err)
+			if len(left) < len(runs) {
+				segments = b.replaceSegments(segments, left)
+			}
+			b.mu.Lock()
+			b.pending = append(left, b.pending...)
+			b.sealed = append(segments, b.sealed...)
+			b.mu.Unlock()
+			return
+		}
+	}
+	b.remove(segments)
+}
+
+// rotate takes the buffered runs, and the segments of the log holding them, the current one included, which is
+// replaced by a new segment unless the buffer is closed. Nothing is rotated while no runs are buffered.
+func (b *RunBuffer) rotate() ([]*models.AgentRun, []*walSegment, error) {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 && len(b.sealed) == 0 {
+		return nil, nil, nil
+	}
+
+	segments := b.sealed
+	if !b.current.closed {
+		if err := b.current.file.Sync(); err != nil {
+			return nil, nil, err
+		}
+		b.current.file.Close()
+		b.current.closed = true
+		segments = append(segments, b.current)
+	}
+	if !b.closed {
+		next, err := b.openSegment()
+		if err != nil {
+			b.sealed = segments
+			return nil, nil, err
+		}
+		b.current = next
+	}
+
+	runs := b.pending
+	b.pending = nil
+	b.sealed = nil
+	return runs, segments, nil
+}
+
+// replaceSegments writes the runs left to write to a new segment of the log, removing the segments holding them. The
+// segments are kept, and returned, when the new segment can not be written.
+func (b *RunBuffer) replaceSegments(segments []*walSegment, runs []*models.AgentRun) []*walSegment {
+	b.mu.Lock()
+	segment, err := b.openSegment()
+	b.mu.Unlock()
+	if err == nil {
+		err = writeSegment(segment, runs)
+	}
+	if err != nil {
+		log.Printf("Failed to replace the write-ahead log segments of the runs left to write, runs written are written again if it is replayed: %v", err)
+		if segment != nil {
+			os.Remove(segment.path)
+		}
+		return segments
+	}
+	b.remove(segments)
+	return []*walSegment{segment}
+}
+
+// writeSegment writes runs to a new segment of the log, then syncs and closes it
+func writeSegment(segment *walSegment, runs []*models.AgentRun) error {
+	defer func() {
+		segment.file.Close()
+		segment.closed = true
+	}()
+	writer := bufio.NewWriter(segment.file)
+	for _, run := range runs {
+		data, err := bson.Marshal(run)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		segment.size += int64(len(data))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return segment.file.Sync()
+}
+
+// remove removes segments of the log whose runs were written
+func (b *RunBuffer) remove(segments []*walSegment) {
+	for _, segment := range segments {
+		if err := os.Remove(segment.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to remove the write-ahead log segment %s: %v", segment.path, err)
+		}
+	}
+}
+
+// openSegment creates the next segment of the log
+func (b *RunBuffer) openSegment() (*walSegment, error) {
+	b.sequence++
+	path := filepath.Join(b.cfg.Dir, fmt.Sprintf("runs-%016d%s", b.sequence, walSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the write-ahead log segment %s: %w", path, err)
+	}
+	return &walSegment{path: path, file: file}, nil
+}
+
+// replay loads the runs of the segments of the log left in the directory, in the order they were written. A run
+// cut short by a crash while it was appended was never accepted, and is skipped.
+func (b *RunBuffer) replay() error {
+	entries, err := os.ReadDir(b.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("unable to read the run buffer directory: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), walSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(b.cfg.Dir, name)
+		runs, err := readSegment(path)
+		if err != nil {
+			return fmt.Errorf("unable to read the write-ahead log segment %s: %w", path, err)
+		}
+		b.pending = append(b.pending, runs...)
+		b.sealed = append(b.sealed, &walSegment{path: path, closed: true})
+
+		var sequence int64
+		if _, err := fmt.Sscanf(name, "runs-%d"+walSuffix, &sequence); err == nil && sequence > b.sequence {
+			b.sequence = sequence
+		}
+	}
+	return nil
+}
+
+// readSegment reads the runs of a segment of the log, BSON documents written one after the other
+func readSegment(path string) ([]*models.AgentRun, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	runs := []*models.AgentRun{}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return runs, nil
+			}
+			return nil, err
+		}
+		size := int(binary.LittleEndian.Uint32(header[:]))
+		if size < len(header) || size > maxWALRunSize {
+			log.Printf("Skipping the corrupt end of the write-ahead log segment %s", path)
+			return runs, nil
+		}
+		doc := make([]byte, size)
+		copy(doc, header[:])
+		if _, err := io.ReadFull(reader, doc[len(header):]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return runs, nil
+			}
+			return nil, err
+		}
+
+		var run models.AgentRun
+		if err := bson.Unmarshal(doc, &run); err != nil {
+			log.Printf("Skipping the corrupt end of the write-ahead log segment %s: %v", path, err)
+			return runs, nil
+		}
+		runs = append(runs, &run)
+	}
+}
@@ -0,0 +1,262 @@
+package ingest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"ripple/models"
+)
+
+// ImportData is the content of an import: agents with their versions and the runs of each version
+type ImportData struct {
+	Agents []*ImportAgent
+}
+
+// ImportAgent is an agent of an import
+type ImportAgent struct {
+	Location string
+	Name     string
+	Project  string
+	Versions []*ImportVersion
+}
+
+// ImportVersion is an agent version of an import
+type ImportVersion struct {
+	Location string
+	models.RegisterAgentVersionRequest
+	Runs []ImportRun
+}
+
+// ImportRun is a run of an import
+type ImportRun struct {
+	Location string
+	models.RegisterAgentRunRequest
+}
+
+// importJSON is the JSON import format
+type importJSON struct {
+	Agents []struct {
+		Name     string `json:"name"`
+		Project  string `json:"project"`
+		Versions []struct {
+			models.RegisterAgentVersionRequest
+			Runs []json.RawMessage `json:"runs"`
+		} `json:"versions"`
+	} `json:"agents"`
+}
+
+// importCSVColumns are the columns of the CSV import format, agent, version, created and status are required
+var importCSVColumns = []string{
+	"agent", "project", "version", "cluster", "deployment", "created", "status", "time_taken", "initiator", "tools",
+	"cost", "models", "run_id", "task_id", "trace_id", "span_id", "error_type", "error_message", "prompt_tokens",
+	"completion_tokens",
+}
+
+// DecodeImportJSON parses a JSON import. Runs use the same payload as the runs API and are decoded with their
+// schema_version, or version 1. Invalid runs are reported as item errors; the returned error is set when the
+// document itself can not be parsed.
+func DecodeImportJSON(data []byte, schemas *RunSchemaRegistry) (*ImportData, []models.ImportItemError, error) {
+	var doc importJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	result := &ImportData{}
+	var itemErrors []models.ImportItemError
+	for i, a := range doc.Agents {
+		agent := &ImportAgent{
+			Location: fmt.Sprintf("agents[%d]", i),
+			Name:     a.Name,
+			Project:  a.Project,
+		}
+		for j, v := range a.Versions {
+			version := &ImportVersion{
+				Location:                    fmt.Sprintf("%s.versions[%d]", agent.Location, j),
+				RegisterAgentVersionRequest: v.RegisterAgentVersionRequest,
+			}
+			for k, raw := range v.Runs {
+				location := fmt.Sprintf("%s.runs[%d]", version.Location, k)
+
+				schemaVersion := DefaultRunSchemaVersion
+				var envelope PayloadEnvelope
+				if err := json.Unmarshal(raw, &envelope); err == nil && envelope.SchemaVersion != nil {
+					schemaVersion = *envelope.SchemaVersion
+				}
+
+				req, _, err := schemas.Decode(schemaVersion, raw)
+				if err != nil {
+					itemErrors = append(itemErrors, models.ImportItemError{Location: location, Message: err.Error()})
+					continue
+				}
+				version.Runs = append(version.Runs, ImportRun{Location: location, RegisterAgentRunRequest: req})
+			}
+			agent.Versions = append(agent.Versions, version)
+		}
+		result.Agents = append(result.Agents, agent)
+	}
+
+	return result, itemErrors, nil
+}
+
+// DecodeImportCSV parses a CSV import with a header row, one run per row. Rows are grouped by agent and version,
+// the project, cluster and deployment of an agent or version are taken from its first row. Lists are separated
+// with semicolons. Invalid rows are reported as item errors; the returned error is set when the header is invalid.
+func DecodeImportCSV(r io.Reader) (*ImportData, []models.ImportItemError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil, errors.New("missing header row")
+		}
+		return nil, nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "id" {
+			name = "run_id"
+		}
+		columns[name] = i
+	}
+	for _, required := range []string{"agent", "version", "created", "status"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("missing required column %q, columns are %s", required, strings.Join(importCSVColumns, ", "))
+		}
+	}
+
+	result := &ImportData{}
+	agents := map[string]*ImportAgent{}
+	versions := map[string]*ImportVersion{}
+	var itemErrors []models.ImportItemError
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				itemErrors = append(itemErrors, models.ImportItemError{Location: fmt.Sprintf("line %d", parseErr.Line), Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		location := fmt.Sprintf("line %d", line)
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		run := ImportRun{
+			Location: location,
+			RegisterAgentRunRequest: models.RegisterAgentRunRequest{
+				Created:       field("created"),
+				Status:        field("status"),
+				Initiator:     field("initiator"),
+				Tools:         splitList(field("tools")),
+				Models:        splitList(field("models")),
+				TraceID:       field("trace_id"),
+				SpanID:        field("span_id"),
+				ErrorType:     field("error_type"),
+				ErrorMessage:  field("error_message"),
+				SchemaVersion: DefaultRunSchemaVersion,
+			},
+		}
+		if err := parseCSVNumbers(&run.RegisterAgentRunRequest, field); err != nil {
+			itemErrors = append(itemErrors, models.ImportItemError{Location: location, Message: err.Error()})
+			continue
+		}
+
+		name := field("agent")
+		agent, ok := agents[name]
+		if !ok {
+			agent = &ImportAgent{Location: location, Name: name, Project: field("project")}
+			agents[name] = agent
+			result.Agents = append(result.Agents, agent)
+		}
+
+		versionKey := name + "\x00" + field("version")
+		version, ok := versions[versionKey]
+		if !ok {
+			version = &ImportVersion{
+				Location: location,
+				RegisterAgentVersionRequest: models.RegisterAgentVersionRequest{
+					Version:    field("version"),
+					Cluster:    field("cluster"),
+					Deployment: field("deployment"),
+				},
+			}
+			versions[versionKey] = version
+			agent.Versions = append(agent.Versions, version)
+		}
+		version.Runs = append(version.Runs, run)
+	}
+
+	return result, itemErrors, nil
+}
+
+// parseCSVNumbers parses the numeric columns of a CSV import row, leaving empty cells at zero
+func parseCSVNumbers(req *models.RegisterAgentRunRequest, field func(name string) string) error {
+	var err error
+	if v := field("time_taken"); v != "" {
+		seconds, err := models.ParseSeconds(v)
+		if err != nil {
+			return fmt.Errorf("invalid time_taken %q", v)
+		}
+		req.TimeTaken = models.Seconds(seconds)
+	}
+	if v := field("cost"); v != "" {
+		if req.Cost, err = strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid cost %q", v)
+		}
+	}
+	if v := field("run_id"); v != "" {
+		if req.RunID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid run_id %q", v)
+		}
+	}
+	if v := field("task_id"); v != "" {
+		if req.TaskID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid task_id %q", v)
+		}
+	}
+	if v := field("prompt_tokens"); v != "" {
+		if req.PromptTokens, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid prompt_tokens %q", v)
+		}
+	}
+	if v := field("completion_tokens"); v != "" {
+		if req.CompletionTokens, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid completion_tokens %q", v)
+		}
+	}
+	return nil
+}
+
+// splitList splits a semicolon separated list, returning nil for an empty cell
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	var values []string
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
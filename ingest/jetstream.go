@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"ripple/models"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	initialRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+// JetStreamConfig configures the NATS JetStream run consumer
+type JetStreamConfig struct {
+	URL          string
+	Stream       string
+	Subject      string
+	Consumer     string
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
+// JetStreamConsumer ingests run events published on a NATS JetStream subject. Messages are acknowledged only after
+// their run is stored, and runs whose run ID is already stored are acknowledged without being written again, so
+// redelivered messages are not recorded twice.
+type JetStreamConsumer struct {
+	processor *Processor
+	cfg       JetStreamConfig
+}
+
+// NewJetStreamConsumer creates a new JetStream consumer
+func NewJetStreamConsumer(processor *Processor, cfg JetStreamConfig) *JetStreamConsumer {
+	return &JetStreamConsumer{
+		processor: processor,
+		cfg:       cfg,
+	}
+}
+
+// Run consumes run events until the context is cancelled. The stream is created when it does not exist yet.
+func (c *JetStreamConsumer) Run(ctx context.Context) error {
+	nc, err := nats.Connect(c.cfg.URL, nats.Name("ripple-server"), nats.MaxReconnects(-1))
+	if err != nil {
+		return fmt.Errorf("unable to connect to NATS: %w", err)
+	}
+	defer nc.Drain()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return err
+	}
+
+	stream, err := js.Stream(ctx, c.cfg.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		stream, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     c.cfg.Stream,
+			Subjects: []string{c.cfg.Subject},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open stream %s: %w", c.cfg.Stream, err)
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       c.cfg.Consumer,
+		FilterSubject: c.cfg.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxAckPending: c.cfg.BatchSize * 2,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create consumer %s: %w", c.cfg.Consumer, err)
+	}
+
+	log.Printf("Consuming run events from NATS subject %s as consumer %s", c.cfg.Subject, c.cfg.Consumer)
+	delay := initialRetryDelay
+	for ctx.Err() == nil {
+		batch, err := consumer.Fetch(c.cfg.BatchSize, jetstream.FetchMaxWait(c.cfg.BatchTimeout))
+		if err != nil {
+			log.Printf("Failed to fetch NATS messages: %v", err)
+			sleep(ctx, delay)
+			continue
+		}
+
+		var msgs []jetstream.Msg
+		for msg := range batch.Messages() {
+			msgs = append(msgs, msg)
+		}
+		if err := batch.Error(); err != nil {
+			log.Printf("Failed to fetch NATS messages: %v", err)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+
+		if err := c.processBatch(ctx, msgs, delay); err != nil {
+			log.Printf("Failed to store runs, retrying in %s: %v", delay, err)
+			sleep(ctx, delay)
+			delay = min(delay*2, maxRetryDelay)
+			continue
+		}
+		delay = initialRetryDelay
+	}
+
+	return nil
+}
+
+// processBatch stores the runs of a batch of messages and acknowledges them. Invalid messages are terminated so they
+// are not redelivered; on a transient failure the messages not yet stored are redelivered after retryDelay.
+func (c *JetStreamConsumer) processBatch(ctx context.Context, msgs []jetstream.Msg, retryDelay time.Duration) error {
+	runs := make([]*models.AgentRun, 0, len(msgs))
+	pending := make([]jetstream.Msg, 0, len(msgs))
+	for _, msg := range msgs {
+		run, err := c.processor.Decode(ctx, msg.Data())
+		if err != nil {
+			if !errors.Is(err, ErrInvalidMessage) {
+				nakAll(msgs, retryDelay)
+				return err
+			}
+			log.Printf("Skipping NATS message on %s: %v", msg.Subject(), err)
+			terminate(msg)
+			continue
+		}
+		runs = append(runs, run)
+		pending = append(pending, msg)
+	}
+
+	duplicates, err := c.processor.Duplicates(ctx, runs)
+	if err != nil {
+		nakAll(pending, retryDelay)
+		return err
+	}
+
+	fresh := make([]*models.AgentRun, 0, len(runs))
+	freshMsgs := make([]jetstream.Msg, 0, len(runs))
+	for i, run := range runs {
+		if duplicates[i] {
+			ack(pending[i])
+			continue
+		}
+		fresh = append(fresh, run)
+		freshMsgs = append(freshMsgs, pending[i])
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	rejected, err := c.processor.Write(ctx, fresh)
+	var writeErr *WriteError
+	if err != nil && !errors.As(err, &writeErr) {
+		nakAll(freshMsgs, retryDelay)
+		return err
+	}
+
+	// Only the messages of the runs that failed are redelivered, the others were stored or rejected
+	failed := map[int]bool{}
+	if writeErr != nil {
+		for _, i := range writeErr.Failed {
+			failed[i] = true
+		}
+	}
+	for i, msg := range freshMsgs {
+		if failed[i] {
+			nakAll([]jetstream.Msg{msg}, retryDelay)
+			continue
+		}
+		if rejectErr, ok := rejected[i]; ok {
+			log.Printf("Skipping NATS message on %s: %v", msg.Subject(), rejectErr)
+			terminate(msg)
+			continue
+		}
+		ack(msg)
+	}
+
+	return err
+}
+
+// ack acknowledges a message. A failed acknowledgement only leads to a redelivery, which is deduplicated.
+func ack(msg jetstream.Msg) {
+	if err := msg.Ack(); err != nil {
+		log.Printf("Failed to acknowledge NATS message: %v", err)
+	}
+}
+
+// terminate stops the redelivery of a message that can never be stored
+func terminate(msg jetstream.Msg) {
+	if err := msg.Term(); err != nil {
+		log.Printf("Failed to terminate NATS message: %v", err)
+	}
+}
+
+// nakAll asks for the redelivery of messages after a delay
+func nakAll(msgs []jetstream.Msg, delay time.Duration) {
+	for _, msg := range msgs {
+		if err := msg.NakWithDelay(delay); err != nil {
+			log.Printf("Failed to reject NATS message: %v", err)
+		}
+	}
+}
+
+// sleep waits for the delay or until the context is cancelled
+func sleep(ctx context.Context, delay time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
@@ -0,0 +1,283 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidMessage is returned for messages that can never be ingested, e.g. malformed payloads or unknown agents.
+// Other errors are transient and the message should be retried.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// WriteError is returned by Processor.Write when some runs could not be stored and should be retried. The runs that
+// are not in Failed were stored or rejected, and are not retried.
+type WriteError struct {
+	// Failed holds the indexes of the runs to retry, in order
+	Failed []int
+	Err    error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("unable to store %d runs: %s", len(e.Failed), e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Message is a run event received from a message broker. The agent is identified by ID or by name,
+// and the run uses the same payload as the HTTP API.
+type Message struct {
+	AgentID       string          `json:"agent_id"`
+	Agent         string          `json:"agent"`
+	Version       string          `json:"version"`
+	SchemaVersion *int            `json:"schema_version"`
+	Run           json.RawMessage `json:"run"`
+}
+
+// Processor maps run events received from message brokers to agent runs and stores them
+type Processor struct {
+	agents  db.AgentStore
+	schemas *RunSchemaRegistry
+
+	mu       sync.Mutex
+	agentIDs map[string]primitive.ObjectID
+}
+
+// NewProcessor creates a new processor
+func NewProcessor(agents db.AgentStore, schemas *RunSchemaRegistry) *Processor {
+	return &Processor{
+		agents:   agents,
+		schemas:  schemas,
+		agentIDs: map[string]primitive.ObjectID{},
+	}
+}
+
+// Decode parses a message into an agent run
+func (p *Processor) Decode(ctx context.Context, data []byte) (*models.AgentRun, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	if msg.Version == "" {
+		return nil, fmt.Errorf("%w: missing version", ErrInvalidMessage)
+	}
+	if len(msg.Run) == 0 {
+		return nil, fmt.Errorf("%w: missing run", ErrInvalidMessage)
+	}
+
+	schemaVersion := DefaultRunSchemaVersion
+	if msg.SchemaVersion != nil {
+		schemaVersion = *msg.SchemaVersion
+	}
+	var runEnvelope PayloadEnvelope
+	if err := json.Unmarshal(msg.Run, &runEnvelope); err == nil && runEnvelope.SchemaVersion != nil {
+		schemaVersion = *runEnvelope.SchemaVersion
+	}
+
+	req, _, err := p.schemas.Decode(schemaVersion, msg.Run)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+
+	return p.NewRun(ctx, msg.AgentID, msg.Agent, msg.Version, req)
+}
+
+// NewRun builds the run of an agent version, identified by agent ID or else by agent name
+func (p *Processor) NewRun(ctx context.Context, agentID, agent, version string, req models.RegisterAgentRunRequest) (*models.AgentRun, error) {
+	id, err := p.resolveAgent(ctx, agentID, agent)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := NewAgentRun(id, version, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+
+	return run, nil
+}
+
+// Write stores runs, batching the runs of each agent. Runs that can never be stored (e.g. of an unknown version)
+// are returned by index in rejected. When storing some runs failed and should be retried, the other agents are still
+// written and a *WriteError holding the runs to retry is returned, so that the runs stored are not written again.
+func (p *Processor) Write(ctx context.Context, runs []*models.AgentRun) (rejected map[int]error, err error) {
+	return p.write(ctx, runs, db.BatchOptions{Unordered: true})
+}
+
+// write stores runs like Write, in unordered batches with opts
+func (p *Processor) write(ctx context.Context, runs []*models.AgentRun, opts db.BatchOptions) (rejected map[int]error, err error) {
+	rejected = map[int]error{}
+
+	var agents []primitive.ObjectID
+	byAgent := map[primitive.ObjectID][]int{}
+	for i, run := range runs {
+		if _, ok := byAgent[run.AgentID]; !ok {
+			agents = append(agents, run.AgentID)
+		}
+		byAgent[run.AgentID] = append(byAgent[run.AgentID], i)
+	}
+
+	var failed []int
+	var lastErr error
+	for _, agentID := range agents {
+		indexes := byAgent[agentID]
+		batch := make([]*models.AgentRun, len(indexes))
+		for j, i := range indexes {
+			batch[j] = runs[i]
+		}
+
+		// Runs delivered again are stored once, the others of their batch are still stored
+		err := p.agents.CreateAgentRunBatch(ctx, batch, opts)
+		if err == nil {
+			continue
+		}
+		var batchErr *db.BatchError
+		if errors.As(err, &batchErr) {
+			for _, item := range batchErr.Items {
+				switch {
+				case item.Conflict:
+					// Stored by an earlier delivery
+				case isPermanent(errors.New(item.Error)):
+					rejected[indexes[item.Index]] = fmt.Errorf("%w: %s", ErrInvalidMessage, item.Error)
+				default:
+					// Only the runs that failed are retried, the others of the batch were stored
+					failed = append(failed, indexes[item.Index])
+					lastErr = err
+				}
+			}
+			continue
+		}
+		if !isPermanent(err) {
+			failed = append(failed, indexes...)
+			lastErr = err
+			continue
+		}
+
+		// A run of the batch can not be stored, store the runs one by one to isolate it
+		for _, i := range indexes {
+			if err := p.createRun(ctx, runs[i], opts); err != nil {
+				if err.Error() == "run with this ID already exists" {
+					continue
+				}
+				if !isPermanent(err) {
+					failed = append(failed, i)
+					lastErr = err
+					continue
+				}
+				rejected[i] = fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Ints(failed)
+		return rejected, &WriteError{Failed: failed, Err: lastErr}
+	}
+	return rejected, nil
+}
+
+// createRun stores a single run, in a batch of its own when it was redacted already, since CreateAgentRun would redact
+// it again
+func (p *Processor) createRun(ctx context.Context, run *models.AgentRun, opts db.BatchOptions) error {
+	if !opts.Redacted {
+		return p.agents.CreateAgentRun(ctx, run)
+	}
+
+	err := p.agents.CreateAgentRunBatch(ctx, []*models.AgentRun{run}, db.BatchOptions{Redacted: true})
+	var batchErr *db.BatchError
+	if errors.As(err, &batchErr) && len(batchErr.Items) == 1 {
+		if batchErr.Items[0].Conflict {
+			return nil
+		}
+		return errors.New(batchErr.Items[0].Error)
+	}
+	return err
+}
+
+// Duplicates returns the indexes of runs whose run ID is already stored for their agent, or that repeat the run ID
+// of an earlier run of the batch. Runs without a run ID are never reported.
+func (p *Processor) Duplicates(ctx context.Context, runs []*models.AgentRun) (map[int]bool, error) {
+	duplicates := map[int]bool{}
+
+	byAgent := map[primitive.ObjectID][]int{}
+	for i, run := range runs {
+		if run.RunID != 0 {
+			byAgent[run.AgentID] = append(byAgent[run.AgentID], i)
+		}
+	}
+
+	for agentID, indexes := range byAgent {
+		runIDs := make([]int64, len(indexes))
+		for j, i := range indexes {
+			runIDs[j] = runs[i].RunID
+		}
+
+		existing, err := p.agents.GetExistingRunIDs(ctx, agentID, runIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[int64]bool, len(indexes))
+		for _, i := range indexes {
+			if existing[runs[i].RunID] || seen[runs[i].RunID] {
+				duplicates[i] = true
+			}
+			seen[runs[i].RunID] = true
+		}
+	}
+
+	return duplicates, nil
+}
+
+// resolveAgent returns the ID of an agent, looking agents up by name when no ID is given
+func (p *Processor) resolveAgent(ctx context.Context, agentID, agent string) (primitive.ObjectID, error) {
+	if agentID != "" {
+		id, err := primitive.ObjectIDFromHex(agentID)
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("%w: invalid agent ID format", ErrInvalidMessage)
+		}
+		return id, nil
+	}
+	if agent == "" {
+		return primitive.NilObjectID, fmt.Errorf("%w: missing agent or agent_id", ErrInvalidMessage)
+	}
+
+	p.mu.Lock()
+	id, ok := p.agentIDs[agent]
+	p.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	found, err := p.agents.GetAgentByName(ctx, agent)
+	if err != nil {
+		if isPermanent(err) {
+			return primitive.NilObjectID, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+		}
+		return primitive.NilObjectID, err
+	}
+
+	p.mu.Lock()
+	p.agentIDs[agent] = found.ID
+	p.mu.Unlock()
+	return found.ID, nil
+}
+
+// isPermanent reports whether a store error will occur again when retried
+func isPermanent(err error) bool {
+	switch err.Error() {
+	case "agent not found", "version not found for this agent", "run failed the schema validation of the database":
+		return true
+	}
+	return false
+}
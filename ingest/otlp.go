@@ -0,0 +1,361 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"ripple/models"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Span and resource attributes read from OpenTelemetry traces. The first attribute of each list that is set wins,
+// span attributes take precedence over resource attributes.
+var (
+	otlpAgentNameAttributes       = []string{"ripple.agent.name", "gen_ai.agent.name", "service.name"}
+	otlpVersionAttributes         = []string{"ripple.agent.version", "gen_ai.agent.version", "service.version"}
+	otlpCostAttributes            = []string{"ripple.cost", "gen_ai.usage.cost"}
+	otlpPromptTokenAttributes     = []string{"gen_ai.usage.input_tokens", "gen_ai.usage.prompt_tokens"}
+	otlpCompletionTokenAttributes = []string{"gen_ai.usage.output_tokens", "gen_ai.usage.completion_tokens"}
+	otlpInitiatorAttributes       = []string{"ripple.initiator", "enduser.id"}
+	otlpRunIDAttributes           = []string{"ripple.run_id"}
+	otlpTaskIDAttributes          = []string{"ripple.task_id"}
+	otlpErrorTypeAttributes       = []string{"ripple.error_type", "error.type"}
+	otlpToolAttributes            = []string{"gen_ai.tool.name"}
+	otlpModelAttributes           = []string{"gen_ai.response.model", "gen_ai.request.model"}
+)
+
+// OTLPTrace is an agent run translated from the spans of a trace. The run is set when the root span of the trace was
+// received, the steps hold the other spans of the trace.
+type OTLPTrace struct {
+	TraceID string
+	Agent   string
+	Version string
+	Run     *models.AgentRun
+	Steps   []*models.RunStep
+}
+
+// DecodeOTLPTraces parses an OTLP/HTTP trace export request in the protobuf or JSON encoding
+func DecodeOTLPTraces(body []byte, isJSON bool) (*tracepb.TracesData, error) {
+	// An export request holds the same repeated resource spans field as TracesData
+	traces := &tracepb.TracesData{}
+	if !isJSON {
+		if err := proto.Unmarshal(body, traces); err != nil {
+			return nil, err
+		}
+		return traces, nil
+	}
+
+	// OTLP/JSON encodes trace and span IDs as hex instead of the base64 used for bytes by the protobuf JSON mapping
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	if err := hexIDsToBase64(doc); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, traces); err != nil {
+		return nil, err
+	}
+	return traces, nil
+}
+
+// OTLPExportResponse encodes an OTLP/HTTP trace export response, reporting the spans that were rejected
+func OTLPExportResponse(rejected int64, message string, isJSON bool) []byte {
+	if isJSON {
+		resp := map[string]interface{}{}
+		if rejected > 0 || message != "" {
+			resp["partialSuccess"] = map[string]interface{}{
+				"rejectedSpans": strconv.FormatInt(rejected, 10),
+				"errorMessage":  message,
+			}
+		}
+		data, _ := json.Marshal(resp)
+		return data
+	}
+
+	if rejected == 0 && message == "" {
+		return []byte{}
+	}
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, uint64(rejected))
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, message)
+
+	resp := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(resp, partial)
+}
+
+// OTLPTraces translates spans into agent runs. Root spans become runs, all other spans become steps of the run of
+// their trace. Steps are returned even when the root span of their trace is not part of the export.
+func OTLPTraces(traces *tracepb.TracesData) []*OTLPTrace {
+	byTraceID := map[string]*OTLPTrace{}
+	var order []string
+
+	for _, resourceSpans := range traces.GetResourceSpans() {
+		resource := resourceSpans.GetResource().GetAttributes()
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			for _, span := range scopeSpans.GetSpans() {
+				traceID := hex.EncodeToString(span.GetTraceId())
+				trace, ok := byTraceID[traceID]
+				if !ok {
+					trace = &OTLPTrace{TraceID: traceID}
+					byTraceID[traceID] = trace
+					order = append(order, traceID)
+				}
+
+				if len(span.GetParentSpanId()) == 0 {
+					trace.Agent = otlpString(span.GetAttributes(), resource, otlpAgentNameAttributes)
+					trace.Version = otlpString(span.GetAttributes(), resource, otlpVersionAttributes)
+					trace.Run = otlpRun(span, resource)
+					continue
+				}
+				trace.Steps = append(trace.Steps, otlpStep(span))
+			}
+		}
+	}
+
+	result := make([]*OTLPTrace, 0, len(order))
+	for _, traceID := range order {
+		trace := byTraceID[traceID]
+		sort.SliceStable(trace.Steps, func(i, j int) bool {
+			return trace.Steps[i].Started.Before(trace.Steps[j].Started)
+		})
+		if trace.Run != nil {
+			summarizeSteps(trace.Run, trace.Steps)
+		}
+		result = append(result, trace)
+	}
+
+	return result
+}
+
+// otlpRun builds an agent run from a root span. The agent and version are resolved by the caller.
+func otlpRun(span *tracepb.Span, resource []*commonpb.KeyValue) *models.AgentRun {
+	attributes := span.GetAttributes()
+	runID, _ := strconv.ParseInt(otlpString(attributes, resource, otlpRunIDAttributes), 10, 64)
+	taskID, _ := strconv.ParseInt(otlpString(attributes, resource, otlpTaskIDAttributes), 10, 64)
+
+	run := &models.AgentRun{
+		Created:          time.Unix(0, int64(span.GetStartTimeUnixNano())).UTC(),
+		Status:           otlpStatus(span),
+		TimeTaken:        otlpDuration(span),
+		Initiator:        otlpString(attributes, resource, otlpInitiatorAttributes),
+		Cost:             otlpFloat(attributes, resource, otlpCostAttributes),
+		PromptTokens:     otlpInt(attributes, resource, otlpPromptTokenAttributes),
+		CompletionTokens: otlpInt(attributes, resource, otlpCompletionTokenAttributes),
+		RunID:            runID,
+		TaskID:           taskID,
+		TraceID:          hex.EncodeToString(span.GetTraceId()),
+		SpanID:           hex.EncodeToString(span.GetSpanId()),
+	}
+	if run.Status != models.RunStatusCompleted {
+		run.ErrorType = otlpString(attributes, resource, otlpErrorTypeAttributes)
+		run.ErrorMessage = span.GetStatus().GetMessage()
+	}
+	return run
+}
+
+// otlpStep builds a run step from a child span
+func otlpStep(span *tracepb.Span) *models.RunStep {
+	attributes := span.GetAttributes()
+	step := &models.RunStep{
+		TraceID:          hex.EncodeToString(span.GetTraceId()),
+		SpanID:           hex.EncodeToString(span.GetSpanId()),
+		ParentSpanID:     hex.EncodeToString(span.GetParentSpanId()),
+		Name:             span.GetName(),
+		Kind:             models.StepKindSpan,
+		Started:          time.Unix(0, int64(span.GetStartTimeUnixNano())).UTC(),
+		TimeTaken:        otlpDuration(span),
+		Status:           otlpStatus(span),
+		Tool:             otlpString(attributes, nil, otlpToolAttributes),
+		Model:            otlpString(attributes, nil, otlpModelAttributes),
+		Cost:             otlpFloat(attributes, nil, otlpCostAttributes),
+		PromptTokens:     otlpInt(attributes, nil, otlpPromptTokenAttributes),
+		CompletionTokens: otlpInt(attributes, nil, otlpCompletionTokenAttributes),
+	}
+
+	if step.Tool != "" {
+		step.Kind = models.StepKindTool
+	} else if step.Model != "" {
+		step.Kind = models.StepKindModel
+	}
+
+	if len(attributes) > 0 {
+		step.Attributes = make(map[string]string, len(attributes))
+		for _, kv := range attributes {
+			step.Attributes[kv.GetKey()] = anyValueString(kv.GetValue())
+		}
+	}
+
+	return step
+}
+
+// summarizeSteps fills in the tools and models used by a run, and its cost and tokens when the root span has none
+func summarizeSteps(run *models.AgentRun, steps []*models.RunStep) {
+	tools, modelNames := map[string]bool{}, map[string]bool{}
+	var cost float64
+	var promptTokens, completionTokens int64
+	for _, step := range steps {
+		if step.Tool != "" && !tools[step.Tool] {
+			tools[step.Tool] = true
+			run.Tools = append(run.Tools, step.Tool)
+		}
+		if step.Model != "" && !modelNames[step.Model] {
+			modelNames[step.Model] = true
+			run.Models = append(run.Models, step.Model)
+		}
+		cost += step.Cost
+		promptTokens += step.PromptTokens
+		completionTokens += step.CompletionTokens
+	}
+
+	if run.Cost == 0 {
+		run.Cost = cost
+	}
+	if run.PromptTokens == 0 && run.CompletionTokens == 0 {
+		run.PromptTokens, run.CompletionTokens = promptTokens, completionTokens
+	}
+}
+
+// otlpStatus maps a span status to a run status
+func otlpStatus(span *tracepb.Span) string {
+	if span.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+		return "error"
+	}
+	return "completed"
+}
+
+// otlpDuration returns the duration of a span in seconds
+func otlpDuration(span *tracepb.Span) float64 {
+	if span.GetEndTimeUnixNano() < span.GetStartTimeUnixNano() {
+		return 0
+	}
+	return time.Duration(span.GetEndTimeUnixNano() - span.GetStartTimeUnixNano()).Seconds()
+}
+
+// otlpString returns the first of the given attributes set on the span or its resource
+func otlpString(attributes, resource []*commonpb.KeyValue, keys []string) string {
+	for _, set := range [][]*commonpb.KeyValue{attributes, resource} {
+		for _, key := range keys {
+			for _, kv := range set {
+				if kv.GetKey() == key {
+					return anyValueString(kv.GetValue())
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// otlpFloat returns the first of the given numeric attributes set on the span or its resource
+func otlpFloat(attributes, resource []*commonpb.KeyValue, keys []string) float64 {
+	for _, set := range [][]*commonpb.KeyValue{attributes, resource} {
+		for _, key := range keys {
+			for _, kv := range set {
+				if kv.GetKey() != key {
+					continue
+				}
+				switch v := kv.GetValue().GetValue().(type) {
+				case *commonpb.AnyValue_DoubleValue:
+					return v.DoubleValue
+				case *commonpb.AnyValue_IntValue:
+					return float64(v.IntValue)
+				case *commonpb.AnyValue_StringValue:
+					f, _ := strconv.ParseFloat(v.StringValue, 64)
+					return f
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// otlpInt returns the first of the given integer attributes set on the span or its resource
+func otlpInt(attributes, resource []*commonpb.KeyValue, keys []string) int64 {
+	for _, set := range [][]*commonpb.KeyValue{attributes, resource} {
+		for _, key := range keys {
+			for _, kv := range set {
+				if kv.GetKey() != key {
+					continue
+				}
+				switch v := kv.GetValue().GetValue().(type) {
+				case *commonpb.AnyValue_IntValue:
+					return v.IntValue
+				case *commonpb.AnyValue_DoubleValue:
+					return int64(v.DoubleValue)
+				case *commonpb.AnyValue_StringValue:
+					n, _ := strconv.ParseInt(v.StringValue, 10, 64)
+					return n
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// anyValueString formats an attribute value as a string
+func anyValueString(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return hex.EncodeToString(v.BytesValue)
+	case nil:
+		return ""
+	default:
+		data, _ := protojson.Marshal(value)
+		return string(data)
+	}
+}
+
+// hexIDsToBase64 rewrites the hex encoded trace and span IDs of an OTLP/JSON document to base64
+func hexIDsToBase64(node interface{}) error {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			switch key {
+			case "traceId", "spanId", "parentSpanId", "trace_id", "span_id", "parent_span_id":
+				if s, ok := value.(string); ok {
+					id, err := hex.DecodeString(s)
+					if err != nil {
+						return fmt.Errorf("invalid %s %q: %w", key, s, err)
+					}
+					v[key] = base64.StdEncoding.EncodeToString(id)
+					continue
+				}
+			}
+			if err := hexIDsToBase64(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if err := hexIDsToBase64(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ripple/models"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Metrics understood by the Prometheus remote-write endpoint. All of them need agent and version labels, the runs
+// counter may also carry a status label (default: completed).
+const (
+	PromRunsTotal        = "ripple_agent_runs_total"
+	PromRunDurationSum   = "ripple_agent_run_duration_seconds_sum"
+	PromRunDurationCount = "ripple_agent_run_duration_seconds_count"
+	PromRunCostTotal     = "ripple_agent_run_cost_total"
+)
+
+const (
+	promMetricNameLabel  = "__name__"
+	promAgentLabel       = "agent"
+	promVersionLabel     = "version"
+	promStatusLabel      = "status"
+	promDefaultRunStatus = "completed"
+	promInitiator        = "prometheus"
+
+	// maxPromRunsPerSample caps the runs created from a single counter increase
+	maxPromRunsPerSample = 10000
+)
+
+// PromSeries is a time series received over Prometheus remote-write
+type PromSeries struct {
+	Labels  map[string]string
+	Samples []PromSample
+}
+
+// PromSample is a sample of a time series, the timestamp is in milliseconds
+type PromSample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// PromRuns are the runs of an agent version derived from the counters of a remote-write request
+type PromRuns struct {
+	Agent   string
+	Version string
+	Runs    []*models.AgentRun
+}
+
+// DecodeRemoteWrite parses a snappy compressed Prometheus remote-write 1.0 request. A positive maxSize caps the
+// decompressed size of the request.
+func DecodeRemoteWrite(body []byte, maxSize int64) ([]PromSeries, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	if maxSize > 0 && int64(size) > maxSize {
+		return nil, &http.MaxBytesError{Limit: maxSize}
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+
+	// WriteRequest: repeated TimeSeries timeseries = 1
+	var series []PromSeries
+	err = eachField(data, func(num protowire.Number, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+		s, err := decodeTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		series = append(series, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// PromSeriesKey identifies a series by its sorted labels
+func PromSeriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + labels[name]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// IsPromRunSeries reports whether a series is one of the run counters, with the labels needed to map it to runs
+func IsPromRunSeries(s PromSeries) bool {
+	switch s.Labels[promMetricNameLabel] {
+	case PromRunsTotal, PromRunDurationSum, PromRunDurationCount, PromRunCostTotal:
+		return s.Labels[promAgentLabel] != "" && s.Labels[promVersionLabel] != ""
+	}
+	return false
+}
+
+// PromRunsFromSeries turns the increase of the run counters since their last known values into runs. Each new run
+// gets the average duration and cost of the runs counted in the same request. The first sample of a series only
+// sets its baseline. The returned values are the new last values of the series, to be passed to the next call.
+func PromRunsFromSeries(series []PromSeries, last map[string]float64) ([]*PromRuns, map[string]float64) {
+	type statusCount struct {
+		status    string
+		count     int
+		timestamp int64
+	}
+	type versionTotals struct {
+		agent, version string
+		counts         []statusCount
+		durationSum    float64
+		durationCount  float64
+		cost           float64
+	}
+
+	values := map[string]float64{}
+	totals := map[string]*versionTotals{}
+	var order []string
+
+	for _, s := range series {
+		if !IsPromRunSeries(s) {
+			continue
+		}
+
+		agent, version := s.Labels[promAgentLabel], s.Labels[promVersionLabel]
+		groupKey := agent + "\x00" + version
+		group, ok := totals[groupKey]
+		if !ok {
+			group = &versionTotals{agent: agent, version: version}
+			totals[groupKey] = group
+			order = append(order, groupKey)
+		}
+
+		samples := append([]PromSample{}, s.Samples...)
+		sort.Slice(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+
+		key := PromSeriesKey(s.Labels)
+		prev, seen := last[key]
+		if v, ok := values[key]; ok {
+			prev, seen = v, true
+		}
+		for _, sample := range samples {
+			if math.IsNaN(sample.Value) {
+				continue
+			}
+			if !seen {
+				prev, seen = sample.Value, true
+				continue
+			}
+
+			// A counter lower than before was reset, it counted from zero since
+			delta := sample.Value - prev
+			if delta < 0 {
+				delta = sample.Value
+			}
+			prev = sample.Value
+
+			switch s.Labels[promMetricNameLabel] {
+			case PromRunsTotal:
+				status := s.Labels[promStatusLabel]
+				if status == "" {
+					status = promDefaultRunStatus
+				}
+				if count := int(math.Round(delta)); count > 0 {
+					group.counts = append(group.counts, statusCount{status, min(count, maxPromRunsPerSample), sample.Timestamp})
+				}
+			case PromRunDurationSum:
+				group.durationSum += delta
+			case PromRunDurationCount:
+				group.durationCount += delta
+			case PromRunCostTotal:
+				group.cost += delta
+			}
+		}
+		if seen {
+			values[key] = prev
+		}
+	}
+
+	var result []*PromRuns
+	for _, groupKey := range order {
+		group := totals[groupKey]
+
+		var runCount int
+		for _, c := range group.counts {
+			runCount += c.count
+		}
+		if runCount == 0 {
+			continue
+		}
+
+		var timeTaken, cost float64
+		if group.durationCount > 0 {
+			timeTaken = group.durationSum / group.durationCount
+		}
+		cost = group.cost / float64(runCount)
+
+		runs := &PromRuns{Agent: group.agent, Version: group.version}
+		for _, c := range group.counts {
+			for i := 0; i < c.count; i++ {
+				runs.Runs = append(runs.Runs, &models.AgentRun{
+					Version:   group.version,
+					Created:   time.UnixMilli(c.timestamp).UTC(),
+					Status:    c.status,
+					TimeTaken: timeTaken,
+					Initiator: promInitiator,
+					Cost:      cost,
+				})
+			}
+		}
+		result = append(result, runs)
+	}
+
+	return result, values
+}
+
+// decodeTimeSeries parses a TimeSeries message: repeated Label labels = 1, repeated Sample samples = 2
+func decodeTimeSeries(data []byte) (PromSeries, error) {
+	s := PromSeries{Labels: map[string]string{}}
+	err := eachField(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			name, labelValue, err := decodeLabel(value)
+			if err != nil {
+				return err
+			}
+			s.Labels[name] = labelValue
+		case 2:
+			sample, err := decodeSample(value)
+			if err != nil {
+				return err
+			}
+			s.Samples = append(s.Samples, sample)
+		}
+		return nil
+	})
+	return s, err
+}
+
+// decodeLabel parses a Label message: string name = 1, string value = 2
+func decodeLabel(data []byte) (name, value string, err error) {
+	err = eachField(data, func(num protowire.Number, field []byte) error {
+		switch num {
+		case 1:
+			name = string(field)
+		case 2:
+			value = string(field)
+		}
+		return nil
+	})
+	return name, value, err
+}
+
+// decodeSample parses a Sample message: double value = 1, int64 timestamp = 2
+func decodeSample(data []byte) (PromSample, error) {
+	var sample PromSample
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return sample, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return sample, protowire.ParseError(n)
+			}
+			sample.Value = math.Float64frombits(v)
+			data = data[n:]
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return sample, protowire.ParseError(n)
+			}
+			sample.Timestamp = int64(v)
+			data = data[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return sample, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+	return sample, nil
+}
+
+// eachField calls fn with the length delimited fields of a protobuf message, skipping fields of other types
+func eachField(data []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			if err := fn(num, value); err != nil {
+				return err
+			}
+			data = data[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewAgentRun builds an agent run from a run registration request
+func NewAgentRun(agentID primitive.ObjectID, version string, req models.RegisterAgentRunRequest) (*models.AgentRun, error) {
+	// Parse the created time
+	createdTime := time.Now()
+	if req.Created != "" {
+		if parsed, err := time.Parse(time.RFC3339, req.Created); err == nil {
+			createdTime = parsed
+		}
+	}
+
+	traceID, spanID := req.TraceID, req.SpanID
+	if req.TraceParent != "" {
+		var err error
+		traceID, spanID, err = ParseTraceParent(req.TraceParent)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Explicit IDs are held to the format of the IDs of traceparent values, they are placed in trace links
+		if traceID != "" && !traceContextID(traceID, 32) {
+			return nil, errors.New("trace_id must be 32 lowercase hex characters, not all zeros")
+		}
+		if spanID != "" && !traceContextID(spanID, 16) {
+			return nil, errors.New("span_id must be 16 lowercase hex characters, not all zeros")
+		}
+	}
+
+	return &models.AgentRun{
+		AgentID:          agentID,
+		Version:          version,
+		Created:          createdTime,
+		Status:           req.Status,
+		TimeTaken:        float64(req.TimeTaken),
+		Initiator:        req.Initiator,
+		Tools:            req.Tools,
+		Cost:             req.Cost,
+		Models:           req.Models,
+		RunID:            req.RunID,
+		TaskID:           req.TaskID,
+		TraceID:          traceID,
+		SpanID:           spanID,
+		ErrorType:        strings.TrimSpace(req.ErrorType),
+		ErrorMessage:     req.ErrorMessage,
+		PromptTokens:     req.PromptTokens,
+		CompletionTokens: req.CompletionTokens,
+		SchemaVersion:    req.SchemaVersion,
+	}, nil
+}
+
+// ParseTraceParent extracts the trace and parent span IDs from a W3C traceparent value
+// (https://www.w3.org/TR/trace-context/#traceparent-header)
+func ParseTraceParent(traceParent string) (traceID, spanID string, err error) {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", errors.New("invalid traceparent format")
+	}
+
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", errors.New("invalid traceparent version")
+	}
+
+	for _, p := range parts[:4] {
+		if !lowerHex(p) {
+			return "", "", errors.New("traceparent must be lowercase hex")
+		}
+	}
+
+	if !traceContextID(parts[1], 32) || !traceContextID(parts[2], 16) {
+		return "", "", errors.New("traceparent trace and span IDs must not be all zeros")
+	}
+
+	return parts[1], parts[2], nil
+}
+
+// traceContextID reports whether id is a trace or span ID of the W3C trace context: length lowercase hex
+// characters, not all zeros
+func traceContextID(id string, length int) bool {
+	return len(id) == length && lowerHex(id) && id != strings.Repeat("0", length)
+}
+
+// lowerHex reports whether s is made of lowercase hex characters
+func lowerHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
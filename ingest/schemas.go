@@ -0,0 +1,203 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"ripple/models"
+)
+
+// Run payload schema versions
+const (
+	// RunSchemaV1 is the original run payload, used when no schema_version is sent. It is not deprecated while it is
+	// the default, so that the clients sending no schema_version are not warned.
+	RunSchemaV1 = 1
+	// RunSchemaV2 renames the run ID to run_id and rejects unknown fields
+	RunSchemaV2 = 2
+
+	// DefaultRunSchemaVersion is assumed for payloads without a schema_version
+	DefaultRunSchemaVersion = RunSchemaV1
+)
+
+// RunSchema describes a supported version of the run ingest payload
+type RunSchema struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Deprecated  bool   `json:"deprecated"`
+	Received    int64  `json:"received"`
+
+	decode func(data []byte) (models.RegisterAgentRunRequest, error)
+}
+
+// RunSchemaRegistry holds the supported run payload versions and counts the payloads received for each
+type RunSchemaRegistry struct {
+	mu      sync.Mutex
+	schemas map[int]*RunSchema
+	warned  map[int]bool
+}
+
+// RunSchemaList represents the response listing the supported run payload versions
+type RunSchemaList struct {
+	Default  int         `json:"default"`
+	Latest   int         `json:"latest"`
+	Versions []RunSchema `json:"versions"`
+}
+
+// NewRunSchemaRegistry creates a registry with all supported run payload versions
+func NewRunSchemaRegistry() *RunSchemaRegistry {
+	s := &RunSchemaRegistry{
+		schemas: map[int]*RunSchema{},
+		warned:  map[int]bool{},
+	}
+
+	s.Register(RunSchema{
+		Version:     RunSchemaV1,
+		Description: "Original run payload, with the run ID sent as id",
+		decode:      decodeRunV1,
+	})
+	s.Register(RunSchema{
+		Version:     RunSchemaV2,
+		Description: "Run ID sent as run_id, unknown fields are rejected",
+		decode:      decodeRunV2,
+	})
+
+	return s
+}
+
+// Register adds a run payload version to the registry
+func (s *RunSchemaRegistry) Register(schema RunSchema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schemas[schema.Version] = &schema
+}
+
+// Decode decodes a run payload with the decoder of the given schema version
+func (s *RunSchemaRegistry) Decode(version int, data []byte) (models.RegisterAgentRunRequest, *RunSchema, error) {
+	s.mu.Lock()
+	schema, ok := s.schemas[version]
+	if ok {
+		schema.Received++
+		if schema.Deprecated && !s.warned[version] {
+			s.warned[version] = true
+			log.Printf("Received run payload with deprecated schema_version %d, latest is %d", version, s.latest())
+		}
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return models.RegisterAgentRunRequest{}, nil, fmt.Errorf("unsupported schema_version %d", version)
+	}
+
+	req, err := schema.decode(data)
+	if err != nil {
+		return req, schema, err
+	}
+	req.SchemaVersion = version
+
+	return req, schema, nil
+}
+
+// List returns the supported run payload versions with the number of payloads received for each
+func (s *RunSchemaRegistry) List() RunSchemaList {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := RunSchemaList{
+		Default:  DefaultRunSchemaVersion,
+		Latest:   s.latest(),
+		Versions: []RunSchema{},
+	}
+	for _, schema := range s.schemas {
+		list.Versions = append(list.Versions, *schema)
+	}
+	sort.Slice(list.Versions, func(i, j int) bool {
+		return list.Versions[i].Version < list.Versions[j].Version
+	})
+
+	return list
+}
+
+// Latest returns the highest registered schema version
+func (s *RunSchemaRegistry) Latest() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest()
+}
+
+// latest returns the highest registered schema version. The caller must hold the lock.
+func (s *RunSchemaRegistry) latest() int {
+	latest := 0
+	for version := range s.schemas {
+		if version > latest {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// decodeRunV1 decodes the original run payload
+func decodeRunV1(data []byte) (models.RegisterAgentRunRequest, error) {
+	var req models.RegisterAgentRunRequest
+	err := json.Unmarshal(data, &req)
+	return req, err
+}
+
+// runPayloadV2 is the version 2 run payload
+type runPayloadV2 struct {
+	SchemaVersion    int            `json:"schema_version"`
+	Created          string         `json:"created"`
+	Status           string         `json:"status"`
+	TimeTaken        models.Seconds `json:"time_taken"`
+	Initiator        string         `json:"initiator"`
+	Tools            []string       `json:"tools"`
+	Cost             float64        `json:"cost"`
+	Models           []string       `json:"models"`
+	RunID            int64          `json:"run_id"`
+	TaskID           int64          `json:"task_id"`
+	TraceParent      string         `json:"traceparent"`
+	TraceID          string         `json:"trace_id"`
+	SpanID           string         `json:"span_id"`
+	ErrorType        string         `json:"error_type"`
+	ErrorMessage     string         `json:"error_message"`
+	PromptTokens     int64          `json:"prompt_tokens"`
+	CompletionTokens int64          `json:"completion_tokens"`
+}
+
+// decodeRunV2 decodes a version 2 run payload
+func decodeRunV2(data []byte) (models.RegisterAgentRunRequest, error) {
+	var payload runPayloadV2
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&payload); err != nil {
+		return models.RegisterAgentRunRequest{}, err
+	}
+
+	return models.RegisterAgentRunRequest{
+		Created:          payload.Created,
+		Status:           payload.Status,
+		TimeTaken:        payload.TimeTaken,
+		Initiator:        payload.Initiator,
+		Tools:            payload.Tools,
+		Cost:             payload.Cost,
+		Models:           payload.Models,
+		RunID:            payload.RunID,
+		TaskID:           payload.TaskID,
+		TraceParent:      payload.TraceParent,
+		TraceID:          payload.TraceID,
+		SpanID:           payload.SpanID,
+		ErrorType:        payload.ErrorType,
+		ErrorMessage:     payload.ErrorMessage,
+		PromptTokens:     payload.PromptTokens,
+		CompletionTokens: payload.CompletionTokens,
+	}, nil
+}
+
+// PayloadEnvelope holds the fields shared by single run and batch payloads
+type PayloadEnvelope struct {
+	SchemaVersion *int              `json:"schema_version"`
+	Runs          []json.RawMessage `json:"runs"`
+}
@@ -0,0 +1,241 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ripple/models"
+)
+
+const (
+	// StatsDRunMetric is the counter recorded as runs, e.g. ripple.run:1|c|#agent:chat-agent,version:1.2.0
+	StatsDRunMetric = "ripple.run"
+
+	// maxStatsDRunsPerLine caps the runs recorded from a single counter line
+	maxStatsDRunsPerLine = 1000
+	// maxStatsDBuffered caps the runs waiting to be written, the oldest are dropped when the store is unavailable
+	maxStatsDBuffered = 100000
+	// maxStatsDPacketSize is the largest UDP payload
+	maxStatsDPacketSize = 65535
+)
+
+// errNotRunMetric is returned for statsd lines of other metrics, which are ignored
+var errNotRunMetric = errors.New("not a run metric")
+
+// StatsDListener records runs from statsd counter lines received over UDP, written in batches
+type StatsDListener struct {
+	processor     *Processor
+	addr          string
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	pending []*models.AgentRun
+}
+
+// NewStatsDListener creates a new statsd listener
+func NewStatsDListener(processor *Processor, addr string, flushInterval time.Duration) *StatsDListener {
+	return &StatsDListener{
+		processor:     processor,
+		addr:          addr,
+		flushInterval: flushInterval,
+	}
+}
+
+// Run receives statsd lines until the context is cancelled, then writes the runs still pending
+func (l *StatsDListener) Run(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", l.addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", l.addr, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go l.flushLoop(ctx)
+
+	log.Printf("Receiving statsd run metrics on %s", conn.LocalAddr())
+	buf := make([]byte, maxStatsDPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The pending runs are still written on shutdown
+				l.flush(context.WithoutCancel(ctx))
+				return nil
+			}
+			log.Printf("Failed to read statsd packet: %v", err)
+			continue
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			runs, err := l.decodeLine(ctx, line)
+			if err != nil {
+				if !errors.Is(err, errNotRunMetric) {
+					log.Printf("Skipping statsd line %q: %v", line, err)
+				}
+				continue
+			}
+			l.add(runs)
+		}
+	}
+}
+
+// decodeLine parses a statsd line into runs
+func (l *StatsDListener) decodeLine(ctx context.Context, line string) ([]*models.AgentRun, error) {
+	metric, count, tags, err := ParseStatsDLine(line)
+	if metric != StatsDRunMetric {
+		return nil, errNotRunMetric
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	if tags["agent"] == "" || tags["version"] == "" {
+		return nil, fmt.Errorf("%w: agent and version tags are required", ErrInvalidMessage)
+	}
+
+	req := models.RegisterAgentRunRequest{
+		Status:    tags["status"],
+		Initiator: tags["initiator"],
+	}
+	if req.Status == "" {
+		req.Status = "completed"
+	}
+	if v, ok := tags["time_taken"]; ok {
+		seconds, err := models.ParseSeconds(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid time_taken tag %q", ErrInvalidMessage, v)
+		}
+		req.TimeTaken = models.Seconds(seconds)
+	}
+	if v, ok := tags["cost"]; ok {
+		if req.Cost, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%w: invalid cost tag %q", ErrInvalidMessage, v)
+		}
+	}
+
+	runs := make([]*models.AgentRun, 0, count)
+	for i := 0; i < count; i++ {
+		run, err := l.processor.NewRun(ctx, "", tags["agent"], tags["version"], req)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// add queues runs for the next flush, dropping the oldest runs when the buffer is full
+func (l *StatsDListener) add(runs []*models.AgentRun) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pending = append(l.pending, runs...)
+	if dropped := len(l.pending) - maxStatsDBuffered; dropped > 0 {
+		log.Printf("Dropping %d statsd runs, the run buffer is full", dropped)
+		l.pending = append([]*models.AgentRun{}, l.pending[dropped:]...)
+	}
+}
+
+// flushLoop writes the pending runs every flush interval
+func (l *StatsDListener) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.flush(ctx)
+		}
+	}
+}
+
+// flush writes the pending runs. On a transient failure the runs that failed are kept for the next flush.
+func (l *StatsDListener) flush(ctx context.Context) {
+	l.mu.Lock()
+	runs := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	if len(runs) == 0 {
+		return
+	}
+
+	rejected, err := l.processor.Write(ctx, runs)
+	for i, rejectErr := range rejected {
+		log.Printf("Skipping statsd run of agent %s version %s: %v", runs[i].AgentID.Hex(), runs[i].Version, rejectErr)
+	}
+	if err != nil {
+		// Only the runs that failed are kept, the others were stored or rejected
+		var writeErr *WriteError
+		if errors.As(err, &writeErr) {
+			failed := make([]*models.AgentRun, len(writeErr.Failed))
+			for j, i := range writeErr.Failed {
+				failed[j] = runs[i]
+			}
+			runs = failed
+		}
+		log.Printf("Failed to write %d statsd runs, retrying on the next flush: %v", len(runs), err)
+		l.mu.Lock()
+		l.pending = append(runs, l.pending...)
+		l.mu.Unlock()
+	}
+}
+
+// ParseStatsDLine parses a statsd counter line of the form name:value|c[|@rate][|#tag:value,...] into the metric
+// name, the count scaled by the sample rate, and the tags
+func ParseStatsDLine(line string) (metric string, count int, tags map[string]string, err error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return "", 0, nil, errors.New("missing metric name")
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return name, 0, nil, errors.New("missing metric type")
+	}
+	if parts[1] != "c" {
+		return name, 0, nil, fmt.Errorf("unsupported metric type %q, expected a counter", parts[1])
+	}
+
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || value < 0 {
+		return name, 0, nil, fmt.Errorf("invalid value %q", parts[0])
+	}
+
+	rate := 1.0
+	tags = map[string]string{}
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err = strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return name, 0, nil, fmt.Errorf("invalid sample rate %q", part)
+			}
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Split(part[1:], ",") {
+				key, val, _ := strings.Cut(tag, ":")
+				tags[key] = val
+			}
+		}
+	}
+
+	count = int(math.Round(value / rate))
+	return name, min(count, maxStatsDRunsPerLine), tags, nil
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -9,15 +12,45 @@ import (
 // Agent represents an agent in the system
 type Agent struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID     primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
 	Name      string             `json:"name" bson:"name"`
 	Project   string             `json:"project" bson:"project"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+
+	// Team is the name of the team owning the agent, and Owner the team itself in agent responses
+	Team  string `json:"team,omitempty" bson:"team,omitempty"`
+	Owner *Team  `json:"owner,omitempty" bson:"-"`
+
+	// Archived agents are left out of agent lists, dashboard statistics and metrics aggregation
+	Archived   bool       `json:"archived" bson:"archived"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+
+	// IngestToken is the token writing the runs of the agent, only set in the registration response
+	IngestToken string `json:"ingest_token,omitempty" bson:"-"`
+
+	// SigningSecret signs the run requests of the agent. It is only returned as IssuedSigningSecret, in the
+	// registration and signing secret rotation responses.
+	SigningSecret       string `json:"-" bson:"signing_secret,omitempty"`
+	IssuedSigningSecret string `json:"signing_secret,omitempty" bson:"-"`
+}
+
+// SigningSecretPrefix starts every agent signing secret
+const SigningSecretPrefix = "rss_"
+
+// NewSigningSecret generates a secret signing the run requests of an agent
+func NewSigningSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return SigningSecretPrefix + hex.EncodeToString(secret), nil
 }
 
 // AgentVersion represents a specific version of an agent
 type AgentVersion struct {
 	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID      primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
 	AgentID    primitive.ObjectID `json:"agent_id" bson:"agent_id"`
 	Version    string             `json:"version" bson:"version"`
 	Cluster    string             `json:"cluster" bson:"cluster"`
@@ -27,11 +60,15 @@ type AgentVersion struct {
 	Deployment string             `json:"deployment" bson:"deployment"`
 	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+
+	// IngestToken is the token writing the runs of the version, only set in the registration response
+	IngestToken string `json:"ingest_token,omitempty" bson:"-"`
 }
 
 // AgentRun represents a single run of an agent version
 type AgentRun struct {
 	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID      primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
 	AgentID    primitive.ObjectID `json:"agent_id" bson:"agent_id"`
 	VersionID  primitive.ObjectID `json:"version_id" bson:"version_id"`
 	Version    string             `json:"version" bson:"version"`
@@ -42,9 +79,94 @@ type AgentRun struct {
 	Tools      []string           `json:"tools" bson:"tools"`
 	Cost       float64            `json:"cost" bson:"cost"`
 	Models     []string           `json:"models" bson:"models"`
-	RunID      int64              `json:"id" bson:"run_id"`
+	RunID      int64              `json:"run_id" bson:"run_id"`
 	TaskID     int64              `json:"task_id" bson:"task_id"`
 	RecordedAt time.Time          `json:"recorded_at" bson:"recorded_at"`
+	TraceID    string             `json:"trace_id,omitempty" bson:"trace_id,omitempty"`
+	SpanID     string             `json:"span_id,omitempty" bson:"span_id,omitempty"`
+	TraceLinks map[string]string  `json:"trace_links,omitempty" bson:"-"`
+
+	// ErrorType categorizes the failure of a run, such as rate_limit or tool_error, and ErrorMessage describes it
+	ErrorType    string `json:"error_type,omitempty" bson:"error_type,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty" bson:"error_message,omitempty"`
+
+	// PromptTokens and CompletionTokens are the tokens the models of the run consumed and produced
+	PromptTokens     int64 `json:"prompt_tokens,omitempty" bson:"prompt_tokens,omitempty"`
+	CompletionTokens int64 `json:"completion_tokens,omitempty" bson:"completion_tokens,omitempty"`
+
+	// SchemaVersion is the version of the ingest payload the run was recorded with
+	SchemaVersion int `json:"schema_version,omitempty" bson:"schema_version,omitempty"`
+}
+
+// agentRun has the fields of AgentRun without its JSON methods
+type agentRun AgentRun
+
+// agentRunJSON is the JSON rendering of a run: its run ID is rendered under id, as it is sent when the run is
+// registered, alongside run_id, and shadows the ID of its document
+type agentRunJSON struct {
+	ID int64 `json:"id"`
+	*agentRun
+}
+
+// MarshalJSON renders the run as agentRunJSON
+func (r AgentRun) MarshalJSON() ([]byte, error) {
+	return json.Marshal(agentRunJSON{ID: r.RunID, agentRun: (*agentRun)(&r)})
+}
+
+// UnmarshalJSON decodes a run rendered by MarshalJSON, its run ID being read from run_id
+func (r *AgentRun) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &agentRunJSON{agentRun: (*agentRun)(r)})
+}
+
+// FieldAliases returns the JSON fields of runs rendered from another of their fields, by the name of that field, for
+// the fields and sort query parameters
+func (r AgentRun) FieldAliases() map[string]string {
+	return map[string]string{"id": "run_id"}
+}
+
+// ErrorCategory returns the category errors of the run are counted under: its error type, or its status when the
+// run has no error type
+func (r *AgentRun) ErrorCategory() string {
+	if r.ErrorType != "" {
+		return r.ErrorType
+	}
+	return r.Status
+}
+
+// AgentRunDetail is a run with its agent, its agent version and the steps recorded for its trace
+type AgentRunDetail struct {
+	AgentRun
+	Agent        *Agent        `json:"agent"`
+	AgentVersion *AgentVersion `json:"agent_version"`
+	Steps        []RunStep     `json:"steps"`
+}
+
+// agentRunDetailJSON is the JSON rendering of a run detail, which would otherwise be that of its run alone
+type agentRunDetailJSON struct {
+	agentRunJSON
+	Agent        *Agent        `json:"agent"`
+	AgentVersion *AgentVersion `json:"agent_version"`
+	Steps        []RunStep     `json:"steps"`
+}
+
+// MarshalJSON renders the run detail as agentRunDetailJSON
+func (d AgentRunDetail) MarshalJSON() ([]byte, error) {
+	return json.Marshal(agentRunDetailJSON{
+		agentRunJSON: agentRunJSON{ID: d.RunID, agentRun: (*agentRun)(&d.AgentRun)},
+		Agent:        d.Agent,
+		AgentVersion: d.AgentVersion,
+		Steps:        d.Steps,
+	})
+}
+
+// UnmarshalJSON decodes a run detail rendered by MarshalJSON
+func (d *AgentRunDetail) UnmarshalJSON(data []byte) error {
+	detail := agentRunDetailJSON{agentRunJSON: agentRunJSON{agentRun: (*agentRun)(&d.AgentRun)}}
+	if err := json.Unmarshal(data, &detail); err != nil {
+		return err
+	}
+	d.Agent, d.AgentVersion, d.Steps = detail.Agent, detail.AgentVersion, detail.Steps
+	return nil
 }
 
 // Request and Response types
@@ -53,6 +175,7 @@ type AgentRun struct {
 type RegisterAgentRequest struct {
 	Name    string `json:"name"`
 	Project string `json:"project"`
+	Team    string `json:"team"`
 }
 
 // RegisterAgentVersionRequest represents the request to register a new agent version
@@ -68,16 +191,61 @@ type RegisterAgentVersionRequest struct {
 type RegisterAgentRunRequest struct {
 	Created   string   `json:"created"`
 	Status    string   `json:"status"`
-	TimeTaken float64  `json:"time_taken"`
+	TimeTaken Seconds  `json:"time_taken"`
 	Initiator string   `json:"initiator"`
 	Tools     []string `json:"tools"`
 	Cost      float64  `json:"cost"`
 	Models    []string `json:"models"`
 	RunID     int64    `json:"id"`
 	TaskID    int64    `json:"task_id"`
+
+	// Trace correlation, either as a W3C traceparent or as explicit trace and span IDs
+	TraceParent string `json:"traceparent"`
+	TraceID     string `json:"trace_id"`
+	SpanID      string `json:"span_id"`
+
+	ErrorType    string `json:"error_type"`
+	ErrorMessage string `json:"error_message"`
+
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+
+	// SchemaVersion is the payload schema version, set by the decoder that parsed the request
+	SchemaVersion int `json:"schema_version"`
 }
 
 // RegisterAgentRunBatchRequest represents a batch request to register multiple agent runs
 type RegisterAgentRunBatchRequest struct {
 	Runs []RegisterAgentRunRequest `json:"runs"`
 }
+
+// ImportReport summarizes an import of agents, versions and runs. In a dry run the counts are what the import
+// would create.
+type ImportReport struct {
+	DryRun     bool              `json:"dry_run"`
+	Agents     ImportCounts      `json:"agents"`
+	Versions   ImportCounts      `json:"versions"`
+	Runs       ImportRunCounts   `json:"runs"`
+	ErrorCount int               `json:"error_count"`
+	Errors     []ImportItemError `json:"errors"`
+}
+
+// ImportCounts counts the agents or versions of an import that are new and that already exist
+type ImportCounts struct {
+	Created  int `json:"created"`
+	Existing int `json:"existing"`
+}
+
+// ImportRunCounts counts the runs of an import that are imported and that are skipped because their run ID is
+// already stored for the agent
+type ImportRunCounts struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+}
+
+// ImportItemError is a validation error of an item of an import, located by its path in a JSON import or its
+// line in a CSV import
+type ImportItemError struct {
+	Location string `json:"location"`
+	Message  string `json:"message"`
+}
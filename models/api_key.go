@@ -0,0 +1,126 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from other bearer credentials
+const APIKeyPrefix = "rak_"
+
+// API key scopes. Admin keys have the permissions of write keys, and write keys those of read keys. runs:write only
+// writes runs, and is not implied by the other scopes. versions:write only registers agent versions and
+// deployments:write only posts deployment events, both are implied by write.
+const (
+	ScopeRead             = "read"
+	ScopeWrite            = "write"
+	ScopeAdmin            = "admin"
+	ScopeRunsWrite        = "runs:write"
+	ScopeVersionsWrite    = "versions:write"
+	ScopeDeploymentsWrite = "deployments:write"
+)
+
+// Scopes lists the API key scopes
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeAdmin, ScopeRunsWrite, ScopeVersionsWrite, ScopeDeploymentsWrite}
+
+// impliedScopes are the scopes granted along with a scope
+var impliedScopes = map[string][]string{
+	ScopeAdmin: {ScopeWrite, ScopeRead, ScopeVersionsWrite, ScopeDeploymentsWrite},
+	ScopeWrite: {ScopeRead, ScopeVersionsWrite, ScopeDeploymentsWrite},
+}
+
+// APIKey authenticates a client with the permissions of its scopes. Only the SHA-256 hash of the key is stored, the
+// key itself is returned once, when it is created.
+type APIKey struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID     primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	Hash      string             `json:"-" bson:"hash"`
+	Scopes    []string           `json:"scopes" bson:"scopes"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+
+	// Project restricts the key to the agents of a project and their data, when set
+	Project string `json:"project,omitempty" bson:"project,omitempty"`
+
+	// ServiceAccountID is the service account the key belongs to, whose scopes and project the key has. Disabled is
+	// set while the account is disabled.
+	ServiceAccountID *primitive.ObjectID `json:"service_account_id,omitempty" bson:"service_account_id,omitempty"`
+	Disabled         bool                `json:"disabled,omitempty" bson:"disabled,omitempty"`
+
+	// LastUsedAt is when the key last authenticated a request, recorded at most once per APIKeyLastUsedResolution
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+
+	// RotatedAt is when the key was last rotated. The key it replaced, of hash PreviousHash, stays valid until
+	// PreviousExpiresAt.
+	RotatedAt         *time.Time `json:"rotated_at,omitempty" bson:"rotated_at,omitempty"`
+	PreviousHash      string     `json:"-" bson:"previous_hash,omitempty"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty" bson:"previous_expires_at,omitempty"`
+
+	// Key is the API key, only set in the creation and rotation responses
+	Key string `json:"key,omitempty" bson:"-"`
+}
+
+// APIKeyLastUsedResolution is how often the last use of an API key is recorded, so that a busy client does not
+// write on every request
+const APIKeyLastUsedResolution = time.Minute
+
+// CreateAPIKeyRequest represents the request to create an API key
+type CreateAPIKeyRequest struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Project string   `json:"project,omitempty"`
+}
+
+// RotateAPIKeyRequest represents the request to rotate an API key. The replaced key stays valid for GracePeriod, a
+// duration such as 1h or 7d.
+type RotateAPIKeyRequest struct {
+	GracePeriod string `json:"grace_period"`
+}
+
+// APIKeyScopes lists the scopes of an API key and the scopes they grant
+type APIKeyScopes struct {
+	ID              primitive.ObjectID `json:"id"`
+	Name            string             `json:"name"`
+	Scopes          []string           `json:"scopes"`
+	EffectiveScopes []string           `json:"effective_scopes"`
+}
+
+// NewAPIKey generates an API key with scopes and returns it with the record to store
+func NewAPIKey(name string, scopes []string) (string, *APIKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	key := APIKeyPrefix + hex.EncodeToString(secret)
+	return key, &APIKey{Name: name, Hash: HashAPIKey(key), Scopes: scopes}, nil
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// EffectiveScopes returns the scopes of the key and the scopes they imply, in the order of Scopes
+func (k *APIKey) EffectiveScopes() []string {
+	granted := map[string]bool{}
+	for _, scope := range k.Scopes {
+		granted[scope] = true
+		for _, implied := range impliedScopes[scope] {
+			granted[implied] = true
+		}
+	}
+
+	effective := []string{}
+	for _, scope := range Scopes {
+		if granted[scope] {
+			effective = append(effective, scope)
+		}
+	}
+	return effective
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Restore job statuses
+const (
+	RestoreStatusPending   = "pending"
+	RestoreStatusRunning   = "running"
+	RestoreStatusCompleted = "completed"
+	RestoreStatusFailed    = "failed"
+)
+
+// DefaultRestoreKeepDays is how long restored runs are kept when the restore request does not say
+const DefaultRestoreKeepDays = 7
+
+// RunArchive is a file of runs moved from the database to cold storage. The runs were created in [From, To].
+type RunArchive struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Key       string             `json:"key" bson:"key"`
+	From      time.Time          `json:"from" bson:"from"`
+	To        time.Time          `json:"to" bson:"to"`
+	Runs      int64              `json:"runs" bson:"runs"`
+	Bytes     int64              `json:"bytes" bson:"bytes"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// RestoreJob tracks the restore of the archived runs of a time range into the database, where they are kept until
+// KeepUntil
+type RestoreJob struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	From        time.Time          `json:"from" bson:"from"`
+	To          time.Time          `json:"to" bson:"to"`
+	KeepUntil   time.Time          `json:"keep_until" bson:"keep_until"`
+	Archives    int64              `json:"archives" bson:"archives"`
+	Runs        int64              `json:"runs" bson:"runs"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	StartedAt   *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// RestoreRequest represents the request to restore the archived runs of a time range. The range includes From and
+// excludes To. KeepDays defaults to DefaultRestoreKeepDays.
+type RestoreRequest struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	KeepDays int       `json:"keep_days"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Artifact storages
+const (
+	ArtifactStorageGridFS = "gridfs"
+	ArtifactStorageS3     = "s3"
+)
+
+// Artifact is a file attached to a run, such as a trace, a transcript or a file the agent generated. Its content is
+// stored in GridFS or in a bucket under Key, apart from the run, so that large payloads do not bloat the runs.
+type Artifact struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AgentID     primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	RunID       int64              `json:"run_id" bson:"run_id"`
+	Name        string             `json:"name" bson:"name"`
+	ContentType string             `json:"content_type" bson:"content_type"`
+	Size        int64              `json:"size" bson:"size"`
+	SHA256      string             `json:"sha256" bson:"sha256"`
+	Storage     string             `json:"storage" bson:"storage"`
+	Key         string             `json:"-" bson:"key"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
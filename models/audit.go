@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit actions
+const (
+	AuditPurgeRequested = "runs.purge.requested"
+	AuditPurgeCompleted = "runs.purge.completed"
+	AuditPurgeFailed    = "runs.purge.failed"
+)
+
+// AuditRecord records an operation on stored data. Audit records are never updated or deleted by the server.
+type AuditRecord struct {
+	ID         primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Action     string                 `json:"action" bson:"action"`
+	TargetID   primitive.ObjectID     `json:"target_id" bson:"target_id"`
+	RemoteAddr string                 `json:"remote_addr,omitempty" bson:"remote_addr,omitempty"`
+	UserAgent  string                 `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	Time       time.Time              `json:"time" bson:"time"`
+}
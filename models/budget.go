@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Budget periods
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// BudgetPeriods lists the supported budget periods
+var BudgetPeriods = []string{BudgetPeriodDaily, BudgetPeriodMonthly}
+
+// Budget caps the spend of an agent or of all agents of a project over a daily or monthly period. Exactly one of
+// AgentID and Project is set.
+type Budget struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	AgentID   *primitive.ObjectID `json:"agent_id,omitempty" bson:"agent_id,omitempty"`
+	Project   string              `json:"project,omitempty" bson:"project,omitempty"`
+	Period    string              `json:"period" bson:"period"`
+	Amount    float64             `json:"amount" bson:"amount"`
+	CreatedAt time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// PeriodStart returns the start of the budget period containing t, a day or a month in the timezone of t
+func (b *Budget) PeriodStart(t time.Time) time.Time {
+	if b.Period == BudgetPeriodMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// PeriodEnd returns the end of the budget period starting at start
+func (b *Budget) PeriodEnd(start time.Time) time.Time {
+	if b.Period == BudgetPeriodMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// BudgetRequest represents the request to create or update a budget
+type BudgetRequest struct {
+	AgentID *primitive.ObjectID `json:"agent_id"`
+	Project string              `json:"project"`
+	Period  string              `json:"period"`
+	Amount  float64             `json:"amount"`
+}
+
+// BudgetStatus is the spend of a budget over its current period, computed by the worker
+type BudgetStatus struct {
+	BudgetID        primitive.ObjectID  `json:"budget_id" bson:"_id"`
+	AgentID         *primitive.ObjectID `json:"agent_id,omitempty" bson:"agent_id,omitempty"`
+	Project         string              `json:"project,omitempty" bson:"project,omitempty"`
+	Period          string              `json:"period" bson:"period"`
+	Amount          float64             `json:"amount" bson:"amount"`
+	PeriodStart     time.Time           `json:"period_start" bson:"period_start"`
+	PeriodEnd       time.Time           `json:"period_end" bson:"period_end"`
+	Spend           float64             `json:"spend" bson:"spend"`
+	Remaining       float64             `json:"remaining" bson:"remaining"`
+	PercentConsumed float64             `json:"percent_consumed" bson:"percent_consumed"`
+	Exceeded        bool                `json:"exceeded" bson:"exceeded"`
+	ComputedAt      time.Time           `json:"computed_at" bson:"computed_at"`
+}
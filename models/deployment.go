@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Deployment statuses
+const (
+	DeploymentStarted    = "started"
+	DeploymentSucceeded  = "succeeded"
+	DeploymentFailed     = "failed"
+	DeploymentRolledBack = "rolled_back"
+)
+
+// DeploymentStatuses lists the statuses of deployment events
+var DeploymentStatuses = []string{DeploymentStarted, DeploymentSucceeded, DeploymentFailed, DeploymentRolledBack}
+
+// DeploymentEvent records a step of the deployment of an agent version to an environment, posted by the pipeline
+// deploying it. Actor is the subject of the credentials that posted it.
+type DeploymentEvent struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AgentID     primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Version     string             `json:"version" bson:"version"`
+	Environment string             `json:"environment" bson:"environment"`
+	Cluster     string             `json:"cluster,omitempty" bson:"cluster,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Commit      string             `json:"commit,omitempty" bson:"commit,omitempty"`
+	URL         string             `json:"url,omitempty" bson:"url,omitempty"`
+	Actor       string             `json:"actor,omitempty" bson:"actor,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// DeploymentEventRequest represents the request to post a deployment event
+type DeploymentEventRequest struct {
+	Environment string `json:"environment"`
+	Cluster     string `json:"cluster"`
+	Status      string `json:"status"`
+	Commit      string `json:"commit"`
+	URL         string `json:"url"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Export job statuses
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// ExportJob tracks the export of the runs of a time range to an object store bucket
+type ExportJob struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Format      string             `json:"format" bson:"format"`
+	From        time.Time          `json:"from" bson:"from"`
+	To          time.Time          `json:"to" bson:"to"`
+	Destination string             `json:"destination" bson:"destination"`
+	Files       []ExportFile       `json:"files" bson:"files"`
+	Rows        int64              `json:"rows" bson:"rows"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	StartedAt   *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// ExportFile is a file written by an export job
+type ExportFile struct {
+	Key   string `json:"key" bson:"key"`
+	Rows  int64  `json:"rows" bson:"rows"`
+	Bytes int64  `json:"bytes" bson:"bytes"`
+}
+
+// ExportRequest represents the request to start an export job. The range includes From and excludes To.
+type ExportRequest struct {
+	Format string    `json:"format"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
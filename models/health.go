@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Agent version health statuses, from best to worst. Stale versions have not been seen recently, so their health is
+// unknown.
+const (
+	HealthHealthy   = "healthy"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+	HealthStale     = "stale"
+)
+
+// HealthStatuses lists the agent version health statuses
+var HealthStatuses = []string{HealthHealthy, HealthDegraded, HealthUnhealthy, HealthStale}
+
+// Health thresholds. The recent window is compared to the baseline window preceding it.
+const (
+	HealthRecentWindow   = time.Hour
+	HealthBaselineWindow = 7 * 24 * time.Hour
+	// HealthStaleAfter is the time since the last run after which a version is stale
+	HealthStaleAfter = 24 * time.Hour
+
+	healthDegradedErrorRate  = 5.0
+	healthUnhealthyErrorRate = 20.0
+	healthDegradedLatency    = 1.5
+	healthUnhealthyLatency   = 3.0
+	// healthMinRuns is the number of runs the recent and baseline windows need for their rates to be compared
+	healthMinRuns = 5
+)
+
+// HealthSignals are the telemetry of an agent version the health is derived from
+type HealthSignals struct {
+	LastSeen time.Time
+	// RecentRuns, RecentErrors and RecentAvgTime cover the recent window
+	RecentRuns    int64
+	RecentErrors  int64
+	RecentAvgTime float64
+	// BaselineRuns and BaselineAvgTime cover the baseline window
+	BaselineRuns    int64
+	BaselineAvgTime float64
+}
+
+// ComputeHealth derives the health of an agent version at now from its telemetry: stale when it has not run for
+// HealthStaleAfter, otherwise degraded or unhealthy when its recent error rate or its recent latency, relative to
+// the baseline, crosses a threshold. The reasons explain every crossed threshold.
+func ComputeHealth(signals HealthSignals, now time.Time) (string, []string) {
+	if signals.LastSeen.IsZero() || now.Sub(signals.LastSeen) > HealthStaleAfter {
+		return HealthStale, []string{fmt.Sprintf("no runs for more than %d hours", int(HealthStaleAfter.Hours()))}
+	}
+
+	health := HealthHealthy
+	var reasons []string
+	worsen := func(h string) {
+		if h == HealthUnhealthy || health == HealthHealthy {
+			health = h
+		}
+	}
+
+	if signals.RecentRuns >= healthMinRuns {
+		errorRate := float64(signals.RecentErrors) / float64(signals.RecentRuns) * 100
+		switch {
+		case errorRate >= healthUnhealthyErrorRate:
+			worsen(HealthUnhealthy)
+		case errorRate >= healthDegradedErrorRate:
+			worsen(HealthDegraded)
+		}
+		if errorRate >= healthDegradedErrorRate {
+			reasons = append(reasons, fmt.Sprintf("error rate of %.1f%% over the last %d minutes", errorRate, int(HealthRecentWindow.Minutes())))
+		}
+
+		if signals.BaselineRuns >= healthMinRuns && signals.BaselineAvgTime > 0 {
+			ratio := signals.RecentAvgTime / signals.BaselineAvgTime
+			switch {
+			case ratio >= healthUnhealthyLatency:
+				worsen(HealthUnhealthy)
+			case ratio >= healthDegradedLatency:
+				worsen(HealthDegraded)
+			}
+			if ratio >= healthDegradedLatency {
+				reasons = append(reasons, fmt.Sprintf("latency %.1fx the baseline over the last %d minutes", ratio, int(HealthRecentWindow.Minutes())))
+			}
+		}
+	}
+
+	return health, reasons
+}
+
+// VersionHealth is the health of an agent version
+type VersionHealth struct {
+	ID            primitive.ObjectID `json:"id"`
+	Name          string             `json:"name"`
+	Project       string             `json:"project"`
+	Version       string             `json:"version"`
+	Cluster       string             `json:"cluster"`
+	Status        string             `json:"status"`
+	LastSeen      time.Time          `json:"lastSeen"`
+	Health        string             `json:"health"`
+	HealthReasons []string           `json:"healthReasons,omitempty"`
+}
+
+// HealthSummary counts agent versions by health and lists the versions that are not healthy, worst first
+type HealthSummary struct {
+	Total    int             `json:"total"`
+	Counts   map[string]int  `json:"counts"`
+	Versions []VersionHealth `json:"versions"`
+}
+
+// NewHealthSummary summarizes the health of agent versions
+func NewHealthSummary(metrics []AgentVersionMetrics) *HealthSummary {
+	summary := &HealthSummary{
+		Counts:   make(map[string]int, len(HealthStatuses)),
+		Versions: []VersionHealth{},
+	}
+	for _, health := range HealthStatuses {
+		summary.Counts[health] = 0
+	}
+
+	for _, m := range metrics {
+		// Metrics aggregated before health was computed have none
+		if m.Health == "" {
+			continue
+		}
+		summary.Total++
+		summary.Counts[m.Health]++
+		if m.Health == HealthHealthy {
+			continue
+		}
+		summary.Versions = append(summary.Versions, VersionHealth{
+			ID:            m.Id,
+			Name:          m.Name,
+			Project:       m.Project,
+			Version:       m.Version,
+			Cluster:       m.Cluster,
+			Status:        m.Status,
+			LastSeen:      m.LastSeen,
+			Health:        m.Health,
+			HealthReasons: m.HealthReasons,
+		})
+	}
+
+	sort.SliceStable(summary.Versions, func(i, j int) bool {
+		return healthRank[summary.Versions[i].Health] < healthRank[summary.Versions[j].Health]
+	})
+	return summary
+}
+
+// healthRank orders the health statuses that are not healthy, worst first
+var healthRank = map[string]int{HealthUnhealthy: 0, HealthDegraded: 1, HealthStale: 2}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the snapshot of the response to a write request sent with an Idempotency-Key header,
+// replayed when the request is retried with the same key
+type IdempotencyRecord struct {
+	ID          string    `bson:"_id"`
+	Key         string    `bson:"key"`
+	Method      string    `bson:"method"`
+	Path        string    `bson:"path"`
+	RequestHash string    `bson:"request_hash"`
+	Completed   bool      `bson:"completed"`
+	Status      int       `bson:"status,omitempty"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
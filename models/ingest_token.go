@@ -0,0 +1,46 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IngestTokenPrefix starts every ingest token, telling them apart from other bearer credentials
+const IngestTokenPrefix = "rit_"
+
+// IngestToken authorizes writing the runs of an agent, or only those of one of its versions when Version is set.
+// Only the SHA-256 hash of the token is stored, the token itself is returned once, when it is issued.
+type IngestToken struct {
+	Hash      string             `json:"-" bson:"_id"`
+	OrgID     primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	AgentID   primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Version   string             `json:"version,omitempty" bson:"version,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// NewIngestToken generates a token for the runs of an agent, or of one of its versions, and returns it with the
+// record to store
+func NewIngestToken(agentID primitive.ObjectID, version string) (string, *IngestToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	token := IngestTokenPrefix + hex.EncodeToString(secret)
+	return token, &IngestToken{Hash: HashIngestToken(token), AgentID: agentID, Version: version}, nil
+}
+
+// HashIngestToken returns the hash an ingest token is stored and looked up by
+func HashIngestToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows reports whether the token authorizes writing the runs of an agent version
+func (t *IngestToken) Allows(agentID primitive.ObjectID, version string) bool {
+	return t.AgentID == agentID && (t.Version == "" || t.Version == version)
+}
@@ -6,10 +6,23 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Default metrics dimensions, used until runs carry a window or environment
+const (
+	MetricsWindowAll          = "all"
+	MetricsEnvironmentDefault = "default"
+)
+
+// AgentVersionMetrics holds the aggregated metrics of an agent version. Spend and SpendTotal are the cost of all
+// runs, SpendToday the cost of the runs created since midnight in the reporting timezone of the agent's project, and
+// Spend7d and Spend30d the cost of the runs created over the last 7 and 30 days.
 type AgentVersionMetrics struct {
-	Id             primitive.ObjectID `json:"id" bson:"_id"`
+	Id             primitive.ObjectID `json:"id" bson:"version_id"`
+	OrgID          primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	Window         string             `json:"window" bson:"window"`
+	Environment    string             `json:"environment" bson:"environment"`
 	Name           string             `json:"name" bson:"name"`
 	Project        string             `json:"project" bson:"project"`
+	Team           string             `json:"team,omitempty" bson:"team"`
 	Status         string             `json:"status" bson:"status"`
 	LastSeen       time.Time          `json:"lastSeen" bson:"lastSeen"`
 	Version        string             `json:"version" bson:"version"`
@@ -17,7 +30,321 @@ type AgentVersionMetrics struct {
 	SuccessRate    float64            `json:"successRate" bson:"successRate"`
 	TotalRuns      int64              `json:"totalRuns" bson:"totalRuns"`
 	Spend          float64            `json:"spend" bson:"spend"`
+	SpendToday     float64            `json:"spendToday" bson:"spendToday"`
+	Spend7d        float64            `json:"spend7d" bson:"spend7d"`
+	Spend30d       float64            `json:"spend30d" bson:"spend30d"`
+	SpendTotal     float64            `json:"spendTotal" bson:"spendTotal"`
 	Tools          []string           `json:"tools" bson:"tools"`
 	Models         []string           `json:"models" bson:"models"`
 	Cluster        string             `json:"cluster" bson:"cluster"`
+	// Health is derived from the recent error rate and latency and the last seen time, see ComputeHealth
+	Health        string   `json:"health" bson:"health"`
+	HealthReasons []string `json:"healthReasons,omitempty" bson:"healthReasons,omitempty"`
+	// Errors counts the failed runs by error category, see AgentRun.ErrorCategory
+	Errors map[string]int64 `json:"errors" bson:"errors"`
+}
+
+// MetricsKey identifies a metrics document: an agent version aggregated over a window, environment and cluster
+type MetricsKey struct {
+	VersionID   primitive.ObjectID `json:"version_id" bson:"version_id"`
+	Window      string             `json:"window" bson:"window"`
+	Environment string             `json:"environment" bson:"environment"`
+	Cluster     string             `json:"cluster" bson:"cluster"`
+}
+
+// Key returns the composite key of the metrics document
+func (m *AgentVersionMetrics) Key() MetricsKey {
+	return MetricsKey{
+		VersionID:   m.Id,
+		Window:      m.Window,
+		Environment: m.Environment,
+		Cluster:     m.Cluster,
+	}
+}
+
+// Run statuses. Completed runs succeeded and running runs are not finished yet, any other status is a failure.
+const (
+	RunStatusCompleted = "completed"
+	RunStatusRunning   = "running"
+)
+
+// VersionStats summarizes the finished runs of an agent version over a time window
+type VersionStats struct {
+	Version      string  `json:"version"`
+	Runs         int64   `json:"runs"`
+	Running      int64   `json:"running"`
+	SuccessRate  float64 `json:"success_rate"`
+	AvgTimeTaken float64 `json:"avg_time_taken"`
+	P95TimeTaken float64 `json:"p95_time_taken"`
+	TotalCost    float64 `json:"total_cost"`
+	CostPerRun   float64 `json:"cost_per_run"`
+	// Errors counts the failed runs by status
+	Errors map[string]int64 `json:"errors"`
+}
+
+// VersionComparison compares two versions of an agent side by side over the same time window
+type VersionComparison struct {
+	AgentID primitive.ObjectID `json:"agent_id"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Base    *VersionStats      `json:"base"`
+	Target  *VersionStats      `json:"target"`
+	// Delta is the change from the base version to the target version
+	Delta VersionStatsDelta `json:"delta"`
+}
+
+// VersionStatsDelta is the difference between the statistics of two versions
+type VersionStatsDelta struct {
+	SuccessRate  float64 `json:"success_rate"`
+	AvgTimeTaken float64 `json:"avg_time_taken"`
+	P95TimeTaken float64 `json:"p95_time_taken"`
+	CostPerRun   float64 `json:"cost_per_run"`
+}
+
+// NewVersionComparison compares the statistics of a base and a target version
+func NewVersionComparison(agentID primitive.ObjectID, from, to time.Time, base, target *VersionStats) *VersionComparison {
+	return &VersionComparison{
+		AgentID: agentID,
+		From:    from,
+		To:      to,
+		Base:    base,
+		Target:  target,
+		Delta: VersionStatsDelta{
+			SuccessRate:  target.SuccessRate - base.SuccessRate,
+			AvgTimeTaken: target.AvgTimeTaken - base.AvgTimeTaken,
+			P95TimeTaken: target.P95TimeTaken - base.P95TimeTaken,
+			CostPerRun:   target.CostPerRun - base.CostPerRun,
+		},
+	}
+}
+
+// Time series metrics
+const (
+	TimeSeriesRuns        = "runs"
+	TimeSeriesCost        = "cost"
+	TimeSeriesLatency     = "latency"
+	TimeSeriesSuccessRate = "success_rate"
+)
+
+// TimeSeries is a metric of an agent version aggregated into fixed time buckets
+type TimeSeries struct {
+	AgentID primitive.ObjectID `json:"agent_id"`
+	Version string             `json:"version"`
+	Metric  string             `json:"metric"`
+	Bucket  string             `json:"bucket"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Points  []TimeSeriesPoint  `json:"points"`
+}
+
+// TimeSeriesPoint is the value of a metric over the bucket starting at Time, with the number of runs it covers
+type TimeSeriesPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Runs  int64     `json:"runs"`
+}
+
+// LatencyHistogram is the distribution of the time taken by the finished runs of an agent version
+type LatencyHistogram struct {
+	AgentID primitive.ObjectID `json:"agent_id"`
+	Version string             `json:"version"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Runs    int64              `json:"runs"`
+	Buckets []HistogramBucket  `json:"buckets"`
+}
+
+// HistogramBucket counts the runs that took at least Min and less than Max seconds. The last bucket of a histogram
+// has no Max.
+type HistogramBucket struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max,omitempty"`
+	Count int64    `json:"count"`
+}
+
+// VersionAdoption is the share of the runs of an agent each of its versions handled, overall and per time bucket
+type VersionAdoption struct {
+	AgentID  primitive.ObjectID `json:"agent_id"`
+	Bucket   string             `json:"bucket"`
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	Versions []VersionShare     `json:"versions"`
+	Points   []AdoptionPoint    `json:"points"`
+}
+
+// AdoptionPoint is the share of the runs each version handled over the bucket starting at Time
+type AdoptionPoint struct {
+	Time     time.Time      `json:"time"`
+	Runs     int64          `json:"runs"`
+	Versions []VersionShare `json:"versions"`
+}
+
+// VersionShare is the number of runs a version handled and its percentage of the runs of the agent
+type VersionShare struct {
+	Version string  `json:"version"`
+	Runs    int64   `json:"runs"`
+	Share   float64 `json:"share"`
+}
+
+// UsageStats summarizes the finished runs that used a tool or a model
+type UsageStats struct {
+	Name         string  `json:"name"`
+	Runs         int64   `json:"runs"`
+	Failed       int64   `json:"failed"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgTimeTaken float64 `json:"avg_time_taken"`
+	TotalCost    float64 `json:"total_cost"`
+}
+
+// UsageStatsReport lists the usage statistics of the tools or models of an agent, or of all agents, over a time
+// window
+type UsageStatsReport struct {
+	AgentID *primitive.ObjectID `json:"agent_id,omitempty"`
+	Version string              `json:"version,omitempty"`
+	From    time.Time           `json:"from"`
+	To      time.Time           `json:"to"`
+	Stats   []UsageStats        `json:"stats"`
+}
+
+// ClusterStats summarizes the runs of the agent versions deployed to a cluster
+type ClusterStats struct {
+	Cluster   string  `json:"cluster"`
+	Agents    int64   `json:"agents"`
+	Versions  int64   `json:"versions"`
+	Runs      int64   `json:"runs"`
+	Failed    int64   `json:"failed"`
+	ErrorRate float64 `json:"error_rate"`
+	TotalCost float64 `json:"total_cost"`
+}
+
+// ClusterStatsReport lists the statistics of every cluster over a time window
+type ClusterStatsReport struct {
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Clusters []ClusterStats `json:"clusters"`
+}
+
+// Heatmap metrics
+const (
+	HeatmapRuns = "runs"
+	HeatmapCost = "cost"
+)
+
+// Heatmap is a metric of the runs of all agents by day of the week and hour of the day, in UTC. Values is indexed
+// by day of the week, starting on Sunday, then by hour.
+type Heatmap struct {
+	Metric string         `json:"metric"`
+	From   time.Time      `json:"from"`
+	To     time.Time      `json:"to"`
+	Values [7][24]float64 `json:"values"`
+}
+
+// Leaderboard metrics
+const (
+	LeaderboardCost   = "cost"
+	LeaderboardErrors = "errors"
+	LeaderboardRuns   = "runs"
+)
+
+// Leaderboard groupings
+const (
+	LeaderboardByAgent   = "agent"
+	LeaderboardByVersion = "version"
+)
+
+// LeaderboardEntry summarizes the runs of an agent, or of a version of an agent, ranked on a leaderboard
+type LeaderboardEntry struct {
+	AgentID   primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	AgentName string             `json:"agent_name" bson:"agent_name"`
+	Version   string             `json:"version,omitempty" bson:"version,omitempty"`
+	Runs      int64              `json:"runs" bson:"runs"`
+	Errors    int64              `json:"errors" bson:"errors"`
+	TotalCost float64            `json:"total_cost" bson:"total_cost"`
+}
+
+// Leaderboard lists the agents or agent versions with the highest value of a metric over a time window
+type Leaderboard struct {
+	By      string             `json:"by"`
+	Group   string             `json:"group"`
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// ErrorCategoryStats counts the failed runs of an error category over a window and over the preceding window of the
+// same length. ChangePercent is omitted when there were no errors in the preceding window.
+type ErrorCategoryStats struct {
+	Type          string   `json:"type,omitempty"`
+	Count         int64    `json:"count"`
+	PreviousCount int64    `json:"previous_count"`
+	Change        int64    `json:"change"`
+	ChangePercent *float64 `json:"change_percent,omitempty"`
+	Trend         string   `json:"trend"`
+}
+
+// AgentErrorStats summarizes the failed runs of an agent, with its top error categories
+type AgentErrorStats struct {
+	AgentID    primitive.ObjectID   `json:"agent_id"`
+	AgentName  string               `json:"agent_name"`
+	Errors     ErrorCategoryStats   `json:"errors"`
+	Categories []ErrorCategoryStats `json:"categories"`
+}
+
+// ErrorReport lists the errors of every agent over a window, compared to the preceding window of the same length
+type ErrorReport struct {
+	From         time.Time         `json:"from"`
+	To           time.Time         `json:"to"`
+	PreviousFrom time.Time         `json:"previous_from"`
+	Agents       []AgentErrorStats `json:"agents"`
+}
+
+// Cost breakdown groupings
+const (
+	CostGroupAgent   = "agent"
+	CostGroupProject = "project"
+	CostGroupTeam    = "team"
+	CostGroupModel   = "model"
+)
+
+// CostBreakdownItem is the cost of the runs of an agent, project, team or model, and its share of the total cost
+type CostBreakdownItem struct {
+	AgentID *primitive.ObjectID `json:"agent_id,omitempty" bson:"agent_id,omitempty"`
+	Name    string              `json:"name" bson:"name"`
+	Runs    int64               `json:"runs" bson:"runs"`
+	Cost    float64             `json:"cost" bson:"cost"`
+	Percent float64             `json:"percent" bson:"-"`
+}
+
+// TokenUsageItem is the token usage and cost of the runs of an agent using a model on a day. The tokens and cost of
+// a run using several models are split evenly between them.
+type TokenUsageItem struct {
+	Day              string             `json:"day" bson:"day"`
+	AgentID          primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Agent            string             `json:"agent" bson:"agent"`
+	Model            string             `json:"model" bson:"model"`
+	Runs             int64              `json:"runs" bson:"runs"`
+	PromptTokens     float64            `json:"prompt_tokens" bson:"prompt_tokens"`
+	CompletionTokens float64            `json:"completion_tokens" bson:"completion_tokens"`
+	Cost             float64            `json:"cost" bson:"cost"`
+}
+
+// TokenUsage is the token usage and cost of the runs created over a time range, per day, agent and model, with the
+// days starting at midnight in the reporting timezone
+type TokenUsage struct {
+	From             time.Time        `json:"from"`
+	To               time.Time        `json:"to"`
+	Timezone         string           `json:"timezone"`
+	PromptTokens     float64          `json:"prompt_tokens"`
+	CompletionTokens float64          `json:"completion_tokens"`
+	Cost             float64          `json:"cost"`
+	Items            []TokenUsageItem `json:"items"`
+}
+
+// CostBreakdown attributes the cost of the runs created over a time window to agents, projects or models
+type CostBreakdown struct {
+	GroupBy   string              `json:"group_by"`
+	From      time.Time           `json:"from"`
+	To        time.Time           `json:"to"`
+	TotalCost float64             `json:"total_cost"`
+	Items     []CostBreakdownItem `json:"items"`
 }
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Organization is a tenant of a shared ripple service. In org tenant mode, agents, versions, runs and metrics carry
+// the ID of the organization they belong to, and requests only see the data of the caller's organization.
+type Organization struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name   string             `json:"name" bson:"name"`
+	Quotas OrganizationQuotas `json:"quotas" bson:"quotas"`
+	// RetentionDays is how many days the runs of the organization are kept, forever when 0
+	RetentionDays int `json:"retention_days" bson:"retention_days"`
+	// Region is the region the data of the organization is stored in, the home region when empty. It is set when
+	// the organization is created and never changes, since the data is not moved.
+	Region    string    `json:"region,omitempty" bson:"region,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+
+	// Usage is only set when a single organization is retrieved
+	Usage *OrganizationUsage `json:"usage,omitempty" bson:"-"`
+}
+
+// OrganizationQuotas limit the usage of an organization, zero limits are unlimited
+type OrganizationQuotas struct {
+	// MaxAgents is the number of agents the organization can register
+	MaxAgents int64 `json:"max_agents" bson:"max_agents"`
+	// MaxRunsPerDay is the number of runs the organization can write per UTC day
+	MaxRunsPerDay int64 `json:"max_runs_per_day" bson:"max_runs_per_day"`
+}
+
+// CreateOrganizationRequest represents the request to create an organization
+type CreateOrganizationRequest struct {
+	Name          string             `json:"name"`
+	Quotas        OrganizationQuotas `json:"quotas"`
+	RetentionDays int                `json:"retention_days"`
+	Region        string             `json:"region,omitempty"`
+}
+
+// UpdateOrganizationRequest represents the request to set the quotas and retention of an organization
+type UpdateOrganizationRequest struct {
+	Quotas        OrganizationQuotas `json:"quotas"`
+	RetentionDays int                `json:"retention_days"`
+}
+
+// OrganizationUsage is the usage of an organization counted against its quotas
+type OrganizationUsage struct {
+	Agents    int64 `json:"agents"`
+	RunsToday int64 `json:"runs_today"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purge job statuses
+const (
+	PurgeStatusPending   = "pending"
+	PurgeStatusRunning   = "running"
+	PurgeStatusCompleted = "completed"
+	PurgeStatusFailed    = "failed"
+)
+
+// PurgeFilter selects the runs of an agent deleted by a purge job. Empty fields match every run, the time range
+// includes From and excludes To. Runs have no metadata to filter on, personal data is found by the initiator, task
+// or trace of the runs.
+type PurgeFilter struct {
+	From      *time.Time `json:"from,omitempty" bson:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty" bson:"to,omitempty"`
+	Version   string     `json:"version,omitempty" bson:"version,omitempty"`
+	Status    string     `json:"status,omitempty" bson:"status,omitempty"`
+	Initiator string     `json:"initiator,omitempty" bson:"initiator,omitempty"`
+	TaskID    *int64     `json:"task_id,omitempty" bson:"task_id,omitempty"`
+	TraceID   string     `json:"trace_id,omitempty" bson:"trace_id,omitempty"`
+}
+
+// IsEmpty reports whether the filter matches every run
+func (f PurgeFilter) IsEmpty() bool {
+	return f == PurgeFilter{}
+}
+
+// PurgeJob tracks the deletion of the runs of an agent matching a filter, and the steps of their traces
+type PurgeJob struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AgentID      primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Filter       PurgeFilter        `json:"filter" bson:"filter"`
+	Reason       string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status       string             `json:"status" bson:"status"`
+	RunsDeleted  int64              `json:"runs_deleted" bson:"runs_deleted"`
+	StepsDeleted int64              `json:"steps_deleted" bson:"steps_deleted"`
+	Error        string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	StartedAt    *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	CompletedAt  *time.Time         `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
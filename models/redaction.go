@@ -0,0 +1,165 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Redaction actions
+const (
+	// RedactMask replaces the redacted text with RedactedText
+	RedactMask = "mask"
+	// RedactHash replaces the redacted text with a hash of it, so that runs with the same value can still be grouped
+	RedactHash = "hash"
+)
+
+// RedactedText replaces the text masked by redaction rules
+const RedactedText = "[REDACTED]"
+
+// attributesField prefixes the field paths of step attributes, e.g. attributes.user.email
+const attributesField = "attributes."
+
+// redactableFields are the field paths of the text of runs and steps redaction rules apply to
+var redactableFields = []string{"initiator", "error_message", "tools", "models", "name", "tool", "model", "attributes"}
+
+// RedactionRule redacts personal data from the runs and steps of the agents of a project before they are stored.
+// The rule applies to a field path, such as error_message or attributes.user.email for a step attribute, or to every
+// redactable field when Field is empty. Pattern selects the text of the field that is redacted, the whole value when
+// empty.
+type RedactionRule struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	// Project is the project of the agents the rule applies to, every project when empty
+	Project   string    `json:"project" bson:"project"`
+	Name      string    `json:"name" bson:"name"`
+	Field     string    `json:"field,omitempty" bson:"field,omitempty"`
+	Pattern   string    `json:"pattern,omitempty" bson:"pattern,omitempty"`
+	Action    string    `json:"action" bson:"action"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// RedactionRuleRequest represents the request to create or replace a redaction rule
+type RedactionRuleRequest struct {
+	Project string `json:"project"`
+	Name    string `json:"name"`
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+}
+
+// Validate checks that the rule has a name, a known action and field, and a valid pattern
+func (r *RedactionRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if r.Action != RedactMask && r.Action != RedactHash {
+		return fmt.Errorf("action must be %s or %s", RedactMask, RedactHash)
+	}
+	if r.Field == "" && r.Pattern == "" {
+		return errors.New("a field, a pattern or both are required")
+	}
+	if r.Field != "" && !containsString(redactableFields, r.Field) && (!strings.HasPrefix(r.Field, attributesField) || r.Field == attributesField) {
+		return fmt.Errorf("unknown field %s, expected one of %s or attributes.<name>", r.Field, strings.Join(redactableFields, ", "))
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	return nil
+}
+
+// Redaction applies compiled redaction rules to runs and steps
+type Redaction struct {
+	rules []compiledRedactionRule
+}
+
+type compiledRedactionRule struct {
+	field   string
+	pattern *regexp.Regexp
+	hash    bool
+}
+
+// NewRedaction compiles redaction rules, in the order they are applied
+func NewRedaction(rules []RedactionRule) (*Redaction, error) {
+	redaction := &Redaction{}
+	for _, rule := range rules {
+		compiled := compiledRedactionRule{field: rule.Field, hash: rule.Action == RedactHash}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of redaction rule %s: %w", rule.Name, err)
+			}
+			compiled.pattern = pattern
+		}
+		redaction.rules = append(redaction.rules, compiled)
+	}
+	return redaction, nil
+}
+
+// Run redacts the initiator, error message, tools and models of a run
+func (r *Redaction) Run(run *AgentRun) {
+	if r == nil {
+		return
+	}
+	for _, rule := range r.rules {
+		rule.apply("initiator", &run.Initiator)
+		rule.apply("error_message", &run.ErrorMessage)
+		for i := range run.Tools {
+			rule.apply("tools", &run.Tools[i])
+		}
+		for i := range run.Models {
+			rule.apply("models", &run.Models[i])
+		}
+	}
+}
+
+// Step redacts the name, tool, model and attributes of a step
+func (r *Redaction) Step(step *RunStep) {
+	if r == nil {
+		return
+	}
+	for _, rule := range r.rules {
+		rule.apply("name", &step.Name)
+		rule.apply("tool", &step.Tool)
+		rule.apply("model", &step.Model)
+		for key, value := range step.Attributes {
+			if rule.field == "" || rule.field == "attributes" || rule.field == attributesField+key {
+				step.Attributes[key] = rule.redact(value)
+			}
+		}
+	}
+}
+
+// apply redacts a field when the rule applies to it
+func (c compiledRedactionRule) apply(field string, value *string) {
+	if c.field == "" || c.field == field {
+		*value = c.redact(*value)
+	}
+}
+
+// redact replaces the text of a value matching the pattern of the rule, or the whole value without one
+func (c compiledRedactionRule) redact(value string) string {
+	if value == "" {
+		return value
+	}
+	if c.pattern == nil {
+		return c.replacement(value)
+	}
+	return c.pattern.ReplaceAllStringFunc(value, c.replacement)
+}
+
+// replacement returns what redacted text is replaced with: its hash or the masked text
+func (c compiledRedactionRule) replacement(text string) string {
+	if !c.hash {
+		return RedactedText
+	}
+	sum := sha256.Sum256([]byte(text))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
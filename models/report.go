@@ -0,0 +1,132 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Report cadences
+const (
+	ReportCadenceDaily   = "daily"
+	ReportCadenceWeekly  = "weekly"
+	ReportCadenceMonthly = "monthly"
+)
+
+// ReportCadences lists the supported report cadences
+var ReportCadences = []string{ReportCadenceDaily, ReportCadenceWeekly, ReportCadenceMonthly}
+
+// Report formats
+const (
+	ReportFormatHTML = "html"
+	ReportFormatCSV  = "csv"
+)
+
+// ReportFormats lists the supported report formats
+var ReportFormats = []string{ReportFormatHTML, ReportFormatCSV}
+
+// Report metrics, the columns of a report
+const (
+	ReportMetricRuns         = "runs"
+	ReportMetricErrors       = "errors"
+	ReportMetricSuccessRate  = "success_rate"
+	ReportMetricAvgTimeTaken = "avg_time_taken"
+	ReportMetricCost         = "cost"
+)
+
+// ReportMetrics lists the supported report metrics, in the order they are rendered
+var ReportMetrics = []string{ReportMetricRuns, ReportMetricErrors, ReportMetricSuccessRate, ReportMetricAvgTimeTaken, ReportMetricCost}
+
+// Report is a scheduled summary of the runs of the agents of a project, or of all agents when Project is empty,
+// emailed to its recipients at every cadence
+type Report struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Project    string             `json:"project,omitempty" bson:"project,omitempty"`
+	Metrics    []string           `json:"metrics" bson:"metrics"`
+	Cadence    string             `json:"cadence" bson:"cadence"`
+	Format     string             `json:"format" bson:"format"`
+	Recipients []string           `json:"recipients" bson:"recipients"`
+	NextRunAt  time.Time          `json:"next_run_at" bson:"next_run_at"`
+	LastSentAt *time.Time         `json:"last_sent_at,omitempty" bson:"last_sent_at,omitempty"`
+	LastError  string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+
+	// PendingRunAt is the scheduled run being delivered, or retried, and Attempts the failed deliveries of that run
+	PendingRunAt *time.Time `json:"-" bson:"pending_run_at,omitempty"`
+	Attempts     int        `json:"-" bson:"attempts,omitempty"`
+}
+
+// Period returns the start of the period a report sent at t covers: the preceding day, week or month
+func (r *Report) Period(t time.Time) time.Time {
+	switch r.Cadence {
+	case ReportCadenceWeekly:
+		return t.AddDate(0, 0, -7)
+	case ReportCadenceMonthly:
+		return t.AddDate(0, -1, 0)
+	}
+	return t.AddDate(0, 0, -1)
+}
+
+// NextRun returns the first run of the report after now, following its cadence from the scheduled run at
+func (r *Report) NextRun(at, now time.Time) time.Time {
+	for !at.After(now) {
+		switch r.Cadence {
+		case ReportCadenceWeekly:
+			at = at.AddDate(0, 0, 7)
+		case ReportCadenceMonthly:
+			at = at.AddDate(0, 1, 0)
+		default:
+			at = at.AddDate(0, 0, 1)
+		}
+	}
+	return at
+}
+
+// FirstRun returns the default first run of a report created at now: the next UTC midnight for a daily report,
+// the next Monday for a weekly report and the first day of the next month for a monthly report
+func (r *Report) FirstRun(now time.Time) time.Time {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch r.Cadence {
+	case ReportCadenceWeekly:
+		return midnight.AddDate(0, 0, (int(time.Monday)-int(midnight.Weekday())+6)%7+1)
+	case ReportCadenceMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	}
+	return midnight.AddDate(0, 0, 1)
+}
+
+// ReportRequest represents the request to create or update a report
+type ReportRequest struct {
+	Name       string     `json:"name"`
+	Project    string     `json:"project"`
+	Metrics    []string   `json:"metrics"`
+	Cadence    string     `json:"cadence"`
+	Format     string     `json:"format"`
+	Recipients []string   `json:"recipients"`
+	StartAt    *time.Time `json:"start_at"`
+}
+
+// ReportRow holds the metrics of an agent, or the totals, over the period of a report
+type ReportRow struct {
+	AgentID      primitive.ObjectID `json:"agent_id" bson:"_id"`
+	Agent        string             `json:"agent" bson:"agent"`
+	Runs         int64              `json:"runs" bson:"runs"`
+	Errors       int64              `json:"errors" bson:"errors"`
+	SuccessRate  float64            `json:"success_rate" bson:"success_rate"`
+	AvgTimeTaken float64            `json:"avg_time_taken" bson:"avg_time_taken"`
+	Cost         float64            `json:"cost" bson:"cost"`
+}
+
+// ReportSummary is the content of a report over a period, one row per agent with runs, most expensive first
+type ReportSummary struct {
+	Name    string      `json:"name"`
+	Project string      `json:"project,omitempty"`
+	Metrics []string    `json:"metrics"`
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Rows    []ReportRow `json:"rows"`
+	Totals  ReportRow   `json:"totals"`
+}
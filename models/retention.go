@@ -0,0 +1,66 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RetentionPolicy sets how long the data of the agents of a project is kept. Zero days keep the data forever.
+// Steps, which carry the payloads of runs, are deleted with their runs, and can be deleted earlier with StepsDays.
+type RetentionPolicy struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID     primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	Project   string             `json:"project" bson:"project"`
+	RunsDays  int                `json:"runs_days" bson:"runs_days"`
+	StepsDays int                `json:"steps_days" bson:"steps_days"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	// StepsPurgedBefore is the recording time before which the steps of the runs of the project were deleted, so
+	// that the purge job only goes through the runs recorded since
+	StepsPurgedBefore time.Time `json:"-" bson:"steps_purged_before,omitempty"`
+}
+
+// RetentionPolicyRequest represents the request to set the retention policy of a project
+type RetentionPolicyRequest struct {
+	RunsDays  int `json:"runs_days"`
+	StepsDays int `json:"steps_days"`
+}
+
+// Validate checks that the policy has a project and keeps steps no longer than their runs
+func (p *RetentionPolicy) Validate() error {
+	if strings.TrimSpace(p.Project) == "" {
+		return errors.New("project is required")
+	}
+	if p.RunsDays < 0 || p.StepsDays < 0 {
+		return errors.New("retention days must not be negative")
+	}
+	if p.RunsDays > 0 && p.StepsDays > p.RunsDays {
+		return errors.New("steps_days must not exceed runs_days, steps are deleted with their runs")
+	}
+	return nil
+}
+
+// Cutoffs returns the recording times before which the runs and the steps of runs are deleted at a time, zero for
+// the data kept forever
+func (p *RetentionPolicy) Cutoffs(now time.Time) (runs, steps time.Time) {
+	if p.RunsDays > 0 {
+		runs = now.AddDate(0, 0, -p.RunsDays)
+	}
+	if p.StepsDays > 0 {
+		steps = now.AddDate(0, 0, -p.StepsDays)
+	}
+	return runs, steps
+}
+
+// RetentionPreview counts the documents of a project a retention policy deletes at a time
+type RetentionPreview struct {
+	Project     string     `json:"project"`
+	Time        time.Time  `json:"time"`
+	RunsBefore  *time.Time `json:"runs_before,omitempty"`
+	StepsBefore *time.Time `json:"steps_before,omitempty"`
+	Runs        int64      `json:"runs"`
+	Steps       int64      `json:"steps"`
+}
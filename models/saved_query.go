@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RunQuery is a filter on runs. Runs match when they match every field that is set; a run matches Statuses when
+// its status is one of them, and Tool and Model when it used that tool or model. The time range is either Range, a
+// duration up to the time the query is executed such as 24h or 7d, or From and To. Runs have no metadata, so there
+// are no metadata selectors.
+type RunQuery struct {
+	AgentID   *primitive.ObjectID `json:"agent_id,omitempty" bson:"agent_id,omitempty"`
+	Version   string              `json:"version,omitempty" bson:"version,omitempty"`
+	Statuses  []string            `json:"statuses,omitempty" bson:"statuses,omitempty"`
+	ErrorType string              `json:"error_type,omitempty" bson:"error_type,omitempty"`
+	Initiator string              `json:"initiator,omitempty" bson:"initiator,omitempty"`
+	Tool      string              `json:"tool,omitempty" bson:"tool,omitempty"`
+	Model     string              `json:"model,omitempty" bson:"model,omitempty"`
+	Range     string              `json:"range,omitempty" bson:"range,omitempty"`
+	From      *time.Time          `json:"from,omitempty" bson:"from,omitempty"`
+	To        *time.Time          `json:"to,omitempty" bson:"to,omitempty"`
+}
+
+// Matches reports whether a run matches the query, leaving out its time range
+func (q *RunQuery) Matches(run *AgentRun) bool {
+	if q.AgentID != nil && run.AgentID != *q.AgentID {
+		return false
+	}
+	if q.Version != "" && run.Version != q.Version {
+		return false
+	}
+	if len(q.Statuses) > 0 && !containsString(q.Statuses, run.Status) {
+		return false
+	}
+	if q.ErrorType != "" && run.ErrorType != q.ErrorType {
+		return false
+	}
+	if q.Initiator != "" && run.Initiator != q.Initiator {
+		return false
+	}
+	if q.Tool != "" && !containsString(run.Tools, q.Tool) {
+		return false
+	}
+	if q.Model != "" && !containsString(run.Models, q.Model) {
+		return false
+	}
+	return true
+}
+
+// SavedQuery is a run query persisted under a unique name, so it can be executed by name
+type SavedQuery struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Query       RunQuery           `json:"query" bson:"query"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// SavedQueryRequest represents the request to create or update a saved query
+type SavedQueryRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Query       RunQuery `json:"query"`
+}
+
+// containsString reports whether a list contains a value
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
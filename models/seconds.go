@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Seconds is a duration in seconds, such as the time taken by a run. It is decoded from a JSON number of seconds,
+// or from a string holding either a number of seconds or a duration with a unit, such as "2.35", "2.35s" or
+// "350ms", as older clients sent.
+type Seconds float64
+
+// UnmarshalJSON decodes a number of seconds, or a string parsed with ParseSeconds
+func (s *Seconds) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var seconds float64
+		if err := json.Unmarshal(data, &seconds); err != nil {
+			return fmt.Errorf("invalid duration %s, expected a number of seconds", data)
+		}
+		*s = Seconds(seconds)
+		return nil
+	}
+
+	seconds, err := ParseSeconds(value)
+	if err != nil {
+		return err
+	}
+	*s = Seconds(seconds)
+	return nil
+}
+
+// ParseSeconds parses a number of seconds, or a duration with a unit, such as "2.35s" or "350ms", into seconds
+func ParseSeconds(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		d, durationErr := time.ParseDuration(value)
+		if durationErr != nil {
+			return 0, fmt.Errorf("invalid duration %q, expected a number of seconds or a duration such as 2.35s", value)
+		}
+		seconds = d.Seconds()
+	}
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("invalid duration %q, expected a number of seconds or a duration such as 2.35s", value)
+	}
+	return seconds, nil
+}
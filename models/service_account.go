@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ServiceAccountScopes lists the scopes a service account may have: service accounts read, write runs, register
+// versions and post deployment events, and never write other data or administer the server
+var ServiceAccountScopes = []string{ScopeRead, ScopeRunsWrite, ScopeVersionsWrite, ScopeDeploymentsWrite}
+
+// ServiceAccount is a non-human principal, such as a CI pipeline, authenticating with API keys of its own. Its keys
+// have the scopes and project of the account, and stop being accepted while the account is disabled.
+type ServiceAccount struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Scopes      []string           `json:"scopes" bson:"scopes"`
+	Project     string             `json:"project,omitempty" bson:"project,omitempty"`
+	Disabled    bool               `json:"disabled" bson:"disabled"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// CreateServiceAccountRequest represents the request to create a service account
+type CreateServiceAccountRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Project     string   `json:"project"`
+}
+
+// UpdateServiceAccountRequest represents the request to update the description and scopes of a service account
+type UpdateServiceAccountRequest struct {
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+}
+
+// CreateServiceAccountKeyRequest represents the request to issue a key of a service account
+type CreateServiceAccountKeyRequest struct {
+	Name string `json:"name"`
+}
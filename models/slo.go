@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SLO objectives
+const (
+	// SLOObjectiveSuccessRate counts completed runs as good runs
+	SLOObjectiveSuccessRate = "success_rate"
+	// SLOObjectiveLatency counts finished runs taking at most the threshold as good runs
+	SLOObjectiveLatency = "latency"
+)
+
+// SLOObjectives lists the supported SLO objectives
+var SLOObjectives = []string{SLOObjectiveSuccessRate, SLOObjectiveLatency}
+
+// DefaultSLOWindowDays is the rolling window of an SLO when none is given
+const DefaultSLOWindowDays = 30
+
+// SLO is a service level objective of an agent version. Target is the percentage of finished runs in the rolling
+// window that must be good, so 99% success is a success_rate objective with a target of 99, and p95 < 3s is a
+// latency objective with a target of 95 and a threshold of 3 seconds.
+type SLO struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AgentID    primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Version    string             `json:"version" bson:"version"`
+	Name       string             `json:"name" bson:"name"`
+	Objective  string             `json:"objective" bson:"objective"`
+	Target     float64            `json:"target" bson:"target"`
+	Threshold  float64            `json:"threshold,omitempty" bson:"threshold,omitempty"`
+	WindowDays int                `json:"window_days" bson:"window_days"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// SLORequest represents the request to create an SLO
+type SLORequest struct {
+	Version    string  `json:"version"`
+	Name       string  `json:"name"`
+	Objective  string  `json:"objective"`
+	Target     float64 `json:"target"`
+	Threshold  float64 `json:"threshold"`
+	WindowDays int     `json:"window_days"`
+}
+
+// SLOStatus is the compliance of an SLO over its rolling window, computed by the worker
+type SLOStatus struct {
+	SLOID     primitive.ObjectID `json:"slo_id" bson:"_id"`
+	AgentID   primitive.ObjectID `json:"agent_id" bson:"agent_id"`
+	Version   string             `json:"version" bson:"version"`
+	Name      string             `json:"name" bson:"name"`
+	Objective string             `json:"objective" bson:"objective"`
+	Target    float64            `json:"target" bson:"target"`
+	Threshold float64            `json:"threshold,omitempty" bson:"threshold,omitempty"`
+	From      time.Time          `json:"from" bson:"from"`
+	To        time.Time          `json:"to" bson:"to"`
+	Runs      int64              `json:"runs" bson:"runs"`
+	GoodRuns  int64              `json:"good_runs" bson:"good_runs"`
+	// Compliance is the percentage of good runs, 100 when there were no runs
+	Compliance float64 `json:"compliance" bson:"compliance"`
+	// ErrorBudgetRemaining is the percentage of the error budget left, negative once the budget is exhausted
+	ErrorBudgetRemaining float64 `json:"error_budget_remaining" bson:"error_budget_remaining"`
+	// BurnRate is the rate the error budget is consumed at, 1 spending exactly the budget over the window
+	BurnRate   float64   `json:"burn_rate" bson:"burn_rate"`
+	Met        bool      `json:"met" bson:"met"`
+	ComputedAt time.Time `json:"computed_at" bson:"computed_at"`
+}
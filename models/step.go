@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Run step kinds
+const (
+	StepKindModel = "model"
+	StepKindTool  = "tool"
+	StepKindSpan  = "span"
+)
+
+// RunStep represents a step of an agent run, e.g. a model call or a tool invocation. Steps belong to the run
+// recorded with the same trace ID.
+type RunStep struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	TraceID          string             `json:"trace_id" bson:"trace_id"`
+	SpanID           string             `json:"span_id" bson:"span_id"`
+	ParentSpanID     string             `json:"parent_span_id" bson:"parent_span_id"`
+	Name             string             `json:"name" bson:"name"`
+	Kind             string             `json:"kind" bson:"kind"`
+	Started          time.Time          `json:"started" bson:"started"`
+	TimeTaken        float64            `json:"time_taken" bson:"time_taken"`
+	Status           string             `json:"status" bson:"status"`
+	Tool             string             `json:"tool,omitempty" bson:"tool,omitempty"`
+	Model            string             `json:"model,omitempty" bson:"model,omitempty"`
+	Cost             float64            `json:"cost" bson:"cost"`
+	PromptTokens     int64              `json:"prompt_tokens,omitempty" bson:"prompt_tokens,omitempty"`
+	CompletionTokens int64              `json:"completion_tokens,omitempty" bson:"completion_tokens,omitempty"`
+	Attributes       map[string]string  `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	RecordedAt       time.Time          `json:"recorded_at" bson:"recorded_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Team is a group of people owning agents. The owning team of an agent is the party responsible for it: who is
+// paged when it is unhealthy and who its spend is attributed to.
+type Team struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	Name  string             `json:"name" bson:"name"`
+	// Email, OnCall and SlackChannel are how the team is reached. OnCall is free form, such as a PagerDuty
+	// schedule or an escalation policy.
+	Email        string    `json:"email,omitempty" bson:"email,omitempty"`
+	OnCall       string    `json:"on_call,omitempty" bson:"on_call,omitempty"`
+	SlackChannel string    `json:"slack_channel,omitempty" bson:"slack_channel,omitempty"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// CreateTeamRequest represents the request to create a team
+type CreateTeamRequest struct {
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	OnCall       string `json:"on_call"`
+	SlackChannel string `json:"slack_channel"`
+}
+
+// SetAgentTeamRequest represents the request to assign an agent to its owning team, or to no team when empty
+type SetAgentTeamRequest struct {
+	Team string `json:"team"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Tenant is a tenant provisioned in database tenant mode, whose data is stored in its own database. Requests are only
+// routed to provisioned tenants.
+type Tenant struct {
+	Name      string    `json:"name" bson:"_id"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// ProvisionTenantRequest represents the request to provision a tenant
+type ProvisionTenantRequest struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// User signs in to the dashboard with an email and password, and a TOTP code once TOTP is enabled. Only the hash of
+// the password is stored.
+type User struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrgID        primitive.ObjectID `json:"-" bson:"org_id,omitempty"`
+	Email        string             `json:"email" bson:"email"`
+	Name         string             `json:"name,omitempty" bson:"name,omitempty"`
+	PasswordHash string             `json:"-" bson:"password_hash"`
+	Roles        []string           `json:"roles" bson:"roles"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	LastLoginAt  *time.Time         `json:"last_login_at,omitempty" bson:"last_login_at,omitempty"`
+
+	// TOTPEnabled requires a code of TOTPSecret at sign in. TOTPPendingSecret is the secret being enrolled, until a
+	// code of it is confirmed, and TOTPLastStep the time step of the last code used, which can not be used again.
+	TOTPEnabled       bool   `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret        string `json:"-" bson:"totp_secret,omitempty"`
+	TOTPPendingSecret string `json:"-" bson:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64  `json:"-" bson:"totp_last_step,omitempty"`
+}
+
+// CreateUserRequest represents the request to create a user
+type CreateUserRequest struct {
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+// LoginRequest represents the request to sign in with a password, and a TOTP code when the user enabled TOTP
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	TOTP     string `json:"totp"`
+}
+
+// TOTPEnrollment is the secret of a TOTP enrollment, to add to an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPCodeRequest represents a request carrying a TOTP code, to confirm or disable TOTP
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
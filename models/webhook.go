@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook represents an outbound webhook subscribed to events
+type Webhook struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL         string             `json:"url" bson:"url"`
+	Events      []string           `json:"events" bson:"events"`
+	Secret      string             `json:"secret,omitempty" bson:"secret"`
+	Description string             `json:"description" bson:"description"`
+	Active      bool               `json:"active" bson:"active"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookDelivery represents a single attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	WebhookID   primitive.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	DeliveryID  string             `json:"delivery_id" bson:"delivery_id"`
+	Event       string             `json:"event" bson:"event"`
+	Attempt     int                `json:"attempt" bson:"attempt"`
+	Success     bool               `json:"success" bson:"success"`
+	StatusCode  int                `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs  int64              `json:"duration_ms" bson:"duration_ms"`
+	AttemptedAt time.Time          `json:"attempted_at" bson:"attempted_at"`
+}
+
+// PendingDelivery is the delivery of an event to a webhook, queued until it succeeds or its attempts are exhausted, so
+// that deliveries and their retries outlive the server that queued them. The webhook is in the database named
+// Database, of the organization OrgID in org tenant mode.
+type PendingDelivery struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	Database      string             `bson:"database,omitempty"`
+	OrgID         primitive.ObjectID `bson:"webhook_org_id,omitempty"`
+	WebhookID     primitive.ObjectID `bson:"webhook_id"`
+	DeliveryID    string             `bson:"delivery_id"`
+	Event         string             `bson:"event"`
+	Body          []byte             `bson:"body"`
+	Attempts      int                `bson:"attempts"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+}
+
+// WebhookRequest represents the request to create or update a webhook
+type WebhookRequest struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Secret      string   `json:"secret"`
+	Description string   `json:"description"`
+	Active      *bool    `json:"active"`
+}
@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"ripple/auth"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Action is an operation a caller performs on a resource
+type Action string
+
+// Actions on agents, their versions and their runs
+const (
+	Read          Action = "read"
+	Write         Action = "write"
+	Delete        Action = "delete"
+	WriteRuns     Action = "runs:write"
+	WriteVersions Action = "versions:write"
+)
+
+// actionRoles are the roles granting the actions
+var actionRoles = map[Action]string{
+	Read:          auth.RoleViewer,
+	Write:         auth.RoleEditor,
+	Delete:        auth.RoleAdmin,
+	WriteRuns:     auth.RoleIngest,
+	WriteVersions: auth.RoleVersions,
+}
+
+// Resource is what an action is performed on: the agents of a project, an agent or one of its versions. Zero fields
+// are not checked, a resource with none is the whole organization.
+type Resource struct {
+	Project string
+	AgentID primitive.ObjectID
+	Version string
+}
+
+// Principal is the caller performing an action: the claims of its credentials, and the ingest token it writes runs
+// with. Requests signed by an agent or authenticated with the client certificate of an agent are given an ingest
+// token for their agent. A principal with neither was not authenticated, authentication being disabled.
+type Principal struct {
+	Claims      *auth.Claims
+	IngestToken *models.IngestToken
+}
+
+// Policy decides whether a principal may perform an action on a resource
+type Policy interface {
+	// Can returns nil when the principal may perform the action on the resource, and an error telling why not
+	// otherwise
+	Can(principal Principal, action Action, resource Resource) error
+}
+
+// Roles is the default policy. Ingest tokens only write the runs of their agent, or agent version. Claims must grant
+// the role of the action, viewer to read, editor to write, admin to delete, ingest to write runs and versions to
+// register versions, and project scoped claims only reach the resources of their project. Principals that were not
+// authenticated may do anything.
+type Roles struct{}
+
+// Can returns nil when the principal may perform the action on the resource
+func (Roles) Can(principal Principal, action Action, resource Resource) error {
+	if token := principal.IngestToken; token != nil {
+		if action != WriteRuns {
+			return errors.New("ingest tokens can only write runs")
+		}
+		if !resource.AgentID.IsZero() && !token.Allows(resource.AgentID, resource.Version) {
+			return errors.New("the ingest token does not allow writing runs of this agent version")
+		}
+	}
+
+	claims := principal.Claims
+	if claims == nil {
+		return nil
+	}
+	role, ok := actionRoles[action]
+	if !ok {
+		return fmt.Errorf("unknown action %s", action)
+	}
+	if !claims.Grants(role) {
+		return fmt.Errorf("the %s role is required", role)
+	}
+	if claims.Project != "" && resource.Project != "" && resource.Project != claims.Project {
+		return fmt.Errorf("the caller is restricted to project %s", claims.Project)
+	}
+	return nil
+}
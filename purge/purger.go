@@ -0,0 +1,78 @@
+package purge
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ripple/db"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	// jobTimeout bounds the duration of a job, after which it is considered abandoned and claimed again
+	jobTimeout = time.Hour
+)
+
+// Purger runs the pending purge jobs, deleting the runs matching each job's filter
+type Purger struct {
+	base         *db.MongoDB
+	tenants      *db.TenantRouter
+	pollInterval time.Duration
+}
+
+// NewPurger creates a new purger. With a tenant router, the jobs of every tenant database are run.
+func NewPurger(base *db.MongoDB, tenants *db.TenantRouter) *Purger {
+	return &Purger{
+		base:         base,
+		tenants:      tenants,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// Run polls for pending purge jobs until the context is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		databases, err := db.TenantDatabases(ctx, p.base, p.tenants)
+		if err != nil {
+			log.Printf("Unable to list tenant databases for purges: %v", err)
+		}
+		for tenant, database := range databases {
+			p.runPending(ctx, tenant, db.NewPurgeRepository(database))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runPending runs the pending jobs of a database one after the other
+func (p *Purger) runPending(ctx context.Context, tenant string, repo *db.PurgeRepository) {
+	for ctx.Err() == nil {
+		job, err := repo.ClaimPurgeJob(ctx, jobTimeout)
+		if err != nil {
+			log.Printf("Unable to claim purge job for tenant %q: %v", tenant, err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		log.Printf("Running purge job %s of runs of agent %s", job.ID.Hex(), job.AgentID.Hex())
+		jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+		jobErr := repo.PurgeRuns(jobCtx, job)
+		cancel()
+		if jobErr != nil {
+			log.Printf("Purge job %s failed: %v", job.ID.Hex(), jobErr)
+		}
+		if err := repo.FinishPurgeJob(ctx, job.ID, jobErr); err != nil {
+			log.Printf("Unable to record the end of purge job %s: %v", job.ID.Hex(), err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package purge
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"ripple/db"
+)
+
+// RetentionInterval is how often the runs past the retention of their organization are deleted
+const RetentionInterval = time.Hour
+
+// RunRetention deletes the runs of every organization with a retention that are older than its retention, in the
+// database tenants selects for it in its region, every interval until the context is cancelled
+func RunRetention(ctx context.Context, base *db.MongoDB, tenants *db.TenantRouter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	repo := db.NewOrganizationRepository(base)
+	for {
+		orgs, err := repo.ListOrganizations(ctx)
+		if err != nil {
+			log.Printf("Unable to list organizations for retention: %v", err)
+		}
+		for _, org := range orgs {
+			if org.RetentionDays <= 0 {
+				continue
+			}
+			database, err := tenants.OrgDatabase(&org)
+			if err != nil {
+				log.Printf("Unable to select the database of organization %s: %v", org.ID.Hex(), err)
+				continue
+			}
+			before := time.Now().AddDate(0, 0, -org.RetentionDays)
+			jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+			deleted, err := db.NewOrganizationRepository(database).DeleteExpiredRuns(jobCtx, org.ID, before)
+			cancel()
+			if err != nil {
+				log.Printf("Unable to delete the expired runs of organization %s: %v", org.ID.Hex(), err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d runs of organization %s older than %d days", deleted, org.ID.Hex(), org.RetentionDays)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunWindowRetention enforces the retention windows of runs in every database tenants selects, or in base without
+// tenants, every interval until the context is cancelled: the TTL index of the default window is created in each
+// database the first time it is seen, and the runs of the projects with a shorter window are deleted
+func RunWindowRetention(ctx context.Context, base *db.MongoDB, tenants *db.TenantRouter, retention *db.Retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ensured := map[string]bool{}
+	for {
+		databases, err := db.TenantDatabases(ctx, base, tenants)
+		if err != nil {
+			log.Printf("Unable to list tenant databases for retention: %v", err)
+		}
+		for tenant, database := range databases {
+			repo := db.NewAgentRepository(database)
+			if !ensured[database.Key()] {
+				if err := repo.EnsureRunRetention(ctx, retention.Default); err != nil {
+					log.Printf("Unable to set the run retention of tenant %q: %v", tenant, err)
+				} else {
+					ensured[database.Key()] = true
+				}
+			}
+
+			for project, window := range retention.Projects {
+				jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+				deleted, err := repo.DeleteExpiredProjectRuns(jobCtx, project, time.Now().Add(-window))
+				cancel()
+				if err != nil {
+					log.Printf("Unable to delete the expired runs of project %s of tenant %q: %v", project, tenant, err)
+				} else if deleted > 0 {
+					log.Printf("Deleted %d runs of project %s of tenant %q older than %s", deleted, project, tenant, window)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
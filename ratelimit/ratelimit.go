@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxKeys is the default number of keys a limiter tracks
+const DefaultMaxKeys = 100000
+
+// periods are the periods a rate can be given per
+var periods = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// Limit is a sustained rate of requests per second, and the number of requests allowed at once
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit in the form "100/s", "600/m" or "1000/h", optionally followed by the burst as in
+// "100/s:200". The burst defaults to the number of requests per period.
+func ParseLimit(s string) (Limit, error) {
+	rate, burst, hasBurst := strings.Cut(s, ":")
+	count, period, ok := strings.Cut(rate, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 || periods[period] == 0 {
+		return Limit{}, fmt.Errorf("invalid rate %q, expected a number of requests per s, m or h such as 100/s", rate)
+	}
+
+	limit := Limit{Rate: float64(n) / periods[period].Seconds(), Burst: n}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid burst %q, expected a positive number", burst)
+		}
+	}
+	return limit, nil
+}
+
+// bucket holds the tokens of a key at the time they were last counted
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// Limiter limits the requests of each key with a token bucket: buckets hold up to Burst tokens, refill at Rate tokens
+// per second, and every request takes a token. It tracks at most a fixed number of keys.
+type Limiter struct {
+	mu      sync.Mutex
+	limit   Limit
+	buckets map[string]*bucket
+	maxKeys int
+}
+
+// New creates a limiter tracking at most maxKeys keys
+func New(limit Limit, maxKeys int) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+		maxKeys: maxKeys,
+	}
+}
+
+// Limit returns the limit of the limiter
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from the bucket of key at now. When the bucket is empty, it reports how long until a token is
+// available instead.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxKeys {
+			l.evict(now)
+		}
+		b = &bucket{tokens: float64(l.limit.Burst), at: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.at).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.limit.Rate)
+		b.at = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// evict drops the buckets that refilled, which are the same as new buckets, then arbitrary buckets when the limiter
+// still tracks too many keys
+func (l *Limiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*l.limit.Rate >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	for key := range l.buckets {
+		if len(l.buckets) < l.maxKeys {
+			break
+		}
+		delete(l.buckets, key)
+	}
+}
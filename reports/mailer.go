@@ -0,0 +1,96 @@
+package reports
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends emails through an SMTP server
+type Mailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewMailer creates a new mailer for the SMTP server at addr (host:port), authenticating with PLAIN auth when a
+// username is given
+func NewMailer(addr, from, username, password string) (*Mailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", addr, err)
+	}
+
+	m := &Mailer{addr: addr, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// Send sends an email with an HTML body and optional attachments
+func (m *Mailer) Send(to []string, subject string, html []byte, attachments ...Attachment) error {
+	msg := buildMessage(m.from, to, subject, html, attachments, time.Now())
+	return smtp.SendMail(m.addr, m.auth, m.from, to, msg)
+}
+
+// buildMessage builds a MIME multipart email
+func buildMessage(from string, to []string, subject string, html []byte, attachments []Attachment, date time.Time) []byte {
+	boundary := newBoundary()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	writePart(&b, boundary, "text/html; charset=utf-8", "", html)
+	for _, a := range attachments {
+		writePart(&b, boundary, a.ContentType, a.Filename, a.Content)
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+
+	return b.Bytes()
+}
+
+// writePart writes a base64 encoded MIME part, as an attachment when a filename is given
+func writePart(b *bytes.Buffer, boundary, contentType, filename string, content []byte) {
+	fmt.Fprintf(b, "--%s\r\n", boundary)
+	fmt.Fprintf(b, "Content-Type: %s\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	if filename != "" {
+		fmt.Fprintf(b, "Content-Disposition: attachment; filename=%q\r\n", filename)
+	}
+	b.WriteString("\r\n")
+
+	// Lines of base64 encoded content are limited to 76 characters
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+}
+
+// newBoundary returns a random MIME boundary
+func newBoundary() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return "ripple-" + hex.EncodeToString(buf)
+}
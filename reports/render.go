@@ -0,0 +1,115 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"strconv"
+	"time"
+
+	"ripple/models"
+)
+
+// metricTitles are the column titles of the report metrics
+var metricTitles = map[string]string{
+	models.ReportMetricRuns:         "Runs",
+	models.ReportMetricErrors:       "Errors",
+	models.ReportMetricSuccessRate:  "Success Rate (%)",
+	models.ReportMetricAvgTimeTaken: "Avg Time Taken (s)",
+	models.ReportMetricCost:         "Cost",
+}
+
+// metricValue formats a metric of a report row
+func metricValue(row models.ReportRow, metric string) string {
+	switch metric {
+	case models.ReportMetricRuns:
+		return strconv.FormatInt(row.Runs, 10)
+	case models.ReportMetricErrors:
+		return strconv.FormatInt(row.Errors, 10)
+	case models.ReportMetricSuccessRate:
+		return strconv.FormatFloat(row.SuccessRate, 'f', 1, 64)
+	case models.ReportMetricAvgTimeTaken:
+		return strconv.FormatFloat(row.AvgTimeTaken, 'f', 2, 64)
+	case models.ReportMetricCost:
+		return strconv.FormatFloat(row.Cost, 'f', 2, 64)
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of a report format
+func ContentType(format string) string {
+	if format == models.ReportFormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// Render renders a report summary in a report format
+func Render(summary *models.ReportSummary, format string) ([]byte, error) {
+	if format == models.ReportFormatCSV {
+		return RenderCSV(summary)
+	}
+	return RenderHTML(summary)
+}
+
+// RenderCSV renders a report summary as CSV, one line per agent followed by the totals
+func RenderCSV(summary *models.ReportSummary) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := append([]string{"agent_id", "agent"}, summary.Metrics...)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	rows := append(append([]models.ReportRow{}, summary.Rows...), summary.Totals)
+	for i, row := range rows {
+		record := []string{row.AgentID.Hex(), row.Agent}
+		if i == len(rows)-1 {
+			record[0] = ""
+		}
+		for _, metric := range summary.Metrics {
+			record = append(record, metricValue(row, metric))
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"title": func(metric string) string { return metricTitles[metric] },
+	"value": metricValue,
+	"date":  func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>{{.Name}}</h2>
+<p>{{if .Project}}Project {{.Project}}, from{{else}}All projects, from{{end}} {{date .From}} to {{date .To}}</p>
+<table cellpadding="6" style="border-collapse: collapse">
+<tr style="text-align: left; border-bottom: 1px solid #ccc"><th>Agent</th>{{range .Metrics}}<th>{{title .}}</th>{{end}}</tr>
+{{- $metrics := .Metrics}}
+{{- range .Rows}}
+<tr><td>{{.Agent}}</td>{{$row := .}}{{range $metrics}}<td>{{value $row .}}</td>{{end}}</tr>
+{{- end}}
+<tr style="font-weight: bold; border-top: 1px solid #ccc"><td>{{.Totals.Agent}}</td>{{$totals := .Totals}}{{range $metrics}}<td>{{value $totals .}}</td>{{end}}</tr>
+</table>
+{{- if not .Rows}}
+<p>No runs in this period.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// RenderHTML renders a report summary as an HTML document with a table of the agents and the totals
+func RenderHTML(summary *models.ReportSummary) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, summary); err != nil {
+		return nil, fmt.Errorf("unable to render report: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+)
+
+const (
+	// reportLease is how long a report claimed by a worker is not delivered by others, after which a delivery that
+	// did not finish, as when the worker stopped, is retried
+	reportLease = 10 * time.Minute
+	// reportRetryDelay is how long after a failed delivery it is retried
+	reportRetryDelay = 15 * time.Minute
+	// maxReportAttempts is how many times the delivery of a run is attempted before the report waits for its next run
+	maxReportAttempts = 4
+)
+
+// Scheduler delivers the reports that are due
+type Scheduler struct {
+	repo   *db.ReportRepository
+	mailer *Mailer
+}
+
+// NewScheduler creates a new scheduler delivering the reports of a database with a mailer
+func NewScheduler(repo *db.ReportRepository, mailer *Mailer) *Scheduler {
+	return &Scheduler{
+		repo:   repo,
+		mailer: mailer,
+	}
+}
+
+// RunDue delivers every report scheduled at or before now and schedules its next run. Each report is claimed before
+// it is delivered, so that workers running at the same time deliver it once. A report that fails to be delivered is
+// retried every reportRetryDelay, up to maxReportAttempts times, then waits for its next run.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) error {
+	due, err := s.repo.ListDueReports(ctx, now)
+	if err != nil {
+		return fmt.Errorf("unable to fetch due reports: %w", err)
+	}
+
+	for i := range due {
+		report := &due[i]
+		// Retries keep the schedule of the run they retry
+		scheduled := report.NextRunAt
+		if report.PendingRunAt != nil {
+			scheduled = *report.PendingRunAt
+		}
+		claimed, err := s.repo.ClaimReportRun(ctx, report, scheduled, now.Add(reportLease))
+		if err != nil {
+			log.Printf("Unable to claim report %s. Error is %s", report.ID.Hex(), err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		next, retry := report.NextRun(scheduled, now), false
+		sendErr := s.deliver(ctx, report, now)
+		if sendErr != nil {
+			log.Printf("Unable to deliver report %s. Error is %s", report.ID.Hex(), sendErr)
+			if report.Attempts+1 < maxReportAttempts {
+				next, retry = now.Add(reportRetryDelay), true
+			}
+		}
+
+		if err := s.repo.FinishReportRun(ctx, report, next, now, sendErr, retry); err != nil {
+			log.Printf("Unable to schedule the next run of report %s. Error is %s", report.ID.Hex(), err)
+		}
+	}
+
+	return nil
+}
+
+// deliver renders a report over the period ending at now and emails it to its recipients
+func (s *Scheduler) deliver(ctx context.Context, report *models.Report, now time.Time) error {
+	summary, err := s.repo.GetReportSummary(ctx, report, report.Period(now), now)
+	if err != nil {
+		return err
+	}
+
+	html, err := RenderHTML(summary)
+	if err != nil {
+		return err
+	}
+
+	var attachments []Attachment
+	if report.Format == models.ReportFormatCSV {
+		content, err := RenderCSV(summary)
+		if err != nil {
+			return err
+		}
+		attachments = append(attachments, Attachment{
+			Filename:    fmt.Sprintf("report-%s.csv", now.UTC().Format("2006-01-02")),
+			ContentType: ContentType(models.ReportFormatCSV),
+			Content:     content,
+		})
+	}
+
+	subject := fmt.Sprintf("%s: %s to %s", report.Name, summary.From.UTC().Format("2006-01-02"), summary.To.UTC().Format("2006-01-02"))
+	return s.mailer.Send(report.Recipients, subject, html, attachments...)
+}
@@ -152,7 +152,7 @@ func main() {
 				"version":     versions[versionIndex],
 				"created":     createdTime,
 				"status":      statuses[statusIndex],
-				"time_taken":  fmt.Sprintf("%.2fs", timeTaken),
+				"time_taken":  timeTaken,
 				"initiator":   initiators[initiatorIndex],
 				"tools":       []string{"tool1", "tool2"},
 				"cost":        cost,
@@ -186,7 +186,7 @@ func main() {
 				"version":     versions[versionIndex],
 				"created":     createdTime,
 				"status":      statuses[statusIndex],
-				"time_taken":  fmt.Sprintf("%.2fs", timeTaken),
+				"time_taken":  timeTaken,
 				"initiator":   initiators[initiatorIndex],
 				"tools":       []string{"tool1", "tool2"},
 				"cost":        cost,
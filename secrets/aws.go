@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"ripple/sigv4"
+)
+
+// AWSSource reads a secret from AWS Secrets Manager with Signature Version 4 signed requests
+type AWSSource struct {
+	client   *http.Client
+	endpoint string
+	region   string
+	creds    sigv4.Credentials
+	secretID string
+	field    string
+}
+
+// newAWSSource creates a source for the secret of a name or ARN. The region is read from the AWS_REGION or
+// AWS_DEFAULT_REGION environment variables, and the credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func newAWSSource(client *http.Client, secretID, field string) (*AWSSource, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, errors.New("missing AWS region, set AWS_REGION")
+	}
+	creds, err := sigv4.CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AWSSource{
+		client:   client,
+		endpoint: "https://secretsmanager." + region + ".amazonaws.com/",
+		region:   region,
+		creds:    creds,
+		secretID: secretID,
+		field:    field,
+	}, nil
+}
+
+// Read returns a field of the current version of the secret, or the whole secret
+func (s *AWSSource) Read(ctx context.Context) (string, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": s.secretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, payload, s.creds, s.region, "secretsmanager", time.Now().UTC())
+
+	body, err := get(s.client, req)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+	if resp.SecretString == "" {
+		return "", errors.New("secret has no string value")
+	}
+
+	return jsonField([]byte(resp.SecretString), s.field)
+}
@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com/v1/"
+	gcpMetadataTokenURL      = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCPSource reads a secret from Google Cloud Secret Manager. Requests are authenticated with the access token of the
+// GOOGLE_OAUTH_ACCESS_TOKEN environment variable when set, or else with the token of the service account of the
+// instance, from the metadata server.
+type GCPSource struct {
+	client  *http.Client
+	version string
+	field   string
+}
+
+// newGCPSource creates a source for a secret in the form projects/<project>/secrets/<secret>, optionally followed by
+// /versions/<version>, which defaults to the latest version
+func newGCPSource(client *http.Client, name, field string) (*GCPSource, error) {
+	name = strings.Trim(name, "/")
+	parts := strings.Split(name, "/")
+	if len(parts) == 4 {
+		name += "/versions/latest"
+	} else if len(parts) != 6 || parts[4] != "versions" {
+		return nil, fmt.Errorf("invalid Google Cloud secret %q, expected projects/<project>/secrets/<secret>", name)
+	}
+	if parts[0] != "projects" || parts[2] != "secrets" {
+		return nil, fmt.Errorf("invalid Google Cloud secret %q, expected projects/<project>/secrets/<secret>", name)
+	}
+
+	return &GCPSource{
+		client:  client,
+		version: name,
+		field:   field,
+	}, nil
+}
+
+// Read returns a field of the version of the secret, or the whole secret
+func (s *GCPSource) Read(ctx context.Context) (string, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to get a Google Cloud access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpSecretManagerEndpoint+s.version+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	body, err := get(s.client, req)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+	secret, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret payload: %w", err)
+	}
+
+	return jsonField(secret, s.field)
+}
+
+// accessToken returns the access token requests are authenticated with
+func (s *GCPSource) accessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	body, err := get(s.client, req)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("the metadata server returned no access token")
+	}
+	return resp.AccessToken, nil
+}
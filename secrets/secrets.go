@@ -0,0 +1,160 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ripple/db"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	// reconnectDrain is how long the previous MongoDB client keeps running the operations it started
+	reconnectDrain = time.Minute
+)
+
+// Source reads a secret from a secret manager
+type Source interface {
+	// Read returns the current value of the secret
+	Read(ctx context.Context) (string, error)
+}
+
+// NewSource creates a source for a secret reference:
+//
+//	vault://secret/data/ripple/mongo#uri                   a Vault secret at VAULT_ADDR, read with VAULT_TOKEN
+//	aws-sm://ripple/mongo#uri                              an AWS Secrets Manager secret in AWS_REGION
+//	gcp-sm://projects/acme/secrets/ripple-mongo#uri        the latest version of a Google Cloud Secret Manager secret
+//
+// The optional fragment selects a field of a secret holding a JSON object. Without one, the whole secret is read, as
+// JSON for Vault secrets.
+func NewSource(ref string) (Source, error) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	if !ok || rest == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, expected vault://, aws-sm:// or gcp-sm://", ref)
+	}
+	name, field, _ := strings.Cut(rest, "#")
+
+	client := &http.Client{Timeout: requestTimeout}
+	switch scheme {
+	case "vault":
+		return newVaultSource(client, name, field)
+	case "aws-sm":
+		return newAWSSource(client, name, field)
+	case "gcp-sm":
+		return newGCPSource(client, name, field)
+	default:
+		return nil, fmt.Errorf("unsupported secret manager %q, use vault://, aws-sm:// or gcp-sm://", scheme)
+	}
+}
+
+// jsonField returns a field of a secret holding a JSON object, or the whole secret when field is empty
+func jsonField(secret []byte, field string) (string, error) {
+	if field == "" {
+		return string(secret), nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(secret, &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, can not read field %s", field)
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string field %s", field)
+	}
+	return value, nil
+}
+
+// ResolveMongoURI reads the MongoDB URI of a secret. The secret either holds a mongodb:// or mongodb+srv:// URI, or a
+// JSON object with a uri field, or username and password fields replacing the credentials of baseURI.
+func ResolveMongoURI(ctx context.Context, source Source, baseURI string) (string, error) {
+	secret, err := source.Read(ctx)
+	if err != nil {
+		return "", err
+	}
+	secret = strings.TrimSpace(secret)
+	if strings.HasPrefix(secret, "mongodb://") || strings.HasPrefix(secret, "mongodb+srv://") {
+		return secret, nil
+	}
+
+	var creds struct {
+		URI      string `json:"uri"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal([]byte(secret), &creds); err != nil || (creds.URI == "" && creds.Username == "") {
+		return "", errors.New("secret holds neither a MongoDB URI nor a JSON object with uri or username and password")
+	}
+	if creds.URI != "" {
+		return creds.URI, nil
+	}
+
+	u, err := url.Parse(baseURI)
+	if err != nil {
+		return "", fmt.Errorf("invalid MongoDB URI: %w", err)
+	}
+	u.User = url.UserPassword(creds.Username, creds.Password)
+	return u.String(), nil
+}
+
+// WatchMongo re-reads the MongoDB URI of a secret every interval until ctx is done, and reconnects database when the
+// URI changed from uri, so that rotated credentials are picked up without a restart. Failures are logged, and the
+// current connection is kept.
+func WatchMongo(ctx context.Context, database *db.MongoDB, source Source, baseURI, uri string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		readCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		current, err := ResolveMongoURI(readCtx, source, baseURI)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to refresh the MongoDB credentials: %v", err)
+			continue
+		}
+		if current == uri {
+			continue
+		}
+
+		if err := database.Reconnect(current, reconnectDrain); err != nil {
+			log.Printf("Failed to reconnect to MongoDB with the refreshed credentials: %v", err)
+			continue
+		}
+		uri = current
+	}
+}
+
+// get sends a request and returns the response body, failing on error statuses
+func get(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		message := strings.TrimSpace(string(body))
+		if len(message) > 1024 {
+			message = message[:1024]
+		}
+		return nil, fmt.Errorf("unable to read secret: %s: %s", resp.Status, message)
+	}
+	return body, nil
+}
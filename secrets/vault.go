@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// VaultSource reads a secret from HashiCorp Vault over its HTTP API, authenticated with a token
+type VaultSource struct {
+	client    *http.Client
+	addr      string
+	token     string
+	namespace string
+	path      string
+	field     string
+}
+
+// newVaultSource creates a source for the secret at path, e.g. secret/data/ripple/mongo for a KV version 2 secret
+// or database/creds/ripple for credentials of the database secrets engine. The address, token and namespace are read
+// from the VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE environment variables.
+func newVaultSource(client *http.Client, path, field string) (*VaultSource, error) {
+	source := &VaultSource{
+		client:    client,
+		addr:      strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		path:      strings.Trim(path, "/"),
+		field:     field,
+	}
+	if source.addr == "" || source.token == "" {
+		return nil, errors.New("missing Vault configuration, set VAULT_ADDR and VAULT_TOKEN")
+	}
+	return source, nil
+}
+
+// Read returns a field of the secret, or its data as a JSON object
+func (s *VaultSource) Read(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.addr+"/v1/"+s.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+
+	body, err := get(s.client, req)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		return "", err
+	}
+	// KV version 2 secrets nest their data along with its metadata
+	if nested, ok := resp.Data["data"]; ok && resp.Data["metadata"] != nil {
+		data = nested
+	}
+
+	return jsonField(data, s.field)
+}
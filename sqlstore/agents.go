@@ -0,0 +1,420 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"ripple/db"
+	"ripple/events"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const agentColumns = "id, name, project, team, archived, archived_at, signing_secret, created_at, updated_at"
+
+// agentSortColumns are the sortable JSON fields of agents and their columns
+var agentSortColumns = map[string]string{
+	"id":          "id",
+	"name":        "name",
+	"project":     "project",
+	"team":        "team",
+	"archived":    "archived",
+	"archived_at": "archived_at",
+	"created_at":  "created_at",
+	"updated_at":  "updated_at",
+}
+
+// scanAgent reads a row of agentColumns
+func scanAgent(row scanner) (*models.Agent, error) {
+	var agent models.Agent
+	var id string
+	var archivedAt sql.NullTime
+	if err := row.Scan(&id, &agent.Name, &agent.Project, &agent.Team, &agent.Archived, &archivedAt, &agent.SigningSecret, &agent.CreatedAt, &agent.UpdatedAt); err != nil {
+		return nil, err
+	}
+	agent.ID = objectID(id)
+	agent.ArchivedAt = nullTime(archivedAt)
+	return &agent, nil
+}
+
+const versionColumns = "id, agent_id, version, cluster, status, tools, models, deployment, created_at, updated_at"
+
+// versionSortColumns are the sortable JSON fields of agent versions and their columns
+var versionSortColumns = map[string]string{
+	"id":         "id",
+	"agent_id":   "agent_id",
+	"version":    "version",
+	"cluster":    "cluster",
+	"status":     "status",
+	"deployment": "deployment",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// scanVersion reads a row of versionColumns
+func scanVersion(row scanner) (*models.AgentVersion, error) {
+	var version models.AgentVersion
+	var id, agentID, tools, modelNames string
+	if err := row.Scan(&id, &agentID, &version.Version, &version.Cluster, &version.Status, &tools, &modelNames, &version.Deployment, &version.CreatedAt, &version.UpdatedAt); err != nil {
+		return nil, err
+	}
+	version.ID = objectID(id)
+	version.AgentID = objectID(agentID)
+	if err := parseJSON(tools, &version.Tools); err != nil {
+		return nil, err
+	}
+	if err := parseJSON(modelNames, &version.Models); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// CreateAgent creates a new agent in the database
+func (s *Store) CreateAgent(ctx context.Context, agent *models.Agent) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByName(ctx, agent.Name); err == nil {
+		return errors.New("agent with this name already exists")
+	} else if err.Error() != "agent not found" {
+		return err
+	}
+
+	now := time.Now()
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "INSERT INTO agents ("+agentColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id.Hex(), agent.Name, agent.Project, agent.Team, agent.Archived, agent.ArchivedAt, agent.SigningSecret, now, now)
+	if err != nil {
+		return err
+	}
+
+	agent.ID = id
+	agent.CreatedAt = now
+	agent.UpdatedAt = now
+	return nil
+}
+
+// GetAgentByID retrieves an agent by ID
+func (s *Store) GetAgentByID(ctx context.Context, id primitive.ObjectID) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.agentByID(ctx, id)
+}
+
+// GetAgentByName retrieves an agent by name
+func (s *Store) GetAgentByName(ctx context.Context, name string) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.agentByName(ctx, name)
+}
+
+// agentByID looks up an agent by ID
+func (s *Store) agentByID(ctx context.Context, id primitive.ObjectID) (*models.Agent, error) {
+	agent, err := scanAgent(s.queryRow(ctx, "SELECT "+agentColumns+" FROM agents WHERE id = ?", id.Hex()))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("agent not found")
+	}
+	return agent, err
+}
+
+// agentByName looks up an agent by name
+func (s *Store) agentByName(ctx context.Context, name string) (*models.Agent, error) {
+	agent, err := scanAgent(s.queryRow(ctx, "SELECT "+agentColumns+" FROM agents WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("agent not found")
+	}
+	return agent, err
+}
+
+// ListAgents retrieves all agents
+func (s *Store) ListAgents(ctx context.Context, listOpts db.ListOptions) ([]models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	if !listOpts.IncludeArchived {
+		where.add("NOT archived")
+	}
+	if listOpts.Project != "" {
+		where.add("project = ?", listOpts.Project)
+	}
+	if listOpts.Team != "" {
+		where.add("team = ?", listOpts.Team)
+	}
+	page, pageArgs := s.dialect.limitOffset(listOpts.Limit, 0)
+
+	rows, err := s.query(ctx, "SELECT "+agentColumns+" FROM agents"+where.where()+orderBy(agentSortColumns, listOpts.Sort, "name", "id")+page,
+		append(where.args, pageArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []models.Agent{}
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, *agent)
+	}
+	return agents, rows.Err()
+}
+
+// SetAgentArchived archives or unarchives an agent and returns the updated agent
+func (s *Store) SetAgentArchived(ctx context.Context, id primitive.ObjectID, archived bool) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var archivedAt *time.Time
+	if archived {
+		archivedAt = &now
+	}
+	return s.updateAgent(ctx, id, "archived = ?, archived_at = ?, updated_at = ?", archived, archivedAt, now)
+}
+
+// SetAgentSigningSecret replaces the secret signing the run requests of an agent and returns the updated agent
+func (s *Store) SetAgentSigningSecret(ctx context.Context, id primitive.ObjectID, secret string) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.updateAgent(ctx, id, "signing_secret = ?, updated_at = ?", secret, time.Now())
+}
+
+// SetAgentTeam assigns an agent to its owning team, or to no team when team is empty, and returns the updated agent
+func (s *Store) SetAgentTeam(ctx context.Context, id primitive.ObjectID, team string) (*models.Agent, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.updateAgent(ctx, id, "team = ?, updated_at = ?", team, time.Now())
+}
+
+// updateAgent sets columns of an agent and returns the updated agent
+func (s *Store) updateAgent(ctx context.Context, id primitive.ObjectID, set string, args ...interface{}) (*models.Agent, error) {
+	result, err := s.exec(ctx, "UPDATE agents SET "+set+" WHERE id = ?", append(args, id.Hex())...)
+	if err != nil {
+		return nil, err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if updated == 0 {
+		return nil, errors.New("agent not found")
+	}
+
+	return s.agentByID(ctx, id)
+}
+
+// CreateAgentVersion creates a new agent version
+func (s *Store) CreateAgentVersion(ctx context.Context, version *models.AgentVersion) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByID(ctx, version.AgentID); err != nil {
+		return err
+	}
+	if _, err := s.agentVersion(ctx, version.AgentID, version.Version); err == nil {
+		return errors.New("version already exists for this agent")
+	} else if err.Error() != "version not found for this agent" {
+		return err
+	}
+
+	now := time.Now()
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "INSERT INTO agent_versions ("+versionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id.Hex(), version.AgentID.Hex(), version.Version, version.Cluster, version.Status, stringList(version.Tools), stringList(version.Models), version.Deployment, now, now)
+	if err != nil {
+		return err
+	}
+
+	version.ID = id
+	version.CreatedAt = now
+	version.UpdatedAt = now
+
+	s.events.Publish(events.Event{
+		Type:     events.VersionRegistered,
+		Database: s.dialect.name,
+		Version:  version,
+	})
+	return nil
+}
+
+// GetAgentVersions retrieves all versions for an agent
+func (s *Store) GetAgentVersions(ctx context.Context, agentID primitive.ObjectID, listOpts db.ListOptions) ([]models.AgentVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+	page, pageArgs := s.dialect.limitOffset(listOpts.Limit, 0)
+
+	rows, err := s.query(ctx, "SELECT "+versionColumns+" FROM agent_versions WHERE agent_id = ?"+orderBy(versionSortColumns, listOpts.Sort, "version DESC", "id")+page,
+		append([]interface{}{agentID.Hex()}, pageArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.AgentVersion{}
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+	return versions, rows.Err()
+}
+
+// GetAgentVersion retrieves a specific version for an agent
+func (s *Store) GetAgentVersion(ctx context.Context, agentID primitive.ObjectID, version string) (*models.AgentVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	return s.agentVersion(ctx, agentID, version)
+}
+
+// agentVersion looks up a version of an agent
+func (s *Store) agentVersion(ctx context.Context, agentID primitive.ObjectID, version string) (*models.AgentVersion, error) {
+	agentVersion, err := scanVersion(s.queryRow(ctx, "SELECT "+versionColumns+" FROM agent_versions WHERE agent_id = ? AND version = ?", agentID.Hex(), version))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("version not found for this agent")
+	}
+	return agentVersion, err
+}
+
+// CreateIngestToken stores an ingest token
+func (s *Store) CreateIngestToken(ctx context.Context, token *models.IngestToken) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	token.CreatedAt = time.Now()
+	_, err := s.exec(ctx, "INSERT INTO ingest_tokens (hash, agent_id, version, created_at) VALUES (?, ?, ?, ?)",
+		token.Hash, token.AgentID.Hex(), token.Version, token.CreatedAt)
+	return err
+}
+
+// GetIngestToken retrieves an ingest token by the hash of the token
+func (s *Store) GetIngestToken(ctx context.Context, hash string) (*models.IngestToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	token := models.IngestToken{Hash: hash}
+	var agentID string
+	err := s.queryRow(ctx, "SELECT agent_id, version, created_at FROM ingest_tokens WHERE hash = ?", hash).
+		Scan(&agentID, &token.Version, &token.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("ingest token not found")
+		}
+		return nil, err
+	}
+
+	token.AgentID = objectID(agentID)
+	return &token, nil
+}
+
+const teamColumns = "id, name, email, on_call, slack_channel, created_at, updated_at"
+
+// scanTeam reads a row of teamColumns
+func scanTeam(row scanner) (*models.Team, error) {
+	var team models.Team
+	var id string
+	if err := row.Scan(&id, &team.Name, &team.Email, &team.OnCall, &team.SlackChannel, &team.CreatedAt, &team.UpdatedAt); err != nil {
+		return nil, err
+	}
+	team.ID = objectID(id)
+	return &team, nil
+}
+
+// CreateTeam creates a new team, unless a team with the same name exists
+func (s *Store) CreateTeam(ctx context.Context, team *models.Team) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var count int64
+	if err := s.queryRow(ctx, "SELECT COUNT(*) FROM teams WHERE name = ?", team.Name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("team already exists")
+	}
+
+	now := time.Now()
+	id := primitive.NewObjectID()
+	_, err := s.exec(ctx, "INSERT INTO teams ("+teamColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		id.Hex(), team.Name, team.Email, team.OnCall, team.SlackChannel, now, now)
+	if err != nil {
+		return err
+	}
+
+	team.ID = id
+	team.CreatedAt = now
+	team.UpdatedAt = now
+	return nil
+}
+
+// GetTeam retrieves a team by name
+func (s *Store) GetTeam(ctx context.Context, name string) (*models.Team, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	team, err := scanTeam(s.queryRow(ctx, "SELECT "+teamColumns+" FROM teams WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("team not found")
+	}
+	return team, err
+}
+
+// ListTeams retrieves all teams, sorted by name
+func (s *Store) ListTeams(ctx context.Context) ([]models.Team, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT "+teamColumns+" FROM teams ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []models.Team{}
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, *team)
+	}
+	return teams, rows.Err()
+}
+
+// DeleteTeam deletes a team by name, unless it owns agents
+func (s *Store) DeleteTeam(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var count int64
+	if err := s.queryRow(ctx, "SELECT COUNT(*) FROM agents WHERE team = ?", name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("team owns agents")
+	}
+
+	result, err := s.exec(ctx, "DELETE FROM teams WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return errors.New("team not found")
+	}
+	return nil
+}
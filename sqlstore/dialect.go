@@ -0,0 +1,130 @@
+package sqlstore
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Postgres is the name of the PostgreSQL storage backend
+	Postgres = "postgres"
+	// SQLite is the name of the embedded SQLite storage backend
+	SQLite = "sqlite"
+)
+
+// dialect holds what differs between the SQL databases the store runs on: the driver, the column types of the
+// migrations, the placeholders of the queries and JSON list lookups
+type dialect struct {
+	name   string
+	driver string
+	// types replaces the {time}, {json}, {bool} and {float} column types of the migrations
+	types *strings.Replacer
+	// numbered dialects use $1, $2... placeholders instead of ?
+	numbered bool
+	// jsonContains returns the condition that a JSON list column contains the string of a placeholder
+	jsonContains func(column string) string
+	// unlimited is the LIMIT of a query with an OFFSET but no limit
+	unlimited string
+	// utc dialects compare times as text, so times are written in UTC to sort as text does
+	utc bool
+	// maxOpenConns limits the connections to the database, unlimited when zero
+	maxOpenConns int
+	// defaultDSN is the connection string used when none is configured, none is required when empty
+	defaultDSN string
+}
+
+// dialects are the SQL storage backends, by name
+var dialects = map[string]*dialect{
+	Postgres: {
+		name:   Postgres,
+		driver: "pgx",
+		types: strings.NewReplacer(
+			"{time}", "TIMESTAMPTZ",
+			"{json}", "JSONB",
+			"{bool}", "BOOLEAN",
+			"{float}", "DOUBLE PRECISION",
+		),
+		numbered: true,
+		jsonContains: func(column string) string {
+			return column + " @> to_jsonb(?::text)"
+		},
+		unlimited: "ALL",
+	},
+	SQLite: {
+		name:   SQLite,
+		driver: "sqlite3",
+		types: strings.NewReplacer(
+			"{time}", "TIMESTAMP",
+			"{json}", "TEXT",
+			"{bool}", "BOOLEAN",
+			"{float}", "REAL",
+		),
+		jsonContains: func(column string) string {
+			return "EXISTS (SELECT 1 FROM json_each(" + column + ") WHERE json_each.value = ?)"
+		},
+		unlimited: "-1",
+		utc:       true,
+		// SQLite allows a single writer, and an in-memory database lives in its connection
+		maxOpenConns: 1,
+		defaultDSN:   "ripple.db",
+	},
+}
+
+// rebind rewrites the ? placeholders of a query to the placeholders of the dialect
+func (d *dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// values converts the values of the placeholders of a query to the values the dialect stores
+func (d *dialect) values(args []interface{}) []interface{} {
+	if !d.utc {
+		return args
+	}
+
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC()
+		case *time.Time:
+			if v != nil {
+				converted[i] = v.UTC()
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// limitOffset builds the LIMIT and OFFSET clauses of a listing, zero meaning no limit and no offset
+func (d *dialect) limitOffset(limit, offset int64) (string, []interface{}) {
+	var clause string
+	var args []interface{}
+	if limit > 0 {
+		clause += " LIMIT ?"
+		args = append(args, limit)
+	} else if offset > 0 {
+		clause += " LIMIT " + d.unlimited
+	}
+	if offset > 0 {
+		clause += " OFFSET ?"
+		args = append(args, offset)
+	}
+	return clause, args
+}
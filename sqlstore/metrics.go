@@ -0,0 +1,273 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const metricsColumns = "version_id, metrics_window, environment, cluster, name, project, team, status, last_seen, version, avg_runtime, " +
+	"success_rate, total_runs, spend, spend_today, spend_7d, spend_30d, spend_total, tools, models, health, health_reasons, errors"
+
+// metricsSortColumns are the sortable JSON fields of agent version metrics and their columns
+var metricsSortColumns = map[string]string{
+	"id":          "version_id",
+	"window":      "metrics_window",
+	"environment": "environment",
+	"name":        "name",
+	"project":     "project",
+	"team":        "team",
+	"status":      "status",
+	"lastSeen":    "last_seen",
+	"version":     "version",
+	"avgRuntime":  "avg_runtime",
+	"successRate": "success_rate",
+	"totalRuns":   "total_runs",
+	"spend":       "spend",
+	"spendToday":  "spend_today",
+	"spend7d":     "spend_7d",
+	"spend30d":    "spend_30d",
+	"spendTotal":  "spend_total",
+	"cluster":     "cluster",
+	"health":      "health",
+}
+
+// scanMetrics reads a row of metricsColumns
+func scanMetrics(row scanner) (*models.AgentVersionMetrics, error) {
+	var m models.AgentVersionMetrics
+	var versionID, tools, modelNames, healthReasons, errorCounts string
+	err := row.Scan(&versionID, &m.Window, &m.Environment, &m.Cluster, &m.Name, &m.Project, &m.Team, &m.Status, &m.LastSeen, &m.Version,
+		&m.AverageRunTime, &m.SuccessRate, &m.TotalRuns, &m.Spend, &m.SpendToday, &m.Spend7d, &m.Spend30d, &m.SpendTotal, &tools, &modelNames,
+		&m.Health, &healthReasons, &errorCounts)
+	if err != nil {
+		return nil, err
+	}
+	m.Id = objectID(versionID)
+	if err := parseJSON(tools, &m.Tools); err != nil {
+		return nil, err
+	}
+	if err := parseJSON(modelNames, &m.Models); err != nil {
+		return nil, err
+	}
+	if err := parseJSON(healthReasons, &m.HealthReasons); err != nil {
+		return nil, err
+	}
+	if err := parseJSON(errorCounts, &m.Errors); err != nil {
+		return nil, err
+	}
+	if len(m.HealthReasons) == 0 {
+		m.HealthReasons = nil
+	}
+	return &m, nil
+}
+
+// ListAllAgents lists every agent, archived ones included
+func (s *Store) ListAllAgents(ctx context.Context) ([]*models.Agent, error) {
+	rows, err := s.query(ctx, "SELECT "+agentColumns+" FROM agents")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []*models.Agent{}
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
+
+// ListAllAgentVersions lists the versions of every agent
+func (s *Store) ListAllAgentVersions(ctx context.Context) ([]*models.AgentVersion, error) {
+	rows, err := s.query(ctx, "SELECT "+versionColumns+" FROM agent_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*models.AgentVersion{}
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// ComputeAgentVersionMetrics aggregates the runs of an agent version. Spend windows end at now, and the daily spend
+// starts at midnight in the timezone of now.
+func (s *Store) ComputeAgentVersionMetrics(ctx context.Context, agent *models.Agent, agentVersion *models.AgentVersion, now time.Time) (*models.AgentVersionMetrics, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	recentStart := now.Add(-models.HealthRecentWindow)
+	baselineStart := recentStart.Add(-models.HealthBaselineWindow)
+	failed := "status NOT IN ('" + models.RunStatusCompleted + "', '" + models.RunStatusRunning + "')"
+
+	var count, errorCount int64
+	var avgTimeTaken, totalCost, spendToday, spend7d, spend30d float64
+	var lastSeen timeValue
+	var signals models.HealthSignals
+	var recentTime, baselineTime float64
+	err := s.queryRow(ctx, `SELECT COUNT(*), COUNT(CASE WHEN status = 'error' THEN 1 END), MAX(recorded_at),
+			COALESCE(AVG(time_taken), 0), COALESCE(SUM(cost), 0),
+			COALESCE(SUM(CASE WHEN created >= ? AND created < ? THEN cost ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN created >= ? AND created < ? THEN cost ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN created >= ? AND created < ? THEN cost ELSE 0 END), 0),
+			COUNT(CASE WHEN created >= ? AND created < ? THEN 1 END),
+			COUNT(CASE WHEN created >= ? AND created < ? AND `+failed+` THEN 1 END),
+			COALESCE(SUM(CASE WHEN created >= ? AND created < ? THEN time_taken ELSE 0 END), 0),
+			COUNT(CASE WHEN created >= ? AND created < ? THEN 1 END),
+			COALESCE(SUM(CASE WHEN created >= ? AND created < ? THEN time_taken ELSE 0 END), 0)
+		FROM agent_runs WHERE version_id = ?`,
+		today, now, now.AddDate(0, 0, -7), now, now.AddDate(0, 0, -30), now,
+		recentStart, now, recentStart, now, recentStart, now,
+		baselineStart, recentStart, baselineStart, recentStart,
+		agentVersion.ID.Hex(),
+	).Scan(&count, &errorCount, &lastSeen, &avgTimeTaken, &totalCost, &spendToday, &spend7d, &spend30d,
+		&signals.RecentRuns, &signals.RecentErrors, &recentTime, &signals.BaselineRuns, &baselineTime)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch metrics: %w", err)
+	}
+	if count == 0 || !lastSeen.Valid {
+		return nil, errors.New("unable to fetch last seen time: no runs")
+	}
+
+	errorsByCategory, err := s.countErrorsByCategory(ctx, agentVersion.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	signals.LastSeen = lastSeen.Time
+	if signals.RecentRuns > 0 {
+		signals.RecentAvgTime = recentTime / float64(signals.RecentRuns)
+	}
+	if signals.BaselineRuns > 0 {
+		signals.BaselineAvgTime = baselineTime / float64(signals.BaselineRuns)
+	}
+	health, healthReasons := models.ComputeHealth(signals, now)
+
+	return &models.AgentVersionMetrics{
+		Id:             agentVersion.ID,
+		Window:         models.MetricsWindowAll,
+		Environment:    models.MetricsEnvironmentDefault,
+		Name:           agent.Name,
+		Project:        agent.Project,
+		Team:           agent.Team,
+		Status:         agentVersion.Status,
+		LastSeen:       lastSeen.Time,
+		Version:        agentVersion.Version,
+		AverageRunTime: avgTimeTaken,
+		SuccessRate:    (float64(count-errorCount) / float64(count)) * 100,
+		TotalRuns:      count,
+		Spend:          totalCost,
+		SpendToday:     spendToday,
+		Spend7d:        spend7d,
+		Spend30d:       spend30d,
+		SpendTotal:     totalCost,
+		Tools:          agentVersion.Tools,
+		Models:         agentVersion.Models,
+		Cluster:        agentVersion.Cluster,
+		Errors:         errorsByCategory,
+		Health:         health,
+		HealthReasons:  healthReasons,
+	}, nil
+}
+
+// errorCategory is the expression of the error category of a run, see models.AgentRun.ErrorCategory
+const errorCategory = "CASE WHEN error_type <> '' THEN error_type ELSE status END"
+
+// countErrorsByCategory counts the failed runs of an agent version by error category
+func (s *Store) countErrorsByCategory(ctx context.Context, versionID primitive.ObjectID) (map[string]int64, error) {
+	rows, err := s.query(ctx, "SELECT "+errorCategory+", COUNT(*) FROM agent_runs WHERE version_id = ? AND status NOT IN (?, ?) GROUP BY "+errorCategory,
+		versionID.Hex(), models.RunStatusCompleted, models.RunStatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("unable to count errors by category: %w", err)
+	}
+	defer rows.Close()
+
+	errorsByCategory := map[string]int64{}
+	for rows.Next() {
+		var category string
+		var count int64
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, fmt.Errorf("unable to decode errors by category: %w", err)
+		}
+		errorsByCategory[category] = count
+	}
+	return errorsByCategory, rows.Err()
+}
+
+// UpsertAgentVersionMetrics writes the metrics of an agent version, keyed by version, window, environment and cluster
+func (s *Store) UpsertAgentVersionMetrics(ctx context.Context, avm *models.AgentVersionMetrics) error {
+	errorCounts := avm.Errors
+	if errorCounts == nil {
+		errorCounts = map[string]int64{}
+	}
+	encodedErrors, err := jsonValue(errorCounts)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.exec(ctx, "INSERT INTO agent_version_metrics ("+metricsColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (version_id, metrics_window, environment, cluster) DO UPDATE SET
+			name = excluded.name, project = excluded.project, team = excluded.team, status = excluded.status,
+			last_seen = excluded.last_seen, version = excluded.version, avg_runtime = excluded.avg_runtime,
+			success_rate = excluded.success_rate, total_runs = excluded.total_runs, spend = excluded.spend,
+			spend_today = excluded.spend_today, spend_7d = excluded.spend_7d, spend_30d = excluded.spend_30d,
+			spend_total = excluded.spend_total, tools = excluded.tools, models = excluded.models, health = excluded.health,
+			health_reasons = excluded.health_reasons, errors = excluded.errors`,
+		avm.Id.Hex(), avm.Window, avm.Environment, avm.Cluster, avm.Name, avm.Project, avm.Team, avm.Status, avm.LastSeen, avm.Version,
+		avm.AverageRunTime, avm.SuccessRate, avm.TotalRuns, avm.Spend, avm.SpendToday, avm.Spend7d, avm.Spend30d, avm.SpendTotal,
+		stringList(avm.Tools), stringList(avm.Models), avm.Health, stringList(avm.HealthReasons), encodedErrors)
+	return err
+}
+
+// EnsureMetricsSchema applies the migrations the database is missing, the metrics table included. Metrics are keyed
+// by version, window, environment and cluster from the first migration, so no metrics are ever migrated.
+func (s *Store) EnsureMetricsSchema(ctx context.Context) (int, error) {
+	if _, err := s.migrate(ctx); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// DryRun computes the metrics of all agent versions without writing them and returns the differences with the
+// stored metrics. Daily spend follows the reporting timezones.
+func (s *Store) DryRun(ctx context.Context, timezones *db.Timezones) (*db.MetricsDryRun, error) {
+	stored, err := s.queryMetrics(ctx, "SELECT "+metricsColumns+" FROM agent_version_metrics")
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch agent version metrics: %w", err)
+	}
+
+	return db.ComputeMetricsDryRun(ctx, s, stored, timezones)
+}
+
+// queryMetrics loads the metrics selected by a query over agent_version_metrics returning metricsColumns
+func (s *Store) queryMetrics(ctx context.Context, query string, args ...interface{}) ([]models.AgentVersionMetrics, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []models.AgentVersionMetrics{}
+	for rows.Next() {
+		m, err := scanMetrics(rows)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, *m)
+	}
+	return metrics, rows.Err()
+}
@@ -0,0 +1,215 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// migration is a versioned schema change. Migrations are applied in order, each in a transaction, and recorded in
+// the schema_migrations table so that they run once.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// migrations are the schema changes of the store. Column types in braces are replaced with the types of the
+// dialect. Released migrations must not be changed, schema changes go in new migrations.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create agents, versions, runs, steps, ingest tokens, teams and metrics",
+		statements: []string{
+			`CREATE TABLE agents (
+				id CHAR(24) PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				project TEXT NOT NULL DEFAULT '',
+				team TEXT NOT NULL DEFAULT '',
+				archived {bool} NOT NULL DEFAULT FALSE,
+				archived_at {time},
+				signing_secret TEXT NOT NULL DEFAULT '',
+				created_at {time} NOT NULL,
+				updated_at {time} NOT NULL
+			)`,
+			`CREATE INDEX agents_project_idx ON agents (project)`,
+			`CREATE INDEX agents_team_idx ON agents (team)`,
+
+			`CREATE TABLE agent_versions (
+				id CHAR(24) PRIMARY KEY,
+				agent_id CHAR(24) NOT NULL REFERENCES agents (id),
+				version TEXT NOT NULL,
+				cluster TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT '',
+				tools {json} NOT NULL DEFAULT '[]',
+				models {json} NOT NULL DEFAULT '[]',
+				deployment TEXT NOT NULL DEFAULT '',
+				created_at {time} NOT NULL,
+				updated_at {time} NOT NULL,
+				UNIQUE (agent_id, version)
+			)`,
+			`CREATE INDEX agent_versions_cluster_idx ON agent_versions (cluster)`,
+
+			`CREATE TABLE agent_runs (
+				id CHAR(24) PRIMARY KEY,
+				agent_id CHAR(24) NOT NULL REFERENCES agents (id),
+				version_id CHAR(24) NOT NULL REFERENCES agent_versions (id),
+				version TEXT NOT NULL,
+				created {time} NOT NULL,
+				status TEXT NOT NULL,
+				time_taken {float} NOT NULL DEFAULT 0,
+				initiator TEXT NOT NULL DEFAULT '',
+				tools {json} NOT NULL DEFAULT '[]',
+				cost {float} NOT NULL DEFAULT 0,
+				models {json} NOT NULL DEFAULT '[]',
+				run_id BIGINT NOT NULL,
+				task_id BIGINT NOT NULL DEFAULT 0,
+				recorded_at {time} NOT NULL,
+				trace_id TEXT NOT NULL DEFAULT '',
+				span_id TEXT NOT NULL DEFAULT '',
+				error_type TEXT NOT NULL DEFAULT '',
+				error_message TEXT NOT NULL DEFAULT '',
+				prompt_tokens BIGINT NOT NULL DEFAULT 0,
+				completion_tokens BIGINT NOT NULL DEFAULT 0,
+				schema_version INTEGER NOT NULL DEFAULT 0
+			)`,
+			// Runs are listed per agent and per version newest first, looked up by run ID and tailed by recorded time
+			`CREATE INDEX agent_runs_agent_created_idx ON agent_runs (agent_id, created)`,
+			`CREATE INDEX agent_runs_agent_run_id_idx ON agent_runs (agent_id, run_id)`,
+			`CREATE INDEX agent_runs_agent_recorded_idx ON agent_runs (agent_id, recorded_at, id)`,
+			`CREATE INDEX agent_runs_version_created_idx ON agent_runs (version_id, created)`,
+			// The dashboard aggregates the runs of a time range, and autoscaling counts the recent and running runs
+			`CREATE INDEX agent_runs_created_idx ON agent_runs (created, id)`,
+			`CREATE INDEX agent_runs_recorded_idx ON agent_runs (recorded_at)`,
+			`CREATE INDEX agent_runs_running_idx ON agent_runs (status) WHERE status = 'running'`,
+
+			`CREATE TABLE run_steps (
+				id CHAR(24) PRIMARY KEY,
+				trace_id TEXT NOT NULL,
+				span_id TEXT NOT NULL DEFAULT '',
+				parent_span_id TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL DEFAULT '',
+				kind TEXT NOT NULL DEFAULT '',
+				started {time} NOT NULL,
+				time_taken {float} NOT NULL DEFAULT 0,
+				status TEXT NOT NULL DEFAULT '',
+				tool TEXT NOT NULL DEFAULT '',
+				model TEXT NOT NULL DEFAULT '',
+				cost {float} NOT NULL DEFAULT 0,
+				prompt_tokens BIGINT NOT NULL DEFAULT 0,
+				completion_tokens BIGINT NOT NULL DEFAULT 0,
+				attributes {json} NOT NULL DEFAULT '{}',
+				recorded_at {time} NOT NULL
+			)`,
+			`CREATE INDEX run_steps_trace_idx ON run_steps (trace_id, started)`,
+
+			`CREATE TABLE ingest_tokens (
+				hash TEXT PRIMARY KEY,
+				agent_id CHAR(24) NOT NULL REFERENCES agents (id),
+				version TEXT NOT NULL DEFAULT '',
+				created_at {time} NOT NULL
+			)`,
+
+			`CREATE TABLE teams (
+				id CHAR(24) PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				email TEXT NOT NULL DEFAULT '',
+				on_call TEXT NOT NULL DEFAULT '',
+				slack_channel TEXT NOT NULL DEFAULT '',
+				created_at {time} NOT NULL,
+				updated_at {time} NOT NULL
+			)`,
+
+			`CREATE TABLE agent_version_metrics (
+				version_id CHAR(24) NOT NULL,
+				metrics_window TEXT NOT NULL,
+				environment TEXT NOT NULL,
+				cluster TEXT NOT NULL,
+				name TEXT NOT NULL,
+				project TEXT NOT NULL DEFAULT '',
+				team TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT '',
+				last_seen {time} NOT NULL,
+				version TEXT NOT NULL,
+				avg_runtime {float} NOT NULL DEFAULT 0,
+				success_rate {float} NOT NULL DEFAULT 0,
+				total_runs BIGINT NOT NULL DEFAULT 0,
+				spend {float} NOT NULL DEFAULT 0,
+				spend_today {float} NOT NULL DEFAULT 0,
+				spend_7d {float} NOT NULL DEFAULT 0,
+				spend_30d {float} NOT NULL DEFAULT 0,
+				spend_total {float} NOT NULL DEFAULT 0,
+				tools {json} NOT NULL DEFAULT '[]',
+				models {json} NOT NULL DEFAULT '[]',
+				health TEXT NOT NULL DEFAULT '',
+				health_reasons {json} NOT NULL DEFAULT '[]',
+				errors {json} NOT NULL DEFAULT '{}',
+				PRIMARY KEY (version_id, metrics_window, environment, cluster)
+			)`,
+			`CREATE INDEX agent_version_metrics_name_idx ON agent_version_metrics (name, version)`,
+			`CREATE INDEX agent_version_metrics_project_idx ON agent_version_metrics (project)`,
+		},
+	},
+}
+
+// migrate applies the migrations that were not applied yet, and returns how many it applied. Servers starting at the
+// same time may race on a migration, in which case all but one fail on the version already being recorded and
+// succeed once restarted.
+func (s *Store) migrate(ctx context.Context) (int, error) {
+	if _, err := s.db.ExecContext(ctx, s.dialect.types.Replace(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at {time} NOT NULL
+	)`)); err != nil {
+		return 0, fmt.Errorf("unable to create the schema_migrations table: %w", err)
+	}
+
+	applied := map[int]bool{}
+	rows, err := s.db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the applied migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return 0, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := s.apply(ctx, m); err != nil {
+			return count, fmt.Errorf("unable to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// apply runs the statements of a migration and records it, in a transaction
+func (s *Store) apply(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements {
+		if _, err := tx.ExecContext(ctx, s.dialect.types.Replace(statement)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), s.dialect.values([]interface{}{m.version, m.name, time.Now()})...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,677 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/events"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const runColumns = "id, agent_id, version_id, version, created, status, time_taken, initiator, tools, cost, models, run_id, task_id, " +
+	"recorded_at, trace_id, span_id, error_type, error_message, prompt_tokens, completion_tokens, schema_version"
+
+// runSortColumns are the sortable JSON fields of runs and their columns
+var runSortColumns = map[string]string{
+	"id":                "id",
+	"agent_id":          "agent_id",
+	"version_id":        "version_id",
+	"version":           "version",
+	"created":           "created",
+	"status":            "status",
+	"time_taken":        "time_taken",
+	"initiator":         "initiator",
+	"cost":              "cost",
+	"run_id":            "run_id",
+	"task_id":           "task_id",
+	"recorded_at":       "recorded_at",
+	"trace_id":          "trace_id",
+	"error_type":        "error_type",
+	"prompt_tokens":     "prompt_tokens",
+	"completion_tokens": "completion_tokens",
+}
+
+// scanRun reads a row of runColumns
+func scanRun(row scanner) (*models.AgentRun, error) {
+	var run models.AgentRun
+	var id, agentID, versionID, tools, modelNames string
+	err := row.Scan(&id, &agentID, &versionID, &run.Version, &run.Created, &run.Status, &run.TimeTaken, &run.Initiator, &tools, &run.Cost,
+		&modelNames, &run.RunID, &run.TaskID, &run.RecordedAt, &run.TraceID, &run.SpanID, &run.ErrorType, &run.ErrorMessage,
+		&run.PromptTokens, &run.CompletionTokens, &run.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	run.ID = objectID(id)
+	run.AgentID = objectID(agentID)
+	run.VersionID = objectID(versionID)
+	if err := parseJSON(tools, &run.Tools); err != nil {
+		return nil, err
+	}
+	if err := parseJSON(modelNames, &run.Models); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// runValues returns the values of runColumns of a run
+func runValues(run *models.AgentRun) []interface{} {
+	return []interface{}{
+		run.ID.Hex(), run.AgentID.Hex(), run.VersionID.Hex(), run.Version, run.Created, run.Status, run.TimeTaken, run.Initiator,
+		stringList(run.Tools), run.Cost, stringList(run.Models), run.RunID, run.TaskID, run.RecordedAt, run.TraceID, run.SpanID,
+		run.ErrorType, run.ErrorMessage, run.PromptTokens, run.CompletionTokens, run.SchemaVersion,
+	}
+}
+
+// queryRuns loads the runs selected by a query over agent_runs returning runColumns
+func (s *Store) queryRuns(ctx context.Context, query string, args ...interface{}) ([]models.AgentRun, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.AgentRun{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+// CreateAgentRun creates a new agent run
+func (s *Store) CreateAgentRun(ctx context.Context, run *models.AgentRun) error {
+	return s.CreateAgentRunBatch(ctx, []*models.AgentRun{run}, db.BatchOptions{})
+}
+
+// CreateAgentRunBatch creates multiple agent runs in a transaction, so that either all or none of them are stored,
+// whether the batch is ordered or not
+func (s *Store) CreateAgentRunBatch(ctx context.Context, runs []*models.AgentRun, opts db.BatchOptions) error {
+	if len(runs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec*2)*time.Second)
+	defer cancel()
+
+	// Versions are looked up once per batch, runs of a batch usually share theirs
+	type versionKey struct {
+		agentID primitive.ObjectID
+		version string
+	}
+	versions := map[versionKey]primitive.ObjectID{}
+	for _, run := range runs {
+		key := versionKey{run.AgentID, run.Version}
+		if _, ok := versions[key]; ok {
+			continue
+		}
+		if _, err := s.agentByID(ctx, run.AgentID); err != nil {
+			return err
+		}
+		version, err := s.agentVersion(ctx, run.AgentID, run.Version)
+		if err != nil {
+			return err
+		}
+		versions[key] = version.ID
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.rebind("INSERT INTO agent_runs ("+runColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	ids := make([]primitive.ObjectID, len(runs))
+	for i, run := range runs {
+		// Runs accepted by the run buffer were given their ID already
+		ids[i] = run.ID
+		if ids[i].IsZero() {
+			ids[i] = primitive.NewObjectID()
+		}
+		values := runValues(run)
+		values[0] = ids[i].Hex()
+		values[2] = versions[versionKey{run.AgentID, run.Version}].Hex()
+		values[13] = now
+		if _, err := stmt.ExecContext(ctx, s.dialect.values(values)...); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, run := range runs {
+		run.ID = ids[i]
+		run.VersionID = versions[versionKey{run.AgentID, run.Version}]
+		run.RecordedAt = now
+		s.publishRunCreated(run)
+	}
+	return nil
+}
+
+// publishRunCreated notifies subscribers that a run was recorded, and that it failed when it has the error status
+func (s *Store) publishRunCreated(run *models.AgentRun) {
+	s.events.Publish(events.Event{Type: events.RunCreated, Database: s.dialect.name, Run: run})
+	if run.Status == "error" {
+		s.events.Publish(events.Event{Type: events.RunFailed, Database: s.dialect.name, Run: run})
+	}
+}
+
+// GetAgentRuns retrieves all runs for an agent
+func (s *Store) GetAgentRuns(ctx context.Context, agentID primitive.ObjectID, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id = ?", agentID.Hex())
+	return s.listRuns(ctx, where, listOpts)
+}
+
+// GetAgentVersionRuns retrieves all runs for a specific agent version
+func (s *Store) GetAgentVersionRuns(ctx context.Context, agentID primitive.ObjectID, version string, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+	agentVersion, err := s.agentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id = ?", agentID.Hex())
+	where.add("version_id = ?", agentVersion.ID.Hex())
+	return s.listRuns(ctx, where, listOpts)
+}
+
+// QueryRuns retrieves the runs matching a run query created in [from, to), newest first unless sorted otherwise.
+// Zero times leave the range open.
+func (s *Store) QueryRuns(ctx context.Context, query models.RunQuery, from, to time.Time, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	if query.AgentID != nil {
+		where.add("agent_id = ?", query.AgentID.Hex())
+	}
+	if query.Version != "" {
+		where.add("version = ?", query.Version)
+	}
+	if len(query.Statuses) > 0 {
+		where.in("status", query.Statuses)
+	}
+	if query.ErrorType != "" {
+		where.add("error_type = ?", query.ErrorType)
+	}
+	if query.Initiator != "" {
+		where.add("initiator = ?", query.Initiator)
+	}
+	if query.Tool != "" {
+		where.add(s.dialect.jsonContains("tools"), query.Tool)
+	}
+	if query.Model != "" {
+		where.add(s.dialect.jsonContains("models"), query.Model)
+	}
+	if !from.IsZero() {
+		where.add("created >= ?", from)
+	}
+	if !to.IsZero() {
+		where.add("created < ?", to)
+	}
+	return s.listRuns(ctx, where, listOpts)
+}
+
+// listRuns lists the runs matching the conditions, newest first unless sorted otherwise
+func (s *Store) listRuns(ctx context.Context, where conditions, listOpts db.ListOptions) ([]models.AgentRun, error) {
+	page, pageArgs := s.dialect.limitOffset(listOpts.Limit, 0)
+	return s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+orderBy(runSortColumns, listOpts.Sort, "created DESC", "id")+page,
+		append(where.args, pageArgs...)...)
+}
+
+// runFilter adds the conditions of a run filter
+func runFilter(where *conditions, filter db.RunFilter) {
+	if filter.Version != "" {
+		where.add("version = ?", filter.Version)
+	}
+	if filter.Status != "" {
+		where.add("status = ?", filter.Status)
+	}
+}
+
+// GetAgentRunsAfter retrieves the runs of an agent recorded after the given position, oldest first.
+// The position is the recorded timestamp and ID of the last run already seen.
+func (s *Store) GetAgentRunsAfter(ctx context.Context, agentID primitive.ObjectID, filter db.RunFilter, after time.Time, afterID primitive.ObjectID, limit int64) ([]models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	where.add("agent_id = ?", agentID.Hex())
+	where.add("(recorded_at > ? OR (recorded_at = ? AND id > ?))", after, after, afterID.Hex())
+	runFilter(&where, filter)
+	page, pageArgs := s.dialect.limitOffset(limit, 0)
+
+	return s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+" ORDER BY recorded_at, id"+page, append(where.args, pageArgs...)...)
+}
+
+// ExportAgentRuns calls fn with each run of an agent matching the filter, oldest first, reading them from a cursor
+// so exports of any size use constant memory. The export runs until it completes or ctx is cancelled.
+func (s *Store) ExportAgentRuns(ctx context.Context, agentID primitive.ObjectID, filter db.RunFilter, fn func(run *models.AgentRun) error) error {
+	if _, err := s.GetAgentByID(ctx, agentID); err != nil {
+		return err
+	}
+
+	var where conditions
+	where.add("agent_id = ?", agentID.Hex())
+	runFilter(&where, filter)
+
+	rows, err := s.query(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+" ORDER BY created, id", where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(run); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetAgentRun retrieves a run of an agent by its run ID. When the run ID was recorded more than once, the latest
+// run is returned.
+func (s *Store) GetAgentRun(ctx context.Context, agentID primitive.ObjectID, runID int64) (*models.AgentRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	runs, err := s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs WHERE agent_id = ? AND run_id = ? ORDER BY created DESC, id DESC LIMIT 1",
+		agentID.Hex(), runID)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, errors.New("run not found")
+	}
+
+	return &runs[0], nil
+}
+
+// GetExistingRunIDs reports which of the given run IDs are already stored for an agent
+func (s *Store) GetExistingRunIDs(ctx context.Context, agentID primitive.ObjectID, runIDs []int64) (map[int64]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	existing := map[int64]bool{}
+	if len(runIDs) == 0 {
+		return existing, nil
+	}
+
+	var where conditions
+	where.add("agent_id = ?", agentID.Hex())
+	placeholders := make([]string, len(runIDs))
+	for i, id := range runIDs {
+		placeholders[i] = "?"
+		where.args = append(where.args, id)
+	}
+	where.clauses = append(where.clauses, "run_id IN ("+strings.Join(placeholders, ", ")+")")
+
+	rows, err := s.query(ctx, "SELECT DISTINCT run_id FROM agent_runs"+where.where(), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+
+	return existing, rows.Err()
+}
+
+// GetVersionStats summarizes the runs of an agent version created in [from, to)
+func (s *Store) GetVersionStats(ctx context.Context, agentID primitive.ObjectID, version string, from, to time.Time) (*models.VersionStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	agentVersion, err := s.agentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(ctx, `SELECT status, COUNT(*), COALESCE(SUM(time_taken), 0), COALESCE(SUM(cost), 0)
+		FROM agent_runs WHERE version_id = ? AND created >= ? AND created < ? GROUP BY status`,
+		agentVersion.ID.Hex(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &models.VersionStats{Version: version, Errors: map[string]int64{}}
+	var completed int64
+	var timeTaken float64
+	for rows.Next() {
+		var status string
+		var count int64
+		var groupTime, groupCost float64
+		if err := rows.Scan(&status, &count, &groupTime, &groupCost); err != nil {
+			return nil, err
+		}
+		switch status {
+		case models.RunStatusRunning:
+			stats.Running = count
+			continue
+		case models.RunStatusCompleted:
+			completed = count
+		default:
+			stats.Errors[status] = count
+		}
+		stats.Runs += count
+		stats.TotalCost += groupCost
+		timeTaken += groupTime
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	db.FinishVersionStats(stats, completed, timeTaken)
+	if stats.Runs == 0 {
+		return stats, nil
+	}
+
+	// The p95 is the nearest rank among the finished runs sorted by time taken
+	times, err := s.queryFloats(ctx, `SELECT time_taken FROM agent_runs
+		WHERE version_id = ? AND created >= ? AND created < ? AND status <> ? ORDER BY time_taken`,
+		agentVersion.ID.Hex(), from, to, models.RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	stats.P95TimeTaken = db.Percentile(times, 95)
+
+	return stats, nil
+}
+
+// queryFloats loads the values of a query returning a single number column
+func (s *Store) queryFloats(ctx context.Context, query string, args ...interface{}) ([]float64, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []float64
+	for rows.Next() {
+		var value float64
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// GetVersionTimeBuckets aggregates the runs of an agent version created in [from, to) into buckets of the given
+// duration
+func (s *Store) GetVersionTimeBuckets(ctx context.Context, agentID primitive.ObjectID, version string, bucket time.Duration, from, to time.Time) ([]db.TimeBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	agentVersion, err := s.agentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs WHERE version_id = ? AND created >= ? AND created < ?",
+		agentVersion.ID.Hex(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	return timeBuckets(runs, bucket), nil
+}
+
+// timeBuckets aggregates runs into buckets of the given duration, in UTC
+func timeBuckets(runs []models.AgentRun, bucket time.Duration) []db.TimeBucket {
+	byStart := map[time.Time]*db.TimeBucket{}
+	for i := range runs {
+		start := runs[i].Created.UTC().Truncate(bucket)
+		b, ok := byStart[start]
+		if !ok {
+			b = &db.TimeBucket{Start: start}
+			byStart[start] = b
+		}
+		b.Add(&runs[i])
+	}
+
+	buckets := make([]db.TimeBucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+
+	return buckets
+}
+
+// GetLatencyHistogram counts the finished runs of an agent version created in [from, to) per latency bucket. Bounds
+// are the increasing upper bounds of the buckets, in seconds; runs taking at least the last bound are counted in an
+// overflow bucket.
+func (s *Store) GetLatencyHistogram(ctx context.Context, agentID primitive.ObjectID, version string, bounds []float64, from, to time.Time) ([]db.HistogramCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	agentVersion, err := s.agentVersion(ctx, agentID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	times, err := s.queryFloats(ctx, `SELECT time_taken FROM agent_runs
+		WHERE version_id = ? AND created >= ? AND created < ? AND status <> ? AND time_taken >= 0`,
+		agentVersion.ID.Hex(), from, to, models.RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	boundaries := db.HistogramBoundaries(bounds)
+	counts := make([]db.HistogramCount, len(boundaries))
+	for i, lower := range boundaries {
+		counts[i].Min = lower
+	}
+	for _, timeTaken := range times {
+		// The bucket of a run is the last one starting at or below its time taken
+		b := sort.SearchFloat64s(boundaries, timeTaken)
+		if b == len(boundaries) || boundaries[b] != timeTaken {
+			b--
+		}
+		counts[b].Count++
+	}
+
+	return counts, nil
+}
+
+// GetAdoptionBuckets counts the runs of every version of an agent created in [from, to) per time bucket of the
+// given duration
+func (s *Store) GetAdoptionBuckets(ctx context.Context, agentID primitive.ObjectID, bucket time.Duration, from, to time.Time) ([]db.VersionBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(ctx, "SELECT created, version FROM agent_runs WHERE agent_id = ? AND created >= ? AND created < ?", agentID.Hex(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		start   time.Time
+		version string
+	}
+	counts := map[key]int64{}
+	for rows.Next() {
+		var created time.Time
+		var version string
+		if err := rows.Scan(&created, &version); err != nil {
+			return nil, err
+		}
+		counts[key{created.UTC().Truncate(bucket), version}]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]db.VersionBucket, 0, len(counts))
+	for k, runs := range counts {
+		buckets = append(buckets, db.VersionBucket{Start: k.start, Version: k.version, Runs: runs})
+	}
+	// Versions first running in the same bucket are listed in a stable order
+	sort.Slice(buckets, func(i, j int) bool {
+		if !buckets[i].Start.Equal(buckets[j].Start) {
+			return buckets[i].Start.Before(buckets[j].Start)
+		}
+		return buckets[i].Version < buckets[j].Version
+	})
+
+	return buckets, nil
+}
+
+// GetUsageStats computes the usage statistics of the values of a run list field, tools or models, over the finished
+// runs of an agent created in [from, to), optionally of a single version. The most used values come first.
+func (s *Store) GetUsageStats(ctx context.Context, agentID primitive.ObjectID, field string, version string, from, to time.Time) ([]models.UsageStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	if _, err := s.agentByID(ctx, agentID); err != nil {
+		return nil, err
+	}
+
+	var where conditions
+	where.add("agent_id = ?", agentID.Hex())
+	where.add("created >= ?", from)
+	where.add("created < ?", to)
+	if version != "" {
+		where.add("version = ?", version)
+	}
+	runs, err := s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where(), where.args...)
+	if err != nil {
+		return nil, err
+	}
+	return db.UsageStatsOf(runs, field), nil
+}
+
+const stepColumns = "id, trace_id, span_id, parent_span_id, name, kind, started, time_taken, status, tool, model, cost, prompt_tokens, " +
+	"completion_tokens, attributes, recorded_at"
+
+// CreateRunSteps stores the steps of runs
+func (s *Store) CreateRunSteps(ctx context.Context, steps []*models.RunStep) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec*2)*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.rebind("INSERT INTO run_steps ("+stepColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	ids := make([]primitive.ObjectID, len(steps))
+	for i, step := range steps {
+		attributes := step.Attributes
+		if attributes == nil {
+			attributes = map[string]string{}
+		}
+		encoded, err := jsonValue(attributes)
+		if err != nil {
+			return err
+		}
+		ids[i] = primitive.NewObjectID()
+		_, err = stmt.ExecContext(ctx, s.dialect.values([]interface{}{ids[i].Hex(), step.TraceID, step.SpanID, step.ParentSpanID, step.Name,
+			step.Kind, step.Started, step.TimeTaken, step.Status, step.Tool, step.Model, step.Cost, step.PromptTokens, step.CompletionTokens,
+			encoded, now})...)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, step := range steps {
+		step.ID = ids[i]
+		step.RecordedAt = now
+	}
+	return nil
+}
+
+// GetRunSteps retrieves the steps recorded for a trace, in the order they started
+func (s *Store) GetRunSteps(ctx context.Context, traceID string) ([]models.RunStep, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT "+stepColumns+" FROM run_steps WHERE trace_id = ? ORDER BY started, id", traceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := []models.RunStep{}
+	for rows.Next() {
+		var step models.RunStep
+		var id, attributes string
+		err := rows.Scan(&id, &step.TraceID, &step.SpanID, &step.ParentSpanID, &step.Name, &step.Kind, &step.Started, &step.TimeTaken,
+			&step.Status, &step.Tool, &step.Model, &step.Cost, &step.PromptTokens, &step.CompletionTokens, &attributes, &step.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		step.ID = objectID(id)
+		if err := parseJSON(attributes, &step.Attributes); err != nil {
+			return nil, err
+		}
+		if len(step.Attributes) == 0 {
+			step.Attributes = nil
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, rows.Err()
+}
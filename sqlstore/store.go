@@ -0,0 +1,241 @@
+// Package sqlstore stores agents, their versions, runs and metrics in a SQL database, for teams that don't run
+// MongoDB. Counts and sums are aggregated by the database; the statistics that depend on time zones, time buckets
+// or the tool and model lists of runs are computed from the runs of their time range.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/events"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store implements the agent and metrics stores on a SQL database
+type Store struct {
+	db         *sql.DB
+	dialect    *dialect
+	events     *events.Broker
+	timeoutSec int
+}
+
+// UIStore serves the dashboard views computed from the runs of a Store
+type UIStore struct {
+	*Store
+}
+
+var (
+	_ db.AgentStore   = (*Store)(nil)
+	_ db.MetricsStore = (*Store)(nil)
+	_ db.UIStore      = (*UIStore)(nil)
+)
+
+// Open connects to the database of a storage backend, postgres or sqlite, with a connection string, and applies the
+// migrations the database is missing. SQLite stores the database in ripple.db of the working directory without a
+// connection string.
+func Open(storage, dsn string) (*Store, error) {
+	d, ok := dialects[storage]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage %q, expected %s or %s", storage, db.StorageMongo, strings.Join(Storages(), ", "))
+	}
+	if dsn == "" {
+		dsn = d.defaultDSN
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("no connection string for storage %s", storage)
+	}
+
+	conn, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(d.maxOpenConns)
+	s := &Store{
+		db:         conn,
+		dialect:    d,
+		events:     events.NewBroker(),
+		timeoutSec: 10,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to connect to %s: %w", storage, err)
+	}
+
+	// Migrations create tables and indexes, which may take longer than a query
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelMigrate()
+	if _, err := s.migrate(migrateCtx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Storages returns the names of the SQL storage backends, sorted
+func Storages() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the connections to the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// UI returns the dashboard views of the store
+func (s *Store) UI() *UIStore {
+	return &UIStore{s}
+}
+
+// Events returns the broker the store publishes events on
+func (s *Store) Events() *events.Broker {
+	return s.events
+}
+
+// query runs a query with ? placeholders
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.dialect.rebind(query), s.dialect.values(args)...)
+}
+
+// queryRow runs a query with ? placeholders returning at most one row
+func (s *Store) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.dialect.rebind(query), s.dialect.values(args)...)
+}
+
+// exec runs a statement with ? placeholders
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.db.ExecContext(ctx, s.dialect.rebind(query), s.dialect.values(args)...)
+}
+
+// scanner is a row of a query, *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// conditions builds the WHERE clause of a query from conditions with ? placeholders
+type conditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+// add adds a condition and the values of its placeholders
+func (c *conditions) add(clause string, args ...interface{}) {
+	c.clauses = append(c.clauses, clause)
+	c.args = append(c.args, args...)
+}
+
+// in adds the condition that a column has one of the values
+func (c *conditions) in(column string, values []string) {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = "?"
+		c.args = append(c.args, value)
+	}
+	c.clauses = append(c.clauses, column+" IN ("+strings.Join(placeholders, ", ")+")")
+}
+
+// where returns the WHERE clause of the conditions, empty without conditions
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// orderBy builds the ORDER BY clause of the requested JSON fields of a model, from the columns of its fields, falling
+// back to the given order when none of the fields is sortable. The ID column breaks ties so that pages do not overlap.
+func orderBy(columns map[string]string, sortFields []db.SortField, fallback, id string) string {
+	var order []string
+	for _, sf := range sortFields {
+		column, ok := columns[sf.Field]
+		if !ok {
+			continue
+		}
+		if sf.Descending {
+			column += " DESC"
+		}
+		order = append(order, column)
+	}
+	if len(order) == 0 {
+		order = append(order, fallback)
+	}
+	return " ORDER BY " + strings.Join(append(order, id), ", ")
+}
+
+// objectID parses an ID stored as the hex string of an ObjectID
+func objectID(hex string) primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(strings.TrimSpace(hex))
+	return id
+}
+
+// jsonValue encodes a list or map stored in a JSON column
+func jsonValue(v interface{}) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// stringList encodes a list stored in a JSON column, an empty list for nil
+func stringList(list []string) string {
+	if list == nil {
+		return "[]"
+	}
+	raw, _ := json.Marshal(list)
+	return string(raw)
+}
+
+// parseJSON decodes a list or map stored in a JSON column
+func parseJSON(raw string, v interface{}) error {
+	if raw == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), v)
+}
+
+// timeValue scans a nullable time, of a column or of an aggregate of one, which SQLite returns as text
+type timeValue struct {
+	sql.NullTime
+}
+
+// Scan implements sql.Scanner
+func (t *timeValue) Scan(value interface{}) error {
+	text, ok := value.(string)
+	if !ok {
+		return t.NullTime.Scan(value)
+	}
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			t.Time, t.Valid = parsed, true
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to parse time %q", text)
+}
+
+// nullTime returns the time of a nullable column, nil for NULL
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	value := t.Time
+	return &value
+}
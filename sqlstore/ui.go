@@ -0,0 +1,566 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ripple/db"
+	"ripple/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// agents loads every agent by ID, to name the agents of aggregated runs
+func (s *Store) agents(ctx context.Context) (map[primitive.ObjectID]*models.Agent, error) {
+	all, err := s.ListAllAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make(map[primitive.ObjectID]*models.Agent, len(all))
+	for _, agent := range all {
+		agents[agent.ID] = agent
+	}
+	return agents, nil
+}
+
+// agentName returns the name of an agent, empty when the agent does not exist
+func agentName(agents map[primitive.ObjectID]*models.Agent, id primitive.ObjectID) string {
+	if agent, ok := agents[id]; ok {
+		return agent.Name
+	}
+	return ""
+}
+
+// runsCreated loads the runs of all agents created in [from, to)
+func (s *UIStore) runsCreated(ctx context.Context, from, to time.Time) ([]models.AgentRun, error) {
+	return s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs WHERE created >= ? AND created < ?", from, to)
+}
+
+// GetDashboardStats computes the dashboard statistics of the runs selected by a filter, formatted for the given
+// locale. Budgets are not stored in SQL databases, so the statistics have no budget.
+func (s *UIStore) GetDashboardStats(ctx context.Context, locale *db.Locale, filter db.DashboardFilter) ([]db.StatsData, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	windows := filter.Windows(time.Now())
+
+	var where conditions
+	if !filter.IncludeArchived {
+		where.add("NOT a.archived")
+	}
+	if filter.Project != "" {
+		where.add("a.project = ?", filter.Project)
+	}
+	if filter.Team != "" {
+		where.add("a.team = ?", filter.Team)
+	}
+	if filter.Cluster != "" {
+		where.add("r.version_id IN (SELECT id FROM agent_versions WHERE cluster = ?)", filter.Cluster)
+	}
+
+	// Each statistic is computed over its window and the window it is compared to, in a single scan of the runs
+	var args []interface{}
+	from, to := windows.Runs.From, windows.Runs.To
+	for _, w := range []db.StatsWindow{windows.ActiveAgents, windows.Runs, windows.Cost, windows.ResponseTime} {
+		for _, r := range [][2]time.Time{{w.From, w.To}, {w.CompareFrom, w.CompareTo}} {
+			args = append(args, r[0], r[1])
+			if r[0].Before(from) {
+				from = r[0]
+			}
+			if r[1].After(to) {
+				to = r[1]
+			}
+		}
+	}
+	window := "r.created >= ? AND r.created < ?"
+	selects := []string{
+		"COUNT(DISTINCT CASE WHEN " + window + " THEN r.agent_id END)",
+		"COUNT(DISTINCT CASE WHEN " + window + " THEN r.agent_id END)",
+		"COUNT(CASE WHEN " + window + " THEN 1 END)",
+		"COUNT(CASE WHEN " + window + " THEN 1 END)",
+		"COALESCE(SUM(CASE WHEN " + window + " THEN r.cost ELSE 0 END), 0)",
+		"COALESCE(SUM(CASE WHEN " + window + " THEN r.cost ELSE 0 END), 0)",
+		"COALESCE(AVG(CASE WHEN " + window + " THEN r.time_taken END), 0)",
+		"COALESCE(AVG(CASE WHEN " + window + " THEN r.time_taken END), 0)",
+	}
+	where.add("r.created >= ?", from)
+	where.add("r.created < ?", to)
+
+	var counts db.DashboardCounts
+	query := "SELECT " + strings.Join(selects, ", ") + " FROM agent_runs r JOIN agents a ON a.id = r.agent_id" + where.where()
+	err := s.queryRow(ctx, query, append(args, where.args...)...).Scan(
+		&counts.ActiveAgentsNow, &counts.ActiveAgentsLastWeek,
+		&counts.RunsToday, &counts.RunsYesterday,
+		&counts.CostToday, &counts.CostYesterday,
+		&counts.AvgResponseTimeNow, &counts.AvgResponseTimePrev,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard statistics: %w", err)
+	}
+
+	return db.BuildDashboardStats(locale, counts, windows), nil
+}
+
+// GetRecentActivity retrieves the most recent agent runs selected by a filter, newest first. The returned cursor
+// points after the last item when more runs match, and is nil otherwise.
+func (s *UIStore) GetRecentActivity(ctx context.Context, filter db.ActivityFilter) ([]db.ActivityData, *db.ActivityCursor, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	var where conditions
+	if filter.AgentID != nil {
+		where.add("agent_id = ?", filter.AgentID.Hex())
+	}
+	if len(filter.Statuses) > 0 {
+		where.in("status", filter.Statuses)
+	}
+	if after := filter.After; after != nil {
+		where.add("(created < ? OR (created = ? AND id < ?))", after.Created, after.Created, after.RunID.Hex())
+	}
+
+	// One more run than the limit is fetched to know whether there is a next page
+	runs, err := s.queryRuns(ctx, "SELECT "+runColumns+" FROM agent_runs"+where.where()+" ORDER BY created DESC, id DESC LIMIT ?",
+		append(where.args, filter.Limit+1)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query recent activity: %w", err)
+	}
+	agents, err := s.agents(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hasMore := int64(len(runs)) > filter.Limit
+	if hasMore {
+		runs = runs[:filter.Limit]
+	}
+
+	activities := make([]db.ActivityData, 0, len(runs))
+	for i := range runs {
+		activities = append(activities, db.NewActivityData(&runs[i], agentName(agents, runs[i].AgentID)))
+	}
+
+	var next *db.ActivityCursor
+	if hasMore {
+		last := runs[len(runs)-1]
+		next = &db.ActivityCursor{Created: last.Created, RunID: last.ID}
+	}
+	return activities, next, nil
+}
+
+// versionMetricsFilter adds the conditions of a version metrics filter
+func versionMetricsFilter(where *conditions, filter db.VersionMetricsFilter) {
+	if filter.Project != "" {
+		where.add("project = ?", filter.Project)
+	}
+	if filter.Team != "" {
+		where.add("team = ?", filter.Team)
+	}
+	if len(filter.Statuses) > 0 {
+		where.in("status", filter.Statuses)
+	}
+	if filter.Cluster != "" {
+		where.add("cluster = ?", filter.Cluster)
+	}
+	if len(filter.Health) > 0 {
+		where.in("health", filter.Health)
+	}
+}
+
+// GetAgentVersions retrieves the aggregated metrics of the agent versions selected by the filter, by agent name and
+// version unless another sort is requested
+func (s *UIStore) GetAgentVersions(ctx context.Context, filter db.VersionMetricsFilter, listOpts db.ListOptions) ([]models.AgentVersionMetrics, error) {
+	var where conditions
+	versionMetricsFilter(&where, filter)
+	page, pageArgs := s.dialect.limitOffset(listOpts.Limit, listOpts.Offset)
+
+	// The metrics key breaks ties so that pages do not overlap
+	order := orderBy(metricsSortColumns, listOpts.Sort, "name, version", "version_id, metrics_window, environment, cluster")
+	return s.queryMetrics(ctx, "SELECT "+metricsColumns+" FROM agent_version_metrics"+where.where()+order+page, append(where.args, pageArgs...)...)
+}
+
+// CountAgentVersions counts the agent versions with aggregated metrics selected by the filter
+func (s *UIStore) CountAgentVersions(ctx context.Context, filter db.VersionMetricsFilter) (int64, error) {
+	var where conditions
+	versionMetricsFilter(&where, filter)
+
+	var count int64
+	err := s.queryRow(ctx, "SELECT COUNT(*) FROM agent_version_metrics"+where.where(), where.args...).Scan(&count)
+	return count, err
+}
+
+// GetAgentVersionMetrics retrieves the aggregated metrics for a single agent version, over all time and the default environment
+func (s *UIStore) GetAgentVersionMetrics(ctx context.Context, versionID primitive.ObjectID) (*models.AgentVersionMetrics, error) {
+	metrics, err := scanMetrics(s.queryRow(ctx, "SELECT "+metricsColumns+" FROM agent_version_metrics WHERE version_id = ? AND metrics_window = ? AND environment = ?",
+		versionID.Hex(), models.MetricsWindowAll, models.MetricsEnvironmentDefault))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("metrics not found for this version")
+		}
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// GetAutoscalingSignals computes ingest rate, run backlog and per-agent load over the given window.
+// If agentName is not empty, only that agent is included in the per-agent load, and the totals are those of the agent.
+func (s *UIStore) GetAutoscalingSignals(ctx context.Context, window time.Duration, agentName string) (*db.AutoscalingSignals, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	since := now.Add(-window)
+
+	var where conditions
+	where.add("(r.recorded_at >= ? OR r.status = ?)", since, models.RunStatusRunning)
+	if agentName != "" {
+		where.add("a.name = ?", agentName)
+	}
+	rows, err := s.query(ctx, `SELECT a.id, a.name, a.project,
+			COUNT(CASE WHEN r.recorded_at >= ? THEN 1 END) AS runs_in_window,
+			COUNT(CASE WHEN r.status = ? THEN 1 END) AS running
+		FROM agent_runs r JOIN agents a ON a.id = r.agent_id`+where.where()+`
+		GROUP BY a.id, a.name, a.project ORDER BY running DESC, runs_in_window DESC`,
+		append([]interface{}{since, models.RunStatusRunning}, where.args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []db.AgentLoadSignal{}
+	var runsInWindow, backlog int64
+	for rows.Next() {
+		var load db.AgentLoadSignal
+		var id string
+		if err := rows.Scan(&id, &load.Name, &load.Project, &load.RunsInWindow, &load.Running); err != nil {
+			return nil, err
+		}
+		load.AgentID = objectID(id)
+		load.IngestRate = float64(load.RunsInWindow) / window.Seconds()
+		agents = append(agents, load)
+		runsInWindow += load.RunsInWindow
+		backlog += load.Running
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The totals of all agents include the runs of deleted agents, which are not in the per-agent load
+	if agentName == "" {
+		if err := s.queryRow(ctx, "SELECT COUNT(*) FROM agent_runs WHERE recorded_at >= ?", since).Scan(&runsInWindow); err != nil {
+			return nil, err
+		}
+		if err := s.queryRow(ctx, "SELECT COUNT(*) FROM agent_runs WHERE status = ?", models.RunStatusRunning).Scan(&backlog); err != nil {
+			return nil, err
+		}
+	}
+
+	return &db.AutoscalingSignals{
+		GeneratedAt:   now,
+		WindowSeconds: window.Seconds(),
+		RunsInWindow:  runsInWindow,
+		IngestRate:    float64(runsInWindow) / window.Seconds(),
+		Backlog:       backlog,
+		Agents:        agents,
+	}, nil
+}
+
+// GetModelStats computes the usage statistics of the models of the finished runs of all agents created in [from, to),
+// most used first
+func (s *UIStore) GetModelStats(ctx context.Context, from, to time.Time) ([]models.UsageStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	runs, err := s.runsCreated(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return db.UsageStatsOf(runs, db.UsageFieldModels), nil
+}
+
+// GetClusterStats summarizes the runs created in [from, to) by the cluster of their agent version, busiest first
+func (s *UIStore) GetClusterStats(ctx context.Context, from, to time.Time) ([]models.ClusterStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, `SELECT COALESCE(v.cluster, ''), COUNT(DISTINCT r.agent_id), COUNT(DISTINCT r.version_id), COUNT(*),
+			COUNT(CASE WHEN r.status <> ? THEN 1 END), COUNT(CASE WHEN r.status NOT IN (?, ?) THEN 1 END), COALESCE(SUM(r.cost), 0)
+		FROM agent_runs r LEFT JOIN agent_versions v ON v.id = r.version_id
+		WHERE r.created >= ? AND r.created < ? GROUP BY COALESCE(v.cluster, '')`,
+		models.RunStatusRunning, models.RunStatusCompleted, models.RunStatusRunning, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accumulators := []db.ClusterAccumulator{}
+	for rows.Next() {
+		var acc db.ClusterAccumulator
+		if err := rows.Scan(&acc.Cluster, &acc.Agents, &acc.Versions, &acc.Runs, &acc.Finished, &acc.Failed, &acc.Cost); err != nil {
+			return nil, err
+		}
+		accumulators = append(accumulators, acc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return db.ClusterStatsOf(accumulators), nil
+}
+
+// GetHeatmapCells aggregates the runs created in [from, to) by day of the week and hour of the day, in UTC
+func (s *UIStore) GetHeatmapCells(ctx context.Context, from, to time.Time) ([]db.HeatmapCell, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT created, cost FROM agent_runs WHERE created >= ? AND created < ?", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cells [7][24]db.HeatmapCell
+	for rows.Next() {
+		var created time.Time
+		var cost float64
+		if err := rows.Scan(&created, &cost); err != nil {
+			return nil, err
+		}
+		created = created.UTC()
+		cell := &cells[created.Weekday()][created.Hour()]
+		cell.Runs++
+		cell.Cost += cost
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []db.HeatmapCell{}
+	for weekday := range cells {
+		for hour, cell := range cells[weekday] {
+			if cell.Runs == 0 {
+				continue
+			}
+			cell.Weekday = weekday
+			cell.Hour = hour
+			result = append(result, cell)
+		}
+	}
+	return result, nil
+}
+
+// GetTimeBuckets aggregates the runs of all agents created in [from, to) into buckets of the given duration
+func (s *UIStore) GetTimeBuckets(ctx context.Context, bucket time.Duration, from, to time.Time) ([]db.TimeBucket, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	runs, err := s.runsCreated(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return timeBuckets(runs, bucket), nil
+}
+
+// GetLeaderboard ranks the agents, or the agent versions when group is version, by a metric of their runs created
+// in [from, to) and returns the first limit entries
+func (s *UIStore) GetLeaderboard(ctx context.Context, by, group string, from, to time.Time, limit int) ([]models.LeaderboardEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	version, groupBy := "''", "agent_id"
+	if group == models.LeaderboardByVersion {
+		version, groupBy = "version", "agent_id, version"
+	}
+	rows, err := s.query(ctx, "SELECT agent_id, "+version+", COUNT(*), COUNT(CASE WHEN status NOT IN (?, ?) THEN 1 END), COALESCE(SUM(cost), 0)"+
+		" FROM agent_runs WHERE created >= ? AND created < ? GROUP BY "+groupBy,
+		models.RunStatusCompleted, models.RunStatusRunning, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.LeaderboardEntry{}
+	for rows.Next() {
+		var entry models.LeaderboardEntry
+		var id string
+		if err := rows.Scan(&id, &entry.Version, &entry.Runs, &entry.Errors, &entry.TotalCost); err != nil {
+			return nil, err
+		}
+		entry.AgentID = objectID(id)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	agents, err := s.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].AgentName = agentName(agents, entries[i].AgentID)
+	}
+	return db.RankLeaderboard(entries, by, limit), nil
+}
+
+// GetErrorCounts counts the failed runs created in [from, to) by agent and error category
+func (s *UIStore) GetErrorCounts(ctx context.Context, from, to time.Time) ([]db.ErrorCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, "SELECT agent_id, "+errorCategory+", COUNT(*) FROM agent_runs"+
+		" WHERE created >= ? AND created < ? AND status NOT IN (?, ?) GROUP BY agent_id, "+errorCategory,
+		from, to, models.RunStatusCompleted, models.RunStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []db.ErrorCount{}
+	for rows.Next() {
+		var count db.ErrorCount
+		var id string
+		if err := rows.Scan(&id, &count.Category, &count.Count); err != nil {
+			return nil, err
+		}
+		count.AgentID = objectID(id)
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	agents, err := s.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range counts {
+		counts[i].AgentName = agentName(agents, counts[i].AgentID)
+	}
+	return counts, nil
+}
+
+// GetCostBreakdown sums the cost of the runs created in [from, to) by agent, project, team or model. The cost of a
+// run using several models is split evenly between them.
+func (s *UIStore) GetCostBreakdown(ctx context.Context, groupBy string, from, to time.Time) ([]models.CostBreakdownItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	byKey := map[string]*models.CostBreakdownItem{}
+	add := func(key string, item models.CostBreakdownItem, runs int64, cost float64) {
+		acc, ok := byKey[key]
+		if !ok {
+			acc = &item
+			byKey[key] = acc
+		}
+		acc.Runs += runs
+		acc.Cost += cost
+	}
+
+	switch groupBy {
+	case models.CostGroupModel:
+		runs, err := s.runsCreated(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for i := range runs {
+			for model, share := range db.CostShares(&runs[i]) {
+				add(model, models.CostBreakdownItem{Name: model}, 1, share)
+			}
+		}
+	case models.CostGroupAgent, models.CostGroupProject, models.CostGroupTeam:
+		// Runs are summed by agent, then by the project or team of the agent
+		agents, err := s.agents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := s.query(ctx, "SELECT agent_id, COUNT(*), COALESCE(SUM(cost), 0) FROM agent_runs WHERE created >= ? AND created < ? GROUP BY agent_id", from, to)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			var runs int64
+			var cost float64
+			if err := rows.Scan(&id, &runs, &cost); err != nil {
+				return nil, err
+			}
+			agentID := objectID(id)
+			agent, ok := agents[agentID]
+			if !ok {
+				agent = &models.Agent{}
+			}
+			switch groupBy {
+			case models.CostGroupAgent:
+				add(id, models.CostBreakdownItem{AgentID: &agentID, Name: agent.Name}, runs, cost)
+			case models.CostGroupProject:
+				add(agent.Project, models.CostBreakdownItem{Name: agent.Project}, runs, cost)
+			case models.CostGroupTeam:
+				add(agent.Team, models.CostBreakdownItem{Name: agent.Team}, runs, cost)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported cost grouping %q", groupBy)
+	}
+
+	items := make([]models.CostBreakdownItem, 0, len(byKey))
+	for _, item := range byKey {
+		items = append(items, *item)
+	}
+	return items, nil
+}
+
+// GetTokenUsage sums the tokens and cost of the runs created in [from, to) by day, in the given timezone, agent and
+// model. The tokens and cost of a run using several models are split evenly between them.
+func (s *UIStore) GetTokenUsage(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.TokenUsageItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.timeoutSec)*time.Second)
+	defer cancel()
+
+	runs, err := s.runsCreated(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := s.agents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		day     string
+		agentID primitive.ObjectID
+		model   string
+	}
+	byKey := map[key]*models.TokenUsageItem{}
+	for i := range runs {
+		run := &runs[i]
+		day := run.Created.In(loc).Format("2006-01-02")
+		prompt, completion := db.TokenShares(run)
+		for model, cost := range db.CostShares(run) {
+			k := key{day, run.AgentID, model}
+			item, ok := byKey[k]
+			if !ok {
+				item = &models.TokenUsageItem{Day: day, AgentID: run.AgentID, Agent: agentName(agents, run.AgentID), Model: model}
+				byKey[k] = item
+			}
+			item.Runs++
+			item.PromptTokens += prompt
+			item.CompletionTokens += completion
+			item.Cost += cost
+		}
+	}
+
+	items := make([]models.TokenUsageItem, 0, len(byKey))
+	for _, item := range byKey {
+		items = append(items, *item)
+	}
+	return items, nil
+}
@@ -0,0 +1,285 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ripple/db"
+	"ripple/events"
+	"ripple/models"
+)
+
+// Delivery headers
+const (
+	HeaderEvent     = "X-Ripple-Event"
+	HeaderDelivery  = "X-Ripple-Delivery"
+	HeaderTimestamp = "X-Ripple-Timestamp"
+	HeaderSignature = "X-Ripple-Signature"
+)
+
+const (
+	defaultMaxAttempts  = 5
+	defaultInitialDelay = time.Second
+	defaultConcurrency  = 16
+	deliveryTimeout     = 10 * time.Second
+	// deliveryLease is how long a claimed delivery is left to its worker before another may attempt it, longer than
+	// an attempt and the recording of its outcome
+	deliveryLease = 5 * deliveryTimeout
+	// queuePollInterval is how often idle workers look for deliveries due for a retry, or queued by other servers
+	queuePollInterval = time.Second
+)
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	ID string `json:"id"`
+	events.Event
+}
+
+// Dispatcher delivers published events to the webhooks subscribed to them. Deliveries are queued in the database
+// and attempted by a pool of workers, so that slow webhooks do not hold up the events published meanwhile, and
+// deliveries and their retries survive restarts. Every server sharing the database attempts the queued deliveries.
+type Dispatcher struct {
+	base         *db.MongoDB
+	queue        *db.WebhookRepository
+	client       *http.Client
+	maxAttempts  int
+	initialDelay time.Duration
+	concurrency  int
+	// queued wakes an idle worker when deliveries are queued
+	queued chan struct{}
+}
+
+// NewDispatcher creates a new dispatcher for the events published on the database's broker, queuing deliveries in
+// the database
+func NewDispatcher(base *db.MongoDB) *Dispatcher {
+	return &Dispatcher{
+		base:         base,
+		queue:        db.NewWebhookRepository(base),
+		client:       newClient(),
+		maxAttempts:  defaultMaxAttempts,
+		initialDelay: defaultInitialDelay,
+		concurrency:  defaultConcurrency,
+		queued:       make(chan struct{}, 1),
+	}
+}
+
+// Run queues the deliveries of events, and attempts them, until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	if err := d.queue.EnsureIndexes(ctx); err != nil {
+		log.Printf("Unable to create the webhook queue index: %v", err)
+	}
+
+	sub := d.base.Events.Subscribe()
+	defer d.base.Events.Unsubscribe(sub)
+
+	for i := 0; i < d.concurrency; i++ {
+		go d.work(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			d.dispatch(ctx, event)
+		}
+	}
+}
+
+// dispatch queues a delivery for every webhook of the event's database subscribed to the event type
+func (d *Dispatcher) dispatch(ctx context.Context, event events.Event) {
+	repo := db.NewWebhookRepository(d.database(event.Database).ForOrg(event.Org))
+	webhooks, err := repo.ListWebhooksForEvent(ctx, event.Type)
+	if err != nil {
+		log.Printf("Unable to list webhooks for event %s: %v", event.Type, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	pending := make([]models.PendingDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		payload := Payload{ID: newDeliveryID(), Event: event}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("Unable to encode event %s: %v", event.Type, err)
+			return
+		}
+		pending = append(pending, models.PendingDelivery{
+			Database:   event.Database,
+			OrgID:      event.Org,
+			WebhookID:  webhook.ID,
+			DeliveryID: payload.ID,
+			Event:      event.Type,
+			Body:       body,
+		})
+	}
+	if err := d.queue.QueueDeliveries(ctx, pending); err != nil {
+		log.Printf("Unable to queue %d deliveries of event %s: %v", len(pending), event.Type, err)
+		return
+	}
+
+	select {
+	case d.queued <- struct{}{}:
+	default:
+	}
+}
+
+// work attempts the queued deliveries as they are due, until the context is cancelled
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		pending, err := d.queue.ClaimDelivery(ctx, deliveryLease)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Unable to claim a webhook delivery: %v", err)
+		}
+		if pending != nil {
+			d.deliver(ctx, pending)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.queued:
+		case <-time.After(queuePollInterval):
+		}
+	}
+}
+
+// deliver attempts a queued delivery and logs the attempt. Failed attempts are retried with exponential backoff,
+// deliveries to webhooks that were deleted or deactivated meanwhile are dropped.
+func (d *Dispatcher) deliver(ctx context.Context, pending *models.PendingDelivery) {
+	repo := db.NewWebhookRepository(d.database(pending.Database).ForOrg(pending.OrgID))
+	webhook, err := repo.GetWebhook(ctx, pending.WebhookID)
+	if err != nil && err.Error() != "webhook not found" {
+		log.Printf("Unable to get webhook %s for delivery %s: %v", pending.WebhookID.Hex(), pending.DeliveryID, err)
+		return
+	}
+	if webhook == nil || !webhook.Active {
+		d.remove(ctx, pending)
+		return
+	}
+
+	delivery := d.attempt(ctx, *webhook, pending.Event, pending.DeliveryID, pending.Body)
+	delivery.Attempt = pending.Attempts + 1
+	if err := repo.RecordDelivery(ctx, delivery); err != nil {
+		log.Printf("Unable to record delivery %s to webhook %s: %v", pending.DeliveryID, webhook.ID.Hex(), err)
+	}
+
+	if delivery.Success || !retryable(delivery.StatusCode) {
+		d.remove(ctx, pending)
+		return
+	}
+	if delivery.Attempt >= d.maxAttempts {
+		log.Printf("Giving up delivery %s of %s to webhook %s after %d attempts", pending.DeliveryID, pending.Event, webhook.ID.Hex(), d.maxAttempts)
+		d.remove(ctx, pending)
+		return
+	}
+
+	next := time.Now().Add(d.initialDelay << (delivery.Attempt - 1))
+	if err := d.queue.RetryDelivery(ctx, pending.ID, delivery.Attempt, next); err != nil {
+		log.Printf("Unable to schedule the retry of delivery %s to webhook %s: %v", pending.DeliveryID, webhook.ID.Hex(), err)
+	}
+}
+
+// remove removes a delivery from the queue. A delivery that could not be removed is attempted again once its lease
+// has passed.
+func (d *Dispatcher) remove(ctx context.Context, pending *models.PendingDelivery) {
+	if err := d.queue.RemoveDelivery(ctx, pending.ID); err != nil {
+		log.Printf("Unable to remove delivery %s from the webhook queue: %v", pending.DeliveryID, err)
+	}
+}
+
+// attempt performs a single delivery attempt
+func (d *Dispatcher) attempt(ctx context.Context, webhook models.Webhook, eventType, deliveryID string, body []byte) *models.WebhookDelivery {
+	delivery := &models.WebhookDelivery{
+		WebhookID:   webhook.ID,
+		DeliveryID:  deliveryID,
+		Event:       eventType,
+		AttemptedAt: time.Now(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	timestamp := strconv.FormatInt(delivery.AttemptedAt.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ripple-webhooks/1")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	delivery.DurationMs = time.Since(delivery.AttemptedAt).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	// The response body is not kept: the delivery log is readable by webhook owners, who choose the URL
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+
+	return delivery
+}
+
+// database returns the database an event was published for
+func (d *Dispatcher) database(name string) *db.MongoDB {
+	if name == "" || name == d.base.Name() {
+		return d.base
+	}
+	return d.base.WithDatabase(name)
+}
+
+// Sign computes the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a random webhook signing secret
+func NewSecret() string {
+	return "whsec_" + randomHex(24)
+}
+
+// retryable reports whether a failed attempt should be retried. Network errors (status 0),
+// server errors, timeouts and rate limiting are retried; other client errors are not.
+func retryable(status int) bool {
+	return status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// newDeliveryID generates a unique delivery ID, shared by all attempts of a delivery
+func newDeliveryID() string {
+	return randomHex(16)
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, RFC 6598, which is not public either
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// ErrNonPublicAddress is returned for webhooks on loopback, link-local, private and other non-public addresses,
+// which would let webhook owners reach the internal services and cloud metadata endpoints of the server's network
+var ErrNonPublicAddress = errors.New("webhooks can only be delivered to public addresses")
+
+// CheckURL checks that a webhook URL does not name a host on a non-public address. Host names are checked again
+// when deliveries dial them, after they are resolved.
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrNonPublicAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !publicAddress(addr) {
+		return ErrNonPublicAddress
+	}
+	return nil
+}
+
+// publicAddress reports whether deliveries may connect to an address
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// dialControl rejects connections to non-public addresses. It runs once host names are resolved, for every
+// connection, those of redirects included, so that host names resolving to internal addresses are rejected too.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w, %s is not", ErrNonPublicAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// newClient returns the HTTP client of deliveries, which only connects to public addresses, directly rather than
+// through the proxy of the environment
+func newClient() *http.Client {
+	dialer := &net.Dialer{Timeout: deliveryTimeout, KeepAlive: 30 * time.Second, Control: dialControl}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{Timeout: deliveryTimeout, Transport: transport}
+}